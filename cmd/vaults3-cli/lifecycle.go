@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func runLifecycle(args []string) {
+	if len(args) == 0 {
+		fmt.Println(`Usage: vaults3-cli lifecycle <subcommand>
+
+Subcommands:
+  preview <bucket> [config.json]   Dry-run a lifecycle config (default: the bucket's current config)`)
+		os.Exit(1)
+	}
+
+	requireCreds()
+
+	switch args[0] {
+	case "preview":
+		if len(args) < 2 {
+			fatal("usage: vaults3-cli lifecycle preview <bucket> [config.json]")
+		}
+		configFile := ""
+		if len(args) > 2 {
+			configFile = args[2]
+		}
+		lifecyclePreview(args[1], configFile)
+	default:
+		fatal("unknown lifecycle subcommand: " + args[0])
+	}
+}
+
+func lifecyclePreview(bucket, configFile string) {
+	var body io.Reader
+	if configFile != "" {
+		data, err := os.ReadFile(configFile)
+		if err != nil {
+			fatal("read config: " + err.Error())
+		}
+		body = bytes.NewReader(data)
+	}
+
+	resp, err := apiRequest("POST", "/buckets/"+bucket+"/lifecycle/preview", body)
+	if err != nil {
+		fatal(err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		data, _ := io.ReadAll(resp.Body)
+		fatal(fmt.Sprintf("HTTP %d: %s", resp.StatusCode, string(data)))
+	}
+
+	var result struct {
+		Transitions bool `json:"transitions_enabled"`
+		Rules       []struct {
+			ID      string `json:"id"`
+			Index   int    `json:"index"`
+			Status  string `json:"status"`
+			Actions map[string]struct {
+				Count          int              `json:"count"`
+				Bytes          int64            `json:"bytes"`
+				SampleKeys     []string         `json:"sample_keys"`
+				StorageClasses map[string]int64 `json:"storage_classes"`
+			} `json:"actions"`
+		} `json:"rules"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		fatal("parse response: " + err.Error())
+	}
+
+	if len(result.Rules) == 0 {
+		fmt.Println("No lifecycle rules to preview.")
+		return
+	}
+
+	headers := []string{"RULE", "STATUS", "ACTION", "COUNT", "SIZE", "SAMPLE"}
+	var rows [][]string
+	for _, rule := range result.Rules {
+		id := rule.ID
+		if id == "" {
+			id = "#" + strconv.Itoa(rule.Index)
+		}
+		if len(rule.Actions) == 0 {
+			rows = append(rows, []string{id, rule.Status, "-", "0", formatSize(0), "-"})
+			continue
+		}
+		actions := make([]string, 0, len(rule.Actions))
+		for name := range rule.Actions {
+			actions = append(actions, name)
+		}
+		sort.Strings(actions)
+		for _, name := range actions {
+			a := rule.Actions[name]
+			label := name
+			if len(a.StorageClasses) > 0 {
+				classes := make([]string, 0, len(a.StorageClasses))
+				for class := range a.StorageClasses {
+					classes = append(classes, class)
+				}
+				sort.Strings(classes)
+				label += " → " + strings.Join(classes, ",")
+			}
+			sample := "-"
+			if len(a.SampleKeys) > 0 {
+				sample = a.SampleKeys[0]
+				if a.Count > 1 {
+					sample += fmt.Sprintf(" (+%d more)", a.Count-1)
+				}
+			}
+			rows = append(rows, []string{id, rule.Status, label, strconv.Itoa(a.Count), formatSize(a.Bytes), sample})
+		}
+	}
+	printTable(headers, rows)
+
+	if !result.Transitions {
+		fmt.Println("\nTiering is disabled; transition actions were not evaluated.")
+	}
+}
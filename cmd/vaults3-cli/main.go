@@ -90,6 +90,12 @@ func main() {
 		runUser(cmdArgs)
 	case "replication":
 		runReplication(cmdArgs)
+	case "lifecycle":
+		runLifecycle(cmdArgs)
+	case "replay":
+		runReplay(cmdArgs)
+	case "search":
+		runSearch(cmdArgs)
 	case "mount":
 		runMount(cmdArgs)
 	case "umount":
@@ -120,6 +126,9 @@ Commands:
   object               Object operations (ls, put, get, rm, cp, presign)
   user                 IAM user operations (list, create, delete, attach-policy)
   replication          Replication operations (status, queue)
+  lifecycle            Lifecycle operations (preview)
+  replay               Event replay (start, status, cancel)
+  search               Search objects with the query language
   mount                Mount a bucket as a local filesystem (FUSE)
   umount               Unmount a FUSE mountpoint
   version              Show version
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type replayJob struct {
+	ID      string `json:"id"`
+	Request struct {
+		Bucket  string `json:"bucket"`
+		Prefix  string `json:"prefix"`
+		Target  string `json:"target"`
+		Trigger string `json:"trigger"`
+	} `json:"request"`
+	Status     string    `json:"status"`
+	Total      int       `json:"total"`
+	Replayed   int       `json:"replayed"`
+	Failed     int       `json:"failed"`
+	LastError  string    `json:"last_error"`
+	Position   time.Time `json:"position"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+func runReplay(args []string) {
+	if len(args) == 0 {
+		fmt.Println(`Usage: vaults3-cli replay <subcommand>
+
+Subcommands:
+  start <bucket> --from=<time> [flags]   Replay recorded events to a target or trigger
+      --to=<time>                        End of the time range (default: now)
+      --prefix=<prefix>                  Only keys with this prefix
+      --events=<e1,e2>                   Event name patterns, e.g. s3:ObjectCreated:*
+      --target=<arn>                     Notification target ARN
+      --trigger=<id>                     Lambda trigger ID
+      --rate=<n>                         Events per second (default 50)
+      --dry-run                          Only count the matching events
+      --detach                           Return once the job has started
+  status [id]                            Show replay jobs, or one job
+  cancel <id>                            Cancel a running replay
+
+Times are RFC 3339 (2024-05-01T00:00:00Z) or durations before now (24h).`)
+		os.Exit(1)
+	}
+
+	requireCreds()
+
+	switch args[0] {
+	case "start":
+		if len(args) < 2 || strings.HasPrefix(args[1], "--") {
+			fatal("usage: vaults3-cli replay start <bucket> --from=<time> [flags]")
+		}
+		replayStart(args[1], args[2:])
+	case "status":
+		if len(args) > 1 {
+			replayShow(args[1])
+		} else {
+			replayList()
+		}
+	case "cancel":
+		if len(args) < 2 {
+			fatal("usage: vaults3-cli replay cancel <id>")
+		}
+		replayCancel(args[1])
+	default:
+		fatal("unknown replay subcommand: " + args[0])
+	}
+}
+
+// parseReplayTime accepts an RFC 3339 time or a duration before now.
+func parseReplayTime(s string) time.Time {
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d)
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		fatal("invalid time " + s + ": use RFC 3339 or a duration such as 24h")
+	}
+	return t
+}
+
+func replayStart(bucket string, flags []string) {
+	req := map[string]any{"bucket": bucket}
+	detach := false
+	for _, arg := range flags {
+		name, value, _ := strings.Cut(arg, "=")
+		switch name {
+		case "--from":
+			req["from"] = parseReplayTime(value)
+		case "--to":
+			req["to"] = parseReplayTime(value)
+		case "--prefix":
+			req["prefix"] = value
+		case "--events":
+			req["events"] = strings.Split(value, ",")
+		case "--target":
+			req["target"] = value
+		case "--trigger":
+			req["trigger"] = value
+		case "--rate":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				fatal("invalid rate: " + value)
+			}
+			req["rate"] = n
+		case "--dry-run":
+			req["dry_run"] = true
+		case "--detach":
+			detach = true
+		default:
+			fatal("unknown flag: " + arg)
+		}
+	}
+	if _, ok := req["from"]; !ok {
+		fatal("--from is required")
+	}
+
+	body, _ := json.Marshal(req)
+	resp, err := apiRequest("POST", "/replay/jobs", bytes.NewReader(body))
+	if err != nil {
+		fatal(err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 && resp.StatusCode != 202 {
+		data, _ := io.ReadAll(resp.Body)
+		fatal(fmt.Sprintf("HTTP %d: %s", resp.StatusCode, string(data)))
+	}
+
+	if req["dry_run"] == true {
+		var result struct {
+			Matched int `json:"matched"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			fatal("parse response: " + err.Error())
+		}
+		fmt.Printf("%d events would be replayed.\n", result.Matched)
+		return
+	}
+
+	var job replayJob
+	if err := json.NewDecoder(resp.Body).Decode(&job); err != nil {
+		fatal("parse response: " + err.Error())
+	}
+	fmt.Printf("Started replay %s (%d events)\n", job.ID, job.Total)
+	if detach {
+		return
+	}
+	for job.Status == "running" {
+		fmt.Printf("\r  %d/%d replayed, %d failed", job.Replayed, job.Total, job.Failed)
+		time.Sleep(time.Second)
+		job = getReplayJob(job.ID)
+	}
+	fmt.Printf("\r  %d/%d replayed, %d failed\n", job.Replayed, job.Total, job.Failed)
+	fmt.Printf("Replay %s\n", job.Status)
+	if job.LastError != "" {
+		fmt.Printf("Last error: %s\n", job.LastError)
+	}
+	if job.Status != "completed" || job.Failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func getReplayJob(id string) replayJob {
+	resp, err := apiRequest("GET", "/replay/jobs/"+id, nil)
+	if err != nil {
+		fatal(err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		data, _ := io.ReadAll(resp.Body)
+		fatal(fmt.Sprintf("HTTP %d: %s", resp.StatusCode, string(data)))
+	}
+
+	var job replayJob
+	if err := json.NewDecoder(resp.Body).Decode(&job); err != nil {
+		fatal("parse response: " + err.Error())
+	}
+	return job
+}
+
+func replayShow(id string) {
+	job := getReplayJob(id)
+	dest := "target " + job.Request.Target
+	if job.Request.Trigger != "" {
+		dest = "trigger " + job.Request.Trigger
+	}
+	fmt.Printf("ID:        %s\n", job.ID)
+	fmt.Printf("Status:    %s\n", job.Status)
+	fmt.Printf("Bucket:    %s\n", job.Request.Bucket)
+	if job.Request.Prefix != "" {
+		fmt.Printf("Prefix:    %s\n", job.Request.Prefix)
+	}
+	fmt.Printf("Sent to:   %s\n", dest)
+	fmt.Printf("Progress:  %d/%d replayed, %d failed\n", job.Replayed, job.Total, job.Failed)
+	if !job.Position.IsZero() {
+		fmt.Printf("Position:  %s\n", job.Position.Format(time.RFC3339))
+	}
+	fmt.Printf("Started:   %s\n", job.StartedAt.Local().Format("2006-01-02 15:04:05"))
+	if !job.FinishedAt.IsZero() {
+		fmt.Printf("Finished:  %s\n", job.FinishedAt.Local().Format("2006-01-02 15:04:05"))
+	}
+	if job.LastError != "" {
+		fmt.Printf("Last error: %s\n", job.LastError)
+	}
+}
+
+func replayList() {
+	resp, err := apiRequest("GET", "/replay/jobs", nil)
+	if err != nil {
+		fatal(err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		data, _ := io.ReadAll(resp.Body)
+		fatal(fmt.Sprintf("HTTP %d: %s", resp.StatusCode, string(data)))
+	}
+
+	var jobs []replayJob
+	if err := json.NewDecoder(resp.Body).Decode(&jobs); err != nil {
+		fatal("parse response: " + err.Error())
+	}
+
+	if len(jobs) == 0 {
+		fmt.Println("No replay jobs.")
+		return
+	}
+
+	headers := []string{"ID", "BUCKET", "STATUS", "REPLAYED", "FAILED", "TOTAL", "STARTED"}
+	var rows [][]string
+	for _, j := range jobs {
+		rows = append(rows, []string{
+			j.ID,
+			j.Request.Bucket,
+			j.Status,
+			strconv.Itoa(j.Replayed),
+			strconv.Itoa(j.Failed),
+			strconv.Itoa(j.Total),
+			j.StartedAt.Local().Format("2006-01-02 15:04:05"),
+		})
+	}
+	printTable(headers, rows)
+}
+
+func replayCancel(id string) {
+	resp, err := apiRequest("POST", "/replay/jobs/"+id+"/cancel", nil)
+	if err != nil {
+		fatal(err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		data, _ := io.ReadAll(resp.Body)
+		fatal(fmt.Sprintf("HTTP %d: %s", resp.StatusCode, string(data)))
+	}
+	fmt.Printf("Cancelling replay %s\n", id)
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// searchHit is an object found by a search, from either the admin API or
+// the S3 search extension.
+type searchHit struct {
+	Bucket       string
+	Key          string
+	Size         int64
+	LastModified time.Time
+	Score        float64
+}
+
+type searchPage struct {
+	Hits        []searchHit
+	Total       int
+	TotalCapped bool // Total is a lower bound
+	NextCursor  string
+}
+
+func runSearch(args []string) {
+	var terms []string
+	bucket, prefix, cursor := "", "", ""
+	limit := 50
+	all := false
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "--") {
+			terms = append(terms, arg)
+			continue
+		}
+		name, value, _ := strings.Cut(arg, "=")
+		switch name {
+		case "--bucket":
+			bucket = value
+		case "--prefix":
+			prefix = value
+		case "--limit":
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				fatal("invalid limit: " + value)
+			}
+			limit = n
+		case "--cursor":
+			cursor = value
+		case "--all":
+			all = true
+		default:
+			fatal("unknown flag: " + arg)
+		}
+	}
+	if len(terms) == 0 {
+		fmt.Println(`Usage: vaults3-cli search <query> [flags]
+
+Flags:
+  --bucket=<name>    Search one bucket (uses the S3 search extension)
+  --prefix=<p>       Only keys starting with the prefix
+  --limit=<n>        Results per page (default 50)
+  --cursor=<c>       Continue after the page that returned this cursor
+  --all              Fetch every page
+
+Query examples:
+  'size:>10MB modified:<2026-01-01'
+  'bucket:logs prefix:2026/ NOT type:image'
+  'meta.owner:alice ("quarterly report" OR budget) sort:size desc'`)
+		os.Exit(1)
+	}
+	query := strings.Join(terms, " ")
+	if prefix != "" && bucket == "" {
+		// The admin API has no prefix parameter; the query language does
+		query = `prefix:"` + prefix + `" ` + query
+	}
+
+	requireCreds()
+
+	headers := []string{"BUCKET", "KEY", "SIZE", "LAST MODIFIED", "SCORE"}
+	var rows [][]string
+	var page searchPage
+	for {
+		if bucket != "" {
+			page = searchBucket(bucket, query, prefix, limit, cursor)
+		} else {
+			page = searchAll(query, limit, cursor)
+		}
+		for _, hit := range page.Hits {
+			score := ""
+			if hit.Score > 0 {
+				score = strconv.FormatFloat(hit.Score, 'f', 2, 64)
+			}
+			rows = append(rows, []string{
+				hit.Bucket,
+				hit.Key,
+				formatSize(hit.Size),
+				hit.LastModified.Format("2006-01-02 15:04:05"),
+				score,
+			})
+		}
+		if !all || page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+
+	if len(rows) == 0 {
+		fmt.Println("No objects found.")
+		return
+	}
+	printTable(headers, rows)
+	if page.TotalCapped {
+		fmt.Printf("\n%d of at least %d object(s)\n", len(rows), page.Total)
+	} else {
+		fmt.Printf("\n%d of %d object(s)\n", len(rows), page.Total)
+	}
+	if page.NextCursor != "" {
+		fmt.Printf("More results: --cursor=%s\n", page.NextCursor)
+	}
+}
+
+// searchAll queries the search index of every bucket through the admin API.
+func searchAll(query string, limit int, cursor string) searchPage {
+	params := url.Values{"q": {query}, "limit": {strconv.Itoa(limit)}}
+	if cursor != "" {
+		params.Set("cursor", cursor)
+	}
+	resp, err := apiRequest("GET", "/search?"+params.Encode(), nil)
+	if err != nil {
+		fatal(err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		data, _ := io.ReadAll(resp.Body)
+		fatal(fmt.Sprintf("HTTP %d: %s", resp.StatusCode, string(data)))
+	}
+
+	var result struct {
+		Results []struct {
+			Bucket       string  `json:"bucket"`
+			Key          string  `json:"key"`
+			Size         int64   `json:"size"`
+			LastModified int64   `json:"last_modified"`
+			Score        float64 `json:"score"`
+		} `json:"results"`
+		Total       int    `json:"total"`
+		TotalCapped bool   `json:"total_capped"`
+		NextCursor  string `json:"next_cursor"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		fatal("parse response: " + err.Error())
+	}
+	page := searchPage{Total: result.Total, TotalCapped: result.TotalCapped, NextCursor: result.NextCursor}
+	for _, r := range result.Results {
+		page.Hits = append(page.Hits, searchHit{
+			Bucket:       r.Bucket,
+			Key:          r.Key,
+			Size:         r.Size,
+			LastModified: time.Unix(r.LastModified, 0),
+			Score:        r.Score,
+		})
+	}
+	return page
+}
+
+// searchBucket queries one bucket through the S3 search extension, so only
+// S3 credentials with s3:SearchBucket on the bucket are needed.
+func searchBucket(bucket, query, prefix string, limit int, cursor string) searchPage {
+	params := url.Values{"q": {query}, "max-results": {strconv.Itoa(limit)}}
+	if prefix != "" {
+		params.Set("prefix", prefix)
+	}
+	if cursor != "" {
+		params.Set("cursor", cursor)
+	}
+	resp, err := s3Request("GET", "/"+bucket+"?search&"+params.Encode(), nil)
+	if err != nil {
+		fatal(err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		data, _ := io.ReadAll(resp.Body)
+		fatal(fmt.Sprintf("HTTP %d: %s", resp.StatusCode, string(data)))
+	}
+
+	var result struct {
+		XMLName         xml.Name `xml:"SearchResult"`
+		TotalHits       int      `xml:"TotalHits"`
+		TotalHitsCapped bool     `xml:"TotalHitsCapped"`
+		Contents        []struct {
+			Key          string  `xml:"Key"`
+			Size         int64   `xml:"Size"`
+			LastModified string  `xml:"LastModified"`
+			Score        float64 `xml:"Score"`
+		} `xml:"Contents"`
+		NextCursor string `xml:"NextCursor"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		fatal("parse response: " + err.Error())
+	}
+	page := searchPage{Total: result.TotalHits, TotalCapped: result.TotalHitsCapped, NextCursor: result.NextCursor}
+	for _, c := range result.Contents {
+		t, _ := time.Parse(time.RFC3339, c.LastModified)
+		page.Hits = append(page.Hits, searchHit{
+			Bucket:       bucket,
+			Key:          c.Key,
+			Size:         c.Size,
+			LastModified: t.Local(),
+			Score:        c.Score,
+		})
+	}
+	return page
+}
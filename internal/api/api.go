@@ -8,11 +8,14 @@ import (
 	"strings"
 
 	"github.com/eniz1806/VaultS3/internal/backup"
+	"github.com/eniz1806/VaultS3/internal/batch"
 	"github.com/eniz1806/VaultS3/internal/config"
 	"github.com/eniz1806/VaultS3/internal/lambda"
 	"github.com/eniz1806/VaultS3/internal/metadata"
 	"github.com/eniz1806/VaultS3/internal/metrics"
+	"github.com/eniz1806/VaultS3/internal/notify"
 	"github.com/eniz1806/VaultS3/internal/ratelimit"
+	"github.com/eniz1806/VaultS3/internal/replay"
 	s3auth "github.com/eniz1806/VaultS3/internal/s3"
 	"github.com/eniz1806/VaultS3/internal/scanner"
 	"github.com/eniz1806/VaultS3/internal/search"
@@ -29,12 +32,16 @@ type APIHandler struct {
 	jwt              *JWTService
 	activity         *ActivityLog
 	searchIndex      *search.Index
+	contentIndexer   *search.ContentIndexer
 	scanner          *scanner.Scanner
 	tieringMgr       *tiering.Manager
 	backupSched      *backup.Scheduler
 	rateLimiter      *ratelimit.Limiter
 	oidc             *OIDCValidator
 	lambdaMgr        *lambda.TriggerManager
+	batch            *batch.Processor
+	notify           *notify.Dispatcher
+	replayer         *replay.Replayer
 	eventBus         *EventBus
 	logBroadcaster   *LogBroadcaster
 	traceBroadcaster *TraceBroadcaster
@@ -57,6 +64,12 @@ func (h *APIHandler) SetSearchIndex(idx *search.Index) {
 	h.searchIndex = idx
 }
 
+// SetContentIndexer sets the indexer that extracts the text of objects in
+// buckets with content indexing.
+func (h *APIHandler) SetContentIndexer(ci *search.ContentIndexer) {
+	h.contentIndexer = ci
+}
+
 // SetOIDCValidator sets the OIDC validator for the API handler.
 func (h *APIHandler) SetOIDCValidator(v *OIDCValidator) {
 	h.oidc = v
@@ -133,15 +146,19 @@ func (h *APIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Admin-only routes: IAM, keys, STS, audit, backups, settings, lambda, presign, replication, scanner, tiering
+	// Admin-only routes: IAM, keys, STS, audit, backups, settings, lambda, batch, notification outbox, event replay, presign, replication, scanner, search index, tiering
 	adminPaths := strings.HasPrefix(path, "/keys") ||
 		strings.HasPrefix(path, "/iam/") ||
 		strings.HasPrefix(path, "/sts/") ||
 		path == "/audit" ||
 		strings.HasPrefix(path, "/backups") ||
 		strings.HasPrefix(path, "/lambda/") ||
+		strings.HasPrefix(path, "/batch/") ||
+		strings.HasPrefix(path, "/notifications/") ||
+		strings.HasPrefix(path, "/replay/") ||
 		strings.HasPrefix(path, "/replication/") ||
 		strings.HasPrefix(path, "/scanner/") ||
+		strings.HasPrefix(path, "/search/") ||
 		strings.HasPrefix(path, "/tiering/") ||
 		strings.HasPrefix(path, "/settings") ||
 		path == "/presign"
@@ -216,10 +233,18 @@ func (h *APIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Notification configs
 	case path == "/notifications" && r.Method == http.MethodGet:
 		h.handleListNotifications(w, r)
+	case strings.HasPrefix(path, "/notifications/"):
+		h.routeNotifications(w, r, strings.TrimPrefix(path, "/notifications/"))
 
 	// Search
 	case path == "/search" && r.Method == http.MethodGet:
 		h.handleSearch(w, r)
+	case path == "/search/status" && r.Method == http.MethodGet:
+		h.handleSearchStatus(w, r)
+	case strings.HasPrefix(path, "/search/buckets/"):
+		h.routeSearchBucket(w, r, strings.TrimPrefix(path, "/search/buckets/"))
+	case path == "/search/reindex" && r.Method == http.MethodPost:
+		h.handleSearchReindex(w, r)
 
 	// Presigned URL generation
 	case path == "/presign" && r.Method == http.MethodPost:
@@ -230,6 +255,22 @@ func (h *APIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		h.handleScannerStatus(w, r)
 	case path == "/scanner/quarantine" && r.Method == http.MethodGet:
 		h.handleQuarantineList(w, r)
+	case path == "/scanner/quarantine/release" && r.Method == http.MethodPost:
+		h.handleQuarantineRelease(w, r)
+	case strings.HasPrefix(path, "/scanner/buckets/"):
+		h.routeScannerBucket(w, r, strings.TrimPrefix(path, "/scanner/buckets/"))
+	case path == "/scanner/rescans" && r.Method == http.MethodGet:
+		h.handleListRescans(w, r)
+	case path == "/scanner/rescans" && r.Method == http.MethodPost:
+		h.handleStartRescan(w, r)
+	case strings.HasPrefix(path, "/scanner/rescans/"):
+		h.routeRescan(w, r, strings.TrimPrefix(path, "/scanner/rescans/"))
+	case path == "/scanner/blocklist" && r.Method == http.MethodGet:
+		h.handleListBlocklist(w, r)
+	case path == "/scanner/blocklist" && r.Method == http.MethodPost:
+		h.handleAddBlocklistEntry(w, r)
+	case strings.HasPrefix(path, "/scanner/blocklist/") && r.Method == http.MethodDelete:
+		h.handleDeleteBlocklistEntry(w, r, strings.TrimPrefix(path, "/scanner/blocklist/"))
 
 	// Versioning routes
 	case path == "/versions" && r.Method == http.MethodGet:
@@ -267,6 +308,14 @@ func (h *APIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	case strings.HasPrefix(path, "/lambda/"):
 		h.routeLambda(w, r, strings.TrimPrefix(path, "/lambda/"))
 
+	// Batch operation routes (admin only)
+	case strings.HasPrefix(path, "/batch/"):
+		h.routeBatch(w, r, strings.TrimPrefix(path, "/batch/"))
+
+	// Event replay routes (admin only)
+	case strings.HasPrefix(path, "/replay/"):
+		h.routeReplay(w, r, strings.TrimPrefix(path, "/replay/"))
+
 	// Replication routes
 	case path == "/replication/status" && r.Method == http.MethodGet:
 		h.handleReplicationStatus(w, r)
@@ -434,6 +483,12 @@ func (h *APIHandler) routeBucket(w http.ResponseWriter, r *http.Request, rest st
 		} else {
 			writeError(w, http.StatusNotFound, "not found")
 		}
+	case "scan-history":
+		if keyRest != "" && r.Method == http.MethodGet {
+			h.handleScanHistory(w, r, name, keyRest)
+		} else {
+			writeError(w, http.StatusNotFound, "not found")
+		}
 	case "upload":
 		if r.Method == http.MethodPost {
 			h.handleUpload(w, r, name)
@@ -462,6 +517,14 @@ func (h *APIHandler) routeBucket(w http.ResponseWriter, r *http.Request, rest st
 			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
 		}
 	case "lifecycle":
+		if keyRest == "preview" {
+			if r.Method != http.MethodPost {
+				writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+				return
+			}
+			h.handlePreviewLifecycle(w, r, name)
+			return
+		}
 		switch r.Method {
 		case http.MethodGet:
 			h.handleGetLifecycleRule(w, r, name)
@@ -483,6 +546,17 @@ func (h *APIHandler) routeBucket(w http.ResponseWriter, r *http.Request, rest st
 		default:
 			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
 		}
+	case "upload-policy":
+		switch r.Method {
+		case http.MethodGet:
+			h.handleGetUploadPolicy(w, r, name)
+		case http.MethodPut:
+			h.handlePutUploadPolicy(w, r, name)
+		case http.MethodDelete:
+			h.handleDeleteUploadPolicy(w, r, name)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
 	default:
 		writeError(w, http.StatusNotFound, "not found")
 	}
@@ -2,17 +2,28 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/eniz1806/VaultS3/internal/config"
+	"github.com/eniz1806/VaultS3/internal/lambda"
 	"github.com/eniz1806/VaultS3/internal/metadata"
 	"github.com/eniz1806/VaultS3/internal/metrics"
+	"github.com/eniz1806/VaultS3/internal/notify"
+	"github.com/eniz1806/VaultS3/internal/replay"
+	"github.com/eniz1806/VaultS3/internal/scanner"
+	"github.com/eniz1806/VaultS3/internal/search"
 	"github.com/eniz1806/VaultS3/internal/storage"
+	"github.com/eniz1806/VaultS3/internal/versioning"
 )
 
 func newTestAPI(t *testing.T) (*APIHandler, *metadata.Store) {
@@ -305,6 +316,73 @@ func TestAuthMe(t *testing.T) {
 	}
 }
 
+// --- Notification outbox tests ---
+
+func TestNotificationDeadLetters(t *testing.T) {
+	h, store := newTestAPI(t)
+	token := getToken(t, h)
+
+	rr := doRequest(h, "GET", "/notifications/outbox", nil, token)
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 without dispatcher, got %d", rr.Code)
+	}
+
+	h.SetNotifyDispatcher(notify.NewDispatcher(store, 1, 10, 1, 1))
+	if err := store.EnqueueNotifications([]metadata.NotificationEvent{
+		{Kind: notify.KindWebhook, Destination: "http://example.com/hook", EventName: "s3:ObjectCreated:Put", Bucket: "b", Key: "k"},
+	}); err != nil {
+		t.Fatalf("EnqueueNotifications: %v", err)
+	}
+	events, err := store.DequeueNotifications(10, 0, nil)
+	if err != nil || len(events) != 1 {
+		t.Fatalf("DequeueNotifications: %v (%d events)", err, len(events))
+	}
+	if err := store.DeadLetterNotification(events[0].ID, 1, "boom"); err != nil {
+		t.Fatalf("DeadLetterNotification: %v", err)
+	}
+
+	rr = doRequest(h, "GET", "/notifications/dead-letters", nil, token)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var dead []metadata.NotificationEvent
+	json.NewDecoder(rr.Body).Decode(&dead)
+	if len(dead) != 1 || dead[0].LastError != "boom" {
+		t.Fatalf("unexpected dead letters: %+v", dead)
+	}
+
+	rr = doRequest(h, "GET", "/notifications/dead-letters?limit=0", nil, token)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for bad limit, got %d", rr.Code)
+	}
+
+	rr = doRequest(h, "POST", "/notifications/dead-letters/replay", map[string]interface{}{"ids": []uint64{dead[0].ID}}, token)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var replayed map[string]int
+	json.NewDecoder(rr.Body).Decode(&replayed)
+	if replayed["replayed"] != 1 {
+		t.Fatalf("expected 1 replayed, got %v", replayed)
+	}
+
+	rr = doRequest(h, "GET", "/notifications/outbox", nil, token)
+	var outbox struct {
+		Destinations []metadata.NotificationQueueStats `json:"destinations"`
+	}
+	json.NewDecoder(rr.Body).Decode(&outbox)
+	if len(outbox.Destinations) != 1 || outbox.Destinations[0].Pending != 1 || outbox.Destinations[0].DeadLettered != 0 {
+		t.Fatalf("unexpected outbox stats: %+v", outbox.Destinations)
+	}
+
+	rr = doRequest(h, "POST", "/notifications/dead-letters/purge", nil, token)
+	var purged map[string]int
+	json.NewDecoder(rr.Body).Decode(&purged)
+	if rr.Code != http.StatusOK || purged["purged"] != 0 {
+		t.Fatalf("expected empty purge, got %d %v", rr.Code, purged)
+	}
+}
+
 // --- Validation tests ---
 
 func TestValidateBucketName(t *testing.T) {
@@ -342,3 +420,570 @@ func TestValidateObjectKey(t *testing.T) {
 		t.Error("expected >1024 char key to be invalid")
 	}
 }
+
+func TestWebhookAuthRedacted(t *testing.T) {
+	h, store := newTestAPI(t)
+	token := getToken(t, h)
+	store.SetSecretsKey(bytes.Repeat([]byte{1}, 32))
+	store.CreateBucket("b")
+	store.PutNotificationConfig("b", metadata.BucketNotificationConfig{Webhooks: []metadata.NotificationEndpointConfig{
+		{ID: "hook", Endpoint: "http://example.com/hook", Events: []string{"s3:ObjectCreated:*"}},
+	}})
+
+	rr := doRequest(h, "PUT", "/notifications/b/webhooks/hook/auth", map[string]interface{}{
+		"secret": "top-secret", "headers": map[string]string{"X-Team": "ops"},
+	}, token)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = doRequest(h, "PUT", "/notifications/b/webhooks/missing/auth", map[string]string{"secret": "x"}, token)
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown webhook, got %d", rr.Code)
+	}
+
+	rr = doRequest(h, "GET", "/notifications", nil, token)
+	if bytes.Contains(rr.Body.Bytes(), []byte("top-secret")) || !bytes.Contains(rr.Body.Bytes(), []byte(metadata.RedactedSecret)) {
+		t.Fatalf("secret not redacted: %s", rr.Body.String())
+	}
+
+	// Writing the redacted value back keeps the stored secret.
+	rr = doRequest(h, "PUT", "/notifications/b/webhooks/hook/auth", map[string]string{"secret": metadata.RedactedSecret}, token)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	cfg, _ := store.GetNotificationConfig("b")
+	if a := cfg.Webhooks[0].Auth; a == nil || a.Secret != "top-secret" {
+		t.Fatalf("stored auth = %+v", a)
+	}
+
+	h.cfg.Security.WebhookSecrets = map[string]string{"fn-token": "env:FN_TOKEN"}
+	rr = doRequest(h, "PUT", "/lambda/triggers/b", map[string]interface{}{"triggers": []map[string]interface{}{{
+		"id": "fn", "function_url": "https://example.com/fn", "events": []string{"s3:ObjectCreated:*"},
+		"auth": map[string]string{"secret": "fn-secret", "bearer_token_ref": "fn-token"},
+	}}}, token)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = doRequest(h, "GET", "/lambda/triggers/b", nil, token)
+	if bytes.Contains(rr.Body.Bytes(), []byte("fn-secret")) || !bytes.Contains(rr.Body.Bytes(), []byte("fn-token")) {
+		t.Fatalf("lambda auth not redacted: %s", rr.Body.String())
+	}
+
+	rr = doRequest(h, "PUT", "/lambda/triggers/b", map[string]interface{}{"triggers": []map[string]interface{}{{
+		"id": "fn", "function_url": "https://example.com/fn", "events": []string{"s3:ObjectCreated:*"},
+		"auth": map[string]string{"bearer_token_ref": "env:FN_TOKEN"},
+	}}}, token)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unconfigured token reference, got %d", rr.Code)
+	}
+}
+
+func TestEventReplay(t *testing.T) {
+	h, store := newTestAPI(t)
+	token := getToken(t, h)
+	store.CreateBucket("b")
+
+	rr := doRequest(h, "GET", "/replay/jobs", nil, token)
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 without a replayer, got %d", rr.Code)
+	}
+
+	replayer := replay.NewReplayer(store)
+	t.Cleanup(replayer.Stop)
+	delivered := make(chan string, 10)
+	replayer.SetTargetFunc(func(e notify.Event, arn, _ string) error {
+		delivered <- e.Key
+		return nil
+	})
+	h.SetReplayer(replayer)
+
+	from := time.Now().Add(-time.Hour)
+	replayer.Record(notify.Event{Name: notify.EventObjectCreatedPut, Bucket: "b", Key: "k1", Time: from.Add(time.Minute)})
+	replayer.Record(notify.Event{Name: notify.EventObjectCreatedPut, Bucket: "b", Key: "k2", Time: from.Add(2 * time.Minute)})
+
+	req := map[string]interface{}{"bucket": "b", "from": from, "target": "arn:vaults3:webhook::1:hook", "dry_run": true}
+	rr = doRequest(h, "POST", "/replay/jobs", req, token)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"matched":2`) {
+		t.Fatalf("dry run = %d: %s", rr.Code, rr.Body.String())
+	}
+
+	req["dry_run"] = false
+	rr = doRequest(h, "POST", "/replay/jobs", req, token)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var job replay.Job
+	json.Unmarshal(rr.Body.Bytes(), &job)
+	for _, want := range []string{"k1", "k2"} {
+		select {
+		case got := <-delivered:
+			if got != want {
+				t.Fatalf("replayed %s, want %s", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("replay did not deliver")
+		}
+	}
+
+	rr = doRequest(h, "GET", "/replay/jobs/"+job.ID, nil, token)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	rr = doRequest(h, "GET", "/replay/jobs/missing", nil, token)
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", rr.Code)
+	}
+	rr = doRequest(h, "POST", "/replay/jobs", map[string]interface{}{"bucket": "b", "target": "arn"}, token)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without from, got %d", rr.Code)
+	}
+}
+
+func TestLambdaInvocations(t *testing.T) {
+	h, store := newTestAPI(t)
+	token := getToken(t, h)
+
+	rr := doRequest(h, "GET", "/lambda/invocations", nil, token)
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 without lambda, got %d", rr.Code)
+	}
+
+	h.SetLambdaManager(lambda.NewTriggerManager(store, nil, config.LambdaConfig{MaxWorkers: 1, QueueSize: 1}))
+	store.AppendLambdaInvocation(metadata.LambdaInvocation{Bucket: "b", TriggerID: "fn", Status: metadata.InvocationSucceeded})
+	store.AppendLambdaInvocation(metadata.LambdaInvocation{Bucket: "b", TriggerID: "fn", Status: metadata.InvocationDeadLettered, Error: "status 500"})
+
+	rr = doRequest(h, "GET", "/lambda/invocations?status=dead_lettered", nil, token)
+	var invocations []metadata.LambdaInvocation
+	json.Unmarshal(rr.Body.Bytes(), &invocations)
+	if rr.Code != http.StatusOK || len(invocations) != 1 || invocations[0].Error != "status 500" {
+		t.Fatalf("invocations = %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = doRequest(h, "GET", "/lambda/invocations?before=2", nil, token)
+	json.Unmarshal(rr.Body.Bytes(), &invocations)
+	if len(invocations) != 1 || invocations[0].ID != 1 {
+		t.Fatalf("paged invocations = %s", rr.Body.String())
+	}
+
+	// The trigger no longer exists, so the invocation cannot be retried
+	rr = doRequest(h, "POST", "/lambda/invocations/2/retry", nil, token)
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", rr.Code)
+	}
+	rr = doRequest(h, "POST", "/lambda/dead-letters/purge", nil, token)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"purged":0`) {
+		t.Fatalf("purge = %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestLambdaPipelines(t *testing.T) {
+	h, store := newTestAPI(t)
+	token := getToken(t, h)
+	store.CreateBucket("b")
+	store.SetSecretsKey(bytes.Repeat([]byte{1}, 32))
+
+	step := func(id string, after ...string) map[string]interface{} {
+		return map[string]interface{}{"id": id, "function_url": "https://example.com/" + id, "after": after}
+	}
+	rr := doRequest(h, "PUT", "/lambda/pipelines/b", map[string]interface{}{"pipelines": []map[string]interface{}{{
+		"id": "p", "events": []string{"*"}, "steps": []interface{}{step("a", "b"), step("b", "a")},
+	}}}, token)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "cycle") {
+		t.Fatalf("expected 400 for a cycle, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = doRequest(h, "PUT", "/lambda/pipelines/b", map[string]interface{}{"pipelines": []map[string]interface{}{{
+		"id": "p", "events": []string{"*"}, "steps": []interface{}{
+			map[string]interface{}{"id": "local", "runtime": "process", "command": "missing"},
+		},
+	}}}, token)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a command that is not allow-listed, got %d", rr.Code)
+	}
+
+	signed := step("scan")
+	signed["auth"] = map[string]string{"secret": "step-secret"}
+	rr = doRequest(h, "PUT", "/lambda/pipelines/b", map[string]interface{}{"pipelines": []map[string]interface{}{{
+		"id": "p", "events": []string{"*"}, "steps": []interface{}{step("ingest"), signed},
+	}}}, token)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = doRequest(h, "GET", "/lambda/pipelines/b", nil, token)
+	var resp struct {
+		Pipelines []metadata.LambdaPipeline `json:"pipelines"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if strings.Contains(rr.Body.String(), "step-secret") || len(resp.Pipelines) != 1 {
+		t.Fatalf("pipelines = %s", rr.Body.String())
+	}
+	if after := resp.Pipelines[0].Steps[1].After; len(after) != 1 || after[0] != "ingest" {
+		t.Fatalf("implicit dependency not filled in: %v", after)
+	}
+
+	rr = doRequest(h, "GET", "/lambda/pipeline-runs", nil, token)
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 without lambda, got %d", rr.Code)
+	}
+	h.SetLambdaManager(lambda.NewTriggerManager(store, nil, config.LambdaConfig{MaxWorkers: 1, QueueSize: 1}))
+	rr = doRequest(h, "POST", "/lambda/pipelines/b/p/run", map[string]string{"key": "missing"}, token)
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for a missing object, got %d", rr.Code)
+	}
+	store.PutObjectMeta(metadata.ObjectMeta{Bucket: "b", Key: "k", Size: 3})
+	rr = doRequest(h, "POST", "/lambda/pipelines/b/p/run", map[string]string{"key": "k"}, token)
+	var run metadata.PipelineRun
+	json.Unmarshal(rr.Body.Bytes(), &run)
+	if rr.Code != http.StatusAccepted || run.Status != metadata.PipelineRunning || run.Step("ingest").Status != metadata.StepQueued {
+		t.Fatalf("start = %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = doRequest(h, "POST", "/lambda/pipeline-runs/1/cancel", nil, token)
+	json.Unmarshal(rr.Body.Bytes(), &run)
+	if rr.Code != http.StatusOK || run.Status != metadata.PipelineCancelled || run.Step("scan").Status != metadata.StepSkipped {
+		t.Fatalf("cancel = %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = doRequest(h, "GET", "/lambda/pipeline-runs?status=cancelled", nil, token)
+	var runs []metadata.PipelineRun
+	json.Unmarshal(rr.Body.Bytes(), &runs)
+	if len(runs) != 1 || runs[0].ID != run.ID {
+		t.Fatalf("runs = %s", rr.Body.String())
+	}
+	rr = doRequest(h, "GET", "/lambda/pipeline-runs/99", nil, token)
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", rr.Code)
+	}
+}
+
+func TestScannerScanBeforeServeAndRelease(t *testing.T) {
+	h, store := newTestAPI(t)
+	token := getToken(t, h)
+	store.CreateBucket("uploads")
+	h.engine.CreateBucketDir("uploads")
+
+	rr := doRequest(h, "PUT", "/scanner/buckets/uploads", map[string]bool{"scan_before_serve": true}, token)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("PUT scan setting: %d %s", rr.Code, rr.Body.String())
+	}
+	rr = doRequest(h, "GET", "/buckets/uploads", nil, token)
+	if !strings.Contains(rr.Body.String(), `"scanBeforeServe":true`) {
+		t.Fatalf("bucket detail = %s", rr.Body.String())
+	}
+	if rr = doRequest(h, "GET", "/scanner/buckets/missing", nil, token); rr.Code != http.StatusNotFound {
+		t.Fatalf("unknown bucket: expected 404, got %d", rr.Code)
+	}
+
+	// Pending objects cannot be downloaded from the dashboard either
+	h.engine.PutObject("uploads", "new.txt", strings.NewReader("data"), 4)
+	store.PutObjectMeta(metadata.ObjectMeta{Bucket: "uploads", Key: "new.txt", Size: 4, ScanStatus: metadata.ScanPending})
+	if rr = doRequest(h, "GET", "/buckets/uploads/download/new.txt", nil, token); rr.Code != http.StatusForbidden {
+		t.Fatalf("download of pending object: expected 403, got %d", rr.Code)
+	}
+
+	if rr = doRequest(h, "POST", "/scanner/quarantine/release", map[string]string{"key": "uploads/bad.txt"}, token); rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("release without scanner: expected 503, got %d", rr.Code)
+	}
+
+	h.SetScanner(scanner.NewScanner(store, h.engine, "", 1, 5, "quarantine", false, 0, 4))
+	h.engine.CreateBucketDir("quarantine")
+	h.engine.PutObject("quarantine", "uploads/bad.txt", strings.NewReader("bad"), 3)
+	store.PutQuarantineRecord(metadata.QuarantineRecord{
+		Key: "uploads/bad.txt", Bucket: "uploads", ObjectKey: "bad.txt", Reason: "Eicar-Test-Signature",
+		Meta: metadata.ObjectMeta{Bucket: "uploads", Key: "bad.txt", Size: 3, ContentType: "text/plain", ScanStatus: metadata.ScanPending},
+	})
+
+	rr = doRequest(h, "GET", "/scanner/quarantine", nil, token)
+	if !strings.Contains(rr.Body.String(), `"reason":"Eicar-Test-Signature"`) {
+		t.Fatalf("quarantine list = %s", rr.Body.String())
+	}
+	rr = doRequest(h, "POST", "/scanner/quarantine/release", map[string]string{"key": "uploads/bad.txt"}, token)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("release: %d %s", rr.Code, rr.Body.String())
+	}
+	meta, err := store.GetObjectMeta("uploads", "bad.txt")
+	if err != nil || meta.ContentType != "text/plain" || meta.ScanStatus != metadata.ScanReleased {
+		t.Fatalf("released meta = %+v, %v", meta, err)
+	}
+	if rr = doRequest(h, "POST", "/scanner/quarantine/release", map[string]string{"key": "uploads/bad.txt"}, token); rr.Code != http.StatusNotFound {
+		t.Fatalf("second release: expected 404, got %d", rr.Code)
+	}
+}
+
+func TestScannerRescansAndBlocklist(t *testing.T) {
+	h, store := newTestAPI(t)
+	token := getToken(t, h)
+	store.CreateBucket("uploads")
+	h.engine.CreateBucketDir("uploads")
+	h.engine.PutObject("uploads", "a.txt", strings.NewReader("data"), 4)
+	store.PutObjectMeta(metadata.ObjectMeta{Bucket: "uploads", Key: "a.txt", Size: 4})
+
+	if rr := doRequest(h, "POST", "/scanner/rescans", map[string]string{"bucket": "uploads"}, token); rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("rescan without scanner: expected 503, got %d", rr.Code)
+	}
+	s := scanner.NewScanner(store, h.engine, "", 1, 5, "quarantine", false, 0, 4)
+	h.SetScanner(s)
+	s.Start(t.Context(), 1)
+	defer s.Stop()
+
+	if rr := doRequest(h, "POST", "/scanner/rescans", map[string]string{"bucket": "missing"}, token); rr.Code != http.StatusNotFound {
+		t.Fatalf("rescan of missing bucket: expected 404, got %d", rr.Code)
+	}
+	rr := doRequest(h, "POST", "/scanner/rescans", map[string]interface{}{"bucket": "uploads", "objects_per_sec": 1000}, token)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("start rescan: %d %s", rr.Code, rr.Body.String())
+	}
+	var rescan metadata.Rescan
+	json.NewDecoder(rr.Body).Decode(&rescan)
+	if rescan.ID == 0 || rescan.Total != 1 {
+		t.Fatalf("rescan = %+v", rescan)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for rescan.Status == metadata.RescanRunning && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		rr = doRequest(h, "GET", "/scanner/rescans/"+strconv.FormatUint(rescan.ID, 10), nil, token)
+		json.NewDecoder(rr.Body).Decode(&rescan)
+	}
+	// No webhook URL is configured, so the scan fails
+	if rescan.Status != metadata.RescanCompleted || rescan.Scanned != 1 || rescan.Errors != 1 {
+		t.Fatalf("finished rescan = %+v", rescan)
+	}
+	if rr = doRequest(h, "POST", "/scanner/rescans/"+strconv.FormatUint(rescan.ID, 10)+"/cancel", nil, token); rr.Code != http.StatusConflict {
+		t.Fatalf("cancel finished rescan: expected 409, got %d", rr.Code)
+	}
+	if rr = doRequest(h, "GET", "/scanner/rescans/99", nil, token); rr.Code != http.StatusNotFound {
+		t.Fatalf("unknown rescan: expected 404, got %d", rr.Code)
+	}
+	rr = doRequest(h, "GET", "/scanner/rescans?status=completed", nil, token)
+	if !strings.Contains(rr.Body.String(), `"bucket":"uploads"`) {
+		t.Fatalf("rescan list = %s", rr.Body.String())
+	}
+
+	rr = doRequest(h, "GET", "/buckets/uploads/scan-history/a.txt", nil, token)
+	var history []metadata.ScanRecord
+	json.NewDecoder(rr.Body).Decode(&history)
+	if rr.Code != http.StatusOK || len(history) != 1 || history[0].Verdict != "error" || history[0].RescanID != rescan.ID {
+		t.Fatalf("scan history: %d %s", rr.Code, rr.Body.String())
+	}
+
+	// Blocklist
+	sum := strings.Repeat("ab", 32)
+	if rr = doRequest(h, "POST", "/scanner/blocklist", map[string]string{"sha256": "abc"}, token); rr.Code != http.StatusBadRequest {
+		t.Fatalf("invalid hash: expected 400, got %d", rr.Code)
+	}
+	if rr = doRequest(h, "POST", "/scanner/blocklist", map[string]string{"sha256": strings.ToUpper(sum), "name": "Bad.Sample"}, token); rr.Code != http.StatusCreated {
+		t.Fatalf("add blocklist entry: %d %s", rr.Code, rr.Body.String())
+	}
+	rr = doRequest(h, "GET", "/scanner/blocklist", nil, token)
+	if !strings.Contains(rr.Body.String(), `"sha256":"`+sum+`"`) || !strings.Contains(rr.Body.String(), `"name":"Bad.Sample"`) {
+		t.Fatalf("blocklist = %s", rr.Body.String())
+	}
+	if rr = doRequest(h, "DELETE", "/scanner/blocklist/"+sum, nil, token); rr.Code != http.StatusNoContent {
+		t.Fatalf("delete blocklist entry: expected 204, got %d", rr.Code)
+	}
+	if rr = doRequest(h, "DELETE", "/scanner/blocklist/"+sum, nil, token); rr.Code != http.StatusNotFound {
+		t.Fatalf("delete missing entry: expected 404, got %d", rr.Code)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	h, store := newTestAPI(t)
+	token := getToken(t, h)
+	if rr := doRequest(h, "GET", "/search?q=report", nil, token); rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("search without index: expected 503, got %d", rr.Code)
+	}
+
+	store.CreateBucket("docs")
+	store.PutObjectMeta(metadata.ObjectMeta{Bucket: "docs", Key: "2026/report.pdf", ContentType: "application/pdf", UserMetadata: map[string]string{"owner": "alice"}})
+	idx, err := search.Open(filepath.Join(t.TempDir(), "search.db"), store)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer idx.Close()
+	h.SetSearchIndex(idx)
+
+	if rr := doRequest(h, "POST", "/search/reindex", nil, token); rr.Code != http.StatusAccepted {
+		t.Fatalf("reindex: expected 202, got %d", rr.Code)
+	}
+	var status struct {
+		Objects  int  `json:"objects"`
+		Building bool `json:"building"`
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		rr := doRequest(h, "GET", "/search/status", nil, token)
+		json.NewDecoder(rr.Body).Decode(&status)
+		if !status.Building && status.Objects == 1 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if status.Objects != 1 {
+		t.Fatalf("status = %+v", status)
+	}
+
+	rr := doRequest(h, "GET", "/search?q=rep+meta:owner=alice&bucket=docs", nil, token)
+	var page search.Page
+	json.NewDecoder(rr.Body).Decode(&page)
+	if rr.Code != http.StatusOK || page.Total != 1 || len(page.Results) != 1 || page.Results[0].Key != "2026/report.pdf" || page.Results[0].UserMetadata["owner"] != "alice" {
+		t.Fatalf("search: %d %s", rr.Code, rr.Body.String())
+	}
+	rr = doRequest(h, "GET", "/search?q=report&bucket=other", nil, token)
+	if !strings.Contains(rr.Body.String(), `"results":[]`) {
+		t.Fatalf("search of other bucket = %s", rr.Body.String())
+	}
+	if rr := doRequest(h, "GET", "/search?q=(report", nil, token); rr.Code != http.StatusBadRequest {
+		t.Fatalf("invalid query: expected 400, got %d", rr.Code)
+	}
+	if rr := doRequest(h, "GET", "/search?q=report&cursor=bogus", nil, token); rr.Code != http.StatusBadRequest {
+		t.Fatalf("invalid cursor: expected 400, got %d", rr.Code)
+	}
+}
+
+func TestSearchContentIndex(t *testing.T) {
+	h, store := newTestAPI(t)
+	token := getToken(t, h)
+	store.CreateBucket("notes")
+	h.engine.CreateBucketDir("notes")
+	body := "Minutes of the planning meeting: the budget was approved."
+	size, etag, err := h.engine.PutObject("notes", "minutes.txt", strings.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatalf("PutObject: %v", err)
+	}
+	store.PutObjectMeta(metadata.ObjectMeta{Bucket: "notes", Key: "minutes.txt", ContentType: "text/plain", Size: size, ETag: etag})
+
+	idx, err := search.Open(filepath.Join(t.TempDir(), "search.db"), store)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer idx.Close()
+	if err := idx.Build(); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	ci := search.NewContentIndexer(idx, store, h.engine, 1, 0, 0)
+	ci.Start(context.Background())
+	defer ci.Stop()
+	h.SetSearchIndex(idx)
+	h.SetContentIndexer(ci)
+
+	if rr := doRequest(h, "PUT", "/search/buckets/missing", map[string]bool{"content_index": true}, token); rr.Code != http.StatusNotFound {
+		t.Fatalf("unknown bucket: expected 404, got %d", rr.Code)
+	}
+	if rr := doRequest(h, "PUT", "/search/buckets/notes", map[string]bool{"content_index": true}, token); rr.Code != http.StatusOK {
+		t.Fatalf("enable: %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := doRequest(h, "GET", "/search/buckets/notes", nil, token); !strings.Contains(rr.Body.String(), `"content_index":true`) {
+		t.Fatalf("GET = %s", rr.Body.String())
+	}
+
+	var page search.Page
+	deadline := time.Now().Add(5 * time.Second)
+	for len(page.Results) == 0 && time.Now().Before(deadline) {
+		rr := doRequest(h, "GET", "/search?q=budgets", nil, token)
+		json.NewDecoder(rr.Body).Decode(&page)
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(page.Results) != 1 || page.Results[0].Score <= 0 || len(page.Results[0].Snippet) == 0 {
+		t.Fatalf("results = %+v", page.Results)
+	}
+	rr := doRequest(h, "GET", "/search/status", nil, token)
+	if !strings.Contains(rr.Body.String(), `"indexed":1`) {
+		t.Errorf("status = %s", rr.Body.String())
+	}
+
+	if rr := doRequest(h, "PUT", "/search/buckets/notes", map[string]bool{"content_index": false}, token); rr.Code != http.StatusOK {
+		t.Fatalf("disable: %d", rr.Code)
+	}
+	if rr := doRequest(h, "GET", "/search?q=budget", nil, token); !strings.Contains(rr.Body.String(), `"total":0`) {
+		t.Errorf("search after disabling = %s", rr.Body.String())
+	}
+}
+
+func TestUploadPolicy(t *testing.T) {
+	h, store := newTestAPI(t)
+	token := getToken(t, h)
+	store.CreateBucket("documents")
+	h.engine.CreateBucketDir("documents")
+
+	if rr := doRequest(h, "GET", "/buckets/documents/upload-policy", nil, token); rr.Code != http.StatusNotFound {
+		t.Fatalf("GET without policy: expected 404, got %d", rr.Code)
+	}
+	for _, bad := range []metadata.UploadPolicy{{}, {Deny: []string{"exe"}}, {Allow: []string{"image/p*"}}} {
+		if rr := doRequest(h, "PUT", "/buckets/documents/upload-policy", bad, token); rr.Code != http.StatusBadRequest {
+			t.Errorf("PUT %+v: expected 400, got %d", bad, rr.Code)
+		}
+	}
+	rr := doRequest(h, "PUT", "/buckets/documents/upload-policy", metadata.UploadPolicy{
+		Allow: []string{"document", "image/*"},
+		Deny:  []string{"executable"},
+	}, token)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("PUT policy: %d %s", rr.Code, rr.Body.String())
+	}
+	rr = doRequest(h, "GET", "/buckets/documents/upload-policy", nil, token)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"image/*"`) {
+		t.Fatalf("GET policy: %d %s", rr.Code, rr.Body.String())
+	}
+
+	// Dashboard uploads are checked against the policy as well
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	fw, _ := mw.CreateFormFile("file", "report.pdf")
+	fw.Write([]byte("\x7fELF\x02\x01\x01\x00"))
+	fw, _ = mw.CreateFormFile("other", "scan.png")
+	fw.Write([]byte("\x89PNG\r\n\x1a\n"))
+	mw.Close()
+	req := httptest.NewRequest("POST", "/api/v1/buckets/documents/upload", &form)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	var results []uploadResult
+	json.NewDecoder(rec.Body).Decode(&results)
+	for _, res := range results {
+		if (res.Key == "report.pdf") != (res.Error != "") {
+			t.Errorf("upload result %+v", res)
+		}
+	}
+	if len(results) != 2 {
+		t.Fatalf("upload results = %+v", results)
+	}
+	if _, err := store.GetObjectMeta("documents", "report.pdf"); err == nil {
+		t.Error("rejected file was stored")
+	}
+
+	if rr = doRequest(h, "DELETE", "/buckets/documents/upload-policy", nil, token); rr.Code != http.StatusNoContent {
+		t.Fatalf("DELETE policy: %d", rr.Code)
+	}
+	if _, err := store.GetUploadPolicy("documents"); err == nil {
+		t.Error("policy should be deleted")
+	}
+}
+
+func TestVersionDiffModes(t *testing.T) {
+	h, store := newTestAPI(t)
+	token := getToken(t, h)
+	store.CreateBucket("cfg")
+	h.engine.CreateBucketDir("cfg")
+	for v, body := range map[string]string{"v1": "id,qty\na,1\nb,2\n", "v2": "id,qty\nb,3\nc,1\n"} {
+		size, etag, _ := h.engine.PutObjectVersion("cfg", "stock.csv", v, strings.NewReader(body), int64(len(body)))
+		store.PutObjectVersion(metadata.ObjectMeta{Bucket: "cfg", Key: "stock.csv", VersionID: v, ContentType: "text/csv", Size: size, ETag: etag})
+	}
+	base := "/versions/diff?bucket=cfg&key=stock.csv&v1=v1&v2=v2"
+
+	rr := doRequest(h, "GET", base+"&mode=auto", nil, token)
+	var result versioning.DiffResult
+	json.NewDecoder(rr.Body).Decode(&result)
+	if rr.Code != http.StatusOK || result.Type != "csv" || result.Stats == nil ||
+		result.Stats.Added != 1 || result.Stats.Removed != 1 || result.Stats.Changed != 1 {
+		t.Fatalf("csv diff: %d %s", rr.Code, rr.Body.String())
+	}
+	for query, want := range map[string]int{
+		"&mode=csv&column=missing":  http.StatusBadRequest,
+		"&mode=xml":                 http.StatusBadRequest,
+		"&mode=binary&block_size=1": http.StatusBadRequest,
+		"&mode=json":                http.StatusUnprocessableEntity,
+		"&mode=binary":              http.StatusOK,
+	} {
+		if rr := doRequest(h, "GET", base+query, nil, token); rr.Code != want {
+			t.Errorf("%s: expected %d, got %d: %s", query, want, rr.Code, rr.Body.String())
+		}
+	}
+}
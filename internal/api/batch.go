@@ -0,0 +1,123 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/eniz1806/VaultS3/internal/batch"
+	"github.com/eniz1806/VaultS3/internal/metadata"
+)
+
+// SetBatchProcessor sets the batch job processor.
+func (h *APIHandler) SetBatchProcessor(p *batch.Processor) {
+	h.batch = p
+}
+
+func (h *APIHandler) routeBatch(w http.ResponseWriter, r *http.Request, path string) {
+	if h.batch == nil {
+		writeError(w, http.StatusServiceUnavailable, "batch operations not available")
+		return
+	}
+	if path == "jobs" {
+		switch r.Method {
+		case http.MethodGet:
+			h.handleListBatchJobs(w, r)
+		case http.MethodPost:
+			h.handleCreateBatchJob(w, r)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+		return
+	}
+	if !strings.HasPrefix(path, "jobs/") {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	id, action, _ := strings.Cut(strings.TrimPrefix(path, "jobs/"), "/")
+	if _, err := h.batch.GetJob(id); err != nil {
+		writeError(w, http.StatusNotFound, "batch job not found")
+		return
+	}
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		h.handleGetBatchJob(w, r, id)
+	case action == "" && r.Method == http.MethodDelete:
+		h.handleBatchJobAction(w, id, h.batch.DeleteJob)
+	case action == "pause" && r.Method == http.MethodPost:
+		h.handleBatchJobAction(w, id, h.batch.Pause)
+	case action == "resume" && r.Method == http.MethodPost:
+		h.handleBatchJobAction(w, id, h.batch.Resume)
+	case action == "cancel" && r.Method == http.MethodPost:
+		h.handleBatchJobAction(w, id, h.batch.Cancel)
+	case action == "priority" && r.Method == http.MethodPut:
+		h.handleBatchJobPriority(w, r, id)
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (h *APIHandler) handleListBatchJobs(w http.ResponseWriter, r *http.Request) {
+	jobs, err := h.batch.ListJobs()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if jobs == nil {
+		jobs = []metadata.BatchJob{}
+	}
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+func (h *APIHandler) handleCreateBatchJob(w http.ResponseWriter, r *http.Request) {
+	var req metadata.BatchJob
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	job, err := h.batch.Submit(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, job)
+}
+
+func (h *APIHandler) handleGetBatchJob(w http.ResponseWriter, r *http.Request, id string) {
+	job, err := h.batch.GetJob(id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "batch job not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// handleBatchJobAction applies a state change to a job. Changes that the
+// job's status does not allow are reported as conflicts.
+func (h *APIHandler) handleBatchJobAction(w http.ResponseWriter, id string, action func(string) error) {
+	if err := action(id); err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	job, err := h.batch.GetJob(id)
+	if err != nil {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+func (h *APIHandler) handleBatchJobPriority(w http.ResponseWriter, r *http.Request, id string) {
+	var req struct {
+		Priority *int `json:"priority"`
+	}
+	if err := readJSON(r, &req); err != nil || req.Priority == nil {
+		writeError(w, http.StatusBadRequest, "priority is required")
+		return
+	}
+	if err := h.batch.SetPriority(id, *req.Priority); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	h.handleGetBatchJob(w, r, id)
+}
@@ -1,8 +1,12 @@
 package api
 
 import (
+	"io"
 	"net/http"
+	"time"
 
+	"github.com/eniz1806/VaultS3/internal/filetype"
+	"github.com/eniz1806/VaultS3/internal/lifecycle"
 	"github.com/eniz1806/VaultS3/internal/metadata"
 )
 
@@ -39,45 +43,83 @@ func (h *APIHandler) handlePutBucketVersioning(w http.ResponseWriter, r *http.Re
 // --- Lifecycle ---
 
 func (h *APIHandler) handleGetLifecycleRule(w http.ResponseWriter, _ *http.Request, bucket string) {
-	rule, err := h.store.GetLifecycleRule(bucket)
+	cfg, err := h.store.GetLifecycleConfig(bucket)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-	if rule == nil {
+	if cfg == nil || len(cfg.Rules) == 0 {
 		writeJSON(w, http.StatusOK, map[string]interface{}{"rule": nil})
 		return
 	}
+	rule := cfg.Rules[0]
 	writeJSON(w, http.StatusOK, map[string]interface{}{
 		"rule": map[string]interface{}{
-			"expirationDays": rule.ExpirationDays,
-			"prefix":         rule.Prefix,
-			"status":         rule.Status,
+			"expirationDays":               rule.ExpirationDays,
+			"expirationDate":               rule.ExpirationDate,
+			"prefix":                       rule.Prefix,
+			"status":                       rule.Status,
+			"transitions":                  rule.Transitions,
+			"noncurrentVersionTransitions": rule.NoncurrentVersionTransitions,
 		},
 	})
 }
 
 func (h *APIHandler) handlePutLifecycleRule(w http.ResponseWriter, r *http.Request, bucket string) {
 	var req struct {
-		ExpirationDays int    `json:"expirationDays"`
-		Prefix         string `json:"prefix"`
-		Status         string `json:"status"`
+		ExpirationDays               int                                    `json:"expirationDays"`
+		ExpirationDate               string                                 `json:"expirationDate"`
+		Prefix                       string                                 `json:"prefix"`
+		Status                       string                                 `json:"status"`
+		Transitions                  []metadata.LifecycleTransition         `json:"transitions"`
+		NoncurrentVersionTransitions []metadata.NoncurrentVersionTransition `json:"noncurrentVersionTransitions"`
 	}
 	if err := readJSON(r, &req); err != nil {
 		writeError(w, http.StatusBadRequest, "invalid JSON")
 		return
 	}
-	if req.ExpirationDays < 1 {
-		writeError(w, http.StatusBadRequest, "expirationDays must be >= 1")
+	if req.ExpirationDays < 0 {
+		writeError(w, http.StatusBadRequest, "expirationDays must not be negative")
 		return
 	}
+	if req.ExpirationDays == 0 && req.ExpirationDate == "" && len(req.Transitions) == 0 && len(req.NoncurrentVersionTransitions) == 0 {
+		writeError(w, http.StatusBadRequest, "expirationDays must be >= 1 or an expirationDate or transition must be set")
+		return
+	}
+	if req.ExpirationDate != "" {
+		if _, err := time.Parse(time.RFC3339, req.ExpirationDate); err != nil {
+			writeError(w, http.StatusBadRequest, "expirationDate must be RFC3339")
+			return
+		}
+	}
+	for _, t := range req.Transitions {
+		if t.StorageClass == "" {
+			writeError(w, http.StatusBadRequest, "transition storage_class is required")
+			return
+		}
+		if t.Date != "" {
+			if _, err := time.Parse(time.RFC3339, t.Date); err != nil {
+				writeError(w, http.StatusBadRequest, "transition date must be RFC3339")
+				return
+			}
+		}
+	}
+	for _, t := range req.NoncurrentVersionTransitions {
+		if t.StorageClass == "" || t.NoncurrentDays < 1 {
+			writeError(w, http.StatusBadRequest, "noncurrent transitions need storage_class and noncurrent_days >= 1")
+			return
+		}
+	}
 	if req.Status == "" {
 		req.Status = "Enabled"
 	}
 	rule := metadata.LifecycleRule{
-		ExpirationDays: req.ExpirationDays,
-		Prefix:         req.Prefix,
-		Status:         req.Status,
+		ExpirationDays:               req.ExpirationDays,
+		ExpirationDate:               req.ExpirationDate,
+		Prefix:                       req.Prefix,
+		Status:                       req.Status,
+		Transitions:                  req.Transitions,
+		NoncurrentVersionTransitions: req.NoncurrentVersionTransitions,
 	}
 	if err := h.store.PutLifecycleRule(bucket, rule); err != nil {
 		writeError(w, http.StatusInternalServerError, err.Error())
@@ -94,6 +136,37 @@ func (h *APIHandler) handleDeleteLifecycleRule(w http.ResponseWriter, _ *http.Re
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// handlePreviewLifecycle evaluates a proposed lifecycle config without
+// applying it. An empty body previews the bucket's current config.
+func (h *APIHandler) handlePreviewLifecycle(w http.ResponseWriter, r *http.Request, bucket string) {
+	if !h.store.BucketExists(bucket) {
+		writeError(w, http.StatusNotFound, "bucket not found")
+		return
+	}
+	var cfg metadata.LifecycleConfig
+	if err := readJSON(r, &cfg); err != nil && err != io.EOF {
+		writeError(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+	if len(cfg.Rules) == 0 {
+		current, err := h.store.GetLifecycleConfig(bucket)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if current != nil {
+			cfg = *current
+		}
+	}
+	for _, rule := range cfg.Rules {
+		if rule.Status != "Enabled" && rule.Status != "Disabled" {
+			writeError(w, http.StatusBadRequest, "rule status must be Enabled or Disabled")
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, lifecycle.Preview(h.store, bucket, &cfg, h.tieringMgr != nil))
+}
+
 // --- CORS ---
 
 func (h *APIHandler) handleGetCORSConfig(w http.ResponseWriter, _ *http.Request, bucket string) {
@@ -132,3 +205,53 @@ func (h *APIHandler) handleDeleteCORSConfig(w http.ResponseWriter, _ *http.Reque
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// --- Upload policy ---
+
+func (h *APIHandler) handleGetUploadPolicy(w http.ResponseWriter, _ *http.Request, bucket string) {
+	if !h.store.BucketExists(bucket) {
+		writeError(w, http.StatusNotFound, "bucket not found")
+		return
+	}
+	policy, err := h.store.GetUploadPolicy(bucket)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "no upload policy")
+		return
+	}
+	writeJSON(w, http.StatusOK, policy)
+}
+
+func (h *APIHandler) handlePutUploadPolicy(w http.ResponseWriter, r *http.Request, bucket string) {
+	if !h.store.BucketExists(bucket) {
+		writeError(w, http.StatusNotFound, "bucket not found")
+		return
+	}
+	var policy metadata.UploadPolicy
+	if err := readJSON(r, &policy); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+	if len(policy.Allow) == 0 && len(policy.Deny) == 0 {
+		writeError(w, http.StatusBadRequest, "an upload policy needs allow or deny types")
+		return
+	}
+	for _, pattern := range append(append([]string{}, policy.Allow...), policy.Deny...) {
+		if err := filetype.ValidatePattern(pattern); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if err := h.store.PutUploadPolicy(bucket, policy); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, policy)
+}
+
+func (h *APIHandler) handleDeleteUploadPolicy(w http.ResponseWriter, _ *http.Request, bucket string) {
+	if err := h.store.DeleteUploadPolicy(bucket); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
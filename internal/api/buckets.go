@@ -17,13 +17,15 @@ type bucketListItem struct {
 }
 
 type bucketDetail struct {
-	Name         string          `json:"name"`
-	CreatedAt    time.Time       `json:"createdAt"`
-	Size         int64           `json:"size"`
-	ObjectCount  int64           `json:"objectCount"`
-	MaxSizeBytes int64           `json:"maxSizeBytes,omitempty"`
-	MaxObjects   int64           `json:"maxObjects,omitempty"`
-	Policy       json.RawMessage `json:"policy,omitempty"`
+	Name            string          `json:"name"`
+	CreatedAt       time.Time       `json:"createdAt"`
+	Size            int64           `json:"size"`
+	ObjectCount     int64           `json:"objectCount"`
+	MaxSizeBytes    int64           `json:"maxSizeBytes,omitempty"`
+	MaxObjects      int64           `json:"maxObjects,omitempty"`
+	Policy          json.RawMessage `json:"policy,omitempty"`
+	ScanBeforeServe bool            `json:"scanBeforeServe,omitempty"`
+	ContentIndex    bool            `json:"contentIndex,omitempty"`
 }
 
 type createBucketRequest struct {
@@ -112,12 +114,14 @@ func (h *APIHandler) handleGetBucket(w http.ResponseWriter, _ *http.Request, nam
 	policyBytes, _ := h.store.GetBucketPolicy(name)
 
 	detail := bucketDetail{
-		Name:         b.Name,
-		CreatedAt:    b.CreatedAt,
-		Size:         size,
-		ObjectCount:  count,
-		MaxSizeBytes: b.MaxSizeBytes,
-		MaxObjects:   b.MaxObjects,
+		Name:            b.Name,
+		CreatedAt:       b.CreatedAt,
+		Size:            size,
+		ObjectCount:     count,
+		MaxSizeBytes:    b.MaxSizeBytes,
+		MaxObjects:      b.MaxObjects,
+		ScanBeforeServe: b.ScanBeforeServe,
+		ContentIndex:    b.ContentIndex,
 	}
 	if len(policyBytes) > 0 {
 		detail.Policy = json.RawMessage(policyBytes)
@@ -139,6 +143,7 @@ func (h *APIHandler) handleDeleteBucket(w http.ResponseWriter, _ *http.Request,
 	}
 
 	h.store.DeleteBucketPolicy(name)
+	h.store.DeleteUploadPolicy(name)
 	h.store.DeleteBucketObjectMeta(name)
 	if err := h.store.DeleteBucket(name); err != nil {
 		writeError(w, http.StatusInternalServerError, "failed to delete bucket")
@@ -3,10 +3,12 @@ package api
 import (
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/eniz1806/VaultS3/internal/lambda"
 	"github.com/eniz1806/VaultS3/internal/metadata"
+	"github.com/eniz1806/VaultS3/internal/webhook"
 )
 
 // SetLambdaManager sets the lambda trigger manager.
@@ -17,8 +19,16 @@ func (h *APIHandler) SetLambdaManager(mgr *lambda.TriggerManager) {
 type lambdaTriggerResponse struct {
 	ID          string   `json:"id"`
 	FunctionURL string   `json:"functionURL"`
+	Runtime     string   `json:"runtime,omitempty"`
+	Command     string   `json:"command,omitempty"`
 	Events      []string `json:"events"`
 	KeyFilter   string   `json:"keyFilter"`
+
+	MaxRetries       int `json:"maxRetries,omitempty"`
+	RetryBackoffSecs int `json:"retryBackoffSecs,omitempty"`
+	MaxConcurrency   int `json:"maxConcurrency,omitempty"`
+
+	Auth *metadata.WebhookAuth `json:"auth,omitempty"` // secrets redacted
 }
 
 type bucketTriggersResponse struct {
@@ -42,8 +52,15 @@ func convertTriggers(triggers []metadata.LambdaTrigger) []lambdaTriggerResponse
 		result = append(result, lambdaTriggerResponse{
 			ID:          t.ID,
 			FunctionURL: t.FunctionURL,
+			Runtime:     t.Runtime,
+			Command:     t.Command,
 			Events:      t.Events,
 			KeyFilter:   keyFilter,
+			Auth:        t.Auth.Redacted(),
+
+			MaxRetries:       t.MaxRetries,
+			RetryBackoffSecs: t.RetryBackoffSecs,
+			MaxConcurrency:   t.MaxConcurrency,
 		})
 	}
 	return result
@@ -95,12 +112,27 @@ func (h *APIHandler) handlePutLambdaTriggers(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	// Validate function URLs to prevent SSRF
-	for _, t := range cfg.Triggers {
-		if err := ValidateWebhookURL(t.FunctionURL); err != nil {
-			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid function URL: %v", err))
+	prevAuth := make(map[string]*metadata.WebhookAuth)
+	if prev, err := h.store.GetLambdaConfig(bucket); err == nil {
+		for _, t := range prev.Triggers {
+			prevAuth[t.ID] = t.Auth
+		}
+	}
+
+	for i, t := range cfg.Triggers {
+		if err := h.validateLambdaFunction(t.Runtime, t.FunctionURL, t.Command); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := webhook.Validate(t.Auth, h.cfg.Security.WebhookSecrets); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid auth: %v", err))
+			return
+		}
+		if t.MaxRetries < 0 || t.RetryBackoffSecs < 0 || t.MaxConcurrency < 0 {
+			writeError(w, http.StatusBadRequest, "max_retries, retry_backoff_secs and max_concurrency must not be negative")
 			return
 		}
+		cfg.Triggers[i].Auth.KeepSecrets(prevAuth[t.ID])
 	}
 
 	if err := h.store.PutLambdaConfig(bucket, cfg); err != nil {
@@ -110,6 +142,25 @@ func (h *APIHandler) handlePutLambdaTriggers(w http.ResponseWriter, r *http.Requ
 	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
 }
 
+// validateLambdaFunction checks the function a trigger or pipeline step
+// calls: a function URL that is safe to call, or an allow-listed command.
+func (h *APIHandler) validateLambdaFunction(runtime, functionURL, command string) error {
+	switch runtime {
+	case "", metadata.LambdaRuntimeHTTP:
+		// Validate function URLs to prevent SSRF
+		if err := ValidateWebhookURL(functionURL); err != nil {
+			return fmt.Errorf("invalid function URL: %v", err)
+		}
+	case metadata.LambdaRuntimeProcess:
+		if _, ok := h.cfg.Lambda.Command(command); !ok {
+			return fmt.Errorf("command %q is not allow-listed in lambda.commands", command)
+		}
+	default:
+		return fmt.Errorf("unknown runtime %q", runtime)
+	}
+	return nil
+}
+
 // handleDeleteLambdaTriggers removes lambda triggers for a specific bucket.
 func (h *APIHandler) handleDeleteLambdaTriggers(w http.ResponseWriter, r *http.Request, bucket string) {
 	h.store.DeleteLambdaConfig(bucket)
@@ -123,9 +174,11 @@ func (h *APIHandler) handleLambdaStatus(w http.ResponseWriter, r *http.Request)
 		"totalTriggers": 0,
 		"buckets":       0,
 		"queueDepth":    0,
+		"deadLetters":   0,
 	}
 	if h.lambdaMgr != nil {
 		status["queueDepth"] = h.lambdaMgr.QueueDepth()
+		status["deadLetters"] = h.lambdaMgr.DeadLetterCount()
 		configs, _ := h.store.ListLambdaConfigs()
 		totalTriggers := 0
 		for _, c := range configs {
@@ -144,6 +197,10 @@ func (h *APIHandler) routeLambda(w http.ResponseWriter, r *http.Request, path st
 		h.handleListLambdaTriggers(w, r)
 	case path == "status" && r.Method == http.MethodGet:
 		h.handleLambdaStatus(w, r)
+	case path == "invocations" || strings.HasPrefix(path, "invocations/") || strings.HasPrefix(path, "dead-letters"):
+		h.routeLambdaQueue(w, r, path)
+	case path == "pipelines" || strings.HasPrefix(path, "pipelines/") || strings.HasPrefix(path, "pipeline-runs"):
+		h.routePipelines(w, r, path)
 	case strings.HasPrefix(path, "triggers/"):
 		bucket := strings.TrimPrefix(path, "triggers/")
 		switch r.Method {
@@ -160,3 +217,132 @@ func (h *APIHandler) routeLambda(w http.ResponseWriter, r *http.Request, path st
 		writeError(w, http.StatusNotFound, "not found")
 	}
 }
+
+// routeLambdaQueue handles the invocation history and dead letters.
+func (h *APIHandler) routeLambdaQueue(w http.ResponseWriter, r *http.Request, path string) {
+	if h.lambdaMgr == nil {
+		writeError(w, http.StatusServiceUnavailable, "lambda triggers not enabled")
+		return
+	}
+	switch {
+	case path == "invocations" && r.Method == http.MethodGet:
+		h.handleListLambdaInvocations(w, r)
+	case strings.HasPrefix(path, "invocations/") && strings.HasSuffix(path, "/retry") && r.Method == http.MethodPost:
+		h.handleRetryLambdaInvocation(w, r, strings.TrimSuffix(strings.TrimPrefix(path, "invocations/"), "/retry"))
+	case path == "dead-letters" && r.Method == http.MethodGet:
+		h.handleListLambdaDeadLetters(w, r)
+	case path == "dead-letters/replay" && r.Method == http.MethodPost:
+		h.handleLambdaDeadLetterAction(w, r, h.lambdaMgr.ReplayDeadLetters, "replayed")
+	case path == "dead-letters/purge" && r.Method == http.MethodPost:
+		h.handleLambdaDeadLetterAction(w, r, h.lambdaMgr.PurgeDeadLetters, "purged")
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// queryLimit parses the limit query parameter, defaulting to 100.
+func queryLimit(r *http.Request) (int, bool) {
+	v := r.URL.Query().Get("limit")
+	if v == "" {
+		return 100, true
+	}
+	n, err := strconv.Atoi(v)
+	return n, err == nil && n > 0
+}
+
+// handleListLambdaInvocations returns the invocation history, newest first,
+// filtered by bucket, trigger, status and pipeline run and paged with before.
+func (h *APIHandler) handleListLambdaInvocations(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit, ok := queryLimit(r)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "limit must be a positive integer")
+		return
+	}
+	f := metadata.LambdaInvocationFilter{
+		Bucket:    q.Get("bucket"),
+		TriggerID: q.Get("trigger"),
+		Status:    q.Get("status"),
+		Limit:     limit,
+	}
+	if v := q.Get("before"); v != "" {
+		before, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "before must be an invocation ID")
+			return
+		}
+		f.Before = before
+	}
+	if v := q.Get("run"); v != "" {
+		run, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "run must be a pipeline run ID")
+			return
+		}
+		f.RunID = run
+	}
+	invocations, err := h.lambdaMgr.Invocations(f)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if invocations == nil {
+		invocations = []metadata.LambdaInvocation{}
+	}
+	writeJSON(w, http.StatusOK, invocations)
+}
+
+// handleRetryLambdaInvocation queues the event of a recorded invocation for
+// its trigger again.
+func (h *APIHandler) handleRetryLambdaInvocation(w http.ResponseWriter, r *http.Request, idStr string) {
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid invocation ID")
+		return
+	}
+	if err := h.lambdaMgr.Retry(id); err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "queued"})
+}
+
+func (h *APIHandler) handleListLambdaDeadLetters(w http.ResponseWriter, r *http.Request) {
+	limit, ok := queryLimit(r)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "limit must be a positive integer")
+		return
+	}
+	jobs, err := h.lambdaMgr.DeadLetters(r.URL.Query().Get("bucket"), r.URL.Query().Get("trigger"), limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if jobs == nil {
+		jobs = []metadata.LambdaJob{}
+	}
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+// handleLambdaDeadLetterAction replays or purges the dead letters selected
+// by the request body: the listed IDs, or all of them, optionally for one
+// bucket or trigger.
+func (h *APIHandler) handleLambdaDeadLetterAction(w http.ResponseWriter, r *http.Request, action func([]uint64, string, string) (int, error), verb string) {
+	var req struct {
+		IDs     []uint64 `json:"ids"`
+		Bucket  string   `json:"bucket"`
+		Trigger string   `json:"trigger"`
+	}
+	if r.ContentLength != 0 {
+		if err := readJSON(r, &req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+	}
+	n, err := action(req.IDs, req.Bucket, req.Trigger)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{verb: n})
+}
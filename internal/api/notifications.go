@@ -1,11 +1,29 @@
 package api
 
-import "net/http"
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/eniz1806/VaultS3/internal/metadata"
+	"github.com/eniz1806/VaultS3/internal/notify"
+	"github.com/eniz1806/VaultS3/internal/webhook"
+)
+
+// SetNotifyDispatcher sets the notification dispatcher whose outbox and
+// dead letters the API exposes.
+func (h *APIHandler) SetNotifyDispatcher(d *notify.Dispatcher) {
+	h.notify = d
+}
 
 type notificationResponse struct {
-	Bucket     string   `json:"bucket"`
-	WebhookURL string   `json:"webhookURL"`
-	Events     []string `json:"events"`
+	Bucket     string                `json:"bucket"`
+	ID         string                `json:"id,omitempty"`
+	WebhookURL string                `json:"webhookURL"`
+	Target     string                `json:"target,omitempty"` // ARN of a named target
+	Events     []string              `json:"events"`
+	Auth       *metadata.WebhookAuth `json:"auth,omitempty"` // secrets redacted
 }
 
 func (h *APIHandler) handleListNotifications(w http.ResponseWriter, r *http.Request) {
@@ -15,17 +33,159 @@ func (h *APIHandler) handleListNotifications(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	// Flatten: one entry per webhook endpoint
+	// Flatten: one entry per webhook endpoint or target
 	result := make([]notificationResponse, 0)
 	for bucket, cfg := range configs {
 		for _, wh := range cfg.Webhooks {
 			result = append(result, notificationResponse{
 				Bucket:     bucket,
+				ID:         wh.ID,
 				WebhookURL: wh.Endpoint,
 				Events:     wh.Events,
+				Auth:       wh.Auth.Redacted(),
+			})
+		}
+		for _, tc := range cfg.Targets {
+			result = append(result, notificationResponse{
+				Bucket: bucket,
+				Target: tc.ARN,
+				Events: tc.Events,
 			})
 		}
 	}
 
 	writeJSON(w, http.StatusOK, result)
 }
+
+func (h *APIHandler) routeNotifications(w http.ResponseWriter, r *http.Request, path string) {
+	// {bucket}/webhooks/{id}/auth
+	if parts := strings.Split(path, "/"); len(parts) == 4 && parts[1] == "webhooks" && parts[3] == "auth" {
+		switch r.Method {
+		case http.MethodPut:
+			h.handlePutWebhookAuth(w, r, parts[0], parts[2])
+		case http.MethodDelete:
+			h.handleDeleteWebhookAuth(w, r, parts[0], parts[2])
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+		return
+	}
+	if h.notify == nil {
+		writeError(w, http.StatusServiceUnavailable, "notifications not available")
+		return
+	}
+	switch {
+	case path == "outbox" && r.Method == http.MethodGet:
+		h.handleNotificationOutbox(w, r)
+	case path == "dead-letters" && r.Method == http.MethodGet:
+		h.handleListDeadLetters(w, r)
+	case path == "dead-letters/replay" && r.Method == http.MethodPost:
+		h.handleDeadLetterAction(w, r, h.notify.ReplayDeadLetters, "replayed")
+	case path == "dead-letters/purge" && r.Method == http.MethodPost:
+		h.handleDeadLetterAction(w, r, h.notify.PurgeDeadLetters, "purged")
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (h *APIHandler) handleNotificationOutbox(w http.ResponseWriter, r *http.Request) {
+	stats, err := h.notify.QueueStats()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	delivered, failed := h.notify.DeliveryCounts()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"destinations":    stats,
+		"delivered":       delivered,
+		"failed_attempts": failed,
+	})
+}
+
+func (h *APIHandler) handleListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+		limit = n
+	}
+	events, err := h.notify.DeadLetters(r.URL.Query().Get("destination"), limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if events == nil {
+		events = []metadata.NotificationEvent{}
+	}
+	writeJSON(w, http.StatusOK, events)
+}
+
+// handleDeadLetterAction replays or purges the dead letters selected by the
+// request body: the listed IDs, or all of them, optionally for one destination.
+func (h *APIHandler) handleDeadLetterAction(w http.ResponseWriter, r *http.Request, action func([]uint64, string) (int, error), verb string) {
+	var req struct {
+		IDs         []uint64 `json:"ids"`
+		Destination string   `json:"destination"`
+	}
+	if r.ContentLength != 0 {
+		if err := readJSON(r, &req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+	}
+	n, err := action(req.IDs, req.Destination)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{verb: n})
+}
+
+// handlePutWebhookAuth sets the credentials of a bucket notification webhook.
+// Secrets sent as "REDACTED" keep their stored values.
+func (h *APIHandler) handlePutWebhookAuth(w http.ResponseWriter, r *http.Request, bucket, id string) {
+	var auth metadata.WebhookAuth
+	if err := readJSON(r, &auth); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if err := webhook.Validate(&auth, h.cfg.Security.WebhookSecrets); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid auth: %v", err))
+		return
+	}
+	h.updateWebhookAuth(w, bucket, id, func(prev *metadata.WebhookAuth) *metadata.WebhookAuth {
+		auth.KeepSecrets(prev)
+		return &auth
+	})
+}
+
+// handleDeleteWebhookAuth removes the credentials of a notification webhook.
+func (h *APIHandler) handleDeleteWebhookAuth(w http.ResponseWriter, r *http.Request, bucket, id string) {
+	h.updateWebhookAuth(w, bucket, id, func(*metadata.WebhookAuth) *metadata.WebhookAuth {
+		return nil
+	})
+}
+
+func (h *APIHandler) updateWebhookAuth(w http.ResponseWriter, bucket, id string, update func(prev *metadata.WebhookAuth) *metadata.WebhookAuth) {
+	cfg, err := h.store.GetNotificationConfig(bucket)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "webhook not found")
+		return
+	}
+	for i := range cfg.Webhooks {
+		if cfg.Webhooks[i].ID != id {
+			continue
+		}
+		cfg.Webhooks[i].Auth = update(cfg.Webhooks[i].Auth)
+		if err := h.store.PutNotificationConfig(bucket, *cfg); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+		return
+	}
+	writeError(w, http.StatusNotFound, "webhook not found")
+}
@@ -11,6 +11,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/eniz1806/VaultS3/internal/filetype"
 	"github.com/eniz1806/VaultS3/internal/metadata"
 )
 
@@ -32,6 +33,7 @@ type uploadResult struct {
 	Key         string `json:"key"`
 	Size        int64  `json:"size"`
 	ContentType string `json:"contentType"`
+	Error       string `json:"error,omitempty"` // set when the file was not stored
 }
 
 func (h *APIHandler) handleListObjects(w http.ResponseWriter, r *http.Request, bucket string) {
@@ -123,6 +125,12 @@ func (h *APIHandler) handleDownload(w http.ResponseWriter, r *http.Request, buck
 		return
 	}
 
+	meta, _ := h.store.GetObjectMeta(bucket, key)
+	if meta != nil && metadata.ScanHeld(meta.ScanStatus) {
+		writeError(w, http.StatusForbidden, "object has not passed a virus scan")
+		return
+	}
+
 	reader, size, err := h.engine.GetObject(bucket, key)
 	if err != nil {
 		writeError(w, http.StatusNotFound, "object not found")
@@ -132,7 +140,7 @@ func (h *APIHandler) handleDownload(w http.ResponseWriter, r *http.Request, buck
 
 	// Set content type from metadata
 	ct := "application/octet-stream"
-	if meta, err := h.store.GetObjectMeta(bucket, key); err == nil && meta != nil {
+	if meta != nil {
 		ct = meta.ContentType
 	}
 
@@ -165,6 +173,7 @@ func (h *APIHandler) handleUpload(w http.ResponseWriter, r *http.Request, bucket
 	}
 
 	prefix := r.URL.Query().Get("prefix")
+	policy, _ := h.store.GetUploadPolicy(bucket)
 	var results []uploadResult
 
 	for _, fileHeaders := range r.MultipartForm.File {
@@ -181,12 +190,6 @@ func (h *APIHandler) handleUpload(w http.ResponseWriter, r *http.Request, bucket
 				continue
 			}
 
-			written, etag, err := h.engine.PutObject(bucket, key, file, fh.Size)
-			file.Close()
-			if err != nil {
-				continue
-			}
-
 			// Detect content type
 			ct := fh.Header.Get("Content-Type")
 			if ct == "" || ct == "application/octet-stream" {
@@ -197,6 +200,30 @@ func (h *APIHandler) handleUpload(w http.ResponseWriter, r *http.Request, bucket
 				}
 			}
 
+			// The bucket's upload policy applies to dashboard uploads too
+			var src io.Reader = file
+			if policy != nil {
+				var t filetype.Type
+				if t, src, err = filetype.DetectReader(file); err != nil {
+					file.Close()
+					continue
+				}
+				if reason := policy.Rejection(t); reason != "" {
+					file.Close()
+					results = append(results, uploadResult{Key: key, Size: fh.Size, ContentType: t.MIME, Error: reason})
+					continue
+				}
+				if policy.OverrideContentType && !t.Consistent(ct) {
+					ct = t.MIME
+				}
+			}
+
+			written, etag, err := h.engine.PutObject(bucket, key, src, fh.Size)
+			file.Close()
+			if err != nil {
+				continue
+			}
+
 			h.store.PutObjectMeta(metadata.ObjectMeta{
 				Bucket:       bucket,
 				Key:          key,
@@ -302,6 +329,9 @@ func (h *APIHandler) handleDownloadZip(w http.ResponseWriter, r *http.Request, b
 		if err := validateObjectKey(key); err != nil {
 			continue
 		}
+		if meta, err := h.store.GetObjectMeta(bucket, key); err == nil && metadata.ScanHeld(meta.ScanStatus) {
+			continue
+		}
 		reader, _, err := h.engine.GetObject(bucket, key)
 		if err != nil {
 			continue
@@ -0,0 +1,248 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/eniz1806/VaultS3/internal/lambda"
+	"github.com/eniz1806/VaultS3/internal/metadata"
+	"github.com/eniz1806/VaultS3/internal/webhook"
+)
+
+// routePipelines handles lambda pipeline definitions and runs.
+func (h *APIHandler) routePipelines(w http.ResponseWriter, r *http.Request, path string) {
+	switch {
+	case path == "pipelines" && r.Method == http.MethodGet:
+		h.handleListPipelines(w, r)
+	case strings.HasPrefix(path, "pipelines/"):
+		rest := strings.TrimPrefix(path, "pipelines/")
+		if bucket, pipelineID, ok := strings.Cut(rest, "/"); ok {
+			if id, found := strings.CutSuffix(pipelineID, "/run"); found && r.Method == http.MethodPost {
+				h.handleStartPipeline(w, r, bucket, id)
+				return
+			}
+			writeError(w, http.StatusNotFound, "not found")
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			h.handleGetPipelines(w, r, rest)
+		case http.MethodPut:
+			h.handlePutPipelines(w, r, rest)
+		case http.MethodDelete:
+			h.store.DeletePipelineConfig(rest)
+			writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	case path == "pipeline-runs" && r.Method == http.MethodGet:
+		h.handleListPipelineRuns(w, r)
+	case strings.HasPrefix(path, "pipeline-runs/"):
+		rest := strings.TrimPrefix(path, "pipeline-runs/")
+		idStr, cancel := strings.CutSuffix(rest, "/cancel")
+		id, err := strconv.ParseUint(idStr, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid pipeline run ID")
+			return
+		}
+		switch {
+		case !cancel && r.Method == http.MethodGet:
+			h.handleGetPipelineRun(w, r, id)
+		case cancel && r.Method == http.MethodPost:
+			h.handleCancelPipelineRun(w, r, id)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+type bucketPipelinesResponse struct {
+	Bucket    string                    `json:"bucket"`
+	Pipelines []metadata.LambdaPipeline `json:"pipelines"`
+}
+
+// redactPipelines returns pipelines with their step secrets redacted.
+func redactPipelines(pipelines []metadata.LambdaPipeline) []metadata.LambdaPipeline {
+	out := make([]metadata.LambdaPipeline, 0, len(pipelines))
+	for _, p := range pipelines {
+		steps := make([]metadata.PipelineStep, len(p.Steps))
+		for i, step := range p.Steps {
+			step.Auth = step.Auth.Redacted()
+			steps[i] = step
+		}
+		p.Steps = steps
+		out = append(out, p)
+	}
+	return out
+}
+
+// handleListPipelines returns the pipelines of all buckets.
+func (h *APIHandler) handleListPipelines(w http.ResponseWriter, r *http.Request) {
+	configs, err := h.store.ListPipelineConfigs()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	result := make([]bucketPipelinesResponse, 0, len(configs))
+	for bucket, cfg := range configs {
+		result = append(result, bucketPipelinesResponse{Bucket: bucket, Pipelines: redactPipelines(cfg.Pipelines)})
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// handleGetPipelines returns the pipelines of a bucket.
+func (h *APIHandler) handleGetPipelines(w http.ResponseWriter, r *http.Request, bucket string) {
+	resp := bucketPipelinesResponse{Bucket: bucket, Pipelines: []metadata.LambdaPipeline{}}
+	if cfg, err := h.store.GetPipelineConfig(bucket); err == nil {
+		resp.Pipelines = redactPipelines(cfg.Pipelines)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handlePutPipelines replaces the pipelines of a bucket.
+func (h *APIHandler) handlePutPipelines(w http.ResponseWriter, r *http.Request, bucket string) {
+	if !h.store.BucketExists(bucket) {
+		writeError(w, http.StatusNotFound, "bucket not found")
+		return
+	}
+
+	var cfg metadata.BucketPipelineConfig
+	if err := readJSON(r, &cfg); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	prevAuth := make(map[string]*metadata.WebhookAuth)
+	if prev, err := h.store.GetPipelineConfig(bucket); err == nil {
+		for _, p := range prev.Pipelines {
+			for _, step := range p.Steps {
+				prevAuth[p.ID+"/"+step.ID] = step.Auth
+			}
+		}
+	}
+
+	seen := make(map[string]bool)
+	for i := range cfg.Pipelines {
+		p := &cfg.Pipelines[i]
+		if seen[p.ID] {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("pipeline %s is defined twice", p.ID))
+			return
+		}
+		seen[p.ID] = true
+		if err := lambda.PreparePipeline(p, h.cfg.Lambda.MaxPipelineDepth); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		for j := range p.Steps {
+			step := &p.Steps[j]
+			if err := h.validateLambdaFunction(step.Runtime, step.FunctionURL, step.Command); err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("step %s: %v", step.ID, err))
+				return
+			}
+			if err := webhook.Validate(step.Auth, h.cfg.Security.WebhookSecrets); err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("step %s: invalid auth: %v", step.ID, err))
+				return
+			}
+			if step.MaxRetries < 0 || step.RetryBackoffSecs < 0 || step.MaxBodySize < 0 {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("step %s: max_retries, retry_backoff_secs and max_body_size must not be negative", step.ID))
+				return
+			}
+			step.Auth.KeepSecrets(prevAuth[p.ID+"/"+step.ID])
+		}
+	}
+
+	if err := h.store.PutPipelineConfig(bucket, cfg); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, bucketPipelinesResponse{Bucket: bucket, Pipelines: redactPipelines(cfg.Pipelines)})
+}
+
+// handleStartPipeline runs a pipeline on an existing object.
+func (h *APIHandler) handleStartPipeline(w http.ResponseWriter, r *http.Request, bucket, pipelineID string) {
+	if h.lambdaMgr == nil {
+		writeError(w, http.StatusServiceUnavailable, "lambda triggers not enabled")
+		return
+	}
+	var req struct {
+		Key string `json:"key"`
+	}
+	if err := readJSON(r, &req); err != nil || req.Key == "" {
+		writeError(w, http.StatusBadRequest, "key is required")
+		return
+	}
+	run, err := h.lambdaMgr.StartPipeline(bucket, pipelineID, req.Key)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusAccepted, run)
+}
+
+// handleListPipelineRuns returns pipeline runs, newest first, filtered by
+// bucket, pipeline and status and paged with before.
+func (h *APIHandler) handleListPipelineRuns(w http.ResponseWriter, r *http.Request) {
+	if h.lambdaMgr == nil {
+		writeError(w, http.StatusServiceUnavailable, "lambda triggers not enabled")
+		return
+	}
+	q := r.URL.Query()
+	limit, ok := queryLimit(r)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "limit must be a positive integer")
+		return
+	}
+	f := metadata.PipelineRunFilter{
+		Bucket:     q.Get("bucket"),
+		PipelineID: q.Get("pipeline"),
+		Status:     q.Get("status"),
+		Limit:      limit,
+	}
+	if v := q.Get("before"); v != "" {
+		before, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "before must be a pipeline run ID")
+			return
+		}
+		f.Before = before
+	}
+	runs, err := h.lambdaMgr.PipelineRuns(f)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if runs == nil {
+		runs = []metadata.PipelineRun{}
+	}
+	writeJSON(w, http.StatusOK, runs)
+}
+
+func (h *APIHandler) handleGetPipelineRun(w http.ResponseWriter, r *http.Request, id uint64) {
+	if h.lambdaMgr == nil {
+		writeError(w, http.StatusServiceUnavailable, "lambda triggers not enabled")
+		return
+	}
+	run, err := h.lambdaMgr.PipelineRun(id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, run)
+}
+
+func (h *APIHandler) handleCancelPipelineRun(w http.ResponseWriter, r *http.Request, id uint64) {
+	if h.lambdaMgr == nil {
+		writeError(w, http.StatusServiceUnavailable, "lambda triggers not enabled")
+		return
+	}
+	run, err := h.lambdaMgr.CancelPipelineRun(id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, run)
+}
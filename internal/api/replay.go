@@ -0,0 +1,83 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/eniz1806/VaultS3/internal/replay"
+)
+
+// SetReplayer sets the event replayer.
+func (h *APIHandler) SetReplayer(r *replay.Replayer) {
+	h.replayer = r
+}
+
+func (h *APIHandler) routeReplay(w http.ResponseWriter, r *http.Request, path string) {
+	if h.replayer == nil {
+		writeError(w, http.StatusServiceUnavailable, "event replay not available")
+		return
+	}
+	if path == "jobs" {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, h.replayer.List())
+		case http.MethodPost:
+			h.handleStartReplay(w, r)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+		return
+	}
+	if !strings.HasPrefix(path, "jobs/") {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	id, action, _ := strings.Cut(strings.TrimPrefix(path, "jobs/"), "/")
+	job, ok := h.replayer.Get(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, "replay job not found")
+		return
+	}
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, job)
+	case action == "cancel" && r.Method == http.MethodPost:
+		if err := h.replayer.Cancel(id); err != nil {
+			writeError(w, http.StatusConflict, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "cancelling"})
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// handleStartReplay starts a replay job, or with dry_run only counts the
+// events it would send.
+func (h *APIHandler) handleStartReplay(w http.ResponseWriter, r *http.Request) {
+	var req replay.Request
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.Bucket != "" && !h.store.BucketExists(req.Bucket) {
+		writeError(w, http.StatusNotFound, "bucket not found")
+		return
+	}
+	if req.DryRun {
+		n, err := h.replayer.Count(req)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]int{"matched": n})
+		return
+	}
+	job, err := h.replayer.Start(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusAccepted, job)
+}
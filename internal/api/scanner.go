@@ -1,9 +1,14 @@
 package api
 
 import (
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
+	"strings"
 
+	"github.com/eniz1806/VaultS3/internal/metadata"
 	"github.com/eniz1806/VaultS3/internal/scanner"
 )
 
@@ -40,6 +45,227 @@ func (h *APIHandler) handleQuarantineList(w http.ResponseWriter, r *http.Request
 	json.NewEncoder(w).Encode(objects)
 }
 
+type scanBeforeServeRequest struct {
+	Bucket          string `json:"bucket"`
+	ScanBeforeServe bool   `json:"scan_before_serve"`
+}
+
+// routeScannerBucket handles the per-bucket scan-before-serve setting.
+func (h *APIHandler) routeScannerBucket(w http.ResponseWriter, r *http.Request, bucket string) {
+	info, err := h.store.GetBucket(bucket)
+	if err != nil || info == nil || strings.Contains(bucket, "/") {
+		writeError(w, http.StatusNotFound, "bucket not found")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, scanBeforeServeRequest{Bucket: bucket, ScanBeforeServe: info.ScanBeforeServe})
+	case http.MethodPut:
+		var req scanBeforeServeRequest
+		if err := readJSON(r, &req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+		if err := h.store.SetBucketScanBeforeServe(bucket, req.ScanBeforeServe); err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to update bucket")
+			return
+		}
+		writeJSON(w, http.StatusOK, scanBeforeServeRequest{Bucket: bucket, ScanBeforeServe: req.ScanBeforeServe})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// handleQuarantineRelease moves a quarantined object back to its original
+// location with its metadata.
+func (h *APIHandler) handleQuarantineRelease(w http.ResponseWriter, r *http.Request) {
+	if h.scanner == nil {
+		writeError(w, http.StatusServiceUnavailable, "scanner not enabled")
+		return
+	}
+	var req struct {
+		Key string `json:"key"`
+	}
+	if err := readJSON(r, &req); err != nil || req.Key == "" {
+		writeError(w, http.StatusBadRequest, "key is required")
+		return
+	}
+	meta, err := h.scanner.Release(req.Key)
+	switch {
+	case errors.Is(err, scanner.ErrNotQuarantined):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, scanner.ErrReleaseConflict):
+		writeError(w, http.StatusConflict, err.Error())
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	default:
+		writeJSON(w, http.StatusOK, meta)
+	}
+}
+
+// handleListRescans lists rescans, newest first, optionally filtered by
+// ?status=.
+func (h *APIHandler) handleListRescans(w http.ResponseWriter, r *http.Request) {
+	limit, ok := queryLimit(r)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "limit must be a positive integer")
+		return
+	}
+	rescans, err := h.store.ListRescans(r.URL.Query().Get("status"), limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if rescans == nil {
+		rescans = []metadata.Rescan{}
+	}
+	writeJSON(w, http.StatusOK, rescans)
+}
+
+// handleStartRescan starts a throttled rescan of a bucket or prefix. Its
+// progress is read from GET /scanner/rescans/{id}.
+func (h *APIHandler) handleStartRescan(w http.ResponseWriter, r *http.Request) {
+	if h.scanner == nil {
+		writeError(w, http.StatusServiceUnavailable, "scanner not enabled")
+		return
+	}
+	var req scanner.RescanRequest
+	if err := readJSON(r, &req); err != nil || req.Bucket == "" {
+		writeError(w, http.StatusBadRequest, "bucket is required")
+		return
+	}
+	if req.ObjectsPerSec < 0 {
+		writeError(w, http.StatusBadRequest, "objects_per_sec must not be negative")
+		return
+	}
+	if !h.store.BucketExists(req.Bucket) {
+		writeError(w, http.StatusNotFound, "bucket not found")
+		return
+	}
+	rescan, err := h.scanner.StartRescan(req)
+	switch {
+	case errors.Is(err, scanner.ErrRescanRunning):
+		writeError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, scanner.ErrScannerStopped):
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	default:
+		writeJSON(w, http.StatusAccepted, rescan)
+	}
+}
+
+// routeRescan handles /scanner/rescans/{id} and /scanner/rescans/{id}/cancel.
+func (h *APIHandler) routeRescan(w http.ResponseWriter, r *http.Request, rest string) {
+	idStr, action, _ := strings.Cut(rest, "/")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "rescan not found")
+		return
+	}
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		rescan, err := h.store.GetRescan(id)
+		if err != nil {
+			writeError(w, http.StatusNotFound, "rescan not found")
+			return
+		}
+		writeJSON(w, http.StatusOK, rescan)
+	case action == "cancel" && r.Method == http.MethodPost:
+		if h.scanner == nil {
+			writeError(w, http.StatusServiceUnavailable, "scanner not enabled")
+			return
+		}
+		if _, err := h.store.GetRescan(id); err != nil {
+			writeError(w, http.StatusNotFound, "rescan not found")
+			return
+		}
+		rescan, err := h.scanner.CancelRescan(id)
+		switch {
+		case errors.Is(err, scanner.ErrRescanNotRunning):
+			writeError(w, http.StatusConflict, err.Error())
+		case err != nil:
+			writeError(w, http.StatusInternalServerError, err.Error())
+		default:
+			writeJSON(w, http.StatusOK, rescan)
+		}
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (h *APIHandler) handleListBlocklist(w http.ResponseWriter, r *http.Request) {
+	entries, err := h.store.ListBlocklist()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if entries == nil {
+		entries = []metadata.BlocklistEntry{}
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// handleAddBlocklistEntry adds the SHA-256 of known-bad content. Objects
+// with that content are reported infected without calling the backend.
+func (h *APIHandler) handleAddBlocklistEntry(w http.ResponseWriter, r *http.Request) {
+	var entry metadata.BlocklistEntry
+	if err := readJSON(r, &entry); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	entry.SHA256 = strings.ToLower(entry.SHA256)
+	if !validSHA256(entry.SHA256) {
+		writeError(w, http.StatusBadRequest, "sha256 must be 64 hex characters")
+		return
+	}
+	if entry.Name == "" {
+		entry.Name = "Blocklist." + entry.SHA256[:12]
+	}
+	entry.AddedAt = 0
+	if err := h.store.PutBlocklistEntry(entry); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	added, _ := h.store.GetBlocklistEntry(entry.SHA256)
+	writeJSON(w, http.StatusCreated, added)
+}
+
+func (h *APIHandler) handleDeleteBlocklistEntry(w http.ResponseWriter, r *http.Request, sum string) {
+	sum = strings.ToLower(sum)
+	if _, err := h.store.GetBlocklistEntry(sum); err != nil {
+		writeError(w, http.StatusNotFound, "blocklist entry not found")
+		return
+	}
+	if err := h.store.DeleteBlocklistEntry(sum); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func validSHA256(s string) bool {
+	b, err := hex.DecodeString(s)
+	return err == nil && len(b) == 32
+}
+
+// handleScanHistory returns the scans of an object, newest first.
+func (h *APIHandler) handleScanHistory(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	if !h.store.BucketExists(bucket) {
+		writeError(w, http.StatusNotFound, "bucket not found")
+		return
+	}
+	history, err := h.store.GetScanHistory(bucket, key)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if history == nil {
+		history = []metadata.ScanRecord{}
+	}
+	writeJSON(w, http.StatusOK, history)
+}
+
 // SetScanner sets the scanner instance for API endpoints.
 func (h *APIHandler) SetScanner(s *scanner.Scanner) {
 	h.scanner = s
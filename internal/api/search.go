@@ -1,13 +1,17 @@
 package api
 
 import (
-	"encoding/json"
+	"errors"
+	"log/slog"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/eniz1806/VaultS3/internal/search"
 )
 
+// handleSearch runs a query of the search query language and returns a
+// page of results with the total number of matches.
 func (h *APIHandler) handleSearch(w http.ResponseWriter, r *http.Request) {
 	q := r.URL.Query().Get("q")
 	if q == "" {
@@ -31,11 +35,99 @@ func (h *APIHandler) handleSearch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	results := h.searchIndex.Search(q, bucket, limit)
-	if results == nil {
-		results = []search.Result{}
+	page, err := h.searchIndex.Query(search.Request{
+		Query:  q,
+		Bucket: bucket,
+		Limit:  limit,
+		Cursor: r.URL.Query().Get("cursor"),
+	})
+	var queryErr *search.QueryError
+	switch {
+	case errors.As(err, &queryErr) || errors.Is(err, search.ErrInvalidCursor):
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// handleSearchStatus reports the size of the search index and whether it is
+// being built.
+func (h *APIHandler) handleSearchStatus(w http.ResponseWriter, r *http.Request) {
+	if h.searchIndex == nil {
+		writeError(w, http.StatusServiceUnavailable, "search index not available")
+		return
+	}
+	status := map[string]interface{}{
+		"objects":  h.searchIndex.Count(),
+		"building": h.searchIndex.Building(),
+	}
+	if h.contentIndexer != nil {
+		status["content"] = h.contentIndexer.Stats()
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+type contentIndexRequest struct {
+	Bucket       string `json:"bucket"`
+	ContentIndex bool   `json:"content_index"`
+}
+
+// routeSearchBucket handles the per-bucket content indexing setting.
+// Enabling it indexes the text of the bucket's existing objects in the
+// background; disabling it removes their text from the index.
+func (h *APIHandler) routeSearchBucket(w http.ResponseWriter, r *http.Request, bucket string) {
+	info, err := h.store.GetBucket(bucket)
+	if err != nil || info == nil || strings.Contains(bucket, "/") {
+		writeError(w, http.StatusNotFound, "bucket not found")
+		return
 	}
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, contentIndexRequest{Bucket: bucket, ContentIndex: info.ContentIndex})
+	case http.MethodPut:
+		var req contentIndexRequest
+		if err := readJSON(r, &req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+		if err := h.store.SetBucketContentIndex(bucket, req.ContentIndex); err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to update bucket")
+			return
+		}
+		if req.ContentIndex {
+			if h.contentIndexer != nil {
+				h.contentIndexer.Backfill(bucket)
+			}
+		} else if h.searchIndex != nil {
+			if err := h.searchIndex.ClearContent(bucket); err != nil {
+				writeError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+		}
+		writeJSON(w, http.StatusOK, contentIndexRequest{Bucket: bucket, ContentIndex: req.ContentIndex})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(results)
+// handleSearchReindex rebuilds the search index from the metadata store in
+// the background, for objects written while the index was not updated.
+func (h *APIHandler) handleSearchReindex(w http.ResponseWriter, r *http.Request) {
+	if h.searchIndex == nil {
+		writeError(w, http.StatusServiceUnavailable, "search index not available")
+		return
+	}
+	if h.searchIndex.Building() {
+		writeError(w, http.StatusConflict, search.ErrBuilding.Error())
+		return
+	}
+	go func() {
+		if err := h.searchIndex.Build(); err != nil && !errors.Is(err, search.ErrBuilding) {
+			slog.Warn("search index rebuild failed", "error", err)
+		}
+	}()
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{"building": true})
 }
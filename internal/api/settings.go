@@ -41,8 +41,7 @@ type settingsResponse struct {
 		PerKeyBurst    int     `json:"perKeyBurst"`
 	} `json:"rateLimit,omitempty"`
 	Memory struct {
-		MaxSearchEntries int `json:"maxSearchEntries"`
-		GoMemLimitMB     int `json:"goMemLimitMb,omitempty"`
+		GoMemLimitMB int `json:"goMemLimitMb,omitempty"`
 	} `json:"memory"`
 }
 
@@ -80,7 +79,6 @@ func (h *APIHandler) handleSettings(w http.ResponseWriter, _ *http.Request) {
 		resp.RateLimit.PerKeyBurst = h.cfg.RateLimit.PerKeyBurst
 	}
 
-	resp.Memory.MaxSearchEntries = h.cfg.Memory.MaxSearchEntries
 	resp.Memory.GoMemLimitMB = h.cfg.Memory.GoMemLimitMB
 
 	writeJSON(w, http.StatusOK, resp)
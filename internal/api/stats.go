@@ -7,11 +7,12 @@ import (
 )
 
 type bucketStat struct {
-	Name         string `json:"name"`
-	Size         int64  `json:"size"`
-	ObjectCount  int64  `json:"objectCount"`
-	MaxSizeBytes int64  `json:"maxSizeBytes,omitempty"`
-	MaxObjects   int64  `json:"maxObjects,omitempty"`
+	Name           string `json:"name"`
+	Size           int64  `json:"size"`
+	ObjectCount    int64  `json:"objectCount"`
+	MultipartBytes int64  `json:"multipartBytes,omitempty"`
+	MaxSizeBytes   int64  `json:"maxSizeBytes,omitempty"`
+	MaxObjects     int64  `json:"maxObjects,omitempty"`
 }
 
 type requestMethodStat struct {
@@ -23,6 +24,7 @@ type statsResponse struct {
 	TotalBuckets     int                 `json:"totalBuckets"`
 	TotalObjects     int64               `json:"totalObjects"`
 	TotalSize        int64               `json:"totalSize"`
+	TotalMultipart   int64               `json:"totalMultipartBytes"`
 	UptimeSeconds    float64             `json:"uptimeSeconds"`
 	Goroutines       int                 `json:"goroutines"`
 	MemoryMB         float64             `json:"memoryMB"`
@@ -41,19 +43,22 @@ func (h *APIHandler) handleStats(w http.ResponseWriter, _ *http.Request) {
 		return
 	}
 
-	var totalSize, totalObjects int64
+	var totalSize, totalObjects, totalMultipart int64
 	bucketStats := make([]bucketStat, 0, len(buckets))
 
 	for _, b := range buckets {
 		size, count, _ := h.engine.BucketSize(b.Name)
+		multipart := h.store.MultipartUsage(b.Name)
 		totalSize += size
 		totalObjects += count
+		totalMultipart += multipart
 		bucketStats = append(bucketStats, bucketStat{
-			Name:         b.Name,
-			Size:         size,
-			ObjectCount:  count,
-			MaxSizeBytes: b.MaxSizeBytes,
-			MaxObjects:   b.MaxObjects,
+			Name:           b.Name,
+			Size:           size,
+			ObjectCount:    count,
+			MultipartBytes: multipart,
+			MaxSizeBytes:   b.MaxSizeBytes,
+			MaxObjects:     b.MaxObjects,
 		})
 	}
 
@@ -71,6 +76,7 @@ func (h *APIHandler) handleStats(w http.ResponseWriter, _ *http.Request) {
 		TotalBuckets:     len(buckets),
 		TotalObjects:     totalObjects,
 		TotalSize:        totalSize,
+		TotalMultipart:   totalMultipart,
 		UptimeSeconds:    time.Since(h.metrics.StartTime()).Seconds(),
 		Goroutines:       runtime.NumGoroutine(),
 		MemoryMB:         float64(mem.Alloc) / 1024 / 1024,
@@ -1,8 +1,10 @@
 package api
 
 import (
+	"errors"
 	"io"
 	"net/http"
+	"strconv"
 
 	"github.com/eniz1806/VaultS3/internal/versioning"
 )
@@ -66,8 +68,31 @@ func (h *APIHandler) handleVersionDiff(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	result, err := versioning.Diff(h.store, h.engine, bucket, key, v1, v2)
-	if err != nil {
+	opts := versioning.DiffOptions{
+		Mode:      r.URL.Query().Get("mode"),
+		KeyColumn: r.URL.Query().Get("column"),
+	}
+	if bs := r.URL.Query().Get("block_size"); bs != "" {
+		n, err := strconv.Atoi(bs)
+		if err != nil || n < 512 || n > 1<<20 {
+			writeError(w, http.StatusBadRequest, "block_size must be between 512 and 1048576")
+			return
+		}
+		opts.BlockSize = n
+	}
+
+	result, err := versioning.DiffWithOptions(h.store, h.engine, bucket, key, v1, v2, opts)
+	switch {
+	case errors.Is(err, versioning.ErrInvalidOption):
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	case errors.Is(err, versioning.ErrTooLarge):
+		writeError(w, http.StatusRequestEntityTooLarge, err.Error()+"; use mode=binary")
+		return
+	case errors.Is(err, versioning.ErrInvalidContent):
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	case err != nil:
 		writeError(w, http.StatusNotFound, err.Error())
 		return
 	}
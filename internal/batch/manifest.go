@@ -0,0 +1,154 @@
+package batch
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/url"
+
+	"github.com/eniz1806/VaultS3/internal/metadata"
+)
+
+// Manifest formats.
+const (
+	// ManifestCSV is a CSV object of bucket,key[,versionId] rows without a
+	// header. Keys are URL-encoded, as in S3 Batch Operations manifests.
+	ManifestCSV = "csv"
+	// ManifestInventory is a CSV inventory report with a header row.
+	ManifestInventory = "inventory"
+	// ManifestPrefix lists the objects of a bucket under a prefix.
+	ManifestPrefix = "prefix"
+)
+
+const listPageSize = 1000
+
+// entry is one object of a manifest.
+type entry struct {
+	bucket    string
+	key       string
+	versionID string
+}
+
+// eachEntry calls fn for the manifest entries of job after its checkpoint,
+// in manifest order, until fn returns false.
+func (p *Processor) eachEntry(job *metadata.BatchJob, fn func(entry) bool) error {
+	switch job.Manifest.Format {
+	case ManifestCSV, ManifestInventory:
+		return p.eachCSVEntry(job.Manifest, job.Processed, fn)
+	case ManifestPrefix:
+		return p.eachPrefixEntry(job.Manifest, job.LastKey, fn)
+	}
+	return fmt.Errorf("unknown manifest format: %s", job.Manifest.Format)
+}
+
+func (p *Processor) eachCSVEntry(m metadata.BatchManifest, skip int, fn func(entry) bool) error {
+	meta, err := p.store.GetObjectMeta(m.Bucket, m.Key)
+	if err != nil || meta.DeleteMarker {
+		return fmt.Errorf("manifest %s/%s not found", m.Bucket, m.Key)
+	}
+	reader, _, err := p.open(meta)
+	if err != nil {
+		return fmt.Errorf("read manifest %s/%s: %w", m.Bucket, m.Key, err)
+	}
+	defer reader.Close()
+
+	r := csv.NewReader(reader)
+	r.FieldsPerRecord = -1
+	cols := manifestColumns{bucket: 0, key: 1, versionID: 2, encoded: true}
+	if m.Format == ManifestInventory {
+		header, err := r.Read()
+		if err != nil {
+			return fmt.Errorf("read inventory header: %w", err)
+		}
+		if cols, err = inventoryColumns(header); err != nil {
+			return err
+		}
+	}
+
+	for row := 1; ; row++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("parse manifest: %w", err)
+		}
+		e, err := cols.entry(record)
+		if err != nil {
+			return fmt.Errorf("manifest row %d: %w", row, err)
+		}
+		if row <= skip {
+			continue
+		}
+		if !fn(e) {
+			return nil
+		}
+	}
+}
+
+func (p *Processor) eachPrefixEntry(m metadata.BatchManifest, startAfter string, fn func(entry) bool) error {
+	for {
+		objects, truncated, err := p.engine.ListObjects(m.Bucket, m.Prefix, startAfter, listPageSize)
+		if err != nil {
+			return fmt.Errorf("list %s/%s: %w", m.Bucket, m.Prefix, err)
+		}
+		for _, obj := range objects {
+			if !fn(entry{bucket: m.Bucket, key: obj.Key}) {
+				return nil
+			}
+			startAfter = obj.Key
+		}
+		if !truncated || len(objects) == 0 {
+			return nil
+		}
+	}
+}
+
+// manifestColumns locates the fields of an entry in a manifest row. A
+// negative versionID column means the manifest has no versions.
+type manifestColumns struct {
+	bucket    int
+	key       int
+	versionID int
+	encoded   bool
+}
+
+// inventoryColumns finds the entry fields in an inventory report header.
+func inventoryColumns(header []string) (manifestColumns, error) {
+	cols := manifestColumns{bucket: -1, key: -1, versionID: -1}
+	for i, name := range header {
+		switch name {
+		case "Bucket":
+			cols.bucket = i
+		case "Key":
+			cols.key = i
+		case "VersionID":
+			cols.versionID = i
+		}
+	}
+	if cols.bucket < 0 || cols.key < 0 {
+		return cols, fmt.Errorf("inventory report has no Bucket and Key columns")
+	}
+	return cols, nil
+}
+
+func (c manifestColumns) entry(record []string) (entry, error) {
+	if len(record) <= c.bucket || len(record) <= c.key {
+		return entry{}, fmt.Errorf("expected bucket and key fields")
+	}
+	e := entry{bucket: record[c.bucket], key: record[c.key]}
+	if c.versionID >= 0 && len(record) > c.versionID {
+		e.versionID = record[c.versionID]
+	}
+	if c.encoded {
+		key, err := url.QueryUnescape(e.key)
+		if err != nil {
+			return entry{}, fmt.Errorf("invalid URL-encoded key %q", e.key)
+		}
+		e.key = key
+	}
+	if e.bucket == "" || e.key == "" {
+		return entry{}, fmt.Errorf("empty bucket or key")
+	}
+	return e, nil
+}
@@ -0,0 +1,431 @@
+package batch
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/eniz1806/VaultS3/internal/metadata"
+	"github.com/eniz1806/VaultS3/internal/storage"
+)
+
+const maxObjectTags = 10
+
+// validate checks a job before it is queued.
+func (p *Processor) validate(job *metadata.BatchJob) error {
+	if job.Priority < 0 {
+		return fmt.Errorf("priority must not be negative")
+	}
+
+	m := job.Manifest
+	switch m.Format {
+	case ManifestCSV, ManifestInventory:
+		if m.Bucket == "" || m.Key == "" {
+			return fmt.Errorf("manifest bucket and key are required")
+		}
+		if meta, err := p.store.GetObjectMeta(m.Bucket, m.Key); err != nil || meta.DeleteMarker {
+			return fmt.Errorf("manifest %s/%s not found", m.Bucket, m.Key)
+		}
+	case ManifestPrefix:
+		if !p.store.BucketExists(m.Bucket) {
+			return fmt.Errorf("bucket %s not found", m.Bucket)
+		}
+	default:
+		return fmt.Errorf("manifest format must be csv, inventory or prefix")
+	}
+
+	params := &job.Params
+	switch job.Operation {
+	case JobBulkDelete, JobReplaceMetadata, JobReEncrypt:
+	case JobBulkCopy:
+		if !p.store.BucketExists(params.DstBucket) {
+			return fmt.Errorf("destination bucket %q not found", params.DstBucket)
+		}
+	case JobPutTagging:
+		if len(params.Tags) > maxObjectTags {
+			return fmt.Errorf("at most %d tags are allowed", maxObjectTags)
+		}
+	case JobPutRetention:
+		if params.RetentionMode != "GOVERNANCE" && params.RetentionMode != "COMPLIANCE" {
+			return fmt.Errorf("retention mode must be GOVERNANCE or COMPLIANCE")
+		}
+		if params.RetainUntil <= time.Now().UTC().Unix() {
+			return fmt.Errorf("retain until date must be in the future")
+		}
+	case JobPutLegalHold:
+		if params.LegalHold != "ON" && params.LegalHold != "OFF" {
+			return fmt.Errorf("legal hold must be ON or OFF")
+		}
+	case JobRestore:
+		if p.onRestore == nil {
+			return fmt.Errorf("restore requires tiering to be enabled")
+		}
+		if params.RestoreDays < 1 {
+			return fmt.Errorf("restore days must be at least 1")
+		}
+		switch params.RestoreTier {
+		case "":
+			params.RestoreTier = "Standard"
+		case "Expedited", "Standard", "Bulk":
+		default:
+			return fmt.Errorf("restore tier must be Expedited, Standard or Bulk")
+		}
+	case JobChangeStorageClass:
+		if p.tierMover == nil {
+			return fmt.Errorf("change-storage-class requires tiering to be enabled")
+		}
+		if params.StorageClass == "" {
+			return fmt.Errorf("storage class is required")
+		}
+	case JobInvokeLambda:
+		if p.onInvoke == nil {
+			return fmt.Errorf("invoke-lambda requires lambda triggers to be enabled")
+		}
+		if params.TriggerID == "" {
+			return fmt.Errorf("trigger ID is required")
+		}
+	default:
+		return fmt.Errorf("unknown operation: %s", job.Operation)
+	}
+
+	if job.Report.Enabled {
+		if !p.store.BucketExists(job.Report.Bucket) {
+			return fmt.Errorf("report bucket %q not found", job.Report.Bucket)
+		}
+		switch job.Report.Scope {
+		case "":
+			job.Report.Scope = ReportAllTasks
+		case ReportAllTasks, ReportFailedTasksOnly:
+		default:
+			return fmt.Errorf("report scope must be AllTasks or FailedTasksOnly")
+		}
+	}
+	return nil
+}
+
+// apply runs the job's operation on one manifest entry.
+func (p *Processor) apply(job *metadata.BatchJob, e entry) error {
+	if job.Operation == JobRestore {
+		_, err := p.onRestore(e.bucket, e.key, e.versionID, job.Params.RestoreTier, job.Params.RestoreDays)
+		return err
+	}
+
+	meta, err := p.objectMeta(e)
+	if err != nil {
+		return err
+	}
+	params := job.Params
+	switch job.Operation {
+	case JobBulkDelete:
+		return p.deleteObject(meta, e.versionID != "")
+	case JobBulkCopy:
+		return p.copyObject(meta, params.DstBucket, params.DstPrefix+meta.Key)
+	case JobPutTagging:
+		meta.Tags = make(map[string]string, len(params.Tags))
+		for k, v := range params.Tags {
+			meta.Tags[k] = v
+		}
+		return p.saveMeta(meta, "s3:ObjectTagging:Put")
+	case JobReplaceMetadata:
+		if params.ContentType != "" {
+			meta.ContentType = params.ContentType
+		}
+		meta.ContentEncoding = params.ContentEncoding
+		meta.ContentDisposition = params.ContentDisposition
+		meta.CacheControl = params.CacheControl
+		meta.ContentLanguage = params.ContentLanguage
+		meta.UserMetadata = make(map[string]string, len(params.UserMetadata))
+		for k, v := range params.UserMetadata {
+			meta.UserMetadata[k] = v
+		}
+		return p.saveMeta(meta, "s3:ObjectCreated:Copy")
+	case JobPutRetention:
+		// An active COMPLIANCE retention cannot be shortened
+		if meta.RetentionMode == "COMPLIANCE" && time.Now().UTC().Unix() < meta.RetentionUntil &&
+			params.RetainUntil < meta.RetentionUntil {
+			return fmt.Errorf("cannot shorten COMPLIANCE retention period")
+		}
+		meta.RetentionMode = params.RetentionMode
+		meta.RetentionUntil = params.RetainUntil
+		return p.saveMeta(meta, "s3:ObjectRetention:Put")
+	case JobPutLegalHold:
+		meta.LegalHold = params.LegalHold == "ON"
+		return p.saveMeta(meta, "s3:ObjectLegalHold:Put")
+	case JobReEncrypt:
+		return p.reEncrypt(meta)
+	case JobChangeStorageClass:
+		return p.tierMover.Transition(*meta, params.StorageClass)
+	case JobInvokeLambda:
+		return p.onInvoke(meta.Bucket, params.TriggerID, meta.Key, meta.Size, meta.ETag, meta.VersionID)
+	}
+	return fmt.Errorf("unknown operation: %s", job.Operation)
+}
+
+// objectMeta looks up the object or object version of an entry.
+func (p *Processor) objectMeta(e entry) (*metadata.ObjectMeta, error) {
+	var meta *metadata.ObjectMeta
+	var err error
+	if e.versionID != "" {
+		meta, err = p.store.GetObjectVersion(e.bucket, e.key, e.versionID)
+	} else {
+		meta, err = p.store.GetObjectMeta(e.bucket, e.key)
+	}
+	if err != nil || meta.DeleteMarker {
+		return nil, fmt.Errorf("object not found")
+	}
+	return meta, nil
+}
+
+// saveMeta stores changed object metadata and reports it as eventType.
+func (p *Processor) saveMeta(meta *metadata.ObjectMeta, eventType string) error {
+	var err error
+	if meta.VersionID != "" {
+		err = p.store.UpdateObjectVersionMeta(*meta)
+	} else {
+		err = p.store.PutObjectMeta(*meta)
+	}
+	if err != nil {
+		return err
+	}
+	p.emit(eventType, *meta)
+	return nil
+}
+
+func (p *Processor) emit(eventType string, meta metadata.ObjectMeta) {
+	if p.onEvent != nil {
+		p.onEvent(eventType, meta.Bucket, meta.Key, meta.Size, meta.ETag, meta.VersionID)
+	}
+}
+
+// initialScanStatus returns the scan status of an object a job writes to
+// bucket, like the S3 API does for uploads.
+func (p *Processor) initialScanStatus(bucket string) string {
+	if p.onScan == nil {
+		return ""
+	}
+	info, err := p.store.GetBucket(bucket)
+	if err != nil || !info.ScanBeforeServe {
+		return ""
+	}
+	return metadata.ScanPending
+}
+
+// open reads the data of an object on the hot tier.
+func (p *Processor) open(meta *metadata.ObjectMeta) (storage.ReadSeekCloser, int64, error) {
+	if meta.Tier != "" && meta.Tier != "hot" {
+		return nil, 0, fmt.Errorf("object is archived in the %s tier", meta.Tier)
+	}
+	if meta.VersionID != "" {
+		return p.engine.GetObjectVersion(meta.Bucket, meta.Key, meta.VersionID)
+	}
+	return p.engine.GetObject(meta.Bucket, meta.Key)
+}
+
+// removeData deletes the data of an object, or of one of its versions when
+// versionID is set. Data moved out of the hot tier is removed through the tier
+// mover, which also drops restored copies and pending restores.
+func (p *Processor) removeData(meta *metadata.ObjectMeta, versionID string) error {
+	if meta.Tier != "" && meta.Tier != "hot" && p.tierMover != nil {
+		return p.tierMover.RemoveObject(*meta)
+	}
+	if versionID != "" {
+		return p.engine.DeleteObjectVersion(meta.Bucket, meta.Key, versionID)
+	}
+	return p.engine.DeleteObject(meta.Bucket, meta.Key)
+}
+
+func locked(meta *metadata.ObjectMeta) bool {
+	return meta.LegalHold || (meta.RetentionMode != "" && meta.RetentionUntil > time.Now().UTC().Unix())
+}
+
+// deleteObject deletes a specific version permanently, or the current
+// object the way a DELETE request without a version ID does.
+func (p *Processor) deleteObject(meta *metadata.ObjectMeta, version bool) error {
+	bucket, key := meta.Bucket, meta.Key
+	if version {
+		if locked(meta) {
+			return fmt.Errorf("object is locked")
+		}
+		if err := p.removeData(meta, meta.VersionID); err != nil {
+			return err
+		}
+		if _, err := p.store.RemoveObjectVersion(bucket, key, meta.VersionID, ""); err != nil {
+			return err
+		}
+		p.emit("s3:ObjectRemoved:Delete", *meta)
+		return nil
+	}
+
+	switch versioning, _ := p.store.GetBucketVersioning(bucket); versioning {
+	case "Enabled", "Suspended":
+		versionID := "null"
+		if versioning == "Enabled" {
+			versionID = newVersionID()
+		} else {
+			if null, err := p.store.GetObjectVersion(bucket, key, "null"); err == nil {
+				p.removeData(null, "null")
+			}
+			p.store.DeleteObjectVersion(bucket, key, "null")
+		}
+		if meta.VersionID != "" && meta.VersionID != versionID {
+			meta.IsLatest = false
+			if err := p.store.PutObjectVersion(*meta); err != nil {
+				return err
+			}
+		}
+		dm := metadata.ObjectMeta{
+			Bucket:       bucket,
+			Key:          key,
+			VersionID:    versionID,
+			IsLatest:     true,
+			DeleteMarker: true,
+			LastModified: time.Now().UTC().Unix(),
+		}
+		if err := p.store.PutObjectVersion(dm); err != nil {
+			return err
+		}
+		if err := p.store.PutObjectMeta(dm); err != nil {
+			return err
+		}
+		p.emit("s3:ObjectRemoved:DeleteMarkerCreated", dm)
+		return nil
+	}
+
+	if locked(meta) {
+		return fmt.Errorf("object is locked")
+	}
+	if err := p.removeData(meta, ""); err != nil {
+		return err
+	}
+	if err := p.store.DeleteObjectMeta(bucket, key); err != nil {
+		return err
+	}
+	p.emit("s3:ObjectRemoved:Delete", *meta)
+	return nil
+}
+
+// copyObject copies an object with its metadata and tags to dstBucket.
+func (p *Processor) copyObject(meta *metadata.ObjectMeta, dstBucket, dstKey string) error {
+	// Like GET and CopyObject, do not hand out data the scanner has not passed
+	if metadata.ScanHeld(meta.ScanStatus) {
+		return fmt.Errorf("object has not passed a virus scan")
+	}
+	reader, size, err := p.open(meta)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	dst := metadata.ObjectMeta{
+		Bucket:             dstBucket,
+		Key:                dstKey,
+		ContentType:        meta.ContentType,
+		Tags:               meta.Tags,
+		UserMetadata:       meta.UserMetadata,
+		ContentEncoding:    meta.ContentEncoding,
+		ContentDisposition: meta.ContentDisposition,
+		CacheControl:       meta.CacheControl,
+		ContentLanguage:    meta.ContentLanguage,
+	}
+	return p.putObject(dst, reader, size)
+}
+
+// putObject stores a new object the way a PUT request does: within the
+// bucket's quota, as a new version or the "null" version of versioned
+// buckets, and queued for a virus scan.
+func (p *Processor) putObject(meta metadata.ObjectMeta, r io.Reader, size int64) error {
+	if p.onQuota != nil {
+		if err := p.onQuota(meta.Bucket, size); err != nil {
+			return err
+		}
+	}
+	now := time.Now().UTC().Unix()
+	meta.LastModified = now
+	meta.ScanStatus = p.initialScanStatus(meta.Bucket)
+
+	var err error
+	switch versioning, _ := p.store.GetBucketVersioning(meta.Bucket); versioning {
+	case "Enabled", "Suspended":
+		meta.VersionID = "null"
+		if versioning == "Enabled" {
+			meta.VersionID = newVersionID()
+			if info, err := p.store.GetBucket(meta.Bucket); err == nil &&
+				info.DefaultRetentionMode != "" && info.DefaultRetentionDays > 0 {
+				meta.RetentionMode = info.DefaultRetentionMode
+				meta.RetentionUntil = now + int64(info.DefaultRetentionDays*86400)
+			}
+		}
+		meta.IsLatest = true
+		meta.Size, meta.ETag, err = p.engine.PutObjectVersion(meta.Bucket, meta.Key, meta.VersionID, r, size)
+		if err != nil {
+			return err
+		}
+		if old, err := p.store.GetObjectMeta(meta.Bucket, meta.Key); err == nil &&
+			old.VersionID != "" && old.VersionID != meta.VersionID {
+			old.IsLatest = false
+			p.store.PutObjectVersion(*old)
+		}
+		err = p.store.PutObjectVersion(meta)
+	default:
+		meta.Size, meta.ETag, err = p.engine.PutObject(meta.Bucket, meta.Key, r, size)
+	}
+	if err != nil {
+		return err
+	}
+	if err := p.store.PutObjectMeta(meta); err != nil {
+		return err
+	}
+	p.emit("s3:ObjectCreated:Copy", meta)
+	if p.onScan != nil {
+		p.onScan(meta.Bucket, meta.Key, meta.Size)
+	}
+	return nil
+}
+
+// reEncrypt rewrites an object's data in place so that the storage engine
+// encrypts it with the current key. The data is staged in a temporary file
+// rather than memory, because engines may truncate a version's file before
+// writing it again.
+func (p *Processor) reEncrypt(meta *metadata.ObjectMeta) error {
+	reader, _, err := p.open(meta)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp("", "vaults3-reencrypt-*")
+	if err != nil {
+		reader.Close()
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	size, err := io.Copy(tmp, reader)
+	reader.Close()
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	if meta.VersionID != "" {
+		meta.Size, meta.ETag, err = p.engine.PutObjectVersion(meta.Bucket, meta.Key, meta.VersionID, tmp, size)
+	} else {
+		meta.Size, meta.ETag, err = p.engine.PutObject(meta.Bucket, meta.Key, tmp, size)
+	}
+	if err != nil {
+		return err
+	}
+	return p.saveMeta(meta, "s3:ObjectCreated:Copy")
+}
+
+// newVersionID generates a version ID in the same format as the S3 API.
+func newVersionID() string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		panic("crypto/rand failed: " + err.Error())
+	}
+	return fmt.Sprintf("%016x%s", time.Now().UnixNano(), hex.EncodeToString(b))
+}
@@ -1,8 +1,12 @@
 package batch
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"log/slog"
+	"sort"
 	"sync"
 	"time"
 
@@ -10,34 +14,76 @@ import (
 	"github.com/eniz1806/VaultS3/internal/storage"
 )
 
-// JobType defines the type of batch operation.
-type JobType string
+// Job operations.
+const (
+	JobBulkDelete         = "bulk-delete"
+	JobBulkCopy           = "bulk-copy"
+	JobPutTagging         = "put-tagging"
+	JobReplaceMetadata    = "replace-metadata"
+	JobPutRetention       = "put-retention"
+	JobPutLegalHold       = "put-legal-hold"
+	JobRestore            = "restore"
+	JobReEncrypt          = "re-encrypt"
+	JobChangeStorageClass = "change-storage-class"
+	JobInvokeLambda       = "invoke-lambda"
+)
+
+// Job statuses.
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusPaused    = "paused"
+	StatusCancelled = "cancelled"
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+)
 
 const (
-	JobBulkDelete JobType = "bulk-delete"
-	JobBulkCopy   JobType = "bulk-copy"
+	// checkpointEvery is how many manifest entries are processed between
+	// checkpoints. After a crash at most this many entries are repeated.
+	checkpointEvery = 100
+	pollInterval    = 10 * time.Second
 )
 
-// Job represents a batch operation job.
-type Job struct {
-	ID        string    `json:"id"`
-	Type      JobType   `json:"type"`
-	Bucket    string    `json:"bucket"`
-	Prefix    string    `json:"prefix,omitempty"`
-	DstBucket string    `json:"dst_bucket,omitempty"`
-	Status    string    `json:"status"` // "queued", "running", "completed", "failed"
-	Progress  int       `json:"progress"`
-	Total     int       `json:"total"`
-	Error     string    `json:"error,omitempty"`
-	CreatedAt time.Time `json:"created_at"`
-}
-
-// Processor handles batch operations.
+// RestoreFunc queues a restore of an archived object.
+type RestoreFunc func(bucket, key, versionID, tier string, days int) (queued bool, err error)
+
+// InvokeFunc synchronously calls a bucket's lambda trigger for one object.
+type InvokeFunc func(bucket, triggerID, key string, size int64, etag, versionID string) error
+
+// EventFunc is called for each object a job creates, changes or deletes.
+type EventFunc func(eventType, bucket, key string, size int64, etag, versionID string)
+
+// QuotaFunc checks that a new object of size bytes fits a bucket's quota.
+type QuotaFunc func(bucket string, size int64) error
+
+// ScanFunc queues an object a job wrote for a virus scan.
+type ScanFunc func(bucket, key string, size int64)
+
+// TierMover moves objects between storage classes and removes the data of
+// objects that are no longer on the hot tier.
+type TierMover interface {
+	Transition(meta metadata.ObjectMeta, storageClass string) error
+	RemoveObject(meta metadata.ObjectMeta) error
+}
+
+// Processor runs batch jobs persisted in the metadata store, one at a time
+// in priority order. Jobs checkpoint their progress so that a restart
+// resumes them where they left off.
 type Processor struct {
-	store  *metadata.Store
-	engine storage.Engine
-	mu     sync.RWMutex
-	jobs   map[string]*Job
+	store     *metadata.Store
+	engine    storage.Engine
+	onRestore RestoreFunc
+	onInvoke  InvokeFunc
+	tierMover TierMover
+	onEvent   EventFunc
+	onQuota   QuotaFunc
+	onScan    ScanFunc
+	wake      chan struct{}
+
+	mu      sync.Mutex
+	current *metadata.BatchJob // job being run, nil when idle
+	signal  string             // status requested for the current job
 }
 
 // NewProcessor creates a new batch processor.
@@ -45,115 +91,392 @@ func NewProcessor(store *metadata.Store, engine storage.Engine) *Processor {
 	return &Processor{
 		store:  store,
 		engine: engine,
-		jobs:   make(map[string]*Job),
+		wake:   make(chan struct{}, 1),
 	}
 }
 
-// Submit submits a new batch job. Returns the job ID.
-func (p *Processor) Submit(job *Job) string {
-	job.Status = "queued"
-	job.CreatedAt = time.Now().UTC()
-	p.mu.Lock()
-	p.jobs[job.ID] = job
-	p.mu.Unlock()
+// SetRestoreFunc enables restore jobs.
+func (p *Processor) SetRestoreFunc(fn RestoreFunc) {
+	p.onRestore = fn
+}
+
+// SetInvokeFunc enables invoke-lambda jobs.
+func (p *Processor) SetInvokeFunc(fn InvokeFunc) {
+	p.onInvoke = fn
+}
+
+// SetTierMover enables change-storage-class jobs.
+func (p *Processor) SetTierMover(mover TierMover) {
+	p.tierMover = mover
+}
+
+// SetEventFunc sets the callback for the S3 events of the objects jobs
+// create, change or delete.
+func (p *Processor) SetEventFunc(fn EventFunc) {
+	p.onEvent = fn
+}
 
-	go p.execute(job)
-	return job.ID
+// SetQuotaFunc sets the bucket quota check of objects that jobs create.
+func (p *Processor) SetQuotaFunc(fn QuotaFunc) {
+	p.onQuota = fn
 }
 
-// GetJob returns a job by ID.
-func (p *Processor) GetJob(id string) *Job {
-	p.mu.RLock()
-	defer p.mu.RUnlock()
-	return p.jobs[id]
+// SetScanFunc sets the callback that queues objects jobs write for a virus
+// scan. Objects written to buckets that scan before serving stay pending
+// until the scan passes.
+func (p *Processor) SetScanFunc(fn ScanFunc) {
+	p.onScan = fn
 }
 
-// ListJobs returns all jobs.
-func (p *Processor) ListJobs() []*Job {
-	p.mu.RLock()
-	defer p.mu.RUnlock()
-	jobs := make([]*Job, 0, len(p.jobs))
-	for _, j := range p.jobs {
-		jobs = append(jobs, j)
+// Submit validates and queues a new job, returning it with its ID set.
+func (p *Processor) Submit(job metadata.BatchJob) (*metadata.BatchJob, error) {
+	if err := p.validate(&job); err != nil {
+		return nil, err
+	}
+	id, err := newJobID()
+	if err != nil {
+		return nil, err
+	}
+	job = metadata.BatchJob{
+		ID:        id,
+		Operation: job.Operation,
+		Priority:  job.Priority,
+		Status:    StatusQueued,
+		Manifest:  job.Manifest,
+		Params:    job.Params,
+		Report:    job.Report,
+		CreatedAt: time.Now().UTC().Unix(),
+	}
+	if err := p.store.PutBatchJob(job); err != nil {
+		return nil, err
 	}
-	return jobs
+	p.notify()
+	return &job, nil
 }
 
-func (p *Processor) execute(job *Job) {
+// GetJob returns a job by ID, with live progress if it is running.
+func (p *Processor) GetJob(id string) (*metadata.BatchJob, error) {
 	p.mu.Lock()
-	job.Status = "running"
-	p.mu.Unlock()
-
-	var err error
-	switch job.Type {
-	case JobBulkDelete:
-		err = p.executeBulkDelete(job)
-	case JobBulkCopy:
-		err = p.executeBulkCopy(job)
-	default:
-		err = fmt.Errorf("unknown job type: %s", job.Type)
+	defer p.mu.Unlock()
+	if p.current != nil && p.current.ID == id {
+		job := *p.current
+		return &job, nil
 	}
+	return p.store.GetBatchJob(id)
+}
 
+// ListJobs returns all jobs, newest first.
+func (p *Processor) ListJobs() ([]metadata.BatchJob, error) {
 	p.mu.Lock()
+	defer p.mu.Unlock()
+	jobs, err := p.store.ListBatchJobs()
 	if err != nil {
-		job.Status = "failed"
-		job.Error = err.Error()
-	} else {
-		job.Status = "completed"
+		return nil, err
 	}
-	p.mu.Unlock()
+	for i := range jobs {
+		if p.current != nil && p.current.ID == jobs[i].ID {
+			jobs[i] = *p.current
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		if jobs[i].CreatedAt != jobs[j].CreatedAt {
+			return jobs[i].CreatedAt > jobs[j].CreatedAt
+		}
+		return jobs[i].ID < jobs[j].ID
+	})
+	return jobs, nil
 }
 
-func (p *Processor) executeBulkDelete(job *Job) error {
-	objects, _, err := p.engine.ListObjects(job.Bucket, job.Prefix, "", 10000)
+// Pause stops a queued or running job. A running job stops after its
+// current entry and keeps its checkpoint.
+func (p *Processor) Pause(id string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.current != nil && p.current.ID == id {
+		if p.signal == "" {
+			p.signal = StatusPaused
+		}
+		return nil
+	}
+	job, err := p.store.GetBatchJob(id)
 	if err != nil {
 		return err
 	}
+	if job.Status != StatusQueued {
+		return fmt.Errorf("cannot pause a %s job", job.Status)
+	}
+	job.Status = StatusPaused
+	return p.store.PutBatchJob(*job)
+}
 
+// Resume queues a paused job again.
+func (p *Processor) Resume(id string) error {
 	p.mu.Lock()
-	job.Total = len(objects)
-	p.mu.Unlock()
-
-	for i, obj := range objects {
-		if err := p.engine.DeleteObject(job.Bucket, obj.Key); err != nil {
-			slog.Error("batch delete error", "key", obj.Key, "error", err)
-			continue
-		}
-		p.store.DeleteObjectMeta(job.Bucket, obj.Key)
-
-		p.mu.Lock()
-		job.Progress = i + 1
-		p.mu.Unlock()
+	defer p.mu.Unlock()
+	if p.current != nil && p.current.ID == id {
+		return fmt.Errorf("cannot resume a %s job", StatusRunning)
+	}
+	job, err := p.store.GetBatchJob(id)
+	if err != nil {
+		return err
+	}
+	if job.Status != StatusPaused {
+		return fmt.Errorf("cannot resume a %s job", job.Status)
 	}
+	job.Status = StatusQueued
+	if err := p.store.PutBatchJob(*job); err != nil {
+		return err
+	}
+	p.notify()
 	return nil
 }
 
-func (p *Processor) executeBulkCopy(job *Job) error {
-	objects, _, err := p.engine.ListObjects(job.Bucket, job.Prefix, "", 10000)
+// Cancel ends a job for good. Its completion report covers the entries
+// processed so far.
+func (p *Processor) Cancel(id string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.current != nil && p.current.ID == id {
+		p.signal = StatusCancelled
+		return nil
+	}
+	job, err := p.store.GetBatchJob(id)
 	if err != nil {
 		return err
 	}
+	if job.Status != StatusQueued && job.Status != StatusPaused {
+		return fmt.Errorf("cannot cancel a %s job", job.Status)
+	}
+	job.Status = StatusCancelled
+	job.FinishedAt = time.Now().UTC().Unix()
+	p.finishReport(job)
+	return p.store.PutBatchJob(*job)
+}
 
+// SetPriority changes the priority of a job. Among queued jobs the highest
+// priority runs first.
+func (p *Processor) SetPriority(id string, priority int) error {
+	if priority < 0 {
+		return fmt.Errorf("priority must not be negative")
+	}
 	p.mu.Lock()
-	job.Total = len(objects)
-	p.mu.Unlock()
+	defer p.mu.Unlock()
+	if p.current != nil && p.current.ID == id {
+		p.current.Priority = priority
+		return p.store.PutBatchJob(*p.current)
+	}
+	job, err := p.store.GetBatchJob(id)
+	if err != nil {
+		return err
+	}
+	job.Priority = priority
+	return p.store.PutBatchJob(*job)
+}
 
-	for i, obj := range objects {
-		reader, size, err := p.engine.GetObject(job.Bucket, obj.Key)
-		if err != nil {
-			slog.Error("batch copy read error", "key", obj.Key, "error", err)
+// DeleteJob removes a finished job.
+func (p *Processor) DeleteJob(id string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	job, err := p.store.GetBatchJob(id)
+	if err != nil {
+		return err
+	}
+	switch job.Status {
+	case StatusCompleted, StatusFailed, StatusCancelled:
+		return p.store.DeleteBatchJob(id)
+	}
+	return fmt.Errorf("cannot delete a %s job", job.Status)
+}
+
+// Run processes queued jobs until ctx is cancelled.
+func (p *Processor) Run(ctx context.Context) {
+	p.requeueInterrupted()
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		for ctx.Err() == nil && p.runNext(ctx) {
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Processor) notify() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// requeueInterrupted queues jobs that were running when the server stopped,
+// so that they resume from their last checkpoint.
+func (p *Processor) requeueInterrupted() {
+	jobs, err := p.store.ListBatchJobs()
+	if err != nil {
+		slog.Error("batch error listing jobs", "error", err)
+		return
+	}
+	for _, job := range jobs {
+		if job.Status != StatusRunning {
 			continue
 		}
-		_, _, err = p.engine.PutObject(job.DstBucket, obj.Key, reader, size)
-		reader.Close()
-		if err != nil {
-			slog.Error("batch copy write error", "key", obj.Key, "error", err)
+		job.Status = StatusQueued
+		if err := p.store.PutBatchJob(job); err != nil {
+			slog.Error("batch error requeueing job", "id", job.ID, "error", err)
 			continue
 		}
+		slog.Info("batch job resuming from checkpoint", "id", job.ID, "processed", job.Processed)
+	}
+}
+
+// runNext runs the queued job with the highest priority, oldest first.
+// It reports whether a job was run.
+func (p *Processor) runNext(ctx context.Context) bool {
+	p.mu.Lock()
+	jobs, err := p.store.ListBatchJobs()
+	if err != nil {
+		p.mu.Unlock()
+		slog.Error("batch error listing jobs", "error", err)
+		return false
+	}
+	var next *metadata.BatchJob
+	for i := range jobs {
+		job := &jobs[i]
+		if job.Status != StatusQueued {
+			continue
+		}
+		if next == nil || job.Priority > next.Priority ||
+			(job.Priority == next.Priority && (job.CreatedAt < next.CreatedAt ||
+				(job.CreatedAt == next.CreatedAt && job.ID < next.ID))) {
+			next = job
+		}
+	}
+	if next == nil {
+		p.mu.Unlock()
+		return false
+	}
+	next.Status = StatusRunning
+	if next.StartedAt == 0 {
+		next.StartedAt = time.Now().UTC().Unix()
+	}
+	if err := p.store.PutBatchJob(*next); err != nil {
+		p.mu.Unlock()
+		slog.Error("batch error starting job", "id", next.ID, "error", err)
+		return false
+	}
+	p.current = next
+	p.signal = ""
+	p.mu.Unlock()
+
+	p.execute(ctx, next)
+
+	p.mu.Lock()
+	p.current = nil
+	p.signal = ""
+	p.mu.Unlock()
+	return true
+}
+
+func (p *Processor) execute(ctx context.Context, job *metadata.BatchJob) {
+	slog.Info("batch job started", "id", job.ID, "operation", job.Operation, "processed", job.Processed)
+
+	var results []metadata.BatchTaskResult
+	stop := ""
+	err := p.eachEntry(job, func(e entry) bool {
+		taskErr := p.apply(job, e)
 
 		p.mu.Lock()
-		job.Progress = i + 1
+		job.Processed++
+		if job.Manifest.Format == ManifestPrefix {
+			job.LastKey = e.key
+		}
+		if taskErr == nil {
+			job.Succeeded++
+		} else {
+			job.Failed++
+		}
+		stop = p.signal
 		p.mu.Unlock()
+
+		if r, ok := taskResult(job, e, taskErr); ok {
+			results = append(results, r)
+		}
+		if job.Processed%checkpointEvery == 0 {
+			p.checkpoint(job, results)
+			results = nil
+		}
+		return stop == "" && ctx.Err() == nil
+	})
+	if err == nil && stop == "" && ctx.Err() != nil {
+		// Shutting down: leave the job running so it is requeued on start
+		p.checkpoint(job, results)
+		slog.Info("batch job interrupted", "id", job.ID, "processed", job.Processed)
+		return
 	}
-	return nil
+	p.checkpoint(job, results)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch {
+	case err != nil:
+		job.Status = StatusFailed
+		job.Error = err.Error()
+	case stop != "":
+		job.Status = stop
+	default:
+		job.Status = StatusCompleted
+		// The whole manifest has been walked
+		job.Total = job.Processed
+	}
+	if job.Status != StatusPaused {
+		job.FinishedAt = time.Now().UTC().Unix()
+		p.finishReport(job)
+	}
+	if err := p.store.PutBatchJob(*job); err != nil {
+		slog.Error("batch error saving job", "id", job.ID, "error", err)
+	}
+	slog.Info("batch job stopped", "id", job.ID, "status", job.Status,
+		"succeeded", job.Succeeded, "failed", job.Failed)
+}
+
+// checkpoint saves the job's progress along with its pending task results.
+func (p *Processor) checkpoint(job *metadata.BatchJob, results []metadata.BatchTaskResult) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.store.CheckpointBatchJob(*job, results); err != nil {
+		slog.Error("batch error saving checkpoint", "id", job.ID, "error", err)
+	}
+}
+
+// taskResult returns the report record of an entry, if the job's report
+// includes it.
+func taskResult(job *metadata.BatchJob, e entry, err error) (metadata.BatchTaskResult, bool) {
+	if !job.Report.Enabled || (err == nil && job.Report.Scope == ReportFailedTasksOnly) {
+		return metadata.BatchTaskResult{}, false
+	}
+	r := metadata.BatchTaskResult{
+		Seq:       job.Processed,
+		Bucket:    e.bucket,
+		Key:       e.key,
+		VersionID: e.versionID,
+		Status:    "succeeded",
+	}
+	if err != nil {
+		r.Status = "failed"
+		r.Error = err.Error()
+	}
+	return r, true
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
 }
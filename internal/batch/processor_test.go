@@ -0,0 +1,354 @@
+package batch
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eniz1806/VaultS3/internal/metadata"
+	"github.com/eniz1806/VaultS3/internal/storage"
+)
+
+func newTestProcessor(t *testing.T, buckets ...string) (*Processor, *metadata.Store, storage.Engine) {
+	t.Helper()
+	dir := t.TempDir()
+	store, err := metadata.NewStore(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	fs, err := storage.NewFileSystem(filepath.Join(dir, "data"))
+	if err != nil {
+		t.Fatalf("NewFileSystem: %v", err)
+	}
+	for _, b := range buckets {
+		if err := store.CreateBucket(b); err != nil {
+			t.Fatalf("CreateBucket: %v", err)
+		}
+		if err := fs.CreateBucketDir(b); err != nil {
+			t.Fatalf("CreateBucketDir: %v", err)
+		}
+	}
+	return NewProcessor(store, fs), store, fs
+}
+
+func putTestObject(t *testing.T, store *metadata.Store, engine storage.Engine, bucket, key, body string) {
+	t.Helper()
+	written, etag, err := engine.PutObject(bucket, key, strings.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatalf("PutObject: %v", err)
+	}
+	store.PutObjectMeta(metadata.ObjectMeta{Bucket: bucket, Key: key, Size: written, ETag: etag, LastModified: time.Now().Unix()})
+}
+
+func readTestObject(t *testing.T, engine storage.Engine, bucket, key string) string {
+	t.Helper()
+	r, _, err := engine.GetObject(bucket, key)
+	if err != nil {
+		t.Fatalf("GetObject %s/%s: %v", bucket, key, err)
+	}
+	defer r.Close()
+	data, _ := io.ReadAll(r)
+	return string(data)
+}
+
+// runAll runs queued jobs until none are left.
+func runAll(p *Processor) {
+	for p.runNext(context.Background()) {
+	}
+}
+
+func TestProcessor_CSVManifestTaggingWithReport(t *testing.T) {
+	p, store, fs := newTestProcessor(t, "data", "reports")
+	putTestObject(t, store, fs, "data", "a b.txt", "a")
+	putTestObject(t, store, fs, "data", "c.txt", "c")
+	putTestObject(t, store, fs, "data", "manifest.csv", "data,a+b.txt\ndata,missing.txt\ndata,c.txt\n")
+
+	job, err := p.Submit(metadata.BatchJob{
+		Operation: JobPutTagging,
+		Manifest:  metadata.BatchManifest{Format: ManifestCSV, Bucket: "data", Key: "manifest.csv"},
+		Params:    metadata.BatchJobParams{Tags: map[string]string{"team": "ops"}},
+		Report:    metadata.BatchReport{Enabled: true, Bucket: "reports", Prefix: "batch/"},
+	})
+	if err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+	runAll(p)
+
+	got, err := p.GetJob(job.ID)
+	if err != nil {
+		t.Fatalf("GetJob: %v", err)
+	}
+	if got.Status != StatusCompleted || got.Total != 3 || got.Succeeded != 2 || got.Failed != 1 {
+		t.Fatalf("job = %+v, want completed with 2 succeeded and 1 failed of 3", got)
+	}
+	for _, key := range []string{"a b.txt", "c.txt"} {
+		meta, _ := store.GetObjectMeta("data", key)
+		if meta.Tags["team"] != "ops" {
+			t.Errorf("%s tags = %v", key, meta.Tags)
+		}
+	}
+
+	if got.ReportKey != "batch/job-"+job.ID+"/report.csv" {
+		t.Fatalf("report key = %q", got.ReportKey)
+	}
+	report := readTestObject(t, fs, "reports", got.ReportKey)
+	want := "Bucket,Key,VersionID,Status,Error\n" +
+		"data,a b.txt,,succeeded,\n" +
+		"data,missing.txt,,failed,object not found\n" +
+		"data,c.txt,,succeeded,\n"
+	if report != want {
+		t.Errorf("report = %q, want %q", report, want)
+	}
+}
+
+func TestProcessor_ResumesFromCheckpoint(t *testing.T) {
+	p, store, fs := newTestProcessor(t, "data")
+	for _, key := range []string{"k1", "k2", "k3", "k4"} {
+		putTestObject(t, store, fs, "data", key, key)
+	}
+	putTestObject(t, store, fs, "data", "inventory.csv",
+		"Bucket,Key,Size,ETag,LastModified,ContentType,VersionID,StorageClass\n"+
+			"data,k1,2,,,,,\ndata,k2,2,,,,,\ndata,k3,2,,,,,\ndata,k4,2,,,,,\n")
+
+	// A job interrupted after two entries, as left behind by a crash
+	store.PutBatchJob(metadata.BatchJob{
+		ID:        "interrupted",
+		Operation: JobBulkDelete,
+		Status:    StatusRunning,
+		Manifest:  metadata.BatchManifest{Format: ManifestInventory, Bucket: "data", Key: "inventory.csv"},
+		Total:     4,
+		Processed: 2,
+		Succeeded: 2,
+	})
+
+	p.requeueInterrupted()
+	runAll(p)
+
+	job, _ := store.GetBatchJob("interrupted")
+	if job.Status != StatusCompleted || job.Processed != 4 || job.Succeeded != 4 {
+		t.Fatalf("job = %+v, want completed with 4 processed", job)
+	}
+	for key, kept := range map[string]bool{"k1": true, "k2": true, "k3": false, "k4": false} {
+		if _, err := store.GetObjectMeta("data", key); (err == nil) != kept {
+			t.Errorf("%s kept = %v, want %v", key, err == nil, kept)
+		}
+	}
+}
+
+func TestProcessor_PriorityPauseResumeCancel(t *testing.T) {
+	p, store, fs := newTestProcessor(t, "src", "dst")
+	putTestObject(t, store, fs, "src", "logs/one", "1")
+	putTestObject(t, store, fs, "src", "logs/two", "2")
+	putTestObject(t, store, fs, "src", "other", "3")
+
+	manifest := metadata.BatchManifest{Format: ManifestPrefix, Bucket: "src", Prefix: "logs/"}
+	low, err := p.Submit(metadata.BatchJob{Operation: JobPutLegalHold, Priority: 1, Manifest: manifest,
+		Params: metadata.BatchJobParams{LegalHold: "ON"}})
+	if err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+	high, err := p.Submit(metadata.BatchJob{Operation: JobBulkCopy, Priority: 5, Manifest: manifest,
+		Params: metadata.BatchJobParams{DstBucket: "dst", DstPrefix: "copy/"}})
+	if err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+	doomed, _ := p.Submit(metadata.BatchJob{Operation: JobBulkDelete, Manifest: manifest})
+
+	if err := p.Pause(low.ID); err != nil {
+		t.Fatalf("Pause: %v", err)
+	}
+	if err := p.Cancel(doomed.ID); err != nil {
+		t.Fatalf("Cancel: %v", err)
+	}
+	if !p.runNext(context.Background()) {
+		t.Fatal("expected a job to run")
+	}
+	if p.runNext(context.Background()) {
+		t.Fatal("paused and cancelled jobs must not run")
+	}
+
+	job, _ := p.GetJob(high.ID)
+	if job.Status != StatusCompleted || job.Succeeded != 2 || job.LastKey != "logs/two" {
+		t.Fatalf("copy job = %+v", job)
+	}
+	if got := readTestObject(t, fs, "dst", "copy/logs/two"); got != "2" {
+		t.Errorf("copied body = %q", got)
+	}
+	if _, err := store.GetObjectMeta("dst", "copy/other"); err == nil {
+		t.Error("object outside the prefix was copied")
+	}
+	if job, _ := p.GetJob(doomed.ID); job.Status != StatusCancelled {
+		t.Errorf("cancelled job status = %s", job.Status)
+	}
+
+	if err := p.Resume(low.ID); err != nil {
+		t.Fatalf("Resume: %v", err)
+	}
+	runAll(p)
+	if job, _ := p.GetJob(low.ID); job.Status != StatusCompleted {
+		t.Errorf("resumed job status = %s", job.Status)
+	}
+	if meta, _ := store.GetObjectMeta("src", "logs/one"); !meta.LegalHold {
+		t.Error("legal hold not set")
+	}
+	if err := p.Resume(low.ID); err == nil {
+		t.Error("resuming a completed job should fail")
+	}
+}
+
+func TestProcessor_Validate(t *testing.T) {
+	p, store, fs := newTestProcessor(t, "data")
+	putTestObject(t, store, fs, "data", "manifest.csv", "data,a\n")
+	manifest := metadata.BatchManifest{Format: ManifestCSV, Bucket: "data", Key: "manifest.csv"}
+
+	tests := []struct {
+		name string
+		job  metadata.BatchJob
+	}{
+		{"unknown operation", metadata.BatchJob{Operation: "explode", Manifest: manifest}},
+		{"missing manifest", metadata.BatchJob{Operation: JobBulkDelete,
+			Manifest: metadata.BatchManifest{Format: ManifestCSV, Bucket: "data", Key: "nope.csv"}}},
+		{"copy without destination", metadata.BatchJob{Operation: JobBulkCopy, Manifest: manifest}},
+		{"bad legal hold", metadata.BatchJob{Operation: JobPutLegalHold, Manifest: manifest,
+			Params: metadata.BatchJobParams{LegalHold: "MAYBE"}}},
+		{"restore without tiering", metadata.BatchJob{Operation: JobRestore, Manifest: manifest,
+			Params: metadata.BatchJobParams{RestoreDays: 1}}},
+		{"report bucket missing", metadata.BatchJob{Operation: JobReEncrypt, Manifest: manifest,
+			Report: metadata.BatchReport{Enabled: true, Bucket: "nowhere"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := p.Submit(tt.job); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func putTestVersion(t *testing.T, store *metadata.Store, engine storage.Engine, bucket, key, versionID, body string, modified int64) metadata.ObjectMeta {
+	t.Helper()
+	written, etag, err := engine.PutObjectVersion(bucket, key, versionID, strings.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatalf("PutObjectVersion: %v", err)
+	}
+	if old, err := store.GetObjectMeta(bucket, key); err == nil {
+		old.IsLatest = false
+		store.PutObjectVersion(*old)
+	}
+	meta := metadata.ObjectMeta{Bucket: bucket, Key: key, VersionID: versionID, IsLatest: true, Size: written, ETag: etag, LastModified: modified}
+	store.PutObjectVersion(meta)
+	store.PutObjectMeta(meta)
+	return meta
+}
+
+func TestProcessor_DeleteVersionPromotesSameKey(t *testing.T) {
+	p, store, fs := newTestProcessor(t, "data")
+	store.SetBucketVersioning("data", "Enabled")
+	putTestVersion(t, store, fs, "data", "a", "v1", "one", 100)
+	v2 := putTestVersion(t, store, fs, "data", "a", "v2", "two", 200)
+	putTestVersion(t, store, fs, "data", "a2", "v0", "other", 300)
+
+	// Deleting a noncurrent version leaves the current one alone
+	v1, _ := store.GetObjectVersion("data", "a", "v1")
+	if err := p.deleteObject(v1, true); err != nil {
+		t.Fatalf("delete v1: %v", err)
+	}
+	if cur, _ := store.GetObjectMeta("data", "a"); cur == nil || cur.VersionID != "v2" {
+		t.Fatalf("current after deleting v1 = %+v", cur)
+	}
+
+	putTestVersion(t, store, fs, "data", "a", "v3", "three", 300)
+	v3, _ := store.GetObjectVersion("data", "a", "v3")
+	if err := p.deleteObject(v3, true); err != nil {
+		t.Fatalf("delete v3: %v", err)
+	}
+	cur, err := store.GetObjectMeta("data", "a")
+	if err != nil || cur.VersionID != "v2" || !cur.IsLatest || cur.ETag != v2.ETag {
+		t.Fatalf("current after deleting v3 = %+v, %v", cur, err)
+	}
+
+	if err := p.deleteObject(cur, true); err != nil {
+		t.Fatalf("delete v2: %v", err)
+	}
+	if _, err := store.GetObjectMeta("data", "a"); err == nil {
+		t.Error("current metadata should be gone with the last version")
+	}
+	if other, err := store.GetObjectMeta("data", "a2"); err != nil || other.VersionID != "v0" {
+		t.Errorf("other key changed: %+v, %v", other, err)
+	}
+}
+
+func TestProcessor_CopyHooksAndVersioning(t *testing.T) {
+	p, store, fs := newTestProcessor(t, "src", "dst")
+	putTestObject(t, store, fs, "src", "a.txt", "hello")
+	store.SetBucketVersioning("dst", "Suspended")
+
+	var events []string
+	p.SetEventFunc(func(eventType, bucket, key string, size int64, etag, versionID string) {
+		events = append(events, eventType+" "+bucket+"/"+key+" "+versionID)
+	})
+	var scanned []string
+	p.SetScanFunc(func(bucket, key string, size int64) { scanned = append(scanned, bucket+"/"+key) })
+	var quotaErr error
+	p.SetQuotaFunc(func(bucket string, size int64) error { return quotaErr })
+
+	src, _ := store.GetObjectMeta("src", "a.txt")
+	if err := p.copyObject(src, "dst", "b.txt"); err != nil {
+		t.Fatalf("copyObject: %v", err)
+	}
+	dst, err := store.GetObjectMeta("dst", "b.txt")
+	if err != nil || dst.VersionID != "null" || !dst.IsLatest {
+		t.Fatalf("copy in suspended bucket = %+v, %v", dst, err)
+	}
+	if v, err := store.GetObjectVersion("dst", "b.txt", "null"); err != nil || v.Size != 5 {
+		t.Errorf("null version = %+v, %v", v, err)
+	}
+	if got := strings.Join(events, ","); got != "s3:ObjectCreated:Copy dst/b.txt null" {
+		t.Errorf("events = %q", got)
+	}
+	if got := strings.Join(scanned, ","); got != "dst/b.txt" {
+		t.Errorf("scanned = %q", got)
+	}
+
+	pending := *src
+	pending.ScanStatus = metadata.ScanPending
+	if err := p.copyObject(&pending, "dst", "pending.txt"); err == nil {
+		t.Error("copy of an object pending a scan should fail")
+	}
+
+	quotaErr = io.ErrShortWrite
+	if err := p.copyObject(src, "dst", "c.txt"); err != quotaErr {
+		t.Errorf("copy over quota: %v", err)
+	}
+	if _, err := store.GetObjectMeta("dst", "c.txt"); err == nil {
+		t.Error("copy over quota was stored")
+	}
+}
+
+func TestProcessor_ReEncryptVersionUpdatesMeta(t *testing.T) {
+	p, store, fs := newTestProcessor(t, "data")
+	meta := putTestVersion(t, store, fs, "data", "a", "v1", "version data", 100)
+	meta.ETag, meta.Size = "stale", 1
+	store.UpdateObjectVersionMeta(meta)
+
+	if err := p.reEncrypt(&meta); err != nil {
+		t.Fatalf("reEncrypt: %v", err)
+	}
+	r, _, err := fs.GetObjectVersion("data", "a", "v1")
+	if err != nil {
+		t.Fatalf("GetObjectVersion: %v", err)
+	}
+	data, _ := io.ReadAll(r)
+	r.Close()
+	if string(data) != "version data" {
+		t.Errorf("data after re-encrypt = %q", data)
+	}
+	cur, _ := store.GetObjectMeta("data", "a")
+	if cur.ETag == "stale" || cur.Size != int64(len("version data")) {
+		t.Errorf("meta after re-encrypt = %+v", cur)
+	}
+}
@@ -0,0 +1,61 @@
+package batch
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"log/slog"
+
+	"github.com/eniz1806/VaultS3/internal/metadata"
+)
+
+// Report scopes.
+const (
+	ReportAllTasks        = "AllTasks"
+	ReportFailedTasksOnly = "FailedTasksOnly"
+)
+
+// finishReport writes the completion report of a finished job, if enabled,
+// and drops its stored task results.
+func (p *Processor) finishReport(job *metadata.BatchJob) {
+	if job.Report.Enabled {
+		key, err := p.writeReport(job)
+		if err != nil {
+			slog.Error("batch error writing report", "id", job.ID, "error", err)
+			if job.Error == "" {
+				job.Error = "write report: " + err.Error()
+			}
+		} else {
+			job.ReportKey = key
+		}
+	}
+	if err := p.store.DeleteBatchTaskResults(job.ID); err != nil {
+		slog.Error("batch error deleting task results", "id", job.ID, "error", err)
+	}
+}
+
+// writeReport stores the task results of a job as a CSV object in the
+// report bucket and returns its key.
+func (p *Processor) writeReport(job *metadata.BatchJob) (string, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"Bucket", "Key", "VersionID", "Status", "Error"})
+	err := p.store.IterateBatchTaskResults(job.ID, func(r metadata.BatchTaskResult) bool {
+		w.Write([]string{r.Bucket, r.Key, r.VersionID, r.Status, r.Error})
+		return true
+	})
+	if err != nil {
+		return "", err
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return "", err
+	}
+
+	key := fmt.Sprintf("%sjob-%s/report.csv", job.Report.Prefix, job.ID)
+	meta := metadata.ObjectMeta{Bucket: job.Report.Bucket, Key: key, ContentType: "text/csv"}
+	if err := p.putObject(meta, bytes.NewReader(buf.Bytes()), int64(buf.Len())); err != nil {
+		return "", err
+	}
+	return key, nil
+}
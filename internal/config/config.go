@@ -4,6 +4,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strconv"
 
 	"gopkg.in/yaml.v3"
@@ -21,6 +22,7 @@ type Config struct {
 	Notifications NotificationsConfig `yaml:"notifications"`
 	Replication   ReplicationConfig   `yaml:"replication"`
 	Scanner       ScannerConfig       `yaml:"scanner"`
+	Search        SearchConfig        `yaml:"search"`
 	Tiering       TieringConfig       `yaml:"tiering"`
 	Backup        BackupConfig        `yaml:"backup"`
 	RateLimit     RateLimitConfig     `yaml:"rate_limit"`
@@ -76,9 +78,16 @@ type RebalanceConfig struct {
 	BatchSize        int `yaml:"batch_size"`
 }
 
+// SearchConfig configures the extraction of object text for buckets with
+// content indexing enabled.
+type SearchConfig struct {
+	ContentWorkers      int   `yaml:"content_workers"`
+	ContentQueueSize    int   `yaml:"content_queue_size"`     // uploads beyond it wait for a backfill
+	ContentMaxSizeBytes int64 `yaml:"content_max_size_bytes"` // larger objects are not read
+}
+
 type MemoryConfig struct {
-	MaxSearchEntries int `yaml:"max_search_entries"`
-	GoMemLimitMB     int `yaml:"go_mem_limit_mb"`
+	GoMemLimitMB int `yaml:"go_mem_limit_mb"`
 }
 
 type OIDCConfig struct {
@@ -92,11 +101,42 @@ type OIDCConfig struct {
 }
 
 type LambdaConfig struct {
-	Enabled         bool  `yaml:"enabled"`
-	MaxResponseSize int64 `yaml:"max_response_size"`
-	TimeoutSecs     int   `yaml:"timeout_secs"`
-	MaxWorkers      int   `yaml:"max_workers"`
-	QueueSize       int   `yaml:"queue_size"`
+	Enabled          bool  `yaml:"enabled"`
+	MaxResponseSize  int64 `yaml:"max_response_size"`
+	TimeoutSecs      int   `yaml:"timeout_secs"`
+	MaxWorkers       int   `yaml:"max_workers"`
+	QueueSize        int   `yaml:"queue_size"`
+	MaxRetries       int   `yaml:"max_retries"`        // attempts before a job is dead-lettered, unless set per trigger
+	RetryBackoffSecs int   `yaml:"retry_backoff_secs"` // first retry delay, doubling per attempt, unless set per trigger
+	HistoryDays      int   `yaml:"history_days"`       // invocation history retention; 0 keeps it forever
+	// Commands are the local programs that triggers with the process runtime
+	// may run, by name.
+	Commands []LambdaCommandConfig `yaml:"commands"`
+	WorkDir  string                `yaml:"work_dir"` // parent of per-invocation working directories; system temp dir if empty
+	// MaxPipelineDepth bounds the steps on any path through a pipeline,
+	// counting the steps of the runs that produced the triggering object.
+	MaxPipelineDepth int `yaml:"max_pipeline_depth"`
+}
+
+// LambdaCommandConfig allow-lists a local program for process triggers.
+type LambdaCommandConfig struct {
+	Name        string   `yaml:"name"`
+	Path        string   `yaml:"path"` // absolute path of the executable
+	Args        []string `yaml:"args"`
+	ContentType string   `yaml:"content_type"`  // of stored output; application/octet-stream if empty
+	TimeoutSecs int      `yaml:"timeout_secs"`  // lambda.timeout_secs if zero
+	MaxMemoryMB int      `yaml:"max_memory_mb"` // address space limit; 0 = unlimited
+	MaxCPUSecs  int      `yaml:"max_cpu_secs"`  // CPU time limit; 0 = unlimited
+}
+
+// Command returns the allow-listed command with the given name.
+func (c LambdaConfig) Command(name string) (LambdaCommandConfig, bool) {
+	for _, cmd := range c.Commands {
+		if cmd.Name == name {
+			return cmd, true
+		}
+	}
+	return LambdaCommandConfig{}, false
 }
 
 type RateLimitConfig struct {
@@ -108,10 +148,28 @@ type RateLimitConfig struct {
 }
 
 type TieringConfig struct {
-	Enabled          bool   `yaml:"enabled"`
-	ColdDataDir      string `yaml:"cold_data_dir"`
-	MigrateAfterDays int    `yaml:"migrate_after_days"`
-	ScanIntervalSecs int    `yaml:"scan_interval_secs"`
+	Enabled              bool   `yaml:"enabled"`
+	ColdDataDir          string `yaml:"cold_data_dir"`
+	MigrateAfterDays     int    `yaml:"migrate_after_days"`
+	ScanIntervalSecs     int    `yaml:"scan_interval_secs"`
+	RestoreExpeditedSecs int    `yaml:"restore_expedited_secs"` // delay before an Expedited restore runs
+	RestoreStandardSecs  int    `yaml:"restore_standard_secs"`  // delay before a Standard restore runs
+	RestoreBulkSecs      int    `yaml:"restore_bulk_secs"`      // delay before a Bulk restore runs
+	// StorageClasses overrides the tier ("hot", "cold" or "remote") backing
+	// each S3 storage class used by lifecycle transitions.
+	StorageClasses map[string]string `yaml:"storage_classes"`
+	Remote         RemoteTierConfig  `yaml:"remote"`
+}
+
+// RemoteTierConfig configures the S3-compatible remote tier.
+type RemoteTierConfig struct {
+	Enabled   bool   `yaml:"enabled"`
+	Endpoint  string `yaml:"endpoint"`
+	Bucket    string `yaml:"bucket"`
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"secret_key"`
+	Region    string `yaml:"region"`
+	UseSSL    bool   `yaml:"use_ssl"`
 }
 
 type BackupTarget struct {
@@ -133,13 +191,47 @@ type BackupConfig struct {
 }
 
 type ScannerConfig struct {
-	Enabled          bool   `yaml:"enabled"`
-	WebhookURL       string `yaml:"webhook_url"`
-	TimeoutSecs      int    `yaml:"timeout_secs"`
-	QuarantineBucket string `yaml:"quarantine_bucket"`
-	FailClosed       bool   `yaml:"fail_closed"`
-	MaxScanSizeBytes int64  `yaml:"max_scan_size_bytes"`
-	Workers          int    `yaml:"workers"`
+	Enabled          bool              `yaml:"enabled"`
+	Backend          string            `yaml:"backend"` // "webhook" (default) or "clamd"
+	WebhookURL       string            `yaml:"webhook_url"`
+	Clamd            ClamdConfig       `yaml:"clamd"`
+	TimeoutSecs      int               `yaml:"timeout_secs"`
+	QuarantineBucket string            `yaml:"quarantine_bucket"`
+	FailClosed       bool              `yaml:"fail_closed"`
+	MaxScanSizeBytes int64             `yaml:"max_scan_size_bytes"`
+	Workers          int               `yaml:"workers"`
+	Auth             WebhookAuthConfig `yaml:"auth"`
+
+	RescanObjectsPerSec int                    `yaml:"rescan_objects_per_sec"` // default throttle of rescans
+	RescanSchedules     []RescanScheduleConfig `yaml:"rescan_schedules"`
+}
+
+// RescanScheduleConfig rescans a bucket, or a prefix of it, at a fixed
+// interval so that stored objects are checked against new signatures.
+type RescanScheduleConfig struct {
+	Bucket        string `yaml:"bucket"`
+	Prefix        string `yaml:"prefix"`
+	IntervalHours int    `yaml:"interval_hours"`
+	ObjectsPerSec int    `yaml:"objects_per_sec"` // 0 uses rescan_objects_per_sec
+	SkipCurrent   bool   `yaml:"skip_current"`    // skip objects already found clean by the current engine version
+}
+
+// ClamdConfig configures the clamd scanner backend.
+type ClamdConfig struct {
+	Address         string `yaml:"address"`           // "tcp://host:3310", "unix:///run/clamav/clamd.ctl", "host:port" or a socket path
+	StreamMaxLength int64  `yaml:"stream_max_length"` // StreamMaxLength of clamd.conf; larger objects are not sent
+	ChunkSize       int    `yaml:"chunk_size"`        // bytes per INSTREAM chunk
+}
+
+// WebhookAuthConfig authenticates requests to a webhook configured in the
+// config file. Certificates and keys are read from PEM files.
+type WebhookAuthConfig struct {
+	Secret         string            `yaml:"secret"`           // HMAC-SHA256 signing secret
+	Headers        map[string]string `yaml:"headers"`          // static headers sent with every request
+	BearerTokenRef string            `yaml:"bearer_token_ref"` // name of a secret of security.webhook_secrets
+	ClientCertFile string            `yaml:"client_cert_file"`
+	ClientKeyFile  string            `yaml:"client_key_file"`
+	CAFile         string            `yaml:"ca_file"`
 }
 
 type ReplicationPeer struct {
@@ -162,15 +254,64 @@ type ReplicationConfig struct {
 }
 
 type NotificationsConfig struct {
-	MaxWorkers  int                  `yaml:"max_workers"`
-	QueueSize   int                  `yaml:"queue_size"`
-	TimeoutSecs int                  `yaml:"timeout_secs"`
-	MaxRetries  int                  `yaml:"max_retries"`
-	Kafka       KafkaNotifyConfig    `yaml:"kafka"`
-	NATS        NATSNotifyConfig     `yaml:"nats"`
-	Redis       RedisNotifyConfig    `yaml:"redis"`
-	AMQP        AMQPNotifyConfig     `yaml:"amqp"`
-	Postgres    PostgresNotifyConfig `yaml:"postgres"`
+	MaxWorkers  int    `yaml:"max_workers"`
+	QueueSize   int    `yaml:"queue_size"`
+	TimeoutSecs int    `yaml:"timeout_secs"`
+	MaxRetries  int    `yaml:"max_retries"`
+	Region      string `yaml:"region"` // reported as awsRegion in event records
+	// EventLogDays is how long emitted events are kept for replay; 0
+	// disables the event log.
+	EventLogDays int `yaml:"event_log_days"`
+	// RouteBackends makes global backends receive only the events of buckets
+	// routing to arn:vaults3:sqs::<type> instead of every event.
+	RouteBackends bool                 `yaml:"route_backends"`
+	Kafka         KafkaNotifyConfig    `yaml:"kafka"`
+	NATS          NATSNotifyConfig     `yaml:"nats"`
+	Redis         RedisNotifyConfig    `yaml:"redis"`
+	AMQP          AMQPNotifyConfig     `yaml:"amqp"`
+	Postgres      PostgresNotifyConfig `yaml:"postgres"`
+	MQTT          MQTTNotifyConfig     `yaml:"mqtt"`
+	NSQ           NSQNotifyConfig      `yaml:"nsq"`
+	// Targets are named destinations that bucket notification configurations
+	// route events to by ARN.
+	Targets []NotifyTargetConfig `yaml:"targets"`
+}
+
+// NotifyTargetConfig is a named notification target. Only the fields of its
+// type are used.
+type NotifyTargetConfig struct {
+	Name string `yaml:"name"`
+	Type string `yaml:"type"` // kafka, nats, redis, amqp, postgres, elasticsearch, mqtt or nsq
+	// Service is the ARN service: sqs (default), sns or lambda.
+	Service    string   `yaml:"service"`
+	Brokers    []string `yaml:"brokers"`     // kafka
+	Topic      string   `yaml:"topic"`       // kafka, mqtt, nsq
+	URL        string   `yaml:"url"`         // nats, amqp, elasticsearch, mqtt
+	Subject    string   `yaml:"subject"`     // nats
+	Addr       string   `yaml:"addr"`        // redis, nsq
+	Channel    string   `yaml:"channel"`     // redis
+	ListKey    string   `yaml:"list_key"`    // redis
+	Exchange   string   `yaml:"exchange"`    // amqp
+	RoutingKey string   `yaml:"routing_key"` // amqp
+	ConnStr    string   `yaml:"conn_str"`    // postgres
+	Table      string   `yaml:"table"`       // postgres
+	Index      string   `yaml:"index"`       // elasticsearch
+	QoS        int      `yaml:"qos"`         // mqtt
+	Retain     bool     `yaml:"retain"`      // mqtt
+	Username   string   `yaml:"username"`    // mqtt
+	Password   string   `yaml:"password"`    // mqtt
+	ClientID   string   `yaml:"client_id"`   // mqtt
+	CAFile     string   `yaml:"ca_file"`     // mqtt
+}
+
+// ARN returns the ARN bucket notification configurations use to reference
+// the target, e.g. arn:vaults3:sqs::kafka-main.
+func (t NotifyTargetConfig) ARN() string {
+	service := t.Service
+	if service == "" {
+		service = "sqs"
+	}
+	return "arn:vaults3:" + service + "::" + t.Name
 }
 
 type KafkaNotifyConfig struct {
@@ -205,11 +346,33 @@ type PostgresNotifyConfig struct {
 	Table   string `yaml:"table"`
 }
 
+type MQTTNotifyConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	Broker   string `yaml:"broker"` // tcp://host:1883, or ssl://host:8883 for TLS
+	Topic    string `yaml:"topic"`  // may contain {bucket}, {key} and {event}
+	QoS      int    `yaml:"qos"`    // 0 or 1
+	Retain   bool   `yaml:"retain"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	ClientID string `yaml:"client_id"`
+	CAFile   string `yaml:"ca_file"` // PEM CAs for TLS brokers
+}
+
+type NSQNotifyConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Addr    string `yaml:"addr"` // nsqd TCP address, host:4150
+	Topic   string `yaml:"topic"`
+}
+
 type SecurityConfig struct {
 	IPAllowlist        []string `yaml:"ip_allowlist"`
 	IPBlocklist        []string `yaml:"ip_blocklist"`
 	AuditRetentionDays int      `yaml:"audit_retention_days"`
 	STSMaxDurationSecs int      `yaml:"sts_max_duration_secs"`
+	SecretsKey         string   `yaml:"secrets_key"` // hex AES-256 key for webhook secrets; generated in metadata_dir if empty
+	// WebhookSecrets are the only secrets a webhook bearer_token_ref may
+	// name: each maps a name to "env:NAME" or "file:/path"
+	WebhookSecrets map[string]string `yaml:"webhook_secrets"`
 }
 
 type ServerConfig struct {
@@ -309,10 +472,12 @@ func Load(path string) (*Config, error) {
 			STSMaxDurationSecs: 43200,
 		},
 		Notifications: NotificationsConfig{
-			MaxWorkers:  4,
-			QueueSize:   256,
-			TimeoutSecs: 10,
-			MaxRetries:  3,
+			MaxWorkers:   4,
+			QueueSize:    256,
+			TimeoutSecs:  10,
+			MaxRetries:   3,
+			Region:       "us-east-1",
+			EventLogDays: 7,
 		},
 		Replication: ReplicationConfig{
 			ScanIntervalSecs: 30,
@@ -320,14 +485,26 @@ func Load(path string) (*Config, error) {
 			BatchSize:        100,
 		},
 		Scanner: ScannerConfig{
-			TimeoutSecs:      30,
-			QuarantineBucket: "vaults3-quarantine",
-			MaxScanSizeBytes: 104857600, // 100MB
-			Workers:          2,
+			TimeoutSecs:         30,
+			QuarantineBucket:    "vaults3-quarantine",
+			MaxScanSizeBytes:    104857600, // 100MB
+			Workers:             2,
+			RescanObjectsPerSec: 10,
+			Clamd: ClamdConfig{
+				StreamMaxLength: 25 * 1024 * 1024, // clamd's default
+				ChunkSize:       64 * 1024,
+			},
+		},
+		Search: SearchConfig{
+			ContentWorkers:      2,
+			ContentQueueSize:    1024,
+			ContentMaxSizeBytes: 10 * 1024 * 1024, // 10MB
 		},
 		Tiering: TieringConfig{
-			MigrateAfterDays: 30,
-			ScanIntervalSecs: 3600,
+			MigrateAfterDays:    30,
+			ScanIntervalSecs:    3600,
+			RestoreStandardSecs: 60,
+			RestoreBulkSecs:     300,
 		},
 		Backup: BackupConfig{
 			ScheduleCron:  "0 2 * * *",
@@ -343,13 +520,14 @@ func Load(path string) (*Config, error) {
 			JWKSCacheSecs: 3600,
 		},
 		Lambda: LambdaConfig{
-			MaxResponseSize: 10 * 1024 * 1024, // 10MB
-			TimeoutSecs:     30,
-			MaxWorkers:      4,
-			QueueSize:       256,
-		},
-		Memory: MemoryConfig{
-			MaxSearchEntries: 50000,
+			MaxResponseSize:  10 * 1024 * 1024, // 10MB
+			TimeoutSecs:      30,
+			MaxWorkers:       4,
+			QueueSize:        256,
+			MaxRetries:       3,
+			RetryBackoffSecs: 1,
+			HistoryDays:      7,
+			MaxPipelineDepth: 10,
 		},
 	}
 
@@ -367,6 +545,37 @@ func Load(path string) (*Config, error) {
 		}
 	}
 
+	switch cfg.Scanner.Backend {
+	case "", "webhook":
+	case "clamd":
+		if cfg.Scanner.Enabled && cfg.Scanner.Clamd.Address == "" {
+			return nil, fmt.Errorf("scanner.clamd.address is required for the clamd backend")
+		}
+	default:
+		return nil, fmt.Errorf("unknown scanner backend %q", cfg.Scanner.Backend)
+	}
+	for i, sched := range cfg.Scanner.RescanSchedules {
+		if sched.Bucket == "" || sched.IntervalHours <= 0 {
+			return nil, fmt.Errorf("scanner.rescan_schedules[%d]: bucket and a positive interval_hours are required", i)
+		}
+	}
+
+	// Validate lambda commands
+	seen := make(map[string]bool)
+	for _, cmd := range cfg.Lambda.Commands {
+		switch {
+		case cmd.Name == "":
+			return nil, fmt.Errorf("lambda command %q: name is required", cmd.Path)
+		case seen[cmd.Name]:
+			return nil, fmt.Errorf("lambda command %q is defined twice", cmd.Name)
+		case !filepath.IsAbs(cmd.Path):
+			return nil, fmt.Errorf("lambda command %q: path must be absolute", cmd.Name)
+		case cmd.TimeoutSecs < 0 || cmd.MaxMemoryMB < 0 || cmd.MaxCPUSecs < 0:
+			return nil, fmt.Errorf("lambda command %q: limits must not be negative", cmd.Name)
+		}
+		seen[cmd.Name] = true
+	}
+
 	return cfg, nil
 }
 
@@ -172,3 +172,83 @@ compression:
 		t.Error("compression should be enabled")
 	}
 }
+
+func TestLoad_LambdaCommands(t *testing.T) {
+	p := writeConfig(t, `
+lambda:
+  commands:
+    - name: thumb
+      path: /usr/local/bin/thumb
+      args: ["--size", "128"]
+      max_memory_mb: 256
+`)
+	cfg, err := Load(p)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	cmd, ok := cfg.Lambda.Command("thumb")
+	if !ok || cmd.Path != "/usr/local/bin/thumb" || len(cmd.Args) != 2 || cmd.MaxMemoryMB != 256 {
+		t.Errorf("command: got %+v, %v", cmd, ok)
+	}
+	if _, ok := cfg.Lambda.Command("missing"); ok {
+		t.Error("unknown command should not be found")
+	}
+
+	for name, yaml := range map[string]string{
+		"relative path": "lambda:\n  commands:\n    - name: a\n      path: bin/a\n",
+		"duplicate":     "lambda:\n  commands:\n    - name: a\n      path: /a\n    - name: a\n      path: /b\n",
+		"no name":       "lambda:\n  commands:\n    - path: /a\n",
+		"negative":      "lambda:\n  commands:\n    - name: a\n      path: /a\n      max_cpu_secs: -1\n",
+	} {
+		if _, err := Load(writeConfig(t, yaml)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestLoad_ScannerBackend(t *testing.T) {
+	cfg, err := Load(writeConfig(t, "scanner:\n  enabled: true\n  backend: clamd\n  clamd:\n    address: unix:///run/clamd.sock\n"))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Scanner.Clamd.StreamMaxLength != 25<<20 || cfg.Scanner.Clamd.ChunkSize != 64<<10 {
+		t.Errorf("clamd defaults: got %+v", cfg.Scanner.Clamd)
+	}
+
+	cfg, err = Load(writeConfig(t, "scanner:\n  rescan_schedules:\n    - bucket: data\n      prefix: uploads/\n      interval_hours: 24\n      skip_current: true\n"))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Scanner.RescanObjectsPerSec != 10 || len(cfg.Scanner.RescanSchedules) != 1 || cfg.Scanner.RescanSchedules[0].Prefix != "uploads/" || !cfg.Scanner.RescanSchedules[0].SkipCurrent {
+		t.Errorf("rescan config: got %+v", cfg.Scanner)
+	}
+
+	for name, yaml := range map[string]string{
+		"unknown backend": "scanner:\n  backend: icap\n",
+		"no address":      "scanner:\n  enabled: true\n  backend: clamd\n",
+		"no interval":     "scanner:\n  rescan_schedules:\n    - bucket: data\n",
+		"no bucket":       "scanner:\n  rescan_schedules:\n    - interval_hours: 24\n",
+	} {
+		if _, err := Load(writeConfig(t, yaml)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestLoad_Search(t *testing.T) {
+	cfg, err := Load(writeConfig(t, ""))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Search.ContentWorkers != 2 || cfg.Search.ContentQueueSize != 1024 || cfg.Search.ContentMaxSizeBytes != 10<<20 {
+		t.Errorf("search defaults: got %+v", cfg.Search)
+	}
+
+	cfg, err = Load(writeConfig(t, "search:\n  content_workers: 8\n  content_max_size_bytes: 1048576\n"))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Search.ContentWorkers != 8 || cfg.Search.ContentQueueSize != 1024 || cfg.Search.ContentMaxSizeBytes != 1<<20 {
+		t.Errorf("search config: got %+v", cfg.Search)
+	}
+}
@@ -0,0 +1,321 @@
+// Package filetype identifies the real type of content from its first bytes
+// (magic numbers), independently of the Content-Type a client declares.
+package filetype
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// HeadSize is how many leading bytes Detect looks at.
+const HeadSize = 512
+
+// Classes group related types so that policies do not have to list every
+// MIME type.
+const (
+	ClassExecutable = "executable"
+	ClassScript     = "script"
+	ClassArchive    = "archive"
+	ClassDocument   = "document"
+	ClassImage      = "image"
+	ClassAudio      = "audio"
+	ClassVideo      = "video"
+	ClassText       = "text"
+	ClassUnknown    = "unknown"
+)
+
+var classes = map[string]bool{
+	ClassExecutable: true, ClassScript: true, ClassArchive: true, ClassDocument: true,
+	ClassImage: true, ClassAudio: true, ClassVideo: true, ClassText: true, ClassUnknown: true,
+}
+
+// Type is the detected type of some content.
+type Type struct {
+	MIME  string `json:"mime"`
+	Class string `json:"class"`
+	// Sure is set when a magic number identified the content, rather than a
+	// guess such as plain text or application/octet-stream.
+	Sure bool `json:"sure"`
+}
+
+type signature struct {
+	offset int
+	magic  string
+	mime   string
+	class  string
+}
+
+// Order matters: more specific signatures come before shorter ones that
+// share a prefix.
+var signatures = []signature{
+	// Executables
+	{0, "MZ", "application/vnd.microsoft.portable-executable", ClassExecutable},
+	{0, "\x7fELF", "application/x-elf", ClassExecutable},
+	{0, "\xfe\xed\xfa\xce", "application/x-mach-binary", ClassExecutable},
+	{0, "\xfe\xed\xfa\xcf", "application/x-mach-binary", ClassExecutable},
+	{0, "\xce\xfa\xed\xfe", "application/x-mach-binary", ClassExecutable},
+	{0, "\xcf\xfa\xed\xfe", "application/x-mach-binary", ClassExecutable},
+	{0, "\xca\xfe\xba\xbe", "application/x-mach-binary", ClassExecutable}, // also Java class files
+	{0, "\x00asm", "application/wasm", ClassExecutable},
+	{0, "dex\n", "application/vnd.android.dex", ClassExecutable},
+
+	// Scripts
+	{0, "#!", "text/x-shellscript", ClassScript},
+	{0, "<?php", "application/x-httpd-php", ClassScript},
+
+	// Archives
+	{0, "\x1f\x8b", "application/gzip", ClassArchive},
+	{0, "BZh", "application/x-bzip2", ClassArchive},
+	{0, "\xfd7zXZ\x00", "application/x-xz", ClassArchive},
+	{0, "\x28\xb5\x2f\xfd", "application/zstd", ClassArchive},
+	{0, "7z\xbc\xaf\x27\x1c", "application/x-7z-compressed", ClassArchive},
+	{0, "Rar!\x1a\x07", "application/vnd.rar", ClassArchive},
+	{0, "MSCF", "application/vnd.ms-cab-compressed", ClassArchive},
+	{257, "ustar", "application/x-tar", ClassArchive},
+
+	// Documents
+	{0, "%PDF-", "application/pdf", ClassDocument},
+	{0, "{\\rtf", "application/rtf", ClassDocument},
+	{0, "%!PS", "application/postscript", ClassDocument},
+	{0, "\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1", "application/x-ole-storage", ClassDocument}, // .doc, .xls, .ppt, .msi
+
+	// Images
+	{0, "\x89PNG\r\n\x1a\n", "image/png", ClassImage},
+	{0, "\xff\xd8\xff", "image/jpeg", ClassImage},
+	{0, "GIF87a", "image/gif", ClassImage},
+	{0, "GIF89a", "image/gif", ClassImage},
+	{0, "II*\x00", "image/tiff", ClassImage},
+	{0, "MM\x00*", "image/tiff", ClassImage},
+	{0, "\x00\x00\x01\x00", "image/vnd.microsoft.icon", ClassImage},
+
+	// Audio and video
+	{0, "ID3", "audio/mpeg", ClassAudio},
+	{0, "OggS", "audio/ogg", ClassAudio},
+	{0, "fLaC", "audio/flac", ClassAudio},
+	{0, "\x1a\x45\xdf\xa3", "video/x-matroska", ClassVideo},
+}
+
+// Detect identifies content from its first bytes. It looks at no more than
+// HeadSize bytes of head.
+func Detect(head []byte) Type {
+	if len(head) > HeadSize {
+		head = head[:HeadSize]
+	}
+
+	switch {
+	case hasPrefix(head, 0, "PK\x03\x04"):
+		return detectZip(head)
+	case hasPrefix(head, 0, "RIFF") && len(head) >= 12:
+		switch string(head[8:12]) {
+		case "WEBP":
+			return Type{MIME: "image/webp", Class: ClassImage, Sure: true}
+		case "WAVE":
+			return Type{MIME: "audio/wav", Class: ClassAudio, Sure: true}
+		case "AVI ":
+			return Type{MIME: "video/x-msvideo", Class: ClassVideo, Sure: true}
+		}
+	case hasPrefix(head, 0, "BM") && len(head) >= 14 && string(head[6:10]) == "\x00\x00\x00\x00":
+		// The reserved header fields keep text starting with "BM" out
+		return Type{MIME: "image/bmp", Class: ClassImage, Sure: true}
+	case hasPrefix(head, 4, "ftyp") && len(head) >= 12:
+		switch string(head[8:12]) {
+		case "heic", "heix", "mif1", "msf1":
+			return Type{MIME: "image/heic", Class: ClassImage, Sure: true}
+		case "avif", "avis":
+			return Type{MIME: "image/avif", Class: ClassImage, Sure: true}
+		case "M4A ":
+			return Type{MIME: "audio/mp4", Class: ClassAudio, Sure: true}
+		case "qt  ":
+			return Type{MIME: "video/quicktime", Class: ClassVideo, Sure: true}
+		}
+		return Type{MIME: "video/mp4", Class: ClassVideo, Sure: true}
+	}
+
+	for _, sig := range signatures {
+		if hasPrefix(head, sig.offset, sig.magic) {
+			return Type{MIME: sig.mime, Class: sig.class, Sure: true}
+		}
+	}
+
+	if isSVG(head) {
+		return Type{MIME: "image/svg+xml", Class: ClassImage, Sure: true}
+	}
+
+	// Fall back to the standard library's sniffing, which also recognises
+	// HTML, XML and plain text.
+	ct, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	switch {
+	case ct == "" || ct == "application/octet-stream":
+		return Type{MIME: "application/octet-stream", Class: ClassUnknown}
+	case strings.HasPrefix(ct, "text/"):
+		// Text is told apart by its content, not a magic number, and the
+		// same text is legitimately declared under many types.
+		return Type{MIME: ct, Class: ClassText}
+	default:
+		return Type{MIME: ct, Class: classOf(ct), Sure: true}
+	}
+}
+
+// detectZip tells plain zip archives apart from the formats built on zip,
+// using the name of the first entry.
+func detectZip(head []byte) Type {
+	archive := Type{MIME: "application/zip", Class: ClassArchive, Sure: true}
+	if len(head) < 30 {
+		return archive
+	}
+	nameLen := int(head[26]) | int(head[27])<<8
+	extraLen := int(head[28]) | int(head[29])<<8
+	if 30+nameLen > len(head) {
+		return archive
+	}
+	name := string(head[30 : 30+nameLen])
+	switch {
+	case name == "[Content_Types].xml":
+		return Type{MIME: "application/vnd.openxmlformats-officedocument", Class: ClassDocument, Sure: true}
+	case name == "mimetype":
+		// OpenDocument and EPUB store their type uncompressed as the first entry
+		start := 30 + nameLen + extraLen
+		if start < len(head) {
+			rest := head[start:]
+			if i := bytes.Index(rest, []byte("PK")); i >= 0 {
+				rest = rest[:i]
+			}
+			if mt := string(rest); strings.HasPrefix(mt, "application/vnd.oasis.opendocument.") || mt == "application/epub+zip" {
+				return Type{MIME: mt, Class: ClassDocument, Sure: true}
+			}
+		}
+	case name == "META-INF/MANIFEST.MF" || name == "META-INF/":
+		return Type{MIME: "application/java-archive", Class: ClassExecutable, Sure: true}
+	case name == "AndroidManifest.xml" || name == "classes.dex":
+		return Type{MIME: "application/vnd.android.package-archive", Class: ClassExecutable, Sure: true}
+	}
+	return archive
+}
+
+func isSVG(head []byte) bool {
+	s := bytes.TrimLeft(head, " \t\r\n\xef\xbb\xbf")
+	if bytes.HasPrefix(s, []byte("<?xml")) {
+		if i := bytes.Index(s, []byte("?>")); i >= 0 {
+			s = bytes.TrimLeft(s[i+2:], " \t\r\n")
+		}
+	}
+	for bytes.HasPrefix(s, []byte("<!--")) || bytes.HasPrefix(s, []byte("<!DOCTYPE")) {
+		end := bytes.IndexByte(s, '>')
+		if end < 0 {
+			return false
+		}
+		s = bytes.TrimLeft(s[end+1:], " \t\r\n")
+	}
+	return bytes.HasPrefix(s, []byte("<svg"))
+}
+
+func hasPrefix(head []byte, offset int, magic string) bool {
+	return len(head) >= offset+len(magic) && string(head[offset:offset+len(magic)]) == magic
+}
+
+func classOf(mimeType string) string {
+	major, _, _ := strings.Cut(mimeType, "/")
+	switch major {
+	case "image", "audio", "video", "text":
+		return major
+	}
+	return ClassUnknown
+}
+
+// DetectReader detects the type of the content of r. It returns a reader
+// that still yields the whole content, including the bytes looked at.
+func DetectReader(r io.Reader) (Type, io.Reader, error) {
+	head := make([]byte, HeadSize)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return Type{}, nil, err
+	}
+	head = head[:n]
+	return Detect(head), io.MultiReader(bytes.NewReader(head), r), nil
+}
+
+// aliases maps declared MIME types to the type Detect reports for the
+// same content.
+var aliases = map[string]string{
+	"image/jpg":                     "image/jpeg",
+	"image/pjpeg":                   "image/jpeg",
+	"image/x-icon":                  "image/vnd.microsoft.icon",
+	"image/x-ms-bmp":                "image/bmp",
+	"application/x-zip-compressed":  "application/zip",
+	"application/x-gzip":            "application/gzip",
+	"application/x-rar-compressed":  "application/vnd.rar",
+	"application/x-msdownload":      "application/vnd.microsoft.portable-executable",
+	"application/x-msdos-program":   "application/vnd.microsoft.portable-executable",
+	"application/x-executable":      "application/x-elf",
+	"application/x-sharedlib":       "application/x-elf",
+	"application/x-sh":              "text/x-shellscript",
+	"application/x-shellscript":     "text/x-shellscript",
+	"audio/mp3":                     "audio/mpeg",
+	"audio/x-wav":                   "audio/wav",
+	"audio/wave":                    "audio/wav",
+	"audio/x-flac":                  "audio/flac",
+	"video/webm":                    "video/x-matroska",
+	"application/msword":            "application/x-ole-storage",
+	"application/vnd.ms-excel":      "application/x-ole-storage",
+	"application/vnd.ms-powerpoint": "application/x-ole-storage",
+	"application/x-msi":             "application/x-ole-storage",
+}
+
+// Consistent reports whether a declared Content-Type agrees with the
+// detected type. Types Detect is not sure about agree with anything.
+func (t Type) Consistent(declared string) bool {
+	if !t.Sure {
+		return true
+	}
+	declared, _, _ = mime.ParseMediaType(declared)
+	if alias, ok := aliases[declared]; ok {
+		declared = alias
+	}
+	// A shebang says nothing about which interpreter's type was declared
+	if t.Class == ClassScript && (strings.HasPrefix(declared, "text/") || strings.HasPrefix(declared, "application/x-")) {
+		return true
+	}
+	// Office Open XML types share a prefix, e.g.
+	// application/vnd.openxmlformats-officedocument.wordprocessingml.document
+	return declared == t.MIME || strings.HasPrefix(declared, t.MIME+".")
+}
+
+// Matches reports whether the type matches a policy pattern: a class such
+// as "executable", a MIME type, or a MIME type family such as "image/*".
+func (t Type) Matches(pattern string) bool {
+	switch {
+	case classes[pattern]:
+		return t.Class == pattern
+	case strings.HasSuffix(pattern, "/*"):
+		return strings.HasPrefix(t.MIME, strings.TrimSuffix(pattern, "*"))
+	}
+	return t.MIME == pattern || strings.HasPrefix(t.MIME, pattern+".")
+}
+
+// MatchesAny reports whether the type matches any of the patterns.
+func (t Type) MatchesAny(patterns []string) bool {
+	for _, p := range patterns {
+		if t.Matches(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidatePattern checks that a policy pattern is a class, a MIME type or
+// a MIME type family.
+func ValidatePattern(pattern string) error {
+	if classes[pattern] {
+		return nil
+	}
+	major, minor, ok := strings.Cut(pattern, "/")
+	if !ok || major == "" || minor == "" || major == "*" || strings.ContainsAny(pattern, " ;,") ||
+		(strings.Contains(minor, "*") && minor != "*") {
+		return fmt.Errorf("invalid type %q: use a class, a MIME type or a family such as image/*", pattern)
+	}
+	return nil
+}
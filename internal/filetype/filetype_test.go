@@ -0,0 +1,157 @@
+package filetype
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func zipWith(t *testing.T, names ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range names {
+		var f io.Writer
+		var err error
+		if name == "mimetype" {
+			// ODF and EPUB store the mimetype entry first and uncompressed
+			f, err = zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+		} else {
+			f, err = zw.Create(name)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name == "mimetype" {
+			f.Write([]byte("application/vnd.oasis.opendocument.text"))
+		} else {
+			f.Write([]byte("data"))
+		}
+	}
+	zw.Close()
+	return buf.Bytes()
+}
+
+func TestDetect(t *testing.T) {
+	tar := make([]byte, 512)
+	copy(tar, "file.txt")
+	copy(tar[257:], "ustar\x0000")
+
+	tests := []struct {
+		name  string
+		head  []byte
+		mime  string
+		class string
+		sure  bool
+	}{
+		{"pe", []byte("MZ\x90\x00\x03\x00\x00\x00"), "application/vnd.microsoft.portable-executable", ClassExecutable, true},
+		{"elf", []byte("\x7fELF\x02\x01\x01\x00"), "application/x-elf", ClassExecutable, true},
+		{"macho", []byte("\xcf\xfa\xed\xfe\x07\x00\x00\x01"), "application/x-mach-binary", ClassExecutable, true},
+		{"shebang", []byte("#!/bin/sh\necho hi\n"), "text/x-shellscript", ClassScript, true},
+		{"gzip", []byte("\x1f\x8b\x08\x00"), "application/gzip", ClassArchive, true},
+		{"tar", tar, "application/x-tar", ClassArchive, true},
+		{"zip", zipWith(t, "a.txt"), "application/zip", ClassArchive, true},
+		{"docx", zipWith(t, "[Content_Types].xml", "word/document.xml"), "application/vnd.openxmlformats-officedocument", ClassDocument, true},
+		{"odt", zipWith(t, "mimetype", "content.xml"), "application/vnd.oasis.opendocument.text", ClassDocument, true},
+		{"jar", zipWith(t, "META-INF/MANIFEST.MF"), "application/java-archive", ClassExecutable, true},
+		{"pdf", []byte("%PDF-1.7\n"), "application/pdf", ClassDocument, true},
+		{"png", []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), "image/png", ClassImage, true},
+		{"jpeg", []byte("\xff\xd8\xff\xe0\x00\x10JFIF"), "image/jpeg", ClassImage, true},
+		{"webp", []byte("RIFF\x00\x00\x00\x00WEBPVP8 "), "image/webp", ClassImage, true},
+		{"wav", []byte("RIFF\x00\x00\x00\x00WAVEfmt "), "audio/wav", ClassAudio, true},
+		{"mp4", []byte("\x00\x00\x00\x18ftypisom\x00\x00\x02\x00"), "video/mp4", ClassVideo, true},
+		{"svg", []byte(`<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg"></svg>`), "image/svg+xml", ClassImage, true},
+		{"text", []byte("just some words\n"), "text/plain", ClassText, false},
+		{"html", []byte("<!DOCTYPE html><html></html>"), "text/html", ClassText, false},
+		{"binary", []byte{0x00, 0x01, 0x02, 0x03, 0xfe}, "application/octet-stream", ClassUnknown, false},
+	}
+	for _, tt := range tests {
+		got := Detect(tt.head)
+		if got.MIME != tt.mime || got.Class != tt.class || got.Sure != tt.sure {
+			t.Errorf("%s: Detect = %+v, want %s/%s sure=%v", tt.name, got, tt.mime, tt.class, tt.sure)
+		}
+	}
+}
+
+func TestDetectReader_ReplaysHead(t *testing.T) {
+	content := "%PDF-1.4\n" + strings.Repeat("x", 2*HeadSize)
+	typ, r, err := DetectReader(strings.NewReader(content))
+	if err != nil || typ.MIME != "application/pdf" {
+		t.Fatalf("DetectReader = %+v, %v", typ, err)
+	}
+	data, _ := io.ReadAll(r)
+	if string(data) != content {
+		t.Fatalf("content not replayed: got %d bytes", len(data))
+	}
+}
+
+func TestConsistent(t *testing.T) {
+	pdf := Detect([]byte("%PDF-1.7"))
+	elf := Detect([]byte("\x7fELF"))
+	jpeg := Detect([]byte("\xff\xd8\xff\xe0"))
+	docx := Type{MIME: "application/vnd.openxmlformats-officedocument", Class: ClassDocument, Sure: true}
+	text := Detect([]byte("hello"))
+	script := Detect([]byte("#!/usr/bin/env python3\n"))
+
+	tests := []struct {
+		t        Type
+		declared string
+		want     bool
+	}{
+		{pdf, "application/pdf", true},
+		{pdf, "application/pdf; charset=binary", true},
+		{elf, "application/pdf", false},
+		{jpeg, "image/jpg", true},
+		{jpeg, "image/png", false},
+		{docx, "application/vnd.openxmlformats-officedocument.wordprocessingml.document", true},
+		{text, "application/json", true},
+		{script, "text/x-python", true},
+		{script, "image/png", false},
+	}
+	for _, tt := range tests {
+		if got := tt.t.Consistent(tt.declared); got != tt.want {
+			t.Errorf("%s.Consistent(%q) = %v, want %v", tt.t.MIME, tt.declared, got, tt.want)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	docx := Type{MIME: "application/vnd.openxmlformats-officedocument", Class: ClassDocument, Sure: true}
+	png := Detect([]byte("\x89PNG\r\n\x1a\n"))
+	tests := []struct {
+		t       Type
+		pattern string
+		want    bool
+	}{
+		{png, "image", true},
+		{png, "image/*", true},
+		{png, "image/png", true},
+		{png, "image/jpeg", false},
+		{png, "document", false},
+		{docx, "application/vnd.openxmlformats-officedocument", true},
+		{docx, "application/vnd.openxmlformats", false},
+	}
+	for _, tt := range tests {
+		if got := tt.t.Matches(tt.pattern); got != tt.want {
+			t.Errorf("%s.Matches(%q) = %v, want %v", tt.t.MIME, tt.pattern, got, tt.want)
+		}
+	}
+	if !png.MatchesAny([]string{"document", "image"}) || png.MatchesAny(nil) {
+		t.Error("MatchesAny")
+	}
+}
+
+func TestValidatePattern(t *testing.T) {
+	for _, p := range []string{"executable", "image/*", "application/pdf"} {
+		if err := ValidatePattern(p); err != nil {
+			t.Errorf("ValidatePattern(%q): %v", p, err)
+		}
+	}
+	for _, p := range []string{"", "pdf", "*/*", "image/", "image/p*", "text/plain; charset=utf-8"} {
+		if err := ValidatePattern(p); err == nil {
+			t.Errorf("ValidatePattern(%q): expected error", p)
+		}
+	}
+}
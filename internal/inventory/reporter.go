@@ -97,7 +97,7 @@ func (r *Reporter) generateBucketReport(bucket string) error {
 			modTime.Format(time.RFC3339),
 			meta.ContentType,
 			meta.VersionID,
-			"STANDARD",
+			meta.EffectiveStorageClass(),
 		})
 	}
 	w.Flush()
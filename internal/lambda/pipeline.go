@@ -0,0 +1,492 @@
+package lambda
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eniz1806/VaultS3/internal/metadata"
+	"github.com/eniz1806/VaultS3/internal/notify"
+)
+
+// User metadata VaultS3 sets on objects stored by pipeline steps. Functions
+// that write objects through the S3 API should pass them on as
+// x-amz-meta-* headers, so that runs triggered by those objects count
+// towards the depth limit.
+const (
+	PipelineRunMetaKey   = "vaults3-pipeline-run"
+	PipelineDepthMetaKey = "vaults3-pipeline-depth"
+)
+
+// DefaultMaxPipelineDepth is the depth limit if none is configured.
+const DefaultMaxPipelineDepth = 10
+
+// PipelineContext tells a pipeline step which run it belongs to and what
+// it consumes.
+type PipelineContext struct {
+	RunID      uint64          `json:"run_id"`
+	PipelineID string          `json:"pipeline_id"`
+	Step       string          `json:"step"`
+	Depth      int             `json:"depth"`  // counting the runs that produced the triggering object
+	Source     bool            `json:"source"` // the step consumes the triggering object
+	Inputs     []PipelineInput `json:"inputs,omitempty"`
+}
+
+// PipelineInput is the output of a step that another step consumes. The
+// http runtime receives its body, the process runtime its path.
+type PipelineInput struct {
+	Step        string `json:"step"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type,omitempty"`
+	Body        string `json:"body,omitempty"` // base64
+	Path        string `json:"path,omitempty"`
+
+	path string
+}
+
+// withBodies returns a copy of pc carrying the input bodies.
+func (pc *PipelineContext) withBodies() (*PipelineContext, error) {
+	out := *pc
+	out.Inputs = make([]PipelineInput, len(pc.Inputs))
+	for i, in := range pc.Inputs {
+		data, err := os.ReadFile(in.path)
+		if err != nil {
+			return nil, fmt.Errorf("read output of step %s: %w", in.Step, err)
+		}
+		in.Body = base64.StdEncoding.EncodeToString(data)
+		out.Inputs[i] = in
+	}
+	return &out, nil
+}
+
+// withPaths returns a copy of pc carrying the input paths.
+func (pc *PipelineContext) withPaths() *PipelineContext {
+	if pc == nil {
+		return nil
+	}
+	out := *pc
+	out.Inputs = make([]PipelineInput, len(pc.Inputs))
+	for i, in := range pc.Inputs {
+		in.Path = in.path
+		out.Inputs[i] = in
+	}
+	return &out
+}
+
+// PreparePipeline fills in the implicit dependencies of p's steps and
+// checks that they form an acyclic graph no deeper than maxDepth steps, or
+// DefaultMaxPipelineDepth if maxDepth is not positive.
+func PreparePipeline(p *metadata.LambdaPipeline, maxDepth int) error {
+	if maxDepth <= 0 {
+		maxDepth = DefaultMaxPipelineDepth
+	}
+	if p.ID == "" {
+		return errors.New("pipeline id is required")
+	}
+	if len(p.Steps) == 0 {
+		return fmt.Errorf("pipeline %s has no steps", p.ID)
+	}
+	ids := make(map[string]bool, len(p.Steps))
+	for i := range p.Steps {
+		step := &p.Steps[i]
+		switch {
+		case step.ID == "":
+			return fmt.Errorf("pipeline %s: step %d has no id", p.ID, i+1)
+		case step.ID == metadata.PipelineSource:
+			return fmt.Errorf("pipeline %s: step id %q is reserved", p.ID, metadata.PipelineSource)
+		case strings.ContainsAny(step.ID, `/\`):
+			return fmt.Errorf("pipeline %s: step id %q must not contain slashes", p.ID, step.ID)
+		case ids[step.ID]:
+			return fmt.Errorf("pipeline %s: step %s is defined twice", p.ID, step.ID)
+		}
+		ids[step.ID] = true
+		if len(step.After) == 0 {
+			if i == 0 {
+				step.After = []string{metadata.PipelineSource}
+			} else {
+				step.After = []string{p.Steps[i-1].ID}
+			}
+		}
+	}
+	for _, step := range p.Steps {
+		for _, dep := range step.After {
+			if dep != metadata.PipelineSource && !ids[dep] {
+				return fmt.Errorf("pipeline %s: step %s runs after unknown step %s", p.ID, step.ID, dep)
+			}
+		}
+	}
+	depths, err := stepDepths(p.Steps)
+	if err != nil {
+		return fmt.Errorf("pipeline %s: %w", p.ID, err)
+	}
+	if d := maxStepDepth(depths); d > maxDepth {
+		return fmt.Errorf("pipeline %s is %d steps deep, more than the limit of %d", p.ID, d, maxDepth)
+	}
+	return nil
+}
+
+// stepDepths returns the number of steps on the longest path up to and
+// including each step, or an error if the steps form a cycle.
+func stepDepths(steps []metadata.PipelineStep) (map[string]int, error) {
+	after := make(map[string][]string, len(steps))
+	for _, step := range steps {
+		after[step.ID] = step.After
+	}
+	depths := make(map[string]int, len(steps))
+	visiting := make(map[string]bool)
+	var visit func(id string) (int, error)
+	visit = func(id string) (int, error) {
+		if d, ok := depths[id]; ok {
+			return d, nil
+		}
+		if visiting[id] {
+			return 0, fmt.Errorf("steps form a cycle through %s", id)
+		}
+		visiting[id] = true
+		depth := 1
+		for _, dep := range after[id] {
+			if dep == metadata.PipelineSource {
+				continue
+			}
+			d, err := visit(dep)
+			if err != nil {
+				return 0, err
+			}
+			depth = max(depth, d+1)
+		}
+		visiting[id] = false
+		depths[id] = depth
+		return depth, nil
+	}
+	for _, step := range steps {
+		if _, err := visit(step.ID); err != nil {
+			return nil, err
+		}
+	}
+	return depths, nil
+}
+
+func maxStepDepth(depths map[string]int) int {
+	deepest := 0
+	for _, d := range depths {
+		deepest = max(deepest, d)
+	}
+	return deepest
+}
+
+// stepTrigger returns a trigger that calls the function of a pipeline step.
+func stepTrigger(p metadata.LambdaPipeline, step metadata.PipelineStep) metadata.LambdaTrigger {
+	return metadata.LambdaTrigger{
+		ID:                p.ID + "/" + step.ID,
+		Runtime:           step.Runtime,
+		FunctionURL:       step.FunctionURL,
+		Command:           step.Command,
+		OutputBucket:      step.OutputBucket,
+		OutputKeyTemplate: step.OutputKeyTemplate,
+		IncludeBody:       step.IncludeBody,
+		MaxBodySize:       step.MaxBodySize,
+		Auth:              step.Auth,
+		MaxRetries:        step.MaxRetries,
+		RetryBackoffSecs:  step.RetryBackoffSecs,
+	}
+}
+
+// dispatchPipelines starts a run of each pipeline of e's bucket that
+// matches e.
+func (m *TriggerManager) dispatchPipelines(e notify.Event) {
+	cfg, err := m.store.GetPipelineConfig(e.Bucket)
+	if err != nil {
+		return // no pipelines for this bucket
+	}
+	for _, p := range cfg.Pipelines {
+		if !matchEvent(p.Events, e.Name) || !matchFilter(p.Filters, e.Key) {
+			continue
+		}
+		if _, err := m.startRun(p, e); err != nil {
+			slog.Error("lambda error starting pipeline run", "pipeline_id", p.ID, "bucket", e.Bucket, "key", e.Key, "error", err)
+		}
+	}
+	m.signal()
+}
+
+// StartPipeline runs the pipeline of bucket with the given ID on an existing
+// object, regardless of its event and key filters.
+func (m *TriggerManager) StartPipeline(bucket, pipelineID, key string) (*metadata.PipelineRun, error) {
+	p, err := m.pipeline(bucket, pipelineID)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := m.store.GetObjectMeta(bucket, key)
+	if err != nil {
+		return nil, fmt.Errorf("object %s/%s not found", bucket, key)
+	}
+	run, err := m.startRun(p, notify.Event{
+		Name:        "s3:Pipeline:Run",
+		Bucket:      bucket,
+		Key:         key,
+		Size:        meta.Size,
+		ETag:        meta.ETag,
+		VersionID:   meta.VersionID,
+		PrincipalID: notify.SystemPrincipal,
+	})
+	if err != nil {
+		return nil, err
+	}
+	m.signal()
+	return run, nil
+}
+
+// startRun records a run of p for e and queues its first steps. A run that
+// would exceed the depth limit, counting the runs that produced e's object,
+// is recorded as failed without running any step.
+func (m *TriggerManager) startRun(p metadata.LambdaPipeline, e notify.Event) (*metadata.PipelineRun, error) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	run := &metadata.PipelineRun{
+		Bucket:     e.Bucket,
+		PipelineID: p.ID,
+		EventName:  e.Name,
+		Key:        e.Key,
+		Event:      data,
+		Status:     metadata.PipelineRunning,
+	}
+	if !strings.HasPrefix(e.Name, "s3:ObjectRemoved:") {
+		if meta, err := m.store.GetObjectMeta(e.Bucket, e.Key); err == nil {
+			run.ParentRunID, _ = strconv.ParseUint(meta.UserMetadata[PipelineRunMetaKey], 10, 64)
+			run.BaseDepth, _ = strconv.Atoi(meta.UserMetadata[PipelineDepthMetaKey])
+		}
+	}
+
+	depths, err := stepDepths(p.Steps)
+	if err != nil {
+		return nil, err
+	}
+	for _, step := range p.Steps {
+		run.Steps = append(run.Steps, metadata.PipelineStepRun{ID: step.ID, After: step.After, Depth: depths[step.ID]})
+	}
+	if depth := run.BaseDepth + maxStepDepth(depths); depth > m.maxDepth {
+		run.Status = metadata.PipelineFailed
+		run.Error = fmt.Sprintf("run would be %d steps deep, more than the limit of %d", depth, m.maxDepth)
+		slog.Warn("lambda pipeline run refused", "pipeline_id", p.ID, "bucket", e.Bucket, "key", e.Key, "parent_run_id", run.ParentRunID, "error", run.Error)
+	}
+	if err := m.store.CreatePipelineRun(run); err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+// pipeline returns the current definition of a pipeline.
+func (m *TriggerManager) pipeline(bucket, pipelineID string) (metadata.LambdaPipeline, error) {
+	cfg, err := m.store.GetPipelineConfig(bucket)
+	if err != nil {
+		return metadata.LambdaPipeline{}, fmt.Errorf("no pipelines for bucket %s", bucket)
+	}
+	for _, p := range cfg.Pipelines {
+		if p.ID == pipelineID {
+			return p, nil
+		}
+	}
+	return metadata.LambdaPipeline{}, fmt.Errorf("pipeline %s not found for bucket %s", pipelineID, bucket)
+}
+
+// executeStep runs one step of a pipeline run. On success its output is
+// kept for the steps that consume it, and stored as an object if the step
+// has an output bucket; the run then queues the steps whose inputs are all
+// available. A step that fails after its retries fails the run, and the
+// steps after it are skipped.
+func (m *TriggerManager) executeStep(job metadata.LambdaJob) {
+	run, err := m.store.GetPipelineRun(job.PipelineRunID)
+	if err != nil || run.Finished() {
+		m.ack(job) // cancelled or pruned
+		return
+	}
+	stepRun := run.Step(job.Step)
+	p, err := m.pipeline(job.Bucket, job.TriggerID)
+	var step metadata.PipelineStep
+	if err == nil {
+		err = fmt.Errorf("pipeline %s has no step %s", job.TriggerID, job.Step)
+		for _, s := range p.Steps {
+			if s.ID == job.Step {
+				step, err = s, nil
+			}
+		}
+	}
+	if err == nil && stepRun == nil {
+		err = fmt.Errorf("pipeline run %d has no step %s", run.ID, job.Step)
+	}
+	if err != nil {
+		m.failStep(job, invocationResult{}, err)
+		return
+	}
+	var e notify.Event
+	if err := json.Unmarshal(job.Event, &e); err != nil {
+		m.failStep(job, invocationResult{}, fmt.Errorf("decode event: %w", err))
+		return
+	}
+
+	now := time.Now().UnixNano()
+	m.store.UpdatePipelineStep(run.ID, job.Step, func(s *metadata.PipelineStepRun) {
+		s.Status = metadata.StepRunning
+		s.Attempts = job.Attempts
+		if s.StartedAt == 0 {
+			s.StartedAt = now
+		}
+	})
+
+	pc := &PipelineContext{
+		RunID:      run.ID,
+		PipelineID: p.ID,
+		Step:       step.ID,
+		Depth:      run.BaseDepth + stepRun.Depth,
+	}
+	for _, dep := range stepRun.After {
+		if dep == metadata.PipelineSource {
+			pc.Source = true
+			continue
+		}
+		if d := run.Step(dep); d != nil {
+			pc.Inputs = append(pc.Inputs, PipelineInput{
+				Step:        dep,
+				Size:        d.OutputSize,
+				ContentType: d.ContentType,
+				path:        m.stepOutputPath(run.ID, dep),
+			})
+		}
+	}
+
+	trigger := stepTrigger(p, step)
+	var res invocationResult
+	output, contentType, err := m.call(trigger, e, pc, &res)
+	var outputKey string
+	if err == nil {
+		outputKey = expandTemplate(trigger.OutputKeyTemplate, e.Bucket, e.Key)
+		outputKey = strings.NewReplacer("{run}", strconv.FormatUint(run.ID, 10), "{step}", step.ID).Replace(outputKey)
+		err = m.storeOutput(trigger, outputKey, output, contentType, map[string]string{
+			PipelineRunMetaKey:   strconv.FormatUint(run.ID, 10),
+			PipelineDepthMetaKey: strconv.Itoa(pc.Depth),
+		})
+	}
+	if err == nil {
+		err = m.keepStepOutput(run.ID, step.ID, output)
+	}
+	if err != nil {
+		if m.retry(job, trigger, res, err) {
+			m.store.UpdatePipelineStep(run.ID, job.Step, func(s *metadata.PipelineStepRun) {
+				s.Status = metadata.StepQueued
+				s.Error = err.Error()
+			})
+			return
+		}
+		m.failStep(job, res, err)
+		return
+	}
+
+	m.record(job, res, metadata.InvocationSucceeded, nil)
+	m.ack(job)
+	result := metadata.PipelineStepRun{
+		ID:          step.ID,
+		Status:      metadata.StepSucceeded,
+		Attempts:    job.Attempts,
+		OutputSize:  int64(len(output)),
+		ContentType: contentType,
+		StartedAt:   stepRun.StartedAt,
+	}
+	if result.StartedAt == 0 {
+		result.StartedAt = now
+	}
+	if trigger.OutputBucket != "" && trigger.OutputKeyTemplate != "" {
+		result.OutputKey = trigger.OutputBucket + "/" + outputKey
+	}
+	m.finishStep(run.ID, result)
+}
+
+// failStep fails a step whose attempts are used up. Pipeline jobs are not
+// dead-lettered: the failed run records the failure.
+func (m *TriggerManager) failStep(job metadata.LambdaJob, res invocationResult, cause error) {
+	slog.Error("lambda pipeline step failed", "pipeline_id", job.TriggerID, "step", job.Step, "run_id", job.PipelineRunID, "attempts", job.Attempts, "error", cause)
+	m.record(job, res, metadata.InvocationFailed, cause)
+	m.ack(job)
+	m.finishStep(job.PipelineRunID, metadata.PipelineStepRun{
+		ID:       job.Step,
+		Status:   metadata.StepFailed,
+		Attempts: job.Attempts,
+		Error:    cause.Error(),
+	})
+}
+
+// finishStep records the outcome of a step and removes the kept outputs
+// once the run has ended.
+func (m *TriggerManager) finishStep(runID uint64, result metadata.PipelineStepRun) {
+	run, err := m.store.FinishPipelineStep(runID, result)
+	if err != nil {
+		slog.Error("lambda error recording pipeline step", "run_id", runID, "step", result.ID, "error", err)
+		return
+	}
+	if run.Finished() {
+		m.removeRunOutputs(runID)
+		slog.Info("lambda pipeline run finished", "pipeline_id", run.PipelineID, "run_id", runID, "status", run.Status)
+	}
+}
+
+// PipelineRuns returns the pipeline runs selected by f, newest first.
+func (m *TriggerManager) PipelineRuns(f metadata.PipelineRunFilter) ([]metadata.PipelineRun, error) {
+	return m.store.ListPipelineRuns(f)
+}
+
+// PipelineRun returns the pipeline run with the given ID.
+func (m *TriggerManager) PipelineRun(id uint64) (*metadata.PipelineRun, error) {
+	return m.store.GetPipelineRun(id)
+}
+
+// CancelPipelineRun cancels a running pipeline run. Steps already running
+// finish, but no further steps run.
+func (m *TriggerManager) CancelPipelineRun(id uint64) (*metadata.PipelineRun, error) {
+	run, err := m.store.CancelPipelineRun(id)
+	if err != nil {
+		return nil, err
+	}
+	m.removeRunOutputs(id)
+	return run, nil
+}
+
+// runDir returns the directory holding the step outputs of a run until it
+// ends.
+func (m *TriggerManager) runDir(runID uint64) string {
+	base := m.workDir
+	if base == "" {
+		base = os.TempDir()
+	}
+	return filepath.Join(base, "vaults3-pipeline-runs", strconv.FormatUint(runID, 10))
+}
+
+func (m *TriggerManager) stepOutputPath(runID uint64, step string) string {
+	return filepath.Join(m.runDir(runID), step)
+}
+
+func (m *TriggerManager) keepStepOutput(runID uint64, step string, output []byte) error {
+	if err := os.MkdirAll(m.runDir(runID), 0700); err != nil {
+		return fmt.Errorf("keep step output: %w", err)
+	}
+	if err := os.WriteFile(m.stepOutputPath(runID, step), output, 0600); err != nil {
+		return fmt.Errorf("keep step output: %w", err)
+	}
+	return nil
+}
+
+func (m *TriggerManager) removeRunOutputs(runID uint64) {
+	if err := os.RemoveAll(m.runDir(runID)); err != nil {
+		slog.Error("lambda error removing pipeline outputs", "run_id", runID, "error", err)
+	}
+}
@@ -0,0 +1,202 @@
+package lambda
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/eniz1806/VaultS3/internal/metadata"
+	"github.com/eniz1806/VaultS3/internal/notify"
+)
+
+// newPipelineServer serves functions that append their name to the bodies
+// of their inputs, joined with "|", and fail when named "fail".
+func newPipelineServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/")
+		if name == "fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var event LambdaEvent
+		json.NewDecoder(r.Body).Decode(&event)
+		var parts []string
+		for _, in := range event.Pipeline.Inputs {
+			data, _ := base64.StdEncoding.DecodeString(in.Body)
+			parts = append(parts, string(data))
+		}
+		w.Write([]byte(strings.Join(parts, "|") + name))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func setPipelines(t *testing.T, store *metadata.Store, pipelines ...metadata.LambdaPipeline) {
+	t.Helper()
+	for i := range pipelines {
+		if err := PreparePipeline(&pipelines[i], 0); err != nil {
+			t.Fatalf("PreparePipeline: %v", err)
+		}
+	}
+	if err := store.PutPipelineConfig("b", metadata.BucketPipelineConfig{Pipelines: pipelines}); err != nil {
+		t.Fatalf("PutPipelineConfig: %v", err)
+	}
+}
+
+func waitForRun(t *testing.T, store *metadata.Store) metadata.PipelineRun {
+	t.Helper()
+	var runs []metadata.PipelineRun
+	waitFor(t, "finished run", func() bool {
+		runs, _ = store.ListPipelineRuns(metadata.PipelineRunFilter{Limit: 10})
+		return len(runs) == 1 && runs[0].Finished()
+	})
+	return runs[0]
+}
+
+func TestPipeline_FanOutFanIn(t *testing.T) {
+	srv := newPipelineServer(t)
+	m, store := newTestManager(t)
+	m.workDir = t.TempDir()
+	setPipelines(t, store, metadata.LambdaPipeline{
+		ID:     "media",
+		Events: []string{"s3:ObjectCreated:*"},
+		Steps: []metadata.PipelineStep{
+			{ID: "ingest", FunctionURL: srv.URL + "/I"},
+			{ID: "scan", FunctionURL: srv.URL + "/S"},
+			{ID: "transcode", FunctionURL: srv.URL + "/T"},
+			{ID: "thumbnail", FunctionURL: srv.URL + "/H", After: []string{"scan"}},
+			{ID: "index", FunctionURL: srv.URL + "/X", After: []string{"transcode", "thumbnail"},
+				OutputBucket: "b", OutputKeyTemplate: "out/{run}/{step}"},
+		},
+	})
+
+	m.Dispatch(notify.Event{Name: notify.EventObjectCreatedPut, Bucket: "b", Key: "video.mp4"})
+	run := waitForRun(t, store)
+	if run.Status != metadata.PipelineSucceeded {
+		t.Fatalf("run = %+v", run)
+	}
+	index := run.Step("index")
+	if index.Status != metadata.StepSucceeded || index.Depth != 4 || index.OutputKey != "b/out/1/index" {
+		t.Fatalf("index step = %+v", index)
+	}
+
+	reader, _, err := m.engine.GetObject("b", "out/1/index")
+	if err != nil {
+		t.Fatalf("GetObject output: %v", err)
+	}
+	out, _ := io.ReadAll(reader)
+	reader.Close()
+	if string(out) != "IST|ISHX" {
+		t.Fatalf("output = %q", out)
+	}
+	meta, err := store.GetObjectMeta("b", "out/1/index")
+	if err != nil || meta.UserMetadata[PipelineRunMetaKey] != "1" || meta.UserMetadata[PipelineDepthMetaKey] != "4" {
+		t.Fatalf("output meta = %+v, %v", meta, err)
+	}
+
+	invs, _ := store.ListLambdaInvocations(metadata.LambdaInvocationFilter{RunID: run.ID, Limit: 10})
+	if len(invs) != 5 {
+		t.Fatalf("expected 5 step invocations, got %d", len(invs))
+	}
+	if _, err := os.Stat(m.runDir(run.ID)); !os.IsNotExist(err) {
+		t.Fatalf("step outputs not removed: %v", err)
+	}
+}
+
+func TestPipeline_FailedStepSkipsDependents(t *testing.T) {
+	srv := newPipelineServer(t)
+	m, store := newTestManager(t)
+	m.workDir = t.TempDir()
+	setPipelines(t, store, metadata.LambdaPipeline{
+		ID:     "p",
+		Events: []string{"*"},
+		Steps: []metadata.PipelineStep{
+			{ID: "a", FunctionURL: srv.URL + "/A"},
+			{ID: "bad", FunctionURL: srv.URL + "/fail", MaxRetries: 2},
+			{ID: "after-bad", FunctionURL: srv.URL + "/C"},
+			{ID: "other", FunctionURL: srv.URL + "/D", After: []string{"a"}},
+		},
+	})
+
+	m.Dispatch(notify.Event{Name: notify.EventObjectCreatedPut, Bucket: "b", Key: "k"})
+	run := waitForRun(t, store)
+	if run.Status != metadata.PipelineFailed || !strings.Contains(run.Error, "step bad failed") {
+		t.Fatalf("run = %+v", run)
+	}
+	for id, want := range map[string]string{
+		"a":         metadata.StepSucceeded,
+		"bad":       metadata.StepFailed,
+		"after-bad": metadata.StepSkipped,
+		"other":     metadata.StepSucceeded,
+	} {
+		if got := run.Step(id); got.Status != want {
+			t.Errorf("step %s = %+v, want %s", id, got, want)
+		}
+	}
+	if got := run.Step("bad").Attempts; got != 2 {
+		t.Errorf("bad attempts = %d, want 2", got)
+	}
+	waitFor(t, "empty queue", func() bool { return m.QueueDepth() == 0 })
+	if m.DeadLetterCount() != 0 {
+		t.Error("pipeline steps should not be dead-lettered")
+	}
+}
+
+func TestPipeline_DepthGuard(t *testing.T) {
+	srv := newPipelineServer(t)
+	m, store := newTestManager(t)
+	setPipelines(t, store, metadata.LambdaPipeline{
+		ID:     "p",
+		Events: []string{"*"},
+		Steps: []metadata.PipelineStep{
+			{ID: "a", FunctionURL: srv.URL + "/A"},
+			{ID: "b", FunctionURL: srv.URL + "/B"},
+		},
+	})
+	// The object was written by a run that already went 9 steps deep
+	store.PutObjectMeta(metadata.ObjectMeta{Bucket: "b", Key: "k", UserMetadata: map[string]string{
+		PipelineRunMetaKey:   "7",
+		PipelineDepthMetaKey: "9",
+	}})
+
+	m.Dispatch(notify.Event{Name: notify.EventObjectCreatedPut, Bucket: "b", Key: "k"})
+	run := waitForRun(t, store)
+	if run.Status != metadata.PipelineFailed || run.ParentRunID != 7 || run.BaseDepth != 9 || !strings.Contains(run.Error, "limit of 10") {
+		t.Fatalf("run = %+v", run)
+	}
+	if invs, _ := store.ListLambdaInvocations(metadata.LambdaInvocationFilter{Limit: 10}); len(invs) != 0 {
+		t.Fatalf("refused run invoked %d steps", len(invs))
+	}
+}
+
+func TestPreparePipeline(t *testing.T) {
+	p := metadata.LambdaPipeline{ID: "p", Steps: []metadata.PipelineStep{{ID: "a"}, {ID: "b"}, {ID: "c", After: []string{"source", "a"}}}}
+	if err := PreparePipeline(&p, 0); err != nil {
+		t.Fatalf("PreparePipeline: %v", err)
+	}
+	if got := p.Steps[0].After; len(got) != 1 || got[0] != metadata.PipelineSource {
+		t.Errorf("first step after = %v", got)
+	}
+	if got := p.Steps[1].After; len(got) != 1 || got[0] != "a" {
+		t.Errorf("second step after = %v", got)
+	}
+
+	for name, steps := range map[string][]metadata.PipelineStep{
+		"cycle":     {{ID: "a", After: []string{"b"}}, {ID: "b", After: []string{"a"}}},
+		"unknown":   {{ID: "a", After: []string{"missing"}}},
+		"duplicate": {{ID: "a"}, {ID: "a"}},
+		"reserved":  {{ID: "source"}},
+		"too deep":  {{ID: "a"}, {ID: "b"}, {ID: "c"}},
+	} {
+		p := metadata.LambdaPipeline{ID: "p", Steps: steps}
+		if err := PreparePipeline(&p, 2); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
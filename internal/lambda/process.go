@@ -0,0 +1,151 @@
+package lambda
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eniz1806/VaultS3/internal/config"
+	"github.com/eniz1806/VaultS3/internal/metadata"
+	"github.com/eniz1806/VaultS3/internal/notify"
+)
+
+// maxStderr bounds the stderr kept in the invocation history.
+const maxStderr = 64 << 10
+
+// limitedBuffer keeps the first max bytes written to it and discards the
+// rest, remembering that it did.
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	max       int64
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - int64(b.buf.Len()); int64(len(p)) > room {
+		b.buf.Write(p[:max(room, 0)])
+		b.truncated = true
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+// runProcess runs the trigger's allow-listed command for e in a fresh
+// working directory. The object body is streamed on stdin and the event is
+// passed as JSON in VAULTS3_EVENT and in the file named by
+// VAULTS3_EVENT_FILE. A pipeline step consuming a single step's output gets
+// that on stdin instead; with several inputs, stdin is empty and the event
+// lists their paths. It returns stdout, which becomes the stored output.
+func (m *TriggerManager) runProcess(trigger metadata.LambdaTrigger, e notify.Event, pc *PipelineContext, res *invocationResult) ([]byte, error) {
+	command, ok := m.commands[trigger.Command]
+	if !ok {
+		return nil, fmt.Errorf("lambda command %q is not allow-listed", trigger.Command)
+	}
+
+	workDir, err := os.MkdirTemp(m.workDir, "invocation-")
+	if err != nil {
+		return nil, fmt.Errorf("create working directory: %w", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	event, err := json.Marshal(LambdaEvent{Event: notify.NewS3Event(e, trigger.ID), Pipeline: pc.withPaths()})
+	if err != nil {
+		return nil, fmt.Errorf("marshal event: %w", err)
+	}
+	eventFile := filepath.Join(workDir, "event.json")
+	if err := os.WriteFile(eventFile, event, 0600); err != nil {
+		return nil, fmt.Errorf("write event file: %w", err)
+	}
+
+	timeout := time.Duration(command.TimeoutSecs) * time.Second
+	if timeout <= 0 {
+		timeout = m.timeout
+	}
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	cmd, err := newSandboxedCommand(ctx, command)
+	if err != nil {
+		return nil, err
+	}
+	cmd.Dir = workDir
+	cmd.WaitDelay = 2 * time.Second // don't wait on pipes held by escaped children
+	cmd.Env = []string{
+		"PATH=" + os.Getenv("PATH"),
+		"HOME=" + workDir,
+		"TMPDIR=" + workDir,
+		"VAULTS3_EVENT=" + string(event),
+		"VAULTS3_EVENT_FILE=" + eventFile,
+		"VAULTS3_EVENT_NAME=" + e.Name,
+		"VAULTS3_BUCKET=" + e.Bucket,
+		"VAULTS3_KEY=" + e.Key,
+	}
+	if pc != nil {
+		cmd.Env = append(cmd.Env,
+			"VAULTS3_PIPELINE_RUN="+strconv.FormatUint(pc.RunID, 10),
+			"VAULTS3_PIPELINE_STEP="+pc.Step,
+			"VAULTS3_PIPELINE_DEPTH="+strconv.Itoa(pc.Depth),
+		)
+	}
+	switch {
+	case pc != nil && len(pc.Inputs) == 1 && !pc.Source:
+		f, err := os.Open(pc.Inputs[0].path)
+		if err != nil {
+			return nil, fmt.Errorf("open output of step %s: %w", pc.Inputs[0].Step, err)
+		}
+		defer f.Close()
+		cmd.Stdin = f
+	case (pc == nil || len(pc.Inputs) == 0) && !strings.HasPrefix(e.Name, "s3:ObjectRemoved:"):
+		if reader, _, err := m.engine.GetObject(e.Bucket, e.Key); err == nil {
+			defer reader.Close()
+			cmd.Stdin = reader
+		}
+	}
+	stdout := &limitedBuffer{max: m.maxResponseSize}
+	stderr := &limitedBuffer{max: maxStderr}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	start := time.Now()
+	err = cmd.Run()
+	res.latency = time.Since(start)
+	res.responseSize = int64(stdout.buf.Len())
+	res.stderr = stderr.buf.String()
+	if stderr.truncated {
+		res.stderr += "\n[truncated]"
+	}
+
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		return nil, fmt.Errorf("command %s timed out after %s", command.Name, timeout)
+	case errors.As(err, &exitErr):
+		res.exitCode = exitErr.ExitCode()
+		return nil, fmt.Errorf("command %s failed: %s", command.Name, exitErr)
+	case err != nil:
+		return nil, fmt.Errorf("run command %s: %w", command.Name, err)
+	case stdout.truncated:
+		return nil, fmt.Errorf("command %s output exceeds %d bytes", command.Name, m.maxResponseSize)
+	}
+	return stdout.buf.Bytes(), nil
+}
+
+// commandContentType returns the content type of a command's stored output.
+func commandContentType(command config.LambdaCommandConfig) string {
+	if command.ContentType != "" {
+		return command.ContentType
+	}
+	return "application/octet-stream"
+}
@@ -0,0 +1,37 @@
+//go:build !windows
+
+package lambda
+
+import (
+	"context"
+	"os/exec"
+	"strconv"
+	"syscall"
+
+	"github.com/eniz1806/VaultS3/internal/config"
+)
+
+// newSandboxedCommand prepares command to run in its own process group, so
+// that a timeout kills everything it started. Memory and CPU limits are
+// applied as rlimits by a shell that then execs the command.
+func newSandboxedCommand(ctx context.Context, command config.LambdaCommandConfig) (*exec.Cmd, error) {
+	var cmd *exec.Cmd
+	if command.MaxMemoryMB > 0 || command.MaxCPUSecs > 0 {
+		script := ""
+		if command.MaxMemoryMB > 0 {
+			script += "ulimit -v " + strconv.Itoa(command.MaxMemoryMB*1024) + " || exit 126; "
+		}
+		if command.MaxCPUSecs > 0 {
+			script += "ulimit -t " + strconv.Itoa(command.MaxCPUSecs) + " || exit 126; "
+		}
+		script += `exec "$0" "$@"`
+		cmd = exec.CommandContext(ctx, "/bin/sh", append([]string{"-c", script, command.Path}, command.Args...)...)
+	} else {
+		cmd = exec.CommandContext(ctx, command.Path, command.Args...)
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	return cmd, nil
+}
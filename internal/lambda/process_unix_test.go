@@ -0,0 +1,132 @@
+//go:build !windows
+
+package lambda
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/eniz1806/VaultS3/internal/config"
+	"github.com/eniz1806/VaultS3/internal/metadata"
+	"github.com/eniz1806/VaultS3/internal/notify"
+)
+
+func newProcessManager(t *testing.T, script string, timeoutSecs int) (*TriggerManager, *metadata.Store) {
+	t.Helper()
+	m, store := newTestManager(t, metadata.LambdaTrigger{
+		ID:                "proc",
+		Runtime:           metadata.LambdaRuntimeProcess,
+		Command:           "script",
+		Events:            []string{"*"},
+		OutputBucket:      "b",
+		OutputKeyTemplate: "out/{key}",
+	})
+	m.commands["script"] = config.LambdaCommandConfig{
+		Name:        "script",
+		Path:        "/bin/sh",
+		Args:        []string{"-c", script},
+		ContentType: "text/plain",
+		TimeoutSecs: timeoutSecs,
+	}
+	m.workDir = t.TempDir()
+	return m, store
+}
+
+func putTestObject(t *testing.T, m *TriggerManager, key, body string) notify.Event {
+	t.Helper()
+	if _, _, err := m.engine.PutObject("b", key, strings.NewReader(body), int64(len(body))); err != nil {
+		t.Fatalf("PutObject: %v", err)
+	}
+	return notify.Event{Name: "s3:ObjectCreated:Put", Bucket: "b", Key: key, Size: int64(len(body))}
+}
+
+func TestProcessRuntime_StoresStdoutAndStderr(t *testing.T) {
+	m, store := newProcessManager(t, `tr a-z A-Z; echo "$VAULTS3_KEY" >&2; grep -q '"key":"in.txt"' "$VAULTS3_EVENT_FILE"`, 5)
+	e := putTestObject(t, m, "in.txt", "hello")
+
+	if err := m.InvokeEvent("proc", e); err != nil {
+		t.Fatalf("InvokeEvent: %v", err)
+	}
+	reader, _, err := m.engine.GetObject("b", "out/in.txt")
+	if err != nil {
+		t.Fatalf("GetObject output: %v", err)
+	}
+	defer reader.Close()
+	out, _ := io.ReadAll(reader)
+	if !bytes.Equal(out, []byte("HELLO")) {
+		t.Errorf("output: got %q", out)
+	}
+	meta, err := store.GetObjectMeta("b", "out/in.txt")
+	if err != nil || meta.ContentType != "text/plain" {
+		t.Errorf("output meta: got %+v, %v", meta, err)
+	}
+
+	invs, err := store.ListLambdaInvocations(metadata.LambdaInvocationFilter{Bucket: "b", Limit: 10})
+	if err != nil || len(invs) != 1 {
+		t.Fatalf("invocations: got %d, %v", len(invs), err)
+	}
+	if invs[0].Status != metadata.InvocationSucceeded || invs[0].Stderr != "in.txt\n" {
+		t.Errorf("invocation: got %+v", invs[0])
+	}
+}
+
+func TestProcessRuntime_ExitCode(t *testing.T) {
+	m, store := newProcessManager(t, `echo boom >&2; exit 3`, 5)
+	e := putTestObject(t, m, "in.txt", "x")
+
+	if err := m.InvokeEvent("proc", e); err == nil {
+		t.Fatal("expected error for non-zero exit")
+	}
+	invs, _ := store.ListLambdaInvocations(metadata.LambdaInvocationFilter{Bucket: "b", Limit: 10})
+	if len(invs) != 1 || invs[0].ExitCode != 3 || invs[0].Stderr != "boom\n" || invs[0].Status != metadata.InvocationFailed {
+		t.Errorf("invocation: got %+v", invs)
+	}
+}
+
+func TestProcessRuntime_Timeout(t *testing.T) {
+	m, _ := newProcessManager(t, `sleep 30`, 1)
+	e := putTestObject(t, m, "in.txt", "x")
+
+	err := m.InvokeEvent("proc", e)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("expected timeout, got %v", err)
+	}
+}
+
+func TestProcessRuntime_NotAllowListed(t *testing.T) {
+	m, _ := newProcessManager(t, `true`, 5)
+	delete(m.commands, "script")
+	e := putTestObject(t, m, "in.txt", "x")
+
+	if err := m.InvokeEvent("proc", e); err == nil || !strings.Contains(err.Error(), "not allow-listed") {
+		t.Fatalf("expected allow-list error, got %v", err)
+	}
+}
+
+func TestProcessRuntime_ResourceLimits(t *testing.T) {
+	m, store := newProcessManager(t, `ulimit -t; cat`, 5)
+	cmd := m.commands["script"]
+	cmd.MaxCPUSecs = 7
+	cmd.MaxMemoryMB = 512
+	m.commands["script"] = cmd
+	e := putTestObject(t, m, "in.txt", "body")
+
+	if err := m.InvokeEvent("proc", e); err != nil {
+		t.Fatalf("InvokeEvent: %v", err)
+	}
+	reader, _, err := m.engine.GetObject("b", "out/in.txt")
+	if err != nil {
+		t.Fatalf("GetObject output: %v", err)
+	}
+	defer reader.Close()
+	out, _ := io.ReadAll(reader)
+	if string(out) != "7\nbody" {
+		t.Errorf("output: got %q", out)
+	}
+	invs, _ := store.ListLambdaInvocations(metadata.LambdaInvocationFilter{Bucket: "b", Limit: 10})
+	if len(invs) != 1 || invs[0].Status != metadata.InvocationSucceeded {
+		t.Errorf("invocation: got %+v", invs)
+	}
+}
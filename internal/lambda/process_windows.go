@@ -0,0 +1,20 @@
+//go:build windows
+
+package lambda
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+
+	"github.com/eniz1806/VaultS3/internal/config"
+)
+
+// newSandboxedCommand prepares command to run. Memory and CPU limits are not
+// supported on Windows.
+func newSandboxedCommand(ctx context.Context, command config.LambdaCommandConfig) (*exec.Cmd, error) {
+	if command.MaxMemoryMB > 0 || command.MaxCPUSecs > 0 {
+		return nil, fmt.Errorf("lambda command %s: memory and CPU limits are not supported on windows", command.Name)
+	}
+	return exec.CommandContext(ctx, command.Path, command.Args...), nil
+}
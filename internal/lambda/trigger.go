@@ -5,9 +5,9 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"io"
 	"log/slog"
-	"net/http"
 	"path"
 	"strings"
 	"sync"
@@ -15,172 +15,563 @@ import (
 
 	"github.com/eniz1806/VaultS3/internal/config"
 	"github.com/eniz1806/VaultS3/internal/metadata"
+	"github.com/eniz1806/VaultS3/internal/notify"
 	"github.com/eniz1806/VaultS3/internal/storage"
+	"github.com/eniz1806/VaultS3/internal/webhook"
 )
 
 // LambdaEvent is the payload sent to the function URL.
 type LambdaEvent struct {
-	Event  S3Event `json:"event"`
-	Object string  `json:"object,omitempty"` // base64-encoded body if IncludeBody
+	Event    notify.S3Event   `json:"event"`
+	Object   string           `json:"object,omitempty"`   // base64-encoded body if IncludeBody
+	Pipeline *PipelineContext `json:"pipeline,omitempty"` // set for pipeline steps
 }
 
-// S3Event matches the AWS S3 event notification JSON format.
-type S3Event struct {
-	Records []S3EventRecord `json:"Records"`
-}
-
-type S3EventRecord struct {
-	EventVersion string   `json:"eventVersion"`
-	EventSource  string   `json:"eventSource"`
-	EventTime    string   `json:"eventTime"`
-	EventName    string   `json:"eventName"`
-	S3           S3Detail `json:"s3"`
-}
-
-type S3Detail struct {
-	Bucket S3Bucket `json:"bucket"`
-	Object S3Object `json:"object"`
-}
-
-type S3Bucket struct {
-	Name string `json:"name"`
-}
-
-type S3Object struct {
-	Key       string `json:"key"`
-	Size      int64  `json:"size"`
-	ETag      string `json:"eTag,omitempty"`
-	VersionID string `json:"versionId,omitempty"`
-}
-
-type triggerJob struct {
-	trigger   metadata.LambdaTrigger
-	bucket    string
-	key       string
-	eventType string
-	size      int64
-	etag      string
-	versionID string
-}
+const maxBackoff = 5 * time.Minute
 
-// TriggerManager dispatches S3 events to lambda function URLs.
+// TriggerManager dispatches S3 events to lambda functions: function URLs or,
+// with the process runtime, allow-listed local commands. Dispatch
+// persists a job per matching trigger in a BoltDB queue; workers call the
+// functions, retry failures with exponential backoff and move jobs that
+// still fail after the trigger's retry budget to a dead-letter area. Every
+// call is recorded in the invocation history.
 type TriggerManager struct {
 	store           *metadata.Store
 	engine          storage.Engine
-	client          *http.Client
-	workerCh        chan triggerJob
+	client          *webhook.Client
+	workerCh        chan metadata.LambdaJob
+	wake            chan struct{}
+	stop            chan struct{}
+	stopOnce        sync.Once
 	wg              sync.WaitGroup
 	maxResponseSize int64
+	timeout         time.Duration
+	commands        map[string]config.LambdaCommandConfig // allow-listed for the process runtime
+	workDir         string
+	maxDepth        int // of pipelines
 	maxWorkers      int
+	maxRetries      int
+	batchSize       int
+	poll            time.Duration // queue poll interval, for jobs waiting out a backoff
+	baseBackoff     time.Duration
+	mu              sync.Mutex
+	inflight        map[uint64]bool // jobs handed to workers
+	running         map[string]int  // jobs handed to workers per trigger
 }
 
 // NewTriggerManager creates a new trigger manager.
 func NewTriggerManager(store *metadata.Store, engine storage.Engine, cfg config.LambdaConfig) *TriggerManager {
+	maxWorkers := cfg.MaxWorkers
+	if maxWorkers <= 0 {
+		maxWorkers = 1
+	}
+	queueSize := cfg.QueueSize
+	if queueSize <= 0 {
+		queueSize = 1
+	}
+	maxRetries := cfg.MaxRetries
+	if maxRetries <= 0 {
+		maxRetries = 1
+	}
+	baseBackoff := time.Duration(cfg.RetryBackoffSecs) * time.Second
+	if baseBackoff <= 0 {
+		baseBackoff = time.Second
+	}
+	maxDepth := cfg.MaxPipelineDepth
+	if maxDepth <= 0 {
+		maxDepth = DefaultMaxPipelineDepth
+	}
+	commands := make(map[string]config.LambdaCommandConfig, len(cfg.Commands))
+	for _, c := range cfg.Commands {
+		commands[c.Name] = c
+	}
 	return &TriggerManager{
 		store:           store,
 		engine:          engine,
-		client:          &http.Client{Timeout: time.Duration(cfg.TimeoutSecs) * time.Second},
-		workerCh:        make(chan triggerJob, cfg.QueueSize),
+		client:          webhook.NewClient(time.Duration(cfg.TimeoutSecs) * time.Second),
+		workerCh:        make(chan metadata.LambdaJob, queueSize),
+		wake:            make(chan struct{}, 1),
+		stop:            make(chan struct{}),
 		maxResponseSize: cfg.MaxResponseSize,
-		maxWorkers:      cfg.MaxWorkers,
+		timeout:         time.Duration(cfg.TimeoutSecs) * time.Second,
+		commands:        commands,
+		workDir:         cfg.WorkDir,
+		maxDepth:        maxDepth,
+		maxWorkers:      maxWorkers,
+		maxRetries:      maxRetries,
+		batchSize:       queueSize,
+		poll:            time.Second,
+		baseBackoff:     baseBackoff,
+		inflight:        make(map[uint64]bool),
+		running:         make(map[string]int),
 	}
 }
 
-// Start launches worker goroutines.
+// SetWebhookSecrets sets the named secrets function bearer tokens are read
+// from. It must be called before Start.
+func (m *TriggerManager) SetWebhookSecrets(secrets webhook.Secrets) {
+	m.client.SetSecrets(secrets)
+}
+
+// Start launches the queue scheduler and worker goroutines.
 func (m *TriggerManager) Start(ctx context.Context) {
+	m.wg.Add(1)
+	go m.schedule(ctx)
 	for i := 0; i < m.maxWorkers; i++ {
 		m.wg.Add(1)
 		go func() {
 			defer m.wg.Done()
-			for {
-				select {
-				case <-ctx.Done():
-					return
-				case job, ok := <-m.workerCh:
-					if !ok {
-						return
-					}
-					m.executeTrigger(job)
-				}
+			for job := range m.workerCh {
+				m.execute(job)
 			}
 		}()
 	}
 }
 
-// Stop closes the work channel and waits for workers to drain.
+// Stop waits for the workers to finish the jobs handed to them. Jobs still
+// in the queue run after a restart.
 func (m *TriggerManager) Stop() {
-	close(m.workerCh)
+	m.stopOnce.Do(func() { close(m.stop) })
 	m.wg.Wait()
 }
 
-// QueueDepth returns the current number of pending jobs.
+// QueueDepth returns the number of queued jobs.
 func (m *TriggerManager) QueueDepth() int {
-	return len(m.workerCh)
+	pending, _, _ := m.store.LambdaQueueCounts()
+	return pending
 }
 
-// Dispatch checks lambda configs for the bucket and enqueues matching triggers.
-func (m *TriggerManager) Dispatch(bucket, key, eventType string, size int64, etag, versionID string) {
-	cfg, err := m.store.GetLambdaConfig(bucket)
+// DeadLetterCount returns the number of dead-lettered jobs.
+func (m *TriggerManager) DeadLetterCount() int {
+	_, dead, _ := m.store.LambdaQueueCounts()
+	return dead
+}
+
+// Dispatch checks lambda configs for the bucket and queues a job for each
+// matching trigger, and starts a run of each matching pipeline.
+func (m *TriggerManager) Dispatch(e notify.Event) {
+	m.dispatchPipelines(e)
+	cfg, err := m.store.GetLambdaConfig(e.Bucket)
 	if err != nil {
 		return // no lambda config for this bucket
 	}
 
+	var jobs []metadata.LambdaJob
 	for _, trigger := range cfg.Triggers {
-		if !matchEvent(trigger.Events, eventType) {
+		if !matchEvent(trigger.Events, e.Name) {
 			continue
 		}
-		if !matchFilter(trigger.Filters, key) {
+		if !matchFilter(trigger.Filters, e.Key) {
+			continue
+		}
+		job, err := newJob(trigger.ID, e)
+		if err != nil {
+			slog.Error("lambda error encoding event", "trigger_id", trigger.ID, "error", err)
 			continue
 		}
+		jobs = append(jobs, job)
+	}
+	if len(jobs) == 0 {
+		return
+	}
+	if err := m.store.EnqueueLambdaJobs(jobs); err != nil {
+		slog.Error("lambda error queueing triggers", "bucket", e.Bucket, "key", e.Key, "error", err)
+		return
+	}
+	m.signal()
+}
+
+func newJob(triggerID string, e notify.Event) (metadata.LambdaJob, error) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return metadata.LambdaJob{}, err
+	}
+	return metadata.LambdaJob{
+		Bucket:    e.Bucket,
+		TriggerID: triggerID,
+		EventName: e.Name,
+		Key:       e.Key,
+		Event:     data,
+	}, nil
+}
+
+// Invoke synchronously calls the trigger of bucket with the given ID for one
+// object, regardless of its event and key filters. Batch jobs use it to run a
+// function over the objects of a manifest.
+func (m *TriggerManager) Invoke(bucket, triggerID, key string, size int64, etag, versionID string) error {
+	return m.InvokeEvent(triggerID, notify.Event{
+		Name:        "s3:BatchOperation:Invoke",
+		Bucket:      bucket,
+		Key:         key,
+		Size:        size,
+		ETag:        etag,
+		VersionID:   versionID,
+		PrincipalID: notify.SystemPrincipal,
+	})
+}
+
+// InvokeEvent synchronously calls the trigger of e's bucket with the given ID
+// for e, regardless of its event and key filters. Event replay uses it to
+// re-deliver recorded events. The call is recorded in the invocation history
+// but not retried.
+func (m *TriggerManager) InvokeEvent(triggerID string, e notify.Event) error {
+	trigger, err := m.trigger(e.Bucket, triggerID)
+	if err != nil {
+		return err
+	}
+	job, err := newJob(triggerID, e)
+	if err != nil {
+		return err
+	}
+	job.Attempts = 1
+	res, err := m.invoke(trigger, e)
+	status := metadata.InvocationSucceeded
+	if err != nil {
+		status = metadata.InvocationFailed
+	}
+	m.record(job, res, status, err)
+	return err
+}
+
+// Retry queues the event of a recorded invocation for its trigger again.
+func (m *TriggerManager) Retry(invocationID uint64) error {
+	inv, err := m.store.GetLambdaInvocation(invocationID)
+	if err != nil {
+		return err
+	}
+	if inv.PipelineRunID != 0 {
+		return fmt.Errorf("invocation %d is a step of pipeline run %d; start a new run instead", invocationID, inv.PipelineRunID)
+	}
+	if _, err := m.trigger(inv.Bucket, inv.TriggerID); err != nil {
+		return err
+	}
+	err = m.store.EnqueueLambdaJobs([]metadata.LambdaJob{{
+		Bucket:    inv.Bucket,
+		TriggerID: inv.TriggerID,
+		EventName: inv.EventName,
+		Key:       inv.Key,
+		Event:     inv.Event,
+	}})
+	if err != nil {
+		return err
+	}
+	m.signal()
+	return nil
+}
+
+// Invocations returns the invocation history selected by f, newest first.
+func (m *TriggerManager) Invocations(f metadata.LambdaInvocationFilter) ([]metadata.LambdaInvocation, error) {
+	return m.store.ListLambdaInvocations(f)
+}
+
+// DeadLetters returns up to limit dead-lettered jobs, optionally only those
+// of one bucket or trigger.
+func (m *TriggerManager) DeadLetters(bucket, triggerID string, limit int) ([]metadata.LambdaJob, error) {
+	return m.store.ListLambdaDeadLetters(bucket, triggerID, limit)
+}
+
+// ReplayDeadLetters queues dead-lettered jobs again: those with the given
+// IDs, or all of them if ids is empty, optionally only those of one bucket
+// or trigger. It returns how many were queued.
+func (m *TriggerManager) ReplayDeadLetters(ids []uint64, bucket, triggerID string) (int, error) {
+	n, err := m.store.ReplayLambdaDeadLetters(ids, bucket, triggerID)
+	if n > 0 {
+		m.signal()
+	}
+	return n, err
+}
+
+// PurgeDeadLetters deletes dead-lettered jobs, selected as for
+// ReplayDeadLetters, and returns how many were deleted.
+func (m *TriggerManager) PurgeDeadLetters(ids []uint64, bucket, triggerID string) (int, error) {
+	return m.store.PurgeLambdaDeadLetters(ids, bucket, triggerID)
+}
+
+// signal wakes the scheduler without blocking.
+func (m *TriggerManager) signal() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// schedule hands due jobs to the workers until stopped, then closes the
+// worker channel.
+func (m *TriggerManager) schedule(ctx context.Context) {
+	defer m.wg.Done()
+	defer close(m.workerCh)
+	ticker := time.NewTicker(m.poll)
+	defer ticker.Stop()
+	for {
+		if !m.scheduleDue(ctx) {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.stop:
+			return
+		case <-m.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// scheduleDue hands the due jobs that are not already in flight, and whose
+// triggers are below their concurrency limit, to the workers. It returns
+// false once the manager is stopping.
+func (m *TriggerManager) scheduleDue(ctx context.Context) bool {
+	configs, err := m.store.ListLambdaConfigs()
+	if err != nil {
+		slog.Error("lambda error reading trigger configs", "error", err)
+		return true
+	}
+	limits := make(map[string]int)
+	for bucket, cfg := range configs {
+		for _, t := range cfg.Triggers {
+			limits[triggerKey(bucket, t.ID)] = t.MaxConcurrency
+		}
+	}
 
-		job := triggerJob{
-			trigger:   trigger,
-			bucket:    bucket,
-			key:       key,
-			eventType: eventType,
-			size:      size,
-			etag:      etag,
-			versionID: versionID,
+	m.mu.Lock()
+	picked := make(map[string]int)
+	jobs, err := m.store.DequeueLambdaJobs(m.batchSize, time.Now().UnixNano(), func(job metadata.LambdaJob) bool {
+		if m.inflight[job.ID] {
+			return true
+		}
+		key := triggerKey(job.Bucket, job.TriggerID)
+		if limit := limits[key]; job.PipelineRunID == 0 && limit > 0 && m.running[key]+picked[key] >= limit {
+			return true
 		}
+		picked[key]++
+		return false
+	})
+	for _, job := range jobs {
+		m.inflight[job.ID] = true
+		m.running[triggerKey(job.Bucket, job.TriggerID)]++
+	}
+	m.mu.Unlock()
+	if err != nil {
+		slog.Error("lambda error reading queue", "error", err)
+		return true
+	}
 
-		// Non-blocking send — drop if queue is full
+	for i, job := range jobs {
 		select {
 		case m.workerCh <- job:
-		default:
-			slog.Warn("lambda queue full, dropping trigger", "trigger_id", trigger.ID, "bucket", bucket, "key", key)
+		case <-ctx.Done():
+			m.release(jobs[i:]...)
+			return false
+		case <-m.stop:
+			m.release(jobs[i:]...)
+			return false
 		}
 	}
+	return true
 }
 
-func (m *TriggerManager) executeTrigger(job triggerJob) {
-	event := S3Event{
-		Records: []S3EventRecord{{
-			EventVersion: "2.1",
-			EventSource:  "vaults3",
-			EventTime:    time.Now().UTC().Format(time.RFC3339),
-			EventName:    job.eventType,
-			S3: S3Detail{
-				Bucket: S3Bucket{Name: job.bucket},
-				Object: S3Object{
-					Key:       job.key,
-					Size:      job.size,
-					ETag:      job.etag,
-					VersionID: job.versionID,
-				},
-			},
-		}},
+// release forgets jobs handed to workers once they are done.
+func (m *TriggerManager) release(jobs ...metadata.LambdaJob) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, job := range jobs {
+		delete(m.inflight, job.ID)
+		key := triggerKey(job.Bucket, job.TriggerID)
+		if m.running[key]--; m.running[key] <= 0 {
+			delete(m.running, key)
+		}
 	}
+}
 
-	lambdaEvent := LambdaEvent{Event: event}
+func triggerKey(bucket, triggerID string) string {
+	return bucket + "\x00" + triggerID
+}
+
+// trigger returns the current configuration of a trigger.
+func (m *TriggerManager) trigger(bucket, triggerID string) (metadata.LambdaTrigger, error) {
+	cfg, err := m.store.GetLambdaConfig(bucket)
+	if err != nil {
+		return metadata.LambdaTrigger{}, fmt.Errorf("no lambda triggers for bucket %s", bucket)
+	}
+	for _, trigger := range cfg.Triggers {
+		if trigger.ID == triggerID {
+			return trigger, nil
+		}
+	}
+	return metadata.LambdaTrigger{}, fmt.Errorf("lambda trigger %s not found for bucket %s", triggerID, bucket)
+}
+
+// execute runs a queued job and records the outcome in the queue: removed
+// on success, rescheduled with backoff on failure, or dead-lettered once the
+// trigger's attempts are used up. The trigger is looked up at execution time
+// so that configuration changes apply to queued jobs.
+func (m *TriggerManager) execute(job metadata.LambdaJob) {
+	defer func() {
+		m.release(job)
+		m.signal() // a concurrency slot is free
+	}()
+
+	job.Attempts++
+	if job.PipelineRunID != 0 {
+		m.executeStep(job)
+		return
+	}
+	trigger, err := m.trigger(job.Bucket, job.TriggerID)
+	if err != nil {
+		m.deadLetter(job, invocationResult{}, err)
+		return
+	}
+	var e notify.Event
+	if err := json.Unmarshal(job.Event, &e); err != nil {
+		m.deadLetter(job, invocationResult{}, fmt.Errorf("decode event: %w", err))
+		return
+	}
+
+	res, err := m.invoke(trigger, e)
+	if err == nil {
+		m.record(job, res, metadata.InvocationSucceeded, nil)
+		m.ack(job)
+		return
+	}
+	if !m.retry(job, trigger, res, err) {
+		m.deadLetter(job, res, err)
+	}
+}
+
+func (m *TriggerManager) ack(job metadata.LambdaJob) {
+	if err := m.store.AckLambdaJob(job.ID); err != nil {
+		slog.Error("lambda error acking job", "id", job.ID, "error", err)
+	}
+}
+
+// retry reschedules a failed job with backoff and reports whether it did;
+// it does not once the trigger's attempts are used up.
+func (m *TriggerManager) retry(job metadata.LambdaJob, trigger metadata.LambdaTrigger, res invocationResult, cause error) bool {
+	maxRetries := trigger.MaxRetries
+	if maxRetries <= 0 {
+		maxRetries = m.maxRetries
+	}
+	if job.Attempts >= maxRetries {
+		return false
+	}
+	m.record(job, res, metadata.InvocationRetrying, cause)
+	next := time.Now().Add(m.backoff(trigger, job.Attempts)).UnixNano()
+	if err := m.store.NackLambdaJob(job.ID, job.Attempts, next, cause.Error()); err != nil {
+		slog.Error("lambda error rescheduling job", "id", job.ID, "error", err)
+	}
+	return true
+}
+
+func (m *TriggerManager) deadLetter(job metadata.LambdaJob, res invocationResult, cause error) {
+	slog.Error("lambda trigger failed, dead-lettering", "trigger_id", job.TriggerID, "bucket", job.Bucket, "key", job.Key, "attempts", job.Attempts, "error", cause)
+	m.record(job, res, metadata.InvocationDeadLettered, cause)
+	if err := m.store.DeadLetterLambdaJob(job.ID, job.Attempts, cause.Error()); err != nil {
+		slog.Error("lambda error dead-lettering job", "id", job.ID, "error", err)
+	}
+}
+
+// backoff returns the delay before the attempt following the given number
+// of failed attempts, doubling each time up to maxBackoff.
+func (m *TriggerManager) backoff(trigger metadata.LambdaTrigger, attempts int) time.Duration {
+	delay := m.baseBackoff
+	if trigger.RetryBackoffSecs > 0 {
+		delay = time.Duration(trigger.RetryBackoffSecs) * time.Second
+	}
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+	return delay
+}
+
+// record adds a call to the invocation history.
+func (m *TriggerManager) record(job metadata.LambdaJob, res invocationResult, status string, cause error) {
+	inv := metadata.LambdaInvocation{
+		Bucket:       job.Bucket,
+		TriggerID:    job.TriggerID,
+		EventName:    job.EventName,
+		Key:          job.Key,
+		JobID:        job.ID,
+		Attempt:      job.Attempts,
+		Status:       status,
+		StatusCode:   res.statusCode,
+		LatencyMs:    res.latency.Milliseconds(),
+		ResponseSize: res.responseSize,
+		ExitCode:     res.exitCode,
+		Stderr:       res.stderr,
+		Event:        job.Event,
+
+		PipelineRunID: job.PipelineRunID,
+		Step:          job.Step,
+	}
+	if cause != nil {
+		inv.Error = cause.Error()
+	}
+	if err := m.store.AppendLambdaInvocation(inv); err != nil {
+		slog.Error("lambda error recording invocation", "trigger_id", job.TriggerID, "error", err)
+	}
+}
+
+// invocationResult describes one function call.
+type invocationResult struct {
+	statusCode   int // http runtime
+	exitCode     int // process runtime
+	stderr       string
+	latency      time.Duration
+	responseSize int64
+}
+
+// invoke calls the trigger's function for e and stores its output if the
+// trigger has an output bucket.
+func (m *TriggerManager) invoke(trigger metadata.LambdaTrigger, e notify.Event) (invocationResult, error) {
+	var res invocationResult
+	output, contentType, err := m.call(trigger, e, nil, &res)
+	if err != nil {
+		return res, err
+	}
+	outputKey := expandTemplate(trigger.OutputKeyTemplate, e.Bucket, e.Key)
+	return res, m.storeOutput(trigger, outputKey, output, contentType, nil)
+}
+
+// call runs the trigger's function for e, as a step of a pipeline run if pc
+// is not nil, and returns its output and the output's content type.
+func (m *TriggerManager) call(trigger metadata.LambdaTrigger, e notify.Event, pc *PipelineContext, res *invocationResult) ([]byte, string, error) {
+	switch trigger.Runtime {
+	case metadata.LambdaRuntimeProcess:
+		output, err := m.runProcess(trigger, e, pc, res)
+		return output, commandContentType(m.commands[trigger.Command]), err
+	case "", metadata.LambdaRuntimeHTTP:
+		return m.callFunction(trigger, e, pc, res)
+	default:
+		return nil, "", fmt.Errorf("unknown lambda runtime %q", trigger.Runtime)
+	}
+}
+
+// callFunction POSTs the event to the trigger's function URL and returns the
+// response body and its content type.
+func (m *TriggerManager) callFunction(trigger metadata.LambdaTrigger, e notify.Event, pc *PipelineContext, res *invocationResult) ([]byte, string, error) {
+	lambdaEvent := LambdaEvent{Event: notify.NewS3Event(e, trigger.ID)}
+	if pc != nil {
+		withBodies, err := pc.withBodies()
+		if err != nil {
+			return nil, "", err
+		}
+		lambdaEvent.Pipeline = withBodies
+	}
 
 	// Include object body if configured
-	if job.trigger.IncludeBody && job.eventType != "s3:ObjectRemoved:Delete" {
-		maxBody := job.trigger.MaxBodySize
+	if trigger.IncludeBody && (pc == nil || pc.Source) && !strings.HasPrefix(e.Name, "s3:ObjectRemoved:") {
+		maxBody := trigger.MaxBodySize
 		if maxBody <= 0 {
 			maxBody = 1 << 20 // 1MB default
 		}
-		if job.size <= maxBody {
-			reader, _, err := m.engine.GetObject(job.bucket, job.key)
+		if e.Size <= maxBody {
+			reader, _, err := m.engine.GetObject(e.Bucket, e.Key)
 			if err == nil {
 				data, err := io.ReadAll(io.LimitReader(reader, maxBody+1))
 				reader.Close()
@@ -193,62 +584,64 @@ func (m *TriggerManager) executeTrigger(job triggerJob) {
 
 	payload, err := json.Marshal(lambdaEvent)
 	if err != nil {
-		slog.Error("lambda error marshaling event", "trigger_id", job.trigger.ID, "error", err)
-		return
+		return nil, "", fmt.Errorf("marshal event: %w", err)
 	}
 
-	resp, err := m.client.Post(job.trigger.FunctionURL, "application/json", bytes.NewReader(payload))
+	start := time.Now()
+	resp, err := m.client.Post(context.Background(), trigger.FunctionURL, "application/json", payload, trigger.Auth)
 	if err != nil {
-		slog.Error("lambda function call failed", "url", job.trigger.FunctionURL, "trigger_id", job.trigger.ID, "error", err)
-		return
+		res.latency = time.Since(start)
+		return nil, "", fmt.Errorf("call %s: %w", trigger.FunctionURL, err)
 	}
 	defer resp.Body.Close()
+	res.statusCode = resp.StatusCode
 
-	if resp.StatusCode >= 300 {
-		slog.Warn("lambda trigger returned error status", "trigger_id", job.trigger.ID, "status", resp.StatusCode, "url", job.trigger.FunctionURL)
-		return
+	responseBody, err := io.ReadAll(io.LimitReader(resp.Body, m.maxResponseSize))
+	res.latency = time.Since(start)
+	res.responseSize = int64(len(responseBody))
+	if err != nil {
+		return nil, "", fmt.Errorf("read response: %w", err)
 	}
 
-	// Store response as new object if output bucket is configured
-	if job.trigger.OutputBucket != "" && job.trigger.OutputKeyTemplate != "" {
-		responseBody, err := io.ReadAll(io.LimitReader(resp.Body, m.maxResponseSize))
-		if err != nil {
-			slog.Error("lambda error reading response", "trigger_id", job.trigger.ID, "error", err)
-			return
-		}
-
-		outputKey := expandTemplate(job.trigger.OutputKeyTemplate, job.bucket, job.key)
+	if resp.StatusCode >= 300 {
+		return nil, "", fmt.Errorf("function %s returned status %d", trigger.FunctionURL, resp.StatusCode)
+	}
+	return responseBody, resp.Header.Get("Content-Type"), nil
+}
 
+// storeOutput stores a function's output as a new object under outputKey if
+// the trigger has an output bucket.
+func (m *TriggerManager) storeOutput(trigger metadata.LambdaTrigger, outputKey string, output []byte, contentType string, userMeta map[string]string) error {
+	if trigger.OutputBucket != "" && trigger.OutputKeyTemplate != "" {
 		// Validate output key doesn't contain path traversal
 		for _, segment := range strings.Split(outputKey, "/") {
 			if segment == ".." {
-				slog.Error("lambda output key contains path traversal", "trigger_id", job.trigger.ID, "key", outputKey)
-				return
+				return fmt.Errorf("output key %q contains path traversal", outputKey)
 			}
 		}
 
-		contentType := resp.Header.Get("Content-Type")
 		if contentType == "" {
 			contentType = "application/octet-stream"
 		}
 
-		_, _, err = m.engine.PutObject(job.trigger.OutputBucket, outputKey, bytes.NewReader(responseBody), int64(len(responseBody)))
+		_, _, err := m.engine.PutObject(trigger.OutputBucket, outputKey, bytes.NewReader(output), int64(len(output)))
 		if err != nil {
-			slog.Error("lambda error storing output", "trigger_id", job.trigger.ID, "bucket", job.trigger.OutputBucket, "key", outputKey, "error", err)
-			return
+			return fmt.Errorf("store output %s/%s: %w", trigger.OutputBucket, outputKey, err)
 		}
 
 		// Update metadata
 		m.store.PutObjectMeta(metadata.ObjectMeta{
-			Bucket:       job.trigger.OutputBucket,
+			Bucket:       trigger.OutputBucket,
 			Key:          outputKey,
 			ContentType:  contentType,
-			Size:         int64(len(responseBody)),
+			Size:         int64(len(output)),
 			LastModified: time.Now().Unix(),
+			UserMetadata: userMeta,
 		})
 
-		slog.Info("lambda trigger stored output", "trigger_id", job.trigger.ID, "bucket", job.trigger.OutputBucket, "key", outputKey, "bytes", len(responseBody))
+		slog.Info("lambda trigger stored output", "trigger_id", trigger.ID, "bucket", trigger.OutputBucket, "key", outputKey, "bytes", len(output))
 	}
+	return nil
 }
 
 // expandTemplate expands {bucket}, {key}, {ext} placeholders in the output key template.
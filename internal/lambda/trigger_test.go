@@ -0,0 +1,162 @@
+package lambda
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/eniz1806/VaultS3/internal/config"
+	"github.com/eniz1806/VaultS3/internal/metadata"
+	"github.com/eniz1806/VaultS3/internal/notify"
+	"github.com/eniz1806/VaultS3/internal/storage"
+)
+
+func newTestManager(t *testing.T, triggers ...metadata.LambdaTrigger) (*TriggerManager, *metadata.Store) {
+	t.Helper()
+	dir := t.TempDir()
+	store, err := metadata.NewStore(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	engine, err := storage.NewFileSystem(filepath.Join(dir, "data"))
+	if err != nil {
+		t.Fatalf("NewFileSystem: %v", err)
+	}
+	store.CreateBucket("b")
+	if err := store.PutLambdaConfig("b", metadata.BucketLambdaConfig{Triggers: triggers}); err != nil {
+		t.Fatalf("PutLambdaConfig: %v", err)
+	}
+
+	m := NewTriggerManager(store, engine, config.LambdaConfig{
+		MaxResponseSize: 1 << 20,
+		TimeoutSecs:     5,
+		MaxWorkers:      4,
+		QueueSize:       16,
+		MaxRetries:      3,
+	})
+	m.poll = 10 * time.Millisecond
+	m.baseBackoff = time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	m.Start(ctx)
+	t.Cleanup(func() {
+		cancel()
+		m.Stop()
+	})
+	return m, store
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestTriggerManager_RetriesThenSucceeds(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("done"))
+	}))
+	defer srv.Close()
+
+	m, store := newTestManager(t, metadata.LambdaTrigger{ID: "fn", FunctionURL: srv.URL, Events: []string{"s3:ObjectCreated:*"}})
+	m.Dispatch(notify.Event{Name: notify.EventObjectCreatedPut, Bucket: "b", Key: "k"})
+
+	var invocations []metadata.LambdaInvocation
+	waitFor(t, "3 invocations", func() bool {
+		invocations, _ = store.ListLambdaInvocations(metadata.LambdaInvocationFilter{Limit: 10})
+		return len(invocations) == 3
+	})
+	if got := invocations[0]; got.Status != metadata.InvocationSucceeded || got.Attempt != 3 || got.ResponseSize != 4 || got.StatusCode != 200 {
+		t.Fatalf("last invocation = %+v", got)
+	}
+	if got := invocations[2]; got.Status != metadata.InvocationRetrying || got.StatusCode != 503 || got.Error == "" {
+		t.Fatalf("first invocation = %+v", got)
+	}
+	waitFor(t, "empty queue", func() bool { return m.QueueDepth() == 0 })
+}
+
+func TestTriggerManager_DeadLetterAndReplay(t *testing.T) {
+	var fail atomic.Bool
+	fail.Store(true)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	m, store := newTestManager(t, metadata.LambdaTrigger{ID: "fn", FunctionURL: srv.URL, Events: []string{"*"}, MaxRetries: 2})
+	m.Dispatch(notify.Event{Name: notify.EventObjectCreatedPut, Bucket: "b", Key: "k"})
+
+	waitFor(t, "dead letter", func() bool { return m.DeadLetterCount() == 1 })
+	dead, _ := m.DeadLetters("b", "fn", 10)
+	if len(dead) != 1 || dead[0].Attempts != 2 {
+		t.Fatalf("dead letters = %+v", dead)
+	}
+	failed, _ := store.ListLambdaInvocations(metadata.LambdaInvocationFilter{Status: metadata.InvocationDeadLettered, Limit: 10})
+	if len(failed) != 1 {
+		t.Fatalf("dead-lettered invocations = %+v", failed)
+	}
+
+	fail.Store(false)
+	if n, err := m.ReplayDeadLetters(nil, "", ""); err != nil || n != 1 {
+		t.Fatalf("ReplayDeadLetters = %d, %v", n, err)
+	}
+	waitFor(t, "successful replay", func() bool {
+		ok, _ := store.ListLambdaInvocations(metadata.LambdaInvocationFilter{Status: metadata.InvocationSucceeded, Limit: 10})
+		return len(ok) == 1
+	})
+
+	// Retry a recorded invocation
+	if err := m.Retry(failed[0].ID); err != nil {
+		t.Fatalf("Retry: %v", err)
+	}
+	waitFor(t, "retried invocation", func() bool {
+		ok, _ := store.ListLambdaInvocations(metadata.LambdaInvocationFilter{Status: metadata.InvocationSucceeded, Limit: 10})
+		return len(ok) == 2
+	})
+}
+
+func TestTriggerManager_ConcurrencyLimit(t *testing.T) {
+	var mu sync.Mutex
+	var active, peak int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		active++
+		if active > peak {
+			peak = active
+		}
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		active--
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	m, _ := newTestManager(t, metadata.LambdaTrigger{ID: "fn", FunctionURL: srv.URL, Events: []string{"*"}, MaxConcurrency: 1})
+	for i := 0; i < 5; i++ {
+		m.Dispatch(notify.Event{Name: notify.EventObjectCreatedPut, Bucket: "b", Key: "k"})
+	}
+	waitFor(t, "empty queue", func() bool { return m.QueueDepth() == 0 })
+	mu.Lock()
+	defer mu.Unlock()
+	if peak != 1 {
+		t.Fatalf("peak concurrency = %d, want 1", peak)
+	}
+}
@@ -1,6 +1,7 @@
 package lifecycle
 
 import (
+	"bytes"
 	"io"
 	"path/filepath"
 	"testing"
@@ -8,6 +9,7 @@ import (
 
 	"github.com/eniz1806/VaultS3/internal/metadata"
 	"github.com/eniz1806/VaultS3/internal/storage"
+	"github.com/eniz1806/VaultS3/internal/tiering"
 )
 
 // mockEngine implements storage.Engine for testing.
@@ -219,6 +221,43 @@ func TestLifecycleMatchingLogic(t *testing.T) {
 			versioning: "Enabled",
 			shouldSkip: true,
 		},
+		{
+			name:       "expiration date reached",
+			meta:       metadata.ObjectMeta{Bucket: "b", Key: "new.txt", LastModified: now},
+			rule:       metadata.LifecycleRule{Status: "Enabled", ExpirationDate: time.Unix(now, 0).UTC().Format("2006-01-02") + "T00:00:00Z"},
+			shouldSkip: false,
+		},
+		{
+			name:       "expiration date in the future",
+			meta:       metadata.ObjectMeta{Bucket: "b", Key: "old.txt", LastModified: now - 400*86400},
+			rule:       metadata.LifecycleRule{Status: "Enabled", ExpirationDate: "2999-01-01T00:00:00Z"},
+			shouldSkip: true,
+		},
+		{
+			name: "and filter matches prefix, all tags and size bounds",
+			meta: metadata.ObjectMeta{
+				Bucket: "b", Key: "logs/a.log", Size: 500, LastModified: now - 2*86400,
+				Tags: map[string]string{"env": "prod", "team": "ops", "extra": "x"},
+			},
+			rule: metadata.LifecycleRule{
+				Status: "Enabled", ExpirationDays: 1, FilterForm: metadata.LifecycleFilterAnd, Prefix: "logs/",
+				TagFilter:             map[string]string{"env": "prod", "team": "ops"},
+				ObjectSizeGreaterThan: 100, ObjectSizeLessThan: 1000,
+			},
+			shouldSkip: false,
+		},
+		{
+			name: "and filter missing one tag",
+			meta: metadata.ObjectMeta{
+				Bucket: "b", Key: "logs/a.log", Size: 500, LastModified: now - 2*86400,
+				Tags: map[string]string{"env": "prod"},
+			},
+			rule: metadata.LifecycleRule{
+				Status: "Enabled", ExpirationDays: 1, FilterForm: metadata.LifecycleFilterAnd, Prefix: "logs/",
+				TagFilter: map[string]string{"env": "prod", "team": "ops"},
+			},
+			shouldSkip: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -239,8 +278,7 @@ func shouldSkipObject(meta metadata.ObjectMeta, rule *metadata.LifecycleRule, no
 	}
 
 	// Check if object is expired
-	expiryTime := meta.LastModified + int64(rule.ExpirationDays)*86400
-	if expiryTime > now {
+	if !expirationDue(rule, &meta, now) {
 		return true // not expired yet
 	}
 
@@ -295,3 +333,335 @@ func TestScan_PrunesAuditEntries(t *testing.T) {
 		t.Errorf("expected 0 entries after pruning, got %d", len(entries))
 	}
 }
+
+// mockMover records lifecycle transitions.
+type mockMover struct {
+	moved map[string]string
+}
+
+func (m *mockMover) Transition(meta metadata.ObjectMeta, storageClass string) error {
+	m.moved[meta.Key+"@"+meta.VersionID] = storageClass
+	return nil
+}
+
+func (m *mockMover) RemoveObject(metadata.ObjectMeta) error { return nil }
+
+func TestScan_Transitions(t *testing.T) {
+	store := newTestStore(t)
+	now := time.Now().UTC()
+	day := int64(86400)
+
+	store.CreateBucket("mybucket")
+	store.PutLifecycleConfig("mybucket", metadata.LifecycleConfig{Rules: []metadata.LifecycleRule{{
+		Status: "Enabled",
+		Prefix: "logs/",
+		Transitions: []metadata.LifecycleTransition{
+			{Days: 30, StorageClass: "STANDARD_IA"},
+			{Days: 60, StorageClass: "GLACIER"},
+		},
+		NoncurrentVersionTransitions: []metadata.NoncurrentVersionTransition{
+			{NoncurrentDays: 7, StorageClass: "DEEP_ARCHIVE"},
+		},
+	}, {
+		Status:      "Enabled",
+		Prefix:      "dated/",
+		Transitions: []metadata.LifecycleTransition{{Date: now.AddDate(0, 0, -1).Format(time.RFC3339), StorageClass: "GLACIER_IR"}},
+	}}})
+
+	put := func(meta metadata.ObjectMeta) {
+		meta.Bucket = "mybucket"
+		store.PutObjectMeta(meta)
+	}
+	put(metadata.ObjectMeta{Key: "logs/old", LastModified: now.Unix() - 90*day})
+	put(metadata.ObjectMeta{Key: "logs/month", LastModified: now.Unix() - 40*day})
+	put(metadata.ObjectMeta{Key: "logs/new", LastModified: now.Unix() - day})
+	put(metadata.ObjectMeta{Key: "logs/archived", LastModified: now.Unix() - 40*day, Tier: "cold", StorageClass: "GLACIER"})
+	put(metadata.ObjectMeta{Key: "other/old", LastModified: now.Unix() - 90*day})
+	put(metadata.ObjectMeta{Key: "dated/file", LastModified: now.Unix()})
+
+	// Versioned key: v1 became noncurrent 10 days ago when v2 was written,
+	// v2 only 2 days ago
+	for _, v := range []metadata.ObjectMeta{
+		{Key: "logs/v", VersionID: "v1", LastModified: now.Unix() - 20*day},
+		{Key: "logs/v", VersionID: "v2", LastModified: now.Unix() - 10*day},
+		{Key: "logs/v", VersionID: "v3", LastModified: now.Unix() - 2*day, IsLatest: true},
+	} {
+		v.Bucket = "mybucket"
+		store.PutObjectVersion(v)
+	}
+
+	mover := &mockMover{moved: make(map[string]string)}
+	w := NewWorker(store, &mockEngine{}, 3600, 0)
+	w.SetTierMover(mover)
+	w.scan()
+
+	want := map[string]string{
+		"logs/old@":   "GLACIER",
+		"logs/month@": "STANDARD_IA",
+		"dated/file@": "GLACIER_IR",
+		"logs/v@v1":   "DEEP_ARCHIVE",
+	}
+	if len(mover.moved) != len(want) {
+		t.Errorf("transitions: got %v, want %v", mover.moved, want)
+	}
+	for k, class := range want {
+		if mover.moved[k] != class {
+			t.Errorf("%s: got %q, want %q", k, mover.moved[k], class)
+		}
+	}
+}
+
+func TestScan_ExpiresTransitionedObject(t *testing.T) {
+	store := newTestStore(t)
+	dir := t.TempDir()
+	hot, err := storage.NewFileSystem(filepath.Join(dir, "hot"))
+	if err != nil {
+		t.Fatalf("NewFileSystem: %v", err)
+	}
+	cold, err := storage.NewFileSystem(filepath.Join(dir, "cold"))
+	if err != nil {
+		t.Fatalf("NewFileSystem: %v", err)
+	}
+
+	store.CreateBucket("mybucket")
+	hot.CreateBucketDir("mybucket")
+	hot.PutObject("mybucket", "a", bytes.NewReader([]byte("data")), 4)
+	meta := metadata.ObjectMeta{Bucket: "mybucket", Key: "a", Size: 4, LastModified: 1}
+	store.PutObjectMeta(meta)
+
+	mgr := tiering.NewManager(store, hot, cold, 30, 3600)
+	if err := mgr.Transition(meta, "GLACIER"); err != nil {
+		t.Fatalf("Transition: %v", err)
+	}
+	// A restored hot copy with its pending expiry
+	hot.PutObject("mybucket", "a", bytes.NewReader([]byte("data")), 4)
+	store.PutRestoreRequest(metadata.RestoreRequest{Bucket: "mybucket", Key: "a", Tier: "Standard", Days: 1, ExpiresAt: time.Now().Unix() + 86400})
+
+	store.PutLifecycleConfig("mybucket", metadata.LifecycleConfig{Rules: []metadata.LifecycleRule{{
+		Status:         "Enabled",
+		ExpirationDays: 1,
+	}}})
+	w := NewWorker(store, hot, 3600, 0)
+	w.SetTierMover(mgr)
+	w.scan()
+
+	if _, err := store.GetObjectMeta("mybucket", "a"); err == nil {
+		t.Error("object metadata should be deleted")
+	}
+	if _, _, err := cold.GetObject("mybucket", "a"); err == nil {
+		t.Error("cold copy should be deleted")
+	}
+	if _, _, err := hot.GetObject("mybucket", "a"); err == nil {
+		t.Error("restored copy should be deleted")
+	}
+	if req, _ := store.GetRestoreRequest("mybucket", "a", ""); req != nil {
+		t.Errorf("restore request should be deleted: %+v", req)
+	}
+}
+
+func TestScan_TransitionsIgnoredWithoutMover(t *testing.T) {
+	store := newTestStore(t)
+	engine := &mockEngine{}
+
+	store.CreateBucket("mybucket")
+	store.PutLifecycleConfig("mybucket", metadata.LifecycleConfig{Rules: []metadata.LifecycleRule{{
+		Status:      "Enabled",
+		Transitions: []metadata.LifecycleTransition{{Days: 0, StorageClass: "GLACIER"}},
+	}}})
+	store.PutObjectMeta(metadata.ObjectMeta{Bucket: "mybucket", Key: "a", LastModified: 1})
+
+	w := NewWorker(store, engine, 3600, 0)
+	w.scan()
+
+	meta, err := store.GetObjectMeta("mybucket", "a")
+	if err != nil || meta.EffectiveStorageClass() != "STANDARD" {
+		t.Errorf("object should be untouched without a tier mover: %+v %v", meta, err)
+	}
+}
+
+func TestScan_NoncurrentDaysWithNewerVersions(t *testing.T) {
+	store := newTestStore(t)
+	now := time.Now().UTC().Unix()
+	day := int64(86400)
+
+	store.CreateBucket("mybucket")
+	store.PutLifecycleConfig("mybucket", metadata.LifecycleConfig{Rules: []metadata.LifecycleRule{{
+		Status:                          "Enabled",
+		NoncurrentVersionExpirationDays: 5,
+		MaxNoncurrentVersions:           1,
+	}}})
+	// v4 is current; v3 is past the age cutoff but is the newest noncurrent
+	// version, so only v2 and v1 expire
+	for _, v := range []metadata.ObjectMeta{
+		{Key: "k", VersionID: "v1", LastModified: now - 8*day},
+		{Key: "k", VersionID: "v2", LastModified: now - 7*day},
+		{Key: "k", VersionID: "v3", LastModified: now - 6*day},
+		{Key: "k", VersionID: "v4", LastModified: now, IsLatest: true},
+	} {
+		v.Bucket = "mybucket"
+		store.PutObjectVersion(v)
+	}
+
+	w := NewWorker(store, &mockEngine{}, 3600, 0)
+	w.scan()
+
+	for id, wantKept := range map[string]bool{"v1": false, "v2": false, "v3": true, "v4": true} {
+		_, err := store.GetObjectVersion("mybucket", "k", id)
+		if kept := err == nil; kept != wantKept {
+			t.Errorf("version %s: kept=%v, want %v", id, kept, wantKept)
+		}
+	}
+}
+
+func TestScan_NoncurrentAgeFromSuccessor(t *testing.T) {
+	store := newTestStore(t)
+	now := time.Now().UTC().Unix()
+	day := int64(86400)
+
+	store.CreateBucket("mybucket")
+	store.PutLifecycleConfig("mybucket", metadata.LifecycleConfig{Rules: []metadata.LifecycleRule{{
+		Status:                          "Enabled",
+		NoncurrentVersionExpirationDays: 5,
+	}}})
+	// v1 was written 30 days ago but only became noncurrent when v2 was
+	// written a day ago, so nothing has been noncurrent for 5 days
+	for _, v := range []metadata.ObjectMeta{
+		{Key: "k", VersionID: "v1", LastModified: now - 30*day},
+		{Key: "k", VersionID: "v2", LastModified: now - 1*day},
+		{Key: "k", VersionID: "v3", LastModified: now, IsLatest: true},
+	} {
+		v.Bucket = "mybucket"
+		store.PutObjectVersion(v)
+	}
+
+	w := NewWorker(store, &mockEngine{}, 3600, 0)
+	w.scan()
+
+	for _, id := range []string{"v1", "v2", "v3"} {
+		if _, err := store.GetObjectVersion("mybucket", "k", id); err != nil {
+			t.Errorf("version %s should be kept: noncurrent for less than 5 days", id)
+		}
+	}
+}
+
+func TestScan_Paged(t *testing.T) {
+	defer func(n int) { planPageSize = n }(planPageSize)
+	planPageSize = 2
+
+	store := newTestStore(t)
+	now := time.Now().UTC().Unix()
+	day := int64(86400)
+
+	store.CreateBucket("mybucket")
+	store.PutLifecycleConfig("mybucket", metadata.LifecycleConfig{Rules: []metadata.LifecycleRule{
+		{Status: "Enabled", Prefix: "tmp/", ExpirationDays: 1},
+		{Status: "Enabled", MaxNoncurrentVersions: 1},
+	}})
+	for _, key := range []string{"keep", "tmp/a", "tmp/b", "tmp/c", "tmp/d", "tmp/e"} {
+		store.PutObjectMeta(metadata.ObjectMeta{Bucket: "mybucket", Key: key, LastModified: now - 2*day})
+	}
+	// Three versions per key, so every key's versions span a page boundary
+	for _, key := range []string{"v/a", "v/b", "v/c"} {
+		for i, id := range []string{"1", "2", "3"} {
+			store.PutObjectVersion(metadata.ObjectMeta{
+				Bucket: "mybucket", Key: key, VersionID: id, LastModified: now - int64(3-i)*day, IsLatest: id == "3",
+			})
+		}
+	}
+
+	engine := &mockEngine{}
+	w := NewWorker(store, engine, 3600, 0)
+	w.scan()
+
+	if len(engine.deleted) != 5 {
+		t.Errorf("expected 5 expired objects, got %v", engine.deleted)
+	}
+	if _, err := store.GetObjectMeta("mybucket", "keep"); err != nil {
+		t.Error("object outside the rule prefix should be kept")
+	}
+	for _, key := range []string{"v/a", "v/b", "v/c"} {
+		for id, wantKept := range map[string]bool{"1": false, "2": true, "3": true} {
+			_, err := store.GetObjectVersion("mybucket", key, id)
+			if kept := err == nil; kept != wantKept {
+				t.Errorf("%s version %s: kept=%v, want %v", key, id, kept, wantKept)
+			}
+		}
+	}
+}
+
+func TestPreview(t *testing.T) {
+	store := newTestStore(t)
+	now := time.Now().UTC().Unix()
+	day := int64(86400)
+
+	store.CreateBucket("mybucket")
+	store.PutObjectMeta(metadata.ObjectMeta{Bucket: "mybucket", Key: "tmp/a", Size: 10, LastModified: now - 10*day})
+	store.PutObjectMeta(metadata.ObjectMeta{Bucket: "mybucket", Key: "tmp/b", Size: 20, LastModified: now - 10*day})
+	store.PutObjectMeta(metadata.ObjectMeta{Bucket: "mybucket", Key: "tmp/new", Size: 5, LastModified: now})
+	store.PutObjectMeta(metadata.ObjectMeta{Bucket: "mybucket", Key: "logs/x", Size: 100, LastModified: now - 40*day})
+	for _, v := range []metadata.ObjectMeta{
+		{Key: "v/k", VersionID: "v1", Size: 7, LastModified: now - 9*day},
+		{Key: "v/k", VersionID: "v2", Size: 8, LastModified: now - 2*day, IsLatest: true},
+	} {
+		v.Bucket = "mybucket"
+		store.PutObjectVersion(v)
+	}
+	store.CreateMultipartUpload(metadata.MultipartUpload{UploadID: "u1", Bucket: "mybucket", Key: "big", CreatedAt: now - 3*day})
+	store.PutPart("u1", metadata.PartInfo{PartNumber: 1, Size: 1000})
+	store.PutPart("u1", metadata.PartInfo{PartNumber: 2, Size: 500})
+
+	cfg := &metadata.LifecycleConfig{Rules: []metadata.LifecycleRule{
+		{ID: "tmp", Status: "Enabled", Prefix: "tmp/", ExpirationDays: 7},
+		{ID: "logs", Status: "Enabled", Prefix: "logs/", Transitions: []metadata.LifecycleTransition{{Days: 30, StorageClass: "GLACIER"}}},
+		{ID: "versions", Status: "Enabled", Prefix: "v/", NoncurrentVersionExpirationDays: 1},
+		{ID: "uploads", Status: "Enabled", AbortIncompleteMultipartDays: 1},
+		{ID: "off", Status: "Disabled", ExpirationDays: 1},
+	}}
+
+	result := Preview(store, "mybucket", cfg, true)
+	if len(result.Rules) != 5 {
+		t.Fatalf("rules: got %d, want 5", len(result.Rules))
+	}
+	check := func(rule int, action string, count int, bytes int64) *PreviewAction {
+		t.Helper()
+		a := result.Rules[rule].Actions[action]
+		if a == nil {
+			t.Fatalf("rule %d: no %s action in %+v", rule, action, result.Rules[rule].Actions)
+		}
+		if a.Count != count || a.Bytes != bytes {
+			t.Errorf("rule %d %s: got count=%d bytes=%d, want %d/%d", rule, action, a.Count, a.Bytes, count, bytes)
+		}
+		return a
+	}
+	if a := check(0, ActionExpire, 2, 30); len(a.SampleKeys) != 2 {
+		t.Errorf("expire samples: got %v", a.SampleKeys)
+	}
+	if a := check(1, ActionTransition, 1, 100); a.StorageClasses["GLACIER"] != 1 {
+		t.Errorf("transition classes: got %v", a.StorageClasses)
+	}
+	if a := check(2, ActionNoncurrentExpire, 1, 7); a.SampleKeys[0] != "v/k?versionId=v1" {
+		t.Errorf("noncurrent samples: got %v", a.SampleKeys)
+	}
+	if a := check(3, ActionAbortMultipart, 1, 1500); a.SampleKeys[0] != "big?uploadId=u1" {
+		t.Errorf("multipart samples: got %v", a.SampleKeys)
+	}
+	if len(result.Rules[4].Actions) != 0 {
+		t.Errorf("disabled rule should do nothing: %+v", result.Rules[4].Actions)
+	}
+
+	// Without a tier mover transitions are not evaluated
+	if a := Preview(store, "mybucket", cfg, false).Rules[1].Actions[ActionTransition]; a != nil {
+		t.Errorf("transitions should be skipped: %+v", a)
+	}
+
+	// Nothing was changed
+	if _, err := store.GetObjectMeta("mybucket", "tmp/a"); err != nil {
+		t.Errorf("tmp/a removed by preview: %v", err)
+	}
+	if _, err := store.GetObjectVersion("mybucket", "v/k", "v1"); err != nil {
+		t.Errorf("v1 removed by preview: %v", err)
+	}
+	if uploads, _ := store.ListMultipartUploads("mybucket"); len(uploads) != 1 {
+		t.Errorf("uploads: got %d, want 1", len(uploads))
+	}
+}
@@ -0,0 +1,315 @@
+package lifecycle
+
+import (
+	"log/slog"
+	"sort"
+	"strings"
+
+	"github.com/eniz1806/VaultS3/internal/metadata"
+)
+
+// Lifecycle actions, as reported by Preview.
+const (
+	ActionExpire               = "expire"
+	ActionNoncurrentExpire     = "noncurrent_expire"
+	ActionDeleteMarkerCleanup  = "delete_marker_cleanup"
+	ActionTransition           = "transition"
+	ActionNoncurrentTransition = "noncurrent_transition"
+	ActionAbortMultipart       = "abort_multipart"
+)
+
+// plannedAction is a single lifecycle action chosen for an object, version or
+// multipart upload. rule is the index of the rule that selected it.
+type plannedAction struct {
+	bucket       string
+	rule         int
+	action       string
+	meta         metadata.ObjectMeta
+	upload       metadata.MultipartUpload
+	storageClass string
+	size         int64
+}
+
+// planPageSize is the number of objects, versions or uploads the planner
+// reads per page.
+var planPageSize = 1000
+
+// planner evaluates lifecycle configs against the metadata store without
+// changing anything. It streams its actions a page at a time: the worker
+// applies each page, Preview only reports it. Each page's read transaction is
+// closed before its actions are handed out, so they may change the store.
+type planner struct {
+	store       *metadata.Store
+	now         int64
+	transitions bool // whether a TierMover is available
+}
+
+// plan evaluates cfg against the metadata of bucket and calls fn with the
+// chosen actions, one page at a time: first current object expirations and
+// transitions, then noncurrent versions and delete markers, then incomplete
+// multipart uploads.
+func (p *planner) plan(bucket string, cfg *metadata.LifecycleConfig, fn func([]plannedAction)) {
+	versioning, _ := p.store.GetBucketVersioning(bucket)
+
+	// 1. Current objects: expiration, otherwise transitions
+	for after := ""; ; {
+		metas, err := p.store.ListObjectMetaAfter(bucket, "", after, planPageSize)
+		if err != nil {
+			slog.Error("lifecycle error listing objects", "bucket", bucket, "error", err)
+			break
+		}
+		var actions []plannedAction
+		for i := range metas {
+			if a, ok := p.planObject(bucket, cfg, versioning, &metas[i]); ok {
+				actions = append(actions, a)
+			}
+		}
+		if len(actions) > 0 {
+			fn(actions)
+		}
+		if len(metas) < planPageSize {
+			break
+		}
+		after = metas[len(metas)-1].Key
+	}
+
+	// 2. Noncurrent versions and expired delete markers. Versions are listed
+	// by key, so a key's versions are planned together once the listing has
+	// moved past them.
+	if p.needsVersions(cfg) {
+		var group []metadata.ObjectMeta
+		keyMarker, versionMarker := "", ""
+		for {
+			versions, truncated, err := p.store.ListObjectVersions(bucket, "", keyMarker, versionMarker, planPageSize)
+			if err != nil {
+				slog.Error("lifecycle error listing versions", "bucket", bucket, "error", err)
+				break
+			}
+			var actions []plannedAction
+			for _, v := range versions {
+				if len(group) > 0 && group[0].Key != v.Key {
+					actions = p.planVersions(actions, bucket, cfg, group)
+					group = group[:0]
+				}
+				group = append(group, v)
+			}
+			if !truncated || len(versions) == 0 {
+				if len(group) > 0 {
+					actions = p.planVersions(actions, bucket, cfg, group)
+				}
+				if len(actions) > 0 {
+					fn(actions)
+				}
+				break
+			}
+			if len(actions) > 0 {
+				fn(actions)
+			}
+			last := versions[len(versions)-1]
+			keyMarker, versionMarker = last.Key, last.VersionID
+		}
+	}
+
+	// 3. Incomplete multipart uploads
+	p.planAborts(bucket, cfg, fn)
+}
+
+// planObject chooses the action for a current object: the first due
+// expiration, otherwise the coldest due transition across all rules.
+func (p *planner) planObject(bucket string, cfg *metadata.LifecycleConfig, versioning string, meta *metadata.ObjectMeta) (plannedAction, bool) {
+	if meta.DeleteMarker {
+		return plannedAction{}, false
+	}
+	for i := range cfg.Rules {
+		rule := &cfg.Rules[i]
+		if rule.Status != "Enabled" || !matchRule(rule, meta) || !expirationDue(rule, meta, p.now) {
+			continue
+		}
+		if meta.LegalHold || (meta.RetentionMode != "" && meta.RetentionUntil > p.now) {
+			continue
+		}
+		if versioning == "Enabled" && meta.VersionID != "" {
+			continue
+		}
+		return plannedAction{bucket: bucket, rule: i, action: ActionExpire, meta: *meta, size: meta.Size}, true
+	}
+
+	if !p.transitions {
+		return plannedAction{}, false
+	}
+	var best plannedAction
+	found := false
+	for i := range cfg.Rules {
+		rule := &cfg.Rules[i]
+		if rule.Status != "Enabled" || !matchRule(rule, meta) {
+			continue
+		}
+		class, ok := dueTransition(rule, meta, p.now)
+		if !ok || (found && storageClassRank[best.storageClass] >= storageClassRank[class]) {
+			continue
+		}
+		best = plannedAction{bucket: bucket, rule: i, action: ActionTransition, meta: *meta, storageClass: class, size: meta.Size}
+		found = true
+	}
+	return best, found
+}
+
+// planAborts calls fn with the incomplete multipart uploads of bucket that a
+// rule aborts, a page at a time.
+func (p *planner) planAborts(bucket string, cfg *metadata.LifecycleConfig, fn func([]plannedAction)) {
+	aborted := make(map[string]bool)
+	var uploads []metadata.MultipartUpload
+	loaded := false
+	var actions []plannedAction
+	for i := range cfg.Rules {
+		rule := &cfg.Rules[i]
+		if rule.Status != "Enabled" || rule.AbortIncompleteMultipartDays <= 0 {
+			continue
+		}
+		if !loaded {
+			uploads, _ = p.store.ListMultipartUploads(bucket)
+			loaded = true
+		}
+		cutoff := p.now - int64(rule.AbortIncompleteMultipartDays)*86400
+		for _, upload := range uploads {
+			if aborted[upload.UploadID] || upload.CreatedAt >= cutoff {
+				continue
+			}
+			if rule.Prefix != "" && !strings.HasPrefix(upload.Key, rule.Prefix) {
+				continue
+			}
+			aborted[upload.UploadID] = true
+			var size int64
+			if parts, err := p.store.ListParts(upload.UploadID); err == nil {
+				for _, part := range parts {
+					size += part.Size
+				}
+			}
+			actions = append(actions, plannedAction{
+				bucket: bucket, rule: i, action: ActionAbortMultipart, upload: upload, size: size,
+			})
+			if len(actions) == planPageSize {
+				fn(actions)
+				actions = nil
+			}
+		}
+	}
+	if len(actions) > 0 {
+		fn(actions)
+	}
+}
+
+// needsVersions reports whether any enabled rule acts on noncurrent versions
+// or delete markers.
+func (p *planner) needsVersions(cfg *metadata.LifecycleConfig) bool {
+	for i := range cfg.Rules {
+		rule := &cfg.Rules[i]
+		if rule.Status != "Enabled" {
+			continue
+		}
+		if rule.NoncurrentVersionExpirationDays > 0 || rule.MaxNoncurrentVersions > 0 || rule.ExpiredObjectDeleteMarker {
+			return true
+		}
+		if p.transitions && len(rule.NoncurrentVersionTransitions) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// planVersions appends the actions for the versions of one key to actions.
+// Rules apply in order and versions removed by an earlier rule are not seen
+// by later ones. The coldest due transition across all rules wins, unless a
+// rule removes the version.
+func (p *planner) planVersions(actions []plannedAction, bucket string, cfg *metadata.LifecycleConfig, versions []metadata.ObjectMeta) []plannedAction {
+	// Newest first
+	sort.Slice(versions, func(a, b int) bool {
+		return versions[a].LastModified > versions[b].LastModified
+	})
+
+	removed := make(map[string]bool)
+	transitions := make(map[string]plannedAction) // by version ID
+	for i := range cfg.Rules {
+		rule := &cfg.Rules[i]
+		if rule.Status != "Enabled" || (rule.Prefix != "" && !strings.HasPrefix(versions[0].Key, rule.Prefix)) {
+			continue
+		}
+		var live []metadata.ObjectMeta
+		for _, v := range versions {
+			if !removed[v.VersionID] {
+				live = append(live, v)
+			}
+		}
+		actions = p.planRuleVersions(actions, bucket, i, rule, live, removed, transitions)
+	}
+	for _, v := range versions {
+		if t, ok := transitions[v.VersionID]; ok && !removed[v.VersionID] {
+			actions = append(actions, t)
+		}
+	}
+	return actions
+}
+
+// planRuleVersions applies one rule to the versions of a key, newest first.
+func (p *planner) planRuleVersions(actions []plannedAction, bucketName string, ruleIdx int, rule *metadata.LifecycleRule,
+	versions []metadata.ObjectMeta, removed map[string]bool, transitions map[string]plannedAction) []plannedAction {
+	if len(versions) == 0 {
+		return actions
+	}
+	hasNoncurrentExpiry := rule.NoncurrentVersionExpirationDays > 0
+	hasMaxVersions := rule.MaxNoncurrentVersions > 0
+
+	// Noncurrent version expiration by age and/or count. With both set, like
+	// S3's NoncurrentDays plus NewerNoncurrentVersions, a version must be past
+	// the age cutoff and beyond the newest MaxNoncurrentVersions noncurrent
+	// versions. A version's noncurrent age counts from when the next newer
+	// version was written, as for noncurrent transitions.
+	if hasNoncurrentExpiry || hasMaxVersions {
+		cutoff := p.now - int64(rule.NoncurrentVersionExpirationDays)*86400
+		for j, v := range versions[1:] {
+			if hasMaxVersions && j < rule.MaxNoncurrentVersions {
+				continue
+			}
+			if hasNoncurrentExpiry && versions[j].LastModified >= cutoff {
+				continue
+			}
+			if !v.DeleteMarker && !matchRule(rule, &v) {
+				continue
+			}
+			removed[v.VersionID] = true
+			actions = append(actions, plannedAction{
+				bucket: bucketName, rule: ruleIdx, action: ActionNoncurrentExpire, meta: v, size: v.Size,
+			})
+		}
+	}
+
+	// Noncurrent version transitions: a version becomes noncurrent when the
+	// next newer version is written
+	if p.transitions && len(rule.NoncurrentVersionTransitions) > 0 {
+		for j := 1; j < len(versions); j++ {
+			v := versions[j]
+			if v.DeleteMarker || removed[v.VersionID] || !matchRule(rule, &v) {
+				continue
+			}
+			class, ok := dueNoncurrentTransition(rule, &v, versions[j-1].LastModified, j-1, p.now)
+			if !ok {
+				continue
+			}
+			if t, seen := transitions[v.VersionID]; seen && storageClassRank[t.storageClass] >= storageClassRank[class] {
+				continue
+			}
+			transitions[v.VersionID] = plannedAction{
+				bucket: bucketName, rule: ruleIdx, action: ActionNoncurrentTransition, meta: v, storageClass: class, size: v.Size,
+			}
+		}
+	}
+
+	// Expired delete marker cleanup: remove delete markers with no noncurrent versions
+	if rule.ExpiredObjectDeleteMarker && len(versions) == 1 && versions[0].DeleteMarker && !removed[versions[0].VersionID] {
+		removed[versions[0].VersionID] = true
+		actions = append(actions, plannedAction{
+			bucket: bucketName, rule: ruleIdx, action: ActionDeleteMarkerCleanup, meta: versions[0],
+		})
+	}
+	return actions
+}
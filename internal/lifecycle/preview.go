@@ -0,0 +1,92 @@
+package lifecycle
+
+import (
+	"time"
+
+	"github.com/eniz1806/VaultS3/internal/metadata"
+)
+
+// previewSampleKeys caps the sample keys reported per rule and action.
+const previewSampleKeys = 10
+
+// PreviewAction summarises what one rule would do for one action.
+type PreviewAction struct {
+	Count          int              `json:"count"`
+	Bytes          int64            `json:"bytes"`
+	SampleKeys     []string         `json:"sample_keys"`
+	StorageClasses map[string]int64 `json:"storage_classes,omitempty"`
+}
+
+// PreviewRule is the dry-run outcome of a single lifecycle rule.
+type PreviewRule struct {
+	ID      string                    `json:"id"`
+	Index   int                       `json:"index"`
+	Status  string                    `json:"status"`
+	Actions map[string]*PreviewAction `json:"actions"`
+}
+
+// PreviewResult is the dry-run outcome of a lifecycle config for a bucket.
+type PreviewResult struct {
+	Bucket      string        `json:"bucket"`
+	EvaluatedAt int64         `json:"evaluated_at"`
+	Transitions bool          `json:"transitions_enabled"`
+	Rules       []PreviewRule `json:"rules"`
+}
+
+// Preview evaluates cfg against the current metadata of bucket with the same
+// logic as the worker's scan, without changing anything. Only counts, bytes
+// and a few sample keys per action are kept, so large buckets can be
+// previewed. Transition actions
+// are only evaluated when transitions is true, as the worker only applies them
+// with a TierMover.
+func Preview(store *metadata.Store, bucket string, cfg *metadata.LifecycleConfig, transitions bool) *PreviewResult {
+	now := time.Now().UTC().Unix()
+	result := &PreviewResult{
+		Bucket:      bucket,
+		EvaluatedAt: now,
+		Transitions: transitions,
+		Rules:       make([]PreviewRule, len(cfg.Rules)),
+	}
+	for i, rule := range cfg.Rules {
+		result.Rules[i] = PreviewRule{ID: rule.ID, Index: i, Status: rule.Status, Actions: map[string]*PreviewAction{}}
+	}
+
+	p := &planner{store: store, now: now, transitions: transitions}
+	p.plan(bucket, cfg, func(actions []plannedAction) {
+		for _, a := range actions {
+			result.add(a)
+		}
+	})
+	return result
+}
+
+func (r *PreviewResult) add(a plannedAction) {
+	rule := &r.Rules[a.rule]
+	pa, ok := rule.Actions[a.action]
+	if !ok {
+		pa = &PreviewAction{SampleKeys: []string{}}
+		rule.Actions[a.action] = pa
+	}
+	pa.Count++
+	pa.Bytes += a.size
+	if a.storageClass != "" {
+		if pa.StorageClasses == nil {
+			pa.StorageClasses = make(map[string]int64)
+		}
+		pa.StorageClasses[a.storageClass]++
+	}
+	if len(pa.SampleKeys) < previewSampleKeys {
+		pa.SampleKeys = append(pa.SampleKeys, sampleKey(a))
+	}
+}
+
+// sampleKey names the object, version or upload an action applies to.
+func sampleKey(a plannedAction) string {
+	if a.action == ActionAbortMultipart {
+		return a.upload.Key + "?uploadId=" + a.upload.UploadID
+	}
+	if a.meta.VersionID != "" && a.action != ActionExpire && a.action != ActionTransition {
+		return a.meta.Key + "?versionId=" + a.meta.VersionID
+	}
+	return a.meta.Key
+}
@@ -3,7 +3,6 @@ package lifecycle
 import (
 	"context"
 	"log/slog"
-	"sort"
 	"strings"
 	"time"
 
@@ -11,11 +10,25 @@ import (
 	"github.com/eniz1806/VaultS3/internal/storage"
 )
 
+// TierMover moves an object or object version into another storage class and
+// removes the data of objects that are no longer on the hot tier.
+type TierMover interface {
+	Transition(meta metadata.ObjectMeta, storageClass string) error
+	RemoveObject(meta metadata.ObjectMeta) error
+}
+
+// EventFunc is called for each object the worker expires or transitions.
+type EventFunc func(eventType, bucket, key string, size int64, etag, versionID string)
+
 type Worker struct {
 	store              *metadata.Store
 	engine             storage.Engine
+	mover              TierMover
+	onEvent            EventFunc
 	interval           time.Duration
 	auditRetentionDays int
+	eventLogDays       int
+	lambdaHistoryDays  int
 }
 
 func NewWorker(store *metadata.Store, engine storage.Engine, intervalSecs, auditRetentionDays int) *Worker {
@@ -27,6 +40,42 @@ func NewWorker(store *metadata.Store, engine storage.Engine, intervalSecs, audit
 	}
 }
 
+// SetTierMover enables Transition and NoncurrentVersionTransition actions.
+// Without a mover, transitions in lifecycle rules are ignored.
+func (w *Worker) SetTierMover(mover TierMover) {
+	w.mover = mover
+}
+
+// SetEventLogRetention sets how many days of the event log to keep. Zero
+// keeps the event log unpruned.
+func (w *Worker) SetEventLogRetention(days int) {
+	w.eventLogDays = days
+}
+
+// SetLambdaHistoryRetention sets how many days of lambda invocation history
+// and finished pipeline runs to keep. Zero keeps them unpruned.
+func (w *Worker) SetLambdaHistoryRetention(days int) {
+	w.lambdaHistoryDays = days
+}
+
+// SetEventFunc sets the callback for lifecycle expiration and transition events.
+func (w *Worker) SetEventFunc(fn EventFunc) {
+	w.onEvent = fn
+}
+
+// archived reports whether the data of meta left the hot tier and has to be
+// removed through the tier mover.
+func (w *Worker) archived(meta metadata.ObjectMeta) bool {
+	return w.mover != nil && meta.Tier != "" && meta.Tier != "hot"
+}
+
+// emit reports a lifecycle event for meta, if a callback is set.
+func (w *Worker) emit(eventType string, meta metadata.ObjectMeta) {
+	if w.onEvent != nil {
+		w.onEvent(eventType, meta.Bucket, meta.Key, meta.Size, meta.ETag, meta.VersionID)
+	}
+}
+
 func (w *Worker) Run(ctx context.Context) {
 	ticker := time.NewTicker(w.interval)
 	defer ticker.Stop()
@@ -44,7 +93,9 @@ func (w *Worker) Run(ctx context.Context) {
 	}
 }
 
-// matchRule checks if an object matches a lifecycle rule's filters.
+// matchRule checks if an object matches a lifecycle rule's filters. All
+// predicates must hold, as for an S3 Filter.And: the prefix, every tag, and
+// the size bounds (both exclusive).
 func matchRule(rule *metadata.LifecycleRule, meta *metadata.ObjectMeta) bool {
 	if rule.Prefix != "" && !strings.HasPrefix(meta.Key, rule.Prefix) {
 		return false
@@ -65,6 +116,69 @@ func matchRule(rule *metadata.LifecycleRule, meta *metadata.ObjectMeta) bool {
 	return true
 }
 
+// expirationDue reports whether a current object has reached the rule's
+// expiration, given either as days since creation or as an absolute date.
+func expirationDue(rule *metadata.LifecycleRule, meta *metadata.ObjectMeta, now int64) bool {
+	if rule.ExpirationDays > 0 && meta.LastModified+int64(rule.ExpirationDays)*86400 <= now {
+		return true
+	}
+	if rule.ExpirationDate != "" {
+		if at, err := time.Parse(time.RFC3339, rule.ExpirationDate); err == nil && at.Unix() <= now {
+			return true
+		}
+	}
+	return false
+}
+
+// storageClassRank orders storage classes from hottest to coldest. Lifecycle
+// transitions only ever move objects down this list.
+var storageClassRank = map[string]int{
+	"STANDARD":            0,
+	"STANDARD_IA":         1,
+	"INTELLIGENT_TIERING": 2,
+	"ONEZONE_IA":          3,
+	"GLACIER_IR":          4,
+	"GLACIER":             5,
+	"DEEP_ARCHIVE":        6,
+}
+
+// dueTransition returns the coldest storage class among the rule's transitions
+// that are due for meta and colder than its current class.
+func dueTransition(rule *metadata.LifecycleRule, meta *metadata.ObjectMeta, now int64) (string, bool) {
+	best := ""
+	bestRank := storageClassRank[meta.EffectiveStorageClass()]
+	for _, t := range rule.Transitions {
+		if t.Date != "" {
+			at, err := time.Parse(time.RFC3339, t.Date)
+			if err != nil || at.Unix() > now {
+				continue
+			}
+		} else if meta.LastModified+int64(t.Days)*86400 > now {
+			continue
+		}
+		if rank, ok := storageClassRank[t.StorageClass]; ok && rank > bestRank {
+			best, bestRank = t.StorageClass, rank
+		}
+	}
+	return best, best != ""
+}
+
+// dueNoncurrentTransition is like dueTransition for a version that became
+// noncurrent at noncurrentSince and has newer noncurrent versions after it.
+func dueNoncurrentTransition(rule *metadata.LifecycleRule, meta *metadata.ObjectMeta, noncurrentSince int64, newer int, now int64) (string, bool) {
+	best := ""
+	bestRank := storageClassRank[meta.EffectiveStorageClass()]
+	for _, t := range rule.NoncurrentVersionTransitions {
+		if noncurrentSince+int64(t.NoncurrentDays)*86400 > now || newer < t.NewerNoncurrentVersions {
+			continue
+		}
+		if rank, ok := storageClassRank[t.StorageClass]; ok && rank > bestRank {
+			best, bestRank = t.StorageClass, rank
+		}
+	}
+	return best, best != ""
+}
+
 func (w *Worker) scan() {
 	now := time.Now().UTC().Unix()
 
@@ -89,146 +203,62 @@ func (w *Worker) scan() {
 		return
 	}
 
-	var expired, noncurrentExpired, multipartAborted, deleteMarkersRemoved int
+	p := &planner{store: w.store, now: now, transitions: w.mover != nil}
+	var expired, noncurrentExpired, multipartAborted, deleteMarkersRemoved, transitioned int
 
-	// 1. Current object expiration (with size/tag filters, multiple rules)
-	w.store.ScanObjects(func(meta metadata.ObjectMeta) bool {
-		cfg, ok := configs[meta.Bucket]
-		if !ok {
-			return true
-		}
-
-		for i := range cfg.Rules {
-			rule := &cfg.Rules[i]
-			if rule.Status != "Enabled" {
-				continue
-			}
-			if !matchRule(rule, &meta) {
-				continue
-			}
-
-			// Current object expiration
-			if rule.ExpirationDays > 0 && !meta.DeleteMarker {
-				expiryTime := meta.LastModified + int64(rule.ExpirationDays)*86400
-				if expiryTime <= now {
-					if meta.LegalHold || (meta.RetentionMode != "" && meta.RetentionUntil > now) {
-						continue
-					}
-					versioning, _ := w.store.GetBucketVersioning(meta.Bucket)
-					if versioning == "Enabled" && meta.VersionID != "" {
-						continue
-					}
-					if err := w.engine.DeleteObject(meta.Bucket, meta.Key); err != nil {
-						slog.Error("lifecycle error deleting object", "bucket", meta.Bucket, "key", meta.Key, "error", err)
-						continue
-					}
-					w.store.DeleteObjectMeta(meta.Bucket, meta.Key)
-					expired++
-					break
+	// Each page of actions is applied before the next page is read
+	apply := func(actions []plannedAction) {
+		for _, a := range actions {
+			switch a.action {
+			case ActionExpire:
+				var err error
+				if w.archived(a.meta) {
+					err = w.mover.RemoveObject(a.meta)
+				} else {
+					err = w.engine.DeleteObject(a.meta.Bucket, a.meta.Key)
 				}
-			}
-		}
-		return true
-	})
-
-	// 2. Noncurrent version expiration + max noncurrent versions + expired delete marker cleanup
-	for bucketName, cfg := range configs {
-		for i := range cfg.Rules {
-			rule := &cfg.Rules[i]
-			if rule.Status != "Enabled" {
-				continue
-			}
-
-			hasNoncurrentExpiry := rule.NoncurrentVersionExpirationDays > 0
-			hasMaxVersions := rule.MaxNoncurrentVersions > 0
-			hasDeleteMarkerCleanup := rule.ExpiredObjectDeleteMarker
-
-			if !hasNoncurrentExpiry && !hasMaxVersions && !hasDeleteMarkerCleanup {
-				continue
-			}
-
-			// Group versions by key
-			keyVersions := make(map[string][]metadata.ObjectMeta)
-			w.store.ScanObjectVersions(func(meta metadata.ObjectMeta) bool {
-				if meta.Bucket != bucketName {
-					return true
-				}
-				if rule.Prefix != "" && !strings.HasPrefix(meta.Key, rule.Prefix) {
-					return true
+				if err != nil {
+					slog.Error("lifecycle error deleting object", "bucket", a.meta.Bucket, "key", a.meta.Key, "error", err)
+					continue
 				}
-				keyVersions[meta.Key] = append(keyVersions[meta.Key], meta)
-				return true
-			})
-
-			for key, versions := range keyVersions {
-				// Sort by LastModified descending
-				sort.Slice(versions, func(a, b int) bool {
-					return versions[a].LastModified > versions[b].LastModified
-				})
-
-				// Find noncurrent versions (not the latest)
-				var noncurrent []metadata.ObjectMeta
-				for j, v := range versions {
-					if j == 0 {
-						continue // latest
-					}
-					noncurrent = append(noncurrent, v)
+				w.store.DeleteObjectMeta(a.meta.Bucket, a.meta.Key)
+				w.emit("s3:LifecycleExpiration:Delete", a.meta)
+				expired++
+			case ActionNoncurrentExpire:
+				var err error
+				if w.archived(a.meta) {
+					err = w.mover.RemoveObject(a.meta)
+				} else {
+					err = w.engine.DeleteObjectVersion(a.meta.Bucket, a.meta.Key, a.meta.VersionID)
 				}
-
-				// Noncurrent version expiration by age
-				if hasNoncurrentExpiry {
-					cutoff := now - int64(rule.NoncurrentVersionExpirationDays)*86400
-					for _, v := range noncurrent {
-						if v.LastModified < cutoff {
-							w.engine.DeleteObjectVersion(v.Bucket, v.Key, v.VersionID)
-							w.store.DeleteObjectVersion(v.Bucket, v.Key, v.VersionID)
-							noncurrentExpired++
-						}
-					}
+				if err != nil {
+					slog.Error("lifecycle error deleting version", "bucket", a.meta.Bucket, "key", a.meta.Key, "version", a.meta.VersionID, "error", err)
+					continue
 				}
-
-				// Max noncurrent versions
-				if hasMaxVersions && len(noncurrent) > rule.MaxNoncurrentVersions {
-					excess := noncurrent[rule.MaxNoncurrentVersions:]
-					for _, v := range excess {
-						w.engine.DeleteObjectVersion(v.Bucket, v.Key, v.VersionID)
-						w.store.DeleteObjectVersion(v.Bucket, v.Key, v.VersionID)
-						noncurrentExpired++
-					}
-				}
-
-				// Expired delete marker cleanup: remove delete markers with no noncurrent versions
-				if hasDeleteMarkerCleanup && len(versions) == 1 && versions[0].DeleteMarker {
-					w.store.DeleteObjectVersion(bucketName, key, versions[0].VersionID)
-					w.store.DeleteObjectMeta(bucketName, key)
-					deleteMarkersRemoved++
+				w.store.DeleteObjectVersion(a.meta.Bucket, a.meta.Key, a.meta.VersionID)
+				w.emit("s3:LifecycleExpiration:Delete", a.meta)
+				noncurrentExpired++
+			case ActionDeleteMarkerCleanup:
+				w.store.DeleteObjectVersion(a.meta.Bucket, a.meta.Key, a.meta.VersionID)
+				w.store.DeleteObjectMeta(a.meta.Bucket, a.meta.Key)
+				w.emit("s3:LifecycleExpiration:Delete", a.meta)
+				deleteMarkersRemoved++
+			case ActionTransition, ActionNoncurrentTransition:
+				if err := w.mover.Transition(a.meta, a.storageClass); err != nil {
+					slog.Error("lifecycle error transitioning object", "bucket", a.meta.Bucket, "key", a.meta.Key,
+						"version", a.meta.VersionID, "storage_class", a.storageClass, "error", err)
+					continue
 				}
+				w.emit("s3:LifecycleTransition", a.meta)
+				transitioned++
+			case ActionAbortMultipart:
+				w.store.DeleteMultipartUpload(a.upload.UploadID)
+				multipartAborted++
 			}
 		}
 	}
-
-	// 3. Abort incomplete multipart uploads
-	for bucketName, cfg := range configs {
-		for i := range cfg.Rules {
-			rule := &cfg.Rules[i]
-			if rule.Status != "Enabled" || rule.AbortIncompleteMultipartDays <= 0 {
-				continue
-			}
-			uploads, err := w.store.ListMultipartUploads(bucketName)
-			if err != nil {
-				continue
-			}
-			cutoff := now - int64(rule.AbortIncompleteMultipartDays)*86400
-			for _, upload := range uploads {
-				if upload.CreatedAt < cutoff {
-					if rule.Prefix != "" && !strings.HasPrefix(upload.Key, rule.Prefix) {
-						continue
-					}
-					w.store.DeleteMultipartUpload(upload.UploadID)
-					multipartAborted++
-				}
-			}
-		}
+	for bucket, cfg := range configs {
+		p.plan(bucket, cfg, apply)
 	}
 
 	if expired > 0 {
@@ -237,6 +267,9 @@ func (w *Worker) scan() {
 	if noncurrentExpired > 0 {
 		slog.Info("lifecycle deleted noncurrent versions", "count", noncurrentExpired)
 	}
+	if transitioned > 0 {
+		slog.Info("lifecycle transitioned objects", "count", transitioned)
+	}
 	if multipartAborted > 0 {
 		slog.Info("lifecycle aborted incomplete multipart uploads", "count", multipartAborted)
 	}
@@ -259,6 +292,34 @@ func (w *Worker) pruneAndClean() {
 		}
 	}
 
+	// Prune the event log kept for replay
+	if w.eventLogDays > 0 {
+		cutoff := time.Now().UTC().AddDate(0, 0, -w.eventLogDays)
+		pruned, err := w.store.PruneEventLog(cutoff)
+		if err != nil {
+			slog.Error("lifecycle error pruning event log", "error", err)
+		} else if pruned > 0 {
+			slog.Info("lifecycle pruned event log", "count", pruned)
+		}
+	}
+
+	// Prune the lambda invocation history and finished pipeline runs
+	if w.lambdaHistoryDays > 0 {
+		cutoff := time.Now().UTC().AddDate(0, 0, -w.lambdaHistoryDays)
+		pruned, err := w.store.PruneLambdaInvocations(cutoff)
+		if err != nil {
+			slog.Error("lifecycle error pruning lambda invocations", "error", err)
+		} else if pruned > 0 {
+			slog.Info("lifecycle pruned lambda invocations", "count", pruned)
+		}
+		pruned, err = w.store.PrunePipelineRuns(cutoff)
+		if err != nil {
+			slog.Error("lifecycle error pruning pipeline runs", "error", err)
+		} else if pruned > 0 {
+			slog.Info("lifecycle pruned pipeline runs", "count", pruned)
+		}
+	}
+
 	// Clean up expired STS keys
 	deleted, err := w.store.DeleteExpiredAccessKeys()
 	if err != nil {
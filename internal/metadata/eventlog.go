@@ -0,0 +1,106 @@
+package metadata
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// EventLogEntry is an object event as it was emitted, kept so that events can
+// be replayed to consumers later.
+type EventLogEntry struct {
+	Seq         uint64 `json:"seq"`  // orders entries of the same time
+	Time        int64  `json:"time"` // unix nanos
+	Name        string `json:"name"`
+	Bucket      string `json:"bucket"`
+	Key         string `json:"key"`
+	Size        int64  `json:"size,omitempty"`
+	ETag        string `json:"etag,omitempty"`
+	VersionID   string `json:"version_id,omitempty"`
+	PrincipalID string `json:"principal_id,omitempty"`
+	SourceIP    string `json:"source_ip,omitempty"`
+	RequestID   string `json:"request_id,omitempty"`
+}
+
+// eventLogKey orders entries by time; the sequence keeps entries of the same
+// nanosecond apart.
+func eventLogKey(nanos int64, seq uint64) []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key, uint64(nanos))
+	binary.BigEndian.PutUint64(key[8:], seq)
+	return key
+}
+
+// AppendEventLog records emitted events in one transaction.
+func (s *Store) AppendEventLog(entries ...EventLogEntry) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(eventLogBucket)
+		for _, entry := range entries {
+			seq, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+			entry.Seq = seq
+			data, err := json.Marshal(entry)
+			if err != nil {
+				return err
+			}
+			if err := b.Put(eventLogKey(entry.Time, seq), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ScanEventLog calls fn, oldest first, for the events of bucket whose key
+// starts with prefix and whose time is in [from, to). A zero to means no
+// upper bound. Entries at from with a sequence number up to afterSeq are
+// skipped, so a scan can resume after the last entry it saw. Scanning stops
+// when fn returns false.
+func (s *Store) ScanEventLog(bucket, prefix string, from, to int64, afterSeq uint64, fn func(EventLogEntry) bool) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(eventLogBucket).Cursor()
+		for k, v := c.Seek(eventLogKey(from, afterSeq+1)); k != nil; k, v = c.Next() {
+			if to > 0 && int64(binary.BigEndian.Uint64(k)) >= to {
+				break
+			}
+			var entry EventLogEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				continue
+			}
+			if entry.Bucket != bucket || !strings.HasPrefix(entry.Key, prefix) {
+				continue
+			}
+			if !fn(entry) {
+				break
+			}
+		}
+		return nil
+	})
+}
+
+// PruneEventLog removes events recorded before olderThan.
+func (s *Store) PruneEventLog(olderThan time.Time) (int, error) {
+	cutoff := olderThan.UnixNano()
+	pruned := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(eventLogBucket)
+		var keys [][]byte
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil && int64(binary.BigEndian.Uint64(k)) < cutoff; k, _ = c.Next() {
+			keys = append(keys, append([]byte(nil), k...))
+		}
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		pruned = len(keys)
+		return nil
+	})
+	return pruned, err
+}
@@ -0,0 +1,363 @@
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// LambdaJob is a trigger invocation waiting in the lambda queue, or
+// dead-lettered after too many failed attempts.
+type LambdaJob struct {
+	ID            uint64          `json:"id"`
+	Bucket        string          `json:"bucket"`
+	TriggerID     string          `json:"trigger_id"`
+	EventName     string          `json:"event_name"`
+	Key           string          `json:"key"`
+	Event         json.RawMessage `json:"event"` // the S3 event the function is called for
+	Attempts      int             `json:"attempts"`
+	LastError     string          `json:"last_error,omitempty"`
+	NextAttemptAt int64           `json:"next_attempt_at"`   // unix nanos
+	CreatedAt     int64           `json:"created_at"`        // unix nanos
+	DeadAt        int64           `json:"dead_at,omitempty"` // unix nanos
+	// Pipeline jobs run one step of a pipeline run; TriggerID is then the
+	// pipeline's ID.
+	PipelineRunID uint64 `json:"pipeline_run_id,omitempty"`
+	Step          string `json:"step,omitempty"`
+}
+
+// Lambda invocation outcomes.
+const (
+	InvocationSucceeded    = "succeeded"
+	InvocationRetrying     = "retrying"      // failed, another attempt is queued
+	InvocationDeadLettered = "dead_lettered" // failed, no attempts left
+	InvocationFailed       = "failed"        // failed synchronous invocation or pipeline step
+)
+
+// LambdaInvocation records one call of a lambda function.
+type LambdaInvocation struct {
+	ID           uint64          `json:"id"`
+	Time         int64           `json:"time"` // unix nanos
+	Bucket       string          `json:"bucket"`
+	TriggerID    string          `json:"trigger_id"`
+	EventName    string          `json:"event_name"`
+	Key          string          `json:"key"`
+	JobID        uint64          `json:"job_id,omitempty"` // queue job; zero for synchronous invocations
+	Attempt      int             `json:"attempt"`
+	Status       string          `json:"status"`
+	StatusCode   int             `json:"status_code,omitempty"`
+	LatencyMs    int64           `json:"latency_ms"`
+	ResponseSize int64           `json:"response_size"`
+	Error        string          `json:"error,omitempty"`
+	ExitCode     int             `json:"exit_code,omitempty"` // process runtime
+	Stderr       string          `json:"stderr,omitempty"`    // process runtime, truncated
+	Event        json.RawMessage `json:"event"`
+
+	PipelineRunID uint64 `json:"pipeline_run_id,omitempty"`
+	Step          string `json:"step,omitempty"`
+}
+
+// LambdaInvocationFilter selects invocations from the history. Empty fields
+// match everything.
+type LambdaInvocationFilter struct {
+	Bucket    string
+	TriggerID string
+	Status    string
+	RunID     uint64 // pipeline run
+	Before    uint64 // only invocations with a lower ID, for paging
+	Limit     int
+}
+
+// EnqueueLambdaJobs persists jobs in the lambda queue in one transaction and
+// assigns their IDs.
+func (s *Store) EnqueueLambdaJobs(jobs []LambdaJob) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for _, job := range jobs {
+			if err := putLambdaJob(tx, job); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func putLambdaJob(tx *bolt.Tx, job LambdaJob) error {
+	b := tx.Bucket(lambdaQueueBucket)
+	id, _ := b.NextSequence()
+	job.ID = id
+	if job.CreatedAt == 0 {
+		job.CreatedAt = time.Now().UnixNano()
+	}
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return b.Put(replicationKey(id), data)
+}
+
+// DequeueLambdaJobs returns up to limit queued jobs due at now, oldest
+// first, leaving out those for which skip returns true.
+func (s *Store) DequeueLambdaJobs(limit int, now int64, skip func(LambdaJob) bool) ([]LambdaJob, error) {
+	var jobs []LambdaJob
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(lambdaQueueBucket).Cursor()
+		for k, v := c.First(); k != nil && len(jobs) < limit; k, v = c.Next() {
+			var job LambdaJob
+			if err := json.Unmarshal(v, &job); err != nil {
+				continue
+			}
+			if job.NextAttemptAt > now || (skip != nil && skip(job)) {
+				continue
+			}
+			jobs = append(jobs, job)
+		}
+		return nil
+	})
+	return jobs, err
+}
+
+// AckLambdaJob removes a completed job from the queue.
+func (s *Store) AckLambdaJob(id uint64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(lambdaQueueBucket).Delete(replicationKey(id))
+	})
+}
+
+// NackLambdaJob records a failed attempt of a queued job and when to try it
+// next.
+func (s *Store) NackLambdaJob(id uint64, attempts int, nextAttemptAt int64, lastError string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(lambdaQueueBucket)
+		data := b.Get(replicationKey(id))
+		if data == nil {
+			return nil
+		}
+		var job LambdaJob
+		if err := json.Unmarshal(data, &job); err != nil {
+			return err
+		}
+		job.Attempts = attempts
+		job.NextAttemptAt = nextAttemptAt
+		job.LastError = lastError
+		updated, err := json.Marshal(job)
+		if err != nil {
+			return err
+		}
+		return b.Put(replicationKey(id), updated)
+	})
+}
+
+// DeadLetterLambdaJob moves a queued job to the lambda dead letters.
+func (s *Store) DeadLetterLambdaJob(id uint64, attempts int, lastError string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(lambdaQueueBucket)
+		key := replicationKey(id)
+		data := b.Get(key)
+		if data == nil {
+			return nil
+		}
+		var job LambdaJob
+		if err := json.Unmarshal(data, &job); err != nil {
+			return err
+		}
+		job.Attempts = attempts
+		job.LastError = lastError
+		job.DeadAt = time.Now().UnixNano()
+		updated, err := json.Marshal(job)
+		if err != nil {
+			return err
+		}
+		if err := tx.Bucket(lambdaDeadLetterBucket).Put(key, updated); err != nil {
+			return err
+		}
+		return b.Delete(key)
+	})
+}
+
+// LambdaQueueCounts returns the number of queued and dead-lettered jobs.
+func (s *Store) LambdaQueueCounts() (pending, dead int, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		pending = tx.Bucket(lambdaQueueBucket).Stats().KeyN
+		dead = tx.Bucket(lambdaDeadLetterBucket).Stats().KeyN
+		return nil
+	})
+	return pending, dead, err
+}
+
+// ListLambdaDeadLetters returns up to limit dead-lettered jobs, oldest
+// first. Non-empty bucket and triggerID restrict them to that trigger.
+func (s *Store) ListLambdaDeadLetters(bucket, triggerID string, limit int) ([]LambdaJob, error) {
+	var jobs []LambdaJob
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(lambdaDeadLetterBucket).Cursor()
+		for k, v := c.First(); k != nil && len(jobs) < limit; k, v = c.Next() {
+			var job LambdaJob
+			if err := json.Unmarshal(v, &job); err != nil {
+				continue
+			}
+			if matchLambdaJob(job, bucket, triggerID) {
+				jobs = append(jobs, job)
+			}
+		}
+		return nil
+	})
+	return jobs, err
+}
+
+// ReplayLambdaDeadLetters moves dead-lettered jobs back into the queue for
+// immediate execution with a fresh retry budget. It selects the jobs with
+// the given IDs, or all of them if ids is empty, restricted to bucket and
+// triggerID if those are non-empty, and returns how many were moved.
+func (s *Store) ReplayLambdaDeadLetters(ids []uint64, bucket, triggerID string) (int, error) {
+	return s.removeLambdaDeadLetters(ids, bucket, triggerID, func(tx *bolt.Tx, job LambdaJob) error {
+		b := tx.Bucket(lambdaQueueBucket)
+		id, _ := b.NextSequence()
+		job.ID = id
+		job.Attempts = 0
+		job.NextAttemptAt = 0
+		job.DeadAt = 0
+		data, err := json.Marshal(job)
+		if err != nil {
+			return err
+		}
+		return b.Put(replicationKey(id), data)
+	})
+}
+
+// PurgeLambdaDeadLetters deletes dead-lettered jobs, selected as for
+// ReplayLambdaDeadLetters, and returns how many were deleted.
+func (s *Store) PurgeLambdaDeadLetters(ids []uint64, bucket, triggerID string) (int, error) {
+	return s.removeLambdaDeadLetters(ids, bucket, triggerID, nil)
+}
+
+func (s *Store) removeLambdaDeadLetters(ids []uint64, bucket, triggerID string, fn func(*bolt.Tx, LambdaJob) error) (int, error) {
+	wanted := make(map[uint64]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+	count := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(lambdaDeadLetterBucket)
+		var selected []LambdaJob
+		err := b.ForEach(func(k, v []byte) error {
+			var job LambdaJob
+			if err := json.Unmarshal(v, &job); err != nil {
+				return nil
+			}
+			if (len(wanted) == 0 || wanted[job.ID]) && matchLambdaJob(job, bucket, triggerID) {
+				selected = append(selected, job)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, job := range selected {
+			if fn != nil {
+				if err := fn(tx, job); err != nil {
+					return err
+				}
+			}
+			if err := b.Delete(replicationKey(job.ID)); err != nil {
+				return err
+			}
+		}
+		count = len(selected)
+		return nil
+	})
+	return count, err
+}
+
+func matchLambdaJob(job LambdaJob, bucket, triggerID string) bool {
+	return (bucket == "" || job.Bucket == bucket) && (triggerID == "" || job.TriggerID == triggerID)
+}
+
+// AppendLambdaInvocation adds an invocation to the history and assigns its
+// ID.
+func (s *Store) AppendLambdaInvocation(inv LambdaInvocation) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(lambdaInvocationsBucket)
+		id, _ := b.NextSequence()
+		inv.ID = id
+		if inv.Time == 0 {
+			inv.Time = time.Now().UnixNano()
+		}
+		data, err := json.Marshal(inv)
+		if err != nil {
+			return err
+		}
+		return b.Put(replicationKey(id), data)
+	})
+}
+
+// GetLambdaInvocation returns the invocation with the given ID.
+func (s *Store) GetLambdaInvocation(id uint64) (*LambdaInvocation, error) {
+	var inv *LambdaInvocation
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(lambdaInvocationsBucket).Get(replicationKey(id))
+		if data == nil {
+			return fmt.Errorf("invocation %d not found", id)
+		}
+		inv = &LambdaInvocation{}
+		return json.Unmarshal(data, inv)
+	})
+	return inv, err
+}
+
+// ListLambdaInvocations returns the invocations selected by f, newest first.
+func (s *Store) ListLambdaInvocations(f LambdaInvocationFilter) ([]LambdaInvocation, error) {
+	var invocations []LambdaInvocation
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(lambdaInvocationsBucket).Cursor()
+		k, v := c.Last()
+		if f.Before > 0 {
+			if sk, _ := c.Seek(replicationKey(f.Before)); sk != nil {
+				k, v = c.Prev()
+			}
+		}
+		for ; k != nil && len(invocations) < f.Limit; k, v = c.Prev() {
+			var inv LambdaInvocation
+			if err := json.Unmarshal(v, &inv); err != nil {
+				continue
+			}
+			if (f.Bucket != "" && inv.Bucket != f.Bucket) ||
+				(f.TriggerID != "" && inv.TriggerID != f.TriggerID) ||
+				(f.Status != "" && inv.Status != f.Status) ||
+				(f.RunID != 0 && inv.PipelineRunID != f.RunID) {
+				continue
+			}
+			invocations = append(invocations, inv)
+		}
+		return nil
+	})
+	return invocations, err
+}
+
+// PruneLambdaInvocations removes invocations recorded before olderThan.
+func (s *Store) PruneLambdaInvocations(olderThan time.Time) (int, error) {
+	cutoff := olderThan.UnixNano()
+	pruned := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(lambdaInvocationsBucket)
+		var keys [][]byte
+		c := b.Cursor()
+		// IDs increase with time, so stop at the first entry to keep
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var inv LambdaInvocation
+			if err := json.Unmarshal(v, &inv); err == nil && inv.Time >= cutoff {
+				break
+			}
+			keys = append(keys, append([]byte(nil), k...))
+		}
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		pruned = len(keys)
+		return nil
+	})
+	return pruned, err
+}
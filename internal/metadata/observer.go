@@ -0,0 +1,31 @@
+package metadata
+
+// ObjectObserver is told about committed changes to the current metadata of
+// objects, whichever component made them: the S3 API, the dashboard,
+// lifecycle, batch jobs or the scanner. Access time updates are not reported.
+type ObjectObserver interface {
+	// ObjectChanged is called after the latest metadata of an object was
+	// written; meta is nil when it was deleted.
+	ObjectChanged(bucket, key string, meta *ObjectMeta)
+	// BucketDeleted is called after a bucket, or all of its object
+	// metadata, was removed.
+	BucketDeleted(bucket string)
+}
+
+// SetObjectObserver sets the observer of object metadata changes. It must be
+// called before the store is used by other goroutines.
+func (s *Store) SetObjectObserver(o ObjectObserver) {
+	s.observer = o
+}
+
+func (s *Store) objectChanged(bucket, key string, meta *ObjectMeta) {
+	if s.observer != nil {
+		s.observer.ObjectChanged(bucket, key, meta)
+	}
+}
+
+func (s *Store) bucketDeleted(bucket string) {
+	if s.observer != nil {
+		s.observer.BucketDeleted(bucket)
+	}
+}
@@ -0,0 +1,432 @@
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// PipelineSource names the triggering object in PipelineStep.After.
+const PipelineSource = "source"
+
+// LambdaPipeline chains lambda functions: each step consumes the outputs of
+// the steps it runs after, so steps can run in sequence, fan out from one
+// step and fan back in.
+type LambdaPipeline struct {
+	ID      string              `json:"id"`
+	Events  []string            `json:"events"`
+	Filters LambdaTriggerFilter `json:"filters,omitempty"`
+	Steps   []PipelineStep      `json:"steps"`
+}
+
+// PipelineStep is one function of a pipeline. Its fields mean what they do
+// for a LambdaTrigger; the output key template may also use {run} and
+// {step}.
+type PipelineStep struct {
+	ID string `json:"id"`
+	// After lists the steps whose outputs this step consumes, and
+	// PipelineSource for the triggering object. If empty, the step runs
+	// after the previous one, or on the triggering object if it is first.
+	After             []string     `json:"after,omitempty"`
+	Runtime           string       `json:"runtime,omitempty"`
+	FunctionURL       string       `json:"function_url,omitempty"`
+	Command           string       `json:"command,omitempty"`
+	IncludeBody       bool         `json:"include_body,omitempty"` // send the triggering object's body to an http step
+	MaxBodySize       int64        `json:"max_body_size,omitempty"`
+	OutputBucket      string       `json:"output_bucket,omitempty"` // also store the output as an object
+	OutputKeyTemplate string       `json:"output_key_template,omitempty"`
+	Auth              *WebhookAuth `json:"auth,omitempty"`
+	MaxRetries        int          `json:"max_retries,omitempty"`
+	RetryBackoffSecs  int          `json:"retry_backoff_secs,omitempty"`
+}
+
+type BucketPipelineConfig struct {
+	Pipelines []LambdaPipeline `json:"pipelines"`
+}
+
+// Pipeline run states.
+const (
+	PipelineRunning   = "running"
+	PipelineSucceeded = "succeeded"
+	PipelineFailed    = "failed"
+	PipelineCancelled = "cancelled"
+)
+
+// Pipeline step states.
+const (
+	StepPending   = "pending" // waiting for the steps it runs after
+	StepQueued    = "queued"
+	StepRunning   = "running"
+	StepSucceeded = "succeeded"
+	StepFailed    = "failed"
+	StepSkipped   = "skipped" // a step it runs after failed, or the run was cancelled
+)
+
+// PipelineRun records one run of a pipeline for a triggering object.
+type PipelineRun struct {
+	ID          uint64            `json:"id"`
+	Bucket      string            `json:"bucket"`
+	PipelineID  string            `json:"pipeline_id"`
+	EventName   string            `json:"event_name"`
+	Key         string            `json:"key"`
+	Event       json.RawMessage   `json:"event"`
+	ParentRunID uint64            `json:"parent_run_id,omitempty"` // run that produced the triggering object
+	BaseDepth   int               `json:"base_depth,omitempty"`    // steps of the runs that produced the triggering object
+	Status      string            `json:"status"`
+	Error       string            `json:"error,omitempty"`
+	Steps       []PipelineStepRun `json:"steps"`
+	CreatedAt   int64             `json:"created_at"`            // unix nanos
+	FinishedAt  int64             `json:"finished_at,omitempty"` // unix nanos
+}
+
+// PipelineStepRun is the state of one step of a pipeline run.
+type PipelineStepRun struct {
+	ID          string   `json:"id"`
+	After       []string `json:"after"`
+	Depth       int      `json:"depth"` // steps on the longest path up to and including this one
+	Status      string   `json:"status"`
+	Attempts    int      `json:"attempts,omitempty"`
+	Error       string   `json:"error,omitempty"`
+	OutputSize  int64    `json:"output_size,omitempty"`
+	ContentType string   `json:"content_type,omitempty"`
+	OutputKey   string   `json:"output_key,omitempty"`  // bucket/key of the stored output, if any
+	StartedAt   int64    `json:"started_at,omitempty"`  // unix nanos
+	FinishedAt  int64    `json:"finished_at,omitempty"` // unix nanos
+}
+
+// Step returns the state of the step with the given ID.
+func (r *PipelineRun) Step(id string) *PipelineStepRun {
+	for i := range r.Steps {
+		if r.Steps[i].ID == id {
+			return &r.Steps[i]
+		}
+	}
+	return nil
+}
+
+// Finished reports whether the run has ended.
+func (r *PipelineRun) Finished() bool {
+	return r.Status != PipelineRunning
+}
+
+// PipelineRunFilter selects pipeline runs. Empty fields match everything.
+type PipelineRunFilter struct {
+	Bucket     string
+	PipelineID string
+	Status     string
+	Before     uint64 // only runs with a lower ID, for paging
+	Limit      int
+}
+
+// Pipeline definition operations
+
+func (s *Store) PutPipelineConfig(bucket string, cfg BucketPipelineConfig) error {
+	cfg, err := s.sealPipelineConfig(cfg)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		data, err := json.Marshal(cfg)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(lambdaPipelinesBucket).Put([]byte(bucket), data)
+	})
+}
+
+func (s *Store) GetPipelineConfig(bucket string) (*BucketPipelineConfig, error) {
+	var cfg *BucketPipelineConfig
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(lambdaPipelinesBucket).Get([]byte(bucket))
+		if data == nil {
+			return fmt.Errorf("no pipeline config for bucket: %s", bucket)
+		}
+		cfg = &BucketPipelineConfig{}
+		if err := json.Unmarshal(data, cfg); err != nil {
+			return err
+		}
+		return s.openPipelineConfig(cfg)
+	})
+	return cfg, err
+}
+
+func (s *Store) DeletePipelineConfig(bucket string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(lambdaPipelinesBucket).Delete([]byte(bucket))
+	})
+}
+
+func (s *Store) ListPipelineConfigs() (map[string]BucketPipelineConfig, error) {
+	configs := make(map[string]BucketPipelineConfig)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(lambdaPipelinesBucket).ForEach(func(k, v []byte) error {
+			var cfg BucketPipelineConfig
+			if err := json.Unmarshal(v, &cfg); err != nil {
+				return nil
+			}
+			if err := s.openPipelineConfig(&cfg); err != nil {
+				return err
+			}
+			configs[string(k)] = cfg
+			return nil
+		})
+	})
+	return configs, err
+}
+
+// Pipeline run operations
+
+// CreatePipelineRun persists a new run and assigns its ID. If the run is
+// running, the steps that only consume the triggering object are queued in
+// the same transaction.
+func (s *Store) CreatePipelineRun(run *PipelineRun) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(pipelineRunsBucket)
+		id, _ := b.NextSequence()
+		run.ID = id
+		now := time.Now().UnixNano()
+		run.CreatedAt = now
+		for i := range run.Steps {
+			run.Steps[i].Status = StepPending
+		}
+		if run.Status == PipelineRunning {
+			if err := advancePipelineRun(tx, run, now); err != nil {
+				return err
+			}
+		} else {
+			for i := range run.Steps {
+				run.Steps[i].Status = StepSkipped
+			}
+			run.FinishedAt = now
+		}
+		return putPipelineRun(tx, run)
+	})
+}
+
+// GetPipelineRun returns the run with the given ID.
+func (s *Store) GetPipelineRun(id uint64) (*PipelineRun, error) {
+	var run *PipelineRun
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		run, err = getPipelineRun(tx, id)
+		return err
+	})
+	return run, err
+}
+
+// ListPipelineRuns returns the runs selected by f, newest first.
+func (s *Store) ListPipelineRuns(f PipelineRunFilter) ([]PipelineRun, error) {
+	var runs []PipelineRun
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(pipelineRunsBucket).Cursor()
+		k, v := c.Last()
+		if f.Before > 0 {
+			if sk, _ := c.Seek(replicationKey(f.Before)); sk != nil {
+				k, v = c.Prev()
+			}
+		}
+		for ; k != nil && len(runs) < f.Limit; k, v = c.Prev() {
+			var run PipelineRun
+			if err := json.Unmarshal(v, &run); err != nil {
+				continue
+			}
+			if (f.Bucket != "" && run.Bucket != f.Bucket) ||
+				(f.PipelineID != "" && run.PipelineID != f.PipelineID) ||
+				(f.Status != "" && run.Status != f.Status) {
+				continue
+			}
+			runs = append(runs, run)
+		}
+		return nil
+	})
+	return runs, err
+}
+
+// UpdatePipelineStep applies fn to a step of a run and returns the updated
+// run.
+func (s *Store) UpdatePipelineStep(runID uint64, stepID string, fn func(*PipelineStepRun)) (*PipelineRun, error) {
+	var run *PipelineRun
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		if run, err = getPipelineRun(tx, runID); err != nil {
+			return err
+		}
+		step := run.Step(stepID)
+		if step == nil {
+			return fmt.Errorf("pipeline run %d has no step %s", runID, stepID)
+		}
+		fn(step)
+		return putPipelineRun(tx, run)
+	})
+	return run, err
+}
+
+// FinishPipelineStep records the outcome of a step, which must be
+// StepSucceeded or StepFailed. In the same transaction it queues the steps
+// whose inputs are now all available, skips those that can no longer run
+// and ends the run once no step is left to run. It returns the updated run.
+func (s *Store) FinishPipelineStep(runID uint64, result PipelineStepRun) (*PipelineRun, error) {
+	var run *PipelineRun
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		if run, err = getPipelineRun(tx, runID); err != nil {
+			return err
+		}
+		step := run.Step(result.ID)
+		if step == nil {
+			return fmt.Errorf("pipeline run %d has no step %s", runID, result.ID)
+		}
+		now := time.Now().UnixNano()
+		result.After = step.After
+		result.Depth = step.Depth
+		result.FinishedAt = now
+		*step = result
+		if run.Status == PipelineRunning {
+			if err := advancePipelineRun(tx, run, now); err != nil {
+				return err
+			}
+		}
+		return putPipelineRun(tx, run)
+	})
+	return run, err
+}
+
+// CancelPipelineRun stops a running run: its pending and queued steps are
+// skipped, and steps already running finish without queueing others.
+func (s *Store) CancelPipelineRun(id uint64) (*PipelineRun, error) {
+	var run *PipelineRun
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		if run, err = getPipelineRun(tx, id); err != nil {
+			return err
+		}
+		if run.Finished() {
+			return nil
+		}
+		for i := range run.Steps {
+			if st := run.Steps[i].Status; st == StepPending || st == StepQueued {
+				run.Steps[i].Status = StepSkipped
+			}
+		}
+		run.Status = PipelineCancelled
+		run.FinishedAt = time.Now().UnixNano()
+		return putPipelineRun(tx, run)
+	})
+	return run, err
+}
+
+// PrunePipelineRuns removes runs that finished before olderThan.
+func (s *Store) PrunePipelineRuns(olderThan time.Time) (int, error) {
+	cutoff := olderThan.UnixNano()
+	pruned := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(pipelineRunsBucket)
+		var keys [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			var run PipelineRun
+			if err := json.Unmarshal(v, &run); err != nil {
+				return nil
+			}
+			if run.Finished() && run.FinishedAt < cutoff {
+				keys = append(keys, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		pruned = len(keys)
+		return nil
+	})
+	return pruned, err
+}
+
+func getPipelineRun(tx *bolt.Tx, id uint64) (*PipelineRun, error) {
+	data := tx.Bucket(pipelineRunsBucket).Get(replicationKey(id))
+	if data == nil {
+		return nil, fmt.Errorf("pipeline run %d not found", id)
+	}
+	run := &PipelineRun{}
+	return run, json.Unmarshal(data, run)
+}
+
+func putPipelineRun(tx *bolt.Tx, run *PipelineRun) error {
+	data, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(pipelineRunsBucket).Put(replicationKey(run.ID), data)
+}
+
+// advancePipelineRun skips the pending steps of a running run that run
+// after a failed or skipped step, queues those whose inputs have all been
+// produced and ends the run once every step is done.
+func advancePipelineRun(tx *bolt.Tx, run *PipelineRun, now int64) error {
+	for changed := true; changed; {
+		changed = false
+		for i := range run.Steps {
+			step := &run.Steps[i]
+			if step.Status != StepPending {
+				continue
+			}
+			ready := true
+			for _, dep := range step.After {
+				if dep == PipelineSource {
+					continue
+				}
+				switch d := run.Step(dep); {
+				case d == nil || d.Status == StepFailed || d.Status == StepSkipped:
+					step.Status = StepSkipped
+					step.FinishedAt = now
+					changed = true
+				case d.Status != StepSucceeded:
+					ready = false
+				}
+				if step.Status == StepSkipped {
+					break
+				}
+			}
+			if step.Status != StepPending || !ready {
+				continue
+			}
+			step.Status = StepQueued
+			err := putLambdaJob(tx, LambdaJob{
+				Bucket:        run.Bucket,
+				TriggerID:     run.PipelineID,
+				EventName:     run.EventName,
+				Key:           run.Key,
+				Event:         run.Event,
+				PipelineRunID: run.ID,
+				Step:          step.ID,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	status := PipelineSucceeded
+	for _, step := range run.Steps {
+		switch step.Status {
+		case StepPending, StepQueued, StepRunning:
+			return nil
+		case StepFailed:
+			if status == PipelineSucceeded {
+				run.Error = fmt.Sprintf("step %s failed: %s", step.ID, step.Error)
+			}
+			status = PipelineFailed
+		case StepSkipped:
+			status = PipelineFailed
+		}
+	}
+	run.Status = status
+	run.FinishedAt = now
+	return nil
+}
@@ -0,0 +1,153 @@
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Rescan statuses.
+const (
+	RescanRunning   = "running"
+	RescanCompleted = "completed"
+	RescanCancelled = "cancelled"
+	RescanFailed    = "failed"
+)
+
+// Rescan is a scan of the objects already stored in a bucket or under a
+// prefix, for example after the scanner's signatures were updated. Its
+// progress is saved as it goes so that it resumes after a restart.
+type Rescan struct {
+	ID            uint64 `json:"id"`
+	Bucket        string `json:"bucket"`
+	Prefix        string `json:"prefix,omitempty"`
+	ObjectsPerSec int    `json:"objects_per_sec"`
+	// SkipCurrent skips objects whose last scan was by the engine version
+	// the scanner runs now.
+	SkipCurrent bool   `json:"skip_current,omitempty"`
+	Schedule    string `json:"schedule,omitempty"` // name of the schedule that started it; empty when started on demand
+	Status      string `json:"status"`
+	Error       string `json:"error,omitempty"`
+
+	Total    int64  `json:"total"` // objects under the prefix when the rescan started
+	Scanned  int64  `json:"scanned"`
+	Clean    int64  `json:"clean"`
+	Infected int64  `json:"infected"`
+	Errors   int64  `json:"errors"`
+	Skipped  int64  `json:"skipped"`
+	LastKey  string `json:"last_key,omitempty"` // last key done, where a resumed rescan continues
+	Engine   string `json:"engine,omitempty"`   // engine version when the rescan started
+
+	CreatedAt  int64 `json:"created_at"`
+	UpdatedAt  int64 `json:"updated_at"`
+	FinishedAt int64 `json:"finished_at,omitempty"`
+}
+
+// CreateRescan persists a new rescan and assigns its ID.
+func (s *Store) CreateRescan(r *Rescan) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(rescansBucket)
+		id, _ := b.NextSequence()
+		r.ID = id
+		r.CreatedAt = time.Now().Unix()
+		r.UpdatedAt = r.CreatedAt
+		data, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		return b.Put(replicationKey(id), data)
+	})
+}
+
+// UpdateRescan saves the progress of a rescan.
+func (s *Store) UpdateRescan(r *Rescan) error {
+	r.UpdatedAt = time.Now().Unix()
+	return s.db.Update(func(tx *bolt.Tx) error {
+		data, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(rescansBucket).Put(replicationKey(r.ID), data)
+	})
+}
+
+func (s *Store) GetRescan(id uint64) (*Rescan, error) {
+	var r *Rescan
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(rescansBucket).Get(replicationKey(id))
+		if data == nil {
+			return fmt.Errorf("rescan %d not found", id)
+		}
+		r = &Rescan{}
+		return json.Unmarshal(data, r)
+	})
+	return r, err
+}
+
+// ListRescans returns up to limit rescans, newest first. A status selects
+// only rescans in that status.
+func (s *Store) ListRescans(status string, limit int) ([]Rescan, error) {
+	var result []Rescan
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(rescansBucket).Cursor()
+		for k, v := c.Last(); k != nil && (limit <= 0 || len(result) < limit); k, v = c.Prev() {
+			var r Rescan
+			if err := json.Unmarshal(v, &r); err != nil {
+				continue
+			}
+			if status == "" || r.Status == status {
+				result = append(result, r)
+			}
+		}
+		return nil
+	})
+	return result, err
+}
+
+// ListObjectMetaAfter returns up to limit objects of a bucket whose keys
+// start with prefix and sort after the key after, in key order.
+func (s *Store) ListObjectMetaAfter(bucket, prefix, after string, limit int) ([]ObjectMeta, error) {
+	var result []ObjectMeta
+	bucketPrefix := bucket + "/"
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(objectsBucket).Cursor()
+		k, v := c.Seek(objectMetaKey(bucket, prefix))
+		if after != "" && after >= prefix {
+			k, v = c.Seek(objectMetaKey(bucket, after))
+			if k != nil && string(k) == string(objectMetaKey(bucket, after)) {
+				k, v = c.Next()
+			}
+		}
+		for ; k != nil && len(result) < limit; k, v = c.Next() {
+			key, ok := strings.CutPrefix(string(k), bucketPrefix)
+			if !ok || !strings.HasPrefix(key, prefix) {
+				break
+			}
+			var meta ObjectMeta
+			if err := json.Unmarshal(v, &meta); err != nil {
+				continue
+			}
+			result = append(result, meta)
+		}
+		return nil
+	})
+	return result, err
+}
+
+// CountObjects returns the number of objects of a bucket whose keys start
+// with prefix.
+func (s *Store) CountObjects(bucket, prefix string) (int64, error) {
+	var n int64
+	start := objectMetaKey(bucket, prefix)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(objectsBucket).Cursor()
+		for k, _ := c.Seek(start); k != nil && strings.HasPrefix(string(k), string(start)); k, _ = c.Next() {
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
@@ -0,0 +1,326 @@
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Scan statuses of objects written to buckets that scan before serving.
+// Objects in other buckets have no scan status.
+const (
+	ScanPending  = "pending"  // not served until the scanner decides
+	ScanClean    = "clean"    // scanned and found clean
+	ScanError    = "error"    // could not be scanned; served because the scanner fails open
+	ScanSkipped  = "skipped"  // larger than the scanner's max scan size; not served until it can be scanned
+	ScanReleased = "released" // released from quarantine by an admin
+)
+
+// ScanHeld reports whether objects with a scan status are held back from
+// serving: pending a scan, or too large to be scanned. Held objects are
+// scanned again when the scanner can take them.
+func ScanHeld(status string) bool {
+	return status == ScanPending || status == ScanSkipped
+}
+
+// QuarantineRecord remembers where a quarantined object came from so that
+// it can be released.
+type QuarantineRecord struct {
+	Key           string       `json:"key"` // key in the quarantine bucket
+	Bucket        string       `json:"bucket"`
+	ObjectKey     string       `json:"object_key"`
+	Reason        string       `json:"reason"`
+	QuarantinedAt int64        `json:"quarantined_at"`
+	Meta          ObjectMeta   `json:"meta"`              // metadata of the object when it was quarantined
+	History       []ScanRecord `json:"history,omitempty"` // scan history of the object, newest first
+}
+
+// SetBucketScanBeforeServe sets whether objects written to a bucket are
+// held back until the scanner marks them clean.
+func (s *Store) SetBucketScanBeforeServe(name string, enabled bool) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketsBucket)
+		data := b.Get([]byte(name))
+		if data == nil {
+			return fmt.Errorf("bucket not found: %s", name)
+		}
+		var info BucketInfo
+		if err := json.Unmarshal(data, &info); err != nil {
+			return err
+		}
+		info.ScanBeforeServe = enabled
+		updated, err := json.Marshal(info)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(name), updated)
+	})
+}
+
+// putPendingScan keeps the index of held objects in step with an object's
+// latest metadata.
+func putPendingScan(tx *bolt.Tx, meta ObjectMeta) error {
+	b := tx.Bucket(pendingScansBucket)
+	if ScanHeld(meta.ScanStatus) {
+		return b.Put(objectMetaKey(meta.Bucket, meta.Key), []byte(meta.ETag))
+	}
+	return b.Delete(objectMetaKey(meta.Bucket, meta.Key))
+}
+
+// SetObjectScanStatus records the scan status of an object that is still
+// held. It does nothing when the object was overwritten since the scan
+// started (its ETag changed) or is no longer held.
+func (s *Store) SetObjectScanStatus(bucket, key, etag, status string) error {
+	var changed *ObjectMeta
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		changed = nil
+		objects := tx.Bucket(objectsBucket)
+		data := objects.Get(objectMetaKey(bucket, key))
+		if data == nil {
+			return tx.Bucket(pendingScansBucket).Delete(objectMetaKey(bucket, key))
+		}
+		var meta ObjectMeta
+		if err := json.Unmarshal(data, &meta); err != nil {
+			return err
+		}
+		if !ScanHeld(meta.ScanStatus) || meta.ETag != etag {
+			return nil
+		}
+		meta.ScanStatus = status
+		updated, err := json.Marshal(meta)
+		if err != nil {
+			return err
+		}
+		if err := objects.Put(objectMetaKey(bucket, key), updated); err != nil {
+			return err
+		}
+		if meta.VersionID != "" {
+			versions := tx.Bucket(objectVersionsBucket)
+			if versions.Get(versionKey(bucket, key, meta.VersionID)) != nil {
+				if err := versions.Put(versionKey(bucket, key, meta.VersionID), updated); err != nil {
+					return err
+				}
+			}
+		}
+		changed = &meta
+		return putPendingScan(tx, meta)
+	})
+	if err == nil && changed != nil {
+		s.objectChanged(bucket, key, changed)
+	}
+	return err
+}
+
+// ListPendingScans returns the held objects still waiting for a scan, so
+// that they can be queued again after a restart or once they fit the
+// scanner's size limit.
+func (s *Store) ListPendingScans() ([]ObjectMeta, error) {
+	var result []ObjectMeta
+	err := s.db.View(func(tx *bolt.Tx) error {
+		objects := tx.Bucket(objectsBucket)
+		return tx.Bucket(pendingScansBucket).ForEach(func(k, _ []byte) error {
+			data := objects.Get(k)
+			if data == nil {
+				return nil
+			}
+			var meta ObjectMeta
+			if err := json.Unmarshal(data, &meta); err != nil {
+				return nil
+			}
+			if ScanHeld(meta.ScanStatus) {
+				result = append(result, meta)
+			}
+			return nil
+		})
+	})
+	return result, err
+}
+
+// PutQuarantineRecord stores where a quarantined object came from.
+func (s *Store) PutQuarantineRecord(rec QuarantineRecord) error {
+	if rec.QuarantinedAt == 0 {
+		rec.QuarantinedAt = time.Now().Unix()
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(quarantineBucket).Put([]byte(rec.Key), data)
+	})
+}
+
+// GetQuarantineRecord returns the record of a quarantined object by its key
+// in the quarantine bucket.
+func (s *Store) GetQuarantineRecord(key string) (*QuarantineRecord, error) {
+	var rec *QuarantineRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(quarantineBucket).Get([]byte(key))
+		if data == nil {
+			return fmt.Errorf("quarantine record not found: %s", key)
+		}
+		rec = &QuarantineRecord{}
+		return json.Unmarshal(data, rec)
+	})
+	return rec, err
+}
+
+// ListQuarantineRecords returns all quarantine records, newest first.
+func (s *Store) ListQuarantineRecords() ([]QuarantineRecord, error) {
+	var result []QuarantineRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(quarantineBucket).ForEach(func(_, v []byte) error {
+			var rec QuarantineRecord
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return nil
+			}
+			result = append(result, rec)
+			return nil
+		})
+	})
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].QuarantinedAt > result[j].QuarantinedAt
+	})
+	return result, err
+}
+
+func (s *Store) DeleteQuarantineRecord(key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(quarantineBucket).Delete([]byte(key))
+	})
+}
+
+// ScanRecord is one scan of an object, kept in the object's scan history.
+type ScanRecord struct {
+	ScannedAt int64  `json:"scanned_at"`
+	ETag      string `json:"etag,omitempty"`
+	Engine    string `json:"engine"`  // scanning engine and its signature version, or "blocklist"
+	Verdict   string `json:"verdict"` // "clean", "infected" or "error"
+	Detail    string `json:"detail,omitempty"`
+	RescanID  uint64 `json:"rescan_id,omitempty"` // set when a rescan scanned the object
+}
+
+// maxScanHistory is how many scans of an object are kept.
+const maxScanHistory = 20
+
+// AddScanRecord appends a scan to an object's history, dropping the oldest
+// scans beyond maxScanHistory.
+func (s *Store) AddScanRecord(bucket, key string, rec ScanRecord) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(scanHistoryBucket)
+		var history []ScanRecord
+		if data := b.Get(objectMetaKey(bucket, key)); data != nil {
+			json.Unmarshal(data, &history)
+		}
+		history = append(history, rec)
+		if len(history) > maxScanHistory {
+			history = history[len(history)-maxScanHistory:]
+		}
+		data, err := json.Marshal(history)
+		if err != nil {
+			return err
+		}
+		return b.Put(objectMetaKey(bucket, key), data)
+	})
+}
+
+// GetScanHistory returns the scans of an object, newest first.
+func (s *Store) GetScanHistory(bucket, key string) ([]ScanRecord, error) {
+	var history []ScanRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(scanHistoryBucket).Get(objectMetaKey(bucket, key))
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, &history)
+	})
+	for i, j := 0, len(history)-1; i < j; i, j = i+1, j-1 {
+		history[i], history[j] = history[j], history[i]
+	}
+	return history, err
+}
+
+// PutScanHistory replaces the scan history of an object, given newest
+// first as GetScanHistory returns it.
+func (s *Store) PutScanHistory(bucket, key string, history []ScanRecord) error {
+	ordered := make([]ScanRecord, len(history))
+	for i, rec := range history {
+		ordered[len(history)-1-i] = rec
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		data, err := json.Marshal(ordered)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(scanHistoryBucket).Put(objectMetaKey(bucket, key), data)
+	})
+}
+
+// BlocklistEntry is the SHA-256 of content known to be malicious. The
+// scanner reports matching objects as infected without asking its backend.
+type BlocklistEntry struct {
+	SHA256  string `json:"sha256"` // lowercase hex
+	Name    string `json:"name"`   // reported as the detection name
+	AddedAt int64  `json:"added_at"`
+}
+
+func (s *Store) PutBlocklistEntry(entry BlocklistEntry) error {
+	if entry.AddedAt == 0 {
+		entry.AddedAt = time.Now().Unix()
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(scanBlocklistBucket).Put([]byte(entry.SHA256), data)
+	})
+}
+
+func (s *Store) GetBlocklistEntry(sha256 string) (*BlocklistEntry, error) {
+	var entry *BlocklistEntry
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(scanBlocklistBucket).Get([]byte(sha256))
+		if data == nil {
+			return fmt.Errorf("blocklist entry not found: %s", sha256)
+		}
+		entry = &BlocklistEntry{}
+		return json.Unmarshal(data, entry)
+	})
+	return entry, err
+}
+
+// ListBlocklist returns all blocklist entries ordered by hash.
+func (s *Store) ListBlocklist() ([]BlocklistEntry, error) {
+	var result []BlocklistEntry
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(scanBlocklistBucket).ForEach(func(_, v []byte) error {
+			var entry BlocklistEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return nil
+			}
+			result = append(result, entry)
+			return nil
+		})
+	})
+	return result, err
+}
+
+// BlocklistSize returns the number of blocklist entries.
+func (s *Store) BlocklistSize() int {
+	var n int
+	s.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket(scanBlocklistBucket).Stats().KeyN
+		return nil
+	})
+	return n
+}
+
+func (s *Store) DeleteBlocklistEntry(sha256 string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(scanBlocklistBucket).Delete([]byte(sha256))
+	})
+}
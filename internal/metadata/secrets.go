@@ -0,0 +1,265 @@
+package metadata
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// RedactedSecret replaces secret values in API responses. Writing it back
+// keeps the stored value.
+const RedactedSecret = "REDACTED"
+
+// sealedPrefix marks secret values encrypted at rest.
+const sealedPrefix = "enc:v1:"
+
+// IsSealedSecret reports whether v is in the format of a secret encrypted at
+// rest. Only the store writes such values; they are rejected as input.
+func IsSealedSecret(v string) bool {
+	return strings.HasPrefix(v, sealedPrefix)
+}
+
+// WebhookAuth configures how VaultS3 authenticates itself to a webhook
+// endpoint. Secret, ClientKey and the header values are stored encrypted and
+// redacted in API responses.
+type WebhookAuth struct {
+	Secret         string            `json:"secret,omitempty"`           // HMAC-SHA256 signing secret
+	Headers        map[string]string `json:"headers,omitempty"`          // static headers sent with every request, e.g. Authorization
+	BearerTokenRef string            `json:"bearer_token_ref,omitempty"` // name of a secret of security.webhook_secrets holding a bearer token
+	ClientCert     string            `json:"client_cert,omitempty"`      // PEM client certificate for mTLS
+	ClientKey      string            `json:"client_key,omitempty"`       // PEM private key of ClientCert
+	CABundle       string            `json:"ca_bundle,omitempty"`        // PEM CAs trusted for the endpoint
+}
+
+// Redacted returns a copy of a with its secrets replaced by RedactedSecret.
+func (a *WebhookAuth) Redacted() *WebhookAuth {
+	if a == nil {
+		return nil
+	}
+	out := *a
+	if out.Secret != "" {
+		out.Secret = RedactedSecret
+	}
+	if out.ClientKey != "" {
+		out.ClientKey = RedactedSecret
+	}
+	if len(a.Headers) > 0 {
+		out.Headers = make(map[string]string, len(a.Headers))
+		for k := range a.Headers {
+			out.Headers[k] = RedactedSecret
+		}
+	}
+	return &out
+}
+
+// KeepSecrets replaces redacted secrets in a with the values of prev, so that
+// a configuration read from the API can be written back unchanged.
+func (a *WebhookAuth) KeepSecrets(prev *WebhookAuth) {
+	if a == nil {
+		return
+	}
+	if a.Secret == RedactedSecret {
+		a.Secret = ""
+		if prev != nil {
+			a.Secret = prev.Secret
+		}
+	}
+	if a.ClientKey == RedactedSecret {
+		a.ClientKey = ""
+		if prev != nil {
+			a.ClientKey = prev.ClientKey
+		}
+	}
+	for k, v := range a.Headers {
+		if v != RedactedSecret {
+			continue
+		}
+		if prev != nil && prev.Headers[k] != "" {
+			a.Headers[k] = prev.Headers[k]
+		} else {
+			delete(a.Headers, k)
+		}
+	}
+}
+
+// SetSecretsKey sets the 32-byte AES-256 key that webhook secrets are
+// encrypted with at rest. Without a key, configurations carrying secrets are
+// rejected.
+func (s *Store) SetSecretsKey(key []byte) error {
+	if len(key) != 32 {
+		return fmt.Errorf("secrets key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return fmt.Errorf("create AES cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return fmt.Errorf("create GCM: %w", err)
+	}
+	s.secrets = gcm
+	return nil
+}
+
+// sealSecret encrypts v. Secrets are always read back decrypted, so a value
+// that already looks sealed did not come from the store and is rejected
+// rather than stored as-is.
+func (s *Store) sealSecret(v string) (string, error) {
+	if v == "" {
+		return v, nil
+	}
+	if IsSealedSecret(v) {
+		return "", fmt.Errorf("webhook secrets must not start with %q", sealedPrefix)
+	}
+	if s.secrets == nil {
+		return "", fmt.Errorf("cannot store webhook secrets: no secrets key configured")
+	}
+	nonce := make([]byte, s.secrets.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("generate nonce: %w", err)
+	}
+	sealed := s.secrets.Seal(nonce, nonce, []byte(v), nil)
+	return sealedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (s *Store) openSecret(v string) (string, error) {
+	if !strings.HasPrefix(v, sealedPrefix) {
+		return v, nil
+	}
+	if s.secrets == nil {
+		return "", fmt.Errorf("cannot read webhook secrets: no secrets key configured")
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(v, sealedPrefix))
+	if err != nil || len(data) < s.secrets.NonceSize() {
+		return "", fmt.Errorf("malformed webhook secret")
+	}
+	n := s.secrets.NonceSize()
+	plain, err := s.secrets.Open(nil, data[:n], data[n:], nil)
+	if err != nil {
+		return "", fmt.Errorf("decrypt webhook secret: %w", err)
+	}
+	return string(plain), nil
+}
+
+// sealAuth returns a copy of a with its secrets encrypted.
+func (s *Store) sealAuth(a *WebhookAuth) (*WebhookAuth, error) {
+	if a == nil {
+		return nil, nil
+	}
+	out := *a
+	var err error
+	if out.Secret, err = s.sealSecret(a.Secret); err != nil {
+		return nil, err
+	}
+	if out.ClientKey, err = s.sealSecret(a.ClientKey); err != nil {
+		return nil, err
+	}
+	if len(a.Headers) > 0 {
+		out.Headers = make(map[string]string, len(a.Headers))
+		for k, v := range a.Headers {
+			if out.Headers[k], err = s.sealSecret(v); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &out, nil
+}
+
+// openAuth decrypts the secrets of a in place.
+func (s *Store) openAuth(a *WebhookAuth) error {
+	if a == nil {
+		return nil
+	}
+	var err error
+	if a.Secret, err = s.openSecret(a.Secret); err != nil {
+		return err
+	}
+	if a.ClientKey, err = s.openSecret(a.ClientKey); err != nil {
+		return err
+	}
+	for k, v := range a.Headers {
+		if a.Headers[k], err = s.openSecret(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) sealNotificationConfig(cfg BucketNotificationConfig) (BucketNotificationConfig, error) {
+	webhooks := make([]NotificationEndpointConfig, len(cfg.Webhooks))
+	for i, wh := range cfg.Webhooks {
+		auth, err := s.sealAuth(wh.Auth)
+		if err != nil {
+			return cfg, err
+		}
+		wh.Auth = auth
+		webhooks[i] = wh
+	}
+	cfg.Webhooks = webhooks
+	return cfg, nil
+}
+
+func (s *Store) openNotificationConfig(cfg *BucketNotificationConfig) error {
+	for i := range cfg.Webhooks {
+		if err := s.openAuth(cfg.Webhooks[i].Auth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) sealLambdaConfig(cfg BucketLambdaConfig) (BucketLambdaConfig, error) {
+	triggers := make([]LambdaTrigger, len(cfg.Triggers))
+	for i, t := range cfg.Triggers {
+		auth, err := s.sealAuth(t.Auth)
+		if err != nil {
+			return cfg, err
+		}
+		t.Auth = auth
+		triggers[i] = t
+	}
+	cfg.Triggers = triggers
+	return cfg, nil
+}
+
+func (s *Store) openLambdaConfig(cfg *BucketLambdaConfig) error {
+	for i := range cfg.Triggers {
+		if err := s.openAuth(cfg.Triggers[i].Auth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) sealPipelineConfig(cfg BucketPipelineConfig) (BucketPipelineConfig, error) {
+	pipelines := make([]LambdaPipeline, len(cfg.Pipelines))
+	for i, p := range cfg.Pipelines {
+		steps := make([]PipelineStep, len(p.Steps))
+		for j, step := range p.Steps {
+			auth, err := s.sealAuth(step.Auth)
+			if err != nil {
+				return cfg, err
+			}
+			step.Auth = auth
+			steps[j] = step
+		}
+		p.Steps = steps
+		pipelines[i] = p
+	}
+	cfg.Pipelines = pipelines
+	return cfg, nil
+}
+
+func (s *Store) openPipelineConfig(cfg *BucketPipelineConfig) error {
+	for _, p := range cfg.Pipelines {
+		for j := range p.Steps {
+			if err := s.openAuth(p.Steps[j].Auth); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
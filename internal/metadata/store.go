@@ -1,10 +1,13 @@
 package metadata
 
 import (
+	"bytes"
 	"context"
+	"crypto/cipher"
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -39,25 +42,78 @@ var (
 	changeLogBucket         = []byte("change_log")
 	replicationConfigBucket = []byte("replication_configs")
 	serverSettingsBucket    = []byte("server_settings")
+	restoreRequestsBucket   = []byte("restore_requests")
+	intTieringBucket        = []byte("intelligent_tiering_configs")
+	batchJobsBucket         = []byte("batch_jobs")
+	batchResultsBucket      = []byte("batch_results")
+	multipartUsageBucket    = []byte("multipart_usage")
+	notifyOutboxBucket      = []byte("notify_outbox")
+	notifyDeadLetterBucket  = []byte("notify_dead_letter")
+	notifyDueBucket         = []byte("notify_due")
+	notifyPendingBucket     = []byte("notify_pending")
+	notifyCountsBucket      = []byte("notify_counts")
+	eventLogBucket          = []byte("event_log")
+	lambdaQueueBucket       = []byte("lambda_queue")
+	lambdaDeadLetterBucket  = []byte("lambda_dead_letter")
+	lambdaInvocationsBucket = []byte("lambda_invocations")
+	lambdaPipelinesBucket   = []byte("lambda_pipelines")
+	pipelineRunsBucket      = []byte("pipeline_runs")
+	pendingScansBucket      = []byte("pending_scans")
+	quarantineBucket        = []byte("quarantine_records")
+	uploadPoliciesBucket    = []byte("upload_policies")
+	scanHistoryBucket       = []byte("scan_history")
+	scanBlocklistBucket     = []byte("scan_blocklist")
+	rescansBucket           = []byte("rescans")
 )
 
 type Store struct {
-	db *bolt.DB
+	db       *bolt.DB
+	secrets  cipher.AEAD    // encrypts webhook secrets at rest; see SetSecretsKey
+	observer ObjectObserver // see SetObjectObserver
 }
 
 type LifecycleRule struct {
-	ID                              string            `json:"id,omitempty"`
-	ExpirationDays                  int               `json:"expiration_days"`
-	Prefix                          string            `json:"prefix,omitempty"`
-	Status                          string            `json:"status"` // "Enabled" or "Disabled"
-	TagFilter                       map[string]string `json:"tag_filter,omitempty"`
-	NoncurrentVersionExpirationDays int               `json:"noncurrent_version_expiration_days,omitempty"`
-	MaxNoncurrentVersions           int               `json:"max_noncurrent_versions,omitempty"`
-	AbortIncompleteMultipartDays    int               `json:"abort_incomplete_multipart_days,omitempty"`
-	ExpiredObjectDeleteMarker       bool              `json:"expired_object_delete_marker,omitempty"`
-	ObjectSizeGreaterThan           int64             `json:"object_size_greater_than,omitempty"`
-	ObjectSizeLessThan              int64             `json:"object_size_less_than,omitempty"`
-}
+	ID                              string                        `json:"id,omitempty"`
+	ExpirationDays                  int                           `json:"expiration_days"`
+	ExpirationDate                  string                        `json:"expiration_date,omitempty"` // RFC3339, midnight UTC
+	Prefix                          string                        `json:"prefix,omitempty"`
+	ExplicitPrefix                  bool                          `json:"explicit_prefix,omitempty"` // filter had a Prefix element, possibly empty
+	FilterForm                      string                        `json:"filter_form,omitempty"`     // see LifecycleFilter* constants
+	Status                          string                        `json:"status"`                    // "Enabled" or "Disabled"
+	TagFilter                       map[string]string             `json:"tag_filter,omitempty"`
+	NoncurrentVersionExpirationDays int                           `json:"noncurrent_version_expiration_days,omitempty"`
+	MaxNoncurrentVersions           int                           `json:"max_noncurrent_versions,omitempty"`
+	AbortIncompleteMultipartDays    int                           `json:"abort_incomplete_multipart_days,omitempty"`
+	ExpiredObjectDeleteMarker       bool                          `json:"expired_object_delete_marker,omitempty"`
+	ObjectSizeGreaterThan           int64                         `json:"object_size_greater_than,omitempty"`
+	ObjectSizeLessThan              int64                         `json:"object_size_less_than,omitempty"`
+	Transitions                     []LifecycleTransition         `json:"transitions,omitempty"`
+	NoncurrentVersionTransitions    []NoncurrentVersionTransition `json:"noncurrent_version_transitions,omitempty"`
+}
+
+// LifecycleTransition moves current objects to another storage class after
+// Days since creation or once Date (RFC3339) is reached.
+type LifecycleTransition struct {
+	Days         int    `json:"days,omitempty"`
+	Date         string `json:"date,omitempty"`
+	StorageClass string `json:"storage_class"`
+}
+
+// NoncurrentVersionTransition moves versions to another storage class
+// NoncurrentDays after they became noncurrent.
+type NoncurrentVersionTransition struct {
+	NoncurrentDays          int    `json:"noncurrent_days"`
+	NewerNoncurrentVersions int    `json:"newer_noncurrent_versions,omitempty"` // newest noncurrent versions to keep in place
+	StorageClass            string `json:"storage_class"`
+}
+
+// How a lifecycle rule's filter was expressed in S3 XML, kept so that
+// configurations round-trip unchanged.
+const (
+	LifecycleFilterSimple = ""       // <Filter> with at most one predicate
+	LifecycleFilterAnd    = "and"    // <Filter><And>...</And></Filter>
+	LifecycleFilterLegacy = "legacy" // deprecated rule-level <Prefix>
+)
 
 type LifecycleConfig struct {
 	Rules []LifecycleRule `json:"rules"`
@@ -77,7 +133,9 @@ type BucketInfo struct {
 	DefaultRetentionMode string            `json:"default_retention_mode,omitempty"` // "GOVERNANCE" or "COMPLIANCE"
 	DefaultRetentionDays int               `json:"default_retention_days,omitempty"`
 	Tags                 map[string]string `json:"tags,omitempty"`
-	FIFOQuota            bool              `json:"fifo_quota,omitempty"` // delete oldest objects to make room instead of rejecting
+	FIFOQuota            bool              `json:"fifo_quota,omitempty"`        // delete oldest objects to make room instead of rejecting
+	ScanBeforeServe      bool              `json:"scan_before_serve,omitempty"` // hold new objects back until the scanner marks them clean
+	ContentIndex         bool              `json:"content_index,omitempty"`     // index the text of objects for full-text search
 }
 
 type AccessKey struct {
@@ -128,15 +186,28 @@ type LambdaTriggerFilter struct {
 	Suffix string `json:"suffix,omitempty"`
 }
 
+// Lambda trigger runtimes.
+const (
+	LambdaRuntimeHTTP    = "http"    // POST the event to FunctionURL
+	LambdaRuntimeProcess = "process" // run an allow-listed local command
+)
+
 type LambdaTrigger struct {
 	ID                string              `json:"id"`
-	FunctionURL       string              `json:"function_url"`
+	Runtime           string              `json:"runtime,omitempty"` // http if empty
+	FunctionURL       string              `json:"function_url,omitempty"`
+	Command           string              `json:"command,omitempty"` // name of a lambda.commands entry, for the process runtime
 	Events            []string            `json:"events"`
 	Filters           LambdaTriggerFilter `json:"filters,omitempty"`
 	OutputBucket      string              `json:"output_bucket,omitempty"`
 	OutputKeyTemplate string              `json:"output_key_template,omitempty"`
 	IncludeBody       bool                `json:"include_body"`
 	MaxBodySize       int64               `json:"max_body_size,omitempty"`
+	Auth              *WebhookAuth        `json:"auth,omitempty"`
+	// Retries and concurrency; zero values use the lambda config defaults.
+	MaxRetries       int `json:"max_retries,omitempty"`        // attempts before dead-lettering
+	RetryBackoffSecs int `json:"retry_backoff_secs,omitempty"` // first retry delay, doubling per attempt
+	MaxConcurrency   int `json:"max_concurrency,omitempty"`    // concurrent calls of this trigger
 }
 
 type BucketLambdaConfig struct {
@@ -170,10 +241,22 @@ type NotificationEndpointConfig struct {
 	Events   []string                 `json:"events"`
 	Filters  []NotificationFilterRule `json:"filters,omitempty"`
 	Endpoint string                   `json:"endpoint"`
+	Auth     *WebhookAuth             `json:"auth,omitempty"`
+}
+
+// NotificationTargetConfig routes a bucket's matching events to a named
+// notification target, referenced by its ARN.
+type NotificationTargetConfig struct {
+	ID      string                   `json:"id"`
+	Kind    string                   `json:"kind"` // Queue, Topic or CloudFunction, as configured in XML
+	ARN     string                   `json:"arn"`
+	Events  []string                 `json:"events"`
+	Filters []NotificationFilterRule `json:"filters,omitempty"`
 }
 
 type BucketNotificationConfig struct {
 	Webhooks []NotificationEndpointConfig `json:"webhooks"`
+	Targets  []NotificationTargetConfig   `json:"targets,omitempty"`
 }
 
 type AuditEntry struct {
@@ -201,6 +284,34 @@ type ReplicationEvent struct {
 	CreatedAt   int64  `json:"created_at"`    // unix timestamp
 }
 
+// NotificationEvent is an event notification waiting in the outbox for
+// delivery to one destination, or dead-lettered after too many failures.
+type NotificationEvent struct {
+	ID            uint64 `json:"id"`
+	Kind          string `json:"kind"`                // webhook, target or backend
+	Destination   string `json:"destination"`         // webhook URL, target ARN or backend name
+	ConfigID      string `json:"config_id,omitempty"` // ID of the webhook or target configuration
+	EventName     string `json:"event_name"`
+	Bucket        string `json:"bucket"`
+	Key           string `json:"key"`
+	Payload       []byte `json:"payload"`
+	Attempts      int    `json:"attempts"`
+	LastError     string `json:"last_error,omitempty"`
+	NextAttemptAt int64  `json:"next_attempt_at"`   // unix nanos
+	CreatedAt     int64  `json:"created_at"`        // unix nanos
+	DeadAt        int64  `json:"dead_at,omitempty"` // unix nanos
+}
+
+// NotificationQueueStats summarises the outbox and dead letters of one
+// destination.
+type NotificationQueueStats struct {
+	Kind          string `json:"kind"`
+	Destination   string `json:"destination"`
+	Pending       int    `json:"pending"`
+	OldestPending int64  `json:"oldest_pending,omitempty"` // unix nanos
+	DeadLettered  int    `json:"dead_lettered"`
+}
+
 type ReplicationStatus struct {
 	Peer         string `json:"peer"`
 	QueueDepth   int    `json:"queue_depth"`
@@ -222,18 +333,125 @@ type BackupRecord struct {
 	Error       string `json:"error,omitempty"`
 }
 
-type MultipartUpload struct {
-	UploadID    string `json:"upload_id"`
+// RestoreRequest tracks an archive restore from the cold tier. While ExpiresAt
+// is zero the restore is still queued; afterwards it records when the
+// temporary hot copy must be removed again.
+type RestoreRequest struct {
 	Bucket      string `json:"bucket"`
 	Key         string `json:"key"`
-	ContentType string `json:"content_type"`
-	CreatedAt   int64  `json:"created_at"`
+	VersionID   string `json:"version_id,omitempty"`
+	Tier        string `json:"tier"` // "Expedited", "Standard" or "Bulk"
+	Days        int    `json:"days"`
+	RequestedAt int64  `json:"requested_at"`
+	ReadyAt     int64  `json:"ready_at"`             // earliest time the restore may run
+	ExpiresAt   int64  `json:"expires_at,omitempty"` // unix timestamp, 0 while ongoing
+}
+
+// IntelligentTieringConfig opts INTELLIGENT_TIERING objects matching its
+// filter into the archive access tiers after the given days without access.
+type IntelligentTieringConfig struct {
+	ID        string               `json:"id"`
+	Status    string               `json:"status"` // "Enabled" or "Disabled"
+	Prefix    string               `json:"prefix,omitempty"`
+	TagFilter map[string]string    `json:"tag_filter,omitempty"`
+	Tierings  []IntelligentTiering `json:"tierings"`
+}
+
+// IntelligentTiering is an archive access tier and the days without access
+// after which objects move into it.
+type IntelligentTiering struct {
+	AccessTier string `json:"access_tier"` // "ARCHIVE_ACCESS" or "DEEP_ARCHIVE_ACCESS"
+	Days       int    `json:"days"`
+}
+
+// BatchJob is a batch operation over the objects listed by a manifest.
+// Processed is the checkpoint: the number of manifest entries already done.
+// Total, the number of manifest entries, is known once a job completes.
+type BatchJob struct {
+	ID         string         `json:"id"`
+	Operation  string         `json:"operation"`
+	Priority   int            `json:"priority"`
+	Status     string         `json:"status"` // "queued", "running", "paused", "cancelled", "completed", "failed"
+	Manifest   BatchManifest  `json:"manifest"`
+	Params     BatchJobParams `json:"params"`
+	Report     BatchReport    `json:"report"`
+	Total      int            `json:"total"`
+	Processed  int            `json:"processed"`
+	LastKey    string         `json:"last_key,omitempty"` // checkpoint of prefix manifests
+	Succeeded  int            `json:"succeeded"`
+	Failed     int            `json:"failed"`
+	Error      string         `json:"error,omitempty"`
+	ReportKey  string         `json:"report_key,omitempty"`
+	CreatedAt  int64          `json:"created_at"`
+	StartedAt  int64          `json:"started_at,omitempty"`
+	FinishedAt int64          `json:"finished_at,omitempty"`
+}
+
+// BatchManifest lists the objects of a batch job: a CSV object of
+// bucket,key[,versionId] rows, an inventory report, or a bucket prefix.
+type BatchManifest struct {
+	Format string `json:"format"` // "csv", "inventory" or "prefix"
+	Bucket string `json:"bucket"`
+	Key    string `json:"key,omitempty"`
+	Prefix string `json:"prefix,omitempty"`
+}
+
+// BatchJobParams holds the operation-specific arguments of a batch job.
+type BatchJobParams struct {
+	DstBucket          string            `json:"dst_bucket,omitempty"`
+	DstPrefix          string            `json:"dst_prefix,omitempty"`
+	Tags               map[string]string `json:"tags,omitempty"`
+	ContentType        string            `json:"content_type,omitempty"`
+	ContentEncoding    string            `json:"content_encoding,omitempty"`
+	ContentDisposition string            `json:"content_disposition,omitempty"`
+	CacheControl       string            `json:"cache_control,omitempty"`
+	ContentLanguage    string            `json:"content_language,omitempty"`
+	UserMetadata       map[string]string `json:"user_metadata,omitempty"`
+	RetentionMode      string            `json:"retention_mode,omitempty"` // "GOVERNANCE" or "COMPLIANCE"
+	RetainUntil        int64             `json:"retain_until,omitempty"`   // unix timestamp
+	LegalHold          string            `json:"legal_hold,omitempty"`     // "ON" or "OFF"
+	RestoreTier        string            `json:"restore_tier,omitempty"`
+	RestoreDays        int               `json:"restore_days,omitempty"`
+	StorageClass       string            `json:"storage_class,omitempty"`
+	TriggerID          string            `json:"trigger_id,omitempty"`
+}
+
+// BatchReport configures the completion report of a batch job.
+type BatchReport struct {
+	Enabled bool   `json:"enabled"`
+	Bucket  string `json:"bucket,omitempty"`
+	Prefix  string `json:"prefix,omitempty"`
+	Scope   string `json:"scope,omitempty"` // "AllTasks" or "FailedTasksOnly"
+}
+
+// BatchTaskResult is the outcome of a batch job for one manifest entry.
+type BatchTaskResult struct {
+	Seq       int    `json:"seq"`
+	Bucket    string `json:"bucket"`
+	Key       string `json:"key"`
+	VersionID string `json:"version_id,omitempty"`
+	Status    string `json:"status"` // "succeeded" or "failed"
+	Error     string `json:"error,omitempty"`
+}
+
+type MultipartUpload struct {
+	UploadID          string `json:"upload_id"`
+	Bucket            string `json:"bucket"`
+	Key               string `json:"key"`
+	ContentType       string `json:"content_type"`
+	CreatedAt         int64  `json:"created_at"`
+	ChecksumAlgorithm string `json:"checksum_algorithm,omitempty"` // SHA256, SHA1, CRC32 or CRC32C
 }
 
 type PartInfo struct {
-	PartNumber int    `json:"part_number"`
-	ETag       string `json:"etag"`
-	Size       int64  `json:"size"`
+	PartNumber     int    `json:"part_number"`
+	ETag           string `json:"etag"`
+	Size           int64  `json:"size"`
+	LastModified   int64  `json:"last_modified,omitempty"`
+	ChecksumSHA256 string `json:"checksum_sha256,omitempty"`
+	ChecksumCRC32  string `json:"checksum_crc32,omitempty"`
+	ChecksumCRC32C string `json:"checksum_crc32c,omitempty"`
+	ChecksumSHA1   string `json:"checksum_sha1,omitempty"`
 }
 
 type ObjectMeta struct {
@@ -250,9 +468,14 @@ type ObjectMeta struct {
 	LegalHold      bool              `json:"legal_hold,omitempty"`
 	RetentionMode  string            `json:"retention_mode,omitempty"`   // "GOVERNANCE" or "COMPLIANCE"
 	RetentionUntil int64             `json:"retention_until,omitempty"`  // unix timestamp
-	Tier           string            `json:"tier,omitempty"`             // "hot" or "cold", default "hot"
+	Tier           string            `json:"tier,omitempty"`             // "hot", "cold" or "remote", default "hot"
+	StorageClass   string            `json:"storage_class,omitempty"`    // S3 storage class, default "STANDARD"
+	AccessTier     string            `json:"access_tier,omitempty"`      // Intelligent-Tiering access tier, default frequent access
 	LastAccessTime int64             `json:"last_access_time,omitempty"` // unix timestamp
+	RestoreOngoing bool              `json:"restore_ongoing,omitempty"`  // archive restore queued or running
+	RestoreExpiry  int64             `json:"restore_expiry,omitempty"`   // unix timestamp when the restored copy expires
 	VectorClock    json.RawMessage   `json:"vector_clock,omitempty"`     // vector clock for active-active replication
+	ScanStatus     string            `json:"scan_status,omitempty"`      // see Scan* constants; empty unless the bucket scans before serving
 
 	// Phase 1: S3-compatible metadata headers
 	UserMetadata       map[string]string `json:"user_metadata,omitempty"`
@@ -269,6 +492,19 @@ type ObjectMeta struct {
 	WebsiteRedirect    string            `json:"website_redirect,omitempty"`
 	ContentMD5         string            `json:"content_md5,omitempty"`
 	PartBoundaries     []int64           `json:"part_boundaries,omitempty"` // cumulative byte offsets for each part
+	Parts              []PartInfo        `json:"parts,omitempty"`           // parts of a multipart object, for GetObjectAttributes
+}
+
+// EffectiveStorageClass returns the S3 storage class reported for the object.
+// Objects moved to the cold tier by age-based tiering report GLACIER.
+func (m *ObjectMeta) EffectiveStorageClass() string {
+	if m.StorageClass != "" {
+		return m.StorageClass
+	}
+	if m.Tier == "cold" {
+		return "GLACIER"
+	}
+	return "STANDARD"
 }
 
 func NewStore(path string) (*Store, error) {
@@ -356,6 +592,78 @@ func NewStore(path string) (*Store, error) {
 		if _, err := tx.CreateBucketIfNotExists(serverSettingsBucket); err != nil {
 			return err
 		}
+		if _, err := tx.CreateBucketIfNotExists(restoreRequestsBucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(intTieringBucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(batchJobsBucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(batchResultsBucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(notifyOutboxBucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(notifyDeadLetterBucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(eventLogBucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(lambdaQueueBucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(lambdaDeadLetterBucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(lambdaInvocationsBucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(lambdaPipelinesBucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(pipelineRunsBucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(pendingScansBucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(quarantineBucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(uploadPoliciesBucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(scanHistoryBucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(scanBlocklistBucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(rescansBucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(notifyDueBucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(notifyPendingBucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(notifyCountsBucket); err != nil {
+			return err
+		}
+		if tx.Bucket(multipartUsageBucket) == nil {
+			// Account for parts stored before usage was tracked
+			if _, err := tx.CreateBucket(multipartUsageBucket); err != nil {
+				return err
+			}
+			if err := rebuildMultipartUsage(tx); err != nil {
+				return err
+			}
+		}
 		return nil
 	})
 	if err != nil {
@@ -388,13 +696,17 @@ func (s *Store) CreateBucket(name string) error {
 }
 
 func (s *Store) DeleteBucket(name string) error {
-	return s.db.Update(func(tx *bolt.Tx) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket(bucketsBucket)
 		if b.Get([]byte(name)) == nil {
 			return fmt.Errorf("bucket not found: %s", name)
 		}
 		return b.Delete([]byte(name))
 	})
+	if err == nil {
+		s.bucketDeleted(name)
+	}
+	return err
 }
 
 func (s *Store) GetBucket(name string) (*BucketInfo, error) {
@@ -589,14 +901,31 @@ func objectMetaKey(bucket, key string) []byte {
 }
 
 func (s *Store) PutObjectMeta(meta ObjectMeta) error {
-	return s.db.Update(func(tx *bolt.Tx) error {
+	return s.PutObjectMetaWithNotifications(meta, nil)
+}
+
+// PutObjectMetaWithNotifications writes meta and persists the outbox events
+// of the change in the same transaction, so a committed change is never
+// missing its notifications.
+func (s *Store) PutObjectMetaWithNotifications(meta ObjectMeta, events []NotificationEvent) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket(objectsBucket)
 		data, err := json.Marshal(meta)
 		if err != nil {
 			return err
 		}
-		return b.Put(objectMetaKey(meta.Bucket, meta.Key), data)
+		if err := b.Put(objectMetaKey(meta.Bucket, meta.Key), data); err != nil {
+			return err
+		}
+		if err := putPendingScan(tx, meta); err != nil {
+			return err
+		}
+		return enqueueNotifications(tx, events)
 	})
+	if err == nil {
+		s.objectChanged(meta.Bucket, meta.Key, &meta)
+	}
+	return err
 }
 
 func (s *Store) GetObjectMeta(bucket, key string) (*ObjectMeta, error) {
@@ -613,11 +942,52 @@ func (s *Store) GetObjectMeta(bucket, key string) (*ObjectMeta, error) {
 	return meta, err
 }
 
+// GetObjectMetas returns the metadata of several keys of a bucket, read in one
+// transaction. Keys without metadata are missing from the result.
+func (s *Store) GetObjectMetas(bucket string, keys []string) (map[string]*ObjectMeta, error) {
+	metas := make(map[string]*ObjectMeta, len(keys))
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(objectsBucket)
+		for _, key := range keys {
+			data := b.Get(objectMetaKey(bucket, key))
+			if data == nil {
+				continue
+			}
+			meta := &ObjectMeta{}
+			if err := json.Unmarshal(data, meta); err != nil {
+				continue // skip corrupt entries
+			}
+			metas[key] = meta
+		}
+		return nil
+	})
+	return metas, err
+}
+
 func (s *Store) DeleteObjectMeta(bucket, key string) error {
-	return s.db.Update(func(tx *bolt.Tx) error {
+	return s.DeleteObjectMetaWithNotifications(bucket, key, nil)
+}
+
+// DeleteObjectMetaWithNotifications deletes the metadata of an object and
+// persists the outbox events of the deletion in the same transaction.
+func (s *Store) DeleteObjectMetaWithNotifications(bucket, key string, events []NotificationEvent) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket(objectsBucket)
-		return b.Delete(objectMetaKey(bucket, key))
+		if err := b.Delete(objectMetaKey(bucket, key)); err != nil {
+			return err
+		}
+		if err := tx.Bucket(scanHistoryBucket).Delete(objectMetaKey(bucket, key)); err != nil {
+			return err
+		}
+		if err := tx.Bucket(pendingScansBucket).Delete(objectMetaKey(bucket, key)); err != nil {
+			return err
+		}
+		return enqueueNotifications(tx, events)
 	})
+	if err == nil {
+		s.objectChanged(bucket, key, nil)
+	}
+	return err
 }
 
 // UpdateLastAccess updates the last access time on an object's metadata.
@@ -640,10 +1010,11 @@ func (s *Store) UpdateLastAccess(bucket, key string) {
 
 // AccessUpdater batches last-access time updates and flushes to BoltDB periodically.
 type AccessUpdater struct {
-	store    *Store
-	mu       sync.Mutex
-	dirty    map[string]int64
-	interval time.Duration
+	store        *Store
+	mu           sync.Mutex
+	dirty        map[string]int64
+	interval     time.Duration
+	onAccessTier func(bucket, accessTier string)
 }
 
 // NewAccessUpdater creates an updater that flushes every flushInterval.
@@ -655,6 +1026,12 @@ func NewAccessUpdater(store *Store, flushInterval time.Duration) *AccessUpdater
 	}
 }
 
+// SetAccessTierFunc sets the callback for INTELLIGENT_TIERING objects that an
+// access moved back to the frequent access tier.
+func (u *AccessUpdater) SetAccessTierFunc(fn func(bucket, accessTier string)) {
+	u.onAccessTier = fn
+}
+
 // MarkAccess records an access without writing to BoltDB.
 func (u *AccessUpdater) MarkAccess(bucket, key string) {
 	now := time.Now().Unix()
@@ -674,8 +1051,10 @@ func (u *AccessUpdater) Flush() {
 	u.dirty = make(map[string]int64)
 	u.mu.Unlock()
 
+	var promoted []string
 	u.store.db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket(objectsBucket)
+		vb := tx.Bucket(objectVersionsBucket)
 		for compositeKey, ts := range snapshot {
 			parts := strings.SplitN(compositeKey, "\x00", 2)
 			if len(parts) != 2 {
@@ -691,14 +1070,40 @@ func (u *AccessUpdater) Flush() {
 				continue
 			}
 			meta.LastAccessTime = ts
+			// Intelligent-Tiering moves accessed objects in the instantly
+			// readable tiers back to frequent access
+			resetTier := meta.StorageClass == "INTELLIGENT_TIERING" && meta.AccessTier != "" &&
+				(meta.Tier == "" || meta.Tier == "hot")
+			if resetTier {
+				meta.AccessTier = ""
+				promoted = append(promoted, meta.Bucket)
+			}
 			updated, err := json.Marshal(meta)
 			if err != nil {
 				continue
 			}
 			b.Put(dbKey, updated)
+			if resetTier && meta.VersionID != "" {
+				if data := vb.Get(versionKey(parts[0], parts[1], meta.VersionID)); data != nil {
+					var version ObjectMeta
+					if json.Unmarshal(data, &version) == nil {
+						version.AccessTier = ""
+						version.LastAccessTime = ts
+						if updated, err := json.Marshal(version); err == nil {
+							vb.Put(versionKey(parts[0], parts[1], meta.VersionID), updated)
+						}
+					}
+				}
+			}
 		}
 		return nil
 	})
+
+	if u.onAccessTier != nil {
+		for _, bucket := range promoted {
+			u.onAccessTier(bucket, "FREQUENT_ACCESS")
+		}
+	}
 }
 
 // Run starts the background flush loop. Cancel ctx to stop.
@@ -718,20 +1123,129 @@ func (u *AccessUpdater) Run(ctx context.Context) {
 
 // SetObjectTier updates the storage tier for an object.
 func (s *Store) SetObjectTier(bucket, key, tier string) error {
-	return s.db.Update(func(tx *bolt.Tx) error {
+	var meta ObjectMeta
+	err := s.db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket(objectsBucket)
 		data := b.Get(objectMetaKey(bucket, key))
 		if data == nil {
 			return fmt.Errorf("object not found")
 		}
-		var meta ObjectMeta
 		if err := json.Unmarshal(data, &meta); err != nil {
 			return err
 		}
 		meta.Tier = tier
+		if tier == "hot" {
+			// Data is back in the hot tier; any temporary restored copy is now permanent
+			meta.RestoreOngoing = false
+			meta.RestoreExpiry = 0
+			meta.StorageClass = ""
+		}
 		updated, _ := json.Marshal(meta)
 		return b.Put(objectMetaKey(bucket, key), updated)
 	})
+	if err == nil {
+		s.objectChanged(bucket, key, &meta)
+	}
+	return err
+}
+
+// SetObjectStorageClass records a storage class transition for an object or,
+// when versionID is set, for one of its versions. The latest pointer is kept
+// in sync when it refers to the same version.
+func (s *Store) SetObjectStorageClass(bucket, key, versionID, tier, storageClass string) error {
+	return s.updateObjectVersionMeta(bucket, key, versionID, func(meta *ObjectMeta) {
+		meta.Tier = tier
+		meta.StorageClass = storageClass
+		meta.AccessTier = ""
+		if tier == "hot" {
+			meta.RestoreOngoing = false
+			meta.RestoreExpiry = 0
+		}
+	})
+}
+
+// SetObjectAccessTier moves an INTELLIGENT_TIERING object or version to
+// another access tier backed by tier. An empty accessTier means frequent
+// access, which also counts as an access.
+func (s *Store) SetObjectAccessTier(bucket, key, versionID, tier, accessTier string) error {
+	now := time.Now().Unix()
+	return s.updateObjectVersionMeta(bucket, key, versionID, func(meta *ObjectMeta) {
+		meta.Tier = tier
+		meta.AccessTier = accessTier
+		if tier == "hot" {
+			meta.RestoreOngoing = false
+			meta.RestoreExpiry = 0
+		}
+		if accessTier == "" {
+			meta.LastAccessTime = now
+		}
+	})
+}
+
+// updateObjectVersionMeta applies update to the metadata of an object
+// version and to the latest pointer when it refers to the same version.
+func (s *Store) updateObjectVersionMeta(bucket, key, versionID string, update func(*ObjectMeta)) error {
+	var current *ObjectMeta
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		current = nil
+		found := false
+		if versionID != "" {
+			vb := tx.Bucket(objectVersionsBucket)
+			if data := vb.Get(versionKey(bucket, key, versionID)); data != nil {
+				var meta ObjectMeta
+				if err := json.Unmarshal(data, &meta); err != nil {
+					return err
+				}
+				update(&meta)
+				updated, err := json.Marshal(meta)
+				if err != nil {
+					return err
+				}
+				if err := vb.Put(versionKey(bucket, key, versionID), updated); err != nil {
+					return err
+				}
+				found = true
+			}
+		}
+
+		ob := tx.Bucket(objectsBucket)
+		if data := ob.Get(objectMetaKey(bucket, key)); data != nil {
+			var meta ObjectMeta
+			if err := json.Unmarshal(data, &meta); err != nil {
+				return err
+			}
+			if meta.VersionID == versionID {
+				update(&meta)
+				updated, err := json.Marshal(meta)
+				if err != nil {
+					return err
+				}
+				if err := ob.Put(objectMetaKey(bucket, key), updated); err != nil {
+					return err
+				}
+				current = &meta
+				found = true
+			}
+		}
+
+		if !found {
+			return fmt.Errorf("object not found")
+		}
+		return nil
+	})
+	if err == nil && current != nil {
+		s.objectChanged(bucket, key, current)
+	}
+	return err
+}
+
+// SetObjectRestoreStatus updates the archive restore state of an object
+// version, and of the latest pointer when it refers to the same version.
+func (s *Store) SetObjectRestoreStatus(bucket, key, versionID string, ongoing bool, expiry int64) error {
+	return s.updateObjectVersionMeta(bucket, key, versionID, func(meta *ObjectMeta) {
+		meta.RestoreOngoing = ongoing
+		meta.RestoreExpiry = expiry
+	})
 }
 
 // IterateAllObjects scans all object metadata entries.
@@ -786,22 +1300,41 @@ func (s *Store) GetMultipartUpload(uploadID string) (*MultipartUpload, error) {
 func (s *Store) DeleteMultipartUpload(uploadID string) error {
 	return s.db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket(multipartBucket)
+		var upload MultipartUpload
+		found := false
+		if data := b.Get([]byte(uploadID)); data != nil {
+			found = json.Unmarshal(data, &upload) == nil
+		}
 		if err := b.Delete([]byte(uploadID)); err != nil {
 			return err
 		}
 		// Also delete all parts for this upload
 		pb := tx.Bucket(partsBucket)
 		prefix := []byte(uploadID + "/")
+		var keys [][]byte
+		var size int64
 		c := pb.Cursor()
-		for k, _ := c.Seek(prefix); k != nil && len(k) >= len(prefix) && string(k[:len(prefix)]) == string(prefix); k, _ = c.Next() {
+		for k, v := c.Seek(prefix); k != nil && len(k) >= len(prefix) && string(k[:len(prefix)]) == string(prefix); k, v = c.Next() {
+			keys = append(keys, append([]byte(nil), k...))
+			var part PartInfo
+			if json.Unmarshal(v, &part) == nil {
+				size += part.Size
+			}
+		}
+		for _, k := range keys {
 			if err := pb.Delete(k); err != nil {
 				return err
 			}
 		}
+		if found {
+			return addMultipartUsage(tx, upload.Bucket, -size)
+		}
 		return nil
 	})
 }
 
+// PutPart stores a part and charges its size, less that of any part it
+// replaces, to the multipart usage of the upload's bucket.
 func (s *Store) PutPart(uploadID string, part PartInfo) error {
 	return s.db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket(partsBucket)
@@ -809,9 +1342,84 @@ func (s *Store) PutPart(uploadID string, part PartInfo) error {
 		if err != nil {
 			return err
 		}
-		key := fmt.Sprintf("%s/%05d", uploadID, part.PartNumber)
-		return b.Put([]byte(key), data)
+		key := []byte(fmt.Sprintf("%s/%05d", uploadID, part.PartNumber))
+		delta := part.Size
+		if old := b.Get(key); old != nil {
+			var prev PartInfo
+			if json.Unmarshal(old, &prev) == nil {
+				delta -= prev.Size
+			}
+		}
+		if err := b.Put(key, data); err != nil {
+			return err
+		}
+		if udata := tx.Bucket(multipartBucket).Get([]byte(uploadID)); udata != nil {
+			var upload MultipartUpload
+			if err := json.Unmarshal(udata, &upload); err == nil {
+				return addMultipartUsage(tx, upload.Bucket, delta)
+			}
+		}
+		return nil
+	})
+}
+
+// MultipartUsage returns the bytes held by the stored parts of a bucket's
+// in-progress multipart uploads.
+func (s *Store) MultipartUsage(bucket string) int64 {
+	var usage int64
+	s.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(multipartUsageBucket).Get([]byte(bucket)); len(v) == 8 {
+			usage = int64(binary.BigEndian.Uint64(v))
+		}
+		return nil
+	})
+	return usage
+}
+
+func addMultipartUsage(tx *bolt.Tx, bucket string, delta int64) error {
+	if delta == 0 {
+		return nil
+	}
+	b := tx.Bucket(multipartUsageBucket)
+	var usage int64
+	if v := b.Get([]byte(bucket)); len(v) == 8 {
+		usage = int64(binary.BigEndian.Uint64(v))
+	}
+	usage += delta
+	if usage <= 0 {
+		return b.Delete([]byte(bucket))
+	}
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, uint64(usage))
+	return b.Put([]byte(bucket), v)
+}
+
+// rebuildMultipartUsage recomputes the multipart usage of every bucket from
+// the stored parts.
+func rebuildMultipartUsage(tx *bolt.Tx) error {
+	buckets := make(map[string]string)
+	tx.Bucket(multipartBucket).ForEach(func(k, v []byte) error {
+		var u MultipartUpload
+		if json.Unmarshal(v, &u) == nil {
+			buckets[string(k)] = u.Bucket
+		}
+		return nil
+	})
+	usage := make(map[string]int64)
+	tx.Bucket(partsBucket).ForEach(func(k, v []byte) error {
+		uploadID, _, _ := strings.Cut(string(k), "/")
+		var part PartInfo
+		if bucket, ok := buckets[uploadID]; ok && json.Unmarshal(v, &part) == nil {
+			usage[bucket] += part.Size
+		}
+		return nil
 	})
+	for bucket, size := range usage {
+		if err := addMultipartUsage(tx, bucket, size); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (s *Store) ListParts(uploadID string) ([]PartInfo, error) {
@@ -894,7 +1502,7 @@ func (s *Store) DeleteBucketTags(bucket string) error {
 
 func (s *Store) DeleteBucketObjectMeta(bucket string) error {
 	prefix := []byte(bucket + "/")
-	return s.db.Update(func(tx *bolt.Tx) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket(objectsBucket)
 		c := b.Cursor()
 		for k, _ := c.Seek(prefix); k != nil && len(k) >= len(prefix) && string(k[:len(prefix)]) == string(prefix); k, _ = c.Next() {
@@ -904,6 +1512,10 @@ func (s *Store) DeleteBucketObjectMeta(bucket string) error {
 		}
 		return nil
 	})
+	if err == nil {
+		s.bucketDeleted(bucket)
+	}
+	return err
 }
 
 // Bucket versioning operations
@@ -950,6 +1562,28 @@ func (s *Store) SetBucketDefaultRetention(bucket, mode string, days int) error {
 	})
 }
 
+// SetBucketContentIndex sets whether the text of a bucket's objects is
+// indexed for full-text search.
+func (s *Store) SetBucketContentIndex(bucket string, enabled bool) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketsBucket)
+		data := b.Get([]byte(bucket))
+		if data == nil {
+			return fmt.Errorf("bucket not found: %s", bucket)
+		}
+		var info BucketInfo
+		if err := json.Unmarshal(data, &info); err != nil {
+			return err
+		}
+		info.ContentIndex = enabled
+		updated, err := json.Marshal(info)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(bucket), updated)
+	})
+}
+
 func (s *Store) GetBucketVersioning(bucket string) (string, error) {
 	info, err := s.GetBucket(bucket)
 	if err != nil {
@@ -1057,37 +1691,149 @@ func (s *Store) ListObjectVersions(bucket, prefix, keyMarker, versionMarker stri
 	return versions, truncated, err
 }
 
-// SetLatestVersion updates the objects bucket "latest pointer" for a key.
-func (s *Store) SetLatestVersion(bucket, key, versionID string) error {
-	return s.db.Update(func(tx *bolt.Tx) error {
-		vb := tx.Bucket(objectVersionsBucket)
-		data := vb.Get(versionKey(bucket, key, versionID))
-		if data == nil {
-			return fmt.Errorf("version not found")
-		}
-		ob := tx.Bucket(objectsBucket)
-		return ob.Put(objectMetaKey(bucket, key), data)
+// NewestObjectVersion returns the most recently modified version of exactly
+// key, or nil when the key has no versions left.
+func (s *Store) NewestObjectVersion(bucket, key string) (*ObjectMeta, error) {
+	var newest *ObjectMeta
+	err := s.db.View(func(tx *bolt.Tx) error {
+		newest = newestVersion(tx, bucket, key)
+		return nil
 	})
+	return newest, err
 }
 
-// UpdateObjectVersionMeta updates a version's metadata in-place (for lock operations).
-func (s *Store) UpdateObjectVersionMeta(meta ObjectMeta) error {
-	return s.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket(objectVersionsBucket)
-		data, err := json.Marshal(meta)
-		if err != nil {
-			return err
+func newestVersion(tx *bolt.Tx, bucket, key string) *ObjectMeta {
+	var newest *ObjectMeta
+	prefix := versionPrefix(bucket, key)
+	c := tx.Bucket(objectVersionsBucket).Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		var meta ObjectMeta
+		if err := json.Unmarshal(v, &meta); err != nil {
+			continue
 		}
-		if err := b.Put(versionKey(meta.Bucket, meta.Key, meta.VersionID), data); err != nil {
-			return err
+		// Version IDs start with a timestamp, so they break ties
+		if newest == nil || meta.LastModified > newest.LastModified ||
+			(meta.LastModified == newest.LastModified && meta.VersionID > newest.VersionID) {
+			newest = &meta
 		}
-		// Also update the objects bucket if this is the latest
-		if meta.IsLatest {
-			ob := tx.Bucket(objectsBucket)
-			return ob.Put(objectMetaKey(meta.Bucket, meta.Key), data)
+	}
+	return newest
+}
+
+// RemoveObjectVersion deletes the metadata of one version of an object, or
+// of the unversioned object when versionID is empty, if its ETag is still
+// etag (any ETag when etag is empty). When the removed version was the
+// latest, the latest metadata of the key moves to the newest remaining
+// version, or is deleted when no version is left. It reports whether the
+// version was removed; callers delete its data only then.
+func (s *Store) RemoveObjectVersion(bucket, key, versionID, etag string) (bool, error) {
+	var removed, latestChanged bool
+	var latest *ObjectMeta
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		removed, latestChanged, latest = false, false, nil
+		objects := tx.Bucket(objectsBucket)
+		versions := tx.Bucket(objectVersionsBucket)
+		var data []byte
+		if versionID != "" {
+			data = versions.Get(versionKey(bucket, key, versionID))
+		} else {
+			data = objects.Get(objectMetaKey(bucket, key))
+		}
+		if data == nil {
+			return nil
+		}
+		var meta ObjectMeta
+		if err := json.Unmarshal(data, &meta); err != nil {
+			return err
+		}
+		if etag != "" && meta.ETag != etag {
+			return nil
+		}
+		removed = true
+		if versionID != "" {
+			if err := versions.Delete(versionKey(bucket, key, versionID)); err != nil {
+				return err
+			}
+			var current ObjectMeta
+			if data := objects.Get(objectMetaKey(bucket, key)); data == nil || json.Unmarshal(data, &current) != nil || current.VersionID != versionID {
+				return nil
+			}
+		}
+		latestChanged = true
+		if versionID != "" {
+			latest = newestVersion(tx, bucket, key)
+		}
+		if latest == nil {
+			if err := objects.Delete(objectMetaKey(bucket, key)); err != nil {
+				return err
+			}
+			if err := tx.Bucket(scanHistoryBucket).Delete(objectMetaKey(bucket, key)); err != nil {
+				return err
+			}
+			return tx.Bucket(pendingScansBucket).Delete(objectMetaKey(bucket, key))
+		}
+		latest.IsLatest = true
+		updated, err := json.Marshal(latest)
+		if err != nil {
+			return err
+		}
+		if err := versions.Put(versionKey(bucket, key, latest.VersionID), updated); err != nil {
+			return err
+		}
+		if err := objects.Put(objectMetaKey(bucket, key), updated); err != nil {
+			return err
+		}
+		return putPendingScan(tx, *latest)
+	})
+	if err == nil && latestChanged {
+		s.objectChanged(bucket, key, latest)
+	}
+	return removed, err
+}
+
+// SetLatestVersion updates the objects bucket "latest pointer" for a key.
+func (s *Store) SetLatestVersion(bucket, key, versionID string) error {
+	var meta ObjectMeta
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		vb := tx.Bucket(objectVersionsBucket)
+		data := vb.Get(versionKey(bucket, key, versionID))
+		if data == nil {
+			return fmt.Errorf("version not found")
+		}
+		if err := json.Unmarshal(data, &meta); err != nil {
+			return err
+		}
+		ob := tx.Bucket(objectsBucket)
+		return ob.Put(objectMetaKey(bucket, key), data)
+	})
+	if err == nil {
+		s.objectChanged(bucket, key, &meta)
+	}
+	return err
+}
+
+// UpdateObjectVersionMeta updates a version's metadata in-place (for lock operations).
+func (s *Store) UpdateObjectVersionMeta(meta ObjectMeta) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(objectVersionsBucket)
+		data, err := json.Marshal(meta)
+		if err != nil {
+			return err
+		}
+		if err := b.Put(versionKey(meta.Bucket, meta.Key, meta.VersionID), data); err != nil {
+			return err
+		}
+		// Also update the objects bucket if this is the latest
+		if meta.IsLatest {
+			ob := tx.Bucket(objectsBucket)
+			return ob.Put(objectMetaKey(meta.Bucket, meta.Key), data)
 		}
 		return nil
 	})
+	if err == nil && meta.IsLatest {
+		s.objectChanged(meta.Bucket, meta.Key, &meta)
+	}
+	return err
 }
 
 // Lifecycle rule operations
@@ -1592,6 +2338,10 @@ func (s *Store) PruneAuditEntries(olderThan time.Time) (int, error) {
 // Notification config operations
 
 func (s *Store) PutNotificationConfig(bucket string, cfg BucketNotificationConfig) error {
+	cfg, err := s.sealNotificationConfig(cfg)
+	if err != nil {
+		return err
+	}
 	return s.db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket(notificationBucket)
 		data, err := json.Marshal(cfg)
@@ -1611,7 +2361,10 @@ func (s *Store) GetNotificationConfig(bucket string) (*BucketNotificationConfig,
 			return fmt.Errorf("no notification config for bucket: %s", bucket)
 		}
 		cfg = &BucketNotificationConfig{}
-		return json.Unmarshal(data, cfg)
+		if err := json.Unmarshal(data, cfg); err != nil {
+			return err
+		}
+		return s.openNotificationConfig(cfg)
 	})
 	return cfg, err
 }
@@ -1632,6 +2385,9 @@ func (s *Store) ListNotificationConfigs() (map[string]BucketNotificationConfig,
 			if err := json.Unmarshal(v, &cfg); err != nil {
 				return nil
 			}
+			if err := s.openNotificationConfig(&cfg); err != nil {
+				return err
+			}
 			configs[string(k)] = cfg
 			return nil
 		})
@@ -1639,6 +2395,554 @@ func (s *Store) ListNotificationConfigs() (map[string]BucketNotificationConfig,
 	return configs, err
 }
 
+// Restore request operations
+
+// restoreRequestKey keys restores by object version. Unversioned objects
+// have no version ID and use the bucket and key alone.
+func restoreRequestKey(bucket, key, versionID string) []byte {
+	if versionID == "" {
+		return []byte(bucket + "\x00" + key)
+	}
+	return []byte(bucket + "\x00" + key + "\x00" + versionID)
+}
+
+func (s *Store) PutRestoreRequest(req RestoreRequest) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(restoreRequestsBucket)
+		data, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		return b.Put(restoreRequestKey(req.Bucket, req.Key, req.VersionID), data)
+	})
+}
+
+func (s *Store) GetRestoreRequest(bucket, key, versionID string) (*RestoreRequest, error) {
+	var req *RestoreRequest
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(restoreRequestsBucket)
+		data := b.Get(restoreRequestKey(bucket, key, versionID))
+		if data == nil {
+			return fmt.Errorf("no restore request for %s/%s", bucket, key)
+		}
+		req = &RestoreRequest{}
+		return json.Unmarshal(data, req)
+	})
+	return req, err
+}
+
+func (s *Store) DeleteRestoreRequest(bucket, key, versionID string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(restoreRequestsBucket)
+		return b.Delete(restoreRequestKey(bucket, key, versionID))
+	})
+}
+
+func (s *Store) ListRestoreRequests() ([]RestoreRequest, error) {
+	var reqs []RestoreRequest
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(restoreRequestsBucket)
+		return b.ForEach(func(k, v []byte) error {
+			var req RestoreRequest
+			if err := json.Unmarshal(v, &req); err != nil {
+				return nil
+			}
+			reqs = append(reqs, req)
+			return nil
+		})
+	})
+	return reqs, err
+}
+
+// Intelligent-Tiering configuration operations
+
+func intTieringKey(bucket, id string) []byte {
+	return []byte(bucket + "\x00" + id)
+}
+
+func (s *Store) PutIntelligentTieringConfig(bucket string, cfg IntelligentTieringConfig) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(intTieringBucket)
+		data, err := json.Marshal(cfg)
+		if err != nil {
+			return err
+		}
+		return b.Put(intTieringKey(bucket, cfg.ID), data)
+	})
+}
+
+func (s *Store) GetIntelligentTieringConfig(bucket, id string) (*IntelligentTieringConfig, error) {
+	var cfg *IntelligentTieringConfig
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(intTieringBucket)
+		data := b.Get(intTieringKey(bucket, id))
+		if data == nil {
+			return fmt.Errorf("no intelligent-tiering configuration %s for bucket %s", id, bucket)
+		}
+		cfg = &IntelligentTieringConfig{}
+		return json.Unmarshal(data, cfg)
+	})
+	return cfg, err
+}
+
+func (s *Store) DeleteIntelligentTieringConfig(bucket, id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(intTieringBucket)
+		return b.Delete(intTieringKey(bucket, id))
+	})
+}
+
+// ListIntelligentTieringConfigs returns a bucket's configurations ordered by ID.
+func (s *Store) ListIntelligentTieringConfigs(bucket string) ([]IntelligentTieringConfig, error) {
+	var cfgs []IntelligentTieringConfig
+	prefix := []byte(bucket + "\x00")
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(intTieringBucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && len(k) >= len(prefix) && string(k[:len(prefix)]) == string(prefix); k, v = c.Next() {
+			var cfg IntelligentTieringConfig
+			if err := json.Unmarshal(v, &cfg); err != nil {
+				continue
+			}
+			cfgs = append(cfgs, cfg)
+		}
+		return nil
+	})
+	return cfgs, err
+}
+
+// Batch job operations
+
+func batchResultKey(jobID string, seq int) []byte {
+	return []byte(fmt.Sprintf("%s\x00%012d", jobID, seq))
+}
+
+func (s *Store) PutBatchJob(job BatchJob) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(batchJobsBucket)
+		data, err := json.Marshal(job)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(job.ID), data)
+	})
+}
+
+func (s *Store) GetBatchJob(id string) (*BatchJob, error) {
+	var job *BatchJob
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(batchJobsBucket)
+		data := b.Get([]byte(id))
+		if data == nil {
+			return fmt.Errorf("batch job %s not found", id)
+		}
+		job = &BatchJob{}
+		return json.Unmarshal(data, job)
+	})
+	return job, err
+}
+
+// DeleteBatchJob removes a job along with its task results.
+func (s *Store) DeleteBatchJob(id string) error {
+	if err := s.DeleteBatchTaskResults(id); err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(batchJobsBucket).Delete([]byte(id))
+	})
+}
+
+func (s *Store) ListBatchJobs() ([]BatchJob, error) {
+	var jobs []BatchJob
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(batchJobsBucket)
+		return b.ForEach(func(k, v []byte) error {
+			var job BatchJob
+			if err := json.Unmarshal(v, &job); err != nil {
+				return nil
+			}
+			jobs = append(jobs, job)
+			return nil
+		})
+	})
+	return jobs, err
+}
+
+// CheckpointBatchJob saves a job together with the task results gathered
+// since its last checkpoint in one transaction, so that progress and report
+// never disagree after a restart.
+func (s *Store) CheckpointBatchJob(job BatchJob, results []BatchTaskResult) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		rb := tx.Bucket(batchResultsBucket)
+		for _, r := range results {
+			data, err := json.Marshal(r)
+			if err != nil {
+				return err
+			}
+			if err := rb.Put(batchResultKey(job.ID, r.Seq), data); err != nil {
+				return err
+			}
+		}
+		data, err := json.Marshal(job)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(batchJobsBucket).Put([]byte(job.ID), data)
+	})
+}
+
+// IterateBatchTaskResults calls fn for each task result of a job in manifest
+// order. Iteration stops early if fn returns false.
+func (s *Store) IterateBatchTaskResults(jobID string, fn func(BatchTaskResult) bool) error {
+	prefix := []byte(jobID + "\x00")
+	return s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(batchResultsBucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && len(k) >= len(prefix) && string(k[:len(prefix)]) == string(prefix); k, v = c.Next() {
+			var r BatchTaskResult
+			if err := json.Unmarshal(v, &r); err != nil {
+				continue
+			}
+			if !fn(r) {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+func (s *Store) DeleteBatchTaskResults(jobID string) error {
+	prefix := []byte(jobID + "\x00")
+	return s.db.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket(batchResultsBucket).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && len(k) >= len(prefix) && string(k[:len(prefix)]) == string(prefix); k, _ = c.Seek(prefix) {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Notification outbox operations
+//
+// Outbox events are kept by ID in notifyOutboxBucket and indexed twice:
+// notifyDueBucket orders them by next attempt, so dequeueing seeks to the
+// due ones, and notifyPendingBucket by destination and creation time, for
+// the oldest pending event of a destination. notifyCountsBucket counts the
+// pending and dead-lettered events of each destination.
+
+// notifyDueKey is the next attempt time and ID of an outbox event.
+func notifyDueKey(event NotificationEvent) []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key, uint64(max(event.NextAttemptAt, 0)))
+	binary.BigEndian.PutUint64(key[8:], event.ID)
+	return key
+}
+
+// notifyDestination is the key prefix of a destination in
+// notifyPendingBucket and its key in notifyCountsBucket.
+func notifyDestination(kind, destination string) []byte {
+	return []byte(kind + "\x00" + destination + "\x00")
+}
+
+// notifyPendingKey is the destination, creation time and ID of an outbox
+// event.
+func notifyPendingKey(event NotificationEvent) []byte {
+	key := notifyDestination(event.Kind, event.Destination)
+	key = binary.BigEndian.AppendUint64(key, uint64(max(event.CreatedAt, 0)))
+	return binary.BigEndian.AppendUint64(key, event.ID)
+}
+
+// putOutboxEvent writes an outbox event and its index entries. A new event,
+// without an ID, gets one and is counted as pending.
+func putOutboxEvent(tx *bolt.Tx, event NotificationEvent) error {
+	b := tx.Bucket(notifyOutboxBucket)
+	if event.ID == 0 {
+		id, _ := b.NextSequence()
+		event.ID = id
+		if event.CreatedAt == 0 {
+			event.CreatedAt = time.Now().UnixNano()
+		}
+		if err := addNotifyCounts(tx, event, 1, 0); err != nil {
+			return err
+		}
+		if err := tx.Bucket(notifyPendingBucket).Put(notifyPendingKey(event), nil); err != nil {
+			return err
+		}
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if err := b.Put(replicationKey(event.ID), data); err != nil {
+		return err
+	}
+	return tx.Bucket(notifyDueBucket).Put(notifyDueKey(event), nil)
+}
+
+// getOutboxEvent reads an outbox event; it returns nil if there is none.
+func getOutboxEvent(tx *bolt.Tx, id uint64) (*NotificationEvent, error) {
+	data := tx.Bucket(notifyOutboxBucket).Get(replicationKey(id))
+	if data == nil {
+		return nil, nil
+	}
+	var event NotificationEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+// deleteOutboxEvent removes an outbox event and its index entries.
+func deleteOutboxEvent(tx *bolt.Tx, event NotificationEvent) error {
+	if err := tx.Bucket(notifyDueBucket).Delete(notifyDueKey(event)); err != nil {
+		return err
+	}
+	if err := tx.Bucket(notifyPendingBucket).Delete(notifyPendingKey(event)); err != nil {
+		return err
+	}
+	if err := addNotifyCounts(tx, event, -1, 0); err != nil {
+		return err
+	}
+	return tx.Bucket(notifyOutboxBucket).Delete(replicationKey(event.ID))
+}
+
+// addNotifyCounts adds to the pending and dead-lettered counts of the
+// destination of event.
+func addNotifyCounts(tx *bolt.Tx, event NotificationEvent, pending, dead int) error {
+	b := tx.Bucket(notifyCountsBucket)
+	key := notifyDestination(event.Kind, event.Destination)
+	counts := make([]byte, 16)
+	if v := b.Get(key); len(v) == 16 {
+		copy(counts, v)
+	}
+	p := max(int64(binary.BigEndian.Uint64(counts))+int64(pending), 0)
+	d := max(int64(binary.BigEndian.Uint64(counts[8:]))+int64(dead), 0)
+	if p == 0 && d == 0 {
+		return b.Delete(key)
+	}
+	binary.BigEndian.PutUint64(counts, uint64(p))
+	binary.BigEndian.PutUint64(counts[8:], uint64(d))
+	return b.Put(key, counts)
+}
+
+// EnqueueNotifications persists events in the outbox in one transaction and
+// assigns their IDs.
+func (s *Store) EnqueueNotifications(events []NotificationEvent) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return enqueueNotifications(tx, events)
+	})
+}
+
+func enqueueNotifications(tx *bolt.Tx, events []NotificationEvent) error {
+	for _, event := range events {
+		event.ID = 0
+		if err := putOutboxEvent(tx, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DequeueNotifications returns up to limit outbox events due at now, in the
+// order they became due, leaving out those for which skip returns true.
+func (s *Store) DequeueNotifications(limit int, now int64, skip func(id uint64) bool) ([]NotificationEvent, error) {
+	var events []NotificationEvent
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(notifyDueBucket).Cursor()
+		for k, _ := c.First(); k != nil && len(events) < limit; k, _ = c.Next() {
+			if len(k) != 16 {
+				continue
+			}
+			if int64(binary.BigEndian.Uint64(k)) > now {
+				break
+			}
+			id := binary.BigEndian.Uint64(k[8:])
+			if skip != nil && skip(id) {
+				continue
+			}
+			event, err := getOutboxEvent(tx, id)
+			if err != nil || event == nil {
+				continue
+			}
+			events = append(events, *event)
+		}
+		return nil
+	})
+	return events, err
+}
+
+// AckNotification removes a delivered event from the outbox.
+func (s *Store) AckNotification(id uint64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		event, err := getOutboxEvent(tx, id)
+		if err != nil || event == nil {
+			return err
+		}
+		return deleteOutboxEvent(tx, *event)
+	})
+}
+
+// NackNotification records a failed delivery attempt of an outbox event and
+// when to try it next.
+func (s *Store) NackNotification(id uint64, attempts int, nextAttemptAt int64, lastError string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		event, err := getOutboxEvent(tx, id)
+		if err != nil || event == nil {
+			return err
+		}
+		if err := tx.Bucket(notifyDueBucket).Delete(notifyDueKey(*event)); err != nil {
+			return err
+		}
+		event.Attempts = attempts
+		event.NextAttemptAt = nextAttemptAt
+		event.LastError = lastError
+		return putOutboxEvent(tx, *event)
+	})
+}
+
+// DeadLetterNotification moves an outbox event to the dead letters.
+func (s *Store) DeadLetterNotification(id uint64, attempts int, lastError string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		event, err := getOutboxEvent(tx, id)
+		if err != nil || event == nil {
+			return err
+		}
+		if err := deleteOutboxEvent(tx, *event); err != nil {
+			return err
+		}
+		event.Attempts = attempts
+		event.LastError = lastError
+		event.DeadAt = time.Now().UnixNano()
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		if err := tx.Bucket(notifyDeadLetterBucket).Put(replicationKey(id), data); err != nil {
+			return err
+		}
+		return addNotifyCounts(tx, *event, 0, 1)
+	})
+}
+
+// ListDeadLetterNotifications returns up to limit dead letters, oldest
+// first. A non-empty destination restricts them to that destination.
+func (s *Store) ListDeadLetterNotifications(destination string, limit int) ([]NotificationEvent, error) {
+	var events []NotificationEvent
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(notifyDeadLetterBucket).Cursor()
+		for k, v := c.First(); k != nil && len(events) < limit; k, v = c.Next() {
+			var event NotificationEvent
+			if err := json.Unmarshal(v, &event); err != nil {
+				continue
+			}
+			if destination != "" && event.Destination != destination {
+				continue
+			}
+			events = append(events, event)
+		}
+		return nil
+	})
+	return events, err
+}
+
+// ReplayDeadLetterNotifications moves dead letters back into the outbox for
+// immediate delivery with a fresh retry budget. It selects the dead letters
+// with the given IDs, or all of them if ids is empty, restricted to
+// destination if that is non-empty, and returns how many were moved.
+func (s *Store) ReplayDeadLetterNotifications(ids []uint64, destination string) (int, error) {
+	return s.removeDeadLetterNotifications(ids, destination, func(tx *bolt.Tx, event NotificationEvent) error {
+		event.ID = 0
+		event.Attempts = 0
+		event.NextAttemptAt = 0
+		event.DeadAt = 0
+		return putOutboxEvent(tx, event)
+	})
+}
+
+// PurgeDeadLetterNotifications deletes dead letters, selected as for
+// ReplayDeadLetterNotifications, and returns how many were deleted.
+func (s *Store) PurgeDeadLetterNotifications(ids []uint64, destination string) (int, error) {
+	return s.removeDeadLetterNotifications(ids, destination, nil)
+}
+
+func (s *Store) removeDeadLetterNotifications(ids []uint64, destination string, fn func(*bolt.Tx, NotificationEvent) error) (int, error) {
+	wanted := make(map[uint64]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+	count := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(notifyDeadLetterBucket)
+		var selected []NotificationEvent
+		err := b.ForEach(func(k, v []byte) error {
+			var event NotificationEvent
+			if err := json.Unmarshal(v, &event); err != nil {
+				return nil
+			}
+			if (len(wanted) == 0 || wanted[event.ID]) && (destination == "" || event.Destination == destination) {
+				selected = append(selected, event)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, event := range selected {
+			if err := b.Delete(replicationKey(event.ID)); err != nil {
+				return err
+			}
+			if err := addNotifyCounts(tx, event, 0, -1); err != nil {
+				return err
+			}
+			if fn != nil {
+				if err := fn(tx, event); err != nil {
+					return err
+				}
+			}
+		}
+		count = len(selected)
+		return nil
+	})
+	return count, err
+}
+
+// NotificationQueueStats returns the outbox and dead letter counts of every
+// destination with events in either. It reads the counts and the oldest
+// pending event of each destination, not the events themselves.
+func (s *Store) NotificationQueueStats() ([]NotificationQueueStats, error) {
+	var stats []NotificationQueueStats
+	err := s.db.View(func(tx *bolt.Tx) error {
+		pending := tx.Bucket(notifyPendingBucket).Cursor()
+		return tx.Bucket(notifyCountsBucket).ForEach(func(k, v []byte) error {
+			kind, destination, ok := strings.Cut(strings.TrimSuffix(string(k), "\x00"), "\x00")
+			if !ok || len(v) != 16 {
+				return nil
+			}
+			st := NotificationQueueStats{
+				Kind:         kind,
+				Destination:  destination,
+				Pending:      int(binary.BigEndian.Uint64(v)),
+				DeadLettered: int(binary.BigEndian.Uint64(v[8:])),
+			}
+			if pk, _ := pending.Seek(k); st.Pending > 0 && bytes.HasPrefix(pk, k) && len(pk) == len(k)+16 {
+				st.OldestPending = int64(binary.BigEndian.Uint64(pk[len(k):]))
+			}
+			stats = append(stats, st)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Kind != stats[j].Kind {
+			return stats[i].Kind < stats[j].Kind
+		}
+		return stats[i].Destination < stats[j].Destination
+	})
+	return stats, nil
+}
+
 // Replication queue operations
 
 func replicationKey(id uint64) []byte {
@@ -1876,6 +3180,10 @@ func (s *Store) DeleteExpiredAccessKeys() (int, error) {
 // Lambda trigger operations
 
 func (s *Store) PutLambdaConfig(bucket string, cfg BucketLambdaConfig) error {
+	cfg, err := s.sealLambdaConfig(cfg)
+	if err != nil {
+		return err
+	}
 	return s.db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket(lambdaTriggersBucket)
 		data, err := json.Marshal(cfg)
@@ -1895,7 +3203,10 @@ func (s *Store) GetLambdaConfig(bucket string) (*BucketLambdaConfig, error) {
 			return fmt.Errorf("no lambda config for bucket: %s", bucket)
 		}
 		cfg = &BucketLambdaConfig{}
-		return json.Unmarshal(data, cfg)
+		if err := json.Unmarshal(data, cfg); err != nil {
+			return err
+		}
+		return s.openLambdaConfig(cfg)
 	})
 	return cfg, err
 }
@@ -1916,6 +3227,9 @@ func (s *Store) ListLambdaConfigs() (map[string]BucketLambdaConfig, error) {
 			if err := json.Unmarshal(v, &cfg); err != nil {
 				return nil
 			}
+			if err := s.openLambdaConfig(&cfg); err != nil {
+				return err
+			}
 			configs[string(k)] = cfg
 			return nil
 		})
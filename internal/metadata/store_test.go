@@ -1,9 +1,14 @@
 package metadata
 
 import (
+	"bytes"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
 )
 
 func newTestStore(t *testing.T) *Store {
@@ -81,6 +86,22 @@ func TestStore_ObjectMeta(t *testing.T) {
 	}
 }
 
+func TestStore_GetObjectMetas(t *testing.T) {
+	s := newTestStore(t)
+	s.CreateBucket("bucket")
+	s.PutObjectMeta(ObjectMeta{Bucket: "bucket", Key: "a.txt", StorageClass: "STANDARD_IA"})
+	s.PutObjectMeta(ObjectMeta{Bucket: "bucket", Key: "b.txt"})
+	s.PutObjectMeta(ObjectMeta{Bucket: "other", Key: "c.txt"})
+
+	metas, err := s.GetObjectMetas("bucket", []string{"a.txt", "b.txt", "c.txt"})
+	if err != nil {
+		t.Fatalf("GetObjectMetas: %v", err)
+	}
+	if len(metas) != 2 || metas["a.txt"].StorageClass != "STANDARD_IA" || metas["b.txt"] == nil {
+		t.Errorf("got %+v", metas)
+	}
+}
+
 func TestStore_BucketTags(t *testing.T) {
 	s := newTestStore(t)
 	s.CreateBucket("tagged")
@@ -203,6 +224,39 @@ func TestStore_MultipartUploads(t *testing.T) {
 	}
 }
 
+func TestStore_MultipartUsage(t *testing.T) {
+	s := newTestStore(t)
+
+	s.CreateMultipartUpload(MultipartUpload{UploadID: "u1", Bucket: "mybucket", Key: "a.bin"})
+	s.CreateMultipartUpload(MultipartUpload{UploadID: "u2", Bucket: "mybucket", Key: "b.bin"})
+	s.PutPart("u1", PartInfo{PartNumber: 1, Size: 100})
+	s.PutPart("u1", PartInfo{PartNumber: 2, Size: 50})
+	s.PutPart("u2", PartInfo{PartNumber: 1, Size: 30})
+	if got := s.MultipartUsage("mybucket"); got != 180 {
+		t.Fatalf("usage = %d, want 180", got)
+	}
+
+	// Re-uploading a part replaces its size
+	s.PutPart("u1", PartInfo{PartNumber: 2, Size: 10})
+	if got := s.MultipartUsage("mybucket"); got != 140 {
+		t.Fatalf("usage after replace = %d, want 140", got)
+	}
+
+	if err := s.DeleteMultipartUpload("u1"); err != nil {
+		t.Fatalf("DeleteMultipartUpload: %v", err)
+	}
+	if got := s.MultipartUsage("mybucket"); got != 30 {
+		t.Fatalf("usage after delete = %d, want 30", got)
+	}
+	if parts, _ := s.ListParts("u1"); len(parts) != 0 {
+		t.Errorf("expected parts of deleted upload to be gone, got %d", len(parts))
+	}
+	s.DeleteMultipartUpload("u2")
+	if got := s.MultipartUsage("mybucket"); got != 0 {
+		t.Errorf("usage after all deletes = %d, want 0", got)
+	}
+}
+
 func TestStore_AuditEntries(t *testing.T) {
 	s := newTestStore(t)
 
@@ -226,9 +280,176 @@ func TestStore_AuditEntries(t *testing.T) {
 	}
 }
 
+func TestStore_NotificationOutbox(t *testing.T) {
+	s := newTestStore(t)
+
+	events := []NotificationEvent{
+		{Kind: "webhook", Destination: "http://a", Payload: []byte(`{}`)},
+		{Kind: "target", Destination: "arn:vaults3:sqs::q", Payload: []byte(`{}`)},
+		{Kind: "target", Destination: "arn:vaults3:sqs::q", Payload: []byte(`{}`), NextAttemptAt: 1 << 62},
+	}
+	if err := s.EnqueueNotifications(events); err != nil {
+		t.Fatalf("EnqueueNotifications: %v", err)
+	}
+	due, err := s.DequeueNotifications(10, 1000, func(id uint64) bool { return id == 1 })
+	if err != nil || len(due) != 1 || due[0].ID != 2 {
+		t.Fatalf("DequeueNotifications = %+v, %v; want only event 2", due, err)
+	}
+
+	s.NackNotification(1, 1, 1<<62, "timeout")
+	s.DeadLetterNotification(2, 3, "refused")
+	stats, _ := s.NotificationQueueStats()
+	if len(stats) != 2 || stats[0].Kind != "target" || stats[0].Pending != 1 || stats[0].DeadLettered != 1 ||
+		stats[1].Kind != "webhook" || stats[1].Pending != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+
+	dead, _ := s.ListDeadLetterNotifications("arn:vaults3:sqs::q", 10)
+	if len(dead) != 1 || dead[0].Attempts != 3 || dead[0].LastError != "refused" || dead[0].DeadAt == 0 {
+		t.Fatalf("dead letters = %+v", dead)
+	}
+	if n, _ := s.PurgeDeadLetterNotifications(nil, "http://other"); n != 0 {
+		t.Errorf("purged %d dead letters of another destination", n)
+	}
+	if n, _ := s.PurgeDeadLetterNotifications(nil, ""); n != 1 {
+		t.Errorf("purged %d dead letters, want 1", n)
+	}
+	if dead, _ := s.ListDeadLetterNotifications("", 10); len(dead) != 0 {
+		t.Errorf("dead letters after purge = %d", len(dead))
+	}
+}
+
+func TestStore_NotificationOutboxIndexes(t *testing.T) {
+	s := newTestStore(t)
+
+	// The outbox events of a write commit with the metadata
+	err := s.PutObjectMetaWithNotifications(ObjectMeta{Bucket: "b", Key: "k"}, []NotificationEvent{
+		{Kind: "webhook", Destination: "http://a", CreatedAt: 200},
+		{Kind: "webhook", Destination: "http://a", CreatedAt: 100, NextAttemptAt: 50},
+	})
+	if err != nil {
+		t.Fatalf("PutObjectMetaWithNotifications: %v", err)
+	}
+	s.DeleteObjectMetaWithNotifications("b", "k", []NotificationEvent{{Kind: "target", Destination: "arn:q", NextAttemptAt: 500}})
+	if due, _ := s.DequeueNotifications(10, 100, nil); len(due) != 2 || due[0].ID != 1 || due[1].ID != 2 {
+		t.Fatalf("due at 100 = %+v", due)
+	}
+	s.NackNotification(1, 1, 1000, "timeout")
+	if due, _ := s.DequeueNotifications(10, 600, nil); len(due) != 2 || due[0].ID != 2 || due[1].ID != 3 {
+		t.Fatalf("due at 600 = %+v", due)
+	}
+	s.AckNotification(3)
+	s.DeadLetterNotification(1, 2, "refused")
+	stats, _ := s.NotificationQueueStats()
+	if len(stats) != 1 || stats[0].Pending != 1 || stats[0].OldestPending != 100 || stats[0].DeadLettered != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	if n, _ := s.ReplayDeadLetterNotifications(nil, ""); n != 1 {
+		t.Fatalf("replayed %d", n)
+	}
+	stats, _ = s.NotificationQueueStats()
+	if len(stats) != 1 || stats[0].Pending != 2 || stats[0].DeadLettered != 0 {
+		t.Fatalf("stats after replay = %+v", stats)
+	}
+}
+
+func TestStore_WebhookSecretsEncrypted(t *testing.T) {
+	s := newTestStore(t)
+	cfg := BucketNotificationConfig{Webhooks: []NotificationEndpointConfig{{
+		ID:       "hook",
+		Endpoint: "http://example.com/hook",
+		Events:   []string{"s3:ObjectCreated:*"},
+		Auth:     &WebhookAuth{Secret: "s3cr3t-signing-key", Headers: map[string]string{"X-Api-Key": "k3y-for-the-api"}},
+	}}}
+
+	if err := s.PutNotificationConfig("b", cfg); err == nil {
+		t.Fatal("expected error storing secrets without a secrets key")
+	}
+	if err := s.SetSecretsKey(bytes.Repeat([]byte{7}, 32)); err != nil {
+		t.Fatalf("SetSecretsKey: %v", err)
+	}
+	if err := s.PutNotificationConfig("b", cfg); err != nil {
+		t.Fatalf("PutNotificationConfig: %v", err)
+	}
+
+	var raw []byte
+	s.db.View(func(tx *bolt.Tx) error {
+		raw = append(raw, tx.Bucket(notificationBucket).Get([]byte("b"))...)
+		return nil
+	})
+	if bytes.Contains(raw, []byte("s3cr3t-signing-key")) || bytes.Contains(raw, []byte("k3y-for-the-api")) {
+		t.Fatalf("secret stored in plaintext: %s", raw)
+	}
+
+	got, err := s.GetNotificationConfig("b")
+	if err != nil {
+		t.Fatalf("GetNotificationConfig: %v", err)
+	}
+	if a := got.Webhooks[0].Auth; a == nil || a.Secret != "s3cr3t-signing-key" || a.Headers["X-Api-Key"] != "k3y-for-the-api" {
+		t.Fatalf("auth = %+v", got.Webhooks[0].Auth)
+	}
+
+	red := got.Webhooks[0].Auth.Redacted()
+	if red.Secret != RedactedSecret || red.Headers["X-Api-Key"] != RedactedSecret || got.Webhooks[0].Auth.Secret == RedactedSecret {
+		t.Fatalf("Redacted = %+v", red)
+	}
+	red.KeepSecrets(got.Webhooks[0].Auth)
+	if red.Secret != "s3cr3t-signing-key" || red.Headers["X-Api-Key"] != "k3y-for-the-api" {
+		t.Fatalf("KeepSecrets = %+v", red)
+	}
+
+	// Values in the sealed format are never stored as-is
+	for _, auth := range []*WebhookAuth{
+		{Secret: "enc:v1:AAAA"},
+		{Headers: map[string]string{"Authorization": "enc:v1:AAAA"}},
+	} {
+		cfg.Webhooks[0].Auth = auth
+		if err := s.PutNotificationConfig("b", cfg); err == nil {
+			t.Errorf("expected error storing %+v", auth)
+		}
+	}
+}
+
 func TestNewStore_InvalidPath(t *testing.T) {
 	_, err := NewStore(filepath.Join(os.DevNull, "nonexistent", "test.db"))
 	if err == nil {
 		t.Error("expected error for invalid path")
 	}
 }
+
+func TestStore_EventLog(t *testing.T) {
+	s := newTestStore(t)
+	base := time.Now().Add(-time.Hour).UnixNano()
+	for i, key := range []string{"logs/a", "logs/b", "img/c", "logs/d"} {
+		if err := s.AppendEventLog(EventLogEntry{Time: base + int64(i), Name: "s3:ObjectCreated:Put", Bucket: "b1", Key: key}); err != nil {
+			t.Fatalf("AppendEventLog: %v", err)
+		}
+	}
+	s.AppendEventLog(EventLogEntry{Time: base, Name: "s3:ObjectCreated:Put", Bucket: "b2", Key: "logs/x"})
+
+	var keys []string
+	var last EventLogEntry
+	s.ScanEventLog("b1", "logs/", base, base+3, 0, func(e EventLogEntry) bool {
+		keys = append(keys, e.Key)
+		last = e
+		return true
+	})
+	if strings.Join(keys, ",") != "logs/a,logs/b" {
+		t.Fatalf("scan = %v, want logs/a,logs/b", keys)
+	}
+
+	// Resume after the last entry seen
+	keys = nil
+	s.ScanEventLog("b1", "logs/", last.Time, 0, last.Seq, func(e EventLogEntry) bool {
+		keys = append(keys, e.Key)
+		return true
+	})
+	if strings.Join(keys, ",") != "logs/d" {
+		t.Fatalf("resumed scan = %v, want logs/d", keys)
+	}
+
+	n, err := s.PruneEventLog(time.Unix(0, base+2))
+	if err != nil || n != 3 {
+		t.Fatalf("PruneEventLog = %d, %v; want 3", n, err)
+	}
+}
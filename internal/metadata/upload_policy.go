@@ -0,0 +1,79 @@
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/eniz1806/VaultS3/internal/filetype"
+)
+
+// UploadPolicy restricts the real types of content a bucket accepts. Types
+// are detected from the first bytes of each upload, not taken from its
+// Content-Type. Entries are classes ("executable", "archive", ...), MIME
+// types ("application/pdf") or families ("image/*").
+type UploadPolicy struct {
+	Allow []string `json:"allow,omitempty"` // if set, only these types are accepted
+	Deny  []string `json:"deny,omitempty"`  // rejected even if allowed
+	// OverrideContentType stores the detected type as the object's
+	// Content-Type when the declared one does not match the content.
+	OverrideContentType bool `json:"override_content_type,omitempty"`
+}
+
+// Rejection returns why the policy rejects content of type t, or "" when it
+// accepts it.
+func (p *UploadPolicy) Rejection(t filetype.Type) string {
+	if t.MatchesAny(p.Deny) {
+		return fmt.Sprintf("Content of type %s (%s) is denied in this bucket", t.MIME, t.Class)
+	}
+	if len(p.Allow) > 0 && !t.MatchesAny(p.Allow) {
+		return fmt.Sprintf("Content of type %s (%s) is not allowed in this bucket", t.MIME, t.Class)
+	}
+	return ""
+}
+
+func (s *Store) PutUploadPolicy(bucket string, policy UploadPolicy) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		data, err := json.Marshal(policy)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(uploadPoliciesBucket).Put([]byte(bucket), data)
+	})
+}
+
+func (s *Store) GetUploadPolicy(bucket string) (*UploadPolicy, error) {
+	var policy *UploadPolicy
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(uploadPoliciesBucket).Get([]byte(bucket))
+		if data == nil {
+			return fmt.Errorf("upload policy not found: %s", bucket)
+		}
+		policy = &UploadPolicy{}
+		return json.Unmarshal(data, policy)
+	})
+	return policy, err
+}
+
+func (s *Store) DeleteUploadPolicy(bucket string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(uploadPoliciesBucket).Delete([]byte(bucket))
+	})
+}
+
+// ListUploadPolicies returns the upload policies of all buckets that have one.
+func (s *Store) ListUploadPolicies() (map[string]UploadPolicy, error) {
+	result := make(map[string]UploadPolicy)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(uploadPoliciesBucket).ForEach(func(k, v []byte) error {
+			var policy UploadPolicy
+			if err := json.Unmarshal(v, &policy); err != nil {
+				return nil
+			}
+			result[string(k)] = policy
+			return nil
+		})
+	})
+	return result, err
+}
@@ -10,7 +10,9 @@ import (
 	"time"
 
 	"github.com/eniz1806/VaultS3/internal/metadata"
+	"github.com/eniz1806/VaultS3/internal/notify"
 	"github.com/eniz1806/VaultS3/internal/storage"
+	"github.com/eniz1806/VaultS3/internal/tiering"
 )
 
 // bucketMetrics holds per-bucket request counters.
@@ -23,8 +25,10 @@ type bucketMetrics struct {
 
 // Collector tracks request metrics and exposes Prometheus-compatible /metrics.
 type Collector struct {
-	store  *metadata.Store
-	engine storage.Engine
+	store   *metadata.Store
+	engine  storage.Engine
+	tiering *tiering.Manager
+	notify  *notify.Dispatcher
 
 	// Request counters by method
 	requestsTotal [methodCount]atomic.Int64
@@ -105,6 +109,16 @@ func NewCollector(store *metadata.Store, engine storage.Engine) *Collector {
 
 const maxBucketMetrics = 100
 
+// SetTieringManager enables per-bucket tier distribution metrics.
+func (c *Collector) SetTieringManager(mgr *tiering.Manager) {
+	c.tiering = mgr
+}
+
+// SetNotifyDispatcher enables notification outbox depth, lag and delivery metrics.
+func (c *Collector) SetNotifyDispatcher(d *notify.Dispatcher) {
+	c.notify = d
+}
+
 // StartTime returns when the collector was created (server start time).
 func (c *Collector) StartTime() time.Time {
 	return c.startTime
@@ -275,6 +289,49 @@ func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintf(w, "vaults3_objects_total %d\n", totalObjects)
 	}
 
+	// Per-bucket tier distribution and Intelligent-Tiering moves
+	if c.tiering != nil {
+		stats := c.tiering.BucketStats()
+		names := make([]string, 0, len(stats))
+		for name := range stats {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			bs := stats[name]
+			for _, tier := range sortedKeys(bs.Tiers) {
+				fmt.Fprintf(w, "vaults3_bucket_tier_objects{bucket=%q,tier=%q} %d\n", name, tier, bs.Tiers[tier].Count)
+				fmt.Fprintf(w, "vaults3_bucket_tier_bytes{bucket=%q,tier=%q} %d\n", name, tier, bs.Tiers[tier].Size)
+			}
+			for _, tier := range sortedKeys(bs.AccessTiers) {
+				fmt.Fprintf(w, "vaults3_bucket_access_tier_objects{bucket=%q,access_tier=%q} %d\n", name, tier, bs.AccessTiers[tier].Count)
+				fmt.Fprintf(w, "vaults3_bucket_access_tier_bytes{bucket=%q,access_tier=%q} %d\n", name, tier, bs.AccessTiers[tier].Size)
+			}
+			for _, tier := range sortedKeys(bs.Transitions) {
+				fmt.Fprintf(w, "vaults3_bucket_access_tier_transitions_total{bucket=%q,access_tier=%q} %d\n", name, tier, bs.Transitions[tier])
+			}
+		}
+	}
+
+	// Notification outbox depth, lag and dead letters per destination
+	if c.notify != nil {
+		delivered, failed := c.notify.DeliveryCounts()
+		fmt.Fprintf(w, "vaults3_notify_delivered_total %d\n", delivered)
+		fmt.Fprintf(w, "vaults3_notify_failed_attempts_total %d\n", failed)
+		if stats, err := c.notify.QueueStats(); err == nil {
+			now := time.Now().UnixNano()
+			for _, qs := range stats {
+				var lag float64
+				if qs.Pending > 0 && qs.OldestPending > 0 && now > qs.OldestPending {
+					lag = time.Duration(now - qs.OldestPending).Seconds()
+				}
+				fmt.Fprintf(w, "vaults3_notify_outbox_depth{kind=%q,destination=%q} %d\n", qs.Kind, qs.Destination, qs.Pending)
+				fmt.Fprintf(w, "vaults3_notify_outbox_lag_seconds{kind=%q,destination=%q} %.3f\n", qs.Kind, qs.Destination, lag)
+				fmt.Fprintf(w, "vaults3_notify_dead_letters{kind=%q,destination=%q} %d\n", qs.Kind, qs.Destination, qs.DeadLettered)
+			}
+		}
+	}
+
 	// Per-bucket request metrics
 	c.bucketMu.RLock()
 	bucketNames := make([]string, 0, len(c.bucketMetrics))
@@ -323,3 +380,13 @@ func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "vaults3_go_memory_sys_bytes %d\n", mem.Sys)
 	fmt.Fprintf(w, "vaults3_go_gc_total %d\n", mem.NumGC)
 }
+
+// sortedKeys returns the keys of m in order, for stable metric output.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
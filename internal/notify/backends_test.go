@@ -0,0 +1,329 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type brokerMessage struct {
+	topic   string
+	payload string
+	qos     int
+	retain  bool
+}
+
+// mqttStandIn is an in-process MQTT broker that accepts CONNECT and PUBLISH.
+type mqttStandIn struct {
+	ln       net.Listener
+	password string
+	// closeAfter closes each connection after that many publishes (0 = never).
+	closeAfter int
+
+	mu       sync.Mutex
+	messages []brokerMessage
+	connects int
+}
+
+func newMQTTStandIn(t *testing.T) *mqttStandIn {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &mqttStandIn{ln: ln}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+	return b
+}
+
+func (b *mqttStandIn) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	typ, body, err := readMQTTPacket(r)
+	if err != nil || typ != 0x10 {
+		return
+	}
+	flags := body[7]
+	rest := body[10:]
+	readStr := func() string {
+		n := int(binary.BigEndian.Uint16(rest))
+		s := string(rest[2 : 2+n])
+		rest = rest[2+n:]
+		return s
+	}
+	readStr() // client ID
+	var password string
+	if flags&0x80 != 0 {
+		readStr()
+	}
+	if flags&0x40 != 0 {
+		password = readStr()
+	}
+	if password != b.password {
+		conn.Write([]byte{0x20, 0x02, 0x00, 0x05})
+		return
+	}
+	conn.Write([]byte{0x20, 0x02, 0x00, 0x00})
+	b.mu.Lock()
+	b.connects++
+	b.mu.Unlock()
+
+	for published := 0; ; {
+		hdr, err := r.Peek(1)
+		if err != nil {
+			return
+		}
+		header := hdr[0]
+		typ, body, err := readMQTTPacket(r)
+		if err != nil || typ != 0x30 {
+			return
+		}
+		n := int(binary.BigEndian.Uint16(body))
+		msg := brokerMessage{topic: string(body[2 : 2+n]), qos: int(header>>1) & 0x03, retain: header&0x01 != 0}
+		body = body[2+n:]
+		if msg.qos > 0 {
+			conn.Write(append([]byte{0x40, 0x02}, body[:2]...))
+			body = body[2:]
+		}
+		msg.payload = string(body)
+		b.mu.Lock()
+		b.messages = append(b.messages, msg)
+		b.mu.Unlock()
+		published++
+		if b.closeAfter > 0 && published == b.closeAfter {
+			return
+		}
+	}
+}
+
+func (b *mqttStandIn) received() []brokerMessage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]brokerMessage(nil), b.messages...)
+}
+
+func testEventPayload(t *testing.T, bucket, key string) []byte {
+	t.Helper()
+	data, err := json.Marshal(NewS3Event(Event{Name: EventObjectCreatedPut, Bucket: bucket, Key: key, Size: 3}, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestMQTTBackend_PublishTemplatedTopic(t *testing.T) {
+	broker := newMQTTStandIn(t)
+	broker.password = "pw"
+	m, err := NewMQTTBackend(MQTTOptions{
+		Broker:   "tcp://" + broker.ln.Addr().String(),
+		Topic:    "vaults3/{bucket}/{key}",
+		QoS:      1,
+		Retain:   true,
+		Username: "user",
+		Password: "pw",
+	})
+	if err != nil {
+		t.Fatalf("NewMQTTBackend: %v", err)
+	}
+	defer m.Close()
+
+	payload := testEventPayload(t, "sensors", "dev 1/temp#1.json")
+	if err := m.Publish(context.Background(), payload); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	msgs := broker.received()
+	if len(msgs) != 1 {
+		t.Fatalf("received %d messages, want 1", len(msgs))
+	}
+	got := msgs[0]
+	if got.topic != "vaults3/sensors/dev 1/temp_1.json" || got.qos != 1 || !got.retain || got.payload != string(payload) {
+		t.Fatalf("message = %+v", got)
+	}
+}
+
+func TestMQTTBackend_BadCredentials(t *testing.T) {
+	broker := newMQTTStandIn(t)
+	broker.password = "pw"
+	m, _ := NewMQTTBackend(MQTTOptions{Broker: "tcp://" + broker.ln.Addr().String(), Topic: "t", Password: "wrong"})
+	defer m.Close()
+	if err := m.Publish(context.Background(), []byte("{}")); err == nil || !strings.Contains(err.Error(), "refused") {
+		t.Fatalf("Publish error = %v, want connection refused", err)
+	}
+}
+
+func TestMQTTBackend_Reconnects(t *testing.T) {
+	broker := newMQTTStandIn(t)
+	broker.closeAfter = 1
+	m, _ := NewMQTTBackend(MQTTOptions{Broker: "tcp://" + broker.ln.Addr().String(), Topic: "events", QoS: 1})
+	defer m.Close()
+
+	for i := 0; i < 3; i++ {
+		if err := m.Publish(context.Background(), []byte("{}")); err != nil {
+			t.Fatalf("Publish %d: %v", i, err)
+		}
+	}
+	if n := len(broker.received()); n != 3 {
+		t.Fatalf("received %d messages, want 3", n)
+	}
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+	if broker.connects != 3 {
+		t.Fatalf("connects = %d, want 3", broker.connects)
+	}
+}
+
+func TestNewMQTTBackend_Validation(t *testing.T) {
+	for _, opts := range []MQTTOptions{
+		{Broker: "tcp://localhost:1883", Topic: "t", QoS: 2},
+		{Broker: "tcp://localhost:1883"},
+		{Broker: "ws://localhost:1883", Topic: "t"},
+		{Broker: "localhost", Topic: "t"},
+	} {
+		if _, err := NewMQTTBackend(opts); err == nil {
+			t.Errorf("NewMQTTBackend(%+v) succeeded", opts)
+		}
+	}
+}
+
+// nsqStandIn is an in-process nsqd that accepts PUB commands.
+type nsqStandIn struct {
+	ln        net.Listener
+	heartbeat bool // send a heartbeat before each response
+	// closeAfter closes each connection after that many publishes (0 = never).
+	closeAfter int
+
+	mu       sync.Mutex
+	messages []brokerMessage
+}
+
+func newNSQStandIn(t *testing.T) *nsqStandIn {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &nsqStandIn{ln: ln}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+	return b
+}
+
+func nsqFrame(typ uint32, data string) []byte {
+	f := binary.BigEndian.AppendUint32(nil, uint32(len(data)+4))
+	f = binary.BigEndian.AppendUint32(f, typ)
+	return append(f, data...)
+}
+
+func (b *nsqStandIn) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != "  V2" {
+		return
+	}
+	for published := 0; ; {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		topic, ok := strings.CutPrefix(strings.TrimSpace(line), "PUB ")
+		if !ok {
+			return
+		}
+		var size [4]byte
+		if _, err := io.ReadFull(r, size[:]); err != nil {
+			return
+		}
+		body := make([]byte, binary.BigEndian.Uint32(size[:]))
+		if _, err := io.ReadFull(r, body); err != nil {
+			return
+		}
+		if strings.ContainsAny(topic, "!/ ") {
+			conn.Write(nsqFrame(nsqFrameError, "E_BAD_TOPIC PUB topic name is not valid"))
+			continue
+		}
+		if b.heartbeat {
+			conn.Write(nsqFrame(nsqFrameResponse, "_heartbeat_"))
+			if nop, err := r.ReadString('\n'); err != nil || nop != "NOP\n" {
+				return
+			}
+		}
+		b.mu.Lock()
+		b.messages = append(b.messages, brokerMessage{topic: topic, payload: string(body)})
+		b.mu.Unlock()
+		conn.Write(nsqFrame(nsqFrameResponse, "OK"))
+		published++
+		if b.closeAfter > 0 && published == b.closeAfter {
+			return
+		}
+	}
+}
+
+func TestNSQBackend_Publish(t *testing.T) {
+	nsqd := newNSQStandIn(t)
+	nsqd.heartbeat = true
+	n := NewNSQBackend(nsqd.ln.Addr().String(), "s3-events")
+	defer n.Close()
+
+	payload := testEventPayload(t, "b", "k")
+	for i := 0; i < 2; i++ {
+		if err := n.Publish(context.Background(), payload); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+	nsqd.mu.Lock()
+	defer nsqd.mu.Unlock()
+	if len(nsqd.messages) != 2 || nsqd.messages[0].topic != "s3-events" || nsqd.messages[0].payload != string(payload) {
+		t.Fatalf("messages = %+v", nsqd.messages)
+	}
+}
+
+func TestNSQBackend_ErrorFrame(t *testing.T) {
+	nsqd := newNSQStandIn(t)
+	n := NewNSQBackend(nsqd.ln.Addr().String(), "bad!")
+	defer n.Close()
+	if err := n.Publish(context.Background(), []byte("{}")); err == nil || !strings.Contains(err.Error(), "E_BAD_TOPIC") {
+		t.Fatalf("Publish error = %v, want E_BAD_TOPIC", err)
+	}
+}
+
+func TestNSQBackend_Reconnects(t *testing.T) {
+	nsqd := newNSQStandIn(t)
+	nsqd.closeAfter = 1
+	n := NewNSQBackend(nsqd.ln.Addr().String(), "events")
+	defer n.Close()
+
+	for _, p := range []string{"1", "2", "3"} {
+		if err := n.Publish(context.Background(), []byte(p)); err != nil {
+			t.Fatalf("Publish %s: %v", p, err)
+		}
+	}
+	nsqd.mu.Lock()
+	defer nsqd.mu.Unlock()
+	if len(nsqd.messages) != 3 || nsqd.messages[2].payload != "3" {
+		t.Fatalf("messages = %+v", nsqd.messages)
+	}
+}
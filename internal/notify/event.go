@@ -0,0 +1,201 @@
+package notify
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// Event names. Clients may subscribe to a whole family with a wildcard such
+// as "s3:ObjectCreated:*".
+const (
+	EventObjectCreatedPut                     = "s3:ObjectCreated:Put"
+	EventObjectCreatedPost                    = "s3:ObjectCreated:Post"
+	EventObjectCreatedCopy                    = "s3:ObjectCreated:Copy"
+	EventObjectCreatedCompleteMultipartUpload = "s3:ObjectCreated:CompleteMultipartUpload"
+	EventObjectRemovedDelete                  = "s3:ObjectRemoved:Delete"
+	EventObjectRemovedDeleteMarkerCreated     = "s3:ObjectRemoved:DeleteMarkerCreated"
+	EventObjectRestorePost                    = "s3:ObjectRestore:Post"
+	EventObjectRestoreCompleted               = "s3:ObjectRestore:Completed"
+	EventObjectRestoreDelete                  = "s3:ObjectRestore:Delete"
+	EventObjectTaggingPut                     = "s3:ObjectTagging:Put"
+	EventObjectTaggingDelete                  = "s3:ObjectTagging:Delete"
+	EventObjectAclPut                         = "s3:ObjectAcl:Put"
+	EventObjectRetentionPut                   = "s3:ObjectRetention:Put"
+	EventObjectLegalHoldPut                   = "s3:ObjectLegalHold:Put"
+	EventObjectRejectedContentType            = "s3:ObjectRejected:ContentType"
+	EventLifecycleExpirationDelete            = "s3:LifecycleExpiration:Delete"
+	EventLifecycleTransition                  = "s3:LifecycleTransition"
+	EventReplicationOperationCompleted        = "s3:Replication:OperationCompletedReplication"
+	EventReplicationOperationFailed           = "s3:Replication:OperationFailedReplication"
+)
+
+const (
+	// DefaultRegion is reported as awsRegion when none is configured.
+	DefaultRegion = "us-east-1"
+	// SystemPrincipal is the principal of events that VaultS3 raises on its
+	// own, such as lifecycle expirations and replication results.
+	SystemPrincipal = "vaults3"
+)
+
+// Event describes an S3 event before it is rendered as an AWS event record.
+// Every delivery path, from webhooks and backends to lambda triggers, builds
+// its payload from an Event with NewS3Event.
+type Event struct {
+	Name      string    `json:"name"`
+	Bucket    string    `json:"bucket"`
+	Key       string    `json:"key"`
+	Size      int64     `json:"size,omitempty"`
+	ETag      string    `json:"etag,omitempty"`
+	VersionID string    `json:"version_id,omitempty"`
+	Time      time.Time `json:"time"` // zero means now
+
+	Region      string `json:"region,omitempty"`
+	PrincipalID string `json:"principal_id,omitempty"` // access key of the requester, or SystemPrincipal
+	SourceIP    string `json:"source_ip,omitempty"`
+	RequestID   string `json:"request_id,omitempty"`
+
+	// Restore details, reported as glacierEventData for restore events
+	RestoreExpiry       time.Time `json:"restore_expiry,omitempty"`
+	RestoreStorageClass string    `json:"restore_storage_class,omitempty"`
+
+	// Rejection details, reported as rejectionEventData for rejection events
+	DeclaredContentType string `json:"declared_content_type,omitempty"`
+	DetectedContentType string `json:"detected_content_type,omitempty"`
+	RejectionReason     string `json:"rejection_reason,omitempty"`
+
+	// Queued is set when the outbox events were persisted with the
+	// metadata change, so dispatching only has to wake the workers
+	Queued bool `json:"-"`
+}
+
+// S3Event matches the AWS S3 event notification JSON format.
+type S3Event struct {
+	Records []S3EventRecord `json:"Records"`
+}
+
+type S3EventRecord struct {
+	EventVersion      string            `json:"eventVersion"`
+	EventSource       string            `json:"eventSource"`
+	AWSRegion         string            `json:"awsRegion"`
+	EventTime         string            `json:"eventTime"`
+	EventName         string            `json:"eventName"`
+	UserIdentity      UserIdentity      `json:"userIdentity"`
+	RequestParameters RequestParameters `json:"requestParameters"`
+	ResponseElements  map[string]string `json:"responseElements"`
+	S3                S3Detail          `json:"s3"`
+	GlacierEventData  *GlacierEventData `json:"glacierEventData,omitempty"`
+	// RejectionEventData is a VaultS3 extension for uploads rejected by a
+	// bucket's upload policy.
+	RejectionEventData *RejectionEventData `json:"rejectionEventData,omitempty"`
+}
+
+type UserIdentity struct {
+	PrincipalID string `json:"principalId"`
+}
+
+type RequestParameters struct {
+	SourceIPAddress string `json:"sourceIPAddress"`
+}
+
+type S3Detail struct {
+	SchemaVersion   string   `json:"s3SchemaVersion"`
+	ConfigurationID string   `json:"configurationId"`
+	Bucket          S3Bucket `json:"bucket"`
+	Object          S3Object `json:"object"`
+}
+
+type S3Bucket struct {
+	Name          string       `json:"name"`
+	OwnerIdentity UserIdentity `json:"ownerIdentity"`
+	ARN           string       `json:"arn"`
+}
+
+type S3Object struct {
+	Key       string `json:"key"`
+	Size      int64  `json:"size"`
+	ETag      string `json:"eTag,omitempty"`
+	VersionID string `json:"versionId,omitempty"`
+	Sequencer string `json:"sequencer"`
+}
+
+type GlacierEventData struct {
+	RestoreEventData RestoreEventData `json:"restoreEventData"`
+}
+
+type RestoreEventData struct {
+	LifecycleRestorationExpiryTime string `json:"lifecycleRestorationExpiryTime,omitempty"`
+	LifecycleRestoreStorageClass   string `json:"lifecycleRestoreStorageClass,omitempty"`
+}
+
+type RejectionEventData struct {
+	DeclaredContentType string `json:"declaredContentType,omitempty"`
+	DetectedContentType string `json:"detectedContentType"`
+	Reason              string `json:"reason"`
+}
+
+// NewS3Event renders e as a single-record S3 event. configurationID names the
+// notification configuration or trigger the event is delivered for. As in
+// AWS, the object key is URL-encoded and the sequencer orders the events of
+// one key.
+func NewS3Event(e Event, configurationID string) S3Event {
+	t := e.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	region := e.Region
+	if region == "" {
+		region = DefaultRegion
+	}
+	principal := e.PrincipalID
+	if principal == "" {
+		principal = "anonymous"
+	}
+	responseElements := map[string]string{}
+	if e.RequestID != "" {
+		responseElements["x-amz-request-id"] = e.RequestID
+		responseElements["x-amz-id-2"] = e.RequestID
+	}
+
+	record := S3EventRecord{
+		EventVersion:      "2.1",
+		EventSource:       "vaults3",
+		AWSRegion:         region,
+		EventTime:         t.UTC().Format("2006-01-02T15:04:05.000Z"),
+		EventName:         e.Name,
+		UserIdentity:      UserIdentity{PrincipalID: principal},
+		RequestParameters: RequestParameters{SourceIPAddress: e.SourceIP},
+		ResponseElements:  responseElements,
+		S3: S3Detail{
+			SchemaVersion:   "1.0",
+			ConfigurationID: configurationID,
+			Bucket: S3Bucket{
+				Name:          e.Bucket,
+				OwnerIdentity: UserIdentity{PrincipalID: SystemPrincipal},
+				ARN:           "arn:aws:s3:::" + e.Bucket,
+			},
+			Object: S3Object{
+				Key:       url.QueryEscape(e.Key),
+				Size:      e.Size,
+				ETag:      e.ETag,
+				VersionID: e.VersionID,
+				Sequencer: fmt.Sprintf("%016X", t.UnixNano()),
+			},
+		},
+	}
+	if e.RestoreStorageClass != "" || !e.RestoreExpiry.IsZero() {
+		data := RestoreEventData{LifecycleRestoreStorageClass: e.RestoreStorageClass}
+		if !e.RestoreExpiry.IsZero() {
+			data.LifecycleRestorationExpiryTime = e.RestoreExpiry.UTC().Format("2006-01-02T15:04:05.000Z")
+		}
+		record.GlacierEventData = &GlacierEventData{RestoreEventData: data}
+	}
+	if e.RejectionReason != "" {
+		record.RejectionEventData = &RejectionEventData{
+			DeclaredContentType: e.DeclaredContentType,
+			DetectedContentType: e.DetectedContentType,
+			Reason:              e.RejectionReason,
+		}
+	}
+	return S3Event{Records: []S3EventRecord{record}}
+}
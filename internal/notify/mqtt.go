@@ -0,0 +1,326 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MQTTOptions configures an MQTT backend.
+type MQTTOptions struct {
+	// Broker is tcp://host:1883, or ssl://, tls:// or mqtts:// for TLS.
+	Broker string
+	// Topic may contain {bucket}, {key} and {event}, filled from each event.
+	Topic    string
+	QoS      int // 0 or 1
+	Retain   bool
+	Username string
+	Password string
+	ClientID string // defaults to "vaults3-<pid>"
+	CAFile   string // PEM CAs trusted for TLS brokers; system roots if empty
+}
+
+// MQTTBackend publishes notification events to an MQTT 3.1.1 broker.
+// The connection is established lazily and re-established after failures.
+type MQTTBackend struct {
+	opts   MQTTOptions
+	addr   string
+	tlsCfg *tls.Config
+	mu     sync.Mutex
+	conn   net.Conn
+	r      *bufio.Reader
+	nextID uint16
+	closed bool
+}
+
+// NewMQTTBackend creates an MQTT notification backend.
+func NewMQTTBackend(opts MQTTOptions) (*MQTTBackend, error) {
+	if opts.QoS < 0 || opts.QoS > 1 {
+		return nil, fmt.Errorf("mqtt qos must be 0 or 1")
+	}
+	if opts.Topic == "" {
+		return nil, fmt.Errorf("mqtt topic is required")
+	}
+	u, err := url.Parse(opts.Broker)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("mqtt broker must be a URL such as tcp://host:1883")
+	}
+	m := &MQTTBackend{opts: opts, addr: u.Host}
+	switch u.Scheme {
+	case "tcp", "mqtt":
+		if u.Port() == "" {
+			m.addr = net.JoinHostPort(u.Hostname(), "1883")
+		}
+	case "ssl", "tls", "mqtts":
+		if u.Port() == "" {
+			m.addr = net.JoinHostPort(u.Hostname(), "8883")
+		}
+		m.tlsCfg = &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12}
+		if opts.CAFile != "" {
+			pem, err := os.ReadFile(opts.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("mqtt ca file: %w", err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("mqtt ca file contains no certificates")
+			}
+			m.tlsCfg.RootCAs = pool
+		}
+	default:
+		return nil, fmt.Errorf("unsupported mqtt broker scheme %q", u.Scheme)
+	}
+	if m.opts.ClientID == "" {
+		m.opts.ClientID = fmt.Sprintf("vaults3-%d", os.Getpid())
+	}
+	return m, nil
+}
+
+func (m *MQTTBackend) Name() string {
+	return "mqtt"
+}
+
+// Publish sends payload to the topic rendered for its event. A publish on a
+// connection the broker has dropped is retried once on a new connection.
+func (m *MQTTBackend) Publish(ctx context.Context, payload []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return fmt.Errorf("mqtt backend closed")
+	}
+	topic := renderTopic(m.opts.Topic, payload)
+
+	reused := m.conn != nil
+	err := m.publish(ctx, topic, payload)
+	if err != nil && reused && ctx.Err() == nil {
+		err = m.publish(ctx, topic, payload)
+	}
+	return err
+}
+
+func (m *MQTTBackend) publish(ctx context.Context, topic string, payload []byte) error {
+	if err := m.ensureConnection(ctx); err != nil {
+		return err
+	}
+	if err := m.writePublish(ctx, topic, payload); err != nil {
+		m.drop()
+		return err
+	}
+	return nil
+}
+
+func (m *MQTTBackend) ensureConnection(ctx context.Context) error {
+	if m.conn != nil {
+		return nil
+	}
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	var conn net.Conn
+	var err error
+	if m.tlsCfg != nil {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: m.tlsCfg}).DialContext(ctx, "tcp", m.addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", m.addr)
+	}
+	if err != nil {
+		return fmt.Errorf("mqtt dial: %w", err)
+	}
+	setDeadline(ctx, conn)
+
+	// CONNECT with a clean session and keep-alive disabled: the backend only
+	// publishes, and a dead connection is detected on the next publish.
+	var flags byte = 0x02
+	var body []byte
+	body = appendString(body, "MQTT")
+	body = append(body, 4) // protocol level 3.1.1
+	if m.opts.Username != "" {
+		flags |= 0x80
+	}
+	if m.opts.Password != "" {
+		flags |= 0x40
+	}
+	body = append(body, flags, 0, 0)
+	body = appendString(body, m.opts.ClientID)
+	if m.opts.Username != "" {
+		body = appendString(body, m.opts.Username)
+	}
+	if m.opts.Password != "" {
+		body = appendString(body, m.opts.Password)
+	}
+	if _, err := conn.Write(mqttPacket(0x10, body)); err != nil {
+		conn.Close()
+		return fmt.Errorf("mqtt connect: %w", err)
+	}
+
+	r := bufio.NewReader(conn)
+	typ, ack, err := readMQTTPacket(r)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("mqtt connack: %w", err)
+	}
+	if typ != 0x20 || len(ack) != 2 {
+		conn.Close()
+		return fmt.Errorf("mqtt: unexpected packet 0x%02x instead of CONNACK", typ)
+	}
+	if ack[1] != 0 {
+		conn.Close()
+		return fmt.Errorf("mqtt: connection refused (code %d)", ack[1])
+	}
+	m.conn = conn
+	m.r = r
+	return nil
+}
+
+func (m *MQTTBackend) writePublish(ctx context.Context, topic string, payload []byte) error {
+	setDeadline(ctx, m.conn)
+	header := byte(0x30) | byte(m.opts.QoS<<1)
+	if m.opts.Retain {
+		header |= 0x01
+	}
+	body := appendString(nil, topic)
+	var id uint16
+	if m.opts.QoS > 0 {
+		m.nextID++
+		if m.nextID == 0 {
+			m.nextID = 1
+		}
+		id = m.nextID
+		body = binary.BigEndian.AppendUint16(body, id)
+	}
+	body = append(body, payload...)
+	if _, err := m.conn.Write(mqttPacket(header, body)); err != nil {
+		return fmt.Errorf("mqtt publish: %w", err)
+	}
+	if m.opts.QoS == 0 {
+		return nil
+	}
+	for {
+		typ, ack, err := readMQTTPacket(m.r)
+		if err != nil {
+			return fmt.Errorf("mqtt puback: %w", err)
+		}
+		if typ == 0x40 && len(ack) == 2 && binary.BigEndian.Uint16(ack) == id {
+			return nil
+		}
+	}
+}
+
+// drop closes the current connection so the next publish reconnects.
+func (m *MQTTBackend) drop() {
+	if m.conn != nil {
+		m.conn.Close()
+		m.conn = nil
+		m.r = nil
+	}
+}
+
+func (m *MQTTBackend) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	if m.conn != nil {
+		m.conn.SetWriteDeadline(time.Now().Add(time.Second))
+		m.conn.Write([]byte{0xE0, 0x00}) // DISCONNECT
+	}
+	m.drop()
+	return nil
+}
+
+// renderTopic fills {bucket}, {key} and {event} from the event in payload.
+// MQTT wildcards are not allowed in published topics, so + and # in object
+// keys are replaced with _.
+func renderTopic(tmpl string, payload []byte) string {
+	if !strings.Contains(tmpl, "{") {
+		return tmpl
+	}
+	var event S3Event
+	var bucket, key, name string
+	if json.Unmarshal(payload, &event) == nil && len(event.Records) > 0 {
+		rec := event.Records[0]
+		bucket = rec.S3.Bucket.Name
+		name = rec.EventName
+		key = rec.S3.Object.Key
+		if k, err := url.QueryUnescape(key); err == nil {
+			key = k
+		}
+	}
+	clean := strings.NewReplacer("+", "_", "#", "_")
+	return strings.NewReplacer(
+		"{bucket}", clean.Replace(bucket),
+		"{key}", clean.Replace(key),
+		"{event}", clean.Replace(name),
+	).Replace(tmpl)
+}
+
+func mqttPacket(header byte, body []byte) []byte {
+	pkt := []byte{header}
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		pkt = append(pkt, b)
+		if n == 0 {
+			break
+		}
+	}
+	return append(pkt, body...)
+}
+
+// readMQTTPacket reads one control packet and returns its type (the high
+// nibble of the fixed header) and its body.
+func readMQTTPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	n, mult := 0, 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return 0, nil, errors.New("malformed remaining length")
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		n += int(b&0x7F) * mult
+		mult *= 128
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header & 0xF0, body, nil
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// setDeadline bounds the next network operations on conn by ctx, or by five
+// seconds when ctx has no deadline.
+func setDeadline(ctx context.Context, conn net.Conn) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(5 * time.Second)
+	}
+	conn.SetDeadline(deadline)
+}
@@ -1,47 +1,20 @@
 package notify
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"log/slog"
-	"net/http"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/eniz1806/VaultS3/internal/metadata"
+	"github.com/eniz1806/VaultS3/internal/webhook"
 )
 
-// S3Event matches the AWS S3 event notification JSON format.
-type S3Event struct {
-	Records []S3EventRecord `json:"Records"`
-}
-
-type S3EventRecord struct {
-	EventVersion string   `json:"eventVersion"`
-	EventSource  string   `json:"eventSource"`
-	EventTime    string   `json:"eventTime"`
-	EventName    string   `json:"eventName"`
-	S3           S3Detail `json:"s3"`
-}
-
-type S3Detail struct {
-	Bucket S3Bucket `json:"bucket"`
-	Object S3Object `json:"object"`
-}
-
-type S3Bucket struct {
-	Name string `json:"name"`
-}
-
-type S3Object struct {
-	Key       string `json:"key"`
-	Size      int64  `json:"size"`
-	ETag      string `json:"eTag,omitempty"`
-	VersionID string `json:"versionId,omitempty"`
-}
-
 // Backend is the interface for notification delivery backends.
 type Backend interface {
 	Name() string
@@ -49,58 +22,107 @@ type Backend interface {
 	Close() error
 }
 
-type deliveryJob struct {
-	endpoint   string
-	payload    []byte
-	retryCount int
-	maxRetries int
-}
+// Delivery kinds of outbox events.
+const (
+	KindWebhook = "webhook"
+	KindTarget  = "target"
+)
+
+const maxBackoff = 5 * time.Minute
 
-// Dispatcher handles async webhook delivery with retry.
+// Dispatcher delivers event notifications at least once. Dispatch persists
+// an event per destination in a BoltDB outbox before returning; workers
+// deliver due events with exponential backoff and move events that still fail
+// after maxRetries attempts to a dead-letter area. Undelivered events survive
+// restarts.
 type Dispatcher struct {
-	store      *metadata.Store
-	client     *http.Client
-	workerCh   chan deliveryJob
-	wg         sync.WaitGroup
-	maxWorkers int
-	maxRetries int
-	backoff    []time.Duration
-	backends   []Backend
-	mu         sync.Mutex
+	store       *metadata.Store
+	client      *webhook.Client
+	timeout     time.Duration
+	workerCh    chan metadata.NotificationEvent
+	wake        chan struct{}
+	stop        chan struct{}
+	stopOnce    sync.Once
+	wg          sync.WaitGroup
+	maxWorkers  int
+	maxRetries  int
+	batchSize   int
+	poll        time.Duration // outbox poll interval, for events waiting out a backoff
+	baseBackoff time.Duration
+	backends    []Backend
+	routed      bool               // route global backends by ARN instead of fanning out
+	targets     map[string]Backend // named targets by ARN
+	inflight    map[uint64]bool    // events handed to workers
+	delivered   atomic.Int64
+	failed      atomic.Int64 // failed delivery attempts
+	mu          sync.Mutex
 }
 
 func NewDispatcher(store *metadata.Store, maxWorkers, queueSize, timeoutSecs, maxRetries int) *Dispatcher {
+	if maxWorkers <= 0 {
+		maxWorkers = 1
+	}
+	if queueSize <= 0 {
+		queueSize = 1
+	}
+	if maxRetries <= 0 {
+		maxRetries = 1
+	}
 	return &Dispatcher{
-		store:      store,
-		client:     &http.Client{Timeout: time.Duration(timeoutSecs) * time.Second},
-		workerCh:   make(chan deliveryJob, queueSize),
-		maxWorkers: maxWorkers,
-		maxRetries: maxRetries,
-		backoff:    []time.Duration{1 * time.Second, 5 * time.Second, 30 * time.Second},
+		store:       store,
+		client:      webhook.NewClient(time.Duration(timeoutSecs) * time.Second),
+		timeout:     time.Duration(timeoutSecs) * time.Second,
+		workerCh:    make(chan metadata.NotificationEvent, queueSize),
+		wake:        make(chan struct{}, 1),
+		stop:        make(chan struct{}),
+		maxWorkers:  maxWorkers,
+		maxRetries:  maxRetries,
+		batchSize:   queueSize,
+		poll:        time.Second,
+		baseBackoff: time.Second,
+		targets:     make(map[string]Backend),
+		inflight:    make(map[uint64]bool),
 	}
 }
 
+// Start launches the outbox scheduler and delivery workers.
 func (d *Dispatcher) Start(ctx context.Context) {
+	d.wg.Add(1)
+	go d.schedule(ctx)
 	for i := 0; i < d.maxWorkers; i++ {
 		d.wg.Add(1)
 		go func() {
 			defer d.wg.Done()
-			for {
-				select {
-				case <-ctx.Done():
-					return
-				case job, ok := <-d.workerCh:
-					if !ok {
-						return
-					}
-					d.deliverWebhook(job)
-				}
+			for event := range d.workerCh {
+				d.deliver(event)
 			}
 		}()
 	}
 }
 
-// AddBackend registers a notification backend.
+// SetWebhookSecrets sets the named secrets webhook bearer tokens are read
+// from. It must be called before Start.
+func (d *Dispatcher) SetWebhookSecrets(secrets webhook.Secrets) {
+	d.client.SetSecrets(secrets)
+}
+
+// BackendARN returns the ARN bucket notification configurations route events
+// to a global backend by, e.g. arn:vaults3:sqs::kafka.
+func BackendARN(name string) string {
+	return "arn:vaults3:sqs::" + name
+}
+
+// SetRouteBackends makes global backends receive only the events of buckets
+// whose configuration routes to their BackendARN, like named targets. By
+// default every backend receives every event of buckets with a notification
+// configuration.
+func (d *Dispatcher) SetRouteBackends(route bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.routed = route
+}
+
+// AddBackend registers a global notification backend.
 func (d *Dispatcher) AddBackend(b Backend) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
@@ -108,109 +130,366 @@ func (d *Dispatcher) AddBackend(b Backend) {
 	slog.Info("notification backend registered", "backend", b.Name())
 }
 
+// AddTarget registers a named notification target under its ARN. Buckets
+// route events to it through target configurations naming the ARN.
+func (d *Dispatcher) AddTarget(arn string, b Backend) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.targets[arn] = b
+	slog.Info("notification target registered", "arn", arn, "backend", b.Name())
+}
+
+// Backends returns the names of the registered global backends.
+func (d *Dispatcher) Backends() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	names := make([]string, 0, len(d.backends))
+	for _, b := range d.backends {
+		names = append(names, b.Name())
+	}
+	return names
+}
+
+// TargetARNs returns the ARNs of the registered targets, sorted.
+func (d *Dispatcher) TargetARNs() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	arns := make([]string, 0, len(d.targets)+len(d.backends))
+	for arn := range d.targets {
+		arns = append(arns, arn)
+	}
+	for _, b := range d.backends {
+		if _, ok := d.targets[BackendARN(b.Name())]; !ok {
+			arns = append(arns, BackendARN(b.Name()))
+		}
+	}
+	sort.Strings(arns)
+	return arns
+}
+
+// Stop waits for the workers to finish the events handed to them and closes
+// the backends. Events still in the outbox are delivered after a restart.
 func (d *Dispatcher) Stop() {
-	close(d.workerCh)
+	d.stopOnce.Do(func() { close(d.stop) })
 	d.wg.Wait()
 	d.mu.Lock()
 	defer d.mu.Unlock()
 	for _, b := range d.backends {
 		b.Close()
 	}
+	for _, b := range d.targets {
+		b.Close()
+	}
 }
 
-// Dispatch checks notification configs for the bucket and fires matching webhooks.
-func (d *Dispatcher) Dispatch(bucket, key, eventType string, size int64, etag, versionID string) {
-	cfg, err := d.store.GetNotificationConfig(bucket)
-	if err != nil {
-		return // no config for this bucket
-	}
-
-	event := S3Event{
-		Records: []S3EventRecord{{
-			EventVersion: "2.1",
-			EventSource:  "vaults3",
-			EventTime:    time.Now().UTC().Format(time.RFC3339),
-			EventName:    eventType,
-			S3: S3Detail{
-				Bucket: S3Bucket{Name: bucket},
-				Object: S3Object{
-					Key:       key,
-					Size:      size,
-					ETag:      etag,
-					VersionID: versionID,
-				},
-			},
-		}},
-	}
-
-	payload, err := json.Marshal(event)
-	if err != nil {
-		slog.Error("notify error marshaling event", "error", err)
+// Dispatch checks notification configs for the bucket and persists an outbox
+// event for each matching webhook, named target and global backend. Events
+// whose outbox events were persisted with the metadata change that caused
+// them (Queued) only wake the workers.
+func (d *Dispatcher) Dispatch(e Event) {
+	if e.Queued {
+		d.signal()
+		return
+	}
+	events := d.Plan(e)
+	if len(events) == 0 {
+		return
+	}
+	if err := d.store.EnqueueNotifications(events); err != nil {
+		slog.Error("notify error persisting events", "event", e.Name, "bucket", e.Bucket, "key", e.Key, "error", err)
 		return
 	}
+	d.signal()
+}
 
-	// Publish to all registered backends
-	d.mu.Lock()
-	backends := make([]Backend, len(d.backends))
-	copy(backends, d.backends)
-	d.mu.Unlock()
-	for _, b := range backends {
-		if err := b.Publish(context.Background(), payload); err != nil {
-			slog.Error("notify backend publish error", "backend", b.Name(), "error", err)
+// Plan returns the outbox events of e that Dispatch would persist, one for
+// each destination, for a caller to persist with the change that caused e.
+func (d *Dispatcher) Plan(e Event) []metadata.NotificationEvent {
+	cfg, err := d.store.GetNotificationConfig(e.Bucket)
+	if err != nil {
+		return nil // no config for this bucket
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	var events []metadata.NotificationEvent
+	add := func(kind, destination, configurationID string) {
+		payload, err := json.Marshal(NewS3Event(e, configurationID))
+		if err != nil {
+			slog.Error("notify error marshaling event", "error", err)
+			return
 		}
+		events = append(events, metadata.NotificationEvent{
+			Kind:        kind,
+			Destination: destination,
+			ConfigID:    configurationID,
+			EventName:   e.Name,
+			Bucket:      e.Bucket,
+			Key:         e.Key,
+			Payload:     payload,
+		})
 	}
 
+	// Unless they are routed by ARN, global backends receive every event
+	fanned := make(map[string]bool)
+	d.mu.Lock()
+	if !d.routed {
+		for _, b := range d.backends {
+			arn := BackendARN(b.Name())
+			if _, ok := d.targets[arn]; !ok {
+				fanned[arn] = true
+				add(KindTarget, arn, b.Name())
+			}
+		}
+	}
+	d.mu.Unlock()
 	for _, wh := range cfg.Webhooks {
-		if !matchEvent(wh.Events, eventType) {
-			continue
+		if matchEvent(wh.Events, e.Name) && matchFilters(wh.Filters, e.Key) {
+			add(KindWebhook, wh.Endpoint, wh.ID)
 		}
-		if !matchFilters(wh.Filters, key) {
+	}
+	// Route to named targets and routed global backends by their own event
+	// and key filters
+	for _, tc := range cfg.Targets {
+		if fanned[tc.ARN] {
 			continue
 		}
+		if matchEvent(tc.Events, e.Name) && matchFilters(tc.Filters, e.Key) {
+			add(KindTarget, tc.ARN, tc.ID)
+		}
+	}
+	return events
+}
 
-		job := deliveryJob{
-			endpoint:   wh.Endpoint,
-			payload:    payload,
-			retryCount: 0,
-			maxRetries: d.maxRetries,
+// DispatchTo persists an outbox event delivering e to the named target or
+// global backend arn only, regardless of the bucket's notification
+// configuration.
+func (d *Dispatcher) DispatchTo(e Event, arn, configurationID string) error {
+	if d.backend(arn) == nil {
+		return fmt.Errorf("notification target %s is not registered", arn)
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	payload, err := json.Marshal(NewS3Event(e, configurationID))
+	if err != nil {
+		return fmt.Errorf("marshal event: %w", err)
+	}
+	err = d.store.EnqueueNotifications([]metadata.NotificationEvent{{
+		Kind:        KindTarget,
+		Destination: arn,
+		EventName:   e.Name,
+		Bucket:      e.Bucket,
+		Key:         e.Key,
+		Payload:     payload,
+	}})
+	if err != nil {
+		return err
+	}
+	d.signal()
+	return nil
+}
+
+// signal wakes the scheduler without blocking.
+func (d *Dispatcher) signal() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// schedule hands due outbox events to the workers until stopped, then
+// closes the worker channel.
+func (d *Dispatcher) schedule(ctx context.Context) {
+	defer d.wg.Done()
+	defer close(d.workerCh)
+	ticker := time.NewTicker(d.poll)
+	defer ticker.Stop()
+	for {
+		if !d.scheduleDue(ctx) {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.stop:
+			return
+		case <-d.wake:
+		case <-ticker.C:
 		}
+	}
+}
 
-		// Non-blocking send — drop if queue is full
+// scheduleDue hands the due events not already in flight to the workers. It
+// returns false once the dispatcher is stopping.
+func (d *Dispatcher) scheduleDue(ctx context.Context) bool {
+	events, err := d.store.DequeueNotifications(d.batchSize, time.Now().UnixNano(), d.isInflight)
+	if err != nil {
+		slog.Error("notify error reading outbox", "error", err)
+		return true
+	}
+	for _, event := range events {
+		d.mu.Lock()
+		d.inflight[event.ID] = true
+		d.mu.Unlock()
 		select {
-		case d.workerCh <- job:
-		default:
-			slog.Warn("notify queue full, dropping event", "event", eventType, "bucket", bucket, "key", key)
+		case d.workerCh <- event:
+		case <-ctx.Done():
+			return false
+		case <-d.stop:
+			return false
 		}
 	}
+	return true
 }
 
-func (d *Dispatcher) deliverWebhook(job deliveryJob) {
-	resp, err := d.client.Post(job.endpoint, "application/json", bytes.NewReader(job.payload))
-	if err == nil {
-		resp.Body.Close()
-		if resp.StatusCode < 300 {
-			return // success
+func (d *Dispatcher) isInflight(id uint64) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.inflight[id]
+}
+
+// send makes one delivery attempt of an event.
+func (d *Dispatcher) send(event metadata.NotificationEvent) error {
+	switch event.Kind {
+	case KindTarget:
+		b := d.backend(event.Destination)
+		if b == nil {
+			return fmt.Errorf("notification %s %s is not registered", event.Kind, event.Destination)
+		}
+		ctx := context.Background()
+		if d.timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, d.timeout)
+			defer cancel()
+		}
+		return b.Publish(ctx, event.Payload)
+	}
+
+	resp, err := d.client.Post(context.Background(), event.Destination, "application/json", event.Payload, d.webhookAuth(event))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return &httpError{statusCode: resp.StatusCode}
+	}
+	return nil
+}
+
+// webhookAuth returns the current signing and TLS settings of the webhook an
+// outbox event is addressed to. They are looked up at delivery time so that
+// secrets never enter the outbox and rotations apply to pending events. The
+// webhook is found by its ID, so webhooks sharing an endpoint keep their own
+// secrets.
+func (d *Dispatcher) webhookAuth(event metadata.NotificationEvent) *metadata.WebhookAuth {
+	cfg, err := d.store.GetNotificationConfig(event.Bucket)
+	if err != nil {
+		return nil
+	}
+	for _, wh := range cfg.Webhooks {
+		if wh.ID == event.ConfigID {
+			return wh.Auth
 		}
-		err = &httpError{statusCode: resp.StatusCode}
 	}
+	return nil
+}
 
-	// Retry
-	if job.retryCount < job.maxRetries-1 {
-		backoffIdx := job.retryCount
-		if backoffIdx >= len(d.backoff) {
-			backoffIdx = len(d.backoff) - 1
+// backend returns the named target or global backend registered under arn.
+func (d *Dispatcher) backend(arn string) Backend {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if b, ok := d.targets[arn]; ok {
+		return b
+	}
+	for _, b := range d.backends {
+		if BackendARN(b.Name()) == arn {
+			return b
 		}
-		time.Sleep(d.backoff[backoffIdx])
+	}
+	return nil
+}
 
-		job.retryCount++
-		select {
-		case d.workerCh <- job:
-		default:
-			slog.Warn("notify queue full on retry, dropping webhook", "endpoint", job.endpoint)
+// deliver attempts an event and records the outcome in the outbox: removed
+// on success, rescheduled with backoff on failure, or dead-lettered once its
+// attempts are used up.
+func (d *Dispatcher) deliver(event metadata.NotificationEvent) {
+	defer func() {
+		d.mu.Lock()
+		delete(d.inflight, event.ID)
+		d.mu.Unlock()
+	}()
+
+	err := d.send(event)
+	if err == nil {
+		d.delivered.Add(1)
+		if err := d.store.AckNotification(event.ID); err != nil {
+			slog.Error("notify error acking event", "id", event.ID, "error", err)
+		}
+		return
+	}
+
+	d.failed.Add(1)
+	event.Attempts++
+	if event.Attempts >= d.maxRetries {
+		slog.Error("notify delivery failed after retries, dead-lettering", "retries", d.maxRetries, "kind", event.Kind, "destination", event.Destination, "error", err)
+		if err := d.store.DeadLetterNotification(event.ID, event.Attempts, err.Error()); err != nil {
+			slog.Error("notify error dead-lettering event", "id", event.ID, "error", err)
 		}
-	} else {
-		slog.Error("notify webhook failed after retries", "retries", job.maxRetries, "endpoint", job.endpoint, "error", err)
+		return
 	}
+	next := time.Now().Add(d.backoff(event.Attempts)).UnixNano()
+	if err := d.store.NackNotification(event.ID, event.Attempts, next, err.Error()); err != nil {
+		slog.Error("notify error rescheduling event", "id", event.ID, "error", err)
+	}
+}
+
+// backoff returns the delay before the attempt following the given number
+// of failed attempts, doubling each time up to maxBackoff.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.baseBackoff
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+	return delay
+}
+
+// DeadLetters returns up to limit dead-lettered events, optionally only those
+// for one destination.
+func (d *Dispatcher) DeadLetters(destination string, limit int) ([]metadata.NotificationEvent, error) {
+	return d.store.ListDeadLetterNotifications(destination, limit)
+}
+
+// ReplayDeadLetters queues dead-lettered events for delivery again: those
+// with the given IDs, or all of them if ids is empty, optionally only for
+// one destination. It returns how many were queued.
+func (d *Dispatcher) ReplayDeadLetters(ids []uint64, destination string) (int, error) {
+	n, err := d.store.ReplayDeadLetterNotifications(ids, destination)
+	if n > 0 {
+		d.signal()
+	}
+	return n, err
+}
+
+// PurgeDeadLetters deletes dead-lettered events, selected as for
+// ReplayDeadLetters, and returns how many were deleted.
+func (d *Dispatcher) PurgeDeadLetters(ids []uint64, destination string) (int, error) {
+	return d.store.PurgeDeadLetterNotifications(ids, destination)
+}
+
+// QueueStats returns the outbox depth and dead letters of each destination.
+func (d *Dispatcher) QueueStats() ([]metadata.NotificationQueueStats, error) {
+	return d.store.NotificationQueueStats()
+}
+
+// DeliveryCounts returns the number of delivered events and of failed
+// delivery attempts since start.
+func (d *Dispatcher) DeliveryCounts() (delivered, failed int64) {
+	return d.delivered.Load(), d.failed.Load()
 }
 
 type httpError struct {
@@ -218,7 +497,7 @@ type httpError struct {
 }
 
 func (e *httpError) Error() string {
-	return "webhook returned non-success status"
+	return fmt.Sprintf("webhook returned status %d", e.statusCode)
 }
 
 // matchEvent checks if the actual event type matches any of the configured event patterns.
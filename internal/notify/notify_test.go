@@ -2,15 +2,22 @@ package notify
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/eniz1806/VaultS3/internal/metadata"
+	"github.com/eniz1806/VaultS3/internal/webhook"
 )
 
 func newTestStore(t *testing.T) *metadata.Store {
@@ -95,29 +102,45 @@ func TestDispatcher_BackendClose(t *testing.T) {
 
 func TestDispatcher_DispatchToBackend(t *testing.T) {
 	store := newTestStore(t)
-	// Create a bucket with notification config
 	store.CreateBucket("test-bucket")
 	store.PutNotificationConfig("test-bucket", metadata.BucketNotificationConfig{
+		Targets: []metadata.NotificationTargetConfig{
+			{ID: "t", Kind: "Queue", ARN: BackendARN("test"), Events: []string{"s3:ObjectCreated:*"}},
+		},
+	})
+	store.CreateBucket("other-bucket")
+	store.PutNotificationConfig("other-bucket", metadata.BucketNotificationConfig{
 		Webhooks: []metadata.NotificationEndpointConfig{},
 	})
 
-	d := NewDispatcher(store, 1, 10, 5, 3)
-	b := &mockBackend{name: "test"}
-	d.AddBackend(b)
-
-	ctx, cancel := context.WithCancel(context.Background())
-	d.Start(ctx)
-
-	d.Dispatch("test-bucket", "file.txt", "s3:ObjectCreated:Put", 1024, "etag123", "")
-
-	// Give time for async processing
-	time.Sleep(50 * time.Millisecond)
-
-	cancel()
-	d.Stop()
-
-	if len(b.messages) != 1 {
-		t.Errorf("expected 1 message to backend, got %d", len(b.messages))
+	// By default the backend receives every event of buckets with a
+	// configuration; routed, only those of the bucket routing to it, by its
+	// own filters
+	for route, want := range map[bool]int{false: 3, true: 1} {
+		d := NewDispatcher(store, 1, 10, 5, 3)
+		d.SetRouteBackends(route)
+		b := &mockBackend{name: "test"}
+		d.AddBackend(b)
+		if arns := d.TargetARNs(); len(arns) != 1 || arns[0] != "arn:vaults3:sqs::test" {
+			t.Fatalf("TargetARNs = %v", arns)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		d.Start(ctx)
+
+		d.Dispatch(Event{Name: "s3:ObjectCreated:Put", Bucket: "test-bucket", Key: "file.txt", Size: 1024, ETag: "etag123"})
+		d.Dispatch(Event{Name: "s3:ObjectRemoved:Delete", Bucket: "test-bucket", Key: "file.txt"})
+		d.Dispatch(Event{Name: "s3:ObjectCreated:Put", Bucket: "other-bucket", Key: "file.txt", Size: 1024, ETag: "etag123"})
+
+		// Give time for async processing
+		time.Sleep(50 * time.Millisecond)
+
+		cancel()
+		d.Stop()
+
+		if len(b.messages) != want {
+			t.Errorf("route=%v: expected %d messages to backend, got %d", route, want, len(b.messages))
+		}
 	}
 }
 
@@ -145,7 +168,7 @@ func TestDispatcher_WebhookDelivery(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	d.Start(ctx)
 
-	d.Dispatch("test-bucket", "file.txt", "s3:ObjectCreated:Put", 100, "etag", "")
+	d.Dispatch(Event{Name: "s3:ObjectCreated:Put", Bucket: "test-bucket", Key: "file.txt", Size: 100, ETag: "etag"})
 
 	time.Sleep(200 * time.Millisecond)
 	cancel()
@@ -181,7 +204,7 @@ func TestDispatcher_EventFiltering(t *testing.T) {
 	d.Start(ctx)
 
 	// This should NOT match the webhook (ObjectCreated vs ObjectRemoved)
-	d.Dispatch("test-bucket", "file.txt", "s3:ObjectCreated:Put", 100, "etag", "")
+	d.Dispatch(Event{Name: "s3:ObjectCreated:Put", Bucket: "test-bucket", Key: "file.txt", Size: 100, ETag: "etag"})
 
 	time.Sleep(100 * time.Millisecond)
 	cancel()
@@ -220,14 +243,14 @@ func TestDispatcher_KeyFilter(t *testing.T) {
 	d.Start(ctx)
 
 	// Non-matching key
-	d.Dispatch("test-bucket", "docs/file.txt", "s3:ObjectCreated:Put", 100, "etag", "")
+	d.Dispatch(Event{Name: "s3:ObjectCreated:Put", Bucket: "test-bucket", Key: "docs/file.txt", Size: 100, ETag: "etag"})
 	time.Sleep(100 * time.Millisecond)
 	if received.Load() != 0 {
 		t.Errorf("expected 0 for non-matching prefix, got %d", received.Load())
 	}
 
 	// Matching key
-	d.Dispatch("test-bucket", "images/photo.jpg", "s3:ObjectCreated:Put", 100, "etag", "")
+	d.Dispatch(Event{Name: "s3:ObjectCreated:Put", Bucket: "test-bucket", Key: "images/photo.jpg", Size: 100, ETag: "etag"})
 	time.Sleep(100 * time.Millisecond)
 
 	cancel()
@@ -238,6 +261,348 @@ func TestDispatcher_KeyFilter(t *testing.T) {
 	}
 }
 
+func TestDispatcher_TargetRouting(t *testing.T) {
+	store := newTestStore(t)
+	store.CreateBucket("a")
+	store.CreateBucket("b")
+	store.PutNotificationConfig("a", metadata.BucketNotificationConfig{
+		Targets: []metadata.NotificationTargetConfig{
+			{ID: "created", Kind: "Queue", ARN: "arn:vaults3:sqs::kafka-main", Events: []string{"s3:ObjectCreated:*"}},
+		},
+	})
+	store.PutNotificationConfig("b", metadata.BucketNotificationConfig{
+		Targets: []metadata.NotificationTargetConfig{
+			{ID: "deletes", Kind: "Queue", ARN: "arn:vaults3:sqs::nats-main", Events: []string{"s3:ObjectRemoved:*"},
+				Filters: []metadata.NotificationFilterRule{{Name: "suffix", Value: ".log"}}},
+		},
+	})
+
+	d := NewDispatcher(store, 1, 10, 5, 1)
+	kafka := &mockBackend{name: "kafka"}
+	nats := &mockBackend{name: "nats"}
+	d.AddTarget("arn:vaults3:sqs::kafka-main", kafka)
+	d.AddTarget("arn:vaults3:sqs::nats-main", nats)
+	if arns := d.TargetARNs(); len(arns) != 2 || arns[0] != "arn:vaults3:sqs::kafka-main" {
+		t.Fatalf("TargetARNs = %v", arns)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d.Start(ctx)
+	d.Dispatch(Event{Name: "s3:ObjectCreated:Put", Bucket: "a", Key: "x.log", Size: 1, ETag: "e"})
+	d.Dispatch(Event{Name: "s3:ObjectRemoved:Delete", Bucket: "a", Key: "x.log"})
+	d.Dispatch(Event{Name: "s3:ObjectCreated:Put", Bucket: "b", Key: "x.log", Size: 1, ETag: "e"})
+	d.Dispatch(Event{Name: "s3:ObjectRemoved:Delete", Bucket: "b", Key: "x.txt"})
+	d.Dispatch(Event{Name: "s3:ObjectRemoved:Delete", Bucket: "b", Key: "y.log"})
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	d.Stop()
+
+	if len(kafka.messages) != 1 || !strings.Contains(string(kafka.messages[0]), `"name":"a"`) {
+		t.Errorf("kafka target got %d messages, want bucket a's create", len(kafka.messages))
+	}
+	if len(nats.messages) != 1 || !strings.Contains(string(nats.messages[0]), `"key":"y.log"`) {
+		t.Errorf("nats target got %d messages, want bucket b's .log delete", len(nats.messages))
+	}
+	if !kafka.closed || !nats.closed {
+		t.Error("targets should be closed on stop")
+	}
+}
+
+// flakyBackend fails its first failures publishes.
+type flakyBackend struct {
+	mu       sync.Mutex
+	failures int
+	attempts int
+	messages [][]byte
+}
+
+func (f *flakyBackend) Name() string { return "flaky" }
+func (f *flakyBackend) Publish(_ context.Context, payload []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.attempts++
+	if f.attempts <= f.failures {
+		return errors.New("broker unavailable")
+	}
+	f.messages = append(f.messages, payload)
+	return nil
+}
+func (f *flakyBackend) Close() error { return nil }
+
+func (f *flakyBackend) counts() (attempts, delivered int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.attempts, len(f.messages)
+}
+
+func newOutboxDispatcher(store *metadata.Store, maxRetries int) *Dispatcher {
+	d := NewDispatcher(store, 1, 10, 5, maxRetries)
+	d.poll = 5 * time.Millisecond
+	d.baseBackoff = time.Millisecond
+	return d
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestNewS3Event(t *testing.T) {
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	ev := NewS3Event(Event{
+		Name:        EventObjectCreatedPut,
+		Bucket:      "photos",
+		Key:         "2026/my cat.jpg",
+		Size:        42,
+		ETag:        "abc",
+		VersionID:   "v1",
+		Time:        at,
+		Region:      "eu-west-1",
+		PrincipalID: "AKIAEXAMPLE",
+		SourceIP:    "10.0.0.7",
+		RequestID:   "req-1",
+	}, "thumbnails")
+
+	if len(ev.Records) != 1 {
+		t.Fatalf("expected 1 record, got %d", len(ev.Records))
+	}
+	r := ev.Records[0]
+	if r.AWSRegion != "eu-west-1" || r.EventTime != "2026-03-01T12:00:00.000Z" {
+		t.Errorf("unexpected region/time: %s %s", r.AWSRegion, r.EventTime)
+	}
+	if r.UserIdentity.PrincipalID != "AKIAEXAMPLE" || r.RequestParameters.SourceIPAddress != "10.0.0.7" {
+		t.Errorf("unexpected requester: %+v %+v", r.UserIdentity, r.RequestParameters)
+	}
+	if r.ResponseElements["x-amz-request-id"] != "req-1" {
+		t.Errorf("unexpected response elements: %v", r.ResponseElements)
+	}
+	if r.S3.ConfigurationID != "thumbnails" || r.S3.SchemaVersion != "1.0" || r.S3.Bucket.ARN != "arn:aws:s3:::photos" {
+		t.Errorf("unexpected s3 detail: %+v", r.S3)
+	}
+	if r.S3.Object.Key != "2026%2Fmy+cat.jpg" {
+		t.Errorf("expected URL-encoded key, got %q", r.S3.Object.Key)
+	}
+	if r.S3.Object.Sequencer != fmt.Sprintf("%016X", at.UnixNano()) {
+		t.Errorf("unexpected sequencer %q", r.S3.Object.Sequencer)
+	}
+	if r.GlacierEventData != nil {
+		t.Error("put event should not carry glacierEventData")
+	}
+
+	// Restore events report the restored copy; defaults fill the rest
+	expiry := at.Add(48 * time.Hour)
+	r = NewS3Event(Event{
+		Name:                EventObjectRestoreCompleted,
+		Bucket:              "photos",
+		Key:                 "old.jpg",
+		RestoreExpiry:       expiry,
+		RestoreStorageClass: "GLACIER",
+	}, "").Records[0]
+	if r.AWSRegion != DefaultRegion || r.UserIdentity.PrincipalID != "anonymous" {
+		t.Errorf("unexpected defaults: %s %s", r.AWSRegion, r.UserIdentity.PrincipalID)
+	}
+	if r.GlacierEventData == nil ||
+		r.GlacierEventData.RestoreEventData.LifecycleRestorationExpiryTime != "2026-03-03T12:00:00.000Z" ||
+		r.GlacierEventData.RestoreEventData.LifecycleRestoreStorageClass != "GLACIER" {
+		t.Errorf("unexpected glacierEventData: %+v", r.GlacierEventData)
+	}
+	if r.RejectionEventData != nil {
+		t.Error("restore event should not carry rejectionEventData")
+	}
+
+	// Rejected uploads report what was declared and detected
+	r = NewS3Event(Event{
+		Name:                EventObjectRejectedContentType,
+		Bucket:              "docs",
+		Key:                 "report.pdf",
+		DeclaredContentType: "application/pdf",
+		DetectedContentType: "application/x-elf",
+		RejectionReason:     "content type application/x-elf is not allowed",
+	}, "").Records[0]
+	if r.RejectionEventData == nil || r.RejectionEventData.DeclaredContentType != "application/pdf" ||
+		r.RejectionEventData.DetectedContentType != "application/x-elf" || r.RejectionEventData.Reason == "" {
+		t.Errorf("unexpected rejectionEventData: %+v", r.RejectionEventData)
+	}
+}
+
+func TestDispatcher_WebhookAuthByID(t *testing.T) {
+	var mu sync.Mutex
+	var verified []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		for _, secret := range []string{"secret-a", "secret-b"} {
+			if webhook.Verify(secret, r.Header.Get(webhook.SignatureHeader), r.Header.Get(webhook.DeliveryHeader), body, webhook.DefaultTolerance, time.Now()) == nil {
+				mu.Lock()
+				verified = append(verified, secret)
+				mu.Unlock()
+			}
+		}
+	}))
+	defer server.Close()
+
+	store := newTestStore(t)
+	if err := store.SetSecretsKey(make([]byte, 32)); err != nil {
+		t.Fatalf("SetSecretsKey: %v", err)
+	}
+	store.CreateBucket("b")
+	// Two webhooks share an endpoint but sign with their own secrets
+	store.PutNotificationConfig("b", metadata.BucketNotificationConfig{
+		Webhooks: []metadata.NotificationEndpointConfig{
+			{ID: "a", Endpoint: server.URL, Events: []string{"s3:ObjectCreated:*"}, Auth: &metadata.WebhookAuth{Secret: "secret-a"}},
+			{ID: "b", Endpoint: server.URL, Events: []string{"s3:ObjectRemoved:*"}, Auth: &metadata.WebhookAuth{Secret: "secret-b"}},
+		},
+	})
+
+	d := NewDispatcher(store, 1, 10, 5, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	d.Start(ctx)
+	d.Dispatch(Event{Name: "s3:ObjectRemoved:Delete", Bucket: "b", Key: "k"})
+	time.Sleep(200 * time.Millisecond)
+	cancel()
+	d.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(verified) != 1 || verified[0] != "secret-b" {
+		t.Errorf("verified with %v, want [secret-b]", verified)
+	}
+}
+
+func TestDispatcher_ConfigurationIDPerDestination(t *testing.T) {
+	store := newTestStore(t)
+	store.CreateBucket("b")
+	store.PutNotificationConfig("b", metadata.BucketNotificationConfig{
+		Webhooks: []metadata.NotificationEndpointConfig{
+			{ID: "hook", Endpoint: "http://example.com/hook", Events: []string{"s3:*"}},
+		},
+		Targets: []metadata.NotificationTargetConfig{
+			{ID: "queue", Kind: "Queue", ARN: "arn:vaults3:sqs::main", Events: []string{"s3:ObjectTagging:*"}},
+		},
+	})
+	d := NewDispatcher(store, 1, 10, 1, 1)
+	d.AddTarget("arn:vaults3:sqs::main", &mockBackend{name: "main"})
+
+	d.Dispatch(Event{Name: EventObjectTaggingPut, Bucket: "b", Key: "k"})
+
+	events, err := store.DequeueNotifications(10, time.Now().UnixNano(), nil)
+	if err != nil {
+		t.Fatalf("DequeueNotifications: %v", err)
+	}
+	got := map[string]string{}
+	for _, e := range events {
+		var ev S3Event
+		if err := json.Unmarshal(e.Payload, &ev); err != nil {
+			t.Fatalf("unmarshal payload: %v", err)
+		}
+		got[e.Destination] = ev.Records[0].S3.ConfigurationID
+	}
+	if len(got) != 2 || got["http://example.com/hook"] != "hook" || got["arn:vaults3:sqs::main"] != "queue" {
+		t.Errorf("unexpected configuration IDs: %v", got)
+	}
+}
+
+func TestDispatcher_RetriesWithBackoff(t *testing.T) {
+	store := newTestStore(t)
+	store.PutNotificationConfig("b", metadata.BucketNotificationConfig{
+		Targets: []metadata.NotificationTargetConfig{
+			{ID: "t", Kind: "Queue", ARN: BackendARN("flaky"), Events: []string{"s3:*"}},
+		},
+	})
+
+	d := newOutboxDispatcher(store, 5)
+	b := &flakyBackend{failures: 2}
+	d.AddBackend(b)
+	ctx, cancel := context.WithCancel(context.Background())
+	d.Start(ctx)
+	defer func() { cancel(); d.Stop() }()
+
+	d.Dispatch(Event{Name: "s3:ObjectCreated:Put", Bucket: "b", Key: "k", Size: 1, ETag: "e"})
+	waitFor(t, "delivery", func() bool { _, n := b.counts(); return n == 1 })
+
+	if attempts, _ := b.counts(); attempts != 3 {
+		t.Errorf("attempts = %d, want 3", attempts)
+	}
+	waitFor(t, "outbox to drain", func() bool {
+		stats, _ := d.QueueStats()
+		return len(stats) == 0
+	})
+	if delivered, failed := d.DeliveryCounts(); delivered != 1 || failed != 2 {
+		t.Errorf("delivered, failed = %d, %d", delivered, failed)
+	}
+	if got := d.backoff(3); got != 4*time.Millisecond {
+		t.Errorf("backoff(3) = %v, want 4ms", got)
+	}
+}
+
+func TestDispatcher_DeadLetterAndReplay(t *testing.T) {
+	store := newTestStore(t)
+	store.PutNotificationConfig("b", metadata.BucketNotificationConfig{
+		Targets: []metadata.NotificationTargetConfig{
+			{ID: "t", Kind: "Queue", ARN: BackendARN("flaky"), Events: []string{"s3:*"}},
+		},
+	})
+
+	d := newOutboxDispatcher(store, 2)
+	b := &flakyBackend{failures: 2}
+	d.AddBackend(b)
+	ctx, cancel := context.WithCancel(context.Background())
+	d.Start(ctx)
+	defer func() { cancel(); d.Stop() }()
+
+	d.Dispatch(Event{Name: "s3:ObjectRemoved:Delete", Bucket: "b", Key: "k"})
+	var dead []metadata.NotificationEvent
+	waitFor(t, "dead letter", func() bool {
+		dead, _ = d.DeadLetters("", 10)
+		return len(dead) == 1
+	})
+	if dead[0].Attempts != 2 || dead[0].LastError != "broker unavailable" || dead[0].Destination != BackendARN("flaky") {
+		t.Fatalf("dead letter = %+v", dead[0])
+	}
+
+	n, err := d.ReplayDeadLetters([]uint64{dead[0].ID}, "")
+	if err != nil || n != 1 {
+		t.Fatalf("ReplayDeadLetters = %d, %v", n, err)
+	}
+	waitFor(t, "replayed delivery", func() bool { _, n := b.counts(); return n == 1 })
+	if dead, _ := d.DeadLetters("", 10); len(dead) != 0 {
+		t.Errorf("dead letters after replay = %d", len(dead))
+	}
+}
+
+func TestDispatcher_OutboxSurvivesRestart(t *testing.T) {
+	store := newTestStore(t)
+	store.PutNotificationConfig("b", metadata.BucketNotificationConfig{
+		Targets: []metadata.NotificationTargetConfig{
+			{ID: "t", Kind: "Queue", ARN: "arn:vaults3:sqs::main", Events: []string{"s3:*"}},
+		},
+	})
+
+	// Events dispatched while nothing delivers stay in the outbox
+	first := newOutboxDispatcher(store, 3)
+	first.AddTarget("arn:vaults3:sqs::main", &mockBackend{name: "kafka"})
+	first.Dispatch(Event{Name: "s3:ObjectCreated:Put", Bucket: "b", Key: "k1", Size: 1, ETag: "e"})
+	first.Dispatch(Event{Name: "s3:ObjectCreated:Put", Bucket: "b", Key: "k2", Size: 1, ETag: "e"})
+	stats, _ := first.QueueStats()
+	if len(stats) != 1 || stats[0].Pending != 2 || stats[0].Kind != KindTarget || stats[0].OldestPending == 0 {
+		t.Fatalf("stats before restart = %+v", stats)
+	}
+
+	target := &flakyBackend{}
+	second := newOutboxDispatcher(store, 3)
+	second.AddTarget("arn:vaults3:sqs::main", target)
+	ctx, cancel := context.WithCancel(context.Background())
+	second.Start(ctx)
+	defer func() { cancel(); second.Stop() }()
+
+	waitFor(t, "delivery after restart", func() bool { _, n := target.counts(); return n == 2 })
+}
+
 // --- matchEvent tests ---
 
 func TestMatchEvent_Exact(t *testing.T) {
@@ -325,7 +690,7 @@ func TestDispatcher_NoBucketConfig(t *testing.T) {
 	// Don't create bucket or notification config
 	d := NewDispatcher(store, 1, 10, 5, 3)
 	// Should not panic
-	d.Dispatch("nonexistent", "file.txt", "s3:ObjectCreated:Put", 100, "etag", "")
+	d.Dispatch(Event{Name: "s3:ObjectCreated:Put", Bucket: "nonexistent", Key: "file.txt", Size: 100, ETag: "etag"})
 }
 
 // Ensure temp dir doesn't leak
@@ -0,0 +1,155 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// NSQ frame types.
+const (
+	nsqFrameResponse = 0
+	nsqFrameError    = 1
+)
+
+// NSQBackend publishes notification events to an nsqd topic over the NSQ TCP
+// protocol. The connection is established lazily and re-established after
+// failures.
+type NSQBackend struct {
+	addr   string
+	topic  string
+	mu     sync.Mutex
+	conn   net.Conn
+	r      *bufio.Reader
+	closed bool
+}
+
+// NewNSQBackend creates an NSQ notification backend for the nsqd at addr
+// (host:4150).
+func NewNSQBackend(addr, topic string) *NSQBackend {
+	return &NSQBackend{addr: addr, topic: topic}
+}
+
+func (n *NSQBackend) Name() string {
+	return "nsq"
+}
+
+// Publish sends payload to the topic. A publish on a connection nsqd has
+// dropped is retried once on a new connection.
+func (n *NSQBackend) Publish(ctx context.Context, payload []byte) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.closed {
+		return fmt.Errorf("nsq backend closed")
+	}
+	reused := n.conn != nil
+	err := n.publish(ctx, payload)
+	if err != nil && reused && ctx.Err() == nil {
+		err = n.publish(ctx, payload)
+	}
+	return err
+}
+
+func (n *NSQBackend) publish(ctx context.Context, payload []byte) error {
+	if err := n.ensureConnection(ctx); err != nil {
+		return err
+	}
+	if err := n.writePub(ctx, payload); err != nil {
+		n.drop()
+		return err
+	}
+	return nil
+}
+
+func (n *NSQBackend) ensureConnection(ctx context.Context) error {
+	if n.conn != nil {
+		return nil
+	}
+	conn, err := (&net.Dialer{Timeout: 10 * time.Second}).DialContext(ctx, "tcp", n.addr)
+	if err != nil {
+		return fmt.Errorf("nsq dial: %w", err)
+	}
+	setDeadline(ctx, conn)
+	if _, err := conn.Write([]byte("  V2")); err != nil {
+		conn.Close()
+		return fmt.Errorf("nsq handshake: %w", err)
+	}
+	n.conn = conn
+	n.r = bufio.NewReader(conn)
+	return nil
+}
+
+func (n *NSQBackend) writePub(ctx context.Context, payload []byte) error {
+	setDeadline(ctx, n.conn)
+	cmd := make([]byte, 0, len(n.topic)+len(payload)+9)
+	cmd = append(cmd, "PUB "+n.topic+"\n"...)
+	cmd = binary.BigEndian.AppendUint32(cmd, uint32(len(payload)))
+	cmd = append(cmd, payload...)
+	if _, err := n.conn.Write(cmd); err != nil {
+		return fmt.Errorf("nsq publish: %w", err)
+	}
+	for {
+		typ, data, err := readNSQFrame(n.r)
+		if err != nil {
+			return fmt.Errorf("nsq response: %w", err)
+		}
+		switch {
+		case typ == nsqFrameError:
+			return fmt.Errorf("nsq: %s", data)
+		case typ == nsqFrameResponse && string(data) == "_heartbeat_":
+			if _, err := n.conn.Write([]byte("NOP\n")); err != nil {
+				return fmt.Errorf("nsq heartbeat: %w", err)
+			}
+		case typ == nsqFrameResponse:
+			if string(data) != "OK" {
+				return fmt.Errorf("nsq: unexpected response %q", data)
+			}
+			return nil
+		}
+	}
+}
+
+// drop closes the current connection so the next publish reconnects.
+func (n *NSQBackend) drop() {
+	if n.conn != nil {
+		n.conn.Close()
+		n.conn = nil
+		n.r = nil
+	}
+}
+
+func (n *NSQBackend) Close() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.closed = true
+	if n.conn != nil {
+		n.conn.SetWriteDeadline(time.Now().Add(time.Second))
+		n.conn.Write([]byte("CLS\n"))
+	}
+	n.drop()
+	return nil
+}
+
+// readNSQFrame reads one frame: a 4-byte size, a 4-byte frame type and the
+// frame data.
+func readNSQFrame(r *bufio.Reader) (int32, []byte, error) {
+	var hdr [8]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	size := binary.BigEndian.Uint32(hdr[:4])
+	if size < 4 || size > 16<<20 {
+		return 0, nil, fmt.Errorf("invalid frame size %d", size)
+	}
+	data := make([]byte, size-4)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return 0, nil, err
+	}
+	return int32(binary.BigEndian.Uint32(hdr[4:])), data, nil
+}
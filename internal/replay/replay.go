@@ -0,0 +1,432 @@
+// Package replay re-delivers recorded object events so that downstream
+// consumers can be rebuilt without re-uploading data. Every emitted event is
+// kept in the metadata store's event log; a replay job reads the events of a
+// bucket, prefix and time range back and sends them, rate limited, to one
+// notification target or lambda trigger.
+package replay
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eniz1806/VaultS3/internal/metadata"
+	"github.com/eniz1806/VaultS3/internal/notify"
+)
+
+// Job states.
+const (
+	StatusRunning   = "running"
+	StatusCompleted = "completed"
+	StatusCancelled = "cancelled"
+	StatusFailed    = "failed"
+)
+
+// ConfigurationID is reported as s3.configurationId of replayed events so
+// consumers can tell them from live ones.
+const ConfigurationID = "vaults3-replay"
+
+const (
+	defaultRate = 50
+	maxRate     = 10000
+	pageSize    = 500
+)
+
+// Recorded events are buffered and written to the event log every
+// flushInterval, or as soon as flushBatch of them are waiting.
+var (
+	flushInterval = time.Second
+	flushBatch    = 1000
+)
+
+// TargetFunc delivers an event to the notification target with the given ARN.
+type TargetFunc func(e notify.Event, arn, configurationID string) error
+
+// TriggerFunc invokes the lambda trigger of the event's bucket with the given ID.
+type TriggerFunc func(triggerID string, e notify.Event) error
+
+// Request selects the events to replay and where to send them. Exactly one
+// of Target and Trigger must be set.
+type Request struct {
+	Bucket  string    `json:"bucket"`
+	Prefix  string    `json:"prefix,omitempty"`
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`               // zero means now
+	Events  []string  `json:"events,omitempty"` // event name patterns, e.g. "s3:ObjectCreated:*"; empty means all
+	Target  string    `json:"target,omitempty"` // notification target ARN
+	Trigger string    `json:"trigger,omitempty"`
+	Rate    int       `json:"rate,omitempty"` // events per second, default 50
+	DryRun  bool      `json:"dry_run,omitempty"`
+}
+
+// Job reports the progress of a replay.
+type Job struct {
+	ID         string    `json:"id"`
+	Request    Request   `json:"request"`
+	Status     string    `json:"status"`
+	Total      int       `json:"total"`
+	Replayed   int       `json:"replayed"`
+	Failed     int       `json:"failed"`
+	LastError  string    `json:"last_error,omitempty"`
+	Position   time.Time `json:"position"` // time of the last event sent
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+type job struct {
+	Job
+	cancel context.CancelFunc
+}
+
+// Replayer records events and runs replay jobs. Jobs are kept in memory.
+// Recorded events are written to the event log in batches by Run, off the
+// request path.
+type Replayer struct {
+	store     *metadata.Store
+	onTarget  TargetFunc
+	onTrigger TriggerFunc
+
+	mu   sync.Mutex
+	jobs map[string]*job
+	wg   sync.WaitGroup
+
+	logMu   sync.Mutex
+	pending []metadata.EventLogEntry
+	wake    chan struct{}
+}
+
+// NewReplayer creates a replayer over the store's event log.
+func NewReplayer(store *metadata.Store) *Replayer {
+	return &Replayer{store: store, jobs: make(map[string]*job), wake: make(chan struct{}, 1)}
+}
+
+// SetTargetFunc enables replay to notification targets.
+func (r *Replayer) SetTargetFunc(fn TargetFunc) {
+	r.onTarget = fn
+}
+
+// SetTriggerFunc enables replay to lambda triggers.
+func (r *Replayer) SetTriggerFunc(fn TriggerFunc) {
+	r.onTrigger = fn
+}
+
+// Record queues an emitted event for the event log.
+func (r *Replayer) Record(e notify.Event) {
+	t := e.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	entry := metadata.EventLogEntry{
+		Time:        t.UnixNano(),
+		Name:        e.Name,
+		Bucket:      e.Bucket,
+		Key:         e.Key,
+		Size:        e.Size,
+		ETag:        e.ETag,
+		VersionID:   e.VersionID,
+		PrincipalID: e.PrincipalID,
+		SourceIP:    e.SourceIP,
+		RequestID:   e.RequestID,
+	}
+	r.logMu.Lock()
+	r.pending = append(r.pending, entry)
+	full := len(r.pending) >= flushBatch
+	r.logMu.Unlock()
+	if full {
+		select {
+		case r.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Flush writes the queued events to the event log in a single transaction.
+func (r *Replayer) Flush() {
+	r.logMu.Lock()
+	if len(r.pending) == 0 {
+		r.logMu.Unlock()
+		return
+	}
+	entries := r.pending
+	r.pending = nil
+	r.logMu.Unlock()
+
+	if err := r.store.AppendEventLog(entries...); err != nil {
+		slog.Error("replay error recording events", "count", len(entries), "error", err)
+	}
+}
+
+// Run starts the background flush loop of the event log. Cancel ctx to stop.
+func (r *Replayer) Run(ctx context.Context) {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.Flush()
+		case <-r.wake:
+			r.Flush()
+		case <-ctx.Done():
+			r.Flush()
+			return
+		}
+	}
+}
+
+func (r *Replayer) validate(req *Request) error {
+	if req.Bucket == "" {
+		return fmt.Errorf("bucket is required")
+	}
+	if req.From.IsZero() {
+		return fmt.Errorf("from is required")
+	}
+	if !req.To.IsZero() && !req.To.After(req.From) {
+		return fmt.Errorf("to must be after from")
+	}
+	if req.Rate < 0 || req.Rate > maxRate {
+		return fmt.Errorf("rate must be between 1 and %d events per second", maxRate)
+	}
+	if req.Rate == 0 {
+		req.Rate = defaultRate
+	}
+	if req.DryRun {
+		return nil
+	}
+	switch {
+	case (req.Target == "") == (req.Trigger == ""):
+		return fmt.Errorf("exactly one of target and trigger is required")
+	case req.Target != "" && r.onTarget == nil:
+		return fmt.Errorf("notification targets are not available")
+	case req.Trigger != "" && r.onTrigger == nil:
+		return fmt.Errorf("lambda triggers are not enabled")
+	}
+	return nil
+}
+
+// Count returns how many events req selects.
+func (r *Replayer) Count(req Request) (int, error) {
+	if err := r.validate(&req); err != nil {
+		return 0, err
+	}
+	// Include the events recorded so far
+	r.Flush()
+	n := 0
+	err := r.scan(req, 0, 0, func(metadata.EventLogEntry) bool {
+		n++
+		return true
+	})
+	return n, err
+}
+
+// scan calls fn for the selected events after position (from, seq).
+func (r *Replayer) scan(req Request, from int64, seq uint64, fn func(metadata.EventLogEntry) bool) error {
+	if from == 0 {
+		from = req.From.UnixNano()
+	}
+	var to int64
+	if !req.To.IsZero() {
+		to = req.To.UnixNano()
+	}
+	return r.store.ScanEventLog(req.Bucket, req.Prefix, from, to, seq, func(e metadata.EventLogEntry) bool {
+		if len(req.Events) > 0 && !matchEvent(req.Events, e.Name) {
+			return true
+		}
+		return fn(e)
+	})
+}
+
+// Start validates req and replays the selected events in the background.
+func (r *Replayer) Start(req Request) (*Job, error) {
+	if err := r.validate(&req); err != nil {
+		return nil, err
+	}
+	if req.DryRun {
+		return nil, fmt.Errorf("dry runs are counted with Count")
+	}
+	if req.To.IsZero() {
+		// Events emitted after the job starts are not part of it
+		req.To = time.Now()
+	}
+	total, err := r.Count(req)
+	if err != nil {
+		return nil, err
+	}
+	id, err := newJobID()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &job{
+		Job: Job{
+			ID:        id,
+			Request:   req,
+			Status:    StatusRunning,
+			Total:     total,
+			StartedAt: time.Now().UTC(),
+		},
+		cancel: cancel,
+	}
+	r.mu.Lock()
+	r.jobs[id] = j
+	snapshot := j.Job
+	r.mu.Unlock()
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		r.run(ctx, j)
+	}()
+	return &snapshot, nil
+}
+
+func (r *Replayer) run(ctx context.Context, j *job) {
+	req := j.Request
+	ticker := time.NewTicker(time.Second / time.Duration(req.Rate))
+	defer ticker.Stop()
+
+	var from int64
+	var seq uint64
+	for {
+		var page []metadata.EventLogEntry
+		err := r.scan(req, from, seq, func(e metadata.EventLogEntry) bool {
+			page = append(page, e)
+			return len(page) < pageSize
+		})
+		if err != nil {
+			r.finish(j, StatusFailed, err)
+			return
+		}
+		for _, e := range page {
+			select {
+			case <-ctx.Done():
+				r.finish(j, StatusCancelled, nil)
+				return
+			case <-ticker.C:
+			}
+			err := r.send(req, e)
+			r.mu.Lock()
+			if err != nil {
+				j.Failed++
+				j.LastError = err.Error()
+			} else {
+				j.Replayed++
+			}
+			j.Position = time.Unix(0, e.Time).UTC()
+			r.mu.Unlock()
+		}
+		if len(page) < pageSize {
+			r.finish(j, StatusCompleted, nil)
+			return
+		}
+		last := page[len(page)-1]
+		from, seq = last.Time, last.Seq
+	}
+}
+
+func (r *Replayer) send(req Request, entry metadata.EventLogEntry) error {
+	e := notify.Event{
+		Name:        entry.Name,
+		Bucket:      entry.Bucket,
+		Key:         entry.Key,
+		Size:        entry.Size,
+		ETag:        entry.ETag,
+		VersionID:   entry.VersionID,
+		Time:        time.Unix(0, entry.Time),
+		PrincipalID: entry.PrincipalID,
+		SourceIP:    entry.SourceIP,
+		RequestID:   entry.RequestID,
+	}
+	if req.Target != "" {
+		return r.onTarget(e, req.Target, ConfigurationID)
+	}
+	return r.onTrigger(req.Trigger, e)
+}
+
+func (r *Replayer) finish(j *job, status string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	j.Status = status
+	j.FinishedAt = time.Now().UTC()
+	if err != nil {
+		j.LastError = err.Error()
+	}
+	slog.Info("event replay finished", "id", j.ID, "status", status, "replayed", j.Replayed, "failed", j.Failed)
+}
+
+// Get returns the job with the given ID.
+func (r *Replayer) Get(id string) (*Job, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	j, ok := r.jobs[id]
+	if !ok {
+		return nil, false
+	}
+	snapshot := j.Job
+	return &snapshot, true
+}
+
+// List returns all jobs, newest first.
+func (r *Replayer) List() []Job {
+	r.mu.Lock()
+	jobs := make([]Job, 0, len(r.jobs))
+	for _, j := range r.jobs {
+		jobs = append(jobs, j.Job)
+	}
+	r.mu.Unlock()
+	sort.Slice(jobs, func(a, b int) bool { return jobs[a].StartedAt.After(jobs[b].StartedAt) })
+	return jobs
+}
+
+// Cancel stops a running job.
+func (r *Replayer) Cancel(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	j, ok := r.jobs[id]
+	if !ok {
+		return fmt.Errorf("replay job %s not found", id)
+	}
+	if j.Status != StatusRunning {
+		return fmt.Errorf("replay job %s is %s", id, j.Status)
+	}
+	j.cancel()
+	return nil
+}
+
+// Stop cancels running jobs and waits for them to finish.
+func (r *Replayer) Stop() {
+	r.mu.Lock()
+	for _, j := range r.jobs {
+		j.cancel()
+	}
+	r.mu.Unlock()
+	r.wg.Wait()
+	r.Flush()
+}
+
+// matchEvent checks if an event name matches any of the configured patterns.
+func matchEvent(patterns []string, eventName string) bool {
+	for _, p := range patterns {
+		if p == eventName || p == "s3:*" || p == "*" {
+			return true
+		}
+		if strings.HasSuffix(p, "*") && strings.HasPrefix(eventName, strings.TrimSuffix(p, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate job id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,178 @@
+package replay
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eniz1806/VaultS3/internal/metadata"
+	"github.com/eniz1806/VaultS3/internal/notify"
+)
+
+func newTestReplayer(t *testing.T) *Replayer {
+	t.Helper()
+	store, err := metadata.NewStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	r := NewReplayer(store)
+	t.Cleanup(r.Stop)
+	return r
+}
+
+func waitForJob(t *testing.T, r *Replayer, id string) *Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		job, _ := r.Get(id)
+		if job.Status != StatusRunning {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("replay job %s did not finish", id)
+	return nil
+}
+
+func TestReplayer_ReplaysToTarget(t *testing.T) {
+	r := newTestReplayer(t)
+	start := time.Now().Add(-time.Minute)
+	for i := 0; i < 5; i++ {
+		r.Record(notify.Event{Name: notify.EventObjectCreatedPut, Bucket: "b", Key: fmt.Sprintf("data/%d", i), Size: 3, Time: start.Add(time.Duration(i) * time.Millisecond)})
+	}
+	r.Record(notify.Event{Name: notify.EventObjectRemovedDelete, Bucket: "b", Key: "data/0", Time: start.Add(10 * time.Millisecond)})
+	r.Record(notify.Event{Name: notify.EventObjectCreatedPut, Bucket: "b", Key: "other/0", Time: start})
+
+	var mu sync.Mutex
+	var got []string
+	r.SetTargetFunc(func(e notify.Event, arn, configurationID string) error {
+		if arn != "arn:vaults3:webhook::1:hook" || configurationID != ConfigurationID {
+			t.Errorf("arn = %q, configurationID = %q", arn, configurationID)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, e.Key)
+		if e.Key == "data/3" {
+			return fmt.Errorf("target down")
+		}
+		return nil
+	})
+
+	req := Request{
+		Bucket: "b",
+		Prefix: "data/",
+		From:   start.Add(-time.Second),
+		Events: []string{"s3:ObjectCreated:*"},
+		Target: "arn:vaults3:webhook::1:hook",
+		Rate:   1000,
+	}
+	dry := req
+	dry.DryRun = true
+	if n, err := r.Count(dry); err != nil || n != 5 {
+		t.Fatalf("Count = %d, %v; want 5", n, err)
+	}
+
+	job, err := r.Start(req)
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	job = waitForJob(t, r, job.ID)
+	if job.Status != StatusCompleted || job.Total != 5 || job.Replayed != 4 || job.Failed != 1 || job.LastError != "target down" {
+		t.Fatalf("job = %+v", job)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) != 5 || got[0] != "data/0" || got[4] != "data/4" {
+		t.Fatalf("replayed %v", got)
+	}
+}
+
+func TestReplayer_Cancel(t *testing.T) {
+	r := newTestReplayer(t)
+	start := time.Now().Add(-time.Minute)
+	for i := 0; i < 20; i++ {
+		r.Record(notify.Event{Name: notify.EventObjectCreatedPut, Bucket: "b", Key: fmt.Sprintf("k%d", i), Time: start})
+	}
+	r.SetTriggerFunc(func(string, notify.Event) error { return nil })
+
+	job, err := r.Start(Request{Bucket: "b", From: start.Add(-time.Second), Trigger: "thumbs", Rate: 1})
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if err := r.Cancel(job.ID); err != nil {
+		t.Fatalf("Cancel: %v", err)
+	}
+	job = waitForJob(t, r, job.ID)
+	if job.Status != StatusCancelled || job.Replayed == job.Total {
+		t.Fatalf("job = %+v", job)
+	}
+}
+
+func TestReplayer_Validate(t *testing.T) {
+	r := newTestReplayer(t)
+	from := time.Now().Add(-time.Hour)
+	for _, req := range []Request{
+		{From: from, Target: "arn"},
+		{Bucket: "b", Target: "arn"},
+		{Bucket: "b", From: from, To: from.Add(-time.Minute), Target: "arn"},
+		{Bucket: "b", From: from},
+		{Bucket: "b", From: from, Target: "arn", Trigger: "t"},
+		{Bucket: "b", From: from, Target: "arn"}, // no target func set
+		{Bucket: "b", From: from, Target: "arn", Rate: maxRate + 1},
+	} {
+		if _, err := r.Start(req); err == nil {
+			t.Errorf("Start(%+v) succeeded", req)
+		}
+	}
+}
+
+func TestReplayer_BatchesRecords(t *testing.T) {
+	r := newTestReplayer(t)
+	defer func(batch int) { flushBatch = batch }(flushBatch)
+	flushBatch = 3
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		r.Run(ctx)
+		close(done)
+	}()
+
+	stored := func() int {
+		n := 0
+		r.store.ScanEventLog("b", "", 0, 0, 0, func(metadata.EventLogEntry) bool {
+			n++
+			return true
+		})
+		return n
+	}
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		r.Record(notify.Event{Name: notify.EventObjectCreatedPut, Bucket: "b", Key: fmt.Sprintf("k%d", i), Time: start})
+	}
+	if n := stored(); n != 0 {
+		t.Fatalf("events written before a flush: %d", n)
+	}
+
+	// A full batch is written without waiting for the interval
+	r.Record(notify.Event{Name: notify.EventObjectCreatedPut, Bucket: "b", Key: "k2", Time: start})
+	deadline := time.Now().Add(time.Second)
+	for stored() != 3 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := stored(); n != 3 {
+		t.Fatalf("events written after a full batch: %d", n)
+	}
+
+	// Stopping flushes what is left
+	r.Record(notify.Event{Name: notify.EventObjectCreatedPut, Bucket: "b", Key: "k3", Time: start})
+	cancel()
+	<-done
+	if n := stored(); n != 4 {
+		t.Fatalf("events written after stop: %d", n)
+	}
+}
@@ -52,6 +52,10 @@ type RealtimeEvent struct {
 	ETag   string
 }
 
+// EventFunc is called when an object has been replicated to a peer or its
+// replication has finally failed.
+type EventFunc func(eventType, bucket, key string, size int64, etag, versionID string)
+
 // Worker handles async replication to peer VaultS3 instances.
 type Worker struct {
 	store      *metadata.Store
@@ -62,6 +66,7 @@ type Worker struct {
 	batchSize  int
 	client     *http.Client
 	eventCh    chan RealtimeEvent
+	onEvent    EventFunc
 }
 
 func NewWorker(store *metadata.Store, engine storage.Engine, cfg config.ReplicationConfig) *Worker {
@@ -90,6 +95,18 @@ func NewWorker(store *metadata.Store, engine storage.Engine, cfg config.Replicat
 	}
 }
 
+// SetEventFunc sets the callback for replication success and failure events.
+func (w *Worker) SetEventFunc(fn EventFunc) {
+	w.onEvent = fn
+}
+
+// emit reports the outcome of replicating event, if a callback is set.
+func (w *Worker) emit(eventType string, event metadata.ReplicationEvent) {
+	if w.onEvent != nil {
+		w.onEvent(eventType, event.Bucket, event.Key, event.Size, event.ETag, "")
+	}
+}
+
 // Notify sends a real-time replication event (non-blocking).
 func (w *Worker) Notify(event RealtimeEvent) {
 	select {
@@ -173,6 +190,7 @@ func (w *Worker) processQueue() {
 				slog.Warn("replication max retries exceeded, dead-lettering", "peer", peer.Name, "event_id", event.ID)
 				w.store.DeadLetterReplication(event.ID)
 				w.updateStatus(peer.Name, replicateErr.Error(), true)
+				w.emit("s3:Replication:OperationFailedReplication", event)
 			} else {
 				backoff := backoffDelay(event.RetryCount)
 				nextRetry := time.Now().Unix() + int64(backoff.Seconds())
@@ -181,6 +199,7 @@ func (w *Worker) processQueue() {
 		} else {
 			w.store.AckReplication(event.ID)
 			w.updateStatus(peer.Name, "", false)
+			w.emit("s3:Replication:OperationCompletedReplication", event)
 		}
 	}
 }
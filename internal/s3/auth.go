@@ -301,6 +301,18 @@ func (a *Authenticator) Authorize(identity *iam.Identity, action, resource strin
 	return fmt.Errorf("access denied: %s on %s", action, resource)
 }
 
+// AuthorizeWithContext is Authorize for actions whose policies may have
+// conditions; ctx holds the values of the condition keys of the request.
+func (a *Authenticator) AuthorizeWithContext(identity *iam.Identity, action, resource string, ctx map[string]string) error {
+	if identity.IsAdmin {
+		return nil
+	}
+	if iam.EvaluateWithContext(identity.Policies, action, resource, ctx) {
+		return nil
+	}
+	return fmt.Errorf("access denied: %s on %s", action, resource)
+}
+
 func parseAuthParams(s string) map[string]string {
 	params := make(map[string]string)
 	for _, part := range strings.Split(s, ", ") {
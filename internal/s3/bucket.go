@@ -14,6 +14,7 @@ import (
 
 	"github.com/eniz1806/VaultS3/internal/metadata"
 	"github.com/eniz1806/VaultS3/internal/storage"
+	"github.com/eniz1806/VaultS3/internal/webhook"
 )
 
 // validateEndpointURL prevents SSRF by blocking private/internal URLs.
@@ -48,8 +49,10 @@ func validateEndpointURL(rawURL string) error {
 }
 
 type BucketHandler struct {
-	store  *metadata.Store
-	engine storage.Engine
+	store          *metadata.Store
+	engine         storage.Engine
+	notifyTargets  map[string]bool // ARNs of named notification targets
+	lambdaCommands map[string]bool // names of commands process triggers may run
 }
 
 // ListBuckets responds to GET / with a list of all buckets.
@@ -258,16 +261,22 @@ func (h *BucketHandler) GetBucketQuota(w http.ResponseWriter, r *http.Request, b
 
 	currentSize, currentCount, _ := h.engine.BucketSize(bucket)
 
+	multipartSize := h.store.MultipartUsage(bucket)
+
 	resp := struct {
-		MaxSizeBytes int64 `json:"max_size_bytes"`
-		MaxObjects   int64 `json:"max_objects"`
-		CurrentSize  int64 `json:"current_size_bytes"`
-		CurrentCount int64 `json:"current_object_count"`
+		MaxSizeBytes  int64 `json:"max_size_bytes"`
+		MaxObjects    int64 `json:"max_objects"`
+		CurrentSize   int64 `json:"current_size_bytes"`
+		CurrentCount  int64 `json:"current_object_count"`
+		MultipartSize int64 `json:"multipart_size_bytes"`
+		QuotaUsed     int64 `json:"quota_used_bytes"`
 	}{
-		MaxSizeBytes: info.MaxSizeBytes,
-		MaxObjects:   info.MaxObjects,
-		CurrentSize:  currentSize,
-		CurrentCount: currentCount,
+		MaxSizeBytes:  info.MaxSizeBytes,
+		MaxObjects:    info.MaxObjects,
+		CurrentSize:   currentSize,
+		CurrentCount:  currentCount,
+		MultipartSize: multipartSize,
+		QuotaUsed:     currentSize + multipartSize,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -282,18 +291,7 @@ func (h *BucketHandler) PutBucketLifecycle(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	var req struct {
-		XMLName xml.Name `xml:"LifecycleConfiguration"`
-		Rules   []struct {
-			Expiration struct {
-				Days int `xml:"Days"`
-			} `xml:"Expiration"`
-			Filter struct {
-				Prefix string `xml:"Prefix"`
-			} `xml:"Filter"`
-			Status string `xml:"Status"`
-		} `xml:"Rule"`
-	}
+	var req xmlLifecycleConfiguration
 	if err := xml.NewDecoder(io.LimitReader(r.Body, 256*1024)).Decode(&req); err != nil {
 		writeS3Error(w, "MalformedXML", "Could not parse lifecycle XML", http.StatusBadRequest)
 		return
@@ -304,18 +302,30 @@ func (h *BucketHandler) PutBucketLifecycle(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// Store the first rule (simplified — one rule per bucket)
-	rule := req.Rules[0]
-	if rule.Expiration.Days <= 0 {
-		writeS3Error(w, "InvalidArgument", "Expiration days must be positive", http.StatusBadRequest)
+	if len(req.Rules) > 1000 {
+		writeS3Error(w, "InvalidArgument", "A lifecycle configuration can have at most 1000 rules", http.StatusBadRequest)
 		return
 	}
 
-	if err := h.store.PutLifecycleRule(bucket, metadata.LifecycleRule{
-		ExpirationDays: rule.Expiration.Days,
-		Prefix:         rule.Filter.Prefix,
-		Status:         rule.Status,
-	}); err != nil {
+	var cfg metadata.LifecycleConfig
+	ids := make(map[string]bool)
+	for _, xr := range req.Rules {
+		rule, code, msg := lifecycleRuleFromXML(xr)
+		if msg != "" {
+			writeS3Error(w, code, msg, http.StatusBadRequest)
+			return
+		}
+		if rule.ID != "" {
+			if ids[rule.ID] {
+				writeS3Error(w, "InvalidArgument", "Rule ID must be unique. Found same ID for more than one rule", http.StatusBadRequest)
+				return
+			}
+			ids[rule.ID] = true
+		}
+		cfg.Rules = append(cfg.Rules, rule)
+	}
+
+	if err := h.store.PutLifecycleConfig(bucket, cfg); err != nil {
 		slog.Error("internal error", "error", err)
 		writeS3Error(w, "InternalError", "An internal error occurred", http.StatusInternalServerError)
 		return
@@ -331,37 +341,18 @@ func (h *BucketHandler) GetBucketLifecycle(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	rule, err := h.store.GetLifecycleRule(bucket)
-	if err != nil {
+	cfg, err := h.store.GetLifecycleConfig(bucket)
+	if err != nil || cfg == nil {
 		writeS3Error(w, "NoSuchLifecycleConfiguration", "No lifecycle configuration", http.StatusNotFound)
 		return
 	}
 
-	type xmlExpiration struct {
-		Days int `xml:"Days"`
-	}
-	type xmlFilter struct {
-		Prefix string `xml:"Prefix,omitempty"`
-	}
-	type xmlRule struct {
-		Expiration xmlExpiration `xml:"Expiration"`
-		Filter     xmlFilter     `xml:"Filter"`
-		Status     string        `xml:"Status"`
-	}
-	type xmlLifecycleConfig struct {
-		XMLName xml.Name  `xml:"LifecycleConfiguration"`
-		Xmlns   string    `xml:"xmlns,attr"`
-		Rules   []xmlRule `xml:"Rule"`
+	resp := xmlLifecycleConfiguration{Xmlns: "http://s3.amazonaws.com/doc/2006-03-01/"}
+	for _, rule := range cfg.Rules {
+		resp.Rules = append(resp.Rules, lifecycleRuleToXML(rule))
 	}
 
-	writeXML(w, http.StatusOK, xmlLifecycleConfig{
-		Xmlns: "http://s3.amazonaws.com/doc/2006-03-01/",
-		Rules: []xmlRule{{
-			Expiration: xmlExpiration{Days: rule.ExpirationDays},
-			Filter:     xmlFilter{Prefix: rule.Prefix},
-			Status:     rule.Status,
-		}},
-	})
+	writeXML(w, http.StatusOK, resp)
 }
 
 // DeleteBucketLifecycle handles DELETE /{bucket}?lifecycle.
@@ -626,6 +617,10 @@ func (h *BucketHandler) DeleteBucketCORS(w http.ResponseWriter, r *http.Request,
 }
 
 // PutBucketNotification handles PUT /{bucket}?notification.
+//
+// Queue, Topic and CloudFunction configurations route events to the named
+// notification target whose ARN they give. For compatibility, a Topic that is
+// not an ARN is treated as a webhook URL.
 func (h *BucketHandler) PutBucketNotification(w http.ResponseWriter, r *http.Request, bucket string) {
 	if !h.store.BucketExists(bucket) {
 		writeS3Error(w, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
@@ -642,15 +637,19 @@ func (h *BucketHandler) PutBucketNotification(w http.ResponseWriter, r *http.Req
 	type xmlFilter struct {
 		S3Key xmlS3Key `xml:"S3Key"`
 	}
-	type xmlTopicConfig struct {
-		ID     string    `xml:"Id"`
-		Topic  string    `xml:"Topic"`
-		Events []string  `xml:"Event"`
-		Filter xmlFilter `xml:"Filter"`
+	type xmlTargetConfig struct {
+		ID            string    `xml:"Id"`
+		Queue         string    `xml:"Queue"`
+		Topic         string    `xml:"Topic"`
+		CloudFunction string    `xml:"CloudFunction"`
+		Events        []string  `xml:"Event"`
+		Filter        xmlFilter `xml:"Filter"`
 	}
 	type xmlNotificationConfig struct {
-		XMLName xml.Name         `xml:"NotificationConfiguration"`
-		Topics  []xmlTopicConfig `xml:"TopicConfiguration"`
+		XMLName   xml.Name          `xml:"NotificationConfiguration"`
+		Queues    []xmlTargetConfig `xml:"QueueConfiguration"`
+		Topics    []xmlTargetConfig `xml:"TopicConfiguration"`
+		Functions []xmlTargetConfig `xml:"CloudFunctionConfiguration"`
 	}
 
 	var req xmlNotificationConfig
@@ -659,30 +658,80 @@ func (h *BucketHandler) PutBucketNotification(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	// The XML configuration cannot carry webhook credentials, so keep the
+	// credentials of webhooks whose ID and endpoint are unchanged.
+	prevAuth := make(map[string]*metadata.WebhookAuth)
+	if prev, err := h.store.GetNotificationConfig(bucket); err == nil {
+		for _, wh := range prev.Webhooks {
+			prevAuth[wh.ID+"\x00"+wh.Endpoint] = wh.Auth
+		}
+	}
+
 	cfg := metadata.BucketNotificationConfig{}
-	for _, tc := range req.Topics {
-		if tc.Topic == "" {
-			writeS3Error(w, "InvalidArgument", "Topic (endpoint URL) is required", http.StatusBadRequest)
-			return
+	add := func(kind, dest string, tc xmlTargetConfig) bool {
+		if dest == "" {
+			writeS3Error(w, "InvalidArgument", kind+" is required", http.StatusBadRequest)
+			return false
 		}
-		if err := validateEndpointURL(tc.Topic); err != nil {
-			writeS3Error(w, "InvalidArgument", fmt.Sprintf("Invalid endpoint URL: %v", err), http.StatusBadRequest)
-			return
+		if len(tc.Events) == 0 {
+			writeS3Error(w, "InvalidArgument", "At least one Event is required", http.StatusBadRequest)
+			return false
 		}
 		var filters []metadata.NotificationFilterRule
 		for _, fr := range tc.Filter.S3Key.FilterRules {
-			filters = append(filters, metadata.NotificationFilterRule{Name: fr.Name, Value: fr.Value})
+			name := strings.ToLower(fr.Name)
+			if name != "prefix" && name != "suffix" {
+				writeS3Error(w, "InvalidArgument", "FilterRule name must be either prefix or suffix", http.StatusBadRequest)
+				return false
+			}
+			filters = append(filters, metadata.NotificationFilterRule{Name: name, Value: fr.Value})
 		}
 		id := tc.ID
 		if id == "" {
 			id = generateVersionID()[:8]
 		}
+
+		if h.notifyTargets[dest] {
+			cfg.Targets = append(cfg.Targets, metadata.NotificationTargetConfig{
+				ID:      id,
+				Kind:    kind,
+				ARN:     dest,
+				Events:  tc.Events,
+				Filters: filters,
+			})
+			return true
+		}
+		if kind != "Topic" || strings.HasPrefix(dest, "arn:") {
+			writeS3Error(w, "InvalidArgument", "Unable to validate the destination ARN: "+dest, http.StatusBadRequest)
+			return false
+		}
+		if err := validateEndpointURL(dest); err != nil {
+			writeS3Error(w, "InvalidArgument", fmt.Sprintf("Invalid endpoint URL: %v", err), http.StatusBadRequest)
+			return false
+		}
 		cfg.Webhooks = append(cfg.Webhooks, metadata.NotificationEndpointConfig{
 			ID:       id,
-			Endpoint: tc.Topic,
+			Endpoint: dest,
 			Events:   tc.Events,
 			Filters:  filters,
+			Auth:     prevAuth[id+"\x00"+dest],
 		})
+		return true
+	}
+	for _, tc := range req.Queues {
+		if !add("Queue", tc.Queue, tc) {
+			return
+		}
+	}
+	for _, tc := range req.Topics {
+		if !add("Topic", tc.Topic, tc) {
+			return
+		}
+	}
+	for _, tc := range req.Functions {
+		if !add("CloudFunction", tc.CloudFunction, tc) {
+			return
+		}
 	}
 
 	if err := h.store.PutNotificationConfig(bucket, cfg); err != nil {
@@ -722,36 +771,57 @@ func (h *BucketHandler) GetBucketNotification(w http.ResponseWriter, r *http.Req
 		XMLName xml.Name `xml:"Filter"`
 		S3Key   xmlS3Key `xml:"S3Key"`
 	}
-	type xmlTopicConfig struct {
-		XMLName xml.Name   `xml:"TopicConfiguration"`
-		ID      string     `xml:"Id"`
-		Topic   string     `xml:"Topic"`
-		Events  []string   `xml:"Event"`
-		Filter  *xmlFilter `xml:"Filter,omitempty"`
+	type xmlTargetConfig struct {
+		ID            string     `xml:"Id"`
+		Queue         string     `xml:"Queue,omitempty"`
+		Topic         string     `xml:"Topic,omitempty"`
+		CloudFunction string     `xml:"CloudFunction,omitempty"`
+		Events        []string   `xml:"Event"`
+		Filter        *xmlFilter `xml:"Filter,omitempty"`
 	}
 	type xmlNotificationConfig struct {
-		XMLName xml.Name         `xml:"NotificationConfiguration"`
-		Xmlns   string           `xml:"xmlns,attr"`
-		Topics  []xmlTopicConfig `xml:"TopicConfiguration"`
+		XMLName   xml.Name          `xml:"NotificationConfiguration"`
+		Xmlns     string            `xml:"xmlns,attr"`
+		Queues    []xmlTargetConfig `xml:"QueueConfiguration"`
+		Topics    []xmlTargetConfig `xml:"TopicConfiguration"`
+		Functions []xmlTargetConfig `xml:"CloudFunctionConfiguration"`
+	}
+
+	toFilter := func(rules []metadata.NotificationFilterRule) *xmlFilter {
+		if len(rules) == 0 {
+			return nil
+		}
+		filter := &xmlFilter{}
+		for _, f := range rules {
+			filter.S3Key.FilterRules = append(filter.S3Key.FilterRules, xmlFilterRule{Name: f.Name, Value: f.Value})
+		}
+		return filter
 	}
 
 	resp := xmlNotificationConfig{
 		Xmlns: "http://s3.amazonaws.com/doc/2006-03-01/",
 	}
 	for _, wh := range cfg.Webhooks {
-		tc := xmlTopicConfig{
+		resp.Topics = append(resp.Topics, xmlTargetConfig{
 			ID:     wh.ID,
 			Topic:  wh.Endpoint,
 			Events: wh.Events,
+			Filter: toFilter(wh.Filters),
+		})
+	}
+	for _, t := range cfg.Targets {
+		tc := xmlTargetConfig{ID: t.ID, Events: t.Events, Filter: toFilter(t.Filters)}
+		switch t.Kind {
+		case "Queue":
+			tc.Queue = t.ARN
+			resp.Queues = append(resp.Queues, tc)
+		case "CloudFunction":
+			tc.CloudFunction = t.ARN
+			resp.Functions = append(resp.Functions, tc)
+		default:
+			tc.Topic = t.ARN
+			resp.Topics = append(resp.Topics, tc)
 		}
-		if len(wh.Filters) > 0 {
-			filter := &xmlFilter{}
-			for _, f := range wh.Filters {
-				filter.S3Key.FilterRules = append(filter.S3Key.FilterRules, xmlFilterRule{Name: f.Name, Value: f.Value})
-			}
-			tc.Filter = filter
-		}
-		resp.Topics = append(resp.Topics, tc)
 	}
 
 	writeXML(w, http.StatusOK, resp)
@@ -1023,19 +1093,58 @@ func (h *BucketHandler) PutBucketLambda(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
-	for i, t := range cfg.Triggers {
-		if t.FunctionURL == "" {
-			writeS3Error(w, "InvalidArgument", "function_url is required", http.StatusBadRequest)
-			return
+	prevAuth := make(map[string]*metadata.WebhookAuth)
+	if prev, err := h.store.GetLambdaConfig(bucket); err == nil {
+		for _, t := range prev.Triggers {
+			prevAuth[t.ID] = t.Auth
 		}
-		if err := validateEndpointURL(t.FunctionURL); err != nil {
-			writeS3Error(w, "InvalidArgument", fmt.Sprintf("Invalid function URL: %v", err), http.StatusBadRequest)
+	}
+
+	for i, t := range cfg.Triggers {
+		switch t.Runtime {
+		case "", metadata.LambdaRuntimeHTTP:
+			if t.FunctionURL == "" {
+				writeS3Error(w, "InvalidArgument", "function_url is required", http.StatusBadRequest)
+				return
+			}
+			if err := validateEndpointURL(t.FunctionURL); err != nil {
+				writeS3Error(w, "InvalidArgument", fmt.Sprintf("Invalid function URL: %v", err), http.StatusBadRequest)
+				return
+			}
+		case metadata.LambdaRuntimeProcess:
+			if !h.lambdaCommands[t.Command] {
+				writeS3Error(w, "InvalidArgument", fmt.Sprintf("Command %q is not allow-listed", t.Command), http.StatusBadRequest)
+				return
+			}
+		default:
+			writeS3Error(w, "InvalidArgument", fmt.Sprintf("Unknown runtime %q", t.Runtime), http.StatusBadRequest)
 			return
 		}
 		if len(t.Events) == 0 {
 			writeS3Error(w, "InvalidArgument", "events is required", http.StatusBadRequest)
 			return
 		}
+		// Bearer tokens name server secrets, so only administrators may
+		// choose them; a ref set through the admin API may be kept as is.
+		auth := t.Auth
+		if auth != nil && auth.BearerTokenRef != "" {
+			if prev := prevAuth[t.ID]; prev == nil || prev.BearerTokenRef != auth.BearerTokenRef {
+				writeS3Error(w, "InvalidArgument", "bearer_token_ref can only be set through the admin API", http.StatusBadRequest)
+				return
+			}
+			kept := *auth
+			kept.BearerTokenRef = ""
+			auth = &kept
+		}
+		if err := webhook.Validate(auth, nil); err != nil {
+			writeS3Error(w, "InvalidArgument", fmt.Sprintf("Invalid auth: %v", err), http.StatusBadRequest)
+			return
+		}
+		if t.MaxRetries < 0 || t.RetryBackoffSecs < 0 || t.MaxConcurrency < 0 {
+			writeS3Error(w, "InvalidArgument", "max_retries, retry_backoff_secs and max_concurrency must not be negative", http.StatusBadRequest)
+			return
+		}
+		cfg.Triggers[i].Auth.KeepSecrets(prevAuth[t.ID])
 		if t.ID == "" {
 			cfg.Triggers[i].ID = generateVersionID()[:8]
 		}
@@ -1063,6 +1172,9 @@ func (h *BucketHandler) GetBucketLambda(w http.ResponseWriter, r *http.Request,
 		w.Write([]byte(`{"triggers":[]}`))
 		return
 	}
+	for i := range cfg.Triggers {
+		cfg.Triggers[i].Auth = cfg.Triggers[i].Auth.Redacted()
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(cfg)
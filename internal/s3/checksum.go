@@ -1,13 +1,18 @@
 package s3
 
 import (
+	"crypto/md5"
 	"crypto/sha1"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
 	"hash/crc32"
 	"io"
 	"net/http"
+	"strings"
 
 	"github.com/eniz1806/VaultS3/internal/metadata"
 )
@@ -116,3 +121,148 @@ func computeChecksums(r io.Reader) (sha256sum, crc32sum, crc32csum, sha1sum stri
 
 	return
 }
+
+// checksumAlgorithms are the x-amz-checksum-algorithm values supported.
+var checksumAlgorithms = []string{"CRC32", "CRC32C", "SHA1", "SHA256"}
+
+// checksumHeader returns the header carrying a checksum of the algorithm.
+func checksumHeader(algo string) string {
+	switch algo {
+	case "CRC32":
+		return "X-Amz-Checksum-Crc32"
+	case "CRC32C":
+		return "X-Amz-Checksum-Crc32c"
+	case "SHA1":
+		return "X-Amz-Checksum-Sha1"
+	case "SHA256":
+		return "X-Amz-Checksum-Sha256"
+	}
+	return ""
+}
+
+func newChecksumHash(algo string) hash.Hash {
+	switch algo {
+	case "CRC32":
+		return crc32.NewIEEE()
+	case "CRC32C":
+		return crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	case "SHA1":
+		return sha1.New()
+	case "SHA256":
+		return sha256.New()
+	}
+	return nil
+}
+
+// parseChecksumAlgorithm normalises an x-amz-checksum-algorithm value. It
+// reports false for algorithms that are not supported.
+func parseChecksumAlgorithm(v string) (string, bool) {
+	if v == "" {
+		return "", true
+	}
+	algo := strings.ToUpper(v)
+	return algo, newChecksumHash(algo) != nil
+}
+
+// partChecksum returns the checksum of a part for the algorithm.
+func partChecksum(p metadata.PartInfo, algo string) string {
+	switch algo {
+	case "CRC32":
+		return p.ChecksumCRC32
+	case "CRC32C":
+		return p.ChecksumCRC32C
+	case "SHA1":
+		return p.ChecksumSHA1
+	case "SHA256":
+		return p.ChecksumSHA256
+	}
+	return ""
+}
+
+func setPartChecksum(p *metadata.PartInfo, algo, sum string) {
+	switch algo {
+	case "CRC32":
+		p.ChecksumCRC32 = sum
+	case "CRC32C":
+		p.ChecksumCRC32C = sum
+	case "SHA1":
+		p.ChecksumSHA1 = sum
+	case "SHA256":
+		p.ChecksumSHA256 = sum
+	}
+}
+
+// setPartChecksumHeaders echoes the checksums of an uploaded part.
+func setPartChecksumHeaders(w http.ResponseWriter, p metadata.PartInfo) {
+	for _, algo := range checksumAlgorithms {
+		if sum := partChecksum(p, algo); sum != "" {
+			w.Header().Set(checksumHeader(algo), sum)
+		}
+	}
+}
+
+// partChecksums hashes a part while it streams to disk: MD5 for its ETag,
+// plus every checksum the client sent or the upload's algorithm requires.
+type partChecksums struct {
+	md5    hash.Hash
+	hashes map[string]hash.Hash
+}
+
+func newPartChecksums(r *http.Request, uploadAlgo string) *partChecksums {
+	pc := &partChecksums{md5: md5.New(), hashes: make(map[string]hash.Hash)}
+	trailer := strings.ToLower(r.Header.Get("X-Amz-Trailer"))
+	for _, algo := range checksumAlgorithms {
+		header := checksumHeader(algo)
+		if algo == uploadAlgo || r.Header.Get(header) != "" || trailer == strings.ToLower(header) {
+			pc.hashes[algo] = newChecksumHash(algo)
+		}
+	}
+	return pc
+}
+
+func (pc *partChecksums) Write(p []byte) (int, error) {
+	pc.md5.Write(p)
+	for _, h := range pc.hashes {
+		h.Write(p)
+	}
+	return len(p), nil
+}
+
+// verify checks the computed sums against the request's Content-MD5 and
+// x-amz-checksum-* headers and records them on part. On a mismatch it writes
+// the S3 error and returns false.
+func (pc *partChecksums) verify(w http.ResponseWriter, r *http.Request, part *metadata.PartInfo) bool {
+	sum := pc.md5.Sum(nil)
+	if checkContentMD5(w, r.Header.Get("Content-MD5"), sum) {
+		return false
+	}
+	part.ETag = fmt.Sprintf("\"%s\"", hex.EncodeToString(sum))
+	for _, algo := range checksumAlgorithms {
+		h, ok := pc.hashes[algo]
+		if !ok {
+			continue
+		}
+		computed := base64.StdEncoding.EncodeToString(h.Sum(nil))
+		if v := r.Header.Get(checksumHeader(algo)); v != "" && v != computed {
+			writeS3Error(w, "BadDigest", errChecksumMismatch(algo).Error(), http.StatusBadRequest)
+			return false
+		}
+		setPartChecksum(part, algo, computed)
+	}
+	return true
+}
+
+// compositeChecksum computes the checksum of a multipart object as S3 does:
+// the algorithm applied to the concatenated raw part checksums, suffixed with
+// the part count. It returns "" if a part has no checksum of the algorithm.
+func compositeChecksum(algo string, parts []metadata.PartInfo) string {
+	h := newChecksumHash(algo)
+	for _, p := range parts {
+		raw, err := base64.StdEncoding.DecodeString(partChecksum(p, algo))
+		if err != nil || len(raw) == 0 {
+			return ""
+		}
+		h.Write(raw)
+	}
+	return fmt.Sprintf("%s-%d", base64.StdEncoding.EncodeToString(h.Sum(nil)), len(parts))
+}
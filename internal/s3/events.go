@@ -0,0 +1,83 @@
+package s3
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/eniz1806/VaultS3/internal/metadata"
+	"github.com/eniz1806/VaultS3/internal/notify"
+)
+
+// requester identifies who made an S3 request, for event records.
+type requester struct {
+	principalID string
+	sourceIP    string
+	requestID   string
+}
+
+type requesterKey struct{}
+
+func withRequester(r *http.Request, req requester) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), requesterKey{}, req))
+}
+
+// emitEvent reports an S3 event for request r to the notification and lambda
+// callbacks.
+func (h *ObjectHandler) emitEvent(r *http.Request, name, bucket, key string, size int64, etag, versionID string) {
+	h.dispatchEvent(r, notify.Event{
+		Name:      name,
+		Bucket:    bucket,
+		Key:       key,
+		Size:      size,
+		ETag:      etag,
+		VersionID: versionID,
+	})
+}
+
+// putObjectMeta writes meta and, in the same transaction, the outbox events
+// of the S3 event name about it, so a crash cannot commit the change without
+// its notifications. It returns the event for dispatchEvent.
+func (h *ObjectHandler) putObjectMeta(r *http.Request, name string, meta metadata.ObjectMeta, versionID string) (notify.Event, error) {
+	e := notify.Event{Name: name, Bucket: meta.Bucket, Key: meta.Key, Size: meta.Size, ETag: meta.ETag, VersionID: versionID}
+	return e, h.store.PutObjectMetaWithNotifications(meta, h.planEvent(r, &e))
+}
+
+// deleteObjectMeta is putObjectMeta for deleting the metadata of an object.
+func (h *ObjectHandler) deleteObjectMeta(r *http.Request, name, bucket, key string) (notify.Event, error) {
+	e := notify.Event{Name: name, Bucket: bucket, Key: key}
+	return e, h.store.DeleteObjectMetaWithNotifications(bucket, key, h.planEvent(r, &e))
+}
+
+// planEvent returns the outbox events of e, made by the requester of r, and
+// marks it Queued.
+func (h *ObjectHandler) planEvent(r *http.Request, e *notify.Event) []metadata.NotificationEvent {
+	if h.onPlan == nil {
+		return nil
+	}
+	setRequester(r, e)
+	e.Queued = true
+	return h.onPlan(*e)
+}
+
+func setRequester(r *http.Request, e *notify.Event) {
+	if req, ok := r.Context().Value(requesterKey{}).(requester); ok {
+		e.PrincipalID = req.principalID
+		e.SourceIP = req.sourceIP
+		e.RequestID = req.requestID
+	}
+}
+
+// dispatchEvent reports e, made by the requester of r, to the notification
+// and lambda callbacks.
+func (h *ObjectHandler) dispatchEvent(r *http.Request, e notify.Event) {
+	if h.onNotification == nil && h.onLambda == nil {
+		return
+	}
+	setRequester(r, &e)
+	if h.onNotification != nil {
+		h.onNotification(e)
+	}
+	if h.onLambda != nil {
+		h.onLambda(e)
+	}
+}
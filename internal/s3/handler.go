@@ -9,7 +9,9 @@ import (
 
 	"github.com/eniz1806/VaultS3/internal/metadata"
 	"github.com/eniz1806/VaultS3/internal/metrics"
+	"github.com/eniz1806/VaultS3/internal/notify"
 	"github.com/eniz1806/VaultS3/internal/ratelimit"
+	"github.com/eniz1806/VaultS3/internal/search"
 	"github.com/eniz1806/VaultS3/internal/storage"
 )
 
@@ -20,7 +22,11 @@ type ActivityFunc func(method, bucket, key string, status int, size int64, clien
 type AuditFunc func(principal, userID, action, resource, effect, sourceIP string, statusCode int)
 
 // NotificationFunc is called after object mutations to trigger event notifications.
-type NotificationFunc func(eventType, bucket, key string, size int64, etag, versionID string)
+type NotificationFunc func(e notify.Event)
+
+// NotificationPlanFunc returns the outbox events of an S3 event, which the
+// metadata store persists in the transaction of the change that caused it.
+type NotificationPlanFunc func(e notify.Event) []metadata.NotificationEvent
 
 // ReplicationFunc is called after object mutations to enqueue replication events.
 type ReplicationFunc func(eventType, bucket, key string, size int64, etag, versionID string)
@@ -28,11 +34,17 @@ type ReplicationFunc func(eventType, bucket, key string, size int64, etag, versi
 // ScanFunc is called after object uploads to trigger virus scanning.
 type ScanFunc func(bucket, key string, size int64)
 
-// SearchUpdateFunc is called after object mutations to update the search index.
-type SearchUpdateFunc func(eventType, bucket, key string)
-
 // LambdaFunc is called after object mutations to trigger lambda functions.
-type LambdaFunc func(eventType, bucket, key string, size int64, etag, versionID string)
+type LambdaFunc func(e notify.Event)
+
+// RestoreFunc queues a restore of an archived object version; an empty
+// versionID means the current version. It reports whether a new restore was
+// queued; false means an existing restored copy was extended.
+type RestoreFunc func(bucket, key, versionID, tier string, days int) (queued bool, err error)
+
+// RemoveFunc deletes the data of an object version that was moved out of the
+// hot tier, along with any restored copy and pending restore request.
+type RemoveFunc func(meta metadata.ObjectMeta) error
 
 // ClusterProxyFunc checks if a request should be forwarded to another node.
 // Returns true if the request was proxied (caller should return immediately).
@@ -53,12 +65,12 @@ type Handler struct {
 	onNotification      NotificationFunc
 	onReplication       ReplicationFunc
 	onScan              ScanFunc
-	onSearchUpdate      SearchUpdateFunc
 	onLambda            LambdaFunc
 	rateLimiter         *ratelimit.Limiter
 	accessUpdater       *metadata.AccessUpdater
 	replicationPeerKeys map[string]bool
 	clusterProxy        ClusterProxyFunc
+	searchIndex         *search.Index
 }
 
 func NewHandler(store *metadata.Store, engine storage.Engine, auth *Authenticator, encryptionEnabled bool, domain string, mc *metrics.Collector) *Handler {
@@ -71,7 +83,7 @@ func NewHandler(store *metadata.Store, engine storage.Engine, auth *Authenticato
 		metrics:           mc,
 	}
 	h.buckets = &BucketHandler{store: store, engine: engine}
-	h.objects = &ObjectHandler{store: store, engine: engine, encryptionEnabled: encryptionEnabled}
+	h.objects = &ObjectHandler{store: store, engine: engine, encryptionEnabled: encryptionEnabled, quota: &quotaState{}}
 	return h
 }
 
@@ -83,6 +95,7 @@ func (h *Handler) SetActivityFunc(fn ActivityFunc) {
 // SetAuditFunc sets the callback for recording audit trail entries.
 func (h *Handler) SetAuditFunc(fn AuditFunc) {
 	h.onAudit = fn
+	h.objects.onAudit = fn
 }
 
 // SetNotificationFunc sets the callback for S3 event notifications.
@@ -91,6 +104,31 @@ func (h *Handler) SetNotificationFunc(fn NotificationFunc) {
 	h.objects.onNotification = fn
 }
 
+// SetNotificationPlanFunc sets the callback that plans the outbox events of
+// object writes. Events it planned reach the notification callback with
+// Queued set.
+func (h *Handler) SetNotificationPlanFunc(fn NotificationPlanFunc) {
+	h.objects.onPlan = fn
+}
+
+// SetNotificationTargets sets the ARNs of the named notification targets
+// that bucket notification configurations may reference.
+func (h *Handler) SetNotificationTargets(arns []string) {
+	h.buckets.notifyTargets = make(map[string]bool)
+	for _, arn := range arns {
+		h.buckets.notifyTargets[arn] = true
+	}
+}
+
+// SetLambdaCommands sets the names of the allow-listed commands that lambda
+// triggers with the process runtime may run.
+func (h *Handler) SetLambdaCommands(names []string) {
+	h.buckets.lambdaCommands = make(map[string]bool)
+	for _, name := range names {
+		h.buckets.lambdaCommands[name] = true
+	}
+}
+
 // SetReplicationFunc sets the callback for replication event enqueueing.
 func (h *Handler) SetReplicationFunc(fn ReplicationFunc) {
 	h.onReplication = fn
@@ -108,18 +146,22 @@ func (h *Handler) SetRateLimiter(rl *ratelimit.Limiter) {
 	h.rateLimiter = rl
 }
 
-// SetSearchUpdateFunc sets the callback for search index updates.
-func (h *Handler) SetSearchUpdateFunc(fn SearchUpdateFunc) {
-	h.onSearchUpdate = fn
-	h.objects.onSearchUpdate = fn
-}
-
 // SetLambdaFunc sets the callback for lambda function triggers.
 func (h *Handler) SetLambdaFunc(fn LambdaFunc) {
 	h.onLambda = fn
 	h.objects.onLambda = fn
 }
 
+// SetRestoreFunc sets the callback for archive restore requests.
+func (h *Handler) SetRestoreFunc(fn RestoreFunc) {
+	h.objects.onRestore = fn
+}
+
+// SetRemoveFunc sets the callback that deletes the data of archived objects.
+func (h *Handler) SetRemoveFunc(fn RemoveFunc) {
+	h.objects.onRemove = fn
+}
+
 // SetReplicationPeerKeys sets the access keys of configured replication peers.
 func (h *Handler) SetReplicationPeerKeys(keys []string) {
 	h.replicationPeerKeys = make(map[string]bool)
@@ -141,6 +183,11 @@ func (h *Handler) SetClusterProxy(fn ClusterProxyFunc) {
 	h.clusterProxy = fn
 }
 
+// SetSearchIndex sets the search index queried by GET /{bucket}?search.
+func (h *Handler) SetSearchIndex(idx *search.Index) {
+	h.searchIndex = idx
+}
+
 // SetAccessUpdater sets the batched access updater.
 func (h *Handler) SetAccessUpdater(u *metadata.AccessUpdater) {
 	h.accessUpdater = u
@@ -230,6 +277,10 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		if h.store.IsBucketWebsite(bucket) {
 			authRequired = false
 		}
+		if _, ok := r.URL.Query()["search"]; ok {
+			// Search reads metadata and content of every object
+			authRequired = true
+		}
 	}
 
 	// Extract client IP — use RemoteAddr for rate limiting (tamper-proof),
@@ -254,6 +305,7 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Authenticate and authorize
+	principalID := "anonymous"
 	if authRequired {
 		identity, err := h.auth.Authenticate(r)
 		if err != nil {
@@ -283,7 +335,7 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		if !identity.IsAdmin {
 			action := mapMethodToAction(r.Method, bucket, key, r.URL.Query())
 			resource := formatResource(bucket, key)
-			if err := h.auth.Authorize(identity, action, resource); err != nil {
+			if err := h.authorize(identity, action, resource, r, rateLimitIP); err != nil {
 				if h.onAudit != nil {
 					h.onAudit(identity.AccessKey, identity.UserID, action, resource, "Deny", clientIP, http.StatusForbidden)
 				}
@@ -299,8 +351,17 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			resource := formatResource(bucket, key)
 			h.onAudit(identity.AccessKey, identity.UserID, action, resource, "Allow", clientIP, 0)
 		}
+		principalID = identity.AccessKey
 	}
 
+	// Record who made the request for event records, echoing the request ID
+	// as x-amz-request-id like S3 does
+	requestID := w.Header().Get("X-Request-Id")
+	if requestID != "" {
+		w.Header().Set("X-Amz-Request-Id", requestID)
+	}
+	r = withRequester(r, requester{principalID: principalID, sourceIP: clientIP, requestID: requestID})
+
 	// Replication loop prevention: use a per-request ObjectHandler copy with
 	// notification/replication/lambda callbacks disabled for replication peers.
 	// This avoids mutating shared state from concurrent goroutines.
@@ -309,6 +370,7 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		if identity != nil && h.isReplicationPeer(identity.AccessKey) {
 			replicaObjects := *h.objects
 			replicaObjects.onNotification = nil
+			replicaObjects.onPlan = nil
 			replicaObjects.onReplication = nil
 			replicaObjects.onLambda = nil
 			h = &Handler{
@@ -325,11 +387,11 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				onNotification:      h.onNotification,
 				onReplication:       h.onReplication,
 				onScan:              h.onScan,
-				onSearchUpdate:      h.onSearchUpdate,
 				onLambda:            h.onLambda,
 				rateLimiter:         h.rateLimiter,
 				accessUpdater:       h.accessUpdater,
 				replicationPeerKeys: h.replicationPeerKeys,
+				searchIndex:         h.searchIndex,
 			}
 		}
 	}
@@ -448,6 +510,24 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		// Intelligent-Tiering configurations
+		if _, ok := bq["intelligent-tiering"]; ok {
+			_, hasID := bq["id"]
+			switch {
+			case r.Method == http.MethodPut:
+				h.buckets.PutBucketIntelligentTiering(w, r, bucket)
+			case r.Method == http.MethodGet && hasID:
+				h.buckets.GetBucketIntelligentTiering(w, r, bucket)
+			case r.Method == http.MethodGet:
+				h.buckets.ListBucketIntelligentTiering(w, r, bucket)
+			case r.Method == http.MethodDelete:
+				h.buckets.DeleteBucketIntelligentTiering(w, r, bucket)
+			default:
+				writeS3Error(w, "MethodNotAllowed", "Method not allowed", http.StatusMethodNotAllowed)
+			}
+			return
+		}
+
 		// Versioning operations
 		if _, ok := bq["versioning"]; ok {
 			switch r.Method {
@@ -590,6 +670,16 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		// Search extension
+		if _, ok := bq["search"]; ok {
+			if r.Method == http.MethodGet {
+				h.SearchBucket(w, r, bucket)
+			} else {
+				writeS3Error(w, "MethodNotAllowed", "Method not allowed", http.StatusMethodNotAllowed)
+			}
+			return
+		}
+
 		// Quota operations
 		if _, ok := bq["quota"]; ok {
 			switch r.Method {
@@ -820,6 +910,9 @@ func mapMethodToAction(method, bucket, key string, query map[string][]string) st
 
 	if bucket != "" && key == "" {
 		// Bucket-level operations
+		if _, ok := query["search"]; ok && method == http.MethodGet {
+			return actionSearchBucket
+		}
 		if _, ok := query["policy"]; ok {
 			if method == http.MethodPut {
 				return "s3:PutBucketPolicy"
@@ -129,6 +129,7 @@ func TestMapMethodToAction(t *testing.T) {
 		{http.MethodGet, "", "", nil, "s3:ListAllMyBuckets"},
 		{http.MethodPut, "b", "", map[string][]string{"policy": {""}}, "s3:PutBucketPolicy"},
 		{http.MethodGet, "b", "", map[string][]string{"policy": {""}}, "s3:GetBucketPolicy"},
+		{http.MethodGet, "b", "", map[string][]string{"search": {""}}, "s3:SearchBucket"},
 	}
 	for _, tt := range tests {
 		got := mapMethodToAction(tt.method, tt.bucket, tt.key, tt.query)
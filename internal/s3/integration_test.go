@@ -2,20 +2,29 @@ package s3
 
 import (
 	"bytes"
+	"crypto/md5"
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
 	"encoding/xml"
 	"fmt"
 	"io"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/eniz1806/VaultS3/internal/metadata"
+	"github.com/eniz1806/VaultS3/internal/notify"
+	"github.com/eniz1806/VaultS3/internal/search"
 	"github.com/eniz1806/VaultS3/internal/storage"
+	"github.com/eniz1806/VaultS3/internal/tiering"
 )
 
 const (
@@ -26,7 +35,8 @@ const (
 
 // newIntegrationServer creates a real Handler with filesystem storage and BoltDB
 // metadata, wrapped in an httptest.Server. Returns the server and a cleanup func.
-func newIntegrationServer(t *testing.T) *httptest.Server {
+// Options configure the handler before the server starts.
+func newIntegrationServer(t *testing.T, opts ...func(*Handler)) *httptest.Server {
 	t.Helper()
 	dir := t.TempDir()
 
@@ -43,6 +53,9 @@ func newIntegrationServer(t *testing.T) *httptest.Server {
 
 	auth := NewAuthenticator(testAccessKey, testSecretKey, store, nil, nil)
 	handler := NewHandler(store, engine, auth, false, "", nil)
+	for _, opt := range opts {
+		opt(handler)
+	}
 
 	ts := httptest.NewServer(handler)
 	t.Cleanup(ts.Close)
@@ -672,6 +685,475 @@ func TestIntegrationMultipartAbort(t *testing.T) {
 	}
 }
 
+func TestIntegrationMultipartQuota(t *testing.T) {
+	ts := newIntegrationServer(t)
+	bucket := "mp-quota-bucket"
+
+	resp := doSigned(t, http.MethodPut, ts.URL+"/"+bucket, nil)
+	resp.Body.Close()
+	resp = doSigned(t, http.MethodPut, ts.URL+"/"+bucket+"?quota", []byte(`{"max_size_bytes":2500}`))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("PutBucketQuota: expected 200, got %d", resp.StatusCode)
+	}
+
+	initiate := func(key string) string {
+		resp := doSigned(t, http.MethodPost, ts.URL+"/"+bucket+"/"+key+"?uploads", nil)
+		var initResult initiateResult
+		xml.NewDecoder(resp.Body).Decode(&initResult)
+		resp.Body.Close()
+		return initResult.UploadID
+	}
+	etags := make(map[int]string)
+	uploadPart := func(key, uploadID string, partNumber, size int) int {
+		resp := doSigned(t, http.MethodPut,
+			fmt.Sprintf("%s/%s/%s?uploadId=%s&partNumber=%d", ts.URL, bucket, key, uploadID, partNumber),
+			[]byte(strings.Repeat("x", size)))
+		resp.Body.Close()
+		if key == "first.bin" && resp.StatusCode == http.StatusOK {
+			etags[partNumber] = resp.Header.Get("ETag")
+		}
+		return resp.StatusCode
+	}
+	multipartSize := func() int64 {
+		resp := doSigned(t, http.MethodGet, ts.URL+"/"+bucket+"?quota", nil)
+		var q struct {
+			MultipartSize int64 `json:"multipart_size_bytes"`
+		}
+		json.NewDecoder(resp.Body).Decode(&q)
+		resp.Body.Close()
+		return q.MultipartSize
+	}
+
+	first := initiate("first.bin")
+	if code := uploadPart("first.bin", first, 1, 1000); code != http.StatusOK {
+		t.Fatalf("UploadPart 1: expected 200, got %d", code)
+	}
+	second := initiate("second.bin")
+	if code := uploadPart("second.bin", second, 1, 1000); code != http.StatusOK {
+		t.Fatalf("UploadPart on second upload: expected 200, got %d", code)
+	}
+	if got := multipartSize(); got != 2000 {
+		t.Fatalf("multipart_size_bytes = %d, want 2000", got)
+	}
+
+	// Parts of both uploads count towards the quota
+	if code := uploadPart("first.bin", first, 2, 1000); code != http.StatusForbidden {
+		t.Fatalf("UploadPart over quota: expected 403, got %d", code)
+	}
+	// So do single PUTs
+	resp = doSigned(t, http.MethodPut, ts.URL+"/"+bucket+"/single.txt", []byte(strings.Repeat("y", 600)))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("PutObject over quota: expected 403, got %d", resp.StatusCode)
+	}
+	// Replacing a part only needs the difference
+	if code := uploadPart("first.bin", first, 1, 1400); code != http.StatusOK {
+		t.Fatalf("UploadPart replacing part: expected 200, got %d", code)
+	}
+
+	// Aborting releases the upload's bytes
+	resp = doSigned(t, http.MethodDelete, fmt.Sprintf("%s/%s/second.bin?uploadId=%s", ts.URL, bucket, second), nil)
+	resp.Body.Close()
+	if got := multipartSize(); got != 1400 {
+		t.Fatalf("multipart_size_bytes after abort = %d, want 1400", got)
+	}
+	if code := uploadPart("first.bin", first, 2, 1000); code != http.StatusOK {
+		t.Fatalf("UploadPart after abort: expected 200, got %d", code)
+	}
+
+	// Completing moves the bytes from the upload into the object
+	resp = doSigned(t, http.MethodPost, fmt.Sprintf("%s/%s/first.bin?uploadId=%s", ts.URL, bucket, first),
+		[]byte(fmt.Sprintf(`<CompleteMultipartUpload><Part><PartNumber>1</PartNumber><ETag>%s</ETag></Part><Part><PartNumber>2</PartNumber><ETag>%s</ETag></Part></CompleteMultipartUpload>`, etags[1], etags[2])))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("CompleteMultipartUpload: expected 200, got %d", resp.StatusCode)
+	}
+	if got := multipartSize(); got != 0 {
+		t.Errorf("multipart_size_bytes after complete = %d, want 0", got)
+	}
+}
+
+func TestIntegrationMultipartChecksums(t *testing.T) {
+	ts := newIntegrationServer(t)
+	bucket := "mp-checksum-bucket"
+	key := "sum.bin"
+
+	resp := doSigned(t, http.MethodPut, ts.URL+"/"+bucket, nil)
+	resp.Body.Close()
+	resp = doSignedWithHeaders(t, http.MethodPost, ts.URL+"/"+bucket+"/"+key+"?uploads", nil,
+		map[string]string{"X-Amz-Checksum-Algorithm": "sha256"})
+	var initResult initiateResult
+	xml.NewDecoder(resp.Body).Decode(&initResult)
+	resp.Body.Close()
+	if got := resp.Header.Get("X-Amz-Checksum-Algorithm"); got != "SHA256" {
+		t.Fatalf("checksum algorithm = %q, want SHA256", got)
+	}
+	partURL := func(n int) string {
+		return fmt.Sprintf("%s/%s/%s?uploadId=%s&partNumber=%d", ts.URL, bucket, key, initResult.UploadID, n)
+	}
+	b64 := func(b []byte) string { return base64.StdEncoding.EncodeToString(b) }
+
+	// Mismatching Content-MD5 and checksum headers are rejected
+	bad := md5.Sum([]byte("other"))
+	resp = doSignedWithHeaders(t, http.MethodPut, partURL(1), []byte("part-one"), map[string]string{"Content-MD5": b64(bad[:])})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("UploadPart with bad Content-MD5: expected 400, got %d", resp.StatusCode)
+	}
+	resp = doSignedWithHeaders(t, http.MethodPut, partURL(1), []byte("part-one"), map[string]string{"X-Amz-Checksum-Crc32": "AAAAAA=="})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("UploadPart with bad CRC32: expected 400, got %d", resp.StatusCode)
+	}
+
+	var etags, sums []string
+	var raw []byte
+	for i := 1; i <= 3; i++ {
+		data := []byte(fmt.Sprintf("part-%d-%s", i, strings.Repeat("z", i*10)))
+		sum := sha256.Sum256(data)
+		digest := md5.Sum(data)
+		resp = doSignedWithHeaders(t, http.MethodPut, partURL(i), data, map[string]string{
+			"Content-MD5":           b64(digest[:]),
+			"X-Amz-Checksum-Sha256": b64(sum[:]),
+		})
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("UploadPart %d: expected 200, got %d", i, resp.StatusCode)
+		}
+		if got := resp.Header.Get("X-Amz-Checksum-Sha256"); got != b64(sum[:]) {
+			t.Errorf("part %d checksum header = %q", i, got)
+		}
+		etags = append(etags, resp.Header.Get("ETag"))
+		sums = append(sums, b64(sum[:]))
+		raw = append(raw, sum[:]...)
+	}
+
+	// ListParts pages with max-parts and part-number-marker
+	resp = doSigned(t, http.MethodGet, fmt.Sprintf("%s/%s/%s?uploadId=%s&max-parts=2", ts.URL, bucket, key, initResult.UploadID), nil)
+	var list struct {
+		IsTruncated          bool
+		NextPartNumberMarker int
+		Parts                []struct {
+			PartNumber     int
+			ChecksumSHA256 string
+		} `xml:"Part"`
+	}
+	xml.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	if !list.IsTruncated || list.NextPartNumberMarker != 2 || len(list.Parts) != 2 || list.Parts[1].ChecksumSHA256 != sums[1] {
+		t.Fatalf("first ListParts page = %+v", list)
+	}
+	resp = doSigned(t, http.MethodGet, fmt.Sprintf("%s/%s/%s?uploadId=%s&max-parts=2&part-number-marker=2", ts.URL, bucket, key, initResult.UploadID), nil)
+	list.Parts = nil
+	list.IsTruncated = true
+	xml.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	if list.IsTruncated || len(list.Parts) != 1 || list.Parts[0].PartNumber != 3 {
+		t.Fatalf("second ListParts page = %+v", list)
+	}
+
+	complete := func(parts string) *http.Response {
+		return doSigned(t, http.MethodPost, fmt.Sprintf("%s/%s/%s?uploadId=%s", ts.URL, bucket, key, initResult.UploadID),
+			[]byte("<CompleteMultipartUpload>"+parts+"</CompleteMultipartUpload>"))
+	}
+	part := func(n int, etag, sum string) string {
+		return fmt.Sprintf("<Part><PartNumber>%d</PartNumber><ETag>%s</ETag><ChecksumSHA256>%s</ChecksumSHA256></Part>", n, etag, sum)
+	}
+	for name, tc := range map[string]struct {
+		parts string
+		code  string
+	}{
+		"wrong etag":     {part(1, `"deadbeef"`, sums[0]), "InvalidPart"},
+		"wrong checksum": {part(1, etags[0], sums[1]), "InvalidPart"},
+		"out of order":   {part(2, etags[1], sums[1]) + part(1, etags[0], sums[0]), "InvalidPartOrder"},
+	} {
+		body := readBody(t, complete(tc.parts))
+		if !strings.Contains(body, tc.code) {
+			t.Errorf("%s: expected %s, got %s", name, tc.code, body)
+		}
+	}
+
+	resp = complete(part(1, etags[0], sums[0]) + part(2, etags[1], sums[1]) + part(3, etags[2], sums[2]))
+	body := readBody(t, resp)
+	composite := sha256.Sum256(raw)
+	want := b64(composite[:]) + "-3"
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "<ChecksumSHA256>"+want+"</ChecksumSHA256>") {
+		t.Fatalf("CompleteMultipartUpload: %d %s, want composite %s", resp.StatusCode, body, want)
+	}
+
+	// GetObjectAttributes reports the composite checksum and pages the parts
+	resp = doSignedWithHeaders(t, http.MethodGet, ts.URL+"/"+bucket+"/"+key+"?attributes", nil,
+		map[string]string{"X-Amz-Max-Parts": "1", "X-Amz-Part-Number-Marker": "1"})
+	var attrs struct {
+		Checksum struct {
+			ChecksumSHA256 string
+		}
+		ObjectParts struct {
+			TotalPartsCount      int
+			NextPartNumberMarker int
+			IsTruncated          bool
+			Parts                []struct {
+				PartNumber     int
+				Size           int64
+				ChecksumSHA256 string
+			} `xml:"Part"`
+		}
+	}
+	xml.NewDecoder(resp.Body).Decode(&attrs)
+	resp.Body.Close()
+	if attrs.Checksum.ChecksumSHA256 != want {
+		t.Errorf("object checksum = %q, want %q", attrs.Checksum.ChecksumSHA256, want)
+	}
+	op := attrs.ObjectParts
+	if op.TotalPartsCount != 3 || !op.IsTruncated || op.NextPartNumberMarker != 2 || len(op.Parts) != 1 ||
+		op.Parts[0].PartNumber != 2 || op.Parts[0].ChecksumSHA256 != sums[1] {
+		t.Errorf("ObjectParts = %+v", op)
+	}
+}
+
+func TestIntegrationListMultipartUploadsPaging(t *testing.T) {
+	ts := newIntegrationServer(t)
+	bucket := "mp-list-bucket"
+
+	resp := doSigned(t, http.MethodPut, ts.URL+"/"+bucket, nil)
+	resp.Body.Close()
+	ids := make(map[string]bool)
+	for _, key := range []string{"b", "a", "a", "c"} {
+		resp = doSigned(t, http.MethodPost, ts.URL+"/"+bucket+"/"+key+"?uploads", nil)
+		var initResult initiateResult
+		xml.NewDecoder(resp.Body).Decode(&initResult)
+		resp.Body.Close()
+		ids[initResult.UploadID] = true
+	}
+
+	type listResult struct {
+		IsTruncated        bool
+		NextKeyMarker      string
+		NextUploadIdMarker string
+		Uploads            []struct {
+			Key      string
+			UploadId string
+		} `xml:"Upload"`
+	}
+	var keys []string
+	query := "uploads&max-uploads=2"
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatal("listing did not terminate")
+		}
+		resp = doSigned(t, http.MethodGet, ts.URL+"/"+bucket+"?"+query, nil)
+		var page listResult
+		xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if len(page.Uploads) > 2 {
+			t.Fatalf("page has %d uploads, want at most 2", len(page.Uploads))
+		}
+		for _, u := range page.Uploads {
+			if !ids[u.UploadId] {
+				t.Fatalf("unexpected or repeated upload %s", u.UploadId)
+			}
+			delete(ids, u.UploadId)
+			keys = append(keys, u.Key)
+		}
+		if !page.IsTruncated {
+			break
+		}
+		query = fmt.Sprintf("uploads&max-uploads=2&key-marker=%s&upload-id-marker=%s", page.NextKeyMarker, page.NextUploadIdMarker)
+	}
+	if got := strings.Join(keys, ","); got != "a,a,b,c" || len(ids) != 0 {
+		t.Errorf("listed keys = %s, missing uploads = %d", got, len(ids))
+	}
+}
+
+// --- Notification Tests ---
+
+func TestIntegrationLambdaConfigRejectsTokenRef(t *testing.T) {
+	ts := newIntegrationServer(t)
+	bucket := "lambda-bucket"
+	resp := doSigned(t, http.MethodPut, ts.URL+"/"+bucket, nil)
+	resp.Body.Close()
+
+	body := `{"triggers":[{"id":"fn","function_url":"https://example.com/fn","events":["s3:ObjectCreated:*"],` +
+		`"auth":{"bearer_token_ref":"file:/etc/vaults3/secret"}}]}`
+	resp = doSigned(t, http.MethodPut, ts.URL+"/"+bucket+"?lambda", []byte(body))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("bearer_token_ref over S3: expected 400, got %d", resp.StatusCode)
+	}
+}
+
+func TestIntegrationBucketNotificationTargets(t *testing.T) {
+	ts := newIntegrationServer(t, func(h *Handler) {
+		h.SetNotificationTargets([]string{"arn:vaults3:sqs::kafka-main", "arn:vaults3:lambda::thumbs"})
+	})
+	bucket := "notify-bucket"
+	resp := doSigned(t, http.MethodPut, ts.URL+"/"+bucket, nil)
+	resp.Body.Close()
+
+	put := func(body string) *http.Response {
+		return doSigned(t, http.MethodPut, ts.URL+"/"+bucket+"?notification", []byte(body))
+	}
+
+	resp = put(`<NotificationConfiguration><QueueConfiguration><Id>q</Id><Queue>arn:vaults3:sqs::missing</Queue><Event>s3:ObjectCreated:*</Event></QueueConfiguration></NotificationConfiguration>`)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unknown ARN: expected 400, got %d", resp.StatusCode)
+	}
+
+	resp = put(`<NotificationConfiguration>
+		<QueueConfiguration><Id>q</Id><Queue>arn:vaults3:sqs::kafka-main</Queue><Event>s3:ObjectCreated:*</Event>
+			<Filter><S3Key><FilterRule><Name>Prefix</Name><Value>logs/</Value></FilterRule></S3Key></Filter></QueueConfiguration>
+		<CloudFunctionConfiguration><Id>f</Id><CloudFunction>arn:vaults3:lambda::thumbs</CloudFunction><Event>s3:ObjectRemoved:*</Event></CloudFunctionConfiguration>
+	</NotificationConfiguration>`)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("PutBucketNotification: expected 200, got %d", resp.StatusCode)
+	}
+
+	resp = doSigned(t, http.MethodGet, ts.URL+"/"+bucket+"?notification", nil)
+	var got struct {
+		Queues []struct {
+			ID    string `xml:"Id"`
+			Queue string
+			Rules []struct{ Name, Value string } `xml:"Filter>S3Key>FilterRule"`
+		} `xml:"QueueConfiguration"`
+		Functions []struct {
+			CloudFunction string
+			Events        []string `xml:"Event"`
+		} `xml:"CloudFunctionConfiguration"`
+	}
+	xml.NewDecoder(resp.Body).Decode(&got)
+	resp.Body.Close()
+	if len(got.Queues) != 1 || got.Queues[0].Queue != "arn:vaults3:sqs::kafka-main" ||
+		len(got.Queues[0].Rules) != 1 || got.Queues[0].Rules[0].Name != "prefix" {
+		t.Errorf("queue configurations = %+v", got.Queues)
+	}
+	if len(got.Functions) != 1 || got.Functions[0].CloudFunction != "arn:vaults3:lambda::thumbs" ||
+		len(got.Functions[0].Events) != 1 || got.Functions[0].Events[0] != "s3:ObjectRemoved:*" {
+		t.Errorf("cloud function configurations = %+v", got.Functions)
+	}
+}
+
+func TestIntegrationNotificationsCommittedWithObject(t *testing.T) {
+	var store *metadata.Store
+	var mu sync.Mutex
+	queued := 0
+	ts := newIntegrationServer(t, func(h *Handler) {
+		store = h.store
+		h.SetNotificationPlanFunc(func(e notify.Event) []metadata.NotificationEvent {
+			return []metadata.NotificationEvent{{Kind: "webhook", Destination: "http://hook", EventName: e.Name, Bucket: e.Bucket, Key: e.Key}}
+		})
+		h.SetNotificationFunc(func(e notify.Event) {
+			mu.Lock()
+			defer mu.Unlock()
+			if e.Queued {
+				queued++
+			}
+		})
+	})
+	bucket := "outbox-bucket"
+	resp := doSigned(t, http.MethodPut, ts.URL+"/"+bucket, nil)
+	resp.Body.Close()
+	resp = doSigned(t, http.MethodPut, ts.URL+"/"+bucket+"/a.txt", []byte("hello"))
+	resp.Body.Close()
+	resp = doSigned(t, http.MethodDelete, ts.URL+"/"+bucket+"/a.txt", nil)
+	resp.Body.Close()
+
+	events, err := store.DequeueNotifications(10, time.Now().UnixNano(), nil)
+	if err != nil || len(events) != 2 || events[0].EventName != notify.EventObjectCreatedPut ||
+		events[1].EventName != notify.EventObjectRemovedDelete {
+		t.Fatalf("outbox = %+v, %v", events, err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if queued != 2 {
+		t.Errorf("%d events reported as queued, want 2", queued)
+	}
+}
+
+func TestIntegrationEventRecords(t *testing.T) {
+	var mu sync.Mutex
+	var events []notify.Event
+	ts := newIntegrationServer(t, func(h *Handler) {
+		h.SetNotificationFunc(func(e notify.Event) {
+			mu.Lock()
+			events = append(events, e)
+			mu.Unlock()
+		})
+	})
+	bucket := "event-bucket"
+	key := "docs/a.txt"
+	resp := doSigned(t, http.MethodPut, ts.URL+"/"+bucket, nil)
+	resp.Body.Close()
+
+	objURL := ts.URL + "/" + bucket + "/docs/a.txt"
+	for _, req := range []struct {
+		method, url, body string
+	}{
+		{http.MethodPut, objURL, "hello"},
+		{http.MethodPut, objURL + "?tagging", `<Tagging><TagSet><Tag><Key>env</Key><Value>prod</Value></Tag></TagSet></Tagging>`},
+		{http.MethodDelete, objURL + "?tagging", ""},
+		{http.MethodPut, objURL + "?acl", ""},
+		{http.MethodPut, objURL + "?legal-hold", `<LegalHold><Status>ON</Status></LegalHold>`},
+		{http.MethodPut, objURL + "?legal-hold", `<LegalHold><Status>OFF</Status></LegalHold>`},
+		{http.MethodPut, ts.URL + "/" + bucket + "?versioning", `<VersioningConfiguration><Status>Enabled</Status></VersioningConfiguration>`},
+		{http.MethodDelete, objURL, ""},
+	} {
+		var body []byte
+		if req.body != "" {
+			body = []byte(req.body)
+		}
+		resp = doSigned(t, req.method, req.url, body)
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			t.Fatalf("%s %s: status %d", req.method, req.url, resp.StatusCode)
+		}
+	}
+
+	want := []string{
+		notify.EventObjectCreatedPut,
+		notify.EventObjectTaggingPut,
+		notify.EventObjectTaggingDelete,
+		notify.EventObjectAclPut,
+		notify.EventObjectLegalHoldPut,
+		notify.EventObjectLegalHoldPut,
+		notify.EventObjectRemovedDeleteMarkerCreated,
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mu.Lock()
+		n := len(events)
+		mu.Unlock()
+		if n >= len(want) || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) != len(want) {
+		t.Fatalf("expected %d events, got %d: %+v", len(want), len(events), events)
+	}
+	for i, e := range events {
+		if e.Name != want[i] {
+			t.Errorf("event %d: expected %s, got %s", i, want[i], e.Name)
+		}
+		if e.Bucket != bucket || e.Key != key {
+			t.Errorf("event %d: unexpected object %s/%s", i, e.Bucket, e.Key)
+		}
+		if e.PrincipalID != testAccessKey || e.SourceIP != "127.0.0.1" {
+			t.Errorf("event %d: unexpected requester %q from %q", i, e.PrincipalID, e.SourceIP)
+		}
+	}
+	if events[0].ETag == "" || events[1].ETag != events[0].ETag || events[1].Size != 5 {
+		t.Errorf("tagging event should describe the object, got %+v", events[1])
+	}
+	if events[6].VersionID == "" {
+		t.Error("delete marker event should carry the marker's version")
+	}
+}
+
 // --- Tagging Tests ---
 
 func TestIntegrationObjectTagging(t *testing.T) {
@@ -889,3 +1371,573 @@ func TestIntegrationInlineTagging(t *testing.T) {
 		t.Errorf("inline tags not saved: %s", body)
 	}
 }
+
+// --- Lifecycle Transitions ---
+
+func TestIntegrationLifecycleTransitions(t *testing.T) {
+	ts := newIntegrationServer(t)
+	bucket := "lc-bucket"
+
+	resp := doSigned(t, http.MethodPut, ts.URL+"/"+bucket, nil)
+	resp.Body.Close()
+
+	lifecycleXML := `<LifecycleConfiguration>
+<Rule><ID>archive</ID><Filter><Prefix>logs/</Prefix></Filter><Status>Enabled</Status>
+<Transition><Days>0</Days><StorageClass>STANDARD_IA</StorageClass></Transition>
+<Transition><Date>2030-01-01T00:00:00Z</Date><StorageClass>GLACIER</StorageClass></Transition>
+<NoncurrentVersionTransition><NoncurrentDays>7</NoncurrentDays><StorageClass>DEEP_ARCHIVE</StorageClass></NoncurrentVersionTransition>
+</Rule>
+<Rule><ID>expire</ID><Filter><Prefix>tmp/</Prefix></Filter><Status>Enabled</Status><Expiration><Days>1</Days></Expiration></Rule>
+</LifecycleConfiguration>`
+	resp = doSigned(t, http.MethodPut, ts.URL+"/"+bucket+"?lifecycle", []byte(lifecycleXML))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("PutBucketLifecycle: expected 200, got %d", resp.StatusCode)
+	}
+
+	resp = doSigned(t, http.MethodGet, ts.URL+"/"+bucket+"?lifecycle", nil)
+	body := readBody(t, resp)
+	var got xmlLifecycleConfiguration
+	if err := xml.Unmarshal([]byte(body), &got); err != nil {
+		t.Fatalf("decode lifecycle: %v\n%s", err, body)
+	}
+	if len(got.Rules) != 2 {
+		t.Fatalf("expected 2 rules, got %d: %s", len(got.Rules), body)
+	}
+	rule := got.Rules[0]
+	if len(rule.Transitions) != 2 || rule.Transitions[0].Days == nil || *rule.Transitions[0].Days != 0 ||
+		rule.Transitions[1].Date != "2030-01-01T00:00:00Z" || rule.Transitions[1].StorageClass != "GLACIER" {
+		t.Errorf("transitions not preserved: %s", body)
+	}
+	if len(rule.NoncurrentVersionTransitions) != 1 || rule.NoncurrentVersionTransitions[0].NoncurrentDays != 7 {
+		t.Errorf("noncurrent transitions not preserved: %s", body)
+	}
+	if got.Rules[1].Expiration == nil || got.Rules[1].Expiration.Days == nil || *got.Rules[1].Expiration.Days != 1 {
+		t.Errorf("expiration not preserved: %s", body)
+	}
+
+	badXML := `<LifecycleConfiguration><Rule><Status>Enabled</Status><Transition><Days>30</Days><StorageClass>TAPE</StorageClass></Transition></Rule></LifecycleConfiguration>`
+	resp = doSigned(t, http.MethodPut, ts.URL+"/"+bucket+"?lifecycle", []byte(badXML))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("invalid storage class: expected 400, got %d", resp.StatusCode)
+	}
+}
+
+func TestIntegrationLifecycleRoundTrip(t *testing.T) {
+	ts := newIntegrationServer(t)
+	bucket := "lc-roundtrip"
+
+	resp := doSigned(t, http.MethodPut, ts.URL+"/"+bucket, nil)
+	resp.Body.Close()
+
+	// A configuration as exported from an AWS bucket
+	lifecycleXML := `<LifecycleConfiguration xmlns="http://s3.amazonaws.com/doc/2006-03-01/">` +
+		`<Rule><ID>legacy</ID><Prefix>old/</Prefix><Status>Enabled</Status><Expiration><Days>365</Days></Expiration></Rule>` +
+		`<Rule><ID>and</ID><Filter><And><Prefix>logs/</Prefix><Tag><Key>env</Key><Value>prod</Value></Tag><Tag><Key>team</Key><Value>ops</Value></Tag>` +
+		`<ObjectSizeGreaterThan>1024</ObjectSizeGreaterThan><ObjectSizeLessThan>1048576</ObjectSizeLessThan></And></Filter>` +
+		`<Status>Enabled</Status><Expiration><Date>2030-06-01T00:00:00Z</Date></Expiration></Rule>` +
+		`<Rule><ID>all</ID><Filter><Prefix></Prefix></Filter><Status>Enabled</Status><Expiration><Days>30</Days></Expiration></Rule>` +
+		`<Rule><ID>tag</ID><Filter><Tag><Key>archive</Key><Value>yes</Value></Tag></Filter><Status>Disabled</Status>` +
+		`<Transition><Days>30</Days><StorageClass>GLACIER</StorageClass></Transition>` +
+		`<NoncurrentVersionTransition><NoncurrentDays>10</NoncurrentDays><NewerNoncurrentVersions>2</NewerNoncurrentVersions><StorageClass>DEEP_ARCHIVE</StorageClass></NoncurrentVersionTransition></Rule>` +
+		`<Rule><ID>versions</ID><Filter></Filter><Status>Enabled</Status>` +
+		`<Expiration><ExpiredObjectDeleteMarker>true</ExpiredObjectDeleteMarker></Expiration>` +
+		`<NoncurrentVersionExpiration><NoncurrentDays>30</NoncurrentDays><NewerNoncurrentVersions>3</NewerNoncurrentVersions></NoncurrentVersionExpiration>` +
+		`<AbortIncompleteMultipartUpload><DaysAfterInitiation>7</DaysAfterInitiation></AbortIncompleteMultipartUpload></Rule>` +
+		`</LifecycleConfiguration>`
+	resp = doSigned(t, http.MethodPut, ts.URL+"/"+bucket+"?lifecycle", []byte(lifecycleXML))
+	body := readBody(t, resp)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("PutBucketLifecycle: expected 200, got %d: %s", resp.StatusCode, body)
+	}
+
+	resp = doSigned(t, http.MethodGet, ts.URL+"/"+bucket+"?lifecycle", nil)
+	body = readBody(t, resp)
+
+	var sent, got xmlLifecycleConfiguration
+	if err := xml.Unmarshal([]byte(lifecycleXML), &sent); err != nil {
+		t.Fatalf("decode sent: %v", err)
+	}
+	if err := xml.Unmarshal([]byte(body), &got); err != nil {
+		t.Fatalf("decode lifecycle: %v\n%s", err, body)
+	}
+	sent.XMLName, got.XMLName = xml.Name{}, xml.Name{}
+	sentJSON, _ := json.Marshal(sent)
+	gotJSON, _ := json.Marshal(got)
+	if string(sentJSON) != string(gotJSON) {
+		t.Errorf("lifecycle did not round-trip:\nsent %s\ngot  %s", sentJSON, gotJSON)
+	}
+}
+
+func TestIntegrationLifecycleRejectsInvalidFilters(t *testing.T) {
+	ts := newIntegrationServer(t)
+	bucket := "lc-invalid"
+
+	resp := doSigned(t, http.MethodPut, ts.URL+"/"+bucket, nil)
+	resp.Body.Close()
+
+	for name, rule := range map[string]string{
+		"two predicates without And": `<Filter><Prefix>a/</Prefix><Tag><Key>k</Key><Value>v</Value></Tag></Filter><Status>Enabled</Status><Expiration><Days>1</Days></Expiration>`,
+		"prefix and filter":          `<Prefix>a/</Prefix><Filter><Prefix>a/</Prefix></Filter><Status>Enabled</Status><Expiration><Days>1</Days></Expiration>`,
+		"days and date":              `<Filter></Filter><Status>Enabled</Status><Expiration><Days>1</Days><Date>2030-01-01T00:00:00Z</Date></Expiration>`,
+		"date not at midnight":       `<Filter></Filter><Status>Enabled</Status><Expiration><Date>2030-01-01T12:00:00Z</Date></Expiration>`,
+		"duplicate tag keys":         `<Filter><And><Tag><Key>k</Key><Value>a</Value></Tag><Tag><Key>k</Key><Value>b</Value></Tag></And></Filter><Status>Enabled</Status><Expiration><Days>1</Days></Expiration>`,
+		"no action":                  `<Filter></Filter><Status>Enabled</Status>`,
+	} {
+		lifecycleXML := `<LifecycleConfiguration><Rule>` + rule + `</Rule></LifecycleConfiguration>`
+		resp = doSigned(t, http.MethodPut, ts.URL+"/"+bucket+"?lifecycle", []byte(lifecycleXML))
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", name, resp.StatusCode)
+		}
+	}
+}
+
+func TestIntegrationIntelligentTieringConfig(t *testing.T) {
+	ts := newIntegrationServer(t)
+	bucket := "int-tiering"
+
+	resp := doSigned(t, http.MethodPut, ts.URL+"/"+bucket, nil)
+	resp.Body.Close()
+
+	cfgXML := `<IntelligentTieringConfiguration><Id>archive</Id>` +
+		`<Filter><And><Prefix>logs/</Prefix><Tag><Key>class</Key><Value>cold</Value></Tag></And></Filter>` +
+		`<Status>Enabled</Status>` +
+		`<Tiering><AccessTier>ARCHIVE_ACCESS</AccessTier><Days>90</Days></Tiering>` +
+		`<Tiering><AccessTier>DEEP_ARCHIVE_ACCESS</AccessTier><Days>180</Days></Tiering>` +
+		`</IntelligentTieringConfiguration>`
+	resp = doSigned(t, http.MethodPut, ts.URL+"/"+bucket+"?intelligent-tiering&id=archive", []byte(cfgXML))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("put config: expected 204, got %d", resp.StatusCode)
+	}
+
+	resp = doSigned(t, http.MethodGet, ts.URL+"/"+bucket+"?intelligent-tiering&id=archive", nil)
+	body := readBody(t, resp)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("get config: expected 200, got %d: %s", resp.StatusCode, body)
+	}
+	var got xmlIntelligentTieringConfiguration
+	if err := xml.Unmarshal([]byte(body), &got); err != nil {
+		t.Fatalf("parse config: %v", err)
+	}
+	if got.ID != "archive" || got.Filter == nil || got.Filter.And == nil || *got.Filter.And.Prefix != "logs/" ||
+		len(got.Filter.And.Tags) != 1 || len(got.Tierings) != 2 || got.Tierings[1].Days != 180 {
+		t.Errorf("config round-trip: %s", body)
+	}
+
+	resp = doSigned(t, http.MethodGet, ts.URL+"/"+bucket+"?intelligent-tiering", nil)
+	body = readBody(t, resp)
+	var list xmlListIntelligentTieringConfigurations
+	if err := xml.Unmarshal([]byte(body), &list); err != nil || len(list.Configurations) != 1 || list.IsTruncated {
+		t.Errorf("list: %s (%v)", body, err)
+	}
+
+	for name, cfg := range map[string]string{
+		"archive days too low":  `<Id>bad</Id><Status>Enabled</Status><Tiering><AccessTier>ARCHIVE_ACCESS</AccessTier><Days>30</Days></Tiering>`,
+		"unknown access tier":   `<Id>bad</Id><Status>Enabled</Status><Tiering><AccessTier>INFREQUENT_ACCESS</AccessTier><Days>90</Days></Tiering>`,
+		"no tiering":            `<Id>bad</Id><Status>Enabled</Status>`,
+		"deep before archive":   `<Id>bad</Id><Status>Enabled</Status><Tiering><AccessTier>ARCHIVE_ACCESS</AccessTier><Days>200</Days></Tiering><Tiering><AccessTier>DEEP_ARCHIVE_ACCESS</AccessTier><Days>180</Days></Tiering>`,
+		"id mismatch":           `<Id>other</Id><Status>Enabled</Status><Tiering><AccessTier>ARCHIVE_ACCESS</AccessTier><Days>90</Days></Tiering>`,
+		"prefix and tag no And": `<Id>bad</Id><Filter><Prefix>a</Prefix><Tag><Key>k</Key><Value>v</Value></Tag></Filter><Status>Enabled</Status><Tiering><AccessTier>ARCHIVE_ACCESS</AccessTier><Days>90</Days></Tiering>`,
+	} {
+		resp = doSigned(t, http.MethodPut, ts.URL+"/"+bucket+"?intelligent-tiering&id=bad",
+			[]byte(`<IntelligentTieringConfiguration>`+cfg+`</IntelligentTieringConfiguration>`))
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", name, resp.StatusCode)
+		}
+	}
+
+	// Objects uploaded into the class report it
+	resp = doSignedWithHeaders(t, http.MethodPut, ts.URL+"/"+bucket+"/logs/a.txt", []byte("data"),
+		map[string]string{"X-Amz-Storage-Class": "INTELLIGENT_TIERING"})
+	resp.Body.Close()
+	resp = doSigned(t, http.MethodHead, ts.URL+"/"+bucket+"/logs/a.txt", nil)
+	resp.Body.Close()
+	if sc := resp.Header.Get("X-Amz-Storage-Class"); sc != "INTELLIGENT_TIERING" {
+		t.Errorf("storage class: got %q", sc)
+	}
+
+	resp = doSigned(t, http.MethodDelete, ts.URL+"/"+bucket+"?intelligent-tiering&id=archive", nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("delete: expected 204, got %d", resp.StatusCode)
+	}
+	resp = doSigned(t, http.MethodGet, ts.URL+"/"+bucket+"?intelligent-tiering&id=archive", nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("get after delete: expected 404, got %d", resp.StatusCode)
+	}
+}
+
+func TestIntegrationScanBeforeServe(t *testing.T) {
+	var store *metadata.Store
+	var scanned []string
+	ts := newIntegrationServer(t, func(h *Handler) {
+		store = h.store
+		h.SetScanFunc(func(bucket, key string, size int64) { scanned = append(scanned, key) })
+	})
+	bucket := "scan-bucket"
+	resp := doSigned(t, http.MethodPut, ts.URL+"/"+bucket, nil)
+	resp.Body.Close()
+
+	// Without scan-before-serve, objects are served right away
+	resp = doSigned(t, http.MethodPut, ts.URL+"/"+bucket+"/before.txt", []byte("before"))
+	resp.Body.Close()
+	resp = doSigned(t, http.MethodGet, ts.URL+"/"+bucket+"/before.txt", nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get(scanStatusHeader) != "" {
+		t.Fatalf("GET before: %d, scan status %q", resp.StatusCode, resp.Header.Get(scanStatusHeader))
+	}
+
+	if err := store.SetBucketScanBeforeServe(bucket, true); err != nil {
+		t.Fatalf("SetBucketScanBeforeServe: %v", err)
+	}
+	resp = doSigned(t, http.MethodPut, ts.URL+"/"+bucket+"/new.txt", []byte("new data"))
+	resp.Body.Close()
+
+	resp = doSigned(t, http.MethodGet, ts.URL+"/"+bucket+"/new.txt", nil)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden || !strings.Contains(string(body), "pending a virus scan") {
+		t.Fatalf("GET pending: expected 403, got %d: %s", resp.StatusCode, body)
+	}
+	resp = doSigned(t, http.MethodHead, ts.URL+"/"+bucket+"/new.txt", nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get(scanStatusHeader) != metadata.ScanPending {
+		t.Fatalf("HEAD pending: %d, scan status %q", resp.StatusCode, resp.Header.Get(scanStatusHeader))
+	}
+	resp = doSignedWithHeaders(t, http.MethodPut, ts.URL+"/"+bucket+"/copy.txt", nil, map[string]string{
+		"X-Amz-Copy-Source": "/" + bucket + "/new.txt",
+	})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("copy of pending object: expected 403, got %d", resp.StatusCode)
+	}
+
+	// Static website hosting holds back pending objects too, and does not
+	// serve a pending error document
+	if err := store.PutWebsiteConfig(bucket, metadata.WebsiteConfig{IndexDocument: "index.html", ErrorDocument: "new.txt"}); err != nil {
+		t.Fatalf("PutWebsiteConfig: %v", err)
+	}
+	resp, err := http.Get(ts.URL + "/" + bucket + "/new.txt")
+	if err != nil {
+		t.Fatalf("website GET: %v", err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden || strings.Contains(string(body), "new data") {
+		t.Fatalf("website GET pending: expected 403, got %d: %s", resp.StatusCode, body)
+	}
+	resp, err = http.Get(ts.URL + "/" + bucket + "/missing.html")
+	if err != nil {
+		t.Fatalf("website GET: %v", err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound || strings.Contains(string(body), "new data") {
+		t.Fatalf("website error document pending: expected plain 404, got %d: %s", resp.StatusCode, body)
+	}
+	if err := store.DeleteWebsiteConfig(bucket); err != nil {
+		t.Fatalf("DeleteWebsiteConfig: %v", err)
+	}
+
+	meta, _ := store.GetObjectMeta(bucket, "new.txt")
+	if err := store.SetObjectScanStatus(bucket, "new.txt", meta.ETag, metadata.ScanClean); err != nil {
+		t.Fatalf("SetObjectScanStatus: %v", err)
+	}
+	resp = doSigned(t, http.MethodGet, ts.URL+"/"+bucket+"/new.txt", nil)
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "new data" || resp.Header.Get(scanStatusHeader) != metadata.ScanClean {
+		t.Fatalf("GET clean: %d %q, scan status %q", resp.StatusCode, body, resp.Header.Get(scanStatusHeader))
+	}
+	if got := strings.Join(scanned, ","); got != "before.txt,new.txt" {
+		t.Errorf("scanned = %s", got)
+	}
+}
+
+func TestIntegrationUploadPolicy(t *testing.T) {
+	var store *metadata.Store
+	var mu sync.Mutex
+	var events []notify.Event
+	var denied []string
+	ts := newIntegrationServer(t, func(h *Handler) {
+		store = h.store
+		h.SetNotificationFunc(func(e notify.Event) {
+			mu.Lock()
+			events = append(events, e)
+			mu.Unlock()
+		})
+		h.SetAuditFunc(func(principal, userID, action, resource, effect, sourceIP string, statusCode int) {
+			if statusCode == http.StatusForbidden {
+				mu.Lock()
+				denied = append(denied, action+" "+resource)
+				mu.Unlock()
+			}
+		})
+	})
+	bucket := "documents"
+	resp := doSigned(t, http.MethodPut, ts.URL+"/"+bucket, nil)
+	resp.Body.Close()
+	if err := store.PutUploadPolicy(bucket, metadata.UploadPolicy{
+		Allow:               []string{"document", "image", "text"},
+		Deny:                []string{"executable"},
+		OverrideContentType: true,
+	}); err != nil {
+		t.Fatalf("PutUploadPolicy: %v", err)
+	}
+
+	elf := append([]byte("\x7fELF\x02\x01\x01\x00"), bytes.Repeat([]byte{0}, 600)...)
+	pdf := []byte("%PDF-1.7\n" + strings.Repeat("%", 600))
+
+	// An ELF binary disguised as a PDF is rejected, audited and reported
+	resp = doSignedWithHeaders(t, http.MethodPut, ts.URL+"/"+bucket+"/report.pdf", elf, map[string]string{"Content-Type": "application/pdf"})
+	body := readBody(t, resp)
+	if resp.StatusCode != http.StatusForbidden || !strings.Contains(body, "application/x-elf") {
+		t.Fatalf("PUT ELF: expected 403, got %d: %s", resp.StatusCode, body)
+	}
+	if _, err := store.GetObjectMeta(bucket, "report.pdf"); err == nil {
+		t.Fatal("rejected object was stored")
+	}
+	mu.Lock()
+	if len(events) != 1 || events[0].Name != notify.EventObjectRejectedContentType || events[0].DetectedContentType != "application/x-elf" ||
+		events[0].DeclaredContentType != "application/pdf" || events[0].PrincipalID != testAccessKey {
+		t.Errorf("events = %+v", events)
+	}
+	if len(denied) != 1 || denied[0] != "s3:PutObject arn:aws:s3:::documents/report.pdf" {
+		t.Errorf("audited denials = %v", denied)
+	}
+	events = nil
+	mu.Unlock()
+
+	// A PDF with a wrong Content-Type is stored as application/pdf
+	resp = doSignedWithHeaders(t, http.MethodPut, ts.URL+"/"+bucket+"/real.pdf", pdf, map[string]string{"Content-Type": "image/png"})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("PUT PDF: expected 200, got %d", resp.StatusCode)
+	}
+	if meta, _ := store.GetObjectMeta(bucket, "real.pdf"); meta == nil || meta.ContentType != "application/pdf" {
+		t.Fatalf("overridden content type: %+v", meta)
+	}
+
+	// Multipart: a disallowed first part is rejected as soon as it arrives,
+	// and completing checks the start of the assembled object again
+	resp = doSigned(t, http.MethodPost, ts.URL+"/"+bucket+"/tool.bin?uploads", nil)
+	var initResult initiateResult
+	xml.NewDecoder(resp.Body).Decode(&initResult)
+	resp.Body.Close()
+	partURL := func(n int) string {
+		return fmt.Sprintf("%s/%s/tool.bin?uploadId=%s&partNumber=%d", ts.URL, bucket, initResult.UploadID, n)
+	}
+	resp = doSigned(t, http.MethodPut, partURL(1), elf)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("UploadPart ELF: expected 403, got %d", resp.StatusCode)
+	}
+	resp = doSigned(t, http.MethodPut, partURL(2), elf)
+	resp.Body.Close()
+	etag2 := resp.Header.Get("ETag")
+	resp = doSigned(t, http.MethodPost, fmt.Sprintf("%s/%s/tool.bin?uploadId=%s", ts.URL, bucket, initResult.UploadID),
+		[]byte(fmt.Sprintf(`<CompleteMultipartUpload><Part><PartNumber>2</PartNumber><ETag>%s</ETag></Part></CompleteMultipartUpload>`, etag2)))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("CompleteMultipartUpload starting with ELF: expected 403, got %d", resp.StatusCode)
+	}
+	if _, err := store.GetObjectMeta(bucket, "tool.bin"); err == nil {
+		t.Fatal("rejected multipart object was stored")
+	}
+
+	// Copies into the bucket are checked against its policy
+	resp = doSigned(t, http.MethodPut, ts.URL+"/staging", nil)
+	resp.Body.Close()
+	resp = doSigned(t, http.MethodPut, ts.URL+"/staging/a.out", elf)
+	resp.Body.Close()
+	resp = doSignedWithHeaders(t, http.MethodPut, ts.URL+"/"+bucket+"/a.out", nil, map[string]string{"X-Amz-Copy-Source": "/staging/a.out"})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("copy of ELF: expected 403, got %d", resp.StatusCode)
+	}
+
+	// Browser POST uploads too
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	mw.WriteField("key", "upload.exe")
+	fw, _ := mw.CreateFormFile("file", "upload.exe")
+	fw.Write([]byte("MZ\x90\x00\x03\x00\x00\x00"))
+	mw.Close()
+	resp = doSignedWithHeaders(t, http.MethodPost, ts.URL+"/"+bucket, form.Bytes(), map[string]string{"Content-Type": mw.FormDataContentType()})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("POST PE: expected 403, got %d", resp.StatusCode)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	var rejected []string
+	for _, e := range events {
+		if e.Name == notify.EventObjectRejectedContentType {
+			rejected = append(rejected, e.Key)
+		}
+	}
+	if got := strings.Join(rejected, ","); got != "tool.bin,tool.bin,a.out,upload.exe" {
+		t.Errorf("rejection events for %s", got)
+	}
+}
+
+func TestIntegrationSearchExtension(t *testing.T) {
+	ts := newIntegrationServer(t, func(h *Handler) {
+		idx, err := search.Open(filepath.Join(t.TempDir(), "search.db"), h.store)
+		if err != nil {
+			t.Fatalf("search.Open: %v", err)
+		}
+		t.Cleanup(func() { idx.Close() })
+		h.SetSearchIndex(idx)
+		h.store.SetObjectObserver(search.NewObserver(idx, nil))
+	})
+	bucket := "search-bucket"
+	resp := doSigned(t, http.MethodPut, ts.URL+"/"+bucket, nil)
+	resp.Body.Close()
+	for key, size := range map[string]int{"logs/a.log": 10, "logs/b.log": 30, "logs/c.log": 20, "img/d.png": 40} {
+		resp = doSigned(t, http.MethodPut, ts.URL+"/"+bucket+"/"+key, bytes.Repeat([]byte("x"), size))
+		resp.Body.Close()
+	}
+
+	type searchResult struct {
+		TotalHits   int
+		IsTruncated bool
+		NextCursor  string
+		Contents    []struct{ Key string }
+	}
+	query := func(params string) (int, searchResult) {
+		resp := doSigned(t, http.MethodGet, ts.URL+"/"+bucket+"?search&"+params, nil)
+		defer resp.Body.Close()
+		var result searchResult
+		xml.NewDecoder(resp.Body).Decode(&result)
+		return resp.StatusCode, result
+	}
+
+	code, page := query("q=" + url.QueryEscape("prefix:logs/ sort:size desc") + "&max-results=2")
+	if code != http.StatusOK || page.TotalHits != 3 || !page.IsTruncated || len(page.Contents) != 2 ||
+		page.Contents[0].Key != "logs/b.log" || page.Contents[1].Key != "logs/c.log" {
+		t.Fatalf("first page: %d %+v", code, page)
+	}
+	code, page = query("q=" + url.QueryEscape("prefix:logs/ sort:size desc") + "&max-results=2&cursor=" + url.QueryEscape(page.NextCursor))
+	if code != http.StatusOK || page.IsTruncated || len(page.Contents) != 1 || page.Contents[0].Key != "logs/a.log" {
+		t.Fatalf("second page: %d %+v", code, page)
+	}
+
+	if code, _ := query("q=" + url.QueryEscape("size:>ten")); code != http.StatusBadRequest {
+		t.Errorf("invalid query: expected 400, got %d", code)
+	}
+	if code, _ := query("max-results=5"); code != http.StatusBadRequest {
+		t.Errorf("missing q: expected 400, got %d", code)
+	}
+	resp = doSigned(t, http.MethodGet, ts.URL+"/missing-bucket?search&q=log", nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("missing bucket: expected 404, got %d", resp.StatusCode)
+	}
+	code, page = query("q=" + url.QueryEscape("sort:size") + "&prefix=logs/")
+	if code != http.StatusOK || page.TotalHits != 3 || page.Contents[0].Key != "logs/a.log" {
+		t.Errorf("prefix parameter: %d %+v", code, page)
+	}
+}
+
+func TestIntegrationSearchAuthorization(t *testing.T) {
+	var store *metadata.Store
+	ts := newIntegrationServer(t, func(h *Handler) {
+		store = h.store
+		idx, err := search.Open(filepath.Join(t.TempDir(), "search.db"), h.store)
+		if err != nil {
+			t.Fatalf("search.Open: %v", err)
+		}
+		t.Cleanup(func() { idx.Close() })
+		h.SetSearchIndex(idx)
+	})
+	bucket := "search-auth"
+	resp := doSigned(t, http.MethodPut, ts.URL+"/"+bucket, nil)
+	resp.Body.Close()
+
+	// A user who may list the bucket and search under home/alice/ only
+	store.CreateIAMPolicy(metadata.IAMPolicy{Name: "alice", Document: `{"Version":"2012-10-17","Statement":[
+		{"Effect":"Allow","Action":["s3:ListBucket"],"Resource":["arn:aws:s3:::search-auth"]},
+		{"Effect":"Allow","Action":["s3:SearchBucket"],"Resource":["arn:aws:s3:::search-auth"],
+		 "Condition":{"StringLike":{"s3:prefix":["home/alice/*"]}}}]}`})
+	store.CreateIAMUser(metadata.IAMUser{Name: "alice", PolicyARNs: []string{"alice"}})
+	store.CreateAccessKey(metadata.AccessKey{AccessKey: "alicekey", SecretKey: "alicesecret", UserID: "alice"})
+
+	get := func(path string) int {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		signV4Request(req, "alicekey", "alicesecret", nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Do: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	for path, want := range map[string]int{
+		"/" + bucket:                                       http.StatusOK,
+		"/" + bucket + "?search&q=log":                     http.StatusForbidden,
+		"/" + bucket + "?search&q=log&prefix=home/bob/":    http.StatusForbidden,
+		"/" + bucket + "?search&q=log&prefix=home/alice/":  http.StatusOK,
+		"/" + bucket + "?search&q=log&prefix=home/alice/x": http.StatusOK,
+	} {
+		if got := get(path); got != want {
+			t.Errorf("GET %s: got %d, want %d", path, got, want)
+		}
+	}
+}
+
+func TestIntegrationRestoreObject(t *testing.T) {
+	var store *metadata.Store
+	var restoreErr error
+	var gotVersion string
+	ts := newIntegrationServer(t, func(h *Handler) {
+		store = h.store
+		h.SetRestoreFunc(func(bucket, key, versionID, tier string, days int) (bool, error) {
+			gotVersion = versionID
+			return restoreErr == nil, restoreErr
+		})
+	})
+	bucket := "restore-bucket"
+	resp := doSigned(t, http.MethodPut, ts.URL+"/"+bucket, nil)
+	resp.Body.Close()
+	resp = doSigned(t, http.MethodPut, ts.URL+"/"+bucket+"/hot.txt", []byte("hot"))
+	resp.Body.Close()
+	store.PutObjectMeta(metadata.ObjectMeta{Bucket: bucket, Key: "cold.txt", Size: 4, Tier: "cold"})
+	store.PutObjectVersion(metadata.ObjectMeta{Bucket: bucket, Key: "cold.txt", VersionID: "v1", Size: 4, Tier: "cold"})
+
+	tests := []struct {
+		name   string
+		path   string
+		err    error
+		status int
+		code   string
+	}{
+		{"hot object", "/hot.txt?restore", nil, http.StatusForbidden, "InvalidObjectState"},
+		{"archived object", "/cold.txt?restore", nil, http.StatusAccepted, ""},
+		{"archived version", "/cold.txt?restore&versionId=v1", nil, http.StatusAccepted, ""},
+		{"missing version", "/cold.txt?restore&versionId=v9", nil, http.StatusNotFound, "NoSuchVersion"},
+		{"raced to hot", "/cold.txt?restore", tiering.ErrNotArchived, http.StatusForbidden, "InvalidObjectState"},
+		{"in progress", "/cold.txt?restore", tiering.ErrRestoreInProgress, http.StatusConflict, "RestoreAlreadyInProgress"},
+	}
+	for _, tt := range tests {
+		restoreErr, gotVersion = tt.err, ""
+		resp := doSigned(t, http.MethodPost, ts.URL+"/"+bucket+tt.path, nil)
+		body := readBody(t, resp)
+		if resp.StatusCode != tt.status || !strings.Contains(body, tt.code) {
+			t.Errorf("%s: got %d %s, want %d %s", tt.name, resp.StatusCode, body, tt.status, tt.code)
+		}
+	}
+
+	restoreErr = nil
+	resp = doSigned(t, http.MethodPost, ts.URL+"/"+bucket+"/cold.txt?restore&versionId=v1", nil)
+	resp.Body.Close()
+	if gotVersion != "v1" {
+		t.Errorf("restore func got version %q, want v1", gotVersion)
+	}
+}
@@ -0,0 +1,280 @@
+package s3
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+
+	"github.com/eniz1806/VaultS3/internal/metadata"
+)
+
+// Intelligent-Tiering configuration XML, following the S3 grammar.
+
+type xmlIntelligentTieringAnd struct {
+	Prefix *string           `xml:"Prefix"`
+	Tags   []xmlLifecycleTag `xml:"Tag"`
+}
+
+type xmlIntelligentTieringFilter struct {
+	Prefix *string                   `xml:"Prefix"`
+	Tag    *xmlLifecycleTag          `xml:"Tag"`
+	And    *xmlIntelligentTieringAnd `xml:"And"`
+}
+
+type xmlTiering struct {
+	AccessTier string `xml:"AccessTier"`
+	Days       int    `xml:"Days"`
+}
+
+type xmlIntelligentTieringConfiguration struct {
+	XMLName  xml.Name                     `xml:"IntelligentTieringConfiguration"`
+	Xmlns    string                       `xml:"xmlns,attr,omitempty"`
+	ID       string                       `xml:"Id"`
+	Filter   *xmlIntelligentTieringFilter `xml:"Filter"`
+	Status   string                       `xml:"Status"`
+	Tierings []xmlTiering                 `xml:"Tiering"`
+}
+
+type xmlListIntelligentTieringConfigurations struct {
+	XMLName               xml.Name                             `xml:"ListBucketIntelligentTieringConfigurationsOutput"`
+	Xmlns                 string                               `xml:"xmlns,attr"`
+	IsTruncated           bool                                 `xml:"IsTruncated"`
+	ContinuationToken     string                               `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string                               `xml:"NextContinuationToken,omitempty"`
+	Configurations        []xmlIntelligentTieringConfiguration `xml:"IntelligentTieringConfiguration"`
+}
+
+const (
+	maxIntelligentTieringConfigs  = 1000
+	intelligentTieringListMaxKeys = 100
+)
+
+// intelligentTieringDays are the allowed day ranges of the archive access tiers.
+var intelligentTieringDays = map[string][2]int{
+	"ARCHIVE_ACCESS":      {90, 730},
+	"DEEP_ARCHIVE_ACCESS": {180, 730},
+}
+
+// intelligentTieringFromXML validates an Intelligent-Tiering configuration.
+// On failure it returns an S3 error code and message.
+func intelligentTieringFromXML(x xmlIntelligentTieringConfiguration) (cfg metadata.IntelligentTieringConfig, code, msg string) {
+	if x.ID == "" {
+		return cfg, "InvalidArgument", "Id is required"
+	}
+	if x.Status != "Enabled" && x.Status != "Disabled" {
+		return cfg, "MalformedXML", "Status must be Enabled or Disabled"
+	}
+	cfg.ID = x.ID
+	cfg.Status = x.Status
+
+	if f := x.Filter; f != nil {
+		set := 0
+		if f.Prefix != nil {
+			set++
+			cfg.Prefix = *f.Prefix
+		}
+		if f.Tag != nil {
+			set++
+			cfg.TagFilter = map[string]string{f.Tag.Key: f.Tag.Value}
+		}
+		if f.And != nil {
+			set++
+			if f.And.Prefix != nil {
+				cfg.Prefix = *f.And.Prefix
+			}
+			for _, t := range f.And.Tags {
+				if cfg.TagFilter == nil {
+					cfg.TagFilter = make(map[string]string)
+				}
+				if _, dup := cfg.TagFilter[t.Key]; dup {
+					return cfg, "InvalidArgument", "Duplicate Tag Keys are not allowed"
+				}
+				cfg.TagFilter[t.Key] = t.Value
+			}
+		}
+		if set > 1 {
+			return cfg, "MalformedXML", "Filter can only have one of Prefix, Tag or And"
+		}
+	}
+
+	if len(x.Tierings) == 0 {
+		return cfg, "MalformedXML", "At least one Tiering is required"
+	}
+	days := make(map[string]int)
+	for _, t := range x.Tierings {
+		limits, ok := intelligentTieringDays[t.AccessTier]
+		if !ok {
+			return cfg, "MalformedXML", "AccessTier must be ARCHIVE_ACCESS or DEEP_ARCHIVE_ACCESS"
+		}
+		if _, dup := days[t.AccessTier]; dup {
+			return cfg, "InvalidArgument", "Each AccessTier can only be configured once"
+		}
+		if t.Days < limits[0] || t.Days > limits[1] {
+			return cfg, "InvalidArgument", fmt.Sprintf("Days for %s must be between %d and %d", t.AccessTier, limits[0], limits[1])
+		}
+		days[t.AccessTier] = t.Days
+		cfg.Tierings = append(cfg.Tierings, metadata.IntelligentTiering{AccessTier: t.AccessTier, Days: t.Days})
+	}
+	if a, ok := days["ARCHIVE_ACCESS"]; ok {
+		if d, ok := days["DEEP_ARCHIVE_ACCESS"]; ok && d <= a {
+			return cfg, "InvalidArgument", "Days for DEEP_ARCHIVE_ACCESS must be greater than for ARCHIVE_ACCESS"
+		}
+	}
+	return cfg, "", ""
+}
+
+// intelligentTieringToXML renders a stored configuration, using And when the
+// filter has more than one predicate.
+func intelligentTieringToXML(cfg metadata.IntelligentTieringConfig) xmlIntelligentTieringConfiguration {
+	x := xmlIntelligentTieringConfiguration{ID: cfg.ID, Status: cfg.Status}
+	keys := make([]string, 0, len(cfg.TagFilter))
+	for k := range cfg.TagFilter {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	predicates := len(keys)
+	if cfg.Prefix != "" {
+		predicates++
+	}
+	switch {
+	case predicates > 1:
+		and := &xmlIntelligentTieringAnd{}
+		if cfg.Prefix != "" {
+			prefix := cfg.Prefix
+			and.Prefix = &prefix
+		}
+		for _, k := range keys {
+			and.Tags = append(and.Tags, xmlLifecycleTag{Key: k, Value: cfg.TagFilter[k]})
+		}
+		x.Filter = &xmlIntelligentTieringFilter{And: and}
+	case cfg.Prefix != "":
+		prefix := cfg.Prefix
+		x.Filter = &xmlIntelligentTieringFilter{Prefix: &prefix}
+	case len(keys) == 1:
+		x.Filter = &xmlIntelligentTieringFilter{Tag: &xmlLifecycleTag{Key: keys[0], Value: cfg.TagFilter[keys[0]]}}
+	}
+	for _, t := range cfg.Tierings {
+		x.Tierings = append(x.Tierings, xmlTiering{AccessTier: t.AccessTier, Days: t.Days})
+	}
+	return x
+}
+
+// PutBucketIntelligentTiering handles PUT /{bucket}?intelligent-tiering&id=ID.
+func (h *BucketHandler) PutBucketIntelligentTiering(w http.ResponseWriter, r *http.Request, bucket string) {
+	if !h.store.BucketExists(bucket) {
+		writeS3Error(w, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
+		return
+	}
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		writeS3Error(w, "InvalidArgument", "Missing required parameter id", http.StatusBadRequest)
+		return
+	}
+
+	var req xmlIntelligentTieringConfiguration
+	if err := xml.NewDecoder(io.LimitReader(r.Body, 64*1024)).Decode(&req); err != nil {
+		writeS3Error(w, "MalformedXML", "Could not parse Intelligent-Tiering XML", http.StatusBadRequest)
+		return
+	}
+	if req.ID != id {
+		writeS3Error(w, "InvalidArgument", "Configuration Id must match the id parameter", http.StatusBadRequest)
+		return
+	}
+	cfg, code, msg := intelligentTieringFromXML(req)
+	if msg != "" {
+		writeS3Error(w, code, msg, http.StatusBadRequest)
+		return
+	}
+
+	existing, err := h.store.ListIntelligentTieringConfigs(bucket)
+	if err != nil {
+		slog.Error("internal error", "error", err)
+		writeS3Error(w, "InternalError", "An internal error occurred", http.StatusInternalServerError)
+		return
+	}
+	if len(existing) >= maxIntelligentTieringConfigs {
+		if _, err := h.store.GetIntelligentTieringConfig(bucket, id); err != nil {
+			writeS3Error(w, "TooManyConfigurations", "A bucket can have at most 1000 Intelligent-Tiering configurations", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := h.store.PutIntelligentTieringConfig(bucket, cfg); err != nil {
+		slog.Error("internal error", "error", err)
+		writeS3Error(w, "InternalError", "An internal error occurred", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetBucketIntelligentTiering handles GET /{bucket}?intelligent-tiering&id=ID.
+func (h *BucketHandler) GetBucketIntelligentTiering(w http.ResponseWriter, r *http.Request, bucket string) {
+	if !h.store.BucketExists(bucket) {
+		writeS3Error(w, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
+		return
+	}
+	cfg, err := h.store.GetIntelligentTieringConfig(bucket, r.URL.Query().Get("id"))
+	if err != nil {
+		writeS3Error(w, "NoSuchConfiguration", "The specified configuration does not exist", http.StatusNotFound)
+		return
+	}
+	resp := intelligentTieringToXML(*cfg)
+	resp.Xmlns = "http://s3.amazonaws.com/doc/2006-03-01/"
+	writeXML(w, http.StatusOK, resp)
+}
+
+// ListBucketIntelligentTiering handles GET /{bucket}?intelligent-tiering.
+func (h *BucketHandler) ListBucketIntelligentTiering(w http.ResponseWriter, r *http.Request, bucket string) {
+	if !h.store.BucketExists(bucket) {
+		writeS3Error(w, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
+		return
+	}
+	cfgs, err := h.store.ListIntelligentTieringConfigs(bucket)
+	if err != nil {
+		slog.Error("internal error", "error", err)
+		writeS3Error(w, "InternalError", "An internal error occurred", http.StatusInternalServerError)
+		return
+	}
+
+	// The continuation token is the last ID of the previous page
+	token := r.URL.Query().Get("continuation-token")
+	resp := xmlListIntelligentTieringConfigurations{
+		Xmlns:             "http://s3.amazonaws.com/doc/2006-03-01/",
+		ContinuationToken: token,
+	}
+	for _, cfg := range cfgs {
+		if token != "" && cfg.ID <= token {
+			continue
+		}
+		if len(resp.Configurations) == intelligentTieringListMaxKeys {
+			resp.IsTruncated = true
+			resp.NextContinuationToken = resp.Configurations[len(resp.Configurations)-1].ID
+			break
+		}
+		resp.Configurations = append(resp.Configurations, intelligentTieringToXML(cfg))
+	}
+	writeXML(w, http.StatusOK, resp)
+}
+
+// DeleteBucketIntelligentTiering handles DELETE /{bucket}?intelligent-tiering&id=ID.
+func (h *BucketHandler) DeleteBucketIntelligentTiering(w http.ResponseWriter, r *http.Request, bucket string) {
+	if !h.store.BucketExists(bucket) {
+		writeS3Error(w, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
+		return
+	}
+	id := r.URL.Query().Get("id")
+	if _, err := h.store.GetIntelligentTieringConfig(bucket, id); err != nil {
+		writeS3Error(w, "NoSuchConfiguration", "The specified configuration does not exist", http.StatusNotFound)
+		return
+	}
+	if err := h.store.DeleteIntelligentTieringConfig(bucket, id); err != nil {
+		slog.Error("internal error", "error", err)
+		writeS3Error(w, "InternalError", "An internal error occurred", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,366 @@
+package s3
+
+import (
+	"encoding/xml"
+	"sort"
+	"time"
+
+	"github.com/eniz1806/VaultS3/internal/metadata"
+)
+
+// Lifecycle configuration XML, following the S3 grammar. Optional elements
+// are pointers so that presence can be validated and reproduced on GET.
+
+type xmlLifecycleTag struct {
+	Key   string `xml:"Key"`
+	Value string `xml:"Value"`
+}
+
+type xmlLifecycleAnd struct {
+	Prefix                *string           `xml:"Prefix"`
+	Tags                  []xmlLifecycleTag `xml:"Tag"`
+	ObjectSizeGreaterThan *int64            `xml:"ObjectSizeGreaterThan"`
+	ObjectSizeLessThan    *int64            `xml:"ObjectSizeLessThan"`
+}
+
+type xmlLifecycleFilter struct {
+	Prefix                *string          `xml:"Prefix"`
+	Tag                   *xmlLifecycleTag `xml:"Tag"`
+	ObjectSizeGreaterThan *int64           `xml:"ObjectSizeGreaterThan"`
+	ObjectSizeLessThan    *int64           `xml:"ObjectSizeLessThan"`
+	And                   *xmlLifecycleAnd `xml:"And"`
+}
+
+type xmlExpiration struct {
+	Date                      string `xml:"Date,omitempty"`
+	Days                      *int   `xml:"Days"`
+	ExpiredObjectDeleteMarker *bool  `xml:"ExpiredObjectDeleteMarker"`
+}
+
+type xmlTransition struct {
+	Date         string `xml:"Date,omitempty"`
+	Days         *int   `xml:"Days"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type xmlNoncurrentVersionTransition struct {
+	NoncurrentDays          int    `xml:"NoncurrentDays"`
+	NewerNoncurrentVersions int    `xml:"NewerNoncurrentVersions,omitempty"`
+	StorageClass            string `xml:"StorageClass"`
+}
+
+type xmlNoncurrentVersionExpiration struct {
+	NoncurrentDays          int `xml:"NoncurrentDays,omitempty"`
+	NewerNoncurrentVersions int `xml:"NewerNoncurrentVersions,omitempty"`
+}
+
+type xmlAbortIncompleteMultipartUpload struct {
+	DaysAfterInitiation int `xml:"DaysAfterInitiation"`
+}
+
+type xmlLifecycleRule struct {
+	ID                             string                             `xml:"ID,omitempty"`
+	Prefix                         *string                            `xml:"Prefix"`
+	Filter                         *xmlLifecycleFilter                `xml:"Filter"`
+	Status                         string                             `xml:"Status"`
+	Expiration                     *xmlExpiration                     `xml:"Expiration"`
+	Transitions                    []xmlTransition                    `xml:"Transition"`
+	NoncurrentVersionTransitions   []xmlNoncurrentVersionTransition   `xml:"NoncurrentVersionTransition"`
+	NoncurrentVersionExpiration    *xmlNoncurrentVersionExpiration    `xml:"NoncurrentVersionExpiration"`
+	AbortIncompleteMultipartUpload *xmlAbortIncompleteMultipartUpload `xml:"AbortIncompleteMultipartUpload"`
+}
+
+type xmlLifecycleConfiguration struct {
+	XMLName xml.Name           `xml:"LifecycleConfiguration"`
+	Xmlns   string             `xml:"xmlns,attr,omitempty"`
+	Rules   []xmlLifecycleRule `xml:"Rule"`
+}
+
+// lifecycleDate validates an ISO 8601 lifecycle date. Like S3, only midnight
+// UTC is accepted.
+func lifecycleDate(s string) bool {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return false
+	}
+	t = t.UTC()
+	return t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0 && t.Nanosecond() == 0
+}
+
+// lifecycleRuleFromXML validates an XML rule and converts it to its stored
+// form. A non-empty message describes why the rule was rejected, with code
+// holding the S3 error code to return.
+func lifecycleRuleFromXML(xr xmlLifecycleRule) (rule metadata.LifecycleRule, code, msg string) {
+	rule = metadata.LifecycleRule{ID: xr.ID, Status: xr.Status}
+	if len(xr.ID) > 255 {
+		return rule, "InvalidArgument", "ID length should not exceed allowed limit of 255"
+	}
+	if rule.Status != "Enabled" && rule.Status != "Disabled" {
+		return rule, "MalformedXML", "Status must be Enabled or Disabled"
+	}
+
+	// Filter: a rule-level Prefix (deprecated), or a Filter holding a single
+	// predicate or an And of several
+	if xr.Prefix != nil && xr.Filter != nil {
+		return rule, "MalformedXML", "Rule cannot have both Prefix and Filter"
+	}
+	addTag := func(tag xmlLifecycleTag) string {
+		if tag.Key == "" {
+			return "Tag key must not be empty"
+		}
+		if rule.TagFilter == nil {
+			rule.TagFilter = make(map[string]string)
+		}
+		if _, dup := rule.TagFilter[tag.Key]; dup {
+			return "Duplicate Tag Keys are not allowed"
+		}
+		rule.TagFilter[tag.Key] = tag.Value
+		return ""
+	}
+	switch {
+	case xr.Prefix != nil:
+		rule.FilterForm = metadata.LifecycleFilterLegacy
+		rule.Prefix = *xr.Prefix
+	case xr.Filter != nil:
+		f := xr.Filter
+		predicates := 0
+		for _, set := range []bool{f.Prefix != nil, f.Tag != nil, f.ObjectSizeGreaterThan != nil, f.ObjectSizeLessThan != nil, f.And != nil} {
+			if set {
+				predicates++
+			}
+		}
+		if predicates > 1 {
+			return rule, "MalformedXML", "Filter can only have one predicate; combine predicates with And"
+		}
+		if f.And != nil {
+			rule.FilterForm = metadata.LifecycleFilterAnd
+			if f.And.Prefix != nil {
+				rule.Prefix = *f.And.Prefix
+				rule.ExplicitPrefix = true
+			}
+			for _, tag := range f.And.Tags {
+				if m := addTag(tag); m != "" {
+					return rule, "InvalidArgument", m
+				}
+			}
+			if f.And.ObjectSizeGreaterThan != nil {
+				rule.ObjectSizeGreaterThan = *f.And.ObjectSizeGreaterThan
+			}
+			if f.And.ObjectSizeLessThan != nil {
+				rule.ObjectSizeLessThan = *f.And.ObjectSizeLessThan
+			}
+		} else {
+			if f.Prefix != nil {
+				rule.Prefix = *f.Prefix
+				rule.ExplicitPrefix = true
+			}
+			if f.Tag != nil {
+				if m := addTag(*f.Tag); m != "" {
+					return rule, "InvalidArgument", m
+				}
+			}
+			if f.ObjectSizeGreaterThan != nil {
+				rule.ObjectSizeGreaterThan = *f.ObjectSizeGreaterThan
+			}
+			if f.ObjectSizeLessThan != nil {
+				rule.ObjectSizeLessThan = *f.ObjectSizeLessThan
+			}
+		}
+		if rule.ObjectSizeGreaterThan < 0 || rule.ObjectSizeLessThan < 0 {
+			return rule, "InvalidArgument", "Object size filters must not be negative"
+		}
+		if rule.ObjectSizeGreaterThan > 0 && rule.ObjectSizeLessThan > 0 && rule.ObjectSizeLessThan <= rule.ObjectSizeGreaterThan {
+			return rule, "InvalidArgument", "ObjectSizeLessThan must be greater than ObjectSizeGreaterThan"
+		}
+	}
+
+	if e := xr.Expiration; e != nil {
+		actions := 0
+		if e.Days != nil {
+			if *e.Days <= 0 {
+				return rule, "InvalidArgument", "Expiration days must be positive"
+			}
+			rule.ExpirationDays = *e.Days
+			actions++
+		}
+		if e.Date != "" {
+			if !lifecycleDate(e.Date) {
+				return rule, "InvalidArgument", "Expiration date must be midnight UTC in ISO 8601 format"
+			}
+			rule.ExpirationDate = e.Date
+			actions++
+		}
+		if e.ExpiredObjectDeleteMarker != nil {
+			if len(rule.TagFilter) > 0 {
+				return rule, "InvalidArgument", "ExpiredObjectDeleteMarker cannot be specified with tags"
+			}
+			rule.ExpiredObjectDeleteMarker = *e.ExpiredObjectDeleteMarker
+			actions++
+		}
+		if actions != 1 {
+			return rule, "MalformedXML", "Expiration must specify exactly one of Days, Date or ExpiredObjectDeleteMarker"
+		}
+	}
+
+	for _, xt := range xr.Transitions {
+		if !transitionStorageClasses[xt.StorageClass] {
+			return rule, "InvalidStorageClass", "Invalid transition storage class: " + xt.StorageClass
+		}
+		if (xt.Days == nil) == (xt.Date == "") {
+			return rule, "MalformedXML", "Transition must specify exactly one of Days or Date"
+		}
+		t := metadata.LifecycleTransition{StorageClass: xt.StorageClass}
+		if xt.Days != nil {
+			if *xt.Days < 0 {
+				return rule, "InvalidArgument", "Transition days must not be negative"
+			}
+			t.Days = *xt.Days
+		} else {
+			if !lifecycleDate(xt.Date) {
+				return rule, "InvalidArgument", "Transition date must be midnight UTC in ISO 8601 format"
+			}
+			t.Date = xt.Date
+		}
+		rule.Transitions = append(rule.Transitions, t)
+	}
+
+	for _, xt := range xr.NoncurrentVersionTransitions {
+		if !transitionStorageClasses[xt.StorageClass] {
+			return rule, "InvalidStorageClass", "Invalid transition storage class: " + xt.StorageClass
+		}
+		if xt.NoncurrentDays <= 0 {
+			return rule, "InvalidArgument", "NoncurrentDays must be positive"
+		}
+		if xt.NewerNoncurrentVersions < 0 {
+			return rule, "InvalidArgument", "NewerNoncurrentVersions must not be negative"
+		}
+		rule.NoncurrentVersionTransitions = append(rule.NoncurrentVersionTransitions, metadata.NoncurrentVersionTransition{
+			NoncurrentDays:          xt.NoncurrentDays,
+			NewerNoncurrentVersions: xt.NewerNoncurrentVersions,
+			StorageClass:            xt.StorageClass,
+		})
+	}
+
+	if e := xr.NoncurrentVersionExpiration; e != nil {
+		if e.NoncurrentDays <= 0 && e.NewerNoncurrentVersions <= 0 {
+			return rule, "InvalidArgument", "NoncurrentDays must be positive"
+		}
+		if e.NoncurrentDays < 0 || e.NewerNoncurrentVersions < 0 {
+			return rule, "InvalidArgument", "NoncurrentVersionExpiration values must not be negative"
+		}
+		rule.NoncurrentVersionExpirationDays = e.NoncurrentDays
+		rule.MaxNoncurrentVersions = e.NewerNoncurrentVersions
+	}
+
+	if a := xr.AbortIncompleteMultipartUpload; a != nil {
+		if a.DaysAfterInitiation <= 0 {
+			return rule, "InvalidArgument", "DaysAfterInitiation must be positive"
+		}
+		if len(rule.TagFilter) > 0 {
+			return rule, "InvalidArgument", "AbortIncompleteMultipartUpload cannot be specified with tags"
+		}
+		rule.AbortIncompleteMultipartDays = a.DaysAfterInitiation
+	}
+
+	if xr.Expiration == nil && len(rule.Transitions) == 0 && len(rule.NoncurrentVersionTransitions) == 0 &&
+		xr.NoncurrentVersionExpiration == nil && xr.AbortIncompleteMultipartUpload == nil {
+		return rule, "InvalidRequest", "At least one action needs to be specified in a rule"
+	}
+	return rule, "", ""
+}
+
+// lifecycleRuleToXML renders a stored rule in the same shape it was submitted in.
+func lifecycleRuleToXML(rule metadata.LifecycleRule) xmlLifecycleRule {
+	xr := xmlLifecycleRule{ID: rule.ID, Status: rule.Status}
+
+	tagKeys := make([]string, 0, len(rule.TagFilter))
+	for k := range rule.TagFilter {
+		tagKeys = append(tagKeys, k)
+	}
+	sort.Strings(tagKeys)
+	optSize := func(n int64) *int64 {
+		if n <= 0 {
+			return nil
+		}
+		return &n
+	}
+
+	predicates := len(tagKeys)
+	hasPrefix := rule.Prefix != "" || rule.ExplicitPrefix
+	for _, set := range []bool{hasPrefix, rule.ObjectSizeGreaterThan > 0, rule.ObjectSizeLessThan > 0} {
+		if set {
+			predicates++
+		}
+	}
+
+	switch {
+	case rule.FilterForm == metadata.LifecycleFilterLegacy:
+		prefix := rule.Prefix
+		xr.Prefix = &prefix
+	case rule.FilterForm == metadata.LifecycleFilterAnd || predicates > 1:
+		// Rules created through the admin API may combine predicates
+		// without having been submitted as an And
+		and := &xmlLifecycleAnd{
+			ObjectSizeGreaterThan: optSize(rule.ObjectSizeGreaterThan),
+			ObjectSizeLessThan:    optSize(rule.ObjectSizeLessThan),
+		}
+		if hasPrefix {
+			prefix := rule.Prefix
+			and.Prefix = &prefix
+		}
+		for _, k := range tagKeys {
+			and.Tags = append(and.Tags, xmlLifecycleTag{Key: k, Value: rule.TagFilter[k]})
+		}
+		xr.Filter = &xmlLifecycleFilter{And: and}
+	default:
+		f := &xmlLifecycleFilter{
+			ObjectSizeGreaterThan: optSize(rule.ObjectSizeGreaterThan),
+			ObjectSizeLessThan:    optSize(rule.ObjectSizeLessThan),
+		}
+		if hasPrefix {
+			prefix := rule.Prefix
+			f.Prefix = &prefix
+		}
+		if len(tagKeys) == 1 {
+			f.Tag = &xmlLifecycleTag{Key: tagKeys[0], Value: rule.TagFilter[tagKeys[0]]}
+		}
+		xr.Filter = f
+	}
+
+	switch {
+	case rule.ExpirationDays > 0:
+		days := rule.ExpirationDays
+		xr.Expiration = &xmlExpiration{Days: &days}
+	case rule.ExpirationDate != "":
+		xr.Expiration = &xmlExpiration{Date: rule.ExpirationDate}
+	case rule.ExpiredObjectDeleteMarker:
+		marker := true
+		xr.Expiration = &xmlExpiration{ExpiredObjectDeleteMarker: &marker}
+	}
+
+	for _, t := range rule.Transitions {
+		xt := xmlTransition{Date: t.Date, StorageClass: t.StorageClass}
+		if t.Date == "" {
+			days := t.Days
+			xt.Days = &days
+		}
+		xr.Transitions = append(xr.Transitions, xt)
+	}
+	for _, t := range rule.NoncurrentVersionTransitions {
+		xr.NoncurrentVersionTransitions = append(xr.NoncurrentVersionTransitions, xmlNoncurrentVersionTransition{
+			NoncurrentDays:          t.NoncurrentDays,
+			NewerNoncurrentVersions: t.NewerNoncurrentVersions,
+			StorageClass:            t.StorageClass,
+		})
+	}
+	if rule.NoncurrentVersionExpirationDays > 0 || rule.MaxNoncurrentVersions > 0 {
+		xr.NoncurrentVersionExpiration = &xmlNoncurrentVersionExpiration{
+			NoncurrentDays:          rule.NoncurrentVersionExpirationDays,
+			NewerNoncurrentVersions: rule.MaxNoncurrentVersions,
+		}
+	}
+	if rule.AbortIncompleteMultipartDays > 0 {
+		xr.AbortIncompleteMultipartUpload = &xmlAbortIncompleteMultipartUpload{DaysAfterInitiation: rule.AbortIncompleteMultipartDays}
+	}
+	return xr
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/eniz1806/VaultS3/internal/metadata"
+	"github.com/eniz1806/VaultS3/internal/notify"
 )
 
 // PutObjectLegalHold handles PUT /{bucket}/{key}?legal-hold.
@@ -48,6 +49,7 @@ func (h *ObjectHandler) PutObjectLegalHold(w http.ResponseWriter, r *http.Reques
 	}
 
 	w.WriteHeader(http.StatusOK)
+	h.emitEvent(r, notify.EventObjectLegalHoldPut, bucket, key, meta.Size, meta.ETag, meta.VersionID)
 }
 
 // GetObjectLegalHold handles GET /{bucket}/{key}?legal-hold.
@@ -136,6 +138,7 @@ func (h *ObjectHandler) PutObjectRetention(w http.ResponseWriter, r *http.Reques
 	}
 
 	w.WriteHeader(http.StatusOK)
+	h.emitEvent(r, notify.EventObjectRetentionPut, bucket, key, meta.Size, meta.ETag, meta.VersionID)
 }
 
 // GetObjectRetention handles GET /{bucket}/{key}?retention.
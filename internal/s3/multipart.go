@@ -18,6 +18,7 @@ import (
 	"time"
 
 	"github.com/eniz1806/VaultS3/internal/metadata"
+	"github.com/eniz1806/VaultS3/internal/notify"
 )
 
 // validUploadID ensures uploadID is hex-only to prevent path traversal.
@@ -30,6 +31,12 @@ func (h *ObjectHandler) CreateMultipartUpload(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	algo, ok := parseChecksumAlgorithm(r.Header.Get("X-Amz-Checksum-Algorithm"))
+	if !ok {
+		writeS3Error(w, "InvalidRequest", "Checksum algorithm is not supported", http.StatusBadRequest)
+		return
+	}
+
 	uploadID := generateUploadID()
 
 	ct := r.Header.Get("Content-Type")
@@ -38,11 +45,12 @@ func (h *ObjectHandler) CreateMultipartUpload(w http.ResponseWriter, r *http.Req
 	}
 
 	upload := metadata.MultipartUpload{
-		UploadID:    uploadID,
-		Bucket:      bucket,
-		Key:         key,
-		ContentType: ct,
-		CreatedAt:   time.Now().UTC().Unix(),
+		UploadID:          uploadID,
+		Bucket:            bucket,
+		Key:               key,
+		ContentType:       ct,
+		CreatedAt:         time.Now().UTC().Unix(),
+		ChecksumAlgorithm: algo,
 	}
 
 	if err := h.store.CreateMultipartUpload(upload); err != nil {
@@ -66,6 +74,9 @@ func (h *ObjectHandler) CreateMultipartUpload(w http.ResponseWriter, r *http.Req
 		UploadId string   `xml:"UploadId"`
 	}
 
+	if algo != "" {
+		w.Header().Set("X-Amz-Checksum-Algorithm", algo)
+	}
 	writeXML(w, http.StatusOK, initResult{
 		Xmlns:    "http://s3.amazonaws.com/doc/2006-03-01/",
 		Bucket:   bucket,
@@ -76,7 +87,7 @@ func (h *ObjectHandler) CreateMultipartUpload(w http.ResponseWriter, r *http.Req
 
 // UploadPart handles PUT /{bucket}/{key}?partNumber=N&uploadId=X.
 func (h *ObjectHandler) UploadPart(w http.ResponseWriter, r *http.Request, bucket, key, uploadID string) {
-	_, err := h.store.GetMultipartUpload(uploadID)
+	upload, err := h.store.GetMultipartUpload(uploadID)
 	if err != nil {
 		writeS3Error(w, "NoSuchUpload", "Upload not found", http.StatusNotFound)
 		return
@@ -97,34 +108,99 @@ func (h *ObjectHandler) UploadPart(w http.ResponseWriter, r *http.Request, bucke
 	}
 	r.Body = http.MaxBytesReader(w, r.Body, maxPartSize)
 
-	partPath := filepath.Join(h.multipartDir(uploadID), fmt.Sprintf("part-%05d", partNum))
-	f, err := os.Create(partPath)
+	// Charge the part against the bucket quota before writing it. A part
+	// replacing an earlier upload of the same number only needs the difference.
+	previous := h.storedPartSize(uploadID, partNum)
+	release, ok := h.reservePart(w, upload.Bucket, r.ContentLength-previous)
+	if !ok {
+		return
+	}
+	defer release()
+
+	sums := newPartChecksums(r, upload.ChecksumAlgorithm)
+	tmpPath, written, ok := h.writePart(w, uploadID, r.Body, sums)
+	if !ok {
+		return
+	}
+	defer os.Remove(tmpPath)
+
+	// Without a Content-Length the size is only known now
+	if r.ContentLength < 0 {
+		h.quota.mu.Lock()
+		ok := h.checkQuotaLocked(w, upload.Bucket, written-previous, false)
+		h.quota.mu.Unlock()
+		if !ok {
+			return
+		}
+	}
+
+	part := metadata.PartInfo{
+		PartNumber:   partNum,
+		Size:         written,
+		LastModified: time.Now().UTC().Unix(),
+	}
+	if !sums.verify(w, r, &part) {
+		return
+	}
+	if !h.checkFirstPart(w, r, upload, tmpPath, partNum) {
+		return
+	}
+	if !h.commitPart(w, uploadID, tmpPath, part) {
+		return
+	}
+
+	setPartChecksumHeaders(w, part)
+	w.Header().Set("ETag", part.ETag)
+	w.WriteHeader(http.StatusOK)
+}
+
+// checkFirstPart rejects a disallowed upload as soon as its first part
+// arrives. CompleteMultipartUpload checks again, since parts may be
+// replaced and the object may start with another part.
+func (h *ObjectHandler) checkFirstPart(w http.ResponseWriter, r *http.Request, upload *metadata.MultipartUpload, tmpPath string, partNum int) bool {
+	if partNum != 1 {
+		return true
+	}
+	_, ok := h.checkUploadFiles(w, r, upload.Bucket, upload.Key, upload.ContentType, tmpPath)
+	return ok
+}
+
+// writePart streams a part into a temporary file of the upload, hashing it on
+// the way. A rejected part is never renamed into place, so it cannot clobber
+// an earlier upload of the same part number.
+func (h *ObjectHandler) writePart(w http.ResponseWriter, uploadID string, src io.Reader, sums *partChecksums) (string, int64, bool) {
+	f, err := os.CreateTemp(h.multipartDir(uploadID), "part-*.tmp")
 	if err != nil {
 		slog.Error("internal error", "error", err)
 		writeS3Error(w, "InternalError", "An internal error occurred", http.StatusInternalServerError)
-		return
+		return "", 0, false
 	}
 	defer f.Close()
 
-	hash := md5.New()
-	written, err := io.Copy(f, io.TeeReader(r.Body, hash))
+	written, err := io.Copy(f, io.TeeReader(src, sums))
 	if err != nil {
-		os.Remove(partPath)
+		os.Remove(f.Name())
 		slog.Error("internal error", "error", err)
 		writeS3Error(w, "InternalError", "An internal error occurred", http.StatusInternalServerError)
-		return
+		return "", 0, false
 	}
+	return f.Name(), written, true
+}
 
-	etag := fmt.Sprintf("\"%s\"", hex.EncodeToString(hash.Sum(nil)))
-
-	h.store.PutPart(uploadID, metadata.PartInfo{
-		PartNumber: partNum,
-		ETag:       etag,
-		Size:       written,
-	})
-
-	w.Header().Set("ETag", etag)
-	w.WriteHeader(http.StatusOK)
+// commitPart moves a verified part file into place and records the part.
+func (h *ObjectHandler) commitPart(w http.ResponseWriter, uploadID, tmpPath string, part metadata.PartInfo) bool {
+	partPath := filepath.Join(h.multipartDir(uploadID), fmt.Sprintf("part-%05d", part.PartNumber))
+	if err := os.Rename(tmpPath, partPath); err != nil {
+		slog.Error("internal error", "error", err)
+		writeS3Error(w, "InternalError", "An internal error occurred", http.StatusInternalServerError)
+		return false
+	}
+	if err := h.store.PutPart(uploadID, part); err != nil {
+		slog.Error("internal error", "error", err)
+		writeS3Error(w, "InternalError", "An internal error occurred", http.StatusInternalServerError)
+		return false
+	}
+	return true
 }
 
 // CompleteMultipartUpload handles POST /{bucket}/{key}?uploadId=X.
@@ -135,19 +211,19 @@ func (h *ObjectHandler) CompleteMultipartUpload(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	// Check quota (estimate size from parts)
-	parts, _ := h.store.ListParts(uploadID)
-	var estimatedSize int64
-	for _, p := range parts {
-		estimatedSize += p.Size
-	}
-	if !h.checkQuota(w, bucket, estimatedSize) {
+	// Part bytes were charged to the quota as they were uploaded; completing
+	// only moves them into the object, so just the object count is checked.
+	if !h.checkQuota(w, bucket, 0) {
 		return
 	}
 
 	type completePart struct {
-		PartNumber int    `xml:"PartNumber"`
-		ETag       string `xml:"ETag"`
+		PartNumber     int    `xml:"PartNumber"`
+		ETag           string `xml:"ETag"`
+		ChecksumCRC32  string `xml:"ChecksumCRC32"`
+		ChecksumCRC32C string `xml:"ChecksumCRC32C"`
+		ChecksumSHA1   string `xml:"ChecksumSHA1"`
+		ChecksumSHA256 string `xml:"ChecksumSHA256"`
 	}
 	type completeRequest struct {
 		XMLName xml.Name       `xml:"CompleteMultipartUpload"`
@@ -160,9 +236,59 @@ func (h *ObjectHandler) CompleteMultipartUpload(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	sort.Slice(req.Parts, func(i, j int) bool {
-		return req.Parts[i].PartNumber < req.Parts[j].PartNumber
-	})
+	if len(req.Parts) == 0 {
+		writeS3Error(w, "MalformedXML", "The request must list at least one part", http.StatusBadRequest)
+		return
+	}
+
+	stored, err := h.store.ListParts(uploadID)
+	if err != nil {
+		slog.Error("internal error", "error", err)
+		writeS3Error(w, "InternalError", "An internal error occurred", http.StatusInternalServerError)
+		return
+	}
+	byNumber := make(map[int]metadata.PartInfo, len(stored))
+	for _, p := range stored {
+		byNumber[p.PartNumber] = p
+	}
+
+	// Every listed part must have been uploaded with the ETag and checksums
+	// the client gives for it, in ascending part number order.
+	parts := make([]metadata.PartInfo, 0, len(req.Parts))
+	for i, cp := range req.Parts {
+		if i > 0 && cp.PartNumber <= req.Parts[i-1].PartNumber {
+			writeS3Error(w, "InvalidPartOrder", "The list of parts was not in ascending order", http.StatusBadRequest)
+			return
+		}
+		p, ok := byNumber[cp.PartNumber]
+		if !ok || strings.Trim(cp.ETag, `"`) != strings.Trim(p.ETag, `"`) {
+			writeS3Error(w, "InvalidPart", fmt.Sprintf("Part %d was not uploaded or its ETag does not match", cp.PartNumber), http.StatusBadRequest)
+			return
+		}
+		given := metadata.PartInfo{
+			ChecksumCRC32:  cp.ChecksumCRC32,
+			ChecksumCRC32C: cp.ChecksumCRC32C,
+			ChecksumSHA1:   cp.ChecksumSHA1,
+			ChecksumSHA256: cp.ChecksumSHA256,
+		}
+		for _, algo := range checksumAlgorithms {
+			if v := partChecksum(given, algo); v != "" && v != partChecksum(p, algo) {
+				writeS3Error(w, "InvalidPart", fmt.Sprintf("Checksum %s of part %d does not match", algo, cp.PartNumber), http.StatusBadRequest)
+				return
+			}
+		}
+		parts = append(parts, p)
+	}
+
+	// Enforce the bucket's upload policy on the start of the assembled object
+	partPaths := make([]string, len(parts))
+	for i, part := range parts {
+		partPaths[i] = filepath.Join(h.multipartDir(uploadID), fmt.Sprintf("part-%05d", part.PartNumber))
+	}
+	ct, ok := h.checkUploadFiles(w, r, bucket, key, upload.ContentType, partPaths...)
+	if !ok {
+		return
+	}
 
 	// Assemble the final object
 	objPath := h.engine.ObjectPath(bucket, key)
@@ -185,9 +311,8 @@ func (h *ObjectHandler) CompleteMultipartUpload(w http.ResponseWriter, r *http.R
 	combinedHash := md5.New()
 	var partBoundaries []int64
 
-	for _, part := range req.Parts {
-		partPath := filepath.Join(h.multipartDir(uploadID), fmt.Sprintf("part-%05d", part.PartNumber))
-		pf, err := os.Open(partPath)
+	for i, part := range parts {
+		pf, err := os.Open(partPaths[i])
 		if err != nil {
 			os.Remove(objPath)
 			writeS3Error(w, "InvalidPart", fmt.Sprintf("Part %d not found", part.PartNumber), http.StatusBadRequest)
@@ -210,56 +335,71 @@ func (h *ObjectHandler) CompleteMultipartUpload(w http.ResponseWriter, r *http.R
 	}
 
 	// S3 multipart ETag: md5(md5(part1) + md5(part2) + ...)-N
-	etag := fmt.Sprintf("\"%s-%d\"", hex.EncodeToString(combinedHash.Sum(nil)), len(req.Parts))
+	etag := fmt.Sprintf("\"%s-%d\"", hex.EncodeToString(combinedHash.Sum(nil)), len(parts))
+
+	// The object checksum is the composite of its part checksums: the
+	// upload's algorithm, or any algorithm every part was uploaded with.
+	var composite metadata.PartInfo
+	for _, algo := range checksumAlgorithms {
+		if upload.ChecksumAlgorithm == "" || algo == upload.ChecksumAlgorithm {
+			setPartChecksum(&composite, algo, compositeChecksum(algo, parts))
+		}
+	}
 
 	now := time.Now().UTC()
 
-	h.store.PutObjectMeta(metadata.ObjectMeta{
+	event, _ := h.putObjectMeta(r, notify.EventObjectCreatedCompleteMultipartUpload, metadata.ObjectMeta{
 		Bucket:         bucket,
 		Key:            key,
-		ContentType:    upload.ContentType,
+		ContentType:    ct,
 		ETag:           etag,
 		Size:           totalSize,
 		LastModified:   now.Unix(),
-		PartsCount:     len(req.Parts),
+		PartsCount:     len(parts),
 		PartBoundaries: partBoundaries,
-	})
+		Parts:          parts,
+		ChecksumCRC32:  composite.ChecksumCRC32,
+		ChecksumCRC32C: composite.ChecksumCRC32C,
+		ChecksumSHA1:   composite.ChecksumSHA1,
+		ChecksumSHA256: composite.ChecksumSHA256,
+		ScanStatus:     h.initialScanStatus(bucket),
+	}, "")
 
 	// Clean up
 	os.RemoveAll(h.multipartDir(uploadID))
 	h.store.DeleteMultipartUpload(uploadID)
 
 	type completeResult struct {
-		XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
-		Xmlns    string   `xml:"xmlns,attr"`
-		Location string   `xml:"Location"`
-		Bucket   string   `xml:"Bucket"`
-		Key      string   `xml:"Key"`
-		ETag     string   `xml:"ETag"`
+		XMLName        xml.Name `xml:"CompleteMultipartUploadResult"`
+		Xmlns          string   `xml:"xmlns,attr"`
+		Location       string   `xml:"Location"`
+		Bucket         string   `xml:"Bucket"`
+		Key            string   `xml:"Key"`
+		ETag           string   `xml:"ETag"`
+		ChecksumCRC32  string   `xml:"ChecksumCRC32,omitempty"`
+		ChecksumCRC32C string   `xml:"ChecksumCRC32C,omitempty"`
+		ChecksumSHA1   string   `xml:"ChecksumSHA1,omitempty"`
+		ChecksumSHA256 string   `xml:"ChecksumSHA256,omitempty"`
 	}
 
 	writeXML(w, http.StatusOK, completeResult{
-		Xmlns:    "http://s3.amazonaws.com/doc/2006-03-01/",
-		Location: fmt.Sprintf("/%s/%s", bucket, key),
-		Bucket:   bucket,
-		Key:      key,
-		ETag:     etag,
+		Xmlns:          "http://s3.amazonaws.com/doc/2006-03-01/",
+		Location:       fmt.Sprintf("/%s/%s", bucket, key),
+		Bucket:         bucket,
+		Key:            key,
+		ETag:           etag,
+		ChecksumCRC32:  composite.ChecksumCRC32,
+		ChecksumCRC32C: composite.ChecksumCRC32C,
+		ChecksumSHA1:   composite.ChecksumSHA1,
+		ChecksumSHA256: composite.ChecksumSHA256,
 	})
-	if h.onNotification != nil {
-		h.onNotification("s3:ObjectCreated:CompleteMultipartUpload", bucket, key, totalSize, etag, "")
-	}
+	h.dispatchEvent(r, event)
 	if h.onReplication != nil {
 		h.onReplication("s3:ObjectCreated:CompleteMultipartUpload", bucket, key, totalSize, etag, "")
 	}
-	if h.onLambda != nil {
-		h.onLambda("s3:ObjectCreated:CompleteMultipartUpload", bucket, key, totalSize, etag, "")
-	}
 	if h.onScan != nil {
 		h.onScan(bucket, key, totalSize)
 	}
-	if h.onSearchUpdate != nil {
-		h.onSearchUpdate("put", bucket, key)
-	}
 }
 
 // AbortMultipartUpload handles DELETE /{bucket}/{key}?uploadId=X.
@@ -286,7 +426,7 @@ func (h *ObjectHandler) multipartDir(uploadID string) string {
 
 // UploadPartCopy handles PUT /{bucket}/{key}?partNumber=N&uploadId=X with X-Amz-Copy-Source.
 func (h *ObjectHandler) UploadPartCopy(w http.ResponseWriter, r *http.Request, bucket, key, uploadID string) {
-	_, err := h.store.GetMultipartUpload(uploadID)
+	upload, err := h.store.GetMultipartUpload(uploadID)
 	if err != nil {
 		writeS3Error(w, "NoSuchUpload", "Upload not found", http.StatusNotFound)
 		return
@@ -320,6 +460,14 @@ func (h *ObjectHandler) UploadPartCopy(w http.ResponseWriter, r *http.Request, b
 		return
 	}
 
+	if srcMeta, err := h.store.GetObjectMeta(srcBucket, srcKey); err == nil && isArchived(srcMeta) {
+		writeS3Error(w, "InvalidObjectState", "The source object must be restored before it can be copied", http.StatusForbidden)
+		return
+	} else if scanPending(srcMeta) {
+		writeScanPending(w, srcMeta)
+		return
+	}
+
 	// Read source object
 	reader, srcSize, err := h.engine.GetObject(srcBucket, srcKey)
 	if err != nil {
@@ -360,44 +508,52 @@ func (h *ObjectHandler) UploadPartCopy(w http.ResponseWriter, r *http.Request, b
 		dataReader = io.LimitReader(reader, copySize)
 	}
 
-	// Write to part file
-	partPath := filepath.Join(h.multipartDir(uploadID), fmt.Sprintf("part-%05d", partNum))
-	f, err := os.Create(partPath)
-	if err != nil {
-		slog.Error("internal error", "error", err)
-		writeS3Error(w, "InternalError", "An internal error occurred", http.StatusInternalServerError)
+	release, ok := h.reservePart(w, upload.Bucket, copySize-h.storedPartSize(uploadID, partNum))
+	if !ok {
 		return
 	}
-	defer f.Close()
+	defer release()
 
-	hash := md5.New()
-	written, err := io.Copy(f, io.TeeReader(dataReader, hash))
-	if err != nil {
-		os.Remove(partPath)
-		slog.Error("internal error", "error", err)
-		writeS3Error(w, "InternalError", "An internal error occurred", http.StatusInternalServerError)
+	sums := newPartChecksums(r, upload.ChecksumAlgorithm)
+	tmpPath, written, ok := h.writePart(w, uploadID, dataReader, sums)
+	if !ok {
 		return
 	}
-
-	etag := fmt.Sprintf("\"%s\"", hex.EncodeToString(hash.Sum(nil)))
-
-	h.store.PutPart(uploadID, metadata.PartInfo{
-		PartNumber: partNum,
-		ETag:       etag,
-		Size:       written,
-	})
+	defer os.Remove(tmpPath)
 
 	now := time.Now().UTC()
+	part := metadata.PartInfo{
+		PartNumber:   partNum,
+		Size:         written,
+		LastModified: now.Unix(),
+	}
+	if !sums.verify(w, r, &part) {
+		return
+	}
+	if !h.checkFirstPart(w, r, upload, tmpPath, partNum) {
+		return
+	}
+	if !h.commitPart(w, uploadID, tmpPath, part) {
+		return
+	}
 
 	type copyPartResult struct {
-		XMLName      xml.Name `xml:"CopyPartResult"`
-		ETag         string   `xml:"ETag"`
-		LastModified string   `xml:"LastModified"`
+		XMLName        xml.Name `xml:"CopyPartResult"`
+		ETag           string   `xml:"ETag"`
+		LastModified   string   `xml:"LastModified"`
+		ChecksumCRC32  string   `xml:"ChecksumCRC32,omitempty"`
+		ChecksumCRC32C string   `xml:"ChecksumCRC32C,omitempty"`
+		ChecksumSHA1   string   `xml:"ChecksumSHA1,omitempty"`
+		ChecksumSHA256 string   `xml:"ChecksumSHA256,omitempty"`
 	}
 
 	writeXML(w, http.StatusOK, copyPartResult{
-		ETag:         etag,
-		LastModified: now.Format(time.RFC3339),
+		ETag:           part.ETag,
+		LastModified:   now.Format(time.RFC3339),
+		ChecksumCRC32:  part.ChecksumCRC32,
+		ChecksumCRC32C: part.ChecksumCRC32C,
+		ChecksumSHA1:   part.ChecksumSHA1,
+		ChecksumSHA256: part.ChecksumSHA256,
 	})
 }
 
@@ -409,83 +565,228 @@ func generateUploadID() string {
 	return hex.EncodeToString(b)
 }
 
+// maxListParts is the largest page ListParts and GetObjectAttributes return.
+const maxListParts = 1000
+
 // ListMultipartUploads handles GET /{bucket}?uploads.
 func (h *ObjectHandler) ListMultipartUploads(w http.ResponseWriter, r *http.Request, bucket string) {
-	uploads, err := h.store.ListMultipartUploads(bucket)
+	q := r.URL.Query()
+	prefix := q.Get("prefix")
+	keyMarker := q.Get("key-marker")
+	uploadIDMarker := q.Get("upload-id-marker")
+	maxUploads, ok := parseMaxKeys(w, q.Get("max-uploads"), "max-uploads")
+	if !ok {
+		return
+	}
+
+	all, err := h.store.ListMultipartUploads(bucket)
 	if err != nil {
 		slog.Error("internal error", "error", err)
 		writeS3Error(w, "InternalError", "An internal error occurred", http.StatusInternalServerError)
 		return
 	}
 
+	// Uploads are listed by key, and by initiation time within a key.
+	var uploads []metadata.MultipartUpload
+	for _, u := range all {
+		if strings.HasPrefix(u.Key, prefix) {
+			uploads = append(uploads, u)
+		}
+	}
+	sort.Slice(uploads, func(i, j int) bool {
+		a, b := uploads[i], uploads[j]
+		if a.Key != b.Key {
+			return a.Key < b.Key
+		}
+		if a.CreatedAt != b.CreatedAt {
+			return a.CreatedAt < b.CreatedAt
+		}
+		return a.UploadID < b.UploadID
+	})
+
+	start := 0
+	if keyMarker != "" {
+		start = sort.Search(len(uploads), func(i int) bool { return uploads[i].Key > keyMarker })
+		if uploadIDMarker != "" {
+			for i, u := range uploads {
+				if u.Key == keyMarker && u.UploadID == uploadIDMarker {
+					start = i + 1
+					break
+				}
+			}
+		}
+	}
+	uploads = uploads[start:]
+
 	type xmlUpload struct {
-		Key       string `xml:"Key"`
-		UploadID  string `xml:"UploadId"`
-		Initiated string `xml:"Initiated"`
+		Key               string `xml:"Key"`
+		UploadID          string `xml:"UploadId"`
+		Initiated         string `xml:"Initiated"`
+		StorageClass      string `xml:"StorageClass"`
+		ChecksumAlgorithm string `xml:"ChecksumAlgorithm,omitempty"`
 	}
 	type xmlResult struct {
-		XMLName xml.Name    `xml:"ListMultipartUploadsResult"`
-		Xmlns   string      `xml:"xmlns,attr"`
-		Bucket  string      `xml:"Bucket"`
-		Uploads []xmlUpload `xml:"Upload"`
+		XMLName            xml.Name    `xml:"ListMultipartUploadsResult"`
+		Xmlns              string      `xml:"xmlns,attr"`
+		Bucket             string      `xml:"Bucket"`
+		KeyMarker          string      `xml:"KeyMarker"`
+		UploadIDMarker     string      `xml:"UploadIdMarker"`
+		NextKeyMarker      string      `xml:"NextKeyMarker,omitempty"`
+		NextUploadIDMarker string      `xml:"NextUploadIdMarker,omitempty"`
+		Prefix             string      `xml:"Prefix"`
+		MaxUploads         int         `xml:"MaxUploads"`
+		IsTruncated        bool        `xml:"IsTruncated"`
+		Uploads            []xmlUpload `xml:"Upload"`
 	}
 	resp := xmlResult{
-		Xmlns:  "http://s3.amazonaws.com/doc/2006-03-01/",
-		Bucket: bucket,
+		Xmlns:          "http://s3.amazonaws.com/doc/2006-03-01/",
+		Bucket:         bucket,
+		KeyMarker:      keyMarker,
+		UploadIDMarker: uploadIDMarker,
+		Prefix:         prefix,
+		MaxUploads:     maxUploads,
+	}
+	if len(uploads) > maxUploads {
+		uploads = uploads[:maxUploads]
+		resp.IsTruncated = true
 	}
 	for _, u := range uploads {
 		resp.Uploads = append(resp.Uploads, xmlUpload{
-			Key:       u.Key,
-			UploadID:  u.UploadID,
-			Initiated: time.Unix(u.CreatedAt, 0).UTC().Format(time.RFC3339),
+			Key:               u.Key,
+			UploadID:          u.UploadID,
+			Initiated:         time.Unix(u.CreatedAt, 0).UTC().Format(time.RFC3339),
+			StorageClass:      "STANDARD",
+			ChecksumAlgorithm: u.ChecksumAlgorithm,
 		})
 	}
+	if resp.IsTruncated && len(uploads) > 0 {
+		last := uploads[len(uploads)-1]
+		resp.NextKeyMarker = last.Key
+		resp.NextUploadIDMarker = last.UploadID
+	}
 	writeXML(w, http.StatusOK, resp)
 }
 
 // ListParts handles GET /{bucket}/{key}?uploadId=X.
 func (h *ObjectHandler) ListParts(w http.ResponseWriter, r *http.Request, bucket, key, uploadID string) {
-	_, err := h.store.GetMultipartUpload(uploadID)
+	upload, err := h.store.GetMultipartUpload(uploadID)
 	if err != nil {
 		writeS3Error(w, "NoSuchUpload", "Upload not found", http.StatusNotFound)
 		return
 	}
 
+	q := r.URL.Query()
+	maxParts, marker, ok := parsePartPaging(w, q.Get("max-parts"), q.Get("part-number-marker"))
+	if !ok {
+		return
+	}
+
 	parts, err := h.store.ListParts(uploadID)
 	if err != nil {
 		slog.Error("internal error", "error", err)
 		writeS3Error(w, "InternalError", "An internal error occurred", http.StatusInternalServerError)
 		return
 	}
+	page, truncated := pageParts(parts, marker, maxParts)
 
 	type xmlPart struct {
-		PartNumber   int    `xml:"PartNumber"`
-		Size         int64  `xml:"Size"`
-		ETag         string `xml:"ETag"`
-		LastModified string `xml:"LastModified"`
+		PartNumber     int    `xml:"PartNumber"`
+		Size           int64  `xml:"Size"`
+		ETag           string `xml:"ETag"`
+		LastModified   string `xml:"LastModified"`
+		ChecksumCRC32  string `xml:"ChecksumCRC32,omitempty"`
+		ChecksumCRC32C string `xml:"ChecksumCRC32C,omitempty"`
+		ChecksumSHA1   string `xml:"ChecksumSHA1,omitempty"`
+		ChecksumSHA256 string `xml:"ChecksumSHA256,omitempty"`
 	}
 	type xmlResult struct {
-		XMLName  xml.Name  `xml:"ListPartsResult"`
-		Xmlns    string    `xml:"xmlns,attr"`
-		Bucket   string    `xml:"Bucket"`
-		Key      string    `xml:"Key"`
-		UploadID string    `xml:"UploadId"`
-		Parts    []xmlPart `xml:"Part"`
+		XMLName              xml.Name  `xml:"ListPartsResult"`
+		Xmlns                string    `xml:"xmlns,attr"`
+		Bucket               string    `xml:"Bucket"`
+		Key                  string    `xml:"Key"`
+		UploadID             string    `xml:"UploadId"`
+		StorageClass         string    `xml:"StorageClass"`
+		ChecksumAlgorithm    string    `xml:"ChecksumAlgorithm,omitempty"`
+		PartNumberMarker     int       `xml:"PartNumberMarker"`
+		NextPartNumberMarker int       `xml:"NextPartNumberMarker,omitempty"`
+		MaxParts             int       `xml:"MaxParts"`
+		IsTruncated          bool      `xml:"IsTruncated"`
+		Parts                []xmlPart `xml:"Part"`
 	}
 	resp := xmlResult{
-		Xmlns:    "http://s3.amazonaws.com/doc/2006-03-01/",
-		Bucket:   bucket,
-		Key:      key,
-		UploadID: uploadID,
-	}
-	now := time.Now().UTC().Format(time.RFC3339)
-	for _, p := range parts {
+		Xmlns:             "http://s3.amazonaws.com/doc/2006-03-01/",
+		Bucket:            bucket,
+		Key:               key,
+		UploadID:          uploadID,
+		StorageClass:      "STANDARD",
+		ChecksumAlgorithm: upload.ChecksumAlgorithm,
+		PartNumberMarker:  marker,
+		MaxParts:          maxParts,
+		IsTruncated:       truncated,
+	}
+	for _, p := range page {
+		modified := p.LastModified
+		if modified == 0 {
+			modified = upload.CreatedAt
+		}
 		resp.Parts = append(resp.Parts, xmlPart{
-			PartNumber:   p.PartNumber,
-			Size:         p.Size,
-			ETag:         p.ETag,
-			LastModified: now,
+			PartNumber:     p.PartNumber,
+			Size:           p.Size,
+			ETag:           p.ETag,
+			LastModified:   time.Unix(modified, 0).UTC().Format(time.RFC3339),
+			ChecksumCRC32:  p.ChecksumCRC32,
+			ChecksumCRC32C: p.ChecksumCRC32C,
+			ChecksumSHA1:   p.ChecksumSHA1,
+			ChecksumSHA256: p.ChecksumSHA256,
 		})
 	}
+	if truncated && len(page) > 0 {
+		resp.NextPartNumberMarker = page[len(page)-1].PartNumber
+	}
 	writeXML(w, http.StatusOK, resp)
 }
+
+// parseMaxKeys parses a page size parameter, defaulting to and capped at
+// 1000. Returns false if an error was written.
+func parseMaxKeys(w http.ResponseWriter, v, name string) (int, bool) {
+	if v == "" {
+		return maxListParts, true
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		writeS3Error(w, "InvalidArgument", name+" must be a non-negative integer", http.StatusBadRequest)
+		return 0, false
+	}
+	if n > maxListParts {
+		n = maxListParts
+	}
+	return n, true
+}
+
+// parsePartPaging parses the max-parts and part-number-marker of a part
+// listing. Returns false if an error was written.
+func parsePartPaging(w http.ResponseWriter, maxStr, markerStr string) (maxParts, marker int, ok bool) {
+	if maxParts, ok = parseMaxKeys(w, maxStr, "max-parts"); !ok {
+		return 0, 0, false
+	}
+	if markerStr != "" {
+		n, err := strconv.Atoi(markerStr)
+		if err != nil || n < 0 {
+			writeS3Error(w, "InvalidArgument", "part-number-marker must be a non-negative integer", http.StatusBadRequest)
+			return 0, 0, false
+		}
+		marker = n
+	}
+	return maxParts, marker, true
+}
+
+// pageParts returns up to maxParts parts numbered after marker, and whether
+// more parts follow. parts must be sorted by part number.
+func pageParts(parts []metadata.PartInfo, marker, maxParts int) ([]metadata.PartInfo, bool) {
+	start := sort.Search(len(parts), func(i int) bool { return parts[i].PartNumber > marker })
+	parts = parts[start:]
+	if len(parts) > maxParts {
+		return parts[:maxParts], true
+	}
+	return parts, false
+}
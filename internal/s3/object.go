@@ -17,6 +17,7 @@ import (
 	"time"
 
 	"github.com/eniz1806/VaultS3/internal/metadata"
+	"github.com/eniz1806/VaultS3/internal/notify"
 	"github.com/eniz1806/VaultS3/internal/storage"
 )
 
@@ -25,103 +26,15 @@ type ObjectHandler struct {
 	engine            storage.Engine
 	encryptionEnabled bool
 	onNotification    NotificationFunc
+	onPlan            NotificationPlanFunc
 	onReplication     ReplicationFunc
 	onScan            ScanFunc
-	onSearchUpdate    SearchUpdateFunc
 	onLambda          LambdaFunc
+	onRestore         RestoreFunc
+	onRemove          RemoveFunc
+	onAudit           AuditFunc
 	accessUpdater     *metadata.AccessUpdater
-}
-
-// checkQuota verifies bucket quota limits before writing.
-// If FIFOQuota is enabled, oldest objects are deleted to make room.
-func (h *ObjectHandler) checkQuota(w http.ResponseWriter, bucket string, incomingSize int64) bool {
-	info, err := h.store.GetBucket(bucket)
-	if err != nil {
-		return true // no bucket info, allow
-	}
-	if info.MaxSizeBytes == 0 && info.MaxObjects == 0 {
-		return true // no limits
-	}
-
-	currentSize, currentCount, _ := h.engine.BucketSize(bucket)
-
-	if info.FIFOQuota {
-		// FIFO: delete oldest objects to make room
-		if info.MaxObjects > 0 && currentCount >= info.MaxObjects {
-			h.fifoEvict(bucket, 1, 0)
-		}
-		if info.MaxSizeBytes > 0 && incomingSize > 0 && currentSize+incomingSize > info.MaxSizeBytes {
-			needed := currentSize + incomingSize - info.MaxSizeBytes
-			h.fifoEvict(bucket, 0, needed)
-		}
-		return true
-	}
-
-	if info.MaxObjects > 0 && currentCount >= info.MaxObjects {
-		writeS3Error(w, "QuotaExceeded", "Maximum object count exceeded", http.StatusForbidden)
-		return false
-	}
-	if info.MaxSizeBytes > 0 && incomingSize > 0 && currentSize+incomingSize > info.MaxSizeBytes {
-		writeS3Error(w, "QuotaExceeded", "Maximum bucket size exceeded", http.StatusForbidden)
-		return false
-	}
-
-	return true
-}
-
-// fifoEvict deletes oldest objects until count or size requirements are met.
-func (h *ObjectHandler) fifoEvict(bucket string, countToFree int64, bytesToFree int64) {
-	objects, _, err := h.engine.ListObjects(bucket, "", "", 10000)
-	if err != nil || len(objects) == 0 {
-		return
-	}
-
-	// Objects from ListObjects are typically in alphabetical order.
-	// Sort by modified time to find oldest.
-	type objMeta struct {
-		key  string
-		size int64
-		mod  time.Time
-	}
-	var metas []objMeta
-	for _, obj := range objects {
-		meta, err := h.store.GetObjectMeta(bucket, obj.Key)
-		if err != nil {
-			continue
-		}
-		metas = append(metas, objMeta{key: obj.Key, size: meta.Size, mod: time.Unix(0, meta.LastModified)})
-	}
-	// Sort oldest first
-	for i := 0; i < len(metas); i++ {
-		for j := i + 1; j < len(metas); j++ {
-			if metas[j].mod.Before(metas[i].mod) {
-				metas[i], metas[j] = metas[j], metas[i]
-			}
-		}
-	}
-
-	var freedCount int64
-	var freedBytes int64
-	for _, m := range metas {
-		if countToFree > 0 && freedCount >= countToFree && bytesToFree <= 0 {
-			break
-		}
-		if bytesToFree > 0 && freedBytes >= bytesToFree && countToFree <= 0 {
-			break
-		}
-		if countToFree > 0 && freedCount >= countToFree && bytesToFree > 0 && freedBytes >= bytesToFree {
-			break
-		}
-
-		h.engine.DeleteObject(bucket, m.key)
-		h.store.DeleteObjectMeta(bucket, m.key)
-		freedCount++
-		freedBytes += m.size
-
-		if h.onSearchUpdate != nil {
-			h.onSearchUpdate("delete", bucket, m.key)
-		}
-	}
+	quota             *quotaState
 }
 
 // generateVersionID creates a unique version ID using timestamp + random bytes.
@@ -194,13 +107,19 @@ func (h *ObjectHandler) PutObject(w http.ResponseWriter, r *http.Request, bucket
 		return
 	}
 
+	// Enforce the bucket's upload policy on the real type of the content
+	ct, ok := h.checkUploadType(w, r, bucket, key, body, detectContentType(r, key))
+	if !ok {
+		return
+	}
+
 	versioning, _ := h.store.GetBucketVersioning(bucket)
-	ct := detectContentType(r, key)
 	now := time.Now().UTC()
 
 	// Parse extended metadata from headers
 	userMeta := parseUserMetadata(r)
 	tags := parseInlineTags(r)
+	storageClass := uploadStorageClass(r)
 
 	if versioning == "Enabled" {
 		versionID := generateVersionID()
@@ -238,6 +157,8 @@ func (h *ObjectHandler) PutObject(w http.ResponseWriter, r *http.Request, bucket
 			ChecksumCRC32:      ccrc32,
 			ChecksumCRC32C:     ccrc32c,
 			ChecksumSHA1:       csha1,
+			StorageClass:       storageClass,
+			ScanStatus:         h.initialScanStatus(bucket),
 		}
 
 		// Apply inline retention
@@ -265,7 +186,7 @@ func (h *ObjectHandler) PutObject(w http.ResponseWriter, r *http.Request, bucket
 		}
 
 		h.store.PutObjectVersion(meta)
-		h.store.PutObjectMeta(meta) // update "latest pointer"
+		event, _ := h.putObjectMeta(r, notify.EventObjectCreatedPut, meta, versionID) // update "latest pointer"
 
 		w.Header().Set("ETag", etag)
 		w.Header().Set("X-Amz-Version-Id", versionID)
@@ -274,21 +195,13 @@ func (h *ObjectHandler) PutObject(w http.ResponseWriter, r *http.Request, bucket
 		}
 		setChecksumHeaders(w, &meta)
 		w.WriteHeader(http.StatusOK)
-		if h.onNotification != nil {
-			h.onNotification("s3:ObjectCreated:Put", bucket, key, written, etag, versionID)
-		}
+		h.dispatchEvent(r, event)
 		if h.onReplication != nil {
 			h.onReplication("s3:ObjectCreated:Put", bucket, key, written, etag, versionID)
 		}
-		if h.onLambda != nil {
-			h.onLambda("s3:ObjectCreated:Put", bucket, key, written, etag, versionID)
-		}
 		if h.onScan != nil {
 			h.onScan(bucket, key, written)
 		}
-		if h.onSearchUpdate != nil {
-			h.onSearchUpdate("put", bucket, key)
-		}
 		return
 	}
 
@@ -327,10 +240,12 @@ func (h *ObjectHandler) PutObject(w http.ResponseWriter, r *http.Request, bucket
 			ChecksumCRC32:      ccrc32,
 			ChecksumCRC32C:     ccrc32c,
 			ChecksumSHA1:       csha1,
+			StorageClass:       storageClass,
+			ScanStatus:         h.initialScanStatus(bucket),
 		}
 
 		h.store.PutObjectVersion(meta)
-		h.store.PutObjectMeta(meta)
+		event, _ := h.putObjectMeta(r, notify.EventObjectCreatedPut, meta, "null")
 
 		w.Header().Set("ETag", etag)
 		w.Header().Set("X-Amz-Version-Id", "null")
@@ -339,21 +254,13 @@ func (h *ObjectHandler) PutObject(w http.ResponseWriter, r *http.Request, bucket
 		}
 		setChecksumHeaders(w, &meta)
 		w.WriteHeader(http.StatusOK)
-		if h.onNotification != nil {
-			h.onNotification("s3:ObjectCreated:Put", bucket, key, written, etag, "null")
-		}
+		h.dispatchEvent(r, event)
 		if h.onReplication != nil {
 			h.onReplication("s3:ObjectCreated:Put", bucket, key, written, etag, "null")
 		}
-		if h.onLambda != nil {
-			h.onLambda("s3:ObjectCreated:Put", bucket, key, written, etag, "null")
-		}
 		if h.onScan != nil {
 			h.onScan(bucket, key, written)
 		}
-		if h.onSearchUpdate != nil {
-			h.onSearchUpdate("put", bucket, key)
-		}
 		return
 	}
 
@@ -383,9 +290,11 @@ func (h *ObjectHandler) PutObject(w http.ResponseWriter, r *http.Request, bucket
 		ChecksumCRC32:      ccrc32,
 		ChecksumCRC32C:     ccrc32c,
 		ChecksumSHA1:       csha1,
+		StorageClass:       storageClass,
+		ScanStatus:         h.initialScanStatus(bucket),
 	}
 
-	h.store.PutObjectMeta(meta)
+	event, _ := h.putObjectMeta(r, notify.EventObjectCreatedPut, meta, "")
 
 	w.Header().Set("ETag", etag)
 	if h.encryptionEnabled {
@@ -393,21 +302,13 @@ func (h *ObjectHandler) PutObject(w http.ResponseWriter, r *http.Request, bucket
 	}
 	setChecksumHeaders(w, &meta)
 	w.WriteHeader(http.StatusOK)
-	if h.onNotification != nil {
-		h.onNotification("s3:ObjectCreated:Put", bucket, key, written, etag, "")
-	}
+	h.dispatchEvent(r, event)
 	if h.onReplication != nil {
 		h.onReplication("s3:ObjectCreated:Put", bucket, key, written, etag, "")
 	}
-	if h.onLambda != nil {
-		h.onLambda("s3:ObjectCreated:Put", bucket, key, written, etag, "")
-	}
 	if h.onScan != nil {
 		h.onScan(bucket, key, written)
 	}
-	if h.onSearchUpdate != nil {
-		h.onSearchUpdate("put", bucket, key)
-	}
 }
 
 // GetObject handles GET /{bucket}/{key} with optional Range support and ?versionId.
@@ -437,6 +338,14 @@ func (h *ObjectHandler) GetObject(w http.ResponseWriter, r *http.Request, bucket
 			writeS3Error(w, "NoSuchKey", "Object is a delete marker", http.StatusNotFound)
 			return
 		}
+		if isArchived(meta) {
+			writeS3Error(w, "InvalidObjectState", "The operation is not valid for the object's storage class", http.StatusForbidden)
+			return
+		}
+		if scanPending(meta) {
+			writeScanPending(w, meta)
+			return
+		}
 		reader, size, err = h.engine.GetObjectVersion(bucket, key, versionID)
 		if err != nil {
 			writeS3Error(w, "NoSuchKey", "Object not found", http.StatusNotFound)
@@ -454,6 +363,14 @@ func (h *ObjectHandler) GetObject(w http.ResponseWriter, r *http.Request, bucket
 			writeS3Error(w, "NoSuchKey", "Object not found", http.StatusNotFound)
 			return
 		}
+		if isArchived(meta) {
+			writeS3Error(w, "InvalidObjectState", "The operation is not valid for the object's storage class", http.StatusForbidden)
+			return
+		}
+		if scanPending(meta) {
+			writeScanPending(w, meta)
+			return
+		}
 
 		if meta != nil && meta.VersionID != "" {
 			// Versioned bucket — read from version storage
@@ -481,6 +398,9 @@ func (h *ObjectHandler) GetObject(w http.ResponseWriter, r *http.Request, bucket
 		setHTTPMetadataHeaders(w, meta)
 		setUserMetadataHeaders(w, meta)
 		setChecksumHeaders(w, meta)
+		setRestoreHeader(w, meta)
+		setStorageClassHeader(w, meta)
+		setScanStatusHeader(w, meta)
 		if meta.PartsCount > 0 {
 			w.Header().Set("X-Amz-Mp-Parts-Count", strconv.Itoa(meta.PartsCount))
 		}
@@ -614,6 +534,28 @@ func (h *ObjectHandler) serveRange(w http.ResponseWriter, reader storage.ReadSee
 	io.CopyN(w, reader, length)
 }
 
+// removeData deletes the data of an object, or of one of its versions when
+// versionID is set. Data moved out of the hot tier is removed through the
+// tiering callback, which also drops restored copies and pending restores.
+func (h *ObjectHandler) removeData(bucket, key, versionID string) error {
+	if h.onRemove != nil {
+		var meta *metadata.ObjectMeta
+		var err error
+		if versionID != "" {
+			meta, err = h.store.GetObjectVersion(bucket, key, versionID)
+		} else {
+			meta, err = h.store.GetObjectMeta(bucket, key)
+		}
+		if err == nil && isArchiveTier(meta) {
+			return h.onRemove(*meta)
+		}
+	}
+	if versionID != "" {
+		return h.engine.DeleteObjectVersion(bucket, key, versionID)
+	}
+	return h.engine.DeleteObject(bucket, key)
+}
+
 // DeleteObject handles DELETE /{bucket}/{key}.
 func (h *ObjectHandler) DeleteObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
 	if !h.store.BucketExists(bucket) {
@@ -633,34 +575,16 @@ func (h *ObjectHandler) DeleteObject(w http.ResponseWriter, r *http.Request, buc
 			return
 		}
 
-		h.engine.DeleteObjectVersion(bucket, key, versionID)
-		h.store.DeleteObjectVersion(bucket, key, versionID)
-
-		// If we deleted the latest, find the new latest
-		versions, _, _ := h.store.ListObjectVersions(bucket, key, "", "", 1)
-		if len(versions) > 0 {
-			// There's still a version — make it latest
-			versions[0].IsLatest = true
-			h.store.UpdateObjectVersionMeta(versions[0])
-		} else {
-			// No versions left — remove from objects bucket
-			h.store.DeleteObjectMeta(bucket, key)
-		}
+		h.removeData(bucket, key, versionID)
+		// If we deleted the latest, the newest remaining version becomes latest
+		h.store.RemoveObjectVersion(bucket, key, versionID, "")
 
 		w.Header().Set("X-Amz-Version-Id", versionID)
 		w.WriteHeader(http.StatusNoContent)
-		if h.onNotification != nil {
-			h.onNotification("s3:ObjectRemoved:Delete", bucket, key, 0, "", versionID)
-		}
+		h.emitEvent(r, notify.EventObjectRemovedDelete, bucket, key, 0, "", versionID)
 		if h.onReplication != nil {
 			h.onReplication("s3:ObjectRemoved:Delete", bucket, key, 0, "", versionID)
 		}
-		if h.onLambda != nil {
-			h.onLambda("s3:ObjectRemoved:Delete", bucket, key, 0, "", versionID)
-		}
-		if h.onSearchUpdate != nil {
-			h.onSearchUpdate("delete", bucket, key)
-		}
 		return
 	}
 
@@ -683,30 +607,22 @@ func (h *ObjectHandler) DeleteObject(w http.ResponseWriter, r *http.Request, buc
 			LastModified: time.Now().UTC().Unix(),
 		}
 		h.store.PutObjectVersion(dm)
-		h.store.PutObjectMeta(dm) // latest pointer now points to delete marker
+		event, _ := h.putObjectMeta(r, notify.EventObjectRemovedDeleteMarkerCreated, dm, dmVersionID) // latest pointer now points to delete marker
 
 		w.Header().Set("X-Amz-Delete-Marker", "true")
 		w.Header().Set("X-Amz-Version-Id", dmVersionID)
 		w.WriteHeader(http.StatusNoContent)
-		if h.onNotification != nil {
-			h.onNotification("s3:ObjectRemoved:Delete", bucket, key, 0, "", dmVersionID)
-		}
+		h.dispatchEvent(r, event)
 		if h.onReplication != nil {
 			h.onReplication("s3:ObjectRemoved:Delete", bucket, key, 0, "", dmVersionID)
 		}
-		if h.onLambda != nil {
-			h.onLambda("s3:ObjectRemoved:Delete", bucket, key, 0, "", dmVersionID)
-		}
-		if h.onSearchUpdate != nil {
-			h.onSearchUpdate("delete", bucket, key)
-		}
 		return
 	}
 
 	if versioning == "Suspended" {
 		// Suspended versioning: create a null-version delete marker
 		// Remove existing null version if any
-		h.engine.DeleteObjectVersion(bucket, key, "null")
+		h.removeData(bucket, key, "null")
 		h.store.DeleteObjectVersion(bucket, key, "null")
 
 		dm := metadata.ObjectMeta{
@@ -718,47 +634,31 @@ func (h *ObjectHandler) DeleteObject(w http.ResponseWriter, r *http.Request, buc
 			LastModified: time.Now().UTC().Unix(),
 		}
 		h.store.PutObjectVersion(dm)
-		h.store.PutObjectMeta(dm)
+		event, _ := h.putObjectMeta(r, notify.EventObjectRemovedDeleteMarkerCreated, dm, "null")
 
 		w.Header().Set("X-Amz-Delete-Marker", "true")
 		w.Header().Set("X-Amz-Version-Id", "null")
 		w.WriteHeader(http.StatusNoContent)
-		if h.onNotification != nil {
-			h.onNotification("s3:ObjectRemoved:Delete", bucket, key, 0, "", "null")
-		}
+		h.dispatchEvent(r, event)
 		if h.onReplication != nil {
 			h.onReplication("s3:ObjectRemoved:Delete", bucket, key, 0, "", "null")
 		}
-		if h.onLambda != nil {
-			h.onLambda("s3:ObjectRemoved:Delete", bucket, key, 0, "", "null")
-		}
-		if h.onSearchUpdate != nil {
-			h.onSearchUpdate("delete", bucket, key)
-		}
 		return
 	}
 
 	// Non-versioned: delete normally
-	if err := h.engine.DeleteObject(bucket, key); err != nil {
+	if err := h.removeData(bucket, key, ""); err != nil {
 		slog.Error("internal error", "error", err)
 		writeS3Error(w, "InternalError", "An internal error occurred", http.StatusInternalServerError)
 		return
 	}
 
-	h.store.DeleteObjectMeta(bucket, key)
+	event, _ := h.deleteObjectMeta(r, notify.EventObjectRemovedDelete, bucket, key)
 	w.WriteHeader(http.StatusNoContent)
-	if h.onNotification != nil {
-		h.onNotification("s3:ObjectRemoved:Delete", bucket, key, 0, "", "")
-	}
+	h.dispatchEvent(r, event)
 	if h.onReplication != nil {
 		h.onReplication("s3:ObjectRemoved:Delete", bucket, key, 0, "", "")
 	}
-	if h.onLambda != nil {
-		h.onLambda("s3:ObjectRemoved:Delete", bucket, key, 0, "", "")
-	}
-	if h.onSearchUpdate != nil {
-		h.onSearchUpdate("delete", bucket, key)
-	}
 }
 
 // checkObjectLock checks if an object version is locked (legal hold or retention).
@@ -857,6 +757,9 @@ func (h *ObjectHandler) HeadObject(w http.ResponseWriter, r *http.Request, bucke
 	setHTTPMetadataHeaders(w, meta)
 	setUserMetadataHeaders(w, meta)
 	setChecksumHeaders(w, meta)
+	setRestoreHeader(w, meta)
+	setStorageClassHeader(w, meta)
+	setScanStatusHeader(w, meta)
 	if meta.PartsCount > 0 {
 		w.Header().Set("X-Amz-Mp-Parts-Count", strconv.Itoa(meta.PartsCount))
 	}
@@ -919,6 +822,15 @@ func (h *ObjectHandler) CopyObject(w http.ResponseWriter, r *http.Request, bucke
 		return
 	}
 
+	if isArchived(srcMeta) {
+		writeS3Error(w, "InvalidObjectState", "The source object must be restored before it can be copied", http.StatusForbidden)
+		return
+	}
+	if scanPending(srcMeta) {
+		writeScanPending(w, srcMeta)
+		return
+	}
+
 	// Read source object
 	reader, size, err := h.engine.GetObject(srcBucket, srcKey)
 	if err != nil {
@@ -927,8 +839,24 @@ func (h *ObjectHandler) CopyObject(w http.ResponseWriter, r *http.Request, bucke
 	}
 	defer reader.Close()
 
+	// Determine metadata: REPLACE uses request headers, COPY (default) uses source
+	metadataDirective := r.Header.Get("X-Amz-Metadata-Directive")
+	replace := strings.EqualFold(metadataDirective, "REPLACE")
+
+	// The destination bucket's upload policy applies to copies too
+	ct := "application/octet-stream"
+	if replace {
+		ct = detectContentType(r, key)
+	} else if srcMeta != nil {
+		ct = srcMeta.ContentType
+	}
+	ct, src, ok := h.checkUploadStream(w, r, bucket, key, reader, ct)
+	if !ok {
+		return
+	}
+
 	// Write to destination
-	written, etag, err := h.engine.PutObject(bucket, key, reader, size)
+	written, etag, err := h.engine.PutObject(bucket, key, src, size)
 	if err != nil {
 		slog.Error("internal error", "error", err)
 		writeS3Error(w, "InternalError", "An internal error occurred", http.StatusInternalServerError)
@@ -937,20 +865,18 @@ func (h *ObjectHandler) CopyObject(w http.ResponseWriter, r *http.Request, bucke
 
 	now := time.Now().UTC()
 
-	// Determine metadata: REPLACE uses request headers, COPY (default) uses source
-	metadataDirective := r.Header.Get("X-Amz-Metadata-Directive")
-
 	meta := metadata.ObjectMeta{
 		Bucket:       bucket,
 		Key:          key,
 		ETag:         etag,
 		Size:         written,
+		ContentType:  ct,
 		LastModified: now.Unix(),
+		ScanStatus:   h.initialScanStatus(bucket),
 	}
 
-	if strings.EqualFold(metadataDirective, "REPLACE") {
+	if replace {
 		// Use metadata from request headers
-		meta.ContentType = detectContentType(r, key)
 		meta.UserMetadata = parseUserMetadata(r)
 		meta.Tags = parseInlineTags(r)
 		meta.ContentEncoding = r.Header.Get("Content-Encoding")
@@ -960,7 +886,6 @@ func (h *ObjectHandler) CopyObject(w http.ResponseWriter, r *http.Request, bucke
 		meta.WebsiteRedirect = r.Header.Get("X-Amz-Website-Redirect-Location")
 	} else if srcMeta != nil {
 		// COPY (default): copy metadata from source
-		meta.ContentType = srcMeta.ContentType
 		meta.UserMetadata = srcMeta.UserMetadata
 		meta.Tags = srcMeta.Tags
 		meta.ContentEncoding = srcMeta.ContentEncoding
@@ -972,11 +897,9 @@ func (h *ObjectHandler) CopyObject(w http.ResponseWriter, r *http.Request, bucke
 		meta.ChecksumCRC32 = srcMeta.ChecksumCRC32
 		meta.ChecksumCRC32C = srcMeta.ChecksumCRC32C
 		meta.ChecksumSHA1 = srcMeta.ChecksumSHA1
-	} else {
-		meta.ContentType = "application/octet-stream"
 	}
 
-	h.store.PutObjectMeta(meta)
+	event, _ := h.putObjectMeta(r, notify.EventObjectCreatedCopy, meta, "")
 
 	type copyResult struct {
 		XMLName      xml.Name `xml:"CopyObjectResult"`
@@ -988,21 +911,13 @@ func (h *ObjectHandler) CopyObject(w http.ResponseWriter, r *http.Request, bucke
 		ETag:         etag,
 		LastModified: now.Format(time.RFC3339),
 	})
-	if h.onNotification != nil {
-		h.onNotification("s3:ObjectCreated:Copy", bucket, key, written, etag, "")
-	}
+	h.dispatchEvent(r, event)
 	if h.onReplication != nil {
 		h.onReplication("s3:ObjectCreated:Copy", bucket, key, written, etag, "")
 	}
-	if h.onLambda != nil {
-		h.onLambda("s3:ObjectCreated:Copy", bucket, key, written, etag, "")
-	}
 	if h.onScan != nil {
 		h.onScan(bucket, key, written)
 	}
-	if h.onSearchUpdate != nil {
-		h.onSearchUpdate("put", bucket, key)
-	}
 }
 
 func parseCopySource(source string) (bucket, key string) {
@@ -1061,7 +976,7 @@ func (h *ObjectHandler) BatchDelete(w http.ResponseWriter, r *http.Request, buck
 			}
 		}
 
-		err := h.engine.DeleteObject(bucket, obj.Key)
+		err := h.removeData(bucket, obj.Key, "")
 		if err != nil {
 			result.Errors = append(result.Errors, deleteError{
 				Key:     obj.Key,
@@ -1069,22 +984,14 @@ func (h *ObjectHandler) BatchDelete(w http.ResponseWriter, r *http.Request, buck
 				Message: err.Error(),
 			})
 		} else {
-			h.store.DeleteObjectMeta(bucket, obj.Key)
+			event, _ := h.deleteObjectMeta(r, notify.EventObjectRemovedDelete, bucket, obj.Key)
 			if !req.Quiet {
 				result.Deleted = append(result.Deleted, deletedObject{Key: obj.Key})
 			}
-			if h.onNotification != nil {
-				h.onNotification("s3:ObjectRemoved:Delete", bucket, obj.Key, 0, "", "")
-			}
+			h.dispatchEvent(r, event)
 			if h.onReplication != nil {
 				h.onReplication("s3:ObjectRemoved:Delete", bucket, obj.Key, 0, "", "")
 			}
-			if h.onLambda != nil {
-				h.onLambda("s3:ObjectRemoved:Delete", bucket, obj.Key, 0, "", "")
-			}
-			if h.onSearchUpdate != nil {
-				h.onSearchUpdate("delete", bucket, obj.Key)
-			}
 		}
 	}
 
@@ -1125,16 +1032,15 @@ func (h *ObjectHandler) PutObjectTagging(w http.ResponseWriter, r *http.Request,
 		meta.Tags[tag.Key] = tag.Value
 	}
 
-	if err := h.store.PutObjectMeta(*meta); err != nil {
+	event, err := h.putObjectMeta(r, notify.EventObjectTaggingPut, *meta, meta.VersionID)
+	if err != nil {
 		slog.Error("internal error", "error", err)
 		writeS3Error(w, "InternalError", "An internal error occurred", http.StatusInternalServerError)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
-	if h.onSearchUpdate != nil {
-		h.onSearchUpdate("put", bucket, key)
-	}
+	h.dispatchEvent(r, event)
 }
 
 // GetObjectTagging handles GET /{bucket}/{key}?tagging.
@@ -1185,11 +1091,9 @@ func (h *ObjectHandler) DeleteObjectTagging(w http.ResponseWriter, r *http.Reque
 	}
 
 	meta.Tags = nil
-	h.store.PutObjectMeta(*meta)
+	event, _ := h.putObjectMeta(r, notify.EventObjectTaggingDelete, *meta, meta.VersionID)
 	w.WriteHeader(http.StatusNoContent)
-	if h.onSearchUpdate != nil {
-		h.onSearchUpdate("put", bucket, key)
-	}
+	h.dispatchEvent(r, event)
 }
 
 // ListObjectVersions handles GET /{bucket}?versions.
@@ -1271,7 +1175,7 @@ func (h *ObjectHandler) ListObjectVersions(w http.ResponseWriter, r *http.Reques
 				LastModified: time.Unix(v.LastModified, 0).UTC().Format(time.RFC3339),
 				ETag:         v.ETag,
 				Size:         v.Size,
-				StorageClass: "STANDARD",
+				StorageClass: v.EffectiveStorageClass(),
 			})
 		}
 	}
@@ -1330,13 +1234,14 @@ func (h *ObjectHandler) ListObjects(w http.ResponseWriter, r *http.Request, buck
 		KeyCount:    len(objects),
 	}
 
+	classes := h.listingStorageClasses(bucket, objects)
 	for _, obj := range objects {
 		resp.Contents = append(resp.Contents, xmlContent{
 			Key:          obj.Key,
 			LastModified: time.Unix(obj.LastModified, 0).UTC().Format(time.RFC3339),
 			ETag:         obj.ETag,
 			Size:         obj.Size,
-			StorageClass: "STANDARD",
+			StorageClass: classes[obj.Key],
 		})
 	}
 
@@ -1403,6 +1308,7 @@ func (h *ObjectHandler) ListObjectsV1(w http.ResponseWriter, r *http.Request, bu
 		IsTruncated: truncated,
 	}
 
+	classes := h.listingStorageClasses(bucket, objects)
 	if delimiter != "" {
 		seen := make(map[string]bool)
 		for _, obj := range objects {
@@ -1419,7 +1325,7 @@ func (h *ObjectHandler) ListObjectsV1(w http.ResponseWriter, r *http.Request, bu
 					LastModified: time.Unix(obj.LastModified, 0).UTC().Format(time.RFC3339),
 					ETag:         obj.ETag,
 					Size:         obj.Size,
-					StorageClass: "STANDARD",
+					StorageClass: classes[obj.Key],
 				})
 			}
 		}
@@ -1430,7 +1336,7 @@ func (h *ObjectHandler) ListObjectsV1(w http.ResponseWriter, r *http.Request, bu
 				LastModified: time.Unix(obj.LastModified, 0).UTC().Format(time.RFC3339),
 				ETag:         obj.ETag,
 				Size:         obj.Size,
-				StorageClass: "STANDARD",
+				StorageClass: classes[obj.Key],
 			})
 		}
 	}
@@ -1467,8 +1373,21 @@ func (h *ObjectHandler) GetObjectAttributes(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	type xmlObjectPart struct {
+		PartNumber     int    `xml:"PartNumber"`
+		Size           int64  `xml:"Size"`
+		ChecksumCRC32  string `xml:"ChecksumCRC32,omitempty"`
+		ChecksumCRC32C string `xml:"ChecksumCRC32C,omitempty"`
+		ChecksumSHA1   string `xml:"ChecksumSHA1,omitempty"`
+		ChecksumSHA256 string `xml:"ChecksumSHA256,omitempty"`
+	}
 	type xmlObjectParts struct {
-		TotalPartsCount int `xml:"TotalPartsCount"`
+		TotalPartsCount      int             `xml:"TotalPartsCount"`
+		PartNumberMarker     int             `xml:"PartNumberMarker,omitempty"`
+		NextPartNumberMarker int             `xml:"NextPartNumberMarker,omitempty"`
+		MaxParts             int             `xml:"MaxParts,omitempty"`
+		IsTruncated          bool            `xml:"IsTruncated"`
+		Parts                []xmlObjectPart `xml:"Part"`
 	}
 	type xmlChecksum struct {
 		ChecksumSHA256 string `xml:"ChecksumSHA256,omitempty"`
@@ -1488,7 +1407,7 @@ func (h *ObjectHandler) GetObjectAttributes(w http.ResponseWriter, r *http.Reque
 	resp := xmlObjectAttributes{
 		ETag:         meta.ETag,
 		ObjectSize:   meta.Size,
-		StorageClass: "STANDARD",
+		StorageClass: meta.EffectiveStorageClass(),
 	}
 
 	if meta.ChecksumSHA256 != "" || meta.ChecksumCRC32 != "" || meta.ChecksumCRC32C != "" || meta.ChecksumSHA1 != "" {
@@ -1501,7 +1420,32 @@ func (h *ObjectHandler) GetObjectAttributes(w http.ResponseWriter, r *http.Reque
 	}
 
 	if meta.PartsCount > 0 {
-		resp.ObjectParts = &xmlObjectParts{TotalPartsCount: meta.PartsCount}
+		parts := &xmlObjectParts{TotalPartsCount: meta.PartsCount}
+		// Objects completed before parts were recorded only report the count
+		if len(meta.Parts) > 0 {
+			maxParts, marker, ok := parsePartPaging(w, r.Header.Get("X-Amz-Max-Parts"), r.Header.Get("X-Amz-Part-Number-Marker"))
+			if !ok {
+				return
+			}
+			page, truncated := pageParts(meta.Parts, marker, maxParts)
+			parts.PartNumberMarker = marker
+			parts.MaxParts = maxParts
+			parts.IsTruncated = truncated
+			for _, p := range page {
+				parts.Parts = append(parts.Parts, xmlObjectPart{
+					PartNumber:     p.PartNumber,
+					Size:           p.Size,
+					ChecksumCRC32:  p.ChecksumCRC32,
+					ChecksumCRC32C: p.ChecksumCRC32C,
+					ChecksumSHA1:   p.ChecksumSHA1,
+					ChecksumSHA256: p.ChecksumSHA256,
+				})
+			}
+			if truncated && len(page) > 0 {
+				parts.NextPartNumberMarker = page[len(page)-1].PartNumber
+			}
+		}
+		resp.ObjectParts = parts
 	}
 
 	if meta.VersionID != "" {
@@ -1517,13 +1461,14 @@ func (h *ObjectHandler) PutObjectACL(w http.ResponseWriter, r *http.Request, buc
 		writeS3Error(w, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
 		return
 	}
-	_, err := h.store.GetObjectMeta(bucket, key)
+	meta, err := h.store.GetObjectMeta(bucket, key)
 	if err != nil {
 		writeS3Error(w, "NoSuchKey", "Object not found", http.StatusNotFound)
 		return
 	}
 	io.Copy(io.Discard, r.Body)
 	w.WriteHeader(http.StatusOK)
+	h.emitEvent(r, notify.EventObjectAclPut, bucket, key, meta.Size, meta.ETag, meta.VersionID)
 }
 
 // GetObjectACL handles GET /{bucket}/{key}?acl — returns default private ACL.
@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/eniz1806/VaultS3/internal/metadata"
+	"github.com/eniz1806/VaultS3/internal/notify"
 )
 
 // PostUpload handles POST /{bucket} for HTML form-based uploads with policy document validation.
@@ -64,30 +65,39 @@ func (h *ObjectHandler) PostUpload(w http.ResponseWriter, r *http.Request, bucke
 		return
 	}
 
+	ct := header.Header.Get("Content-Type")
+	if ct == "" {
+		ct = "application/octet-stream"
+	}
+	ct, src, ok := h.checkUploadStream(w, r, bucket, key, file, ct)
+	if !ok {
+		return
+	}
+
 	// Store the object
-	size, etag, err := h.engine.PutObject(bucket, key, file, header.Size)
+	size, etag, err := h.engine.PutObject(bucket, key, src, header.Size)
 	if err != nil {
 		writeS3Error(w, "InternalError", err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	ct := header.Header.Get("Content-Type")
-	if ct == "" {
-		ct = "application/octet-stream"
-	}
-
-	h.store.PutObjectMeta(metadata.ObjectMeta{
+	event, _ := h.putObjectMeta(r, notify.EventObjectCreatedPost, metadata.ObjectMeta{
 		Bucket:       bucket,
 		Key:          key,
 		Size:         size,
 		ETag:         etag,
 		ContentType:  ct,
 		LastModified: time.Now().UTC().UnixNano(),
-	})
+		ScanStatus:   h.initialScanStatus(bucket),
+	}, "")
 
 	w.Header().Set("ETag", fmt.Sprintf(`"%s"`, etag))
 	w.Header().Set("Location", fmt.Sprintf("/%s/%s", bucket, key))
 	w.WriteHeader(http.StatusNoContent)
+	h.dispatchEvent(r, event)
+	if h.onScan != nil {
+		h.onScan(bucket, key, size)
+	}
 }
 
 func (h *ObjectHandler) validatePostPolicy(policyB64, signature, credential string, r *http.Request) error {
@@ -153,6 +153,16 @@ func etagMatch(header, etag string) bool {
 // validateContentMD5 checks Content-MD5 header against body.
 // Returns true if validation failed and error was written.
 func validateContentMD5(w http.ResponseWriter, contentMD5 string, body []byte) bool {
+	if contentMD5 == "" {
+		return false
+	}
+	actual := md5.Sum(body)
+	return checkContentMD5(w, contentMD5, actual[:])
+}
+
+// checkContentMD5 compares a Content-MD5 header against an already computed
+// MD5 sum. Returns true if an error was written.
+func checkContentMD5(w http.ResponseWriter, contentMD5 string, actual []byte) bool {
 	if contentMD5 == "" {
 		return false
 	}
@@ -161,7 +171,6 @@ func validateContentMD5(w http.ResponseWriter, contentMD5 string, body []byte) b
 		writeS3Error(w, "InvalidDigest", "Content-MD5 is invalid", http.StatusBadRequest)
 		return true
 	}
-	actual := md5.Sum(body)
 	for i := range expected {
 		if expected[i] != actual[i] {
 			writeS3Error(w, "BadDigest", "Content-MD5 does not match", http.StatusBadRequest)
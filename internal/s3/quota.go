@@ -0,0 +1,180 @@
+package s3
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrQuotaExceeded is returned by CheckQuota when a write would exceed the
+// bucket's object count or size quota.
+var ErrQuotaExceeded = errors.New("bucket quota exceeded")
+
+// quotaState serialises quota checks and reserves the parts of multipart
+// uploads still being received. Bucket size quotas cover stored objects plus
+// in-progress multipart uploads: parts already stored (tracked by the
+// metadata store) and parts being received. Reserving before a part is
+// written keeps concurrent uploads from overshooting the quota together.
+type quotaState struct {
+	mu       sync.Mutex
+	reserved map[string]int64 // bytes per bucket
+}
+
+// checkQuota verifies bucket quota limits before writing.
+// If FIFOQuota is enabled, oldest objects are deleted to make room.
+func (h *ObjectHandler) checkQuota(w http.ResponseWriter, bucket string, incomingSize int64) bool {
+	h.quota.mu.Lock()
+	defer h.quota.mu.Unlock()
+	return h.checkQuotaLocked(w, bucket, incomingSize, true)
+}
+
+// checkQuotaLocked checks a write of incomingSize bytes, which adds an object
+// when newObject is set. The caller holds quota.mu.
+func (h *ObjectHandler) checkQuotaLocked(w http.ResponseWriter, bucket string, incomingSize int64, newObject bool) bool {
+	if msg := h.quotaExceededLocked(bucket, incomingSize, newObject); msg != "" {
+		writeS3Error(w, "QuotaExceeded", msg, http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// quotaExceededLocked returns why a write of incomingSize bytes would exceed
+// the bucket's quota, or "" when it fits. If FIFOQuota is enabled, oldest
+// objects are deleted to make room. The caller holds quota.mu.
+func (h *ObjectHandler) quotaExceededLocked(bucket string, incomingSize int64, newObject bool) string {
+	info, err := h.store.GetBucket(bucket)
+	if err != nil {
+		return "" // no bucket info, allow
+	}
+	if info.MaxSizeBytes == 0 && info.MaxObjects == 0 {
+		return "" // no limits
+	}
+
+	currentSize, currentCount, _ := h.engine.BucketSize(bucket)
+	used := currentSize + h.store.MultipartUsage(bucket) + h.quota.reserved[bucket]
+
+	var countToFree, bytesToFree int64
+	if newObject && info.MaxObjects > 0 && currentCount >= info.MaxObjects {
+		countToFree = currentCount - info.MaxObjects + 1
+	}
+	if info.MaxSizeBytes > 0 && incomingSize > 0 && used+incomingSize > info.MaxSizeBytes {
+		bytesToFree = used + incomingSize - info.MaxSizeBytes
+	}
+
+	if info.FIFOQuota && (countToFree > 0 || bytesToFree > 0) {
+		// FIFO: delete oldest objects to make room
+		freedCount, freedBytes := h.fifoEvict(bucket, countToFree, bytesToFree)
+		countToFree -= freedCount
+		bytesToFree -= freedBytes
+	}
+
+	if countToFree > 0 {
+		return "Maximum object count exceeded"
+	}
+	if bytesToFree > 0 {
+		return "Maximum bucket size exceeded"
+	}
+	return ""
+}
+
+// CheckQuota checks that a new object of size bytes fits the bucket's quota,
+// evicting the oldest objects of FIFO buckets, for writes that do not come
+// through the S3 API.
+func (h *Handler) CheckQuota(bucket string, size int64) error {
+	h.objects.quota.mu.Lock()
+	defer h.objects.quota.mu.Unlock()
+	if msg := h.objects.quotaExceededLocked(bucket, size, true); msg != "" {
+		return fmt.Errorf("%w: %s", ErrQuotaExceeded, msg)
+	}
+	return nil
+}
+
+// reservePart charges an incoming multipart part of size bytes against the
+// bucket's size quota. The returned release func drops the reservation and
+// must be called once the part is stored or discarded.
+func (h *ObjectHandler) reservePart(w http.ResponseWriter, bucket string, size int64) (release func(), ok bool) {
+	h.quota.mu.Lock()
+	defer h.quota.mu.Unlock()
+	if !h.checkQuotaLocked(w, bucket, size, false) {
+		return nil, false
+	}
+	if size <= 0 {
+		return func() {}, true
+	}
+	if h.quota.reserved == nil {
+		h.quota.reserved = make(map[string]int64)
+	}
+	h.quota.reserved[bucket] += size
+	return func() {
+		h.quota.mu.Lock()
+		defer h.quota.mu.Unlock()
+		h.quota.reserved[bucket] -= size
+		if h.quota.reserved[bucket] <= 0 {
+			delete(h.quota.reserved, bucket)
+		}
+	}, true
+}
+
+// storedPartSize returns the size of an already stored part, which a new
+// upload of the same part number replaces.
+func (h *ObjectHandler) storedPartSize(uploadID string, partNum int) int64 {
+	parts, _ := h.store.ListParts(uploadID)
+	for _, p := range parts {
+		if p.PartNumber == partNum {
+			return p.Size
+		}
+	}
+	return 0
+}
+
+// fifoEvict deletes the oldest objects until countToFree objects and
+// bytesToFree bytes are freed or no candidates are left. Objects under legal
+// hold or retention are never evicted. It returns what was freed.
+func (h *ObjectHandler) fifoEvict(bucket string, countToFree, bytesToFree int64) (freedCount, freedBytes int64) {
+	type objMeta struct {
+		key  string
+		size int64
+		mod  int64
+	}
+	var metas []objMeta
+	startAfter := ""
+	for {
+		objects, truncated, err := h.engine.ListObjects(bucket, "", startAfter, 1000)
+		if err != nil {
+			break
+		}
+		for _, obj := range objects {
+			startAfter = obj.Key
+			meta, err := h.store.GetObjectMeta(bucket, obj.Key)
+			if err != nil {
+				continue
+			}
+			if meta.LegalHold || (meta.RetentionMode != "" && meta.RetentionUntil > time.Now().UTC().Unix()) {
+				continue
+			}
+			metas = append(metas, objMeta{key: obj.Key, size: meta.Size, mod: meta.LastModified})
+		}
+		if !truncated || len(objects) == 0 {
+			break
+		}
+	}
+
+	// Objects from ListObjects are in key order; evict the oldest first
+	sort.SliceStable(metas, func(i, j int) bool { return metas[i].mod < metas[j].mod })
+
+	for _, m := range metas {
+		if freedCount >= countToFree && freedBytes >= bytesToFree {
+			break
+		}
+		if err := h.removeData(bucket, m.key, ""); err != nil {
+			continue
+		}
+		h.store.DeleteObjectMeta(bucket, m.key)
+		freedCount++
+		freedBytes += m.size
+	}
+	return freedCount, freedBytes
+}
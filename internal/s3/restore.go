@@ -2,10 +2,16 @@ package s3
 
 import (
 	"encoding/xml"
+	"errors"
+	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"time"
+
+	"github.com/eniz1806/VaultS3/internal/metadata"
+	"github.com/eniz1806/VaultS3/internal/notify"
+	"github.com/eniz1806/VaultS3/internal/tiering"
 )
 
 type restoreRequest struct {
@@ -14,22 +20,33 @@ type restoreRequest struct {
 	Tier    string   `xml:"GlacierJobParameters>Tier,omitempty"`
 }
 
-// RestoreObject handles POST /{bucket}/{key}?restore.
+// RestoreObject handles POST /{bucket}/{key}?restore with optional ?versionId.
 func (h *ObjectHandler) RestoreObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
 	if !h.store.BucketExists(bucket) {
 		writeS3Error(w, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
 		return
 	}
 
-	meta, err := h.store.GetObjectMeta(bucket, key)
-	if err != nil || meta == nil {
+	versionID := r.URL.Query().Get("versionId")
+	var meta *metadata.ObjectMeta
+	var err error
+	if versionID != "" {
+		meta, err = h.store.GetObjectVersion(bucket, key, versionID)
+		if err != nil {
+			writeS3Error(w, "NoSuchVersion", "Version not found", http.StatusNotFound)
+			return
+		}
+	} else {
+		meta, err = h.store.GetObjectMeta(bucket, key)
+	}
+	if err != nil || meta == nil || meta.DeleteMarker {
 		writeS3Error(w, "NoSuchKey", "Object not found", http.StatusNotFound)
 		return
 	}
 
-	// Only cold-tiered objects need restoration
-	if meta.Tier != "cold" {
-		writeS3Error(w, "InvalidObjectState", "Object is not in a cold storage class", http.StatusConflict)
+	// Only archived (cold or remote tier) objects need restoration
+	if !isArchiveTier(meta) {
+		writeS3Error(w, "InvalidObjectState", "Restore is not allowed for the object's current storage class", http.StatusForbidden)
 		return
 	}
 
@@ -48,15 +65,73 @@ func (h *ObjectHandler) RestoreObject(w http.ResponseWriter, r *http.Request, bu
 	if req.Days <= 0 {
 		req.Days = 1
 	}
+	if req.Tier == "" {
+		req.Tier = "Standard"
+	}
+	if req.Tier != "Expedited" && req.Tier != "Standard" && req.Tier != "Bulk" {
+		writeS3Error(w, "MalformedXML", "Tier must be Expedited, Standard or Bulk", http.StatusBadRequest)
+		return
+	}
+
+	if h.onRestore == nil {
+		writeS3Error(w, "NotImplemented", "Object restore is not enabled", http.StatusNotImplemented)
+		return
+	}
+
+	if meta.RestoreOngoing {
+		writeS3Error(w, "RestoreAlreadyInProgress", "Object restore is already in progress", http.StatusConflict)
+		return
+	}
+
+	queued, err := h.onRestore(bucket, key, versionID, req.Tier, req.Days)
+	switch {
+	case errors.Is(err, tiering.ErrNotArchived):
+		writeS3Error(w, "InvalidObjectState", "Restore is not allowed for the object's current storage class", http.StatusForbidden)
+		return
+	case errors.Is(err, tiering.ErrRestoreInProgress):
+		writeS3Error(w, "RestoreAlreadyInProgress", "Object restore is already in progress", http.StatusConflict)
+		return
+	case err != nil:
+		slog.Error("restore error", "bucket", bucket, "key", key, "error", err)
+		writeS3Error(w, "InternalError", "Failed to queue restore", http.StatusInternalServerError)
+		return
+	}
 
-	// Mark object as being restored (change tier to hot)
-	meta.Tier = "hot"
-	meta.LastModified = time.Now().UTC().Unix()
-	if err := h.store.PutObjectMeta(*meta); err != nil {
-		slog.Error("restore error", "error", err)
-		writeS3Error(w, "InternalError", "Failed to update metadata", http.StatusInternalServerError)
+	if !queued {
+		// Already restored: the expiry of the existing copy was extended
+		w.WriteHeader(http.StatusOK)
 		return
 	}
 
 	w.WriteHeader(http.StatusAccepted)
+	h.emitEvent(r, notify.EventObjectRestorePost, bucket, key, meta.Size, meta.ETag, meta.VersionID)
+}
+
+// isArchiveTier reports whether an object's data lives outside the hot tier.
+func isArchiveTier(meta *metadata.ObjectMeta) bool {
+	return meta.Tier != "" && meta.Tier != "hot"
+}
+
+// isArchived reports whether an object's data lives only in an archive tier
+// and has to be restored before it can be read.
+func isArchived(meta *metadata.ObjectMeta) bool {
+	if meta == nil || !isArchiveTier(meta) {
+		return false
+	}
+	return meta.RestoreExpiry == 0 || meta.RestoreExpiry <= time.Now().UTC().Unix()
+}
+
+// setRestoreHeader sets x-amz-restore for archived objects with a restore in flight or done.
+func setRestoreHeader(w http.ResponseWriter, meta *metadata.ObjectMeta) {
+	if !isArchiveTier(meta) {
+		return
+	}
+	if meta.RestoreOngoing {
+		w.Header().Set("X-Amz-Restore", `ongoing-request="true"`)
+		return
+	}
+	if meta.RestoreExpiry > 0 {
+		expiry := time.Unix(meta.RestoreExpiry, 0).UTC().Format(http.TimeFormat)
+		w.Header().Set("X-Amz-Restore", fmt.Sprintf(`ongoing-request="false", expiry-date="%s"`, expiry))
+	}
 }
@@ -0,0 +1,46 @@
+package s3
+
+import (
+	"net/http"
+
+	"github.com/eniz1806/VaultS3/internal/metadata"
+)
+
+// scanStatusHeader exposes the scan status of objects in buckets that scan
+// before serving.
+const scanStatusHeader = "X-VaultS3-Scan-Status"
+
+// initialScanStatus returns the scan status of an object just written to a
+// bucket: pending when the bucket scans before serving and a scanner runs.
+func (h *ObjectHandler) initialScanStatus(bucket string) string {
+	if h.onScan == nil {
+		return ""
+	}
+	info, err := h.store.GetBucket(bucket)
+	if err != nil || info == nil || !info.ScanBeforeServe {
+		return ""
+	}
+	return metadata.ScanPending
+}
+
+// scanPending reports whether an object is held back until the scanner
+// marks it clean: pending a scan, or too large for the scanner.
+func scanPending(meta *metadata.ObjectMeta) bool {
+	return meta != nil && metadata.ScanHeld(meta.ScanStatus)
+}
+
+func writeScanPending(w http.ResponseWriter, meta *metadata.ObjectMeta) {
+	w.Header().Set(scanStatusHeader, meta.ScanStatus)
+	if meta.ScanStatus == metadata.ScanSkipped {
+		writeS3Error(w, "InvalidObjectState", "The object is too large to be virus scanned", http.StatusForbidden)
+		return
+	}
+	writeS3Error(w, "InvalidObjectState", "The object is pending a virus scan", http.StatusForbidden)
+}
+
+// setScanStatusHeader sets X-VaultS3-Scan-Status for objects with a scan status.
+func setScanStatusHeader(w http.ResponseWriter, meta *metadata.ObjectMeta) {
+	if meta.ScanStatus != "" {
+		w.Header().Set(scanStatusHeader, meta.ScanStatus)
+	}
+}
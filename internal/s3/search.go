@@ -0,0 +1,129 @@
+package s3
+
+import (
+	"encoding/xml"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/eniz1806/VaultS3/internal/iam"
+	"github.com/eniz1806/VaultS3/internal/search"
+)
+
+// maxSearchResults caps max-results of a search request.
+const maxSearchResults = 1000
+
+// actionSearchBucket is the IAM action of a search request. Search results
+// reveal the metadata and content of objects, so listing a bucket does not
+// grant it.
+const actionSearchBucket = "s3:SearchBucket"
+
+// authorize checks a non-admin identity's access to action on resource.
+// Search requests are checked with the s3:prefix and aws:SourceIp condition
+// keys, so a policy can limit search to a key prefix.
+func (h *Handler) authorize(identity *iam.Identity, action, resource string, r *http.Request, sourceIP string) error {
+	if action != actionSearchBucket {
+		return h.auth.Authorize(identity, action, resource)
+	}
+	return h.auth.AuthorizeWithContext(identity, action, resource, map[string]string{
+		"s3:prefix":    r.URL.Query().Get("prefix"),
+		"aws:SourceIp": sourceIP,
+	})
+}
+
+// SearchBucket handles GET /{bucket}?search&q=...: a VaultS3 extension that
+// runs a query of the search query language against the objects of a bucket.
+// The prefix parameter restricts it to keys with a prefix. Results are paged
+// with max-results and the NextCursor of the previous page.
+func (h *Handler) SearchBucket(w http.ResponseWriter, r *http.Request, bucket string) {
+	if !h.store.BucketExists(bucket) {
+		writeS3Error(w, "NoSuchBucket", "Bucket does not exist", http.StatusNotFound)
+		return
+	}
+	if h.searchIndex == nil {
+		writeS3Error(w, "NotImplemented", "Search is not enabled on this server", http.StatusNotImplemented)
+		return
+	}
+
+	q := r.URL.Query()
+	query := q.Get("q")
+	if query == "" {
+		writeS3Error(w, "InvalidArgument", "Parameter q is required", http.StatusBadRequest)
+		return
+	}
+	maxResults := 100
+	if v := q.Get("max-results"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeS3Error(w, "InvalidArgument", "max-results must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		maxResults = min(n, maxSearchResults)
+	}
+
+	prefix := q.Get("prefix")
+	page, err := h.searchIndex.Query(search.Request{
+		Query:  query,
+		Bucket: bucket,
+		Prefix: prefix,
+		Limit:  maxResults,
+		Cursor: q.Get("cursor"),
+	})
+	var queryErr *search.QueryError
+	switch {
+	case errors.As(err, &queryErr) || errors.Is(err, search.ErrInvalidCursor):
+		writeS3Error(w, "InvalidArgument", err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		slog.Error("search failed", "bucket", bucket, "error", err)
+		writeS3Error(w, "InternalError", "An internal error occurred", http.StatusInternalServerError)
+		return
+	}
+
+	type xmlContent struct {
+		Key          string  `xml:"Key"`
+		LastModified string  `xml:"LastModified"`
+		ETag         string  `xml:"ETag"`
+		Size         int64   `xml:"Size"`
+		ContentType  string  `xml:"ContentType,omitempty"`
+		Score        float64 `xml:"Score,omitempty"`
+	}
+	type xmlResponse struct {
+		XMLName         xml.Name     `xml:"SearchResult"`
+		Xmlns           string       `xml:"xmlns,attr"`
+		Name            string       `xml:"Name"`
+		Query           string       `xml:"Query"`
+		Prefix          string       `xml:"Prefix,omitempty"`
+		TotalHits       int          `xml:"TotalHits"`
+		TotalHitsCapped bool         `xml:"TotalHitsCapped,omitempty"`
+		MaxResults      int          `xml:"MaxResults"`
+		IsTruncated     bool         `xml:"IsTruncated"`
+		NextCursor      string       `xml:"NextCursor,omitempty"`
+		Contents        []xmlContent `xml:"Contents"`
+	}
+
+	resp := xmlResponse{
+		Xmlns:           "http://s3.amazonaws.com/doc/2006-03-01/",
+		Name:            bucket,
+		Query:           query,
+		Prefix:          prefix,
+		TotalHits:       page.Total,
+		TotalHitsCapped: page.TotalCapped,
+		MaxResults:      maxResults,
+		IsTruncated:     page.NextCursor != "",
+		NextCursor:      page.NextCursor,
+	}
+	for _, res := range page.Results {
+		resp.Contents = append(resp.Contents, xmlContent{
+			Key:          res.Key,
+			LastModified: time.Unix(res.LastModified, 0).UTC().Format(time.RFC3339),
+			ETag:         res.ETag,
+			Size:         res.Size,
+			ContentType:  res.ContentType,
+			Score:        res.Score,
+		})
+	}
+	writeXML(w, http.StatusOK, resp)
+}
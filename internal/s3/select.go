@@ -51,6 +51,14 @@ func (h *ObjectHandler) SelectObjectContent(w http.ResponseWriter, r *http.Reque
 		writeS3Error(w, "InvalidArgument", "Object too large for S3 Select (max 256MB)", http.StatusBadRequest)
 		return
 	}
+	if isArchived(meta) {
+		writeS3Error(w, "InvalidObjectState", "The operation is not valid for the object's storage class", http.StatusForbidden)
+		return
+	}
+	if scanPending(meta) {
+		writeScanPending(w, meta)
+		return
+	}
 
 	// Read the object (handle versioned storage)
 	var reader io.ReadCloser
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/eniz1806/VaultS3/internal/metadata"
+	"github.com/eniz1806/VaultS3/internal/notify"
 )
 
 // SnowballUpload handles PUT /{bucket}/{key} with x-amz-meta-snowball-auto-extract: true.
@@ -55,21 +56,16 @@ func (h *ObjectHandler) SnowballUpload(w http.ResponseWriter, r *http.Request, b
 		}
 
 		ct := "application/octet-stream"
-		h.store.PutObjectMeta(metadata.ObjectMeta{
+		event, _ := h.putObjectMeta(r, notify.EventObjectCreatedPut, metadata.ObjectMeta{
 			Bucket:       bucket,
 			Key:          key,
 			Size:         size,
 			ETag:         etag,
 			ContentType:  ct,
 			LastModified: time.Now().UTC().UnixNano(),
-		})
+		}, "")
 
-		if h.onNotification != nil {
-			h.onNotification("s3:ObjectCreated:Put", bucket, key, size, etag, "")
-		}
-		if h.onSearchUpdate != nil {
-			h.onSearchUpdate("put", bucket, key)
-		}
+		h.dispatchEvent(r, event)
 
 		count++
 	}
@@ -0,0 +1,69 @@
+package s3
+
+import (
+	"net/http"
+
+	"github.com/eniz1806/VaultS3/internal/metadata"
+	"github.com/eniz1806/VaultS3/internal/storage"
+)
+
+// transitionStorageClasses are the storage classes lifecycle rules can
+// transition objects into.
+var transitionStorageClasses = map[string]bool{
+	"STANDARD_IA":         true,
+	"ONEZONE_IA":          true,
+	"INTELLIGENT_TIERING": true,
+	"GLACIER_IR":          true,
+	"GLACIER":             true,
+	"DEEP_ARCHIVE":        true,
+}
+
+// uploadStorageClasses are the storage classes recorded from the
+// x-amz-storage-class header on upload. They keep data on the hot tier;
+// archive classes are reached through lifecycle transitions.
+var uploadStorageClasses = map[string]bool{
+	"STANDARD_IA":         true,
+	"ONEZONE_IA":          true,
+	"INTELLIGENT_TIERING": true,
+	"GLACIER_IR":          true,
+}
+
+// uploadStorageClass returns the storage class to record for an upload.
+func uploadStorageClass(r *http.Request) string {
+	if class := r.Header.Get("X-Amz-Storage-Class"); uploadStorageClasses[class] {
+		return class
+	}
+	return ""
+}
+
+// setStorageClassHeader sets x-amz-storage-class; like S3 it is omitted for
+// STANDARD. Intelligent-Tiering objects in an archive access tier also get
+// x-amz-archive-status.
+func setStorageClassHeader(w http.ResponseWriter, meta *metadata.ObjectMeta) {
+	if class := meta.EffectiveStorageClass(); class != "STANDARD" {
+		w.Header().Set("X-Amz-Storage-Class", class)
+	}
+	if meta.AccessTier == "ARCHIVE_ACCESS" || meta.AccessTier == "DEEP_ARCHIVE_ACCESS" {
+		w.Header().Set("X-Amz-Archive-Status", meta.AccessTier)
+	}
+}
+
+// listingStorageClasses returns the storage classes of listed objects,
+// looking their metadata up in one read. Objects without metadata are
+// STANDARD.
+func (h *ObjectHandler) listingStorageClasses(bucket string, objects []storage.ObjectInfo) map[string]string {
+	keys := make([]string, len(objects))
+	for i, obj := range objects {
+		keys[i] = obj.Key
+	}
+	metas, _ := h.store.GetObjectMetas(bucket, keys)
+	classes := make(map[string]string, len(objects))
+	for _, key := range keys {
+		if meta, ok := metas[key]; ok {
+			classes[key] = meta.EffectiveStorageClass()
+		} else {
+			classes[key] = "STANDARD"
+		}
+	}
+	return classes
+}
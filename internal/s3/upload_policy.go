@@ -0,0 +1,118 @@
+package s3
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+
+	"github.com/eniz1806/VaultS3/internal/filetype"
+	"github.com/eniz1806/VaultS3/internal/metadata"
+	"github.com/eniz1806/VaultS3/internal/notify"
+)
+
+// uploadPolicy returns the upload policy of a bucket, or nil when it has none.
+func (h *ObjectHandler) uploadPolicy(bucket string) *metadata.UploadPolicy {
+	policy, err := h.store.GetUploadPolicy(bucket)
+	if err != nil {
+		return nil
+	}
+	return policy
+}
+
+// checkUploadType enforces the upload policy of a bucket on content that
+// starts with head and is declared as ct. It returns the Content-Type to
+// store, which is the detected one if the policy overrides mismatched types.
+// A rejected upload is answered, audited and reported, and ok is false.
+func (h *ObjectHandler) checkUploadType(w http.ResponseWriter, r *http.Request, bucket, key string, head []byte, ct string) (string, bool) {
+	policy := h.uploadPolicy(bucket)
+	if policy == nil {
+		return ct, true
+	}
+	return h.applyUploadPolicy(w, r, policy, bucket, key, filetype.Detect(head), ct)
+}
+
+// checkUploadStream is checkUploadType for content read from src. The
+// returned reader yields all of src, including the bytes looked at.
+func (h *ObjectHandler) checkUploadStream(w http.ResponseWriter, r *http.Request, bucket, key string, src io.Reader, ct string) (string, io.Reader, bool) {
+	policy := h.uploadPolicy(bucket)
+	if policy == nil {
+		return ct, src, true
+	}
+	t, src, err := filetype.DetectReader(src)
+	if err != nil {
+		slog.Error("internal error", "error", err)
+		writeS3Error(w, "InternalError", "An internal error occurred", http.StatusInternalServerError)
+		return ct, nil, false
+	}
+	ct, ok := h.applyUploadPolicy(w, r, policy, bucket, key, t, ct)
+	return ct, src, ok
+}
+
+// checkUploadFiles is checkUploadType for content stored in files, such as
+// the parts of a multipart upload, in order.
+func (h *ObjectHandler) checkUploadFiles(w http.ResponseWriter, r *http.Request, bucket, key, ct string, paths ...string) (string, bool) {
+	if h.uploadPolicy(bucket) == nil {
+		return ct, true
+	}
+	head, err := readHead(paths...)
+	if err != nil {
+		slog.Error("internal error", "error", err)
+		writeS3Error(w, "InternalError", "An internal error occurred", http.StatusInternalServerError)
+		return ct, false
+	}
+	return h.checkUploadType(w, r, bucket, key, head, ct)
+}
+
+func (h *ObjectHandler) applyUploadPolicy(w http.ResponseWriter, r *http.Request, policy *metadata.UploadPolicy, bucket, key string, t filetype.Type, ct string) (string, bool) {
+	if reason := policy.Rejection(t); reason != "" {
+		h.rejectUpload(w, r, bucket, key, t, ct, reason)
+		return ct, false
+	}
+	if policy.OverrideContentType && !t.Consistent(ct) {
+		return t.MIME, true
+	}
+	return ct, true
+}
+
+// rejectUpload answers an upload that violates the bucket's upload policy
+// and records the violation in the audit trail and as an
+// s3:ObjectRejected:ContentType event.
+func (h *ObjectHandler) rejectUpload(w http.ResponseWriter, r *http.Request, bucket, key string, t filetype.Type, ct, reason string) {
+	if h.onAudit != nil {
+		req, _ := r.Context().Value(requesterKey{}).(requester)
+		action := mapMethodToAction(r.Method, bucket, key, r.URL.Query())
+		h.onAudit(req.principalID, "", action, formatResource(bucket, key), "Deny", req.sourceIP, http.StatusForbidden)
+	}
+	h.dispatchEvent(r, notify.Event{
+		Name:                notify.EventObjectRejectedContentType,
+		Bucket:              bucket,
+		Key:                 key,
+		DeclaredContentType: ct,
+		DetectedContentType: t.MIME,
+		RejectionReason:     reason,
+	})
+	writeS3Error(w, "AccessDenied", reason, http.StatusForbidden)
+}
+
+// readHead reads the first bytes of the concatenation of files for type
+// detection.
+func readHead(paths ...string) ([]byte, error) {
+	head := make([]byte, 0, filetype.HeadSize)
+	for _, path := range paths {
+		if len(head) == filetype.HeadSize {
+			break
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		n, err := io.ReadFull(f, head[len(head):filetype.HeadSize])
+		f.Close()
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+		head = head[:len(head)+n]
+	}
+	return head, nil
+}
@@ -4,9 +4,12 @@ import (
 	"io"
 	"mime"
 	"net/http"
+	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+
+	"github.com/eniz1806/VaultS3/internal/metadata"
 )
 
 // serveWebsite handles static website requests for website-enabled buckets.
@@ -23,8 +26,19 @@ func (h *Handler) serveWebsite(w http.ResponseWriter, r *http.Request, bucket, k
 		resolvedKey += cfg.IndexDocument
 	}
 
+	// Objects held back by the scanner or archived are not served
+	meta, _ := h.store.GetObjectMeta(bucket, resolvedKey)
+	if isArchived(meta) {
+		writeS3Error(w, "InvalidObjectState", "The operation is not valid for the object's storage class", http.StatusForbidden)
+		return
+	}
+	if scanPending(meta) {
+		writeScanPending(w, meta)
+		return
+	}
+
 	// Try to serve the resolved object
-	reader, size, err := h.engine.GetObject(bucket, resolvedKey)
+	reader, size, err := h.openWebsiteObject(bucket, resolvedKey, meta)
 	if err != nil {
 		// Object not found — try error document
 		if cfg.ErrorDocument != "" {
@@ -49,7 +63,12 @@ func (h *Handler) serveWebsite(w http.ResponseWriter, r *http.Request, bucket, k
 
 // serveErrorDocument serves the custom error document with 404 status.
 func (h *Handler) serveErrorDocument(w http.ResponseWriter, bucket, errorDoc string) {
-	reader, size, err := h.engine.GetObject(bucket, errorDoc)
+	meta, _ := h.store.GetObjectMeta(bucket, errorDoc)
+	if isArchived(meta) || scanPending(meta) {
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+		return
+	}
+	reader, size, err := h.openWebsiteObject(bucket, errorDoc, meta)
 	if err != nil {
 		http.Error(w, "404 Not Found", http.StatusNotFound)
 		return
@@ -65,3 +84,18 @@ func (h *Handler) serveErrorDocument(w http.ResponseWriter, bucket, errorDoc str
 	w.WriteHeader(http.StatusNotFound)
 	io.Copy(w, reader)
 }
+
+// openWebsiteObject opens the current data of an object, which lives in
+// version storage in versioned buckets. Delete markers are not found.
+func (h *Handler) openWebsiteObject(bucket, key string, meta *metadata.ObjectMeta) (io.ReadCloser, int64, error) {
+	if meta == nil {
+		return h.engine.GetObject(bucket, key)
+	}
+	if meta.DeleteMarker {
+		return nil, 0, os.ErrNotExist
+	}
+	if meta.VersionID != "" {
+		return h.engine.GetObjectVersion(bucket, key, meta.VersionID)
+	}
+	return h.engine.GetObject(bucket, key)
+}
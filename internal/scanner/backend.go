@@ -0,0 +1,93 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"time"
+
+	"github.com/eniz1806/VaultS3/internal/metadata"
+	"github.com/eniz1806/VaultS3/internal/webhook"
+)
+
+// Backend scans object content.
+type Backend interface {
+	// Scan reads an object's content from r and reports whether it is
+	// infected. An error means the content could not be scanned.
+	Scan(ctx context.Context, job ScanJob, r io.Reader) (Verdict, error)
+	// Name describes the backend in logs.
+	Name() string
+}
+
+// Verdict is the outcome of a successful scan.
+type Verdict struct {
+	Infected bool
+	Detail   string // signature name if infected, or the scanner's response
+	Engine   string // engine and signature version that scanned the object
+}
+
+// versionReporter is implemented by backends that can report their engine
+// version without scanning anything, so that rescans can skip objects the
+// current version already scanned.
+type versionReporter interface {
+	Version(ctx context.Context) (string, error)
+}
+
+// webhookBackend POSTs objects as multipart/form-data to a URL that answers
+// 200 for clean objects and 406 or 403 for infected ones. The engine
+// version is taken from an X-Scanner-Engine response header.
+type webhookBackend struct {
+	url    string
+	client *webhook.Client
+	auth   *metadata.WebhookAuth
+}
+
+func newWebhookBackend(url string, timeout time.Duration) *webhookBackend {
+	return &webhookBackend{url: url, client: webhook.NewClient(timeout)}
+}
+
+func (b *webhookBackend) Name() string {
+	return "webhook " + b.url
+}
+
+func (b *webhookBackend) Scan(ctx context.Context, job ScanJob, r io.Reader) (Verdict, error) {
+	// POST to webhook as multipart/form-data
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("file", job.Key)
+	if err != nil {
+		return Verdict{}, fmt.Errorf("failed to create form: %v", err)
+	}
+	if _, err := io.Copy(part, r); err != nil {
+		return Verdict{}, fmt.Errorf("failed to read object data: %v", err)
+	}
+
+	// Add metadata fields
+	writer.WriteField("bucket", job.Bucket)
+	writer.WriteField("key", job.Key)
+	writer.WriteField("size", fmt.Sprintf("%d", job.Size))
+	writer.Close()
+
+	resp, err := b.client.Post(ctx, b.url, writer.FormDataContentType(), body.Bytes(), b.auth)
+	if err != nil {
+		return Verdict{}, fmt.Errorf("webhook unreachable: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+	engine := resp.Header.Get("X-Scanner-Engine")
+	if engine == "" {
+		engine = "webhook"
+	}
+
+	switch resp.StatusCode {
+	case 200:
+		return Verdict{Detail: string(respBody), Engine: engine}, nil
+	case 406, 403:
+		return Verdict{Infected: true, Detail: string(respBody), Engine: engine}, nil
+	default:
+		return Verdict{}, fmt.Errorf("webhook returned %d: %s", resp.StatusCode, string(respBody))
+	}
+}
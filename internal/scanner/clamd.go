@@ -0,0 +1,231 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultClamdChunkSize       = 64 << 10
+	defaultClamdStreamMaxLength = 25 << 20 // clamd's default StreamMaxLength
+	// clamdVersionTTL is how long a VERSION reply is reused. Signature
+	// updates change it, so it must not be cached for long.
+	clamdVersionTTL = 5 * time.Minute
+)
+
+// ClamdBackend scans objects with clamd's INSTREAM command over TCP or a
+// unix socket, streaming each object in chunks.
+type ClamdBackend struct {
+	network         string
+	address         string
+	timeout         time.Duration // per network operation
+	streamMaxLength int64
+	chunkSize       int
+
+	mu        sync.Mutex
+	version   string
+	versionAt time.Time
+}
+
+// NewClamdBackend creates a clamd backend. The address is "tcp://host:port",
+// "unix:///path/to/clamd.sock", "host:port" or an absolute socket path.
+// Objects larger than streamMaxLength, which should match StreamMaxLength
+// in clamd.conf, are reported as unscannable instead of being sent.
+func NewClamdBackend(address string, timeout time.Duration, streamMaxLength int64, chunkSize int) (*ClamdBackend, error) {
+	network, addr, err := parseClamdAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	if streamMaxLength <= 0 {
+		streamMaxLength = defaultClamdStreamMaxLength
+	}
+	if chunkSize <= 0 {
+		chunkSize = defaultClamdChunkSize
+	}
+	return &ClamdBackend{
+		network:         network,
+		address:         addr,
+		timeout:         timeout,
+		streamMaxLength: streamMaxLength,
+		chunkSize:       chunkSize,
+	}, nil
+}
+
+func parseClamdAddress(address string) (network, addr string, err error) {
+	switch {
+	case strings.HasPrefix(address, "tcp://"):
+		network, addr = "tcp", strings.TrimPrefix(address, "tcp://")
+	case strings.HasPrefix(address, "unix://"):
+		network, addr = "unix", strings.TrimPrefix(address, "unix://")
+	case strings.HasPrefix(address, "/"):
+		network, addr = "unix", address
+	default:
+		network, addr = "tcp", address
+	}
+	if addr == "" {
+		return "", "", fmt.Errorf("invalid clamd address %q", address)
+	}
+	if network == "tcp" {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return "", "", fmt.Errorf("invalid clamd address %q: %w", address, err)
+		}
+	}
+	return network, addr, nil
+}
+
+func (b *ClamdBackend) Name() string {
+	return "clamd " + b.network + "://" + b.address
+}
+
+// errStreamLimit reports an object clamd would not accept.
+var errStreamLimit = errors.New("INSTREAM size limit exceeded")
+
+// Scan streams r to clamd and parses its reply.
+func (b *ClamdBackend) Scan(ctx context.Context, job ScanJob, r io.Reader) (Verdict, error) {
+	if job.Size > b.streamMaxLength {
+		return Verdict{}, fmt.Errorf("clamd: object is %d bytes, over the stream_max_length of %d: %w", job.Size, b.streamMaxLength, errStreamLimit)
+	}
+
+	dialer := net.Dialer{Timeout: b.timeout}
+	conn, err := dialer.DialContext(ctx, b.network, b.address)
+	if err != nil {
+		return Verdict{}, fmt.Errorf("clamd unreachable: %v", err)
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	sendErr := b.send(conn, r)
+	// clamd answers and closes the connection when it rejects the stream,
+	// so a failed write may still leave a reply to read.
+	if b.timeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(b.timeout))
+	}
+	reply, readErr := bufio.NewReader(conn).ReadString(0)
+	if readErr != nil && (reply == "" || readErr != io.EOF) {
+		if sendErr != nil {
+			return Verdict{}, sendErr
+		}
+		return Verdict{}, fmt.Errorf("clamd: read reply: %v", readErr)
+	}
+	v, err := parseClamdReply(reply)
+	if err != nil {
+		return v, err
+	}
+	v.Engine = "clamd"
+	if version, err := b.Version(ctx); err == nil {
+		v.Engine = version
+	}
+	return v, nil
+}
+
+// Version returns clamd's reply to VERSION, such as
+// "ClamAV 1.3.1/27412/Mon Sep 30 08:00:00 2024", which includes the version
+// of the signature database.
+func (b *ClamdBackend) Version(ctx context.Context) (string, error) {
+	b.mu.Lock()
+	if b.version != "" && time.Since(b.versionAt) < clamdVersionTTL {
+		defer b.mu.Unlock()
+		return b.version, nil
+	}
+	b.mu.Unlock()
+
+	dialer := net.Dialer{Timeout: b.timeout}
+	conn, err := dialer.DialContext(ctx, b.network, b.address)
+	if err != nil {
+		return "", fmt.Errorf("clamd unreachable: %v", err)
+	}
+	defer conn.Close()
+	if b.timeout > 0 {
+		conn.SetDeadline(time.Now().Add(b.timeout))
+	}
+	if _, err := conn.Write([]byte("zVERSION\x00")); err != nil {
+		return "", fmt.Errorf("clamd: send command: %v", err)
+	}
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && (reply == "" || err != io.EOF) {
+		return "", fmt.Errorf("clamd: read reply: %v", err)
+	}
+	version := strings.TrimSpace(strings.TrimRight(reply, "\x00"))
+	if version == "" || strings.HasSuffix(version, "ERROR") {
+		return "", fmt.Errorf("clamd: unexpected VERSION reply %q", version)
+	}
+
+	b.mu.Lock()
+	b.version, b.versionAt = version, time.Now()
+	b.mu.Unlock()
+	return version, nil
+}
+
+// send writes the INSTREAM command, the content in length-prefixed chunks
+// and the terminating zero-length chunk. When the content cannot be sent in
+// full, send closes the connection instead of terminating the stream: clamd
+// would otherwise keep waiting for chunks, or scan the truncated content.
+func (b *ClamdBackend) send(conn net.Conn, r io.Reader) error {
+	write := func(p []byte) error {
+		if b.timeout > 0 {
+			conn.SetWriteDeadline(time.Now().Add(b.timeout))
+		}
+		_, err := conn.Write(p)
+		return err
+	}
+	if err := write([]byte("zINSTREAM\x00")); err != nil {
+		return fmt.Errorf("clamd: send command: %v", err)
+	}
+
+	buf := make([]byte, 4+b.chunkSize)
+	var sent int64
+	for {
+		n, err := io.ReadFull(r, buf[4:])
+		if n > 0 {
+			if sent += int64(n); sent > b.streamMaxLength {
+				conn.Close()
+				return fmt.Errorf("clamd: object is over the stream_max_length of %d bytes: %w", b.streamMaxLength, errStreamLimit)
+			}
+			binary.BigEndian.PutUint32(buf[:4], uint32(n))
+			if werr := write(buf[:4+n]); werr != nil {
+				return fmt.Errorf("clamd: send data: %v", werr)
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			conn.Close()
+			return fmt.Errorf("failed to read object data: %v", err)
+		}
+	}
+	if err := write([]byte{0, 0, 0, 0}); err != nil {
+		return fmt.Errorf("clamd: send data: %v", err)
+	}
+	return nil
+}
+
+// parseClamdReply parses a reply to INSTREAM: "stream: OK",
+// "stream: <signature> FOUND" or "<message> ERROR".
+func parseClamdReply(reply string) (Verdict, error) {
+	reply = strings.TrimSpace(strings.TrimRight(reply, "\x00"))
+	msg := strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case msg == "OK":
+		return Verdict{Detail: reply}, nil
+	case strings.HasSuffix(msg, " FOUND"):
+		return Verdict{Infected: true, Detail: strings.TrimSuffix(msg, " FOUND")}, nil
+	case strings.HasSuffix(msg, " ERROR"):
+		msg = strings.TrimSuffix(msg, " ERROR")
+		if strings.Contains(msg, errStreamLimit.Error()) {
+			return Verdict{}, fmt.Errorf("clamd: %s; raise StreamMaxLength in clamd.conf or lower scanner.clamd.stream_max_length: %w", msg, errStreamLimit)
+		}
+		return Verdict{}, fmt.Errorf("clamd: %s", msg)
+	default:
+		return Verdict{}, fmt.Errorf("clamd: unexpected reply %q", reply)
+	}
+}
@@ -0,0 +1,221 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eniz1806/VaultS3/internal/metadata"
+)
+
+// fakeClamd answers INSTREAM like clamd: FOUND for content containing
+// "EICAR", a size limit ERROR for streams over maxLength, OK otherwise.
+// VERSION is answered with a fixed engine and signature version.
+type fakeClamd struct {
+	ln        net.Listener
+	maxLength int
+	received  chan []byte
+}
+
+func newFakeClamd(t *testing.T, network, address string, maxLength int) *fakeClamd {
+	t.Helper()
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	f := &fakeClamd{ln: ln, maxLength: maxLength, received: make(chan []byte, 16)}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeClamd) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	cmd, err := r.ReadString(0)
+	if cmd == "zVERSION\x00" {
+		conn.Write([]byte("ClamAV 1.0.0/27000/Mon Oct  5 08:00:00 2026\x00"))
+		return
+	}
+	if err != nil || cmd != "zINSTREAM\x00" {
+		conn.Write([]byte("UNKNOWN COMMAND\x00"))
+		return
+	}
+	var data bytes.Buffer
+	for {
+		var size uint32
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+			return
+		}
+		if size == 0 {
+			break
+		}
+		if data.Len()+int(size) > f.maxLength {
+			conn.Write([]byte("INSTREAM size limit exceeded. ERROR\x00"))
+			return
+		}
+		if _, err := io.CopyN(&data, r, int64(size)); err != nil {
+			return
+		}
+	}
+	f.received <- data.Bytes()
+	if bytes.Contains(data.Bytes(), []byte("EICAR")) {
+		conn.Write([]byte("stream: Eicar-Test-Signature FOUND\x00"))
+		return
+	}
+	conn.Write([]byte("stream: OK\x00"))
+}
+
+func TestClamdBackend_TCPAndUnix(t *testing.T) {
+	tcp := newFakeClamd(t, "tcp", "127.0.0.1:0", 1<<20)
+	sock := filepath.Join(t.TempDir(), "clamd.sock")
+	newFakeClamd(t, "unix", sock, 1<<20)
+
+	for _, address := range []string{"tcp://" + tcp.ln.Addr().String(), tcp.ln.Addr().String(), "unix://" + sock, sock} {
+		b, err := NewClamdBackend(address, 5*time.Second, 0, 7)
+		if err != nil {
+			t.Fatalf("NewClamdBackend(%q): %v", address, err)
+		}
+		clean := strings.Repeat("clean data ", 10)
+		v, err := b.Scan(context.Background(), ScanJob{Size: int64(len(clean))}, strings.NewReader(clean))
+		if err != nil || v.Infected {
+			t.Fatalf("%s: clean scan = %+v, %v", address, v, err)
+		}
+		v, err = b.Scan(context.Background(), ScanJob{}, strings.NewReader("X5O!P%@AP EICAR test"))
+		if err != nil || !v.Infected || v.Detail != "Eicar-Test-Signature" {
+			t.Fatalf("%s: infected scan = %+v, %v", address, v, err)
+		}
+	}
+
+	// The content arrives intact across chunks
+	b, _ := NewClamdBackend(tcp.ln.Addr().String(), 5*time.Second, 0, 3)
+	for len(tcp.received) > 0 {
+		<-tcp.received
+	}
+	b.Scan(context.Background(), ScanJob{}, strings.NewReader("0123456789"))
+	if got := <-tcp.received; string(got) != "0123456789" {
+		t.Fatalf("received %q", got)
+	}
+}
+
+func TestClamdBackend_StreamMaxLength(t *testing.T) {
+	f := newFakeClamd(t, "tcp", "127.0.0.1:0", 16)
+	addr := f.ln.Addr().String()
+
+	// Known to be too large: not sent at all
+	b, _ := NewClamdBackend(addr, 5*time.Second, 16, 4)
+	_, err := b.Scan(context.Background(), ScanJob{Size: 17}, strings.NewReader(strings.Repeat("a", 17)))
+	if !errors.Is(err, errStreamLimit) {
+		t.Fatalf("expected stream limit error, got %v", err)
+	}
+
+	// Larger than its size said: the stream is abandoned, not scanned, and
+	// the scan does not wait for a reply clamd will never send
+	for len(f.received) > 0 {
+		<-f.received
+	}
+	b, _ = NewClamdBackend(addr, 0, 8, 4)
+	done := make(chan error, 1)
+	go func() {
+		_, err := b.Scan(context.Background(), ScanJob{}, strings.NewReader(strings.Repeat("a", 12)))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, errStreamLimit) {
+			t.Fatalf("expected stream limit error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("scan over the stream limit did not return")
+	}
+	select {
+	case data := <-f.received:
+		t.Fatalf("truncated stream was scanned: %q", data)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// clamd's own limit is lower than configured
+	b, _ = NewClamdBackend(addr, 5*time.Second, 1<<20, 4)
+	_, err = b.Scan(context.Background(), ScanJob{}, strings.NewReader(strings.Repeat("a", 64)))
+	if !errors.Is(err, errStreamLimit) || !strings.Contains(err.Error(), "StreamMaxLength") {
+		t.Fatalf("expected clamd size limit error, got %v", err)
+	}
+}
+
+func TestParseClamdReply(t *testing.T) {
+	tests := []struct {
+		reply    string
+		infected bool
+		detail   string
+		wantErr  bool
+	}{
+		{"stream: OK\x00", false, "stream: OK", false},
+		{"stream: Win.Test.EICAR_HDB-1 FOUND\x00", true, "Win.Test.EICAR_HDB-1", false},
+		{"stream: Can't allocate memory ERROR\x00", false, "", true},
+		{"UNKNOWN COMMAND\x00", false, "", true},
+	}
+	for _, tt := range tests {
+		v, err := parseClamdReply(tt.reply)
+		if (err != nil) != tt.wantErr || v.Infected != tt.infected || v.Detail != tt.detail {
+			t.Errorf("parseClamdReply(%q) = %+v, %v", tt.reply, v, err)
+		}
+	}
+}
+
+func TestNewClamdBackend_InvalidAddress(t *testing.T) {
+	for _, address := range []string{"", "tcp://", "clamd-without-port"} {
+		if _, err := NewClamdBackend(address, time.Second, 0, 0); err == nil {
+			t.Errorf("NewClamdBackend(%q): expected error", address)
+		}
+	}
+}
+
+func TestScanner_ClamdQuarantinesInfected(t *testing.T) {
+	s, store, engine := newTestScanner(t, false)
+	for key, body := range map[string]string{"clean.txt": "hello", "virus.txt": "EICAR"} {
+		engine.PutObject("b", key, strings.NewReader(body), int64(len(body)))
+		store.PutObjectMeta(metadata.ObjectMeta{Bucket: "b", Key: key, Size: int64(len(body))})
+	}
+
+	f := newFakeClamd(t, "tcp", "127.0.0.1:0", 1<<20)
+	clamd, _ := NewClamdBackend(f.ln.Addr().String(), 5*time.Second, 0, 0)
+	s.SetBackend(clamd)
+	s.processJob(ScanJob{Bucket: "b", Key: "clean.txt", Size: 5})
+	s.processJob(ScanJob{Bucket: "b", Key: "virus.txt", Size: 5})
+
+	results := s.RecentResults(10)
+	if len(results) != 2 || results[0].Status != "infected" || results[0].Detail != "Eicar-Test-Signature" || results[1].Status != "clean" {
+		t.Fatalf("results = %+v", results)
+	}
+	if results[0].Engine != "ClamAV 1.0.0/27000/Mon Oct  5 08:00:00 2026" {
+		t.Fatalf("engine = %q", results[0].Engine)
+	}
+	if _, _, err := engine.GetObject("b", "virus.txt"); err == nil {
+		t.Fatal("infected object should be removed")
+	}
+	if r, _, err := engine.GetObject("quarantine", "b/virus.txt"); err != nil {
+		t.Fatalf("quarantined object: %v", err)
+	} else {
+		r.Close()
+	}
+	// Objects outside scan-before-serve buckets get no scan status
+	if meta, _ := store.GetObjectMeta("b", "clean.txt"); meta == nil || meta.ScanStatus != "" {
+		t.Fatalf("clean meta = %+v", meta)
+	}
+}
@@ -0,0 +1,325 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/eniz1806/VaultS3/internal/metadata"
+)
+
+const (
+	defaultRescanRate = 10
+	maxRescanRate     = 1000
+	// blocklistEngine is the engine recorded for objects found in the hash
+	// blocklist.
+	blocklistEngine = "blocklist"
+	// rescanPageSize is how many objects a rescan lists at a time.
+	rescanPageSize = 100
+	// rescanSaveInterval is how often a rescan saves its progress.
+	rescanSaveInterval = time.Second
+)
+
+var (
+	// ErrRescanRunning is returned when starting a rescan of a bucket and
+	// prefix that another rescan is still scanning.
+	ErrRescanRunning = errors.New("a rescan of this bucket and prefix is already running")
+	// ErrRescanNotRunning is returned when cancelling a rescan that has
+	// finished.
+	ErrRescanNotRunning = errors.New("rescan is not running")
+	// ErrScannerStopped is returned when starting a rescan before Start or
+	// after Stop.
+	ErrScannerStopped = errors.New("scanner is not running")
+)
+
+// RescanRequest describes a rescan to start.
+type RescanRequest struct {
+	Bucket        string `json:"bucket"`
+	Prefix        string `json:"prefix"`
+	ObjectsPerSec int    `json:"objects_per_sec"` // 0 uses the scanner's default
+	SkipCurrent   bool   `json:"skip_current"`
+	Schedule      string `json:"-"`
+}
+
+// RescanSchedule starts a rescan of a bucket or prefix at a fixed interval.
+type RescanSchedule struct {
+	Bucket        string
+	Prefix        string
+	Interval      time.Duration
+	ObjectsPerSec int
+	SkipCurrent   bool
+}
+
+// Name identifies the schedule in the rescans it starts.
+func (sc RescanSchedule) Name() string {
+	return sc.Bucket + "/" + sc.Prefix
+}
+
+type runningRescan struct {
+	cancel    context.CancelFunc
+	cancelled bool
+}
+
+// SetRescanDefaults sets the default rate of rescans, in objects per second,
+// and the schedules that start rescans on their own.
+func (s *Scanner) SetRescanDefaults(objectsPerSec int, schedules []RescanSchedule) {
+	if objectsPerSec > 0 {
+		s.rescanRate = objectsPerSec
+	}
+	s.schedules = schedules
+}
+
+// StartRescan starts scanning the objects already stored in a bucket, or
+// under a prefix of it, at a throttled rate.
+func (s *Scanner) StartRescan(req RescanRequest) (*metadata.Rescan, error) {
+	if !s.store.BucketExists(req.Bucket) {
+		return nil, fmt.Errorf("bucket not found: %s", req.Bucket)
+	}
+	if req.ObjectsPerSec <= 0 {
+		req.ObjectsPerSec = s.rescanRate
+	}
+	req.ObjectsPerSec = min(req.ObjectsPerSec, maxRescanRate)
+
+	s.rescanMu.Lock()
+	defer s.rescanMu.Unlock()
+	if s.ctx == nil || s.ctx.Err() != nil {
+		return nil, ErrScannerStopped
+	}
+	running, err := s.store.ListRescans(metadata.RescanRunning, 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range running {
+		if r.Bucket == req.Bucket && r.Prefix == req.Prefix {
+			return nil, ErrRescanRunning
+		}
+	}
+
+	total, _ := s.store.CountObjects(req.Bucket, req.Prefix)
+	r := &metadata.Rescan{
+		Bucket:        req.Bucket,
+		Prefix:        req.Prefix,
+		ObjectsPerSec: req.ObjectsPerSec,
+		SkipCurrent:   req.SkipCurrent,
+		Schedule:      req.Schedule,
+		Status:        metadata.RescanRunning,
+		Total:         total,
+	}
+	if req.SkipCurrent {
+		r.Engine = s.engineVersion()
+	}
+	if err := s.store.CreateRescan(r); err != nil {
+		return nil, err
+	}
+	s.launchRescan(*r)
+	slog.Info("scanner: rescan started", "id", r.ID, "bucket", r.Bucket, "prefix", r.Prefix, "objects_per_sec", r.ObjectsPerSec)
+	return r, nil
+}
+
+// CancelRescan stops a running rescan.
+func (s *Scanner) CancelRescan(id uint64) (*metadata.Rescan, error) {
+	r, err := s.store.GetRescan(id)
+	if err != nil {
+		return nil, err
+	}
+	s.rescanMu.Lock()
+	run, ok := s.rescans[id]
+	if ok {
+		run.cancelled = true
+		run.cancel()
+	}
+	s.rescanMu.Unlock()
+	if r.Status != metadata.RescanRunning {
+		return nil, ErrRescanNotRunning
+	}
+	r.Status = metadata.RescanCancelled
+	if ok {
+		// The rescan saves its final progress as it stops
+		return r, nil
+	}
+	// Left running by a scanner that stopped before finishing it
+	r.FinishedAt = time.Now().Unix()
+	return r, s.store.UpdateRescan(r)
+}
+
+// resumeRescans continues the rescans that were running when the scanner
+// stopped, from the last key they finished.
+func (s *Scanner) resumeRescans() {
+	running, err := s.store.ListRescans(metadata.RescanRunning, 0)
+	if err != nil {
+		slog.Error("scanner: failed to list rescans", "error", err)
+		return
+	}
+	s.rescanMu.Lock()
+	defer s.rescanMu.Unlock()
+	for _, r := range running {
+		if _, ok := s.rescans[r.ID]; !ok {
+			s.launchRescan(r)
+		}
+	}
+}
+
+// launchRescan runs a rescan in the background. rescanMu must be held.
+func (s *Scanner) launchRescan(r metadata.Rescan) {
+	ctx, cancel := context.WithCancel(s.ctx)
+	run := &runningRescan{cancel: cancel}
+	s.rescans[r.ID] = run
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer cancel()
+		s.runRescan(ctx, &r, run)
+		s.rescanMu.Lock()
+		delete(s.rescans, r.ID)
+		s.rescanMu.Unlock()
+	}()
+}
+
+func (s *Scanner) runRescan(ctx context.Context, r *metadata.Rescan, run *runningRescan) {
+	ticker := time.NewTicker(time.Second / time.Duration(r.ObjectsPerSec))
+	defer ticker.Stop()
+	lastSave := time.Now()
+
+	for r.Status == metadata.RescanRunning {
+		page, err := s.store.ListObjectMetaAfter(r.Bucket, r.Prefix, r.LastKey, rescanPageSize)
+		if err != nil {
+			r.Status = metadata.RescanFailed
+			r.Error = err.Error()
+			break
+		}
+		if len(page) == 0 {
+			r.Status = metadata.RescanCompleted
+			break
+		}
+		for _, meta := range page {
+			select {
+			case <-ctx.Done():
+				s.rescanMu.Lock()
+				cancelled := run.cancelled
+				s.rescanMu.Unlock()
+				if !cancelled {
+					// The scanner is stopping: keep the rescan running so
+					// that it resumes on the next start
+					s.store.UpdateRescan(r)
+					return
+				}
+				r.Status = metadata.RescanCancelled
+				r.FinishedAt = time.Now().Unix()
+				s.store.UpdateRescan(r)
+				return
+			case <-ticker.C:
+			}
+			s.rescanObject(r, meta)
+			r.LastKey = meta.Key
+			if time.Since(lastSave) >= rescanSaveInterval {
+				s.store.UpdateRescan(r)
+				lastSave = time.Now()
+			}
+		}
+	}
+
+	r.FinishedAt = time.Now().Unix()
+	if err := s.store.UpdateRescan(r); err != nil {
+		slog.Error("scanner: failed to save rescan", "id", r.ID, "error", err)
+	}
+	slog.Info("scanner: rescan finished", "id", r.ID, "status", r.Status, "scanned", r.Scanned, "infected", r.Infected, "errors", r.Errors)
+}
+
+// rescanObject scans one object for a rescan and counts the outcome.
+func (s *Scanner) rescanObject(r *metadata.Rescan, meta metadata.ObjectMeta) {
+	if s.maxScanSize > 0 && meta.Size > s.maxScanSize {
+		r.Skipped++
+		return
+	}
+	if r.SkipCurrent && r.Engine != "" {
+		if history, _ := s.store.GetScanHistory(meta.Bucket, meta.Key); len(history) > 0 &&
+			history[0].Engine == r.Engine && history[0].ETag == meta.ETag && history[0].Verdict == "clean" {
+			r.Skipped++
+			return
+		}
+	}
+	result := s.scanObject(ScanJob{Bucket: meta.Bucket, Key: meta.Key, Size: meta.Size}, r.ID)
+	r.Scanned++
+	switch result.Status {
+	case "clean":
+		r.Clean++
+	case "infected":
+		r.Infected++
+	default:
+		r.Errors++
+	}
+}
+
+// engineVersion returns the version the backend reports, or "" if it
+// cannot tell without scanning.
+func (s *Scanner) engineVersion() string {
+	vr, ok := s.backend.(versionReporter)
+	if !ok {
+		return ""
+	}
+	timeout := s.timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	version, err := vr.Version(ctx)
+	if err != nil {
+		slog.Warn("scanner: failed to get engine version", "error", err)
+		return ""
+	}
+	return version
+}
+
+// scheduleLoop starts the scheduled rescans that are due, checking once a
+// minute.
+func (s *Scanner) scheduleLoop(ctx context.Context) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		s.runDueSchedules(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runDueSchedules starts a rescan for each schedule whose last rescan
+// started at least its interval before now.
+func (s *Scanner) runDueSchedules(now time.Time) {
+	if len(s.schedules) == 0 {
+		return
+	}
+	rescans, err := s.store.ListRescans("", 0)
+	if err != nil {
+		slog.Error("scanner: failed to list rescans", "error", err)
+		return
+	}
+	for _, sc := range s.schedules {
+		var last int64
+		for _, r := range rescans {
+			if r.Schedule == sc.Name() {
+				last = r.CreatedAt // newest first
+				break
+			}
+		}
+		if last > 0 && now.Sub(time.Unix(last, 0)) < sc.Interval {
+			continue
+		}
+		_, err := s.StartRescan(RescanRequest{
+			Bucket:        sc.Bucket,
+			Prefix:        sc.Prefix,
+			ObjectsPerSec: sc.ObjectsPerSec,
+			SkipCurrent:   sc.SkipCurrent,
+			Schedule:      sc.Name(),
+		})
+		if err != nil && !errors.Is(err, ErrRescanRunning) {
+			slog.Error("scanner: scheduled rescan failed to start", "schedule", sc.Name(), "error", err)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package scanner
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eniz1806/VaultS3/internal/metadata"
+	"github.com/eniz1806/VaultS3/internal/storage"
+)
+
+// versionedBackend is a stubBackend that reports an engine version and
+// counts the objects it scans.
+type versionedBackend struct {
+	stubBackend
+	mu      sync.Mutex
+	version string
+	scanned []string
+}
+
+func (b *versionedBackend) Scan(ctx context.Context, job ScanJob, r io.Reader) (Verdict, error) {
+	b.mu.Lock()
+	b.scanned = append(b.scanned, job.Key)
+	version := b.version
+	b.mu.Unlock()
+	v, err := b.stubBackend.Scan(ctx, job, r)
+	v.Engine = version
+	return v, err
+}
+
+func (b *versionedBackend) Version(context.Context) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.version, nil
+}
+
+func (b *versionedBackend) setVersion(v string) {
+	b.mu.Lock()
+	b.version = v
+	b.scanned = nil
+	b.mu.Unlock()
+}
+
+func (b *versionedBackend) scans() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string(nil), b.scanned...)
+}
+
+func putObject(t *testing.T, store *metadata.Store, engine storage.Engine, key, body string) {
+	t.Helper()
+	_, etag, err := engine.PutObject("b", key, strings.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatalf("PutObject: %v", err)
+	}
+	store.PutObjectMeta(metadata.ObjectMeta{Bucket: "b", Key: key, ETag: etag, Size: int64(len(body))})
+}
+
+func startScanner(t *testing.T, s *Scanner) {
+	t.Helper()
+	s.Start(context.Background(), 1)
+	t.Cleanup(s.Stop)
+}
+
+func waitRescan(t *testing.T, store *metadata.Store, id uint64) *metadata.Rescan {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		r, err := store.GetRescan(id)
+		if err == nil && r.Status != metadata.RescanRunning {
+			return r
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("rescan %d did not finish", id)
+	return nil
+}
+
+func TestScanner_BlocklistAndHistory(t *testing.T) {
+	s, store, engine := newTestScanner(t, false)
+	backend := &versionedBackend{version: "stub 1/100"}
+	s.SetBackend(backend)
+	putObject(t, store, engine, "clean.txt", "hello")
+	putObject(t, store, engine, "known.bin", "known bad")
+	sum := sha256.Sum256([]byte("known bad"))
+	store.PutBlocklistEntry(metadata.BlocklistEntry{SHA256: hex.EncodeToString(sum[:]), Name: "Known.Bad.Sample"})
+
+	s.processJob(ScanJob{Bucket: "b", Key: "clean.txt", Size: 5})
+	s.processJob(ScanJob{Bucket: "b", Key: "known.bin", Size: 9})
+
+	// The blocklist is checked first, without asking the backend
+	if got := strings.Join(backend.scans(), ","); got != "clean.txt" {
+		t.Fatalf("backend scanned %s", got)
+	}
+	results := s.RecentResults(2)
+	if results[0].Status != "infected" || results[0].Detail != "Known.Bad.Sample" || results[0].Engine != blocklistEngine {
+		t.Fatalf("blocklist result = %+v", results[0])
+	}
+	if _, _, err := engine.GetObject("b", "known.bin"); err == nil {
+		t.Fatal("blocklisted object should be quarantined")
+	}
+
+	history, _ := store.GetScanHistory("b", "clean.txt")
+	if len(history) != 1 || history[0].Engine != "stub 1/100" || history[0].Verdict != "clean" || history[0].ETag == "" {
+		t.Fatalf("history = %+v", history)
+	}
+
+	// Quarantine keeps the history and a release brings it back
+	if _, err := s.Release("b/known.bin"); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	history, _ = store.GetScanHistory("b", "known.bin")
+	if len(history) != 1 || history[0].Verdict != "infected" || history[0].Engine != blocklistEngine {
+		t.Fatalf("released history = %+v", history)
+	}
+
+	// Newest first, capped
+	for i := 0; i < 25; i++ {
+		s.processJob(ScanJob{Bucket: "b", Key: "clean.txt", Size: 5})
+	}
+	history, _ = store.GetScanHistory("b", "clean.txt")
+	if len(history) != 20 || history[0].ScannedAt < history[len(history)-1].ScannedAt {
+		t.Fatalf("history has %d entries", len(history))
+	}
+}
+
+func TestScanner_Rescan(t *testing.T) {
+	s, store, engine := newTestScanner(t, true)
+	backend := &versionedBackend{version: "v1"}
+	s.SetBackend(backend)
+	putObject(t, store, engine, "docs/a.txt", "fine")
+	putObject(t, store, engine, "docs/b.txt", "EICAR")
+	putObject(t, store, engine, "docs/c.txt", "BROKEN")
+	putObject(t, store, engine, "docs/d.txt", strings.Repeat("x", 17))
+	putObject(t, store, engine, "other.txt", "fine")
+
+	if _, err := s.StartRescan(RescanRequest{Bucket: "b"}); !errors.Is(err, ErrScannerStopped) {
+		t.Fatalf("rescan before start: %v", err)
+	}
+	startScanner(t, s)
+	if _, err := s.StartRescan(RescanRequest{Bucket: "missing"}); err == nil {
+		t.Fatal("expected error for a missing bucket")
+	}
+
+	r, err := s.StartRescan(RescanRequest{Bucket: "b", Prefix: "docs/", ObjectsPerSec: 1000})
+	if err != nil {
+		t.Fatalf("StartRescan: %v", err)
+	}
+	if r.Total != 4 {
+		t.Fatalf("total = %d", r.Total)
+	}
+	r = waitRescan(t, store, r.ID)
+	if r.Status != metadata.RescanCompleted || r.Scanned != 3 || r.Clean != 1 || r.Infected != 1 || r.Errors != 1 || r.Skipped != 1 || r.FinishedAt == 0 {
+		t.Fatalf("rescan = %+v", r)
+	}
+	if got := strings.Join(backend.scans(), ","); got != "docs/a.txt,docs/b.txt,docs/c.txt" {
+		t.Fatalf("scanned %s", got)
+	}
+	if _, _, err := engine.GetObject("b", "docs/b.txt"); err == nil {
+		t.Fatal("infected object should be quarantined")
+	}
+	// Unlike uploads, rescans leave unscannable objects in place when failing closed
+	if _, _, err := engine.GetObject("b", "docs/c.txt"); err != nil {
+		t.Fatal("unscannable object should stay")
+	}
+	if history, _ := store.GetScanHistory("b", "docs/a.txt"); len(history) != 1 || history[0].RescanID != r.ID {
+		t.Fatalf("history = %+v", history)
+	}
+
+	// Skipping objects the current engine version already found clean
+	backend.setVersion("v1")
+	r, _ = s.StartRescan(RescanRequest{Bucket: "b", Prefix: "docs/", ObjectsPerSec: 1000, SkipCurrent: true})
+	r = waitRescan(t, store, r.ID)
+	if r.Engine != "v1" || r.Scanned != 1 || r.Skipped != 2 {
+		t.Fatalf("skip-current rescan = %+v", r)
+	}
+	backend.setVersion("v2")
+	r, _ = s.StartRescan(RescanRequest{Bucket: "b", Prefix: "docs/", ObjectsPerSec: 1000, SkipCurrent: true})
+	r = waitRescan(t, store, r.ID)
+	if r.Scanned != 2 {
+		t.Fatalf("rescan after signature update = %+v", r)
+	}
+}
+
+func TestScanner_RescanCancel(t *testing.T) {
+	s, store, engine := newTestScanner(t, false)
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		putObject(t, store, engine, key, "fine")
+	}
+	startScanner(t, s)
+
+	r, err := s.StartRescan(RescanRequest{Bucket: "b", ObjectsPerSec: 2})
+	if err != nil {
+		t.Fatalf("StartRescan: %v", err)
+	}
+	if _, err := s.StartRescan(RescanRequest{Bucket: "b"}); !errors.Is(err, ErrRescanRunning) {
+		t.Fatalf("second rescan: %v", err)
+	}
+	if _, err := s.CancelRescan(r.ID); err != nil {
+		t.Fatalf("CancelRescan: %v", err)
+	}
+	r = waitRescan(t, store, r.ID)
+	if r.Status != metadata.RescanCancelled || r.Scanned >= 5 {
+		t.Fatalf("cancelled rescan = %+v", r)
+	}
+	if _, err := s.CancelRescan(r.ID); !errors.Is(err, ErrRescanNotRunning) {
+		t.Fatalf("cancel finished rescan: %v", err)
+	}
+}
+
+func TestScanner_RescanResumes(t *testing.T) {
+	s, store, engine := newTestScanner(t, false)
+	backend := &versionedBackend{}
+	s.SetBackend(backend)
+	for _, key := range []string{"a", "b", "c"} {
+		putObject(t, store, engine, key, "fine")
+	}
+	// A rescan the previous run of the scanner got through "a" of
+	r := &metadata.Rescan{Bucket: "b", ObjectsPerSec: 1000, Status: metadata.RescanRunning, Total: 3, Scanned: 1, Clean: 1, LastKey: "a"}
+	store.CreateRescan(r)
+
+	startScanner(t, s)
+	r = waitRescan(t, store, r.ID)
+	if r.Status != metadata.RescanCompleted || r.Scanned != 3 || r.Clean != 3 {
+		t.Fatalf("resumed rescan = %+v", r)
+	}
+	if got := strings.Join(backend.scans(), ","); got != "b,c" {
+		t.Fatalf("scanned %s", got)
+	}
+}
+
+func TestScanner_RescanSchedules(t *testing.T) {
+	s, store, engine := newTestScanner(t, false)
+	putObject(t, store, engine, "a", "fine")
+	s.SetRescanDefaults(1000, []RescanSchedule{{Bucket: "b", Interval: time.Hour}})
+	startScanner(t, s)
+
+	// The schedule loop runs the first rescan as soon as it starts
+	var first metadata.Rescan
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if rescans, _ := store.ListRescans("", 0); len(rescans) == 1 && rescans[0].Status == metadata.RescanCompleted {
+			first = rescans[0]
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if first.Schedule != "b/" || first.ObjectsPerSec != 1000 {
+		t.Fatalf("scheduled rescan = %+v", first)
+	}
+
+	s.runDueSchedules(time.Now())
+	if rescans, _ := store.ListRescans("", 0); len(rescans) != 1 {
+		t.Fatalf("rescan started before the interval passed: %d", len(rescans))
+	}
+	s.runDueSchedules(time.Now().Add(2 * time.Hour))
+	rescans, _ := store.ListRescans("", 0)
+	if len(rescans) != 2 {
+		t.Fatalf("expected a second scheduled rescan, got %d", len(rescans))
+	}
+	waitRescan(t, store, rescans[0].ID)
+}
@@ -1,18 +1,19 @@
 package scanner
 
 import (
-	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
-	"mime/multipart"
-	"net/http"
 	"sync"
 	"time"
 
 	"github.com/eniz1806/VaultS3/internal/metadata"
 	"github.com/eniz1806/VaultS3/internal/storage"
+	"github.com/eniz1806/VaultS3/internal/webhook"
 )
 
 // ScanJob represents an object to be scanned.
@@ -28,25 +29,52 @@ type ScanResult struct {
 	Key       string `json:"key"`
 	Status    string `json:"status"` // "clean", "infected", "error"
 	Detail    string `json:"detail,omitempty"`
+	Engine    string `json:"engine,omitempty"`
+	RescanID  uint64 `json:"rescan_id,omitempty"`
 	ScannedAt int64  `json:"scanned_at"`
 }
 
-// Scanner posts uploaded objects to a configurable webhook for virus scanning.
+var (
+	// ErrNotQuarantined is returned when releasing an object that was not
+	// quarantined by the scanner.
+	ErrNotQuarantined = errors.New("no quarantined object with this key")
+	// ErrReleaseConflict is returned when releasing an object whose
+	// original location holds an object again.
+	ErrReleaseConflict = errors.New("an object already exists at the original location")
+)
+
+var (
+	// enqueueTimeout is how long Scan waits for room in a full queue.
+	enqueueTimeout = 5 * time.Second
+	// pendingSweepInterval is how often objects still pending a scan are
+	// queued again, so that none is stranded by a full queue.
+	pendingSweepInterval = time.Minute
+)
+
+// Scanner scans uploaded objects for viruses with a Backend, a webhook by
+// default, and quarantines infected ones.
 type Scanner struct {
-	webhookURL       string
 	quarantineBucket string
 	failClosed       bool
 	maxScanSize      int64
 	timeout          time.Duration
 
-	store  *metadata.Store
-	engine storage.Engine
-	client *http.Client
+	store   *metadata.Store
+	engine  storage.Engine
+	backend Backend
+
+	jobs     chan ScanJob
+	queued   map[string]bool // "bucket/key" of jobs waiting in jobs
+	queuedMu sync.Mutex
+	results  []ScanResult
+	mu       sync.RWMutex
 
-	jobs    chan ScanJob
-	results []ScanResult
-	mu      sync.RWMutex
+	rescanRate int // default objects per second of rescans
+	schedules  []RescanSchedule
+	rescans    map[uint64]*runningRescan
+	rescanMu   sync.Mutex
 
+	ctx    context.Context
 	wg     sync.WaitGroup
 	cancel context.CancelFunc
 }
@@ -59,27 +87,114 @@ func NewScanner(store *metadata.Store, engine storage.Engine, webhookURL string,
 	if queueSize <= 0 {
 		queueSize = 256
 	}
+	timeout := time.Duration(timeoutSecs) * time.Second
 	return &Scanner{
-		webhookURL:       webhookURL,
 		quarantineBucket: quarantineBucket,
 		failClosed:       failClosed,
 		maxScanSize:      maxScanSize,
-		timeout:          time.Duration(timeoutSecs) * time.Second,
+		timeout:          timeout,
 		store:            store,
 		engine:           engine,
-		client:           &http.Client{Timeout: time.Duration(timeoutSecs) * time.Second},
+		backend:          newWebhookBackend(webhookURL, timeout),
 		jobs:             make(chan ScanJob, queueSize),
+		queued:           make(map[string]bool),
+		rescanRate:       defaultRescanRate,
+		rescans:          make(map[uint64]*runningRescan),
 	}
 }
 
-// Start launches scanner workers.
+// SetWebhookAuth sets how requests to the scan webhook are authenticated
+// and the named secrets its bearer token is read from.
+func (s *Scanner) SetWebhookAuth(auth *metadata.WebhookAuth, secrets webhook.Secrets) {
+	if b, ok := s.backend.(*webhookBackend); ok {
+		b.auth = auth
+		b.client.SetSecrets(secrets)
+	}
+}
+
+// SetBackend replaces the webhook with another scanning backend.
+func (s *Scanner) SetBackend(b Backend) {
+	s.backend = b
+}
+
+// Start launches scanner workers and queues the objects that were still
+// pending a scan when the server stopped, and again periodically.
 func (s *Scanner) Start(ctx context.Context, workers int) {
 	ctx, s.cancel = context.WithCancel(ctx)
+	s.rescanMu.Lock()
+	s.ctx = ctx
+	s.rescanMu.Unlock()
 	for i := 0; i < workers; i++ {
 		s.wg.Add(1)
 		go s.worker(ctx)
 	}
-	slog.Info("scanner started", "workers", workers, "webhook", s.webhookURL)
+	s.wg.Add(1)
+	go s.sweepLoop(ctx)
+	s.resumeRescans()
+	if len(s.schedules) > 0 {
+		s.wg.Add(1)
+		go s.scheduleLoop(ctx)
+	}
+	slog.Info("scanner started", "workers", workers, "backend", s.backend.Name())
+}
+
+func (s *Scanner) sweepLoop(ctx context.Context) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(pendingSweepInterval)
+	defer ticker.Stop()
+	for {
+		s.requeuePending(ctx)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// requeuePending queues the objects pending a scan that are not queued yet,
+// and objects skipped as too large once they fit the size limit.
+func (s *Scanner) requeuePending(ctx context.Context) {
+	pending, err := s.store.ListPendingScans()
+	if err != nil {
+		slog.Error("scanner: failed to list pending scans", "error", err)
+		return
+	}
+	for _, meta := range pending {
+		if s.tooLarge(meta.Size) {
+			s.skip(&meta)
+			continue
+		}
+		job := ScanJob{Bucket: meta.Bucket, Key: meta.Key, Size: meta.Size}
+		if !s.markQueued(job) {
+			continue
+		}
+		select {
+		case s.jobs <- job:
+		case <-ctx.Done():
+			s.unmarkQueued(job)
+			return
+		}
+	}
+}
+
+// markQueued records that job is about to be queued. It returns false when
+// the object is queued already: the worker reads its latest data anyway.
+func (s *Scanner) markQueued(job ScanJob) bool {
+	s.queuedMu.Lock()
+	defer s.queuedMu.Unlock()
+	k := job.Bucket + "/" + job.Key
+	if s.queued[k] {
+		return false
+	}
+	s.queued[k] = true
+	return true
+}
+
+func (s *Scanner) unmarkQueued(job ScanJob) {
+	s.queuedMu.Lock()
+	delete(s.queued, job.Bucket+"/"+job.Key)
+	s.queuedMu.Unlock()
 }
 
 // Stop shuts down the scanner gracefully.
@@ -90,14 +205,26 @@ func (s *Scanner) Stop() {
 	s.wg.Wait()
 }
 
-// Scan enqueues an object for scanning.
+// Scan enqueues an object for scanning, waiting for room in a full queue
+// for up to enqueueTimeout. Objects already queued are not queued twice.
 func (s *Scanner) Scan(bucket, key string, size int64) {
-	if s.maxScanSize > 0 && size > s.maxScanSize {
-		return // skip files larger than max scan size
+	if s.tooLarge(size) {
+		if meta, err := s.store.GetObjectMeta(bucket, key); err == nil {
+			s.skip(meta)
+		}
+		return
+	}
+	job := ScanJob{Bucket: bucket, Key: key, Size: size}
+	if !s.markQueued(job) {
+		return
 	}
+	timer := time.NewTimer(enqueueTimeout)
+	defer timer.Stop()
 	select {
-	case s.jobs <- ScanJob{Bucket: bucket, Key: key, Size: size}:
-	default:
+	case s.jobs <- job:
+	case <-timer.C:
+		// Objects pending a scan are queued again by the periodic sweep
+		s.unmarkQueued(job)
 		slog.Warn("scanner queue full, dropping scan", "bucket", bucket, "key", key)
 	}
 }
@@ -126,16 +253,30 @@ func (s *Scanner) RecentResults(limit int) []ScanResult {
 	return results
 }
 
-// QuarantineList returns objects in the quarantine bucket.
+// QuarantineList returns objects in the quarantine bucket, with where they
+// came from and why they were quarantined.
 func (s *Scanner) QuarantineList(store *metadata.Store, engine storage.Engine) []map[string]interface{} {
+	records := make(map[string]metadata.QuarantineRecord)
+	if recs, err := store.ListQuarantineRecords(); err == nil {
+		for _, rec := range recs {
+			records[rec.Key] = rec
+		}
+	}
 	objects, _, _ := engine.ListObjects(s.quarantineBucket, "", "", 1000)
 	var results []map[string]interface{}
 	for _, obj := range objects {
-		results = append(results, map[string]interface{}{
+		item := map[string]interface{}{
 			"key":           obj.Key,
 			"size":          obj.Size,
 			"last_modified": obj.LastModified,
-		})
+		}
+		if rec, ok := records[obj.Key]; ok {
+			item["bucket"] = rec.Bucket
+			item["object_key"] = rec.ObjectKey
+			item["reason"] = rec.Reason
+			item["quarantined_at"] = rec.QuarantinedAt
+		}
+		results = append(results, item)
 	}
 	return results
 }
@@ -147,129 +288,275 @@ func (s *Scanner) worker(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		case job := <-s.jobs:
+			s.unmarkQueued(job)
 			s.processJob(job)
 		}
 	}
 }
 
 func (s *Scanner) processJob(job ScanJob) {
+	s.scanObject(job, 0)
+}
+
+// scanObject scans the latest data of an object, records the outcome in
+// its scan history and quarantines it if infected. Rescans (rescanID > 0)
+// leave objects that could not be scanned in place even when failing
+// closed: they were already being served.
+func (s *Scanner) scanObject(job ScanJob, rescanID uint64) ScanResult {
 	result := ScanResult{
 		Bucket:    job.Bucket,
 		Key:       job.Key,
+		RescanID:  rescanID,
 		ScannedAt: time.Now().Unix(),
 	}
 
 	// Read the object
-	reader, size, err := s.engine.GetObject(job.Bucket, job.Key)
+	meta, _ := s.store.GetObjectMeta(job.Bucket, job.Key)
+	reader, _, err := s.readObject(job, meta)
 	if err != nil {
 		result.Status = "error"
 		result.Detail = fmt.Sprintf("failed to read object: %v", err)
 		s.addResult(result)
-		return
+		return result
 	}
 	defer reader.Close()
 
-	// POST to webhook as multipart/form-data
-	var body bytes.Buffer
-	writer := multipart.NewWriter(&body)
-	part, err := writer.CreateFormFile("file", job.Key)
-	if err != nil {
+	verdict, err := s.check(job, reader)
+	result.Engine = verdict.Engine
+	switch {
+	case err != nil:
 		result.Status = "error"
-		result.Detail = fmt.Sprintf("failed to create form: %v", err)
-		s.addResult(result)
-		return
+		result.Detail = err.Error()
+	case verdict.Infected:
+		result.Status = "infected"
+		result.Detail = verdict.Detail
+	default:
+		result.Status = "clean"
+		result.Detail = verdict.Detail
 	}
+	s.recordHistory(meta, result)
 
-	if _, err := io.Copy(part, reader); err != nil {
-		result.Status = "error"
-		result.Detail = fmt.Sprintf("failed to read object data: %v", err)
-		s.addResult(result)
-		return
+	switch {
+	case result.Status == "infected":
+		s.quarantine(job, meta, verdict.Detail)
+	case result.Status == "error" && s.failClosed && rescanID == 0:
+		// Move to quarantine on failure
+		s.quarantine(job, meta, result.Detail+" (fail-closed)")
+	case result.Status == "error":
+		s.setStatus(meta, metadata.ScanError)
+	default:
+		s.setStatus(meta, metadata.ScanClean)
 	}
 
-	// Add metadata fields
-	writer.WriteField("bucket", job.Bucket)
-	writer.WriteField("key", job.Key)
-	writer.WriteField("size", fmt.Sprintf("%d", size))
-	writer.Close()
+	s.addResult(result)
+	return result
+}
 
-	req, err := http.NewRequest("POST", s.webhookURL, &body)
-	if err != nil {
-		result.Status = "error"
-		result.Detail = fmt.Sprintf("failed to create request: %v", err)
-		s.addResult(result)
-		return
+// check looks the content up in the hash blocklist before asking the
+// backend, which is skipped for known-bad content.
+func (s *Scanner) check(job ScanJob, reader storage.ReadSeekCloser) (Verdict, error) {
+	if s.store.BlocklistSize() > 0 {
+		h := sha256.New()
+		if _, err := io.Copy(h, reader); err != nil {
+			return Verdict{}, fmt.Errorf("failed to read object data: %v", err)
+		}
+		if entry, err := s.store.GetBlocklistEntry(hex.EncodeToString(h.Sum(nil))); err == nil {
+			return Verdict{Infected: true, Detail: entry.Name, Engine: blocklistEngine}, nil
+		}
+		if _, err := reader.Seek(0, io.SeekStart); err != nil {
+			return Verdict{}, fmt.Errorf("failed to read object data: %v", err)
+		}
 	}
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-
-	resp, err := s.client.Do(req)
-	if err != nil {
-		// Webhook unreachable
-		result.Status = "error"
-		result.Detail = fmt.Sprintf("webhook unreachable: %v", err)
-		s.addResult(result)
+	return s.backend.Scan(context.Background(), job, reader)
+}
 
-		if s.failClosed {
-			// Move to quarantine on failure
-			s.quarantine(job, "webhook unreachable (fail-closed)")
-		}
+// recordHistory adds a scan to the history of the object it scanned.
+func (s *Scanner) recordHistory(meta *metadata.ObjectMeta, result ScanResult) {
+	if meta == nil {
 		return
 	}
-	defer resp.Body.Close()
+	rec := metadata.ScanRecord{
+		ScannedAt: result.ScannedAt,
+		ETag:      meta.ETag,
+		Engine:    result.Engine,
+		Verdict:   result.Status,
+		Detail:    result.Detail,
+		RescanID:  result.RescanID,
+	}
+	if err := s.store.AddScanRecord(meta.Bucket, meta.Key, rec); err != nil {
+		slog.Error("scanner: failed to record scan history", "bucket", meta.Bucket, "key", meta.Key, "error", err)
+	}
+}
+
+// readObject opens an object's latest data, which versioned buckets keep
+// in version storage.
+func (s *Scanner) readObject(job ScanJob, meta *metadata.ObjectMeta) (storage.ReadSeekCloser, int64, error) {
+	if meta != nil && meta.VersionID != "" {
+		return s.engine.GetObjectVersion(job.Bucket, job.Key, meta.VersionID)
+	}
+	return s.engine.GetObject(job.Bucket, job.Key)
+}
 
-	respBody, _ := io.ReadAll(resp.Body)
+func (s *Scanner) tooLarge(size int64) bool {
+	return s.maxScanSize > 0 && size > s.maxScanSize
+}
 
-	switch {
-	case resp.StatusCode == 200:
-		// Clean
-		result.Status = "clean"
-		result.Detail = string(respBody)
-	case resp.StatusCode == 406 || resp.StatusCode == 403:
-		// Infected
-		result.Status = "infected"
-		result.Detail = string(respBody)
-		s.quarantine(job, string(respBody))
-	default:
-		result.Status = "error"
-		result.Detail = fmt.Sprintf("webhook returned %d: %s", resp.StatusCode, string(respBody))
-		if s.failClosed {
-			s.quarantine(job, result.Detail)
-		}
+// skip marks an object too large to be scanned as skipped. Skipped objects
+// stay held back from serving, so a large upload cannot bypass the scanner;
+// they are scanned once max_scan_size_bytes is raised to fit them.
+func (s *Scanner) skip(meta *metadata.ObjectMeta) {
+	if meta.ScanStatus != metadata.ScanSkipped {
+		s.setStatus(meta, metadata.ScanSkipped)
 	}
+}
 
-	s.addResult(result)
+// setStatus records the outcome of a scan on an object that is held.
+func (s *Scanner) setStatus(meta *metadata.ObjectMeta, status string) {
+	if meta == nil || !metadata.ScanHeld(meta.ScanStatus) {
+		return
+	}
+	if err := s.store.SetObjectScanStatus(meta.Bucket, meta.Key, meta.ETag, status); err != nil {
+		slog.Error("scanner: failed to set scan status", "bucket", meta.Bucket, "key", meta.Key, "error", err)
+	}
 }
 
-func (s *Scanner) quarantine(job ScanJob, reason string) {
+func (s *Scanner) quarantine(job ScanJob, meta *metadata.ObjectMeta, reason string) {
 	// Ensure quarantine bucket exists
 	s.engine.CreateBucketDir(s.quarantineBucket)
 	s.store.CreateBucket(s.quarantineBucket)
 
 	// Read the object
-	reader, size, err := s.engine.GetObject(job.Bucket, job.Key)
+	reader, size, err := s.readObject(job, meta)
 	if err != nil {
 		slog.Error("scanner quarantine: failed to read", "bucket", job.Bucket, "key", job.Key, "error", err)
 		return
 	}
 	defer reader.Close()
 
-	// Write to quarantine bucket with original bucket/key as the key
+	// Write to quarantine bucket with original bucket/key as the key, and
+	// the version ID so that versions of one key do not overwrite each other
 	quarantineKey := fmt.Sprintf("%s/%s", job.Bucket, job.Key)
+	if meta != nil && meta.VersionID != "" {
+		quarantineKey += "?versionId=" + meta.VersionID
+	}
 	if _, _, err := s.engine.PutObject(s.quarantineBucket, quarantineKey, reader, size); err != nil {
 		slog.Error("scanner quarantine: failed to write", "key", quarantineKey, "error", err)
 		return
 	}
+	rec := metadata.QuarantineRecord{Key: quarantineKey, Bucket: job.Bucket, ObjectKey: job.Key, Reason: reason}
+	if meta != nil {
+		rec.Meta = *meta
+		rec.History, _ = s.store.GetScanHistory(job.Bucket, job.Key)
+	} else {
+		rec.Meta = metadata.ObjectMeta{Bucket: job.Bucket, Key: job.Key, Size: size, ContentType: "application/octet-stream"}
+	}
+	if err := s.store.PutQuarantineRecord(rec); err != nil {
+		slog.Error("scanner quarantine: failed to record", "key", quarantineKey, "error", err)
+	}
 
-	// Delete from original bucket
-	if err := s.engine.DeleteObject(job.Bucket, job.Key); err != nil {
+	// Remove the scanned version from the original bucket, unless it was
+	// overwritten since the scan; deleting the latest version of a
+	// versioned object makes the previous one latest again
+	versionID, etag := "", ""
+	if meta != nil {
+		versionID, etag = meta.VersionID, meta.ETag
+	}
+	removed, err := s.store.RemoveObjectVersion(job.Bucket, job.Key, versionID, etag)
+	if err != nil {
+		slog.Error("scanner quarantine: failed to remove original", "bucket", job.Bucket, "key", job.Key, "error", err)
+		return
+	}
+	if meta != nil && !removed {
+		s.engine.DeleteObject(s.quarantineBucket, quarantineKey)
+		s.store.DeleteQuarantineRecord(quarantineKey)
+		slog.Info("scanner quarantine: object changed since the scan, left in place", "bucket", job.Bucket, "key", job.Key)
+		return
+	}
+	if versionID != "" {
+		err = s.engine.DeleteObjectVersion(job.Bucket, job.Key, versionID)
+	} else {
+		err = s.engine.DeleteObject(job.Bucket, job.Key)
+	}
+	if err != nil {
 		slog.Error("scanner quarantine: failed to delete original", "bucket", job.Bucket, "key", job.Key, "error", err)
 		return
 	}
-	s.store.DeleteObjectMeta(job.Bucket, job.Key)
 
 	slog.Warn("scanner quarantined object", "bucket", job.Bucket, "key", job.Key, "reason", reason)
 }
 
+// Release moves a quarantined object back to where it came from and
+// restores its metadata. key is the object's key in the quarantine bucket.
+func (s *Scanner) Release(key string) (*metadata.ObjectMeta, error) {
+	rec, err := s.store.GetQuarantineRecord(key)
+	if err != nil {
+		return nil, ErrNotQuarantined
+	}
+	if !s.store.BucketExists(rec.Bucket) {
+		return nil, fmt.Errorf("bucket %s no longer exists", rec.Bucket)
+	}
+	if rec.Meta.VersionID != "" {
+		if _, err := s.store.GetObjectVersion(rec.Bucket, rec.ObjectKey, rec.Meta.VersionID); err == nil {
+			return nil, ErrReleaseConflict
+		}
+	} else if _, err := s.store.GetObjectMeta(rec.Bucket, rec.ObjectKey); err == nil {
+		return nil, ErrReleaseConflict
+	}
+
+	reader, size, err := s.engine.GetObject(s.quarantineBucket, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read quarantined object: %v", err)
+	}
+	defer reader.Close()
+
+	meta := rec.Meta
+	meta.ScanStatus = metadata.ScanReleased
+	if meta.VersionID != "" {
+		_, _, err = s.engine.PutObjectVersion(rec.Bucket, rec.ObjectKey, meta.VersionID, reader, size)
+	} else {
+		_, _, err = s.engine.PutObject(rec.Bucket, rec.ObjectKey, reader, size)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore object: %v", err)
+	}
+	latest := true
+	if meta.VersionID != "" {
+		// A released version is latest again only if no newer version
+		// was written while it was quarantined
+		newest, err := s.store.NewestObjectVersion(rec.Bucket, rec.ObjectKey)
+		if err != nil {
+			return nil, err
+		}
+		latest = newest == nil || newest.LastModified < meta.LastModified ||
+			(newest.LastModified == meta.LastModified && newest.VersionID < meta.VersionID)
+		meta.IsLatest = latest
+		if err := s.store.PutObjectVersion(meta); err != nil {
+			return nil, err
+		}
+	}
+	if latest {
+		if meta.VersionID != "" {
+			// The version that was latest until now no longer is
+			if prev, err := s.store.GetObjectMeta(rec.Bucket, rec.ObjectKey); err == nil && prev.VersionID != "" {
+				prev.IsLatest = false
+				s.store.PutObjectVersion(*prev)
+			}
+		}
+		if err := s.store.PutObjectMeta(meta); err != nil {
+			return nil, err
+		}
+		if len(rec.History) > 0 {
+			s.store.PutScanHistory(rec.Bucket, rec.ObjectKey, rec.History)
+		}
+	}
+
+	reader.Close()
+	s.engine.DeleteObject(s.quarantineBucket, key)
+	s.store.DeleteQuarantineRecord(key)
+	slog.Warn("scanner released object from quarantine", "bucket", rec.Bucket, "key", rec.ObjectKey)
+	return &meta, nil
+}
+
 func (s *Scanner) addResult(r ScanResult) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
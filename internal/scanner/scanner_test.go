@@ -0,0 +1,280 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eniz1806/VaultS3/internal/metadata"
+	"github.com/eniz1806/VaultS3/internal/storage"
+)
+
+// stubBackend reports content containing "EICAR" as infected and content
+// containing "BROKEN" as unscannable.
+type stubBackend struct{}
+
+func (stubBackend) Name() string { return "stub" }
+
+func (stubBackend) Scan(_ context.Context, _ ScanJob, r io.Reader) (Verdict, error) {
+	data, _ := io.ReadAll(r)
+	switch {
+	case strings.Contains(string(data), "EICAR"):
+		return Verdict{Infected: true, Detail: "Eicar-Test-Signature"}, nil
+	case strings.Contains(string(data), "BROKEN"):
+		return Verdict{}, errors.New("scanner broke")
+	}
+	return Verdict{Detail: "OK"}, nil
+}
+
+func newTestScanner(t *testing.T, failClosed bool) (*Scanner, *metadata.Store, storage.Engine) {
+	t.Helper()
+	dir := t.TempDir()
+	store, err := metadata.NewStore(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	engine, err := storage.NewFileSystem(filepath.Join(dir, "data"))
+	if err != nil {
+		t.Fatalf("NewFileSystem: %v", err)
+	}
+	store.CreateBucket("b")
+	engine.CreateBucketDir("b")
+	s := NewScanner(store, engine, "", 1, 5, "quarantine", failClosed, 16, 4)
+	s.SetBackend(stubBackend{})
+	return s, store, engine
+}
+
+// putPending writes an object the way the S3 handler does in a bucket that
+// scans before serving.
+func putPending(t *testing.T, store *metadata.Store, engine storage.Engine, key, body string) metadata.ObjectMeta {
+	t.Helper()
+	_, etag, err := engine.PutObject("b", key, strings.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatalf("PutObject: %v", err)
+	}
+	meta := metadata.ObjectMeta{Bucket: "b", Key: key, ETag: etag, Size: int64(len(body)),
+		ContentType: "text/plain", Tags: map[string]string{"team": "a"}, ScanStatus: metadata.ScanPending}
+	store.PutObjectMeta(meta)
+	return meta
+}
+
+func scanStatus(t *testing.T, store *metadata.Store, key string) string {
+	t.Helper()
+	meta, err := store.GetObjectMeta("b", key)
+	if err != nil {
+		return "missing"
+	}
+	return meta.ScanStatus
+}
+
+func TestScanner_PendingObjects(t *testing.T) {
+	s, store, engine := newTestScanner(t, false)
+	putPending(t, store, engine, "clean.txt", "hello")
+	putPending(t, store, engine, "broken.txt", "BROKEN")
+	putPending(t, store, engine, "large.txt", strings.Repeat("x", 17))
+	overwritten := putPending(t, store, engine, "overwritten.txt", "old")
+
+	if pending, _ := store.ListPendingScans(); len(pending) != 4 {
+		t.Fatalf("pending = %d, want 4", len(pending))
+	}
+
+	s.processJob(ScanJob{Bucket: "b", Key: "clean.txt", Size: 5})
+	s.processJob(ScanJob{Bucket: "b", Key: "broken.txt", Size: 6})
+	s.Scan("b", "large.txt", 17)
+	// A newer write keeps its own pending state
+	store.SetObjectScanStatus("b", "overwritten.txt", "stale-etag", metadata.ScanClean)
+
+	for key, want := range map[string]string{
+		"clean.txt":       metadata.ScanClean,
+		"broken.txt":      metadata.ScanError,
+		"large.txt":       metadata.ScanSkipped,
+		"overwritten.txt": metadata.ScanPending,
+	} {
+		if got := scanStatus(t, store, key); got != want {
+			t.Errorf("%s: scan status %q, want %q", key, got, want)
+		}
+	}
+	// Skipped objects stay held and are scanned once they fit the limit
+	pending, _ := store.ListPendingScans()
+	if len(pending) != 2 || pending[0].Key != "large.txt" || pending[1].Key != overwritten.Key {
+		t.Fatalf("pending = %+v", pending)
+	}
+	s.maxScanSize = 32
+	s.processJob(ScanJob{Bucket: "b", Key: "large.txt", Size: 17})
+	if got := scanStatus(t, store, "large.txt"); got != metadata.ScanClean {
+		t.Fatalf("large.txt after raising the limit: scan status %q", got)
+	}
+
+	// Pending objects are queued again on start
+	s.Start(context.Background(), 1)
+	defer s.Stop()
+	deadline := time.Now().Add(5 * time.Second)
+	for scanStatus(t, store, overwritten.Key) == metadata.ScanPending {
+		if time.Now().After(deadline) {
+			t.Fatal("pending object was not scanned after start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := scanStatus(t, store, overwritten.Key); got != metadata.ScanClean {
+		t.Fatalf("requeued object: scan status %q", got)
+	}
+}
+
+func TestScanner_FullQueueAndSweep(t *testing.T) {
+	defer func(d time.Duration) { enqueueTimeout = d }(enqueueTimeout)
+	enqueueTimeout = 10 * time.Millisecond
+	s, store, engine := newTestScanner(t, false)
+	keys := []string{"a", "b", "c", "d", "e"}
+	for _, key := range keys {
+		putPending(t, store, engine, key, "hello")
+	}
+
+	// The fifth scan does not fit the queue of four and is dropped after
+	// waiting; a scan of an object already queued is not queued again
+	for _, key := range keys {
+		s.Scan("b", key, 5)
+	}
+	s.Scan("b", "a", 5)
+	if n := s.QueueDepth(); n != 4 {
+		t.Fatalf("queue depth = %d, want 4", n)
+	}
+	for range 4 {
+		job := <-s.jobs
+		s.unmarkQueued(job)
+		s.processJob(job)
+	}
+	if got := scanStatus(t, store, "e"); got != metadata.ScanPending {
+		t.Fatalf("dropped object: scan status %q", got)
+	}
+
+	// The sweep queues the object that is still pending
+	s.requeuePending(context.Background())
+	if n := s.QueueDepth(); n != 1 {
+		t.Fatalf("queue depth after sweep = %d, want 1", n)
+	}
+	s.processJob(<-s.jobs)
+	if got := scanStatus(t, store, "e"); got != metadata.ScanClean {
+		t.Errorf("swept object: scan status %q", got)
+	}
+}
+
+func TestScanner_QuarantineAndRelease(t *testing.T) {
+	s, store, engine := newTestScanner(t, true)
+	putPending(t, store, engine, "virus.txt", "EICAR")
+	putPending(t, store, engine, "broken.txt", "BROKEN")
+
+	s.processJob(ScanJob{Bucket: "b", Key: "virus.txt", Size: 5})
+	s.processJob(ScanJob{Bucket: "b", Key: "broken.txt", Size: 6})
+	for _, key := range []string{"virus.txt", "broken.txt"} {
+		if got := scanStatus(t, store, key); got != "missing" {
+			t.Fatalf("%s should be quarantined, scan status %q", key, got)
+		}
+	}
+
+	rec, err := store.GetQuarantineRecord("b/broken.txt")
+	if err != nil || rec.Bucket != "b" || rec.ObjectKey != "broken.txt" || rec.Reason != "scanner broke (fail-closed)" {
+		t.Fatalf("quarantine record = %+v, %v", rec, err)
+	}
+	list := s.QuarantineList(store, engine)
+	if len(list) != 2 || list[0]["object_key"] == nil {
+		t.Fatalf("quarantine list = %+v", list)
+	}
+
+	meta, err := s.Release("b/broken.txt")
+	if err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	if meta.ScanStatus != metadata.ScanReleased || meta.ContentType != "text/plain" || meta.Tags["team"] != "a" {
+		t.Fatalf("released meta = %+v", meta)
+	}
+	reader, _, err := engine.GetObject("b", "broken.txt")
+	if err != nil {
+		t.Fatalf("released object: %v", err)
+	}
+	data, _ := io.ReadAll(reader)
+	reader.Close()
+	if string(data) != "BROKEN" {
+		t.Fatalf("released content = %q", data)
+	}
+	if _, _, err := engine.GetObject("quarantine", "b/broken.txt"); err == nil {
+		t.Fatal("released object should leave the quarantine bucket")
+	}
+	if _, err := s.Release("b/broken.txt"); !errors.Is(err, ErrNotQuarantined) {
+		t.Fatalf("second release: %v", err)
+	}
+
+	// The original location was written again
+	putPending(t, store, engine, "virus.txt", "new")
+	if _, err := s.Release("b/virus.txt"); !errors.Is(err, ErrReleaseConflict) {
+		t.Fatalf("release over existing object: %v", err)
+	}
+}
+
+func TestScanner_QuarantineVersions(t *testing.T) {
+	s, store, engine := newTestScanner(t, false)
+	putVersion := func(versionID, body string, modified int64, latest bool) metadata.ObjectMeta {
+		t.Helper()
+		_, etag, err := engine.PutObjectVersion("b", "doc.txt", versionID, strings.NewReader(body), int64(len(body)))
+		if err != nil {
+			t.Fatalf("PutObjectVersion: %v", err)
+		}
+		meta := metadata.ObjectMeta{Bucket: "b", Key: "doc.txt", ETag: etag, Size: int64(len(body)),
+			VersionID: versionID, IsLatest: latest, LastModified: modified, ScanStatus: metadata.ScanPending}
+		store.PutObjectVersion(meta)
+		if latest {
+			store.PutObjectMeta(meta)
+		}
+		return meta
+	}
+	v1 := putVersion("v1", "EICAR one", 100, false)
+	putVersion("v2", "EICAR two", 200, true)
+
+	// Quarantining the latest version makes the previous one latest again
+	s.processJob(ScanJob{Bucket: "b", Key: "doc.txt"})
+	if meta, err := store.GetObjectMeta("b", "doc.txt"); err != nil || meta.VersionID != "v1" {
+		t.Fatalf("latest after quarantine = %+v, %v", meta, err)
+	}
+	// Each version gets its own quarantine entry
+	s.quarantine(ScanJob{Bucket: "b", Key: "doc.txt"}, &v1, "test")
+	for _, key := range []string{"b/doc.txt?versionId=v1", "b/doc.txt?versionId=v2"} {
+		if _, err := store.GetQuarantineRecord(key); err != nil {
+			t.Errorf("quarantine record %s: %v", key, err)
+		}
+	}
+	if _, err := store.GetObjectMeta("b", "doc.txt"); err == nil {
+		t.Fatal("latest pointer should be gone with the last version")
+	}
+
+	// Releasing the older version does not hide the newer one
+	if _, err := s.Release("b/doc.txt?versionId=v2"); err != nil {
+		t.Fatalf("Release v2: %v", err)
+	}
+	if _, err := s.Release("b/doc.txt?versionId=v1"); err != nil {
+		t.Fatalf("Release v1: %v", err)
+	}
+	if meta, err := store.GetObjectMeta("b", "doc.txt"); err != nil || meta.VersionID != "v2" {
+		t.Fatalf("latest after release = %+v, %v", meta, err)
+	}
+
+	// An object overwritten since it was scanned is left in place
+	stale := putPending(t, store, engine, "over.txt", "EICAR")
+	putPending(t, store, engine, "over.txt", "fine")
+	s.quarantine(ScanJob{Bucket: "b", Key: "over.txt"}, &stale, "test")
+	reader, _, err := engine.GetObject("b", "over.txt")
+	if err != nil {
+		t.Fatalf("overwritten object: %v", err)
+	}
+	data, _ := io.ReadAll(reader)
+	reader.Close()
+	if string(data) != "fine" || scanStatus(t, store, "over.txt") != metadata.ScanPending {
+		t.Fatalf("overwritten object = %q, scan status %q", data, scanStatus(t, store, "over.txt"))
+	}
+	if _, err := store.GetQuarantineRecord("b/over.txt"); err == nil {
+		t.Error("quarantine record of a stale scan should be dropped")
+	}
+}
@@ -0,0 +1,445 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/eniz1806/VaultS3/internal/metadata"
+	"github.com/eniz1806/VaultS3/internal/storage"
+	bolt "go.etcd.io/bbolt"
+)
+
+// contentBucket holds the extracted text of objects by "bucket/key". It is
+// kept across rebuilds, which link the content back to the new documents
+// when the object has not changed.
+var contentBucket = []byte("content")
+
+var (
+	infoContentDocs   = []byte("content_docs")   // documents with content
+	infoContentLength = []byte("content_length") // total terms of all content
+)
+
+// maxSnippetText is how much of an object's text is kept for snippets.
+const maxSnippetText = 64 << 10
+
+// contentDoc is the indexed text of an object. Its terms are the "c:"
+// terms of the document, with their frequencies stored in the postings.
+type contentDoc struct {
+	ETag   string            `json:"etag"`
+	Length int               `json:"length"` // number of terms
+	Terms  map[string]uint32 `json:"terms"`  // stemmed term -> frequency
+	Text   string            `json:"text"`   // start of the text, for snippets
+}
+
+// SetContent indexes the text of an object. It does nothing when the
+// object is not indexed or has changed since etag was read.
+func (idx *Index) SetContent(bucket, key, etag, text string) error {
+	return idx.db.Update(func(tx *bolt.Tx) error {
+		mk := []byte(bucket + "/" + key)
+		v := tx.Bucket(idsBucket).Get(mk)
+		if v == nil {
+			return nil
+		}
+		id := binary.BigEndian.Uint64(v)
+		var doc storedDoc
+		if data := tx.Bucket(docsBucket).Get(v); data == nil || json.Unmarshal(data, &doc) != nil || doc.ETag != etag {
+			return nil
+		}
+		if err := unlinkContent(tx, mk, id); err != nil {
+			return err
+		}
+
+		cd := contentDoc{ETag: etag, Terms: make(map[string]uint32)}
+		for _, term := range contentTerms(text) {
+			cd.Terms[normalizeTerm(term)]++
+			cd.Length++
+		}
+		cd.Text = truncateUTF8(text, maxSnippetText)
+		data, err := json.Marshal(cd)
+		if err != nil {
+			return err
+		}
+		if err := tx.Bucket(contentBucket).Put(mk, data); err != nil {
+			return err
+		}
+		return linkContent(tx, id, cd)
+	})
+}
+
+// HasContent reports whether the text of an object with the given ETag is
+// indexed.
+func (idx *Index) HasContent(bucket, key, etag string) bool {
+	has := false
+	idx.db.View(func(tx *bolt.Tx) error {
+		var cd contentDoc
+		if data := tx.Bucket(contentBucket).Get([]byte(bucket + "/" + key)); data != nil && json.Unmarshal(data, &cd) == nil {
+			has = cd.ETag == etag
+		}
+		return nil
+	})
+	return has
+}
+
+// ClearContent removes the text of every object in a bucket from the index.
+func (idx *Index) ClearContent(bucket string) error {
+	prefix := []byte(bucket + "/")
+	var keys [][]byte
+	err := idx.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(contentBucket).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			keys = append(keys, bytes.Clone(k))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for start := 0; start < len(keys); start += buildBatchSize {
+		batch := keys[start:min(start+buildBatchSize, len(keys))]
+		err := idx.db.Update(func(tx *bolt.Tx) error {
+			ids := tx.Bucket(idsBucket)
+			for _, mk := range batch {
+				var id uint64
+				if v := ids.Get(mk); v != nil {
+					id = binary.BigEndian.Uint64(v)
+				}
+				if err := unlinkContent(tx, mk, id); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// linkContent adds the "c:" postings of a document's text, with the term
+// frequencies as posting values.
+func linkContent(tx *bolt.Tx, id uint64, cd contentDoc) error {
+	for term, n := range cd.Terms {
+		if err := addPostingValue(tx, "c:"+term, id, binary.BigEndian.AppendUint32(nil, n)); err != nil {
+			return err
+		}
+	}
+	if err := tx.Bucket(lengthsBucket).Put(docID(id), binary.BigEndian.AppendUint32(nil, uint32(cd.Length))); err != nil {
+		return err
+	}
+	if err := addInfo(tx, infoContentDocs, 1); err != nil {
+		return err
+	}
+	return addInfo(tx, infoContentLength, int64(cd.Length))
+}
+
+// unlinkContent removes the text of an object and its "c:" postings. The
+// text of an object with a document is always linked to it; id is 0 when
+// the object has no document, as during a rebuild.
+func unlinkContent(tx *bolt.Tx, mk []byte, id uint64) error {
+	content := tx.Bucket(contentBucket)
+	data := content.Get(mk)
+	if data == nil {
+		return nil
+	}
+	var cd contentDoc
+	if json.Unmarshal(data, &cd) == nil && id != 0 {
+		for term := range cd.Terms {
+			if err := removePosting(tx, "c:"+term, id); err != nil {
+				return err
+			}
+		}
+		if err := tx.Bucket(lengthsBucket).Delete(docID(id)); err != nil {
+			return err
+		}
+		if err := addInfo(tx, infoContentDocs, -1); err != nil {
+			return err
+		}
+		if err := addInfo(tx, infoContentLength, -int64(cd.Length)); err != nil {
+			return err
+		}
+	}
+	return content.Delete(mk)
+}
+
+// truncateUTF8 cuts s to at most n bytes without splitting a character.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && s[n]&0xc0 == 0x80 {
+		n--
+	}
+	return s[:n]
+}
+
+// ContentStats describes the work of a ContentIndexer.
+type ContentStats struct {
+	QueueDepth int   `json:"queue_depth"`
+	Indexed    int64 `json:"indexed"`
+	Failed     int64 `json:"failed"`
+	Dropped    int64 `json:"dropped"` // not queued because the queue was full
+}
+
+// backfillInterval is how often content-indexed buckets are backfilled
+// after Enqueue dropped objects because the queue was full.
+var backfillInterval = 5 * time.Minute
+
+type contentJob struct {
+	bucket string
+	key    string
+	etag   string
+	kind   string
+}
+
+// ContentIndexer extracts the text of objects in buckets with content
+// indexing enabled and adds it to the index. Extraction runs in a bounded
+// pool of workers; Enqueue never blocks, so uploads are not slowed down.
+type ContentIndexer struct {
+	idx     *Index
+	store   *metadata.Store
+	engine  storage.Engine
+	workers int
+	maxSize int64
+	jobs    chan contentJob
+
+	backfillMu  sync.Mutex
+	backfilling map[string]bool // buckets with a backfill running
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	indexed atomic.Int64
+	failed  atomic.Int64
+	dropped atomic.Int64
+}
+
+// NewContentIndexer creates a content indexer. Objects larger than maxSize
+// bytes are not read.
+func NewContentIndexer(idx *Index, store *metadata.Store, engine storage.Engine, workers, queueSize int, maxSize int64) *ContentIndexer {
+	if workers <= 0 {
+		workers = 2
+	}
+	if queueSize <= 0 {
+		queueSize = 1024
+	}
+	return &ContentIndexer{
+		idx:     idx,
+		store:   store,
+		engine:  engine,
+		workers: workers,
+		maxSize: maxSize,
+		jobs:    make(chan contentJob, queueSize),
+
+		backfilling: make(map[string]bool),
+	}
+}
+
+// Start starts the workers and queues the objects of content-indexed
+// buckets whose text is missing or out of date, at once and again
+// periodically after objects were dropped.
+func (ci *ContentIndexer) Start(ctx context.Context) {
+	ci.ctx, ci.cancel = context.WithCancel(ctx)
+	for i := 0; i < ci.workers; i++ {
+		ci.wg.Add(1)
+		go ci.worker()
+	}
+	ci.backfillAll()
+	ci.wg.Add(1)
+	go ci.backfillLoop()
+}
+
+func (ci *ContentIndexer) backfillLoop() {
+	defer ci.wg.Done()
+	ticker := time.NewTicker(backfillInterval)
+	defer ticker.Stop()
+	var seen int64
+	for {
+		select {
+		case <-ticker.C:
+		case <-ci.ctx.Done():
+			return
+		}
+		if n := ci.dropped.Load(); n != seen {
+			seen = n
+			ci.backfillAll()
+		}
+	}
+}
+
+// backfillAll backfills every bucket with content indexing enabled.
+func (ci *ContentIndexer) backfillAll() {
+	buckets, err := ci.store.ListBuckets()
+	if err != nil {
+		slog.Warn("content index: failed to list buckets", "error", err)
+		return
+	}
+	for _, b := range buckets {
+		if b.ContentIndex {
+			ci.Backfill(b.Name)
+		}
+	}
+}
+
+// Stop stops the workers, dropping queued jobs.
+func (ci *ContentIndexer) Stop() {
+	if ci.cancel != nil {
+		ci.cancel()
+	}
+	ci.wg.Wait()
+}
+
+// Stats returns the queue depth and job counts.
+func (ci *ContentIndexer) Stats() ContentStats {
+	return ContentStats{
+		QueueDepth: len(ci.jobs),
+		Indexed:    ci.indexed.Load(),
+		Failed:     ci.failed.Load(),
+		Dropped:    ci.dropped.Load(),
+	}
+}
+
+// Enqueue queues an object for text extraction if its bucket has content
+// indexing enabled, it is of a type the index reads and its text is not
+// indexed yet. When the queue is full the object is dropped; the periodic
+// backfill of the bucket picks it up later.
+func (ci *ContentIndexer) Enqueue(bucket, key string, meta metadata.ObjectMeta) {
+	job, ok := ci.job(bucket, key, meta)
+	if !ok {
+		return
+	}
+	select {
+	case ci.jobs <- job:
+	default:
+		ci.dropped.Add(1)
+	}
+}
+
+func (ci *ContentIndexer) job(bucket, key string, meta metadata.ObjectMeta) (contentJob, bool) {
+	if meta.DeleteMarker || (ci.maxSize > 0 && meta.Size > ci.maxSize) || !scanPassed(&meta) {
+		return contentJob{}, false
+	}
+	kind := contentKind(meta.ContentType, key)
+	if kind == "" {
+		return contentJob{}, false
+	}
+	info, err := ci.store.GetBucket(bucket)
+	if err != nil || info == nil || !info.ContentIndex {
+		return contentJob{}, false
+	}
+	if ci.idx.HasContent(bucket, key, meta.ETag) {
+		return contentJob{}, false
+	}
+	return contentJob{bucket: bucket, key: key, etag: meta.ETag, kind: kind}, true
+}
+
+// Backfill queues, in the background, every object of a bucket whose text
+// is missing or out of date, waiting for room in the queue. It does nothing
+// while a backfill of the bucket is running.
+func (ci *ContentIndexer) Backfill(bucket string) {
+	if ci.ctx == nil {
+		return
+	}
+	ci.backfillMu.Lock()
+	defer ci.backfillMu.Unlock()
+	if ci.backfilling[bucket] {
+		return
+	}
+	ci.backfilling[bucket] = true
+	ci.wg.Add(1)
+	go func() {
+		defer ci.wg.Done()
+		defer func() {
+			ci.backfillMu.Lock()
+			delete(ci.backfilling, bucket)
+			ci.backfillMu.Unlock()
+		}()
+		// Text is linked to documents, which a build is still adding
+		for ci.idx.Building() {
+			select {
+			case <-time.After(time.Second):
+			case <-ci.ctx.Done():
+				return
+			}
+		}
+		after := ""
+		for {
+			page, err := ci.store.ListObjectMetaAfter(bucket, "", after, buildBatchSize)
+			if err != nil {
+				slog.Warn("content index: backfill failed", "bucket", bucket, "error", err)
+				return
+			}
+			if len(page) == 0 {
+				return
+			}
+			for _, meta := range page {
+				job, ok := ci.job(bucket, meta.Key, meta)
+				if !ok {
+					continue
+				}
+				select {
+				case ci.jobs <- job:
+				case <-ci.ctx.Done():
+					return
+				}
+			}
+			after = page[len(page)-1].Key
+		}
+	}()
+}
+
+func (ci *ContentIndexer) worker() {
+	defer ci.wg.Done()
+	for {
+		select {
+		case <-ci.ctx.Done():
+			return
+		case job := <-ci.jobs:
+			if err := ci.process(job); err != nil {
+				ci.failed.Add(1)
+				slog.Warn("content index: extraction failed", "bucket", job.bucket, "key", job.key, "error", err)
+			}
+		}
+	}
+}
+
+func (ci *ContentIndexer) process(job contentJob) error {
+	// Skip objects overwritten or deleted since they were queued
+	meta, err := ci.store.GetObjectMeta(job.bucket, job.key)
+	if err != nil || meta.ETag != job.etag || !scanPassed(meta) {
+		return nil
+	}
+	reader, _, err := ci.engine.GetObject(job.bucket, job.key)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	limit := ci.maxSize
+	if limit <= 0 {
+		limit = 10 << 20
+	}
+	data, err := io.ReadAll(io.LimitReader(reader, limit))
+	if err != nil {
+		return err
+	}
+	if err := ci.idx.SetContent(job.bucket, job.key, job.etag, extractText(job.kind, data)); err != nil {
+		return err
+	}
+	ci.indexed.Add(1)
+	return nil
+}
+
+// scanPassed reports whether the text of an object may be indexed: objects
+// the virus scanner has not found clean are left out until it does, when
+// the scan status change queues them again.
+func scanPassed(meta *metadata.ObjectMeta) bool {
+	return meta.ScanStatus == "" || meta.ScanStatus == metadata.ScanClean
+}
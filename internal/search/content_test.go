@@ -0,0 +1,361 @@
+package search
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eniz1806/VaultS3/internal/metadata"
+	"github.com/eniz1806/VaultS3/internal/storage"
+)
+
+func TestStem(t *testing.T) {
+	tests := map[string]string{
+		"caresses":       "caress",
+		"ponies":         "poni",
+		"cats":           "cat",
+		"agreed":         "agre",
+		"plastered":      "plaster",
+		"motoring":       "motor",
+		"hopping":        "hop",
+		"falling":        "fall",
+		"filing":         "file",
+		"happy":          "happi",
+		"relational":     "relat",
+		"conditional":    "condit",
+		"generalization": "gener",
+		"indexing":       "index",
+		"indexed":        "index",
+		"running":        "run",
+		"connection":     "connect",
+		"goodness":       "good",
+		"go":             "go",
+		"r2d2":           "r2d2",
+	}
+	for word, want := range tests {
+		if got := stem(word); got != want {
+			t.Errorf("stem(%q) = %q, want %q", word, got, want)
+		}
+	}
+}
+
+func TestContentKind(t *testing.T) {
+	tests := []struct {
+		ct, key, want string
+	}{
+		{"text/html; charset=utf-8", "index", kindHTML},
+		{"application/json", "data", kindJSON},
+		{"application/vnd.api+json", "data", kindJSON},
+		{"application/pdf", "report", kindPDF},
+		{"text/csv", "table", kindText},
+		{"text/plain", "notes", kindText},
+		{"text/plain", "page.html", kindHTML},
+		{"application/octet-stream", "README.md", kindText},
+		{"", "doc.pdf", kindPDF},
+		{"image/png", "photo.txt", ""},
+		{"application/octet-stream", "blob.bin", ""},
+	}
+	for _, tt := range tests {
+		if got := contentKind(tt.ct, tt.key); got != tt.want {
+			t.Errorf("contentKind(%q, %q) = %q, want %q", tt.ct, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestExtractText(t *testing.T) {
+	html := `<html><head><style>p { color: red }</style><script>var hidden = 1;</script></head>
+<body><!-- comment --><h1>Quarterly&nbsp;report</h1><p>Revenue &amp; growth</p></body></html>`
+	got := strings.Join(strings.Fields(extractText(kindHTML, []byte(html))), " ")
+	if got != "Quarterly report Revenue & growth" {
+		t.Errorf("html text = %q", got)
+	}
+
+	got = extractText(kindJSON, []byte(`{"title": "Invoice", "lines": [{"item": "widget", "qty": 3}]}`))
+	for _, want := range []string{"title", "Invoice", "item", "widget"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("json text %q is missing %q", got, want)
+		}
+	}
+	if got := extractText(kindJSON, []byte("not json at all")); got != "not json at all" {
+		t.Errorf("invalid json text = %q", got)
+	}
+}
+
+func TestExtractText_PDF(t *testing.T) {
+	var stream bytes.Buffer
+	zw := zlib.NewWriter(&stream)
+	zw.Write([]byte("BT /F1 12 Tf 72 712 Td (Hello \\(PDF\\) world) Tj 0 -14 Td [(Sea)-20(led)-300(envelope)] TJ <4869> Tj ET"))
+	zw.Close()
+
+	var pdf bytes.Buffer
+	pdf.WriteString("%PDF-1.4\n1 0 obj << /Filter /FlateDecode >>\nstream\n")
+	pdf.Write(stream.Bytes())
+	pdf.WriteString("\nendstream\nendobj\n%%EOF\n")
+
+	got := strings.Join(strings.Fields(extractText(kindPDF, pdf.Bytes())), " ")
+	if got != "Hello (PDF) world Sealed envelope Hi" {
+		t.Errorf("pdf text = %q", got)
+	}
+}
+
+func TestIndex_ContentSearch(t *testing.T) {
+	idx := newTestIndex(t)
+	docs := map[string]string{
+		"a.txt": "The storage engine writes objects to disk.",
+		"b.txt": "Indexing documents: the indexer indexes each document as it is uploaded, so indexed text is searchable.",
+		"c.txt": "Search results are ranked, and the index is persistent.",
+	}
+	for key, text := range docs {
+		idx.Update("docs", key, metadata.ObjectMeta{ContentType: "text/plain", ETag: "e-" + key})
+		if err := idx.SetContent("docs", key, "e-"+key, text); err != nil {
+			t.Fatalf("SetContent(%s): %v", key, err)
+		}
+	}
+
+	// Stems match other forms of a word; more occurrences rank higher
+	results, err := idx.Search("indexed", "", 10)
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if got := keys(results); got != "b.txt,c.txt" {
+		t.Fatalf("results = %q", got)
+	}
+	if results[0].Score <= results[1].Score {
+		t.Errorf("scores = %v, %v", results[0].Score, results[1].Score)
+	}
+
+	var snippet strings.Builder
+	var matched []string
+	for _, f := range results[1].Snippet {
+		snippet.WriteString(f.Text)
+		if f.Match {
+			matched = append(matched, f.Text)
+		}
+	}
+	if snippet.String() != docs["c.txt"][:len(docs["c.txt"])-1] || strings.Join(matched, ",") != "index" {
+		t.Errorf("snippet = %q, matches %v", snippet.String(), matched)
+	}
+
+	// Metadata words and text words combine
+	if results, _ := idx.Search("a disk", "", 10); keys(results) != "a.txt" {
+		t.Errorf("a disk = %q", keys(results))
+	}
+	if results, _ := idx.Search("persistent nothing", "", 10); len(results) != 0 {
+		t.Errorf("persistent nothing = %q", keys(results))
+	}
+
+	// Text of an outdated ETag is ignored, and an overwrite drops the text
+	idx.SetContent("docs", "a.txt", "stale", "completely different words")
+	if results, _ := idx.Search("different", "", 10); len(results) != 0 {
+		t.Errorf("stale text was indexed: %q", keys(results))
+	}
+	idx.Update("docs", "a.txt", metadata.ObjectMeta{ContentType: "text/plain", ETag: "new"})
+	if results, _ := idx.Search("disk", "", 10); len(results) != 0 {
+		t.Errorf("text of overwritten object still found: %q", keys(results))
+	}
+	if idx.HasContent("docs", "a.txt", "new") {
+		t.Error("overwritten object should have no text")
+	}
+
+	if err := idx.ClearContent("docs"); err != nil {
+		t.Fatalf("ClearContent: %v", err)
+	}
+	if results, _ := idx.Search("indexed", "", 10); len(results) != 0 {
+		t.Errorf("after clear = %q", keys(results))
+	}
+}
+
+func TestSnippet_Window(t *testing.T) {
+	text := strings.Repeat("filler ", 40) + "needle " + strings.Repeat("padding ", 60)
+	frags := snippet(text, map[string]bool{"needl": true})
+	if len(frags) != 3 || !frags[1].Match || frags[1].Text != "needle" {
+		t.Fatalf("fragments = %+v", frags)
+	}
+	if !strings.HasPrefix(frags[0].Text, "…") || !strings.HasSuffix(frags[2].Text, "…") {
+		t.Errorf("snippet should be cut on both sides: %+v", frags)
+	}
+	if n := len(frags[0].Text) + len(frags[1].Text) + len(frags[2].Text); n > snippetLength+10 {
+		t.Errorf("snippet is %d bytes", n)
+	}
+	if snippet("no match here", map[string]bool{"needl": true}) != nil {
+		t.Error("expected no snippet")
+	}
+}
+
+func TestIndex_ContentSurvivesBuild(t *testing.T) {
+	dir := t.TempDir()
+	store, err := metadata.NewStore(filepath.Join(dir, "meta.db"))
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	defer store.Close()
+	store.CreateBucket("docs")
+	store.PutObjectMeta(metadata.ObjectMeta{Bucket: "docs", Key: "keep.txt", ETag: "1"})
+	store.PutObjectMeta(metadata.ObjectMeta{Bucket: "docs", Key: "changed.txt", ETag: "1"})
+	store.PutObjectMeta(metadata.ObjectMeta{Bucket: "docs", Key: "gone.txt", ETag: "1"})
+
+	idx, err := Open(filepath.Join(dir, "search.db"), store)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer idx.Close()
+	if err := idx.Build(); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	for _, key := range []string{"keep.txt", "changed.txt", "gone.txt"} {
+		idx.SetContent("docs", key, "1", "quarterly budget spreadsheet")
+	}
+
+	store.PutObjectMeta(metadata.ObjectMeta{Bucket: "docs", Key: "changed.txt", ETag: "2"})
+	store.DeleteObjectMeta("docs", "gone.txt")
+	if err := idx.Build(); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	results, _ := idx.Search("budget", "", 10)
+	if got := keys(results); got != "keep.txt" {
+		t.Errorf("results = %q", got)
+	}
+	if !idx.HasContent("docs", "keep.txt", "1") || idx.HasContent("docs", "changed.txt", "1") || idx.HasContent("docs", "gone.txt", "1") {
+		t.Error("only unchanged objects should keep their text")
+	}
+}
+
+func TestContentIndexer(t *testing.T) {
+	dir := t.TempDir()
+	store, err := metadata.NewStore(filepath.Join(dir, "meta.db"))
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	defer store.Close()
+	engine, err := storage.NewFileSystem(filepath.Join(dir, "data"))
+	if err != nil {
+		t.Fatalf("NewFileSystem: %v", err)
+	}
+	idx, err := Open(filepath.Join(dir, "search.db"), store)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer idx.Close()
+
+	put := func(bucket, key, ct, body string) metadata.ObjectMeta {
+		t.Helper()
+		size, etag, err := engine.PutObject(bucket, key, strings.NewReader(body), int64(len(body)))
+		if err != nil {
+			t.Fatalf("PutObject: %v", err)
+		}
+		meta := metadata.ObjectMeta{Bucket: bucket, Key: key, ContentType: ct, Size: size, ETag: etag}
+		store.PutObjectMeta(meta)
+		idx.Update(bucket, key, meta)
+		return meta
+	}
+	for _, b := range []string{"notes", "plain"} {
+		store.CreateBucket(b)
+		engine.CreateBucketDir(b)
+	}
+	store.SetBucketContentIndex("notes", true)
+	// Present before the indexer starts: picked up by the backfill
+	put("notes", "old.md", "text/markdown", "Backfilled meeting notes")
+
+	ci := NewContentIndexer(idx, store, engine, 2, 16, 1024)
+	ci.Start(context.Background())
+	defer ci.Stop()
+
+	ci.Enqueue("notes", "page.html", put("notes", "page.html", "text/html", "<p>Release <b>checklist</b></p>"))
+	ci.Enqueue("notes", "big.txt", put("notes", "big.txt", "text/plain", strings.Repeat("huge ", 300)))
+	ci.Enqueue("notes", "photo.png", put("notes", "photo.png", "image/png", "checklist"))
+	ci.Enqueue("plain", "a.txt", put("plain", "a.txt", "text/plain", "checklist"))
+
+	// The backfill may queue an object uploaded after it started again
+	deadline := time.Now().Add(5 * time.Second)
+	for ci.Stats().Indexed < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	ci.Stop()
+	if stats := ci.Stats(); stats.Indexed < 2 || stats.Failed != 0 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	if results, _ := idx.Search("checklist", "", 10); keys(results) != "page.html" {
+		t.Errorf("checklist = %q", keys(results))
+	}
+	if results, _ := idx.Search("meeting", "", 10); keys(results) != "old.md" {
+		t.Errorf("meeting = %q", keys(results))
+	}
+	if results, _ := idx.Search("huge", "", 10); len(results) != 0 {
+		t.Errorf("objects over the size cap should not be read: %q", keys(results))
+	}
+}
+
+func TestContentIndexer_ScanAndDroppedJobs(t *testing.T) {
+	defer func(d time.Duration) { backfillInterval = d }(backfillInterval)
+	backfillInterval = 20 * time.Millisecond
+	dir := t.TempDir()
+	store, err := metadata.NewStore(filepath.Join(dir, "meta.db"))
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	defer store.Close()
+	engine, err := storage.NewFileSystem(filepath.Join(dir, "data"))
+	if err != nil {
+		t.Fatalf("NewFileSystem: %v", err)
+	}
+	idx, err := Open(filepath.Join(dir, "search.db"), store)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer idx.Close()
+	store.CreateBucket("notes")
+	engine.CreateBucketDir("notes")
+	store.SetBucketContentIndex("notes", true)
+
+	// A queue of one drops most of these jobs
+	ci := NewContentIndexer(idx, store, engine, 1, 1, 1024)
+	store.SetObjectObserver(NewObserver(idx, ci))
+	ci.Start(context.Background())
+	defer ci.Stop()
+	put := func(key, body, scanStatus string) metadata.ObjectMeta {
+		t.Helper()
+		size, etag, err := engine.PutObject("notes", key, strings.NewReader(body), int64(len(body)))
+		if err != nil {
+			t.Fatalf("PutObject: %v", err)
+		}
+		meta := metadata.ObjectMeta{Bucket: "notes", Key: key, ContentType: "text/plain", Size: size, ETag: etag, ScanStatus: scanStatus}
+		store.PutObjectMeta(meta)
+		return meta
+	}
+	pending := put("pending.txt", "unscanned words", metadata.ScanPending)
+	const n = 30
+	for i := range n {
+		put(strings.Repeat("k", i+1)+".txt", "findable text", "")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		results, _ := idx.Search("findable", "", n+1)
+		if len(results) == n {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("indexed %d of %d objects, stats %+v", len(results), n, ci.Stats())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if results, _ := idx.Search("unscanned", "", 10); len(results) != 0 {
+		t.Fatalf("object pending a scan was indexed: %q", keys(results))
+	}
+
+	// A clean scan queues the object again
+	store.SetObjectScanStatus("notes", pending.Key, pending.ETag, metadata.ScanClean)
+	for {
+		if results, _ := idx.Search("unscanned", "", 10); keys(results) == pending.Key {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("object was not indexed after its scan passed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
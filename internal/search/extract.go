@@ -0,0 +1,329 @@
+package search
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/json"
+	"html"
+	"io"
+	"path"
+	"strings"
+	"unicode/utf16"
+)
+
+// Kinds of content the index extracts text from.
+const (
+	kindText = "text" // plain text, Markdown, CSV and other text/* types
+	kindJSON = "json"
+	kindHTML = "html"
+	kindPDF  = "pdf"
+)
+
+// contentKind returns the kind of text extraction for an object, from its
+// content type or, when that is generic, its key's extension. It returns ""
+// for objects the index does not read.
+func contentKind(ct, key string) string {
+	switch ct = contentType(ct); {
+	case ct == "text/html" || ct == "application/xhtml+xml":
+		return kindHTML
+	case ct == "application/json" || ct == "application/x-ndjson" || strings.HasSuffix(ct, "+json"):
+		return kindJSON
+	case ct == "application/pdf":
+		return kindPDF
+	case strings.HasPrefix(ct, "text/") && ct != "text/plain":
+		return kindText
+	case ct == "application/csv" || ct == "application/markdown":
+		return kindText
+	case ct != "" && ct != "text/plain" && ct != "application/octet-stream" && ct != "binary/octet-stream":
+		return ""
+	}
+	switch strings.ToLower(path.Ext(key)) {
+	case ".html", ".htm":
+		return kindHTML
+	case ".json", ".ndjson", ".jsonl":
+		return kindJSON
+	case ".pdf":
+		return kindPDF
+	case ".txt", ".md", ".markdown", ".csv", ".tsv", ".log", ".rst":
+		return kindText
+	}
+	if ct == "text/plain" {
+		return kindText
+	}
+	return ""
+}
+
+// extractText returns the text of data of the given kind.
+func extractText(kind string, data []byte) string {
+	switch kind {
+	case kindHTML:
+		return stripHTML(string(data))
+	case kindJSON:
+		return jsonText(data)
+	case kindPDF:
+		return pdfText(data)
+	}
+	return strings.ToValidUTF8(string(data), " ")
+}
+
+// stripHTML removes tags, comments, scripts and styles and decodes entities.
+func stripHTML(s string) string {
+	var b strings.Builder
+	for len(s) > 0 {
+		lt := strings.IndexByte(s, '<')
+		if lt < 0 {
+			b.WriteString(s)
+			break
+		}
+		b.WriteString(s[:lt])
+		b.WriteByte(' ')
+		s = s[lt:]
+
+		end := ">"
+		switch lower := strings.ToLower(s[:min(len(s), 8)]); {
+		case strings.HasPrefix(lower, "<!--"):
+			end = "-->"
+		case strings.HasPrefix(lower, "<script"):
+			end = "</script>"
+		case strings.HasPrefix(lower, "<style"):
+			end = "</style>"
+		}
+		i := strings.Index(strings.ToLower(s), end)
+		if i < 0 {
+			break
+		}
+		s = s[i+len(end):]
+	}
+	return html.UnescapeString(strings.ToValidUTF8(b.String(), " "))
+}
+
+// jsonText returns the keys and string values of a JSON document, or of a
+// sequence of them as in NDJSON. Input that is not JSON is read as text.
+func jsonText(data []byte) string {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	var b strings.Builder
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if b.Len() == 0 {
+				return strings.ToValidUTF8(string(data), " ")
+			}
+			break
+		}
+		if s, ok := tok.(string); ok {
+			b.WriteString(s)
+			b.WriteByte(' ')
+		}
+	}
+	return b.String()
+}
+
+// pdfText extracts the text shown by the text operators of a PDF's content
+// streams, decompressing Flate streams. It handles simple PDFs whose fonts
+// use single-byte or UTF-16 encodings, not CID fonts or encrypted files.
+func pdfText(data []byte) string {
+	var b strings.Builder
+	for {
+		i := bytes.Index(data, []byte("stream"))
+		if i < 0 {
+			break
+		}
+		data = data[i+len("stream"):]
+		// The stream data starts after an end-of-line
+		if len(data) > 0 && data[0] == '\r' {
+			data = data[1:]
+		}
+		if len(data) > 0 && data[0] == '\n' {
+			data = data[1:]
+		}
+		end := bytes.Index(data, []byte("endstream"))
+		if end < 0 {
+			break
+		}
+		stream := data[:end]
+		data = data[end+len("endstream"):]
+
+		if zr, err := zlib.NewReader(bytes.NewReader(stream)); err == nil {
+			if inflated, err := io.ReadAll(io.LimitReader(zr, int64(len(stream))*64+1<<20)); len(inflated) > 0 {
+				stream = inflated
+			} else if err != nil {
+				continue
+			}
+		}
+		if bytes.Contains(stream, []byte("BT")) {
+			pdfContentText(stream, &b)
+		}
+	}
+	return b.String()
+}
+
+// pdfContentText appends the strings shown by Tj, TJ, ' and " in a content
+// stream to b.
+func pdfContentText(s []byte, b *strings.Builder) {
+	var shown strings.Builder // strings since the last operator
+	inArray := false
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '%':
+			for i < len(s) && s[i] != '\n' && s[i] != '\r' {
+				i++
+			}
+		case c == '(':
+			var str []byte
+			str, i = pdfLiteral(s, i+1)
+			shown.WriteString(pdfDecode(str))
+		case c == '<' && i+1 < len(s) && s[i+1] != '<':
+			j := bytes.IndexByte(s[i:], '>')
+			if j < 0 {
+				return
+			}
+			shown.WriteString(pdfDecode(pdfHex(s[i+1 : i+j])))
+			i += j + 1
+		case c == '[':
+			inArray = true
+			i++
+		case c == ']':
+			inArray = false
+			i++
+		case c == '-' || c == '.' || (c >= '0' && c <= '9'):
+			j := i + 1
+			for j < len(s) && (s[j] == '.' || (s[j] >= '0' && s[j] <= '9')) {
+				j++
+			}
+			// Large negative offsets in TJ arrays separate words
+			if inArray && c == '-' && j-i >= 4 {
+				shown.WriteByte(' ')
+			}
+			i = j
+		case isPDFRegular(c):
+			j := i + 1
+			for j < len(s) && isPDFRegular(s[j]) {
+				j++
+			}
+			switch string(s[i:j]) {
+			case "Tj", "TJ", "'", "\"":
+				b.WriteString(shown.String())
+				b.WriteByte(' ')
+			case "Td", "TD", "T*", "Tm", "ET":
+				b.WriteByte('\n')
+			}
+			shown.Reset()
+			i = j
+		default:
+			i++
+		}
+	}
+}
+
+func isPDFRegular(c byte) bool {
+	switch c {
+	case ' ', '\t', '\r', '\n', '\f', 0, '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return false
+	}
+	return c == '\'' || c == '"' || (c > ' ' && c < 0x7f && !(c >= '0' && c <= '9') && c != '-' && c != '.')
+}
+
+// pdfLiteral reads a literal string starting after its opening parenthesis
+// and returns it with the index after the closing one.
+func pdfLiteral(s []byte, i int) ([]byte, int) {
+	var out []byte
+	depth := 1
+	for i < len(s) {
+		c := s[i]
+		i++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return out, i
+			}
+		case '\\':
+			if i >= len(s) {
+				return out, i
+			}
+			e := s[i]
+			i++
+			switch e {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r', '\n':
+				continue // line continuation
+			default:
+				if e >= '0' && e <= '7' {
+					v := int(e - '0')
+					for k := 0; k < 2 && i < len(s) && s[i] >= '0' && s[i] <= '7'; k++ {
+						v = v*8 + int(s[i]-'0')
+						i++
+					}
+					c = byte(v)
+				} else {
+					c = e
+				}
+			}
+		}
+		out = append(out, c)
+	}
+	return out, i
+}
+
+func pdfHex(s []byte) []byte {
+	var digits []byte
+	for _, c := range s {
+		if (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F') {
+			digits = append(digits, c)
+		}
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	out := make([]byte, len(digits)/2)
+	for i := range out {
+		out[i] = unhex(digits[2*i])<<4 | unhex(digits[2*i+1])
+	}
+	return out
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c >= 'a':
+		return c - 'a' + 10
+	case c >= 'A':
+		return c - 'A' + 10
+	}
+	return c - '0'
+}
+
+// pdfDecode decodes a PDF string: UTF-16BE with a byte order mark, or else
+// a single-byte encoding read as Latin-1. Strings with control bytes, as
+// shown by CID fonts, decode to nothing.
+func pdfDecode(s []byte) string {
+	if len(s) >= 2 && s[0] == 0xfe && s[1] == 0xff {
+		u := make([]uint16, 0, len(s)/2)
+		for i := 2; i+1 < len(s); i += 2 {
+			u = append(u, uint16(s[i])<<8|uint16(s[i+1]))
+		}
+		return string(utf16.Decode(u))
+	}
+	runes := make([]rune, len(s))
+	for i, c := range s {
+		if c < ' ' && c != '\t' && c != '\n' && c != '\r' {
+			return ""
+		}
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
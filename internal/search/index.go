@@ -1,12 +1,20 @@
 package search
 
 import (
-	"container/list"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sort"
 	"strings"
-	"sync"
+	"sync/atomic"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/eniz1806/VaultS3/internal/metadata"
+	bolt "go.etcd.io/bbolt"
 )
 
 // Result represents a search result entry.
@@ -18,271 +26,519 @@ type Result struct {
 	LastModified int64             `json:"last_modified"`
 	ETag         string            `json:"etag"`
 	Tags         map[string]string `json:"tags,omitempty"`
+	UserMetadata map[string]string `json:"user_metadata,omitempty"`
+	Score        float64           `json:"score,omitempty"`   // BM25 score of the object's text
+	Snippet      []Fragment        `json:"snippet,omitempty"` // text around the first match
 }
 
-// entry is an internal index entry with minimal fields.
-type entry struct {
-	bucket       string
-	key          string
-	size         int64
-	contentType  string
-	lastModified int64
-	etag         string
-	tags         map[string]string
-	text         string
-}
-
-// Index provides in-memory full-text search over object metadata.
+// Index is a persistent inverted index over object metadata. It lives in
+// its own BoltDB file next to the metadata store and is kept up to date
+// incrementally, so only the posting lists a query touches are read.
+//
+// Every object is a document with a numeric ID. Its terms are field-prefixed
+// tokens: "b:" bucket, "w:" words of the key, content type, tags and user
+// metadata, "s:" key path segments, "t:" content type, "g:" tags and "m:"
+// user metadata. Postings are stored as term, 0x00, big-endian doc ID, so
+// the documents of a term are a contiguous, sorted key range. The text of
+// objects in buckets with content indexing adds stemmed "c:" terms, whose
+// postings hold the term's frequency in the text.
 type Index struct {
-	mu         sync.RWMutex
-	entries    map[string]*entry
-	lru        *list.List
-	lruElems   map[string]*list.Element
-	store      *metadata.Store
-	maxEntries int
+	db       *bolt.DB
+	store    *metadata.Store
+	building atomic.Bool
 }
 
-const defaultMaxSearchEntries = 50000
+var (
+	docsBucket     = []byte("docs")     // doc ID -> storedDoc
+	idsBucket      = []byte("ids")      // "bucket/key" -> doc ID
+	postingsBucket = []byte("postings") // term 0x00 doc ID -> empty
+	termsBucket    = []byte("terms")    // term -> number of documents
+	infoBucket     = []byte("info")     // index format version, build state, document count
+	lengthsBucket  = []byte("lengths")  // doc ID -> number of terms of its text
+
+	// indexBuckets are cleared by a rebuild; contentBucket is kept
+	indexBuckets = [][]byte{docsBucket, idsBucket, postingsBucket, termsBucket, infoBucket, lengthsBucket}
+)
+
+var (
+	infoVersion = []byte("version")
+	infoBuilt   = []byte("built")
+	infoCount   = []byte("count")
+)
+
+// indexVersion changes whenever the terms of a document change, so that
+// indexes written by an older version are rebuilt.
+const indexVersion = "1"
+
+// maxTermLen is the longest term kept, in bytes. Longer terms are
+// truncated, for documents and queries alike.
+const maxTermLen = 128
+
+// buildBatchSize is how many objects a build indexes per transaction.
+const buildBatchSize = 1000
+
+// ErrBuilding is returned when a build is requested while one is running.
+var ErrBuilding = errors.New("search index build already running")
+
+// storedDoc is what the index keeps of an object to return it as a result
+// and to remove its postings when it changes.
+type storedDoc struct {
+	Bucket       string            `json:"bucket"`
+	Key          string            `json:"key"`
+	Size         int64             `json:"size"`
+	ContentType  string            `json:"content_type"`
+	LastModified int64             `json:"last_modified"`
+	ETag         string            `json:"etag"`
+	Tags         map[string]string `json:"tags,omitempty"`
+	UserMetadata map[string]string `json:"user_metadata,omitempty"`
+	Terms        []string          `json:"terms"`
+}
 
-// NewIndex creates a new search index with the given max entries cap.
-func NewIndex(store *metadata.Store, maxEntries int) *Index {
-	if maxEntries <= 0 {
-		maxEntries = defaultMaxSearchEntries
+// Open opens or creates the index file at path. Objects are read from
+// store when the index is built.
+func Open(path string, store *metadata.Store) (*Index, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return nil, err
 	}
-	return &Index{
-		entries:    make(map[string]*entry, maxEntries),
-		lru:        list.New(),
-		lruElems:   make(map[string]*list.Element, maxEntries),
-		store:      store,
-		maxEntries: maxEntries,
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range append(indexBuckets, contentBucket) {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
 	}
+	return &Index{db: db, store: store}, nil
 }
 
-// Build populates the index by scanning all object metadata from BoltDB.
-func (idx *Index) Build() error {
-	idx.mu.Lock()
-	defer idx.mu.Unlock()
+// Close closes the index file.
+func (idx *Index) Close() error {
+	return idx.db.Close()
+}
 
-	idx.entries = make(map[string]*entry, idx.maxEntries)
-	idx.lru = list.New()
-	idx.lruElems = make(map[string]*list.Element, idx.maxEntries)
+// NeedsBuild reports whether the index is new, was written by an older
+// version or its last build did not finish.
+func (idx *Index) NeedsBuild() bool {
+	needs := true
+	idx.db.View(func(tx *bolt.Tx) error {
+		info := tx.Bucket(infoBucket)
+		needs = string(info.Get(infoVersion)) != indexVersion || string(info.Get(infoBuilt)) != "1"
+		return nil
+	})
+	return needs
+}
 
-	err := idx.store.IterateAllObjects(func(bucket, key string, meta metadata.ObjectMeta) bool {
-		if meta.DeleteMarker {
-			return true // skip delete markers
-		}
-		mk := bucket + "/" + key
-		e := newEntry(bucket, key, meta)
-		idx.entries[mk] = e
-		elem := idx.lru.PushFront(mk)
-		idx.lruElems[mk] = elem
-
-		// Evict oldest if over cap
-		for len(idx.entries) > idx.maxEntries {
-			oldest := idx.lru.Back()
-			if oldest == nil {
-				break
+// Building reports whether a build is running.
+func (idx *Index) Building() bool {
+	return idx.building.Load()
+}
+
+// Build clears the index and indexes every object in the metadata store,
+// a batch per transaction. Updates made while it runs are applied as usual.
+func (idx *Index) Build() error {
+	if idx.store == nil {
+		return errors.New("search index has no metadata store")
+	}
+	if !idx.building.CompareAndSwap(false, true) {
+		return ErrBuilding
+	}
+	defer idx.building.Store(false)
+
+	err := idx.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range indexBuckets {
+			if err := tx.DeleteBucket(name); err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+				return err
+			}
+			if _, err := tx.CreateBucket(name); err != nil {
+				return err
 			}
-			idx.evictLocked(oldest.Value.(string))
 		}
-		return true
+		return tx.Bucket(infoBucket).Put(infoVersion, []byte(indexVersion))
 	})
+	if err != nil {
+		return err
+	}
 
-	if err != nil && err.Error() == "stop" {
-		return nil
+	buckets, err := idx.store.ListBuckets()
+	if err != nil {
+		return err
 	}
-	return err
+	for _, b := range buckets {
+		after := ""
+		for {
+			page, err := idx.store.ListObjectMetaAfter(b.Name, "", after, buildBatchSize)
+			if err != nil {
+				return err
+			}
+			if len(page) == 0 {
+				break
+			}
+			err = idx.db.Update(func(tx *bolt.Tx) error {
+				for _, meta := range page {
+					if meta.DeleteMarker {
+						continue
+					}
+					if err := putDoc(tx, b.Name, meta.Key, meta); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			after = page[len(page)-1].Key
+		}
+	}
+
+	return idx.db.Update(func(tx *bolt.Tx) error {
+		// Drop the text of objects that are gone
+		ids := tx.Bucket(idsBucket)
+		content := tx.Bucket(contentBucket)
+		var gone [][]byte
+		content.ForEach(func(k, _ []byte) error {
+			if ids.Get(k) == nil {
+				gone = append(gone, bytes.Clone(k))
+			}
+			return nil
+		})
+		for _, k := range gone {
+			if err := content.Delete(k); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(infoBucket).Put(infoBuilt, []byte("1"))
+	})
 }
 
 // Update adds or updates an entry in the index.
 func (idx *Index) Update(bucket, key string, meta metadata.ObjectMeta) {
-	idx.mu.Lock()
-	defer idx.mu.Unlock()
-
-	mk := bucket + "/" + key
-
 	if meta.DeleteMarker {
-		idx.evictLocked(mk)
+		idx.Remove(bucket, key)
 		return
 	}
-
-	if elem, exists := idx.lruElems[mk]; exists {
-		idx.lru.MoveToFront(elem)
-	} else {
-		elem := idx.lru.PushFront(mk)
-		idx.lruElems[mk] = elem
-	}
-
-	idx.entries[mk] = newEntry(bucket, key, meta)
-
-	for len(idx.entries) > idx.maxEntries {
-		oldest := idx.lru.Back()
-		if oldest == nil {
-			break
-		}
-		idx.evictLocked(oldest.Value.(string))
+	err := idx.db.Update(func(tx *bolt.Tx) error {
+		return putDoc(tx, bucket, key, meta)
+	})
+	if err != nil {
+		slog.Warn("search index update failed", "bucket", bucket, "key", key, "error", err)
 	}
 }
 
 // Remove deletes an entry from the index.
 func (idx *Index) Remove(bucket, key string) {
-	idx.mu.Lock()
-	defer idx.mu.Unlock()
-	idx.evictLocked(bucket + "/" + key)
-}
-
-func (idx *Index) evictLocked(mk string) {
-	if elem, ok := idx.lruElems[mk]; ok {
-		idx.lru.Remove(elem)
-		delete(idx.lruElems, mk)
+	err := idx.db.Update(func(tx *bolt.Tx) error {
+		return removeDoc(tx, bucket, key)
+	})
+	if err != nil {
+		slog.Warn("search index remove failed", "bucket", bucket, "key", key, "error", err)
 	}
-	delete(idx.entries, mk)
 }
 
-// Search finds objects matching the query string.
-func (idx *Index) Search(query, bucket string, limit int) []Result {
-	idx.mu.RLock()
-	defer idx.mu.RUnlock()
-
-	if limit <= 0 {
-		limit = 50
-	}
-
-	query = strings.TrimSpace(query)
-	if query == "" {
+// RemoveBucket deletes the entries and indexed text of every object in a
+// bucket, for when the bucket is deleted.
+func (idx *Index) RemoveBucket(bucket string) error {
+	prefix := []byte(bucket + "/")
+	var keys []string
+	err := idx.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(idsBucket).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			keys = append(keys, string(k[len(prefix):]))
+		}
 		return nil
+	})
+	if err != nil {
+		return err
 	}
-
-	var tagFilters []tagFilter
-	var typeFilter string
-	var textTerms []string
-
-	for _, part := range strings.Fields(query) {
-		lower := strings.ToLower(part)
-		if strings.HasPrefix(lower, "tag:") {
-			tf := parseTagFilter(strings.TrimPrefix(part, "tag:"))
-			if tf.key != "" {
-				tagFilters = append(tagFilters, tf)
-				continue
+	for start := 0; start < len(keys); start += buildBatchSize {
+		batch := keys[start:min(start+buildBatchSize, len(keys))]
+		err := idx.db.Update(func(tx *bolt.Tx) error {
+			for _, key := range batch {
+				if err := removeDoc(tx, bucket, key); err != nil {
+					return err
+				}
 			}
+			return nil
+		})
+		if err != nil {
+			return err
 		}
-		if strings.HasPrefix(lower, "type:") {
-			typeFilter = strings.ToLower(strings.TrimPrefix(part, "type:"))
-			continue
-		}
-		textTerms = append(textTerms, strings.ToLower(part))
 	}
+	return idx.ClearContent(bucket)
+}
 
-	var results []Result
-	for _, e := range idx.entries {
-		if bucket != "" && e.bucket != bucket {
-			continue
-		}
+// Count returns the number of indexed entries.
+func (idx *Index) Count() int {
+	var n uint64
+	idx.db.View(func(tx *bolt.Tx) error {
+		n = infoValue(tx, infoCount)
+		return nil
+	})
+	return int(n)
+}
 
-		if !matchTagFilters(e.tags, tagFilters) {
-			continue
+// putDoc indexes an object, replacing the postings of its previous version
+// with only the terms that changed.
+func putDoc(tx *bolt.Tx, bucket, key string, meta metadata.ObjectMeta) error {
+	ids := tx.Bucket(idsBucket)
+	docs := tx.Bucket(docsBucket)
+	mk := []byte(bucket + "/" + key)
+
+	var id uint64
+	var oldTerms []string
+	v := ids.Get(mk)
+	existed := v != nil
+	if existed {
+		id = binary.BigEndian.Uint64(v)
+		var old storedDoc
+		if data := docs.Get(v); data != nil && json.Unmarshal(data, &old) == nil {
+			oldTerms = old.Terms
+		}
+	} else {
+		var err error
+		if id, err = docs.NextSequence(); err != nil {
+			return err
+		}
+		if err := ids.Put(mk, docID(id)); err != nil {
+			return err
 		}
+		if err := addInfo(tx, infoCount, 1); err != nil {
+			return err
+		}
+	}
 
-		if typeFilter != "" && !strings.Contains(strings.ToLower(e.contentType), typeFilter) {
-			continue
+	// Text indexed for other content is out of date. The text of a new
+	// document is linked to it when a rebuild finds it unchanged.
+	if data := tx.Bucket(contentBucket).Get(mk); data != nil {
+		var cd contentDoc
+		if err := json.Unmarshal(data, &cd); err != nil || cd.ETag != meta.ETag {
+			linked := uint64(0)
+			if existed {
+				linked = id
+			}
+			if err := unlinkContent(tx, mk, linked); err != nil {
+				return err
+			}
+		} else if !existed {
+			if err := linkContent(tx, id, cd); err != nil {
+				return err
+			}
 		}
+	}
 
-		if len(textTerms) > 0 {
-			allMatch := true
-			for _, term := range textTerms {
-				if !strings.Contains(e.text, term) {
-					allMatch = false
-					break
-				}
+	doc := storedDoc{
+		Bucket:       bucket,
+		Key:          key,
+		Size:         meta.Size,
+		ContentType:  meta.ContentType,
+		LastModified: meta.LastModified,
+		ETag:         meta.ETag,
+		Tags:         meta.Tags,
+		UserMetadata: meta.UserMetadata,
+	}
+	doc.Terms = docTerms(doc)
+
+	// Both term lists are sorted
+	i, j := 0, 0
+	for i < len(oldTerms) || j < len(doc.Terms) {
+		switch {
+		case j == len(doc.Terms) || (i < len(oldTerms) && oldTerms[i] < doc.Terms[j]):
+			if err := removePosting(tx, oldTerms[i], id); err != nil {
+				return err
 			}
-			if !allMatch {
-				continue
+			i++
+		case i == len(oldTerms) || doc.Terms[j] < oldTerms[i]:
+			if err := addPosting(tx, doc.Terms[j], id); err != nil {
+				return err
 			}
+			j++
+		default:
+			i++
+			j++
 		}
+	}
 
-		results = append(results, Result{
-			Bucket:       e.bucket,
-			Key:          e.key,
-			Size:         e.size,
-			ContentType:  e.contentType,
-			LastModified: e.lastModified,
-			ETag:         e.etag,
-			Tags:         e.tags,
-		})
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return docs.Put(docID(id), data)
+}
 
-		if len(results) >= limit {
-			break
+func removeDoc(tx *bolt.Tx, bucket, key string) error {
+	ids := tx.Bucket(idsBucket)
+	docs := tx.Bucket(docsBucket)
+	mk := []byte(bucket + "/" + key)
+	v := ids.Get(mk)
+	if v == nil {
+		return nil
+	}
+	id := binary.BigEndian.Uint64(v)
+	if err := unlinkContent(tx, mk, id); err != nil {
+		return err
+	}
+	var doc storedDoc
+	if data := docs.Get(v); data != nil && json.Unmarshal(data, &doc) == nil {
+		for _, term := range doc.Terms {
+			if err := removePosting(tx, term, id); err != nil {
+				return err
+			}
 		}
 	}
+	if err := docs.Delete(docID(id)); err != nil {
+		return err
+	}
+	if err := ids.Delete(mk); err != nil {
+		return err
+	}
+	return addInfo(tx, infoCount, -1)
+}
 
-	return results
+func addPosting(tx *bolt.Tx, term string, id uint64) error {
+	return addPostingValue(tx, term, id, []byte{})
 }
 
-// Count returns the number of indexed entries.
-func (idx *Index) Count() int {
-	idx.mu.RLock()
-	defer idx.mu.RUnlock()
-	return len(idx.entries)
+// addPostingValue adds a document to the posting list of a term, with a
+// value such as the term's frequency in the document.
+func addPostingValue(tx *bolt.Tx, term string, id uint64, value []byte) error {
+	if err := tx.Bucket(postingsBucket).Put(postingKey(term, id), value); err != nil {
+		return err
+	}
+	terms := tx.Bucket(termsBucket)
+	return terms.Put([]byte(term), docID(termFreq(terms, term)+1))
 }
 
-func newEntry(bucket, key string, meta metadata.ObjectMeta) *entry {
-	return &entry{
-		bucket:       bucket,
-		key:          key,
-		size:         meta.Size,
-		contentType:  meta.ContentType,
-		lastModified: meta.LastModified,
-		etag:         meta.ETag,
-		tags:         meta.Tags,
-		text:         buildSearchText(bucket, key, meta),
+func removePosting(tx *bolt.Tx, term string, id uint64) error {
+	if err := tx.Bucket(postingsBucket).Delete(postingKey(term, id)); err != nil {
+		return err
+	}
+	terms := tx.Bucket(termsBucket)
+	n := termFreq(terms, term)
+	if n <= 1 {
+		return terms.Delete([]byte(term))
 	}
+	return terms.Put([]byte(term), docID(n-1))
 }
 
-type tagFilter struct {
-	key   string
-	value string
+// termFreq returns the number of documents that contain a term.
+func termFreq(terms *bolt.Bucket, term string) uint64 {
+	if v := terms.Get([]byte(term)); len(v) == 8 {
+		return binary.BigEndian.Uint64(v)
+	}
+	return 0
 }
 
-func parseTagFilter(s string) tagFilter {
-	parts := strings.SplitN(s, "=", 2)
-	tf := tagFilter{key: parts[0]}
-	if len(parts) == 2 {
-		tf.value = parts[1]
+// addInfo adds delta to a counter in the info bucket.
+func addInfo(tx *bolt.Tx, name []byte, delta int64) error {
+	n := infoValue(tx, name)
+	if delta < 0 && n < uint64(-delta) {
+		n = 0
+	} else {
+		n = uint64(int64(n) + delta)
 	}
-	return tf
+	return tx.Bucket(infoBucket).Put(name, docID(n))
 }
 
-func matchTagFilters(tags map[string]string, filters []tagFilter) bool {
-	for _, f := range filters {
-		v, ok := tags[f.key]
-		if !ok {
-			return false
+func infoValue(tx *bolt.Tx, name []byte) uint64 {
+	if v := tx.Bucket(infoBucket).Get(name); len(v) == 8 {
+		return binary.BigEndian.Uint64(v)
+	}
+	return 0
+}
+
+func docID(id uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, id)
+	return b
+}
+
+func postingKey(term string, id uint64) []byte {
+	k := make([]byte, 0, len(term)+9)
+	k = append(k, term...)
+	k = append(k, 0)
+	return binary.BigEndian.AppendUint64(k, id)
+}
+
+// docTerms returns the sorted, distinct terms of a document.
+func docTerms(doc storedDoc) []string {
+	set := make(map[string]struct{})
+	add := func(field, value string) {
+		if value != "" {
+			set[normalizeTerm(field+value)] = struct{}{}
 		}
-		if f.value != "" && !strings.EqualFold(v, f.value) {
-			return false
+	}
+	addWords := func(s string) {
+		for _, w := range words(s) {
+			add("w:", w)
 		}
 	}
-	return true
+
+	add("b:", doc.Bucket)
+	addWords(doc.Key)
+	for _, seg := range strings.Split(doc.Key, "/") {
+		add("s:", strings.ToLower(seg))
+	}
+
+	ct := contentType(doc.ContentType)
+	if ct != "" {
+		add("t:", ct)
+		major, minor, _ := strings.Cut(ct, "/")
+		add("t:", major)
+		add("t:", minor)
+		addWords(ct)
+	}
+
+	for k, v := range doc.Tags {
+		k, v = strings.ToLower(k), strings.ToLower(v)
+		add("g:", k)
+		add("g:", k+"="+v)
+		addWords(k)
+		addWords(v)
+	}
+	for k, v := range doc.UserMetadata {
+		k, v = strings.ToLower(k), strings.ToLower(v)
+		add("m:", k)
+		add("m:", k+"="+v)
+		addWords(k)
+		addWords(v)
+	}
+
+	terms := make([]string, 0, len(set))
+	for t := range set {
+		terms = append(terms, t)
+	}
+	sort.Strings(terms)
+	return terms
+}
+
+// contentType returns the lowercased media type without parameters.
+func contentType(ct string) string {
+	ct, _, _ = strings.Cut(ct, ";")
+	return strings.ToLower(strings.TrimSpace(ct))
+}
+
+// words splits s into lowercased runs of letters and digits.
+func words(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
 }
 
-func buildSearchText(bucket, key string, meta metadata.ObjectMeta) string {
-	var b strings.Builder
-	b.WriteString(strings.ToLower(bucket))
-	b.WriteByte(' ')
-	b.WriteString(strings.ToLower(key))
-	b.WriteByte(' ')
-	b.WriteString(strings.ToLower(meta.ContentType))
-	b.WriteByte(' ')
-	b.WriteString(strings.ToLower(meta.ETag))
-	for k, v := range meta.Tags {
-		b.WriteByte(' ')
-		b.WriteString(strings.ToLower(k))
-		b.WriteByte('=')
-		b.WriteString(strings.ToLower(v))
-	}
-	if meta.LastModified > 0 {
-		b.WriteByte(' ')
-		b.WriteString(time.Unix(0, meta.LastModified).Format("2006-01-02"))
-	}
-	return b.String()
+// normalizeTerm makes a term safe to use in a posting key: no 0x00 bytes,
+// which separate the term from the doc ID, and at most maxTermLen bytes.
+func normalizeTerm(term string) string {
+	term = strings.ReplaceAll(term, "\x00", "")
+	if len(term) <= maxTermLen {
+		return term
+	}
+	term = term[:maxTermLen]
+	for len(term) > 0 && !utf8.ValidString(term) {
+		term = term[:len(term)-1]
+	}
+	return term
 }
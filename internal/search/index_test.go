@@ -1,17 +1,34 @@
 package search
 
 import (
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/eniz1806/VaultS3/internal/metadata"
+	bolt "go.etcd.io/bbolt"
 )
 
-func newTestIndex(max int) *Index {
-	return NewIndex(nil, max)
+func newTestIndex(t *testing.T) *Index {
+	t.Helper()
+	idx, err := Open(filepath.Join(t.TempDir(), "search.db"), nil)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { idx.Close() })
+	return idx
+}
+
+func keys(results []Result) string {
+	var ks []string
+	for _, r := range results {
+		ks = append(ks, r.Key)
+	}
+	return strings.Join(ks, ",")
 }
 
 func TestIndex_UpdateAndSearch(t *testing.T) {
-	idx := newTestIndex(100)
+	idx := newTestIndex(t)
 
 	idx.Update("mybucket", "docs/readme.txt", metadata.ObjectMeta{
 		Size:         100,
@@ -20,7 +37,7 @@ func TestIndex_UpdateAndSearch(t *testing.T) {
 		ETag:         "abc123",
 	})
 
-	results := idx.Search("readme", "", 10)
+	results, _ := idx.Search("readme", "", 10)
 	if len(results) != 1 {
 		t.Fatalf("expected 1 result, got %d", len(results))
 	}
@@ -30,7 +47,7 @@ func TestIndex_UpdateAndSearch(t *testing.T) {
 }
 
 func TestIndex_SearchByContentType(t *testing.T) {
-	idx := newTestIndex(100)
+	idx := newTestIndex(t)
 
 	idx.Update("mybucket", "photo.jpg", metadata.ObjectMeta{
 		ContentType: "image/jpeg",
@@ -39,14 +56,14 @@ func TestIndex_SearchByContentType(t *testing.T) {
 		ContentType: "application/pdf",
 	})
 
-	results := idx.Search("type:image", "", 10)
+	results, _ := idx.Search("type:image", "", 10)
 	if len(results) != 1 || results[0].Key != "photo.jpg" {
 		t.Errorf("expected photo.jpg for type:image, got %v", results)
 	}
 }
 
 func TestIndex_SearchByTag(t *testing.T) {
-	idx := newTestIndex(100)
+	idx := newTestIndex(t)
 
 	idx.Update("mybucket", "tagged.txt", metadata.ObjectMeta{
 		Tags: map[string]string{"env": "prod", "team": "backend"},
@@ -55,69 +72,46 @@ func TestIndex_SearchByTag(t *testing.T) {
 		Tags: map[string]string{"env": "dev"},
 	})
 
-	results := idx.Search("tag:env=prod", "", 10)
+	results, _ := idx.Search("tag:env=prod", "", 10)
 	if len(results) != 1 || results[0].Key != "tagged.txt" {
 		t.Errorf("expected tagged.txt for tag:env=prod, got %v", results)
 	}
 }
 
 func TestIndex_BucketFilter(t *testing.T) {
-	idx := newTestIndex(100)
+	idx := newTestIndex(t)
 
 	idx.Update("bucket1", "file.txt", metadata.ObjectMeta{ContentType: "text/plain"})
 	idx.Update("bucket2", "file.txt", metadata.ObjectMeta{ContentType: "text/plain"})
 
-	results := idx.Search("file", "bucket1", 10)
+	results, _ := idx.Search("file", "bucket1", 10)
 	if len(results) != 1 || results[0].Bucket != "bucket1" {
 		t.Errorf("expected only bucket1, got %v", results)
 	}
 }
 
 func TestIndex_Remove(t *testing.T) {
-	idx := newTestIndex(100)
+	idx := newTestIndex(t)
 
 	idx.Update("mybucket", "file.txt", metadata.ObjectMeta{ContentType: "text/plain"})
 	idx.Remove("mybucket", "file.txt")
 
-	results := idx.Search("file", "", 10)
+	results, _ := idx.Search("file", "", 10)
 	if len(results) != 0 {
 		t.Errorf("expected 0 results after remove, got %d", len(results))
 	}
 }
 
-func TestIndex_LRUEviction(t *testing.T) {
-	idx := newTestIndex(3)
-
-	idx.Update("b", "1.txt", metadata.ObjectMeta{})
-	idx.Update("b", "2.txt", metadata.ObjectMeta{})
-	idx.Update("b", "3.txt", metadata.ObjectMeta{})
-	idx.Update("b", "4.txt", metadata.ObjectMeta{}) // should evict 1.txt
-
-	if idx.Count() != 3 {
-		t.Errorf("expected count=3 after eviction, got %d", idx.Count())
-	}
-
-	results := idx.Search("1.txt", "", 10)
-	if len(results) != 0 {
-		t.Error("expected 1.txt to be evicted")
-	}
-
-	results = idx.Search("4.txt", "", 10)
-	if len(results) != 1 {
-		t.Error("expected 4.txt to exist")
-	}
-}
-
 func TestIndex_EmptySearch(t *testing.T) {
-	idx := newTestIndex(100)
-	results := idx.Search("", "", 10)
+	idx := newTestIndex(t)
+	results, _ := idx.Search("", "", 10)
 	if results != nil {
 		t.Errorf("expected nil for empty query, got %v", results)
 	}
 }
 
 func TestIndex_Count(t *testing.T) {
-	idx := newTestIndex(100)
+	idx := newTestIndex(t)
 	if idx.Count() != 0 {
 		t.Error("expected 0 on empty index")
 	}
@@ -126,3 +120,181 @@ func TestIndex_Count(t *testing.T) {
 		t.Error("expected 1 after update")
 	}
 }
+
+func TestIndex_PrefixAndUserMetadata(t *testing.T) {
+	idx := newTestIndex(t)
+	idx.Update("b", "reports/2026/q1-summary.pdf", metadata.ObjectMeta{
+		ContentType:  "application/pdf",
+		UserMetadata: map[string]string{"owner": "Alice", "project": "apollo"},
+	})
+	idx.Update("b", "reports/2025/draft.txt", metadata.ObjectMeta{
+		ContentType:  "text/plain; charset=utf-8",
+		UserMetadata: map[string]string{"owner": "bob"},
+	})
+
+	for query, want := range map[string]string{
+		"summ":                 "reports/2026/q1-summary.pdf",
+		"rep q1":               "reports/2026/q1-summary.pdf",
+		"q1-summary.pdf":       "reports/2026/q1-summary.pdf",
+		"meta:owner=alice":     "reports/2026/q1-summary.pdf",
+		"apollo":               "reports/2026/q1-summary.pdf",
+		"meta:owner":           "reports/2026/q1-summary.pdf,reports/2025/draft.txt",
+		"path:2025":            "reports/2025/draft.txt",
+		"path:reports/ draft":  "reports/2025/draft.txt",
+		"type:text/plain":      "reports/2025/draft.txt",
+		"type:text/plain;":     "",
+		"meta:owner=carol":     "",
+		"reports missing-word": "",
+	} {
+		results, err := idx.Search(query, "", 10)
+		if err != nil {
+			t.Fatalf("Search(%q): %v", query, err)
+		}
+		if got := keys(results); got != want {
+			t.Errorf("Search(%q) = %q, want %q", query, got, want)
+		}
+	}
+	if results, _ := idx.Search("apollo", "", 10); results[0].UserMetadata["owner"] != "Alice" {
+		t.Errorf("result user metadata = %v", results[0].UserMetadata)
+	}
+}
+
+func TestIndex_UpdateReplacesTerms(t *testing.T) {
+	idx := newTestIndex(t)
+	idx.Update("b", "file.txt", metadata.ObjectMeta{Tags: map[string]string{"env": "dev"}})
+	idx.Update("b", "file.txt", metadata.ObjectMeta{Tags: map[string]string{"env": "prod"}})
+
+	if results, _ := idx.Search("tag:env=dev", "", 10); len(results) != 0 {
+		t.Errorf("old tag still matches: %v", results)
+	}
+	if results, _ := idx.Search("tag:env=prod", "", 10); len(results) != 1 {
+		t.Errorf("new tag does not match: %v", results)
+	}
+	if idx.Count() != 1 {
+		t.Errorf("count = %d", idx.Count())
+	}
+
+	// Removing the last document with a term drops the term
+	idx.Remove("b", "file.txt")
+	idx.db.View(func(tx *bolt.Tx) error {
+		if n := tx.Bucket(termsBucket).Stats().KeyN; n != 0 {
+			t.Errorf("%d terms left after removing every document", n)
+		}
+		if n := tx.Bucket(postingsBucket).Stats().KeyN; n != 0 {
+			t.Errorf("%d postings left after removing every document", n)
+		}
+		return nil
+	})
+}
+
+func TestIndex_LimitAndOrder(t *testing.T) {
+	idx := newTestIndex(t)
+	for _, key := range []string{"log-3", "log-1", "other", "log-2"} {
+		idx.Update("b", key, metadata.ObjectMeta{})
+	}
+	results, _ := idx.Search("log", "", 2)
+	if got := keys(results); got != "log-3,log-1" {
+		t.Errorf("results = %q", got)
+	}
+}
+
+func TestIndex_PersistsAndBuilds(t *testing.T) {
+	dir := t.TempDir()
+	store, err := metadata.NewStore(filepath.Join(dir, "meta.db"))
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	defer store.Close()
+	store.CreateBucket("photos")
+	store.PutObjectMeta(metadata.ObjectMeta{Bucket: "photos", Key: "cat.jpg", ContentType: "image/jpeg"})
+	store.PutObjectMeta(metadata.ObjectMeta{Bucket: "photos", Key: "dog.jpg", ContentType: "image/jpeg"})
+
+	path := filepath.Join(dir, "search.db")
+	idx, err := Open(path, store)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if !idx.NeedsBuild() {
+		t.Fatal("new index should need a build")
+	}
+	if err := idx.Build(); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if idx.NeedsBuild() || idx.Count() != 2 {
+		t.Fatalf("after build: needs build %v, count %d", idx.NeedsBuild(), idx.Count())
+	}
+	idx.Update("photos", "bird.png", metadata.ObjectMeta{ContentType: "image/png"})
+	idx.Remove("photos", "dog.jpg")
+	idx.Close()
+
+	// The index is read back as it was, without rebuilding from the store
+	idx, err = Open(path, store)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer idx.Close()
+	if idx.NeedsBuild() {
+		t.Fatal("built index should not need a build")
+	}
+	results, _ := idx.Search("type:image", "photos", 10)
+	if got := keys(results); got != "cat.jpg,bird.png" {
+		t.Errorf("results = %q", got)
+	}
+
+	// A rebuild starts over from the store
+	if err := idx.Build(); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	results, _ = idx.Search("type:image", "photos", 10)
+	if got := keys(results); got != "cat.jpg,dog.jpg" {
+		t.Errorf("results after rebuild = %q", got)
+	}
+}
+
+func TestObserver_FollowsStoreWrites(t *testing.T) {
+	dir := t.TempDir()
+	store, err := metadata.NewStore(filepath.Join(dir, "meta.db"))
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	defer store.Close()
+	idx, err := Open(filepath.Join(dir, "search.db"), store)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer idx.Close()
+	store.SetObjectObserver(NewObserver(idx, nil))
+
+	store.CreateBucket("photos")
+	store.CreateBucket("other")
+	store.PutObjectMeta(metadata.ObjectMeta{Bucket: "photos", Key: "cat.jpg", ContentType: "image/jpeg"})
+	store.PutObjectMeta(metadata.ObjectMeta{Bucket: "photos", Key: "dog.jpg", ContentType: "image/jpeg"})
+	store.PutObjectMeta(metadata.ObjectMeta{Bucket: "other", Key: "fox.jpg", ContentType: "image/jpeg"})
+	store.DeleteObjectMeta("photos", "dog.jpg")
+	results, _ := idx.Search("type:image", "photos", 10)
+	if got := keys(results); got != "cat.jpg" {
+		t.Errorf("results = %q", got)
+	}
+
+	// Writes that do not come from the S3 handlers are indexed too
+	store.PutObjectMeta(metadata.ObjectMeta{Bucket: "photos", Key: "cat.jpg", ContentType: "image/jpeg", VersionID: "v1", IsLatest: true})
+	store.PutObjectVersion(metadata.ObjectMeta{Bucket: "photos", Key: "cat.jpg", ContentType: "image/png", VersionID: "v1", IsLatest: true})
+	if err := store.SetLatestVersion("photos", "cat.jpg", "v1"); err != nil {
+		t.Fatalf("SetLatestVersion: %v", err)
+	}
+	results, _ = idx.Search("type:png", "photos", 10)
+	if got := keys(results); got != "cat.jpg" {
+		t.Errorf("results after SetLatestVersion = %q", got)
+	}
+
+	// Deleting a bucket drops its documents only
+	store.DeleteBucketObjectMeta("photos")
+	store.DeleteBucket("photos")
+	if n := idx.Count(); n != 1 {
+		t.Errorf("count after bucket delete = %d, want 1", n)
+	}
+	results, _ = idx.Search("type:image", "", 10)
+	if got := keys(results); got != "fox.jpg" {
+		t.Errorf("results after bucket delete = %q", got)
+	}
+}
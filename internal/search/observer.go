@@ -0,0 +1,40 @@
+package search
+
+import (
+	"log/slog"
+
+	"github.com/eniz1806/VaultS3/internal/metadata"
+)
+
+// Observer keeps an index up to date with the metadata store. Set as the
+// store's object observer, it sees every metadata write, whether it comes
+// from the S3 API, the dashboard, lifecycle, batch jobs or the scanner.
+type Observer struct {
+	idx     *Index
+	content *ContentIndexer
+}
+
+// NewObserver returns an observer that updates idx and queues changed
+// objects for content indexing. content may be nil.
+func NewObserver(idx *Index, content *ContentIndexer) *Observer {
+	return &Observer{idx: idx, content: content}
+}
+
+// ObjectChanged implements metadata.ObjectObserver.
+func (o *Observer) ObjectChanged(bucket, key string, meta *metadata.ObjectMeta) {
+	if meta == nil {
+		o.idx.Remove(bucket, key)
+		return
+	}
+	o.idx.Update(bucket, key, *meta)
+	if o.content != nil {
+		o.content.Enqueue(bucket, key, *meta)
+	}
+}
+
+// BucketDeleted implements metadata.ObjectObserver.
+func (o *Observer) BucketDeleted(bucket string) {
+	if err := o.idx.RemoveBucket(bucket); err != nil {
+		slog.Warn("search index bucket removal failed", "bucket", bucket, "error", err)
+	}
+}
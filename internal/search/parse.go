@@ -0,0 +1,450 @@
+package search
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// QueryError reports a query that does not parse.
+type QueryError struct {
+	Pos int // byte offset in the query
+	Msg string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("invalid query at position %d: %s", e.Pos, e.Msg)
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokTerm
+	tokPhrase
+	tokLParen
+	tokRParen
+	tokAnd
+	tokOr
+	tokNot
+)
+
+type token struct {
+	kind tokenKind
+	text string // term, or phrase without its quotes
+	pos  int
+}
+
+// lex splits a query into tokens. Terms run up to white space or a
+// parenthesis; a quoted part of a term, as in meta.owner:"Alice Smith",
+// may contain both. AND, OR and NOT are operators only in upper case.
+func lex(query string) ([]token, error) {
+	var toks []token
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			toks = append(toks, token{kind: tokLParen, pos: i})
+			i++
+		case c == ')':
+			toks = append(toks, token{kind: tokRParen, pos: i})
+			i++
+		case c == '"':
+			end := strings.IndexByte(query[i+1:], '"')
+			if end < 0 {
+				return nil, &QueryError{Pos: i, Msg: "unterminated quote"}
+			}
+			toks = append(toks, token{kind: tokPhrase, text: query[i+1 : i+1+end], pos: i})
+			i += end + 2
+		default:
+			start := i
+			var b strings.Builder
+			for i < len(query) && !strings.ContainsRune(" \t\n\r()", rune(query[i])) {
+				if query[i] == '"' {
+					end := strings.IndexByte(query[i+1:], '"')
+					if end < 0 {
+						return nil, &QueryError{Pos: i, Msg: "unterminated quote"}
+					}
+					b.WriteString(query[i+1 : i+1+end])
+					i += end + 2
+					continue
+				}
+				b.WriteByte(query[i])
+				i++
+			}
+			t := token{kind: tokTerm, text: b.String(), pos: start}
+			switch query[start:i] {
+			case "AND":
+				t.kind = tokAnd
+			case "OR":
+				t.kind = tokOr
+			case "NOT":
+				t.kind = tokNot
+			}
+			toks = append(toks, t)
+		}
+	}
+	return append(toks, token{kind: tokEOF, pos: len(query)}), nil
+}
+
+// Sort orders of query results.
+const (
+	sortScore    = "score"
+	sortSize     = "size"
+	sortModified = "modified"
+	sortKey      = "key"
+)
+
+// sortSpec is the sort: clause of a query; field is empty without one.
+type sortSpec struct {
+	field string
+	desc  bool
+}
+
+// query is a parsed query.
+type query struct {
+	root node
+	sort sortSpec
+	// scoreTerms are the "c:" terms of words that are not negated, which
+	// rank matches by their text.
+	scoreTerms []string
+}
+
+type parser struct {
+	toks   []token
+	i      int
+	depth  int // parentheses and NOTs around the current token
+	negate int // NOTs around the current token
+	q      *query
+}
+
+// parse parses a query:
+//
+//	query   = or
+//	or      = and { "OR" and }
+//	and     = unary { ["AND"] unary }
+//	unary   = "NOT" unary | "(" or ")" | phrase | term
+//
+// Terms are words, which match words of the metadata by prefix and words of
+// indexed text by stem, or field:value qualifiers. "sort:field [asc|desc]"
+// may appear once at the top level. A query without terms matches every
+// object.
+func parse(s string) (*query, error) {
+	toks, err := lex(s)
+	if err != nil {
+		return nil, err
+	}
+	q := &query{}
+	p := &parser{toks: toks, q: q}
+	root, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, &QueryError{Pos: t.pos, Msg: "unexpected )"}
+	}
+	if root == nil {
+		root = allNode{}
+	}
+	q.root = root
+	return q, nil
+}
+
+func (p *parser) peek() token { return p.toks[p.i] }
+
+func (p *parser) next() token {
+	t := p.toks[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+// or returns nil when the expression has no terms, as when it only sorts.
+func (p *parser) or() (node, error) {
+	start := p.peek()
+	first, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokOr {
+		return first, nil
+	}
+	if first == nil {
+		return nil, &QueryError{Pos: start.pos, Msg: "OR needs a term on each side"}
+	}
+	nodes := orNode{first}
+	for p.peek().kind == tokOr {
+		op := p.next()
+		n, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		if n == nil {
+			return nil, &QueryError{Pos: op.pos, Msg: "OR needs a term on each side"}
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes, nil
+}
+
+func (p *parser) and() (node, error) {
+	var nodes andNode
+	for {
+		t := p.peek()
+		switch t.kind {
+		case tokEOF, tokRParen, tokOr:
+			switch len(nodes) {
+			case 0:
+				return nil, nil
+			case 1:
+				return nodes[0], nil
+			}
+			return nodes, nil
+		case tokAnd:
+			p.next()
+			if len(nodes) == 0 {
+				return nil, &QueryError{Pos: t.pos, Msg: "AND needs a term on each side"}
+			}
+			if k := p.peek().kind; k == tokEOF || k == tokRParen || k == tokOr || k == tokAnd {
+				return nil, &QueryError{Pos: t.pos, Msg: "AND needs a term on each side"}
+			}
+			continue
+		}
+		n, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		if n != nil {
+			nodes = append(nodes, n)
+		}
+	}
+}
+
+func (p *parser) unary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNot:
+		p.depth++
+		p.negate++
+		n, err := p.unary()
+		p.depth--
+		p.negate--
+		if err != nil {
+			return nil, err
+		}
+		if n == nil {
+			return nil, &QueryError{Pos: t.pos, Msg: "NOT needs a term"}
+		}
+		return notNode{n}, nil
+	case tokLParen:
+		p.depth++
+		n, err := p.or()
+		p.depth--
+		if err != nil {
+			return nil, err
+		}
+		if p.peek().kind != tokRParen {
+			return nil, &QueryError{Pos: t.pos, Msg: "missing )"}
+		}
+		p.next()
+		if n == nil {
+			return nil, &QueryError{Pos: t.pos, Msg: "empty parentheses"}
+		}
+		return n, nil
+	case tokPhrase:
+		return p.phrase(t.text), nil
+	case tokTerm:
+		return p.term(t)
+	case tokEOF:
+		return nil, &QueryError{Pos: t.pos, Msg: "unexpected end of query"}
+	}
+	return nil, &QueryError{Pos: t.pos, Msg: "unexpected " + map[tokenKind]string{tokRParen: ")", tokAnd: "AND", tokOr: "OR"}[t.kind]}
+}
+
+// term parses a word or a field:value qualifier. It returns nil for sort:.
+func (p *parser) term(t token) (node, error) {
+	field, value, ok := strings.Cut(t.text, ":")
+	field = strings.ToLower(field)
+	if !ok || value == "" {
+		return p.words(t.text), nil
+	}
+	lower := strings.ToLower(value)
+	switch {
+	case field == "tag":
+		return &termNode{c: newClause("g:"+lower, false)}, nil
+	case field == "meta":
+		return &termNode{c: newClause("m:"+lower, false)}, nil
+	case strings.HasPrefix(field, "tag.") && len(field) > len("tag."):
+		return &termNode{c: newClause("g:"+field[len("tag."):]+"="+lower, false)}, nil
+	case strings.HasPrefix(field, "meta.") && len(field) > len("meta."):
+		return &termNode{c: newClause("m:"+field[len("meta."):]+"="+lower, false)}, nil
+	case field == "type":
+		return &termNode{c: newClause("t:"+lower, true)}, nil
+	case field == "path":
+		return &termNode{c: newClause("s:"+strings.Trim(lower, "/"), true)}, nil
+	case field == "bucket":
+		return &termNode{c: newClause("b:"+value, false)}, nil
+	case field == "prefix":
+		return newPrefixNode(value), nil
+	case field == sortSize || field == sortModified:
+		lo, hi, err := parseRange(field, value)
+		if err != nil {
+			return nil, &QueryError{Pos: t.pos, Msg: err.Error()}
+		}
+		return rangeNode{field: field, lo: lo, hi: hi}, nil
+	case field == "sort":
+		return nil, p.sortClause(t, lower)
+	}
+	return p.words(t.text), nil
+}
+
+func (p *parser) sortClause(t token, field string) error {
+	if p.depth > 0 {
+		return &QueryError{Pos: t.pos, Msg: "sort: must be outside parentheses and NOT"}
+	}
+	if p.q.sort.field != "" {
+		return &QueryError{Pos: t.pos, Msg: "only one sort: is allowed"}
+	}
+	switch field {
+	case sortScore, sortSize, sortModified, sortKey:
+	default:
+		return &QueryError{Pos: t.pos, Msg: fmt.Sprintf("cannot sort by %q: use score, size, modified or key", field)}
+	}
+	p.q.sort = sortSpec{field: field, desc: field == sortScore}
+	if next := p.peek(); next.kind == tokTerm {
+		switch strings.ToLower(next.text) {
+		case "asc":
+			p.q.sort.desc = false
+			p.next()
+		case "desc":
+			p.q.sort.desc = true
+			p.next()
+		}
+	}
+	return nil
+}
+
+// words returns the node matching all words of s, or nil if it has none.
+func (p *parser) words(s string) node {
+	var nodes andNode
+	for _, w := range words(s) {
+		nodes = append(nodes, p.word(w))
+	}
+	switch len(nodes) {
+	case 0:
+		return nil
+	case 1:
+		return nodes[0]
+	}
+	return nodes
+}
+
+// word matches words of the metadata starting with w and words of indexed
+// text with the stem of w. Stop words are not indexed in text, so any
+// object with text matches them.
+func (p *parser) word(w string) node {
+	c := newClause("w:"+w, true)
+	if stopWords[w] {
+		c.anyContent = true
+	} else {
+		c.content = normalizeTerm("c:" + stem(w))
+		if p.negate == 0 {
+			p.q.scoreTerms = append(p.q.scoreTerms, c.content)
+		}
+	}
+	return &termNode{c: c}
+}
+
+func (p *parser) phrase(s string) node {
+	ws := words(s)
+	if len(ws) <= 1 {
+		return p.words(s)
+	}
+	n := &phraseNode{all: make(andNode, len(ws))}
+	for i, w := range ws {
+		n.all[i] = p.word(w)
+		n.stems = append(n.stems, stem(w))
+	}
+	return n
+}
+
+func newClause(term string, prefix bool) clause {
+	return clause{term: normalizeTerm(term), prefix: prefix}
+}
+
+// parseRange parses the value of size: or modified: into the half-open
+// range [lo, hi) it matches: an optional comparison (>, >=, <, <=, =)
+// followed by a size with an optional unit (10MB, 1.5GiB, 512) or a date
+// (2026-01-01, or an RFC 3339 time). A date without a comparison matches
+// the whole day, and <= and > include and exclude it.
+func parseRange(field, value string) (int64, int64, error) {
+	op := ""
+	for _, o := range []string{">=", "<=", ">", "<", "="} {
+		if strings.HasPrefix(value, o) {
+			op, value = o, value[len(o):]
+			break
+		}
+	}
+	var v, step int64
+	var err error
+	if field == sortSize {
+		v, err = parseSize(value)
+		step = 1
+	} else {
+		v, step, err = parseDate(value)
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	switch op {
+	case ">":
+		return v + step, math.MaxInt64, nil
+	case ">=":
+		return v, math.MaxInt64, nil
+	case "<":
+		return math.MinInt64, v, nil
+	case "<=":
+		return math.MinInt64, v + step, nil
+	}
+	return v, v + step, nil
+}
+
+var sizeUnits = map[string]float64{
+	"": 1, "b": 1,
+	"k": 1 << 10, "kb": 1 << 10, "kib": 1 << 10,
+	"m": 1 << 20, "mb": 1 << 20, "mib": 1 << 20,
+	"g": 1 << 30, "gb": 1 << 30, "gib": 1 << 30,
+	"t": 1 << 40, "tb": 1 << 40, "tib": 1 << 40,
+}
+
+// parseSize parses a size such as 512, 10MB or 1.5GiB. Units are powers of
+// 1024.
+func parseSize(s string) (int64, error) {
+	i := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if i < 0 {
+		i = len(s)
+	}
+	n, err := strconv.ParseFloat(s[:i], 64)
+	unit, ok := sizeUnits[strings.ToLower(s[i:])]
+	if err != nil || !ok || n < 0 {
+		return 0, fmt.Errorf("invalid size %q: use a number with an optional unit, as in 10MB", s)
+	}
+	return int64(n * unit), nil
+}
+
+// parseDate parses a date or an RFC 3339 time into Unix seconds and the
+// length of the period it names: a day or a second.
+func parseDate(s string) (int64, int64, error) {
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t.Unix(), 24 * 60 * 60, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.Unix(), 1, nil
+	}
+	return 0, 0, fmt.Errorf("invalid date %q: use 2006-01-02 or an RFC 3339 time", s)
+}
@@ -0,0 +1,915 @@
+package search
+
+import (
+	"bytes"
+	"cmp"
+	"container/heap"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"hash/fnv"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// maxPrefixTerms is how many distinct terms a prefix may expand to. A
+// prefix that matches more only matches the whole word.
+const maxPrefixTerms = 1000
+
+// clause is one condition of a query: a term, or all terms starting with
+// it when prefix is set. A word of a query also matches its stem in the
+// text of objects, in content, and stop words any object with text.
+type clause struct {
+	term       string
+	prefix     bool
+	content    string
+	anyContent bool
+}
+
+// maxCountedHits is how many matches of an unsorted query are counted for
+// its total. Counting stops there, so a page never reads more documents.
+var maxCountedHits = 10000
+
+// Request is a query with the page of results to return.
+type Request struct {
+	Query  string
+	Bucket string // restricts the query to a bucket, like bucket:
+	Prefix string // restricts the query to keys with a prefix, like prefix:
+	Limit  int    // results per page, 50 by default
+	Cursor string // NextCursor of the previous page
+}
+
+// Page is a page of the results of a query.
+type Page struct {
+	Results     []Result `json:"results"`
+	Total       int      `json:"total"`                  // matches on all pages
+	TotalCapped bool     `json:"total_capped,omitempty"` // Total is a lower bound
+	NextCursor  string   `json:"next_cursor,omitempty"`  // empty on the last page
+}
+
+// ErrInvalidCursor is returned for a cursor that was not returned by the
+// same query.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// BM25 parameters: term frequency saturation and document length
+// normalization.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// Search returns the first results of a query, or nothing for an empty
+// query.
+func (idx *Index) Search(query, bucket string, limit int) ([]Result, error) {
+	if strings.TrimSpace(query) == "" {
+		return nil, nil
+	}
+	page, err := idx.Query(Request{Query: query, Bucket: bucket, Limit: limit})
+	if err != nil {
+		return nil, err
+	}
+	return page.Results, nil
+}
+
+// Query runs a query and returns a page of its results with the total
+// number of matches. When words of the query occur in indexed text, results
+// are ranked by BM25 over that text and come with a snippet; otherwise, and
+// for equal scores, they are in the order objects were first indexed. A
+// sort: clause orders them by another field.
+//
+// Unsorted results are read in document order from the cursor on, and the
+// total counts at most maxCountedHits further matches. Ranked and sorted
+// results visit every match but keep only the page in memory.
+func (idx *Index) Query(req Request) (*Page, error) {
+	q, err := parse(req.Query)
+	if err != nil {
+		return nil, err
+	}
+	root := q.root
+	if req.Prefix != "" {
+		root = andNode{newPrefixNode(req.Prefix), root}
+	}
+	if req.Bucket != "" {
+		root = andNode{&termNode{c: newClause("b:"+req.Bucket, false)}, root}
+	}
+	limit := req.Limit
+	if limit <= 0 {
+		limit = 50
+	}
+	queryHash := hashQuery(req)
+	var after *hit
+	if req.Cursor != "" {
+		if after, err = decodeCursor(req.Cursor, queryHash); err != nil {
+			return nil, err
+		}
+	}
+
+	page := &Page{Results: []Result{}}
+	err = idx.db.View(func(tx *bolt.Tx) error {
+		e := &exec{tx: tx, docs: tx.Bucket(docsBucket)}
+		root.open(e)
+		it := root.iter()
+		if it == nil {
+			it = bucketIter{b: e.docs, n: infoValue(tx, infoCount)}
+		}
+
+		var scoreTerms []string
+		terms := tx.Bucket(termsBucket)
+		for _, t := range q.scoreTerms {
+			if termFreq(terms, t) > 0 && !slices.Contains(scoreTerms, t) {
+				scoreTerms = append(scoreTerms, t)
+			}
+		}
+		var scorer *bm25
+		if len(scoreTerms) > 0 {
+			scorer = newBM25(tx, scoreTerms)
+		}
+		order := q.sort
+		if order.field == "" && scorer != nil {
+			order = sortSpec{field: sortScore, desc: true}
+		}
+
+		var hits []hit
+		exact := root.exact()
+		// matches calls fn for the matching documents from ID from on,
+		// until it returns false
+		matches := func(from uint64, fn func(id uint64) bool) {
+			for next := from; ; {
+				id, ok := it.seek(next)
+				if !ok {
+					return
+				}
+				next = id + 1
+				if (exact || root.match(e, id)) && !fn(id) {
+					return
+				}
+			}
+		}
+
+		if order.field == "" {
+			// Document order: seek past the cursor and stop counting
+			// at the cap
+			skipped, from := 0, uint64(1)
+			if after != nil {
+				skipped, from = after.C, after.ID+1
+			}
+			counted := skipped
+			matches(from, func(id uint64) bool {
+				counted++
+				if len(hits) <= limit {
+					hits = append(hits, hit{ID: id, C: counted})
+				}
+				if counted-skipped-len(hits) >= maxCountedHits {
+					page.TotalCapped = true
+					return false
+				}
+				return true
+			})
+			page.Total = counted
+		} else {
+			// Keep the limit+1 first hits after the cursor in a heap with
+			// the last of them on top
+			top := &hitHeap{order: order}
+			matches(1, func(id uint64) bool {
+				page.Total++
+				h := hit{ID: id}
+				switch order.field {
+				case sortScore:
+					if scorer != nil {
+						h.N = scorer.score(id)
+					}
+				case sortSize, sortModified, sortKey:
+					doc := e.doc(id)
+					if doc == nil {
+						return true
+					}
+					h.N, h.S = float64(doc.Size), doc.Key
+					if order.field == sortModified {
+						h.N = float64(doc.LastModified)
+					}
+				}
+				if after != nil && !order.less(*after, h) {
+					return true
+				}
+				if top.Len() <= limit {
+					heap.Push(top, h)
+				} else if order.less(h, top.hits[0]) {
+					top.hits[0] = h
+					heap.Fix(top, 0)
+				}
+				return true
+			})
+			hits = top.hits
+			sort.Slice(hits, func(i, j int) bool { return order.less(hits[i], hits[j]) })
+		}
+		end := min(limit, len(hits))
+		stems := make(map[string]bool, len(scoreTerms))
+		for _, t := range scoreTerms {
+			stems[strings.TrimPrefix(t, "c:")] = true
+		}
+		for _, h := range hits[:end] {
+			doc := e.doc(h.ID)
+			if doc == nil {
+				continue
+			}
+			r := doc.result()
+			if scorer != nil {
+				r.Score = scorer.score(h.ID)
+				if cd := e.content(h.ID); cd != nil {
+					r.Snippet = snippet(cd.Text, stems)
+				}
+			}
+			page.Results = append(page.Results, r)
+		}
+		if end < len(hits) {
+			page.NextCursor = encodeCursor(hits[end-1], queryHash)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// hit is a match with the value it is sorted by. It is also the position a
+// cursor resumes after.
+type hit struct {
+	ID uint64  `json:"id"`
+	N  float64 `json:"n,omitempty"` // score, size or modification time
+	S  string  `json:"s,omitempty"` // key
+	C  int     `json:"c,omitempty"` // matches up to this one, in document order
+	Q  uint32  `json:"q"`           // hash of the query, in cursors
+}
+
+// hitHeap is a max-heap of hits in sort order: its top is the hit that
+// sorts last.
+type hitHeap struct {
+	hits  []hit
+	order sortSpec
+}
+
+func (h *hitHeap) Len() int           { return len(h.hits) }
+func (h *hitHeap) Less(i, j int) bool { return h.order.less(h.hits[j], h.hits[i]) }
+func (h *hitHeap) Swap(i, j int)      { h.hits[i], h.hits[j] = h.hits[j], h.hits[i] }
+func (h *hitHeap) Push(x any)         { h.hits = append(h.hits, x.(hit)) }
+func (h *hitHeap) Pop() any {
+	x := h.hits[len(h.hits)-1]
+	h.hits = h.hits[:len(h.hits)-1]
+	return x
+}
+
+// less orders hits by the sort field, then by doc ID.
+func (o sortSpec) less(a, b hit) bool {
+	var c int
+	switch o.field {
+	case sortKey:
+		c = strings.Compare(a.S, b.S)
+	case sortScore, sortSize, sortModified:
+		c = cmp.Compare(a.N, b.N)
+	}
+	if o.desc {
+		c = -c
+	}
+	if c != 0 {
+		return c < 0
+	}
+	return a.ID < b.ID
+}
+
+func hashQuery(req Request) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(req.Query + "\x00" + req.Bucket + "\x00" + req.Prefix))
+	return h.Sum32()
+}
+
+func encodeCursor(h hit, queryHash uint32) string {
+	h.Q = queryHash
+	data, _ := json.Marshal(h)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string, queryHash uint32) (*hit, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var h hit
+	if json.Unmarshal(data, &h) != nil || h.Q != queryHash {
+		return nil, ErrInvalidCursor
+	}
+	return &h, nil
+}
+
+// exec holds the state of a running query: its transaction and the
+// documents last read.
+type exec struct {
+	tx   *bolt.Tx
+	docs *bolt.Bucket
+
+	docID     uint64
+	lastDoc   *storedDoc
+	contentID uint64
+	lastCD    *contentDoc
+}
+
+// doc returns a document, or nil if it does not exist.
+func (e *exec) doc(id uint64) *storedDoc {
+	if id == e.docID {
+		return e.lastDoc
+	}
+	e.docID, e.lastDoc = id, nil
+	var doc storedDoc
+	if data := e.docs.Get(docID(id)); data != nil && json.Unmarshal(data, &doc) == nil {
+		e.lastDoc = &doc
+	}
+	return e.lastDoc
+}
+
+// content returns the indexed text of a document, or nil if it has none.
+func (e *exec) content(id uint64) *contentDoc {
+	if id == e.contentID {
+		return e.lastCD
+	}
+	e.contentID, e.lastCD = id, nil
+	doc := e.doc(id)
+	if doc == nil {
+		return nil
+	}
+	var cd contentDoc
+	if data := e.tx.Bucket(contentBucket).Get([]byte(doc.Bucket + "/" + doc.Key)); data != nil && json.Unmarshal(data, &cd) == nil {
+		e.lastCD = &cd
+	}
+	return e.lastCD
+}
+
+// node is a parsed query expression.
+type node interface {
+	// open prepares the node to run in e.
+	open(e *exec)
+	// iter returns the documents that may match, or nil if the node does
+	// not narrow them down.
+	iter() postingIter
+	// exact reports whether iter returns exactly the matching documents.
+	exact() bool
+	// match reports whether a document matches.
+	match(e *exec, id uint64) bool
+}
+
+// termNode matches the documents of a clause.
+type termNode struct {
+	c  clause
+	it postingIter
+}
+
+func (n *termNode) open(e *exec) {
+	if n.it = openClause(e.tx, n.c); n.it == nil {
+		n.it = emptyIter{}
+	}
+}
+
+func (n *termNode) iter() postingIter { return n.it }
+func (n *termNode) exact() bool       { return true }
+
+func (n *termNode) match(_ *exec, id uint64) bool {
+	got, ok := n.it.seek(id)
+	return ok && got == id
+}
+
+// andNode matches documents all of its nodes match.
+type andNode []node
+
+func (n andNode) open(e *exec) {
+	for _, c := range n {
+		c.open(e)
+	}
+}
+
+func (n andNode) iter() postingIter {
+	var its intersectIter
+	for _, c := range n {
+		if it := c.iter(); it != nil {
+			its = append(its, it)
+		}
+	}
+	switch len(its) {
+	case 0:
+		return nil
+	case 1:
+		return its[0]
+	}
+	// The rarest list drives the intersection
+	sort.Slice(its, func(i, j int) bool { return its[i].size() < its[j].size() })
+	return its
+}
+
+func (n andNode) exact() bool {
+	for _, c := range n {
+		if !c.exact() {
+			return false
+		}
+	}
+	return true
+}
+
+func (n andNode) match(e *exec, id uint64) bool {
+	for _, c := range n {
+		if !c.match(e, id) {
+			return false
+		}
+	}
+	return true
+}
+
+// orNode matches documents any of its nodes matches.
+type orNode []node
+
+func (n orNode) open(e *exec) {
+	for _, c := range n {
+		c.open(e)
+	}
+}
+
+func (n orNode) iter() postingIter {
+	its := make(unionIter, 0, len(n))
+	for _, c := range n {
+		it := c.iter()
+		if it == nil {
+			return nil
+		}
+		its = append(its, it)
+	}
+	return its
+}
+
+func (n orNode) exact() bool {
+	for _, c := range n {
+		if !c.exact() {
+			return false
+		}
+	}
+	return true
+}
+
+func (n orNode) match(e *exec, id uint64) bool {
+	for _, c := range n {
+		if c.match(e, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// notNode matches documents its node does not match.
+type notNode struct{ n node }
+
+func (n notNode) open(e *exec)                  { n.n.open(e) }
+func (n notNode) iter() postingIter             { return nil }
+func (n notNode) exact() bool                   { return false }
+func (n notNode) match(e *exec, id uint64) bool { return !n.n.match(e, id) }
+
+// allNode matches every document.
+type allNode struct{}
+
+func (allNode) open(*exec)               {}
+func (allNode) iter() postingIter        { return nil }
+func (allNode) exact() bool              { return false }
+func (allNode) match(*exec, uint64) bool { return true }
+
+// rangeNode matches documents whose size or modification time is in
+// [lo, hi).
+type rangeNode struct {
+	field  string
+	lo, hi int64
+}
+
+func (n rangeNode) open(*exec)        {}
+func (n rangeNode) iter() postingIter { return nil }
+func (n rangeNode) exact() bool       { return false }
+
+func (n rangeNode) match(e *exec, id uint64) bool {
+	doc := e.doc(id)
+	if doc == nil {
+		return false
+	}
+	v := doc.Size
+	if n.field == sortModified {
+		v = doc.LastModified
+	}
+	return v >= n.lo && v < n.hi
+}
+
+// prefixNode matches documents whose key starts with a prefix. The path
+// segments of the prefix narrow down the documents to check.
+type prefixNode struct {
+	prefix   string
+	segments node
+}
+
+func newPrefixNode(prefix string) *prefixNode {
+	n := &prefixNode{prefix: prefix}
+	segs := strings.Split(strings.ToLower(prefix), "/")
+	var terms andNode
+	for i, seg := range segs {
+		if seg != "" {
+			// The last segment may continue in the key
+			terms = append(terms, &termNode{c: newClause("s:"+seg, i == len(segs)-1)})
+		}
+	}
+	if len(terms) > 0 {
+		n.segments = terms
+	}
+	return n
+}
+
+func (n *prefixNode) open(e *exec) {
+	if n.segments != nil {
+		n.segments.open(e)
+	}
+}
+
+func (n *prefixNode) iter() postingIter {
+	if n.segments == nil {
+		return nil
+	}
+	return n.segments.iter()
+}
+
+func (n *prefixNode) exact() bool { return false }
+
+func (n *prefixNode) match(e *exec, id uint64) bool {
+	doc := e.doc(id)
+	return doc != nil && strings.HasPrefix(doc.Key, n.prefix)
+}
+
+// phraseNode matches documents with its words in a row, compared by stem,
+// in a field of the metadata or in the indexed text. Only the start of the
+// text kept for snippets is searched.
+type phraseNode struct {
+	stems []string
+	all   andNode // the words in any order
+}
+
+func (n *phraseNode) open(e *exec)      { n.all.open(e) }
+func (n *phraseNode) iter() postingIter { return n.all.iter() }
+func (n *phraseNode) exact() bool       { return false }
+
+func (n *phraseNode) match(e *exec, id uint64) bool {
+	doc := e.doc(id)
+	if doc == nil || !n.all.match(e, id) {
+		return false
+	}
+	fields := []string{doc.Key, doc.ContentType}
+	for k, v := range doc.Tags {
+		fields = append(fields, k, v)
+	}
+	for k, v := range doc.UserMetadata {
+		fields = append(fields, k, v)
+	}
+	if cd := e.content(id); cd != nil {
+		fields = append(fields, cd.Text)
+	}
+	for _, f := range fields {
+		if containsStems(words(f), n.stems) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsStems reports whether ws has words with the given stems in a row.
+func containsStems(ws, stems []string) bool {
+	for i := 0; i+len(stems) <= len(ws); i++ {
+		j := 0
+		for j < len(stems) && stem(ws[i+j]) == stems[j] {
+			j++
+		}
+		if j == len(stems) {
+			return true
+		}
+	}
+	return false
+}
+
+// bm25 scores documents by the "c:" terms of a query.
+type bm25 struct {
+	postings *bolt.Bucket
+	lengths  *bolt.Bucket
+	terms    []string
+	idf      []float64
+	avgdl    float64
+}
+
+func newBM25(tx *bolt.Tx, terms []string) *bm25 {
+	n := float64(infoValue(tx, infoContentDocs))
+	s := &bm25{
+		postings: tx.Bucket(postingsBucket),
+		lengths:  tx.Bucket(lengthsBucket),
+		terms:    terms,
+		avgdl:    1,
+	}
+	if n > 0 {
+		s.avgdl = max(float64(infoValue(tx, infoContentLength))/n, 1)
+	}
+	tb := tx.Bucket(termsBucket)
+	for _, t := range terms {
+		df := float64(termFreq(tb, t))
+		s.idf = append(s.idf, math.Log(1+(n-df+0.5)/(df+0.5)))
+	}
+	return s
+}
+
+func (s *bm25) score(id uint64) float64 {
+	v := s.lengths.Get(docID(id))
+	if len(v) != 4 {
+		return 0 // matched by metadata only
+	}
+	norm := bm25K1 * (1 - bm25B + bm25B*float64(binary.BigEndian.Uint32(v))/s.avgdl)
+	var score float64
+	for i, t := range s.terms {
+		if p := s.postings.Get(postingKey(t, id)); len(p) == 4 {
+			tf := float64(binary.BigEndian.Uint32(p))
+			score += s.idf[i] * tf * (bm25K1 + 1) / (tf + norm)
+		}
+	}
+	return score
+}
+
+func (doc storedDoc) result() Result {
+	return Result{
+		Bucket:       doc.Bucket,
+		Key:          doc.Key,
+		Size:         doc.Size,
+		ContentType:  doc.ContentType,
+		LastModified: doc.LastModified,
+		ETag:         doc.ETag,
+		Tags:         doc.Tags,
+		UserMetadata: doc.UserMetadata,
+	}
+}
+
+// Fragment is a piece of a snippet. Match marks words that matched the
+// query.
+type Fragment struct {
+	Text  string `json:"text"`
+	Match bool   `json:"match,omitempty"`
+}
+
+// Snippet size: characters of context before the first match and the
+// longest snippet.
+const (
+	snippetContext = 60
+	snippetLength  = 240
+)
+
+// snippet returns the part of text around the first word whose stem is in
+// stems, split into fragments that mark each such word, or nil if no word
+// matches.
+func snippet(text string, stems map[string]bool) []Fragment {
+	spans := wordSpans(text)
+	matches := func(i int) bool {
+		return stems[stem(strings.ToLower(text[spans[i][0]:spans[i][1]]))]
+	}
+	first := -1
+	for i := range spans {
+		if matches(i) {
+			first = i
+			break
+		}
+	}
+	if first < 0 {
+		return nil
+	}
+	from := first
+	for from > 0 && spans[first][0]-spans[from-1][0] <= snippetContext {
+		from--
+	}
+	to := first
+	for to+1 < len(spans) && spans[to+1][1]-spans[from][0] <= snippetLength {
+		to++
+	}
+
+	var frags []Fragment
+	add := func(s string, match bool) {
+		if n := len(frags); n > 0 && !frags[n-1].Match && !match {
+			frags[n-1].Text += s
+			return
+		}
+		frags = append(frags, Fragment{Text: s, Match: match})
+	}
+	if from > 0 {
+		add("…", false)
+	}
+	for i := from; i <= to; i++ {
+		if i > from {
+			add(collapseSpace(text[spans[i-1][1]:spans[i][0]]), false)
+		}
+		add(text[spans[i][0]:spans[i][1]], matches(i))
+	}
+	if to < len(spans)-1 {
+		add("…", false)
+	}
+	return frags
+}
+
+// collapseSpace replaces each run of white space in s with a single space.
+func collapseSpace(s string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range s {
+		if unicode.IsSpace(r) {
+			space = true
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteRune(r)
+	}
+	if space {
+		b.WriteByte(' ')
+	}
+	return b.String()
+}
+
+// wordSpans returns the byte ranges of the runs of letters and digits in s,
+// the words that words returns.
+func wordSpans(s string) [][2]int {
+	var spans [][2]int
+	start := -1
+	for i, r := range s {
+		inWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		switch {
+		case inWord && start < 0:
+			start = i
+		case !inWord && start >= 0:
+			spans = append(spans, [2]int{start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		spans = append(spans, [2]int{start, len(s)})
+	}
+	return spans
+}
+
+// postingIter walks the sorted doc IDs of a posting list.
+type postingIter interface {
+	// seek returns the first doc ID at or after id, or false if there is none.
+	seek(id uint64) (uint64, bool)
+	// size returns the number of documents in the list.
+	size() uint64
+}
+
+// openClause returns an iterator over the documents matching a clause, or
+// nil if none do.
+func openClause(tx *bolt.Tx, c clause) postingIter {
+	terms := tx.Bucket(termsBucket)
+	var union unionIter
+	addTerm := func(term string) {
+		if n := termFreq(terms, term); n > 0 {
+			union = append(union, newTermIter(tx, term, n))
+		}
+	}
+	if c.content != "" {
+		addTerm(c.content)
+	}
+	if c.anyContent {
+		if n := infoValue(tx, infoContentDocs); n > 0 {
+			union = append(union, bucketIter{b: tx.Bucket(lengthsBucket), n: n})
+		}
+	}
+	if !c.prefix {
+		addTerm(c.term)
+	} else {
+		expanded := len(union)
+		tc := terms.Cursor()
+		prefix := []byte(c.term)
+		for k, v := tc.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = tc.Next() {
+			if len(union)-expanded == maxPrefixTerms {
+				// Too broad to expand: fall back to the whole word
+				union = union[:expanded]
+				addTerm(c.term)
+				break
+			}
+			union = append(union, newTermIter(tx, string(k), binary.BigEndian.Uint64(v)))
+		}
+	}
+	switch len(union) {
+	case 0:
+		return nil
+	case 1:
+		return union[0]
+	}
+	return union
+}
+
+type termIter struct {
+	c      *bolt.Cursor
+	prefix []byte
+	n      uint64
+}
+
+func newTermIter(tx *bolt.Tx, term string, n uint64) *termIter {
+	return &termIter{
+		c:      tx.Bucket(postingsBucket).Cursor(),
+		prefix: append([]byte(term), 0),
+		n:      n,
+	}
+}
+
+func (it *termIter) seek(id uint64) (uint64, bool) {
+	k, _ := it.c.Seek(binary.BigEndian.AppendUint64(bytes.Clone(it.prefix), id))
+	if k == nil || len(k) != len(it.prefix)+8 || !bytes.HasPrefix(k, it.prefix) {
+		return 0, false
+	}
+	return binary.BigEndian.Uint64(k[len(it.prefix):]), true
+}
+
+func (it *termIter) size() uint64 { return it.n }
+
+// unionIter matches the documents of any of its iterators.
+type unionIter []postingIter
+
+func (u unionIter) seek(id uint64) (uint64, bool) {
+	var lowest uint64
+	found := false
+	for _, it := range u {
+		if got, ok := it.seek(id); ok && (!found || got < lowest) {
+			lowest, found = got, true
+		}
+	}
+	return lowest, found
+}
+
+func (u unionIter) size() uint64 {
+	var n uint64
+	for _, it := range u {
+		n += it.size()
+	}
+	return n
+}
+
+// intersectIter matches the documents all of its iterators contain.
+type intersectIter []postingIter
+
+func (x intersectIter) seek(id uint64) (uint64, bool) {
+	for {
+		cand, ok := x[0].seek(id)
+		if !ok {
+			return 0, false
+		}
+		agreed := true
+		for _, it := range x[1:] {
+			got, ok := it.seek(cand)
+			if !ok {
+				return 0, false
+			}
+			if got != cand {
+				id, agreed = got, false
+				break
+			}
+		}
+		if agreed {
+			return cand, true
+		}
+	}
+}
+
+func (x intersectIter) size() uint64 {
+	n := x[0].size()
+	for _, it := range x[1:] {
+		n = min(n, it.size())
+	}
+	return n
+}
+
+// bucketIter walks the doc IDs that key a bucket, such as every document
+// or every document with text.
+type bucketIter struct {
+	b *bolt.Bucket
+	n uint64
+}
+
+func (it bucketIter) seek(id uint64) (uint64, bool) {
+	k, _ := it.b.Cursor().Seek(docID(id))
+	if len(k) != 8 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint64(k), true
+}
+
+func (it bucketIter) size() uint64 { return it.n }
+
+// emptyIter matches no documents.
+type emptyIter struct{}
+
+func (emptyIter) seek(uint64) (uint64, bool) { return 0, false }
+func (emptyIter) size() uint64               { return 0 }
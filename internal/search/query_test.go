@@ -0,0 +1,197 @@
+package search
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eniz1806/VaultS3/internal/metadata"
+)
+
+func newQueryTestIndex(t *testing.T) *Index {
+	t.Helper()
+	idx := newTestIndex(t)
+	day := func(s string) int64 {
+		d, _ := time.Parse(time.DateOnly, s)
+		return d.Unix()
+	}
+	objects := []struct {
+		bucket, key string
+		meta        metadata.ObjectMeta
+	}{
+		{"logs", "2026/01/app.log", metadata.ObjectMeta{Size: 20 << 20, ContentType: "text/plain", LastModified: day("2026-01-05"), UserMetadata: map[string]string{"owner": "alice"}}},
+		{"logs", "2025/12/app.log", metadata.ObjectMeta{Size: 5 << 20, ContentType: "text/plain", LastModified: day("2025-12-20"), UserMetadata: map[string]string{"owner": "bob"}}},
+		{"logs", "2026/01/db.log", metadata.ObjectMeta{Size: 50 << 20, ContentType: "text/plain", LastModified: day("2026-01-10"), Tags: map[string]string{"env": "prod"}}},
+		{"media", "2026/cover.png", metadata.ObjectMeta{Size: 1 << 20, ContentType: "image/png", LastModified: day("2026-01-01"), UserMetadata: map[string]string{"owner": "Alice Smith"}}},
+		{"media", "annual-report.pdf", metadata.ObjectMeta{Size: 300 << 10, ContentType: "application/pdf", LastModified: day("2025-06-30")}},
+	}
+	for _, o := range objects {
+		idx.Update(o.bucket, o.key, o.meta)
+	}
+	return idx
+}
+
+func TestQuery_Grammar(t *testing.T) {
+	idx := newQueryTestIndex(t)
+	for query, want := range map[string]string{
+		"size:>10MB":                         "2026/01/app.log,2026/01/db.log",
+		"size:<=1MB":                         "2026/cover.png,annual-report.pdf",
+		"size:1048576":                       "2026/cover.png",
+		"modified:<2026-01-01":               "2025/12/app.log,annual-report.pdf",
+		"modified:2026-01-05":                "2026/01/app.log",
+		"modified:>=2026-01-05":              "2026/01/app.log,2026/01/db.log",
+		"bucket:logs modified:>2026-01-05":   "2026/01/db.log",
+		"prefix:2026/":                       "2026/01/app.log,2026/01/db.log,2026/cover.png",
+		"prefix:2026/01/a":                   "2026/01/app.log",
+		"prefix:2026/C":                      "",
+		"meta.owner:alice":                   "2026/01/app.log",
+		`meta.owner:"alice smith"`:           "2026/cover.png",
+		"tag.env:prod":                       "2026/01/db.log",
+		"db OR cover":                        "2026/01/db.log,2026/cover.png",
+		"log NOT db":                         "2026/01/app.log,2025/12/app.log",
+		"log AND NOT (db OR meta.owner:bob)": "2026/01/app.log",
+		"NOT bucket:logs":                    "2026/cover.png,annual-report.pdf",
+		`"annual report"`:                    "annual-report.pdf",
+		`"report annual"`:                    "",
+		"(type:image OR type:application) size:<1MB": "annual-report.pdf",
+		"sort:size desc":        "2026/01/db.log,2026/01/app.log,2025/12/app.log,2026/cover.png,annual-report.pdf",
+		"log sort:modified":     "2025/12/app.log,2026/01/app.log,2026/01/db.log",
+		"sort:key bucket:media": "2026/cover.png,annual-report.pdf",
+		"text:db":               "2026/01/db.log",
+	} {
+		results, err := idx.Search(query, "", 10)
+		if err != nil {
+			t.Errorf("Search(%q): %v", query, err)
+			continue
+		}
+		if got := keys(results); got != want {
+			t.Errorf("Search(%q) = %q, want %q", query, got, want)
+		}
+	}
+}
+
+func TestQuery_Errors(t *testing.T) {
+	idx := newQueryTestIndex(t)
+	for _, query := range []string{
+		"(app",
+		"app)",
+		"()",
+		"OR app",
+		"app OR",
+		"app AND",
+		"NOT",
+		`"unterminated`,
+		"size:>ten",
+		"modified:<yesterday",
+		"sort:color",
+		"sort:size sort:key",
+		"(app sort:size)",
+	} {
+		_, err := idx.Query(Request{Query: query})
+		var qe *QueryError
+		if !errors.As(err, &qe) {
+			t.Errorf("Query(%q) error = %v, want a QueryError", query, err)
+		}
+	}
+}
+
+func TestQuery_CursorAndTotal(t *testing.T) {
+	idx := newQueryTestIndex(t)
+	var got []string
+	req := Request{Query: "sort:size desc", Limit: 2}
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatal("too many pages")
+		}
+		page, err := idx.Query(req)
+		if err != nil {
+			t.Fatalf("Query: %v", err)
+		}
+		if page.Total != 5 {
+			t.Errorf("total = %d", page.Total)
+		}
+		got = append(got, keys(page.Results))
+		if page.NextCursor == "" {
+			break
+		}
+		req.Cursor = page.NextCursor
+	}
+	if strings.Join(got, "|") != "2026/01/db.log,2026/01/app.log|2025/12/app.log,2026/cover.png|annual-report.pdf" {
+		t.Errorf("pages = %q", got)
+	}
+
+	// Pages in index order resume after the cursor too
+	page, _ := idx.Query(Request{Query: "log", Limit: 2})
+	page, _ = idx.Query(Request{Query: "log", Limit: 2, Cursor: page.NextCursor})
+	if keys(page.Results) != "2026/01/db.log" || page.NextCursor != "" || page.Total != 3 {
+		t.Errorf("second page = %q, total %d", keys(page.Results), page.Total)
+	}
+
+	// A cursor only works for its query
+	if _, err := idx.Query(Request{Query: "app", Cursor: req.Cursor}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("cursor of another query: %v", err)
+	}
+	if _, err := idx.Query(Request{Query: "app", Cursor: "not-a-cursor"}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("bad cursor: %v", err)
+	}
+}
+
+func TestQuery_PrefixAndCappedTotal(t *testing.T) {
+	idx := newQueryTestIndex(t)
+
+	// The request prefix is ANDed with the query
+	page, err := idx.Query(Request{Query: "log", Prefix: "2026/"})
+	if err != nil || keys(page.Results) != "2026/01/app.log,2026/01/db.log" || page.Total != 2 {
+		t.Errorf("prefix query = %q, total %d, %v", keys(page.Results), page.Total, err)
+	}
+	first, _ := idx.Query(Request{Query: "log", Limit: 1})
+	if _, err := idx.Query(Request{Query: "log", Prefix: "2026/", Cursor: first.NextCursor}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("cursor of another prefix: %v", err)
+	}
+
+	// Unsorted queries stop counting matches past the page at the cap
+	defer func(n int) { maxCountedHits = n }(maxCountedHits)
+	maxCountedHits = 2
+	var got []string
+	req := Request{Query: "NOT bucket:none", Limit: 1}
+	for {
+		page, err := idx.Query(req)
+		if err != nil {
+			t.Fatalf("Query: %v", err)
+		}
+		got = append(got, keys(page.Results)+":"+strconv.Itoa(page.Total)+":"+strconv.FormatBool(page.TotalCapped))
+		if page.NextCursor == "" {
+			break
+		}
+		req.Cursor = page.NextCursor
+	}
+	want := "2026/01/app.log:4:true|2025/12/app.log:5:true|2026/01/db.log:5:false|2026/cover.png:5:false|annual-report.pdf:5:false"
+	if strings.Join(got, "|") != want {
+		t.Errorf("pages = %q", got)
+	}
+}
+
+func TestQuery_ContentPhraseAndStopWords(t *testing.T) {
+	idx := newTestIndex(t)
+	idx.Update("docs", "minutes.txt", metadata.ObjectMeta{ETag: "1"})
+	idx.SetContent("docs", "minutes.txt", "1", "The state of the art in storage engines.")
+	idx.Update("docs", "notes.txt", metadata.ObjectMeta{ETag: "1"})
+	idx.SetContent("docs", "notes.txt", "1", "Art of the state.")
+
+	for query, want := range map[string]string{
+		`"state of the art"`: "minutes.txt",
+		`"art of the state"`: "notes.txt",
+		"the engines":        "minutes.txt",
+		"art NOT engine":     "notes.txt",
+	} {
+		results, err := idx.Search(query, "", 10)
+		if err != nil {
+			t.Fatalf("Search(%q): %v", query, err)
+		}
+		if got := keys(results); got != want {
+			t.Errorf("Search(%q) = %q, want %q", query, got, want)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package search
+
+import "strings"
+
+// stopWords are common English words left out of the content index. They
+// occur in nearly every document, so they would only make posting lists
+// long without helping ranking.
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+	"be": true, "but": true, "by": true, "for": true, "if": true, "in": true,
+	"into": true, "is": true, "it": true, "no": true, "not": true, "of": true,
+	"on": true, "or": true, "such": true, "that": true, "the": true,
+	"their": true, "then": true, "there": true, "these": true, "they": true,
+	"this": true, "to": true, "was": true, "will": true, "with": true,
+}
+
+// contentTerms returns the stemmed words of text, without stop words, in
+// order.
+func contentTerms(text string) []string {
+	ws := words(text)
+	terms := ws[:0]
+	for _, w := range ws {
+		if stopWords[w] {
+			continue
+		}
+		terms = append(terms, stem(w))
+	}
+	return terms
+}
+
+// stem reduces an English word to its stem with the Porter algorithm, so
+// that "indexing", "indexed" and "indexes" all match "index". Words that are
+// not lowercase ASCII letters, and words of up to two letters, are returned
+// unchanged.
+func stem(word string) string {
+	if len(word) <= 2 {
+		return word
+	}
+	for i := 0; i < len(word); i++ {
+		if word[i] < 'a' || word[i] > 'z' {
+			return word
+		}
+	}
+	s := &stemmer{b: []byte(word)}
+	s.step1ab()
+	s.step1c()
+	s.step2()
+	s.step3()
+	s.step4()
+	s.step5()
+	return string(s.b)
+}
+
+// stemmer holds a word being stemmed. j marks the end of the stem that the
+// measure and vowel tests look at.
+type stemmer struct {
+	b []byte
+	j int
+}
+
+// cons reports whether b[i] is a consonant.
+func (s *stemmer) cons(i int) bool {
+	switch s.b[i] {
+	case 'a', 'e', 'i', 'o', 'u':
+		return false
+	case 'y':
+		return i == 0 || !s.cons(i-1)
+	}
+	return true
+}
+
+// m returns the number of vowel-consonant sequences in b[:j+1].
+func (s *stemmer) m() int {
+	n, i := 0, 0
+	for ; i <= s.j && s.cons(i); i++ {
+	}
+	for i <= s.j {
+		for ; i <= s.j && !s.cons(i); i++ {
+		}
+		if i > s.j {
+			break
+		}
+		n++
+		for ; i <= s.j && s.cons(i); i++ {
+		}
+	}
+	return n
+}
+
+// vowelInStem reports whether b[:j+1] contains a vowel.
+func (s *stemmer) vowelInStem() bool {
+	for i := 0; i <= s.j; i++ {
+		if !s.cons(i) {
+			return true
+		}
+	}
+	return false
+}
+
+// doublec reports whether b[i-1:i+1] is a double consonant.
+func (s *stemmer) doublec(i int) bool {
+	return i >= 1 && s.b[i] == s.b[i-1] && s.cons(i)
+}
+
+// cvc reports whether b[i-2:i+1] is consonant-vowel-consonant and the last
+// consonant is not w, x or y, as in "hop" but not "snow".
+func (s *stemmer) cvc(i int) bool {
+	if i < 2 || !s.cons(i) || s.cons(i-1) || !s.cons(i-2) {
+		return false
+	}
+	switch s.b[i] {
+	case 'w', 'x', 'y':
+		return false
+	}
+	return true
+}
+
+// ends reports whether the word ends with suffix, setting j to the end of
+// the stem before it.
+func (s *stemmer) ends(suffix string) bool {
+	if !strings.HasSuffix(string(s.b), suffix) {
+		return false
+	}
+	s.j = len(s.b) - len(suffix) - 1
+	return true
+}
+
+// setTo replaces everything after j with r.
+func (s *stemmer) setTo(r string) {
+	s.b = append(s.b[:s.j+1], r...)
+}
+
+// replace replaces the suffix after j with r when the stem has a measure
+// above zero.
+func (s *stemmer) replace(r string) {
+	if s.m() > 0 {
+		s.setTo(r)
+	}
+}
+
+// step1ab removes plurals, -ed and -ing.
+func (s *stemmer) step1ab() {
+	k := len(s.b) - 1
+	if s.b[k] == 's' {
+		switch {
+		case s.ends("sses"):
+			s.b = s.b[:k-1]
+		case s.ends("ies"):
+			s.setTo("i")
+		case k >= 1 && s.b[k-1] != 's':
+			s.b = s.b[:k]
+		}
+	}
+	if s.ends("eed") {
+		if s.m() > 0 {
+			s.b = s.b[:len(s.b)-1]
+		}
+		return
+	}
+	if (s.ends("ed") || s.ends("ing")) && s.vowelInStem() {
+		s.b = s.b[:s.j+1]
+		k = len(s.b) - 1
+		switch {
+		case s.ends("at"):
+			s.setTo("ate")
+		case s.ends("bl"):
+			s.setTo("ble")
+		case s.ends("iz"):
+			s.setTo("ize")
+		case s.doublec(k):
+			switch s.b[k] {
+			case 'l', 's', 'z':
+			default:
+				s.b = s.b[:k]
+			}
+		default:
+			s.j = k
+			if s.m() == 1 && s.cvc(k) {
+				s.b = append(s.b, 'e')
+			}
+		}
+	}
+}
+
+// step1c turns a final y into i when there is another vowel in the stem.
+func (s *stemmer) step1c() {
+	if s.ends("y") && s.vowelInStem() {
+		s.b[len(s.b)-1] = 'i'
+	}
+}
+
+// step2 maps double suffixes to single ones, such as -ization to -ize.
+func (s *stemmer) step2() {
+	if len(s.b) < 3 {
+		return
+	}
+	for _, r := range step2Rules[s.b[len(s.b)-2]] {
+		if s.ends(r[0]) {
+			s.replace(r[1])
+			return
+		}
+	}
+}
+
+var step2Rules = map[byte][][2]string{
+	'a': {{"ational", "ate"}, {"tional", "tion"}},
+	'c': {{"enci", "ence"}, {"anci", "ance"}},
+	'e': {{"izer", "ize"}},
+	'l': {{"bli", "ble"}, {"alli", "al"}, {"entli", "ent"}, {"eli", "e"}, {"ousli", "ous"}},
+	'o': {{"ization", "ize"}, {"ation", "ate"}, {"ator", "ate"}},
+	's': {{"alism", "al"}, {"iveness", "ive"}, {"fulness", "ful"}, {"ousness", "ous"}},
+	't': {{"aliti", "al"}, {"iviti", "ive"}, {"biliti", "ble"}},
+	'g': {{"logi", "log"}},
+}
+
+// step3 handles -ic-, -full, -ness and similar.
+func (s *stemmer) step3() {
+	for _, r := range step3Rules[s.b[len(s.b)-1]] {
+		if s.ends(r[0]) {
+			s.replace(r[1])
+			return
+		}
+	}
+}
+
+var step3Rules = map[byte][][2]string{
+	'e': {{"icate", "ic"}, {"ative", ""}, {"alize", "al"}},
+	'i': {{"iciti", "ic"}},
+	'l': {{"ical", "ic"}, {"ful", ""}},
+	's': {{"ness", ""}},
+}
+
+// step4 removes -ant, -ence and similar suffixes from longer stems.
+func (s *stemmer) step4() {
+	if len(s.b) < 2 {
+		return
+	}
+	for _, suffix := range step4Suffixes[s.b[len(s.b)-2]] {
+		if !s.ends(suffix) {
+			continue
+		}
+		if suffix == "ion" && (s.j < 0 || (s.b[s.j] != 's' && s.b[s.j] != 't')) {
+			return
+		}
+		if s.m() > 1 {
+			s.b = s.b[:s.j+1]
+		}
+		return
+	}
+}
+
+var step4Suffixes = map[byte][]string{
+	'a': {"al"},
+	'c': {"ance", "ence"},
+	'e': {"er"},
+	'i': {"ic"},
+	'l': {"able", "ible"},
+	'n': {"ant", "ement", "ment", "ent"},
+	'o': {"ion", "ou"},
+	's': {"ism"},
+	't': {"ate", "iti"},
+	'u': {"ous"},
+	'v': {"ive"},
+	'z': {"ize"},
+}
+
+// step5 removes a final -e and reduces -ll to -l on longer stems.
+func (s *stemmer) step5() {
+	k := len(s.b) - 1
+	s.j = k
+	if s.b[k] == 'e' {
+		s.j = k - 1
+		if m := s.m(); m > 1 || (m == 1 && !s.cvc(k-1)) {
+			s.b = s.b[:k]
+		}
+	}
+	k = len(s.b) - 1
+	s.j = k
+	if s.b[k] == 'l' && s.doublec(k) && s.m() > 1 {
+		s.b = s.b[:k]
+	}
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/eniz1806/VaultS3/internal/config"
+	"github.com/eniz1806/VaultS3/internal/metadata"
+)
+
+// loadSecretsKey returns the key webhook secrets are encrypted with. Without
+// a configured key, one is generated once and kept in metaDir/secrets.key.
+func loadSecretsKey(cfg config.SecurityConfig, metaDir string) ([]byte, error) {
+	if cfg.SecretsKey != "" {
+		return decodeSecretsKey(cfg.SecretsKey)
+	}
+	path := filepath.Join(metaDir, "secrets.key")
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return decodeSecretsKey(strings.TrimSpace(string(data)))
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("read secrets key: %w", err)
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("generate secrets key: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(key)+"\n"), 0600); err != nil {
+		return nil, fmt.Errorf("write secrets key: %w", err)
+	}
+	return key, nil
+}
+
+func decodeSecretsKey(s string) ([]byte, error) {
+	key, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("secrets key must be hex-encoded: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("secrets key must be 32 bytes (64 hex chars), got %d bytes", len(key))
+	}
+	return key, nil
+}
+
+// webhookAuthFromConfig reads the PEM files named by cfg. It returns nil when
+// cfg configures nothing.
+func webhookAuthFromConfig(cfg config.WebhookAuthConfig) (*metadata.WebhookAuth, error) {
+	auth := &metadata.WebhookAuth{
+		Secret:         cfg.Secret,
+		Headers:        cfg.Headers,
+		BearerTokenRef: cfg.BearerTokenRef,
+	}
+	files := []struct {
+		path string
+		dst  *string
+	}{
+		{cfg.ClientCertFile, &auth.ClientCert},
+		{cfg.ClientKeyFile, &auth.ClientKey},
+		{cfg.CAFile, &auth.CABundle},
+	}
+	for _, f := range files {
+		if f.path == "" {
+			continue
+		}
+		data, err := os.ReadFile(f.path)
+		if err != nil {
+			return nil, err
+		}
+		*f.dst = string(data)
+	}
+	if auth.Secret == "" && len(auth.Headers) == 0 && auth.BearerTokenRef == "" &&
+		auth.ClientCert == "" && auth.ClientKey == "" && auth.CABundle == "" {
+		return nil, nil
+	}
+	return auth, nil
+}
@@ -20,6 +20,7 @@ import (
 	"github.com/eniz1806/VaultS3/internal/accesslog"
 	"github.com/eniz1806/VaultS3/internal/api"
 	"github.com/eniz1806/VaultS3/internal/backup"
+	"github.com/eniz1806/VaultS3/internal/batch"
 	"github.com/eniz1806/VaultS3/internal/cluster"
 	"github.com/eniz1806/VaultS3/internal/config"
 	"github.com/eniz1806/VaultS3/internal/dashboard"
@@ -31,12 +32,14 @@ import (
 	"github.com/eniz1806/VaultS3/internal/middleware"
 	"github.com/eniz1806/VaultS3/internal/notify"
 	"github.com/eniz1806/VaultS3/internal/ratelimit"
+	"github.com/eniz1806/VaultS3/internal/replay"
 	"github.com/eniz1806/VaultS3/internal/replication"
 	"github.com/eniz1806/VaultS3/internal/s3"
 	"github.com/eniz1806/VaultS3/internal/scanner"
 	"github.com/eniz1806/VaultS3/internal/search"
 	"github.com/eniz1806/VaultS3/internal/storage"
 	"github.com/eniz1806/VaultS3/internal/tiering"
+	"github.com/eniz1806/VaultS3/internal/webhook"
 )
 
 type Server struct {
@@ -48,14 +51,18 @@ type Server struct {
 	activity        *api.ActivityLog
 	accessLog       *accesslog.AccessLogger
 	notifyDisp      *notify.Dispatcher
+	replayer        *replay.Replayer
 	replWorker      *replication.Worker
 	biDirWorker     *replication.BiDirectionalWorker
 	searchIndex     *search.Index
+	contentIdx      *search.ContentIndexer
 	scanWorker      *scanner.Scanner
 	tieringMgr      *tiering.Manager
+	restorer        *tiering.Restorer
 	backupSched     *backup.Scheduler
 	rateLimiter     *ratelimit.Limiter
 	lambdaMgr       *lambda.TriggerManager
+	batchProc       *batch.Processor
 	accessUpdater   *metadata.AccessUpdater
 	clusterNode     *cluster.Node
 	clusterProxy    *cluster.Proxy
@@ -144,6 +151,18 @@ func New(cfg *config.Config) (*Server, error) {
 	if err != nil {
 		return nil, fmt.Errorf("init metadata: %w", err)
 	}
+	secretsKey, err := loadSecretsKey(cfg.Security, metaDir)
+	if err == nil {
+		err = store.SetSecretsKey(secretsKey)
+	}
+	if err != nil {
+		store.Close()
+		return nil, fmt.Errorf("init secrets key: %w", err)
+	}
+	if err := webhook.Secrets(cfg.Security.WebhookSecrets).Check(); err != nil {
+		store.Close()
+		return nil, fmt.Errorf("webhook secrets: %w", err)
+	}
 
 	// Initialize erasure healer if EC is enabled
 	if ecEngine != nil {
@@ -328,6 +347,8 @@ func New(cfg *config.Config) (*Server, error) {
 	// Initialize notification dispatcher
 	nc := cfg.Notifications
 	notifyDispatcher := notify.NewDispatcher(store, nc.MaxWorkers, nc.QueueSize, nc.TimeoutSecs, nc.MaxRetries)
+	notifyDispatcher.SetWebhookSecrets(cfg.Security.WebhookSecrets)
+	notifyDispatcher.SetRouteBackends(nc.RouteBackends)
 
 	// Register notification backends
 	if nc.Kafka.Enabled && len(nc.Kafka.Brokers) > 0 && nc.Kafka.Topic != "" {
@@ -355,9 +376,64 @@ func New(cfg *config.Config) (*Server, error) {
 			notifyDispatcher.AddBackend(pgBackend)
 		}
 	}
+	if nc.MQTT.Enabled && nc.MQTT.Broker != "" {
+		mqttBackend, err := notify.NewMQTTBackend(notify.MQTTOptions{
+			Broker:   nc.MQTT.Broker,
+			Topic:    nc.MQTT.Topic,
+			QoS:      nc.MQTT.QoS,
+			Retain:   nc.MQTT.Retain,
+			Username: nc.MQTT.Username,
+			Password: nc.MQTT.Password,
+			ClientID: nc.MQTT.ClientID,
+			CAFile:   nc.MQTT.CAFile,
+		})
+		if err != nil {
+			slog.Warn("MQTT notification backend failed", "error", err)
+		} else {
+			notifyDispatcher.AddBackend(mqttBackend)
+		}
+	}
+	if nc.NSQ.Enabled && nc.NSQ.Addr != "" && nc.NSQ.Topic != "" {
+		notifyDispatcher.AddBackend(notify.NewNSQBackend(nc.NSQ.Addr, nc.NSQ.Topic))
+	}
+	if backends := notifyDispatcher.Backends(); len(backends) > 0 && !nc.RouteBackends {
+		slog.Warn("global notification backends receive every event of buckets with a notification configuration; set notifications.route_backends to route them by ARN",
+			"backends", backends)
+	}
 
-	s3h.SetNotificationFunc(func(eventType, bucket, key string, size int64, etag, versionID string) {
-		notifyDispatcher.Dispatch(bucket, key, eventType, size, etag, versionID)
+	// Register named notification targets
+	for _, t := range nc.Targets {
+		if t.Name == "" {
+			slog.Warn("notification target without a name ignored", "type", t.Type)
+			continue
+		}
+		backend, err := newNotifyTarget(t)
+		if err != nil {
+			slog.Warn("notification target failed", "target", t.Name, "error", err)
+			continue
+		}
+		notifyDispatcher.AddTarget(t.ARN(), backend)
+	}
+	s3h.SetNotificationTargets(notifyDispatcher.TargetARNs())
+
+	// Keep emitted events for replay
+	replayer := replay.NewReplayer(store)
+	replayer.SetTargetFunc(func(e notify.Event, arn, configurationID string) error {
+		e.Region = nc.Region
+		return notifyDispatcher.DispatchTo(e, arn, configurationID)
+	})
+
+	// Persist outbox events in the transaction of the object write
+	s3h.SetNotificationPlanFunc(func(e notify.Event) []metadata.NotificationEvent {
+		e.Region = nc.Region
+		return notifyDispatcher.Plan(e)
+	})
+	s3h.SetNotificationFunc(func(e notify.Event) {
+		e.Region = nc.Region
+		if nc.EventLogDays > 0 {
+			replayer.Record(e)
+		}
+		notifyDispatcher.Dispatch(e)
 	})
 
 	// Initialize replication worker if enabled
@@ -421,28 +497,68 @@ func New(cfg *config.Config) (*Server, error) {
 		}
 	}
 
-	// Build search index
-	searchIdx := search.NewIndex(store, cfg.Memory.MaxSearchEntries)
-	if err := searchIdx.Build(); err != nil {
-		slog.Warn("search index build failed", "error", err)
+	// Open the search index, building it from the metadata store when it is
+	// new or its last build did not finish
+	searchIdx, err := search.Open(filepath.Join(metaDir, "search.db"), store)
+	if err != nil {
+		store.Close()
+		return nil, fmt.Errorf("open search index: %w", err)
 	}
-	s3h.SetSearchUpdateFunc(func(eventType, bucket, key string) {
-		if eventType == "delete" {
-			searchIdx.Remove(bucket, key)
-		} else {
-			if meta, err := store.GetObjectMeta(bucket, key); err == nil {
-				searchIdx.Update(bucket, key, *meta)
+	if searchIdx.NeedsBuild() {
+		go func() {
+			start := time.Now()
+			if err := searchIdx.Build(); err != nil {
+				slog.Warn("search index build failed", "error", err)
+				return
 			}
-		}
-	})
+			slog.Info("search index built", "objects", searchIdx.Count(), "duration", time.Since(start))
+		}()
+	}
+	s3h.SetSearchIndex(searchIdx)
+	contentIdx := search.NewContentIndexer(searchIdx, store, engine,
+		cfg.Search.ContentWorkers, cfg.Search.ContentQueueSize, cfg.Search.ContentMaxSizeBytes)
+	store.SetObjectObserver(search.NewObserver(searchIdx, contentIdx))
 
 	// Initialize scanner if enabled
 	var scanWorker *scanner.Scanner
-	if cfg.Scanner.Enabled && cfg.Scanner.WebhookURL != "" {
+	if cfg.Scanner.Enabled && (cfg.Scanner.WebhookURL != "" || cfg.Scanner.Backend == "clamd") {
 		scanWorker = scanner.NewScanner(store, engine,
 			cfg.Scanner.WebhookURL, cfg.Scanner.Workers,
 			cfg.Scanner.TimeoutSecs, cfg.Scanner.QuarantineBucket,
 			cfg.Scanner.FailClosed, cfg.Scanner.MaxScanSizeBytes, 256)
+		if cfg.Scanner.Backend == "clamd" {
+			clamd, err := scanner.NewClamdBackend(cfg.Scanner.Clamd.Address,
+				time.Duration(cfg.Scanner.TimeoutSecs)*time.Second,
+				cfg.Scanner.Clamd.StreamMaxLength, cfg.Scanner.Clamd.ChunkSize)
+			if err != nil {
+				searchIdx.Close()
+				store.Close()
+				return nil, fmt.Errorf("scanner: %w", err)
+			}
+			scanWorker.SetBackend(clamd)
+		} else {
+			scanAuth, err := webhookAuthFromConfig(cfg.Scanner.Auth)
+			if err == nil {
+				err = webhook.Validate(scanAuth, cfg.Security.WebhookSecrets)
+			}
+			if err != nil {
+				searchIdx.Close()
+				store.Close()
+				return nil, fmt.Errorf("scanner auth: %w", err)
+			}
+			scanWorker.SetWebhookAuth(scanAuth, cfg.Security.WebhookSecrets)
+		}
+		var schedules []scanner.RescanSchedule
+		for _, sc := range cfg.Scanner.RescanSchedules {
+			schedules = append(schedules, scanner.RescanSchedule{
+				Bucket:        sc.Bucket,
+				Prefix:        sc.Prefix,
+				Interval:      time.Duration(sc.IntervalHours) * time.Hour,
+				ObjectsPerSec: sc.ObjectsPerSec,
+				SkipCurrent:   sc.SkipCurrent,
+			})
+		}
+		scanWorker.SetRescanDefaults(cfg.Scanner.RescanObjectsPerSec, schedules)
 		s3h.SetScanFunc(func(bucket, key string, size int64) {
 			scanWorker.Scan(bucket, key, size)
 		})
@@ -450,15 +566,43 @@ func New(cfg *config.Config) (*Server, error) {
 
 	// Initialize tiering if enabled
 	var tieringMgr *tiering.Manager
+	var restorer *tiering.Restorer
 	if cfg.Tiering.Enabled && cfg.Tiering.ColdDataDir != "" {
 		coldFS, err := storage.NewFileSystem(cfg.Tiering.ColdDataDir)
 		if err != nil {
+			searchIdx.Close()
 			store.Close()
 			return nil, fmt.Errorf("init cold storage: %w", err)
 		}
 		tieringMgr = tiering.NewManager(store, fs, coldFS, cfg.Tiering.MigrateAfterDays, cfg.Tiering.ScanIntervalSecs)
+		if err := tieringMgr.SetStorageClasses(cfg.Tiering.StorageClasses); err != nil {
+			searchIdx.Close()
+			store.Close()
+			return nil, fmt.Errorf("init tiering: %w", err)
+		}
 		slog.Info("tiering enabled", "cold_dir", cfg.Tiering.ColdDataDir, "migrate_after_days", cfg.Tiering.MigrateAfterDays)
+
+		restorer = tiering.NewRestorer(store, fs, coldFS,
+			cfg.Tiering.RestoreExpeditedSecs, cfg.Tiering.RestoreStandardSecs, cfg.Tiering.RestoreBulkSecs)
+		if cfg.Tiering.Remote.Enabled {
+			remote := tiering.NewRemoteTier(tiering.RemoteTierConfig{
+				Endpoint:  cfg.Tiering.Remote.Endpoint,
+				Bucket:    cfg.Tiering.Remote.Bucket,
+				AccessKey: cfg.Tiering.Remote.AccessKey,
+				SecretKey: cfg.Tiering.Remote.SecretKey,
+				Region:    cfg.Tiering.Remote.Region,
+				UseSSL:    cfg.Tiering.Remote.UseSSL,
+			})
+			tieringMgr.SetRemoteTier(remote)
+			restorer.SetRemoteTier(remote)
+			slog.Info("remote tier enabled", "endpoint", cfg.Tiering.Remote.Endpoint, "bucket", cfg.Tiering.Remote.Bucket)
+		}
+		s3h.SetRestoreFunc(restorer.Request)
+		s3h.SetRemoveFunc(tieringMgr.RemoveObject)
+		restorer.SetAccessTierFunc(tieringMgr.RecordAccessTier)
+		mc.SetTieringManager(tieringMgr)
 	}
+	mc.SetNotifyDispatcher(notifyDispatcher)
 
 	// Initialize backup scheduler if enabled
 	var backupSched *backup.Scheduler
@@ -484,20 +628,48 @@ func New(cfg *config.Config) (*Server, error) {
 	var lambdaMgr *lambda.TriggerManager
 	if cfg.Lambda.Enabled {
 		lambdaMgr = lambda.NewTriggerManager(store, engine, cfg.Lambda)
-		s3h.SetLambdaFunc(func(eventType, bucket, key string, size int64, etag, versionID string) {
-			lambdaMgr.Dispatch(bucket, key, eventType, size, etag, versionID)
+		lambdaMgr.SetWebhookSecrets(cfg.Security.WebhookSecrets)
+		var commands []string
+		for _, c := range cfg.Lambda.Commands {
+			commands = append(commands, c.Name)
+		}
+		s3h.SetLambdaCommands(commands)
+		s3h.SetLambdaFunc(func(e notify.Event) {
+			e.Region = nc.Region
+			lambdaMgr.Dispatch(e)
 		})
-		slog.Info("lambda triggers enabled", "workers", cfg.Lambda.MaxWorkers, "queue_size", cfg.Lambda.QueueSize)
+		replayer.SetTriggerFunc(func(triggerID string, e notify.Event) error {
+			e.Region = nc.Region
+			return lambdaMgr.InvokeEvent(triggerID, e)
+		})
+		slog.Info("lambda triggers enabled", "workers", cfg.Lambda.MaxWorkers, "queue_size", cfg.Lambda.QueueSize, "max_retries", cfg.Lambda.MaxRetries)
+	}
+
+	// Initialize batch operations
+	batchProc := batch.NewProcessor(store, engine)
+	batchProc.SetQuotaFunc(s3h.CheckQuota)
+	if scanWorker != nil {
+		batchProc.SetScanFunc(scanWorker.Scan)
+	}
+	if tieringMgr != nil {
+		batchProc.SetTierMover(tieringMgr)
+		batchProc.SetRestoreFunc(restorer.Request)
+	}
+	if lambdaMgr != nil {
+		batchProc.SetInvokeFunc(lambdaMgr.Invoke)
 	}
 
 	// Initialize batched access updater
 	accessUpdater := metadata.NewAccessUpdater(store, 30*time.Second)
 	s3h.SetAccessUpdater(accessUpdater)
+	if tieringMgr != nil {
+		accessUpdater.SetAccessTierFunc(tieringMgr.RecordAccessTier)
+	}
 
 	// Initialize built-in IAM policies
 	initBuiltinPolicies(store)
 
-	return &Server{
+	s := &Server{
 		cfg:             cfg,
 		store:           store,
 		engine:          engine,
@@ -506,14 +678,18 @@ func New(cfg *config.Config) (*Server, error) {
 		activity:        activityLog,
 		accessLog:       accessLogger,
 		notifyDisp:      notifyDispatcher,
+		replayer:        replayer,
 		replWorker:      replWorker,
 		biDirWorker:     biDirWorker,
 		searchIndex:     searchIdx,
+		contentIdx:      contentIdx,
 		scanWorker:      scanWorker,
 		tieringMgr:      tieringMgr,
+		restorer:        restorer,
 		backupSched:     backupSched,
 		rateLimiter:     rateLimiter,
 		lambdaMgr:       lambdaMgr,
+		batchProc:       batchProc,
 		accessUpdater:   accessUpdater,
 		clusterNode:     clusterNode,
 		clusterProxy:    clusterProxy,
@@ -522,7 +698,53 @@ func New(cfg *config.Config) (*Server, error) {
 		rebalancer:      rebalancer,
 		ecHealer:        ecHealer,
 		s3Auth:          auth,
-	}, nil
+	}
+
+	// Report restores, replication results and batch job changes as S3 events
+	batchProc.SetEventFunc(s.systemEvent)
+	if restorer != nil {
+		restorer.SetEventFunc(s.systemEvent)
+	}
+	if replWorker != nil {
+		replWorker.SetEventFunc(s.systemEvent)
+	}
+	return s, nil
+}
+
+// publishEvent delivers an S3 event to the notification dispatcher and the
+// lambda triggers.
+func (s *Server) publishEvent(e notify.Event) {
+	e.Region = s.cfg.Notifications.Region
+	if s.cfg.Notifications.EventLogDays > 0 {
+		s.replayer.Record(e)
+	}
+	s.notifyDisp.Dispatch(e)
+	if s.lambdaMgr != nil {
+		s.lambdaMgr.Dispatch(e)
+	}
+}
+
+// systemEvent publishes an event raised by a background worker rather than a
+// request. Restore events carry the restored copy's expiry and storage class.
+func (s *Server) systemEvent(eventType, bucket, key string, size int64, etag, versionID string) {
+	e := notify.Event{
+		Name:        eventType,
+		Bucket:      bucket,
+		Key:         key,
+		Size:        size,
+		ETag:        etag,
+		VersionID:   versionID,
+		PrincipalID: notify.SystemPrincipal,
+	}
+	if strings.HasPrefix(eventType, "s3:ObjectRestore:") {
+		if meta, err := s.store.GetObjectMeta(bucket, key); err == nil {
+			e.RestoreStorageClass = meta.StorageClass
+			if meta.RestoreExpiry > 0 {
+				e.RestoreExpiry = time.Unix(meta.RestoreExpiry, 0)
+			}
+		}
+	}
+	s.publishEvent(e)
 }
 
 // Run starts the server and blocks until shutdown signal is received.
@@ -534,6 +756,10 @@ func (s *Server) Run() error {
 	apiHandler := api.NewAPIHandler(s.store, s.engine, s.metrics, s.cfg, s.activity)
 	apiHandler.SetS3Authenticator(s.s3Auth)
 	apiHandler.SetSearchIndex(s.searchIndex)
+	apiHandler.SetContentIndexer(s.contentIdx)
+	apiHandler.SetBatchProcessor(s.batchProc)
+	apiHandler.SetNotifyDispatcher(s.notifyDisp)
+	apiHandler.SetReplayer(s.replayer)
 	if s.scanWorker != nil {
 		apiHandler.SetScanner(s.scanWorker)
 	}
@@ -655,9 +881,25 @@ func (s *Server) Run() error {
 	lcCtx, lcCancel := context.WithCancel(context.Background())
 	defer lcCancel()
 	lcWorker := lifecycle.NewWorker(s.store, s.engine, s.cfg.Lifecycle.ScanIntervalSecs, s.cfg.Security.AuditRetentionDays)
+	if s.tieringMgr != nil {
+		lcWorker.SetTierMover(s.tieringMgr)
+	}
+	lcWorker.SetEventFunc(s.systemEvent)
+	lcWorker.SetEventLogRetention(s.cfg.Notifications.EventLogDays)
+	lcWorker.SetLambdaHistoryRetention(s.cfg.Lambda.HistoryDays)
 	go lcWorker.Run(lcCtx)
 	slog.Info("lifecycle worker started", "interval_secs", s.cfg.Lifecycle.ScanIntervalSecs)
 
+	// Start the event log writer
+	replayCtx, replayCancel := context.WithCancel(context.Background())
+	defer replayCancel()
+	go s.replayer.Run(replayCtx)
+
+	// Start batch job processor
+	batchCtx, batchCancel := context.WithCancel(context.Background())
+	defer batchCancel()
+	go s.batchProc.Run(batchCtx)
+
 	// Start notification dispatcher
 	notifyCtx, notifyCancel := context.WithCancel(context.Background())
 	defer notifyCancel()
@@ -678,6 +920,12 @@ func (s *Server) Run() error {
 		go s.biDirWorker.Run(biDirCtx)
 	}
 
+	// Start content indexing workers; they also catch up on content-indexed
+	// buckets
+	contentCtx, contentCancel := context.WithCancel(context.Background())
+	defer contentCancel()
+	s.contentIdx.Start(contentCtx)
+
 	// Start scanner workers if enabled
 	if s.scanWorker != nil {
 		scanCtx, scanCancel := context.WithCancel(context.Background())
@@ -690,6 +938,9 @@ func (s *Server) Run() error {
 		tierCtx, tierCancel := context.WithCancel(context.Background())
 		defer tierCancel()
 		go s.tieringMgr.Run(tierCtx)
+		if s.restorer != nil {
+			go s.restorer.Run(tierCtx)
+		}
 	}
 
 	// Start lambda trigger manager if enabled
@@ -721,7 +972,7 @@ func (s *Server) Run() error {
 		go s.backupSched.Run(backupCtx)
 	}
 
-	slog.Info("search index ready", "objects", s.searchIndex.Count())
+	slog.Info("search index ready", "objects", s.searchIndex.Count(), "building", s.searchIndex.Building())
 
 	// Start separate inter-node listener if configured
 	var interNodeServer *http.Server
@@ -788,11 +1039,11 @@ func initBuiltinPolicies(store *metadata.Store) {
 	builtins := []metadata.IAMPolicy{
 		{
 			Name:     "ReadOnlyAccess",
-			Document: `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":["s3:GetObject","s3:ListBucket","s3:ListAllMyBuckets","s3:GetBucketPolicy"],"Resource":["*"]}]}`,
+			Document: `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":["s3:GetObject","s3:ListBucket","s3:SearchBucket","s3:ListAllMyBuckets","s3:GetBucketPolicy"],"Resource":["*"]}]}`,
 		},
 		{
 			Name:     "ReadWriteAccess",
-			Document: `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":["s3:GetObject","s3:PutObject","s3:DeleteObject","s3:ListBucket","s3:ListAllMyBuckets"],"Resource":["*"]}]}`,
+			Document: `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":["s3:GetObject","s3:PutObject","s3:DeleteObject","s3:ListBucket","s3:SearchBucket","s3:ListAllMyBuckets"],"Resource":["*"]}]}`,
 		},
 		{
 			Name:     "FullAccess",
@@ -807,6 +1058,62 @@ func initBuiltinPolicies(store *metadata.Store) {
 	}
 }
 
+// newNotifyTarget creates the backend of a named notification target.
+func newNotifyTarget(t config.NotifyTargetConfig) (notify.Backend, error) {
+	switch t.Type {
+	case "kafka":
+		if len(t.Brokers) == 0 || t.Topic == "" {
+			return nil, fmt.Errorf("kafka target needs brokers and a topic")
+		}
+		return notify.NewKafkaBackend(t.Brokers, t.Topic), nil
+	case "nats":
+		if t.URL == "" || t.Subject == "" {
+			return nil, fmt.Errorf("nats target needs a url and a subject")
+		}
+		return notify.NewNATSBackend(t.URL, t.Subject)
+	case "redis":
+		if t.Addr == "" {
+			return nil, fmt.Errorf("redis target needs an addr")
+		}
+		return notify.NewRedisBackend(t.Addr, t.Channel, t.ListKey), nil
+	case "amqp":
+		if t.URL == "" {
+			return nil, fmt.Errorf("amqp target needs a url")
+		}
+		return notify.NewAMQPBackend(t.URL, t.Exchange, t.RoutingKey), nil
+	case "postgres":
+		if t.ConnStr == "" {
+			return nil, fmt.Errorf("postgres target needs a conn_str")
+		}
+		return notify.NewPostgresBackend(t.ConnStr, t.Table)
+	case "elasticsearch":
+		if t.URL == "" {
+			return nil, fmt.Errorf("elasticsearch target needs a url")
+		}
+		return notify.NewElasticsearchBackend(t.URL, t.Index), nil
+	case "mqtt":
+		if t.URL == "" {
+			return nil, fmt.Errorf("mqtt target needs a url")
+		}
+		return notify.NewMQTTBackend(notify.MQTTOptions{
+			Broker:   t.URL,
+			Topic:    t.Topic,
+			QoS:      t.QoS,
+			Retain:   t.Retain,
+			Username: t.Username,
+			Password: t.Password,
+			ClientID: t.ClientID,
+			CAFile:   t.CAFile,
+		})
+	case "nsq":
+		if t.Addr == "" || t.Topic == "" {
+			return nil, fmt.Errorf("nsq target needs an addr and a topic")
+		}
+		return notify.NewNSQBackend(t.Addr, t.Topic), nil
+	}
+	return nil, fmt.Errorf("unknown notification target type %q", t.Type)
+}
+
 // validateExternalURL checks that a URL does not point to internal/metadata endpoints (SSRF prevention).
 func validateExternalURL(rawURL string) error {
 	u, err := url.Parse(rawURL)
@@ -841,15 +1148,27 @@ func (s *Server) Close() {
 	if s.clusterNode != nil {
 		s.clusterNode.Shutdown()
 	}
+	if s.replayer != nil {
+		s.replayer.Stop()
+	}
 	if s.lambdaMgr != nil {
 		s.lambdaMgr.Stop()
 	}
 	if s.rateLimiter != nil {
 		s.rateLimiter.Stop()
 	}
+	if s.notifyDisp != nil {
+		s.notifyDisp.Stop()
+	}
 	if s.accessLog != nil {
 		s.accessLog.Close()
 	}
+	if s.contentIdx != nil {
+		s.contentIdx.Stop()
+	}
+	if s.searchIndex != nil {
+		s.searchIndex.Close()
+	}
 	if s.store != nil {
 		s.store.Close()
 	}
@@ -0,0 +1,188 @@
+package tiering
+
+import (
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/eniz1806/VaultS3/internal/metadata"
+)
+
+// IntelligentTieringClass is the storage class whose objects move between
+// access tiers based on how recently they were read.
+const IntelligentTieringClass = "INTELLIGENT_TIERING"
+
+// Intelligent-Tiering access tiers, from most to least frequently accessed.
+const (
+	AccessTierFrequent       = "FREQUENT_ACCESS"
+	AccessTierInfrequent     = "INFREQUENT_ACCESS"
+	AccessTierArchiveInstant = "ARCHIVE_INSTANT_ACCESS"
+	AccessTierArchive        = "ARCHIVE_ACCESS"
+	AccessTierDeepArchive    = "DEEP_ARCHIVE_ACCESS"
+)
+
+// Days without access after which objects move into the automatic access
+// tiers. The archive tiers are opt-in per bucket configuration.
+const (
+	infrequentAccessDays     = 30
+	archiveInstantAccessDays = 90
+)
+
+var accessTierRank = map[string]int{
+	AccessTierFrequent:       0,
+	AccessTierInfrequent:     1,
+	AccessTierArchiveInstant: 2,
+	AccessTierArchive:        3,
+	AccessTierDeepArchive:    4,
+}
+
+// archiveAccessClasses maps the archive access tiers to the storage class
+// whose tier holds their data.
+var archiveAccessClasses = map[string]string{
+	AccessTierArchive:     "GLACIER",
+	AccessTierDeepArchive: "DEEP_ARCHIVE",
+}
+
+// AccessTierOf returns the access tier of an INTELLIGENT_TIERING object.
+func AccessTierOf(meta *metadata.ObjectMeta) string {
+	if meta.AccessTier == "" {
+		return AccessTierFrequent
+	}
+	return meta.AccessTier
+}
+
+// matchIntelligentTiering checks an object against a configuration's filter.
+func matchIntelligentTiering(cfg *metadata.IntelligentTieringConfig, meta *metadata.ObjectMeta) bool {
+	if cfg.Status != "Enabled" {
+		return false
+	}
+	if cfg.Prefix != "" && !strings.HasPrefix(meta.Key, cfg.Prefix) {
+		return false
+	}
+	for k, v := range cfg.TagFilter {
+		if meta.Tags[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// targetAccessTier returns the coldest access tier an object qualifies for
+// given how long it has gone without access.
+func targetAccessTier(meta *metadata.ObjectMeta, cfgs []metadata.IntelligentTieringConfig, now int64) string {
+	lastAccess := meta.LastModified
+	if meta.LastAccessTime > lastAccess {
+		lastAccess = meta.LastAccessTime
+	}
+	idle := now - lastAccess
+
+	target := AccessTierFrequent
+	switch {
+	case idle >= archiveInstantAccessDays*86400:
+		target = AccessTierArchiveInstant
+	case idle >= infrequentAccessDays*86400:
+		target = AccessTierInfrequent
+	}
+	for i := range cfgs {
+		if !matchIntelligentTiering(&cfgs[i], meta) {
+			continue
+		}
+		for _, t := range cfgs[i].Tierings {
+			if idle >= int64(t.Days)*86400 && accessTierRank[t.AccessTier] > accessTierRank[target] {
+				target = t.AccessTier
+			}
+		}
+	}
+	return target
+}
+
+// RecordAccessTier counts an object of bucket moving into accessTier.
+func (m *Manager) RecordAccessTier(bucket, accessTier string) {
+	m.statsMu.Lock()
+	defer m.statsMu.Unlock()
+	if m.transitions[bucket] == nil {
+		m.transitions[bucket] = make(map[string]int64)
+	}
+	m.transitions[bucket][accessTier]++
+}
+
+// scanIntelligentTiering moves INTELLIGENT_TIERING objects down the access
+// tiers once they have gone without access long enough. Moving back up
+// happens on access or, for the archive tiers, on restore.
+func (m *Manager) scanIntelligentTiering() {
+	now := time.Now().Unix()
+	buckets, err := m.store.ListBuckets()
+	if err != nil {
+		slog.Error("intelligent-tiering error listing buckets", "error", err)
+		return
+	}
+	cfgs := make(map[string][]metadata.IntelligentTieringConfig, len(buckets))
+	for _, b := range buckets {
+		cfgs[b.Name], _ = m.store.ListIntelligentTieringConfigs(b.Name)
+	}
+
+	type move struct {
+		meta metadata.ObjectMeta
+		to   string
+	}
+	var moves []move
+	m.store.IterateAllObjects(func(bucket, key string, meta metadata.ObjectMeta) bool {
+		if meta.DeleteMarker || meta.StorageClass != IntelligentTieringClass || meta.RestoreOngoing {
+			return true
+		}
+		if to := targetAccessTier(&meta, cfgs[bucket], now); accessTierRank[to] > accessTierRank[AccessTierOf(&meta)] {
+			moves = append(moves, move{meta: meta, to: to})
+		}
+		return true
+	})
+
+	moved := 0
+	for _, mv := range moves {
+		if err := m.moveAccessTier(mv.meta, mv.to); err != nil {
+			slog.Error("intelligent-tiering move failed", "bucket", mv.meta.Bucket, "key", mv.meta.Key,
+				"access_tier", mv.to, "error", err)
+			continue
+		}
+		m.RecordAccessTier(mv.meta.Bucket, mv.to)
+		moved++
+	}
+	if moved > 0 {
+		slog.Info("intelligent-tiering moved objects", "count", moved)
+	}
+}
+
+// moveAccessTier moves an INTELLIGENT_TIERING object into another access
+// tier. The archive tiers move data to the tier backing GLACIER or
+// DEEP_ARCHIVE; the others only change metadata.
+func (m *Manager) moveAccessTier(meta metadata.ObjectMeta, accessTier string) error {
+	from := meta.Tier
+	if from == "" {
+		from = TierHot
+	}
+	to := TierHot
+	if class, ok := archiveAccessClasses[accessTier]; ok {
+		to = m.storageClasses[class]
+		if to == TierRemote && m.remote == nil {
+			to = TierCold
+		}
+	}
+
+	if from != to {
+		if err := m.tiers().copy(from, to, &meta); err != nil {
+			return err
+		}
+	}
+	stored := accessTier
+	if stored == AccessTierFrequent {
+		stored = ""
+	}
+	if err := m.store.SetObjectAccessTier(meta.Bucket, meta.Key, meta.VersionID, to, stored); err != nil {
+		return err
+	}
+	if from != to {
+		if err := m.tiers().remove(from, &meta); err != nil {
+			slog.Error("intelligent-tiering failed to delete source copy", "bucket", meta.Bucket, "key", meta.Key, "tier", from, "error", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package tiering
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/eniz1806/VaultS3/internal/metadata"
+)
+
+func TestManager_IntelligentTiering(t *testing.T) {
+	store, hot, cold := newRestoreTestEnv(t)
+	store.CreateBucket("data")
+	hot.CreateBucketDir("data")
+	now := time.Now().Unix()
+	day := int64(86400)
+
+	put := func(key string, idleDays int64, class string) {
+		hot.PutObject("data", key, bytes.NewReader([]byte(key)), int64(len(key)))
+		store.PutObjectMeta(metadata.ObjectMeta{
+			Bucket: "data", Key: key, Size: int64(len(key)), StorageClass: class,
+			LastModified: now - 400*day, LastAccessTime: now - idleDays*day,
+		})
+	}
+	put("hot.txt", 1, IntelligentTieringClass)
+	put("warm.txt", 40, IntelligentTieringClass)
+	put("cool.txt", 100, IntelligentTieringClass)
+	put("archive/old.txt", 100, IntelligentTieringClass)
+	put("archive/ancient.txt", 200, IntelligentTieringClass)
+	put("standard.txt", 200, "")
+
+	store.PutIntelligentTieringConfig("data", metadata.IntelligentTieringConfig{
+		ID: "archive", Status: "Enabled", Prefix: "archive/",
+		Tierings: []metadata.IntelligentTiering{
+			{AccessTier: AccessTierArchive, Days: 90},
+			{AccessTier: AccessTierDeepArchive, Days: 180},
+		},
+	})
+
+	m := NewManager(store, hot, cold, 30, 3600)
+	m.scanIntelligentTiering()
+
+	want := map[string]struct{ access, tier string }{
+		"hot.txt":             {AccessTierFrequent, TierHot},
+		"warm.txt":            {AccessTierInfrequent, TierHot},
+		"cool.txt":            {AccessTierArchiveInstant, TierHot},
+		"archive/old.txt":     {AccessTierArchive, TierCold},
+		"archive/ancient.txt": {AccessTierDeepArchive, TierCold},
+		"standard.txt":        {AccessTierFrequent, ""},
+	}
+	for key, w := range want {
+		meta, _ := store.GetObjectMeta("data", key)
+		tier := meta.Tier
+		if tier == "" && w.tier == TierHot {
+			tier = TierHot
+		}
+		if AccessTierOf(meta) != w.access || tier != w.tier {
+			t.Errorf("%s: got access=%s tier=%q, want %s/%q", key, AccessTierOf(meta), meta.Tier, w.access, w.tier)
+		}
+	}
+	if !cold.ObjectExists("data", "archive/old.txt") || hot.ObjectExists("data", "archive/old.txt") {
+		t.Error("archived object data should move to the cold tier")
+	}
+
+	stats := m.BucketStats()["data"]
+	if stats == nil {
+		t.Fatal("no stats for bucket")
+	}
+	if stats.AccessTiers[AccessTierArchive].Count != 1 || stats.Tiers[TierCold].Count != 2 {
+		t.Errorf("distribution: %+v", stats)
+	}
+	if stats.Transitions[AccessTierInfrequent] != 1 || stats.Transitions[AccessTierDeepArchive] != 1 {
+		t.Errorf("transitions: %+v", stats.Transitions)
+	}
+
+	// Restoring an archived object moves it back to frequent access for good
+	r := NewRestorer(store, hot, cold, 0, 0, 0)
+	r.SetAccessTierFunc(m.RecordAccessTier)
+	if _, err := r.Request("data", "archive/old.txt", "", RestoreTierExpedited, 1); err != nil {
+		t.Fatalf("Request: %v", err)
+	}
+	r.process()
+
+	meta, _ := store.GetObjectMeta("data", "archive/old.txt")
+	if meta.Tier != TierHot || AccessTierOf(meta) != AccessTierFrequent || meta.RestoreExpiry != 0 || meta.RestoreOngoing {
+		t.Errorf("after restore: %+v", meta)
+	}
+	if cold.ObjectExists("data", "archive/old.txt") || !hot.ObjectExists("data", "archive/old.txt") {
+		t.Error("restored object data should be back on the hot tier only")
+	}
+	if _, err := store.GetRestoreRequest("data", "archive/old.txt", ""); err == nil {
+		t.Error("restore request should be removed")
+	}
+	if n := m.BucketStats()["data"].Transitions[AccessTierFrequent]; n != 1 {
+		t.Errorf("frequent access transitions: got %d, want 1", n)
+	}
+
+	// A fresh scan leaves the restored object alone
+	m.scanIntelligentTiering()
+	if meta, _ := store.GetObjectMeta("data", "archive/old.txt"); AccessTierOf(meta) != AccessTierFrequent {
+		t.Errorf("restored object moved again to %s", AccessTierOf(meta))
+	}
+}
+
+func TestAccessUpdater_ResetsAccessTier(t *testing.T) {
+	store, _, _ := newRestoreTestEnv(t)
+	store.CreateBucket("data")
+	store.PutObjectMeta(metadata.ObjectMeta{Bucket: "data", Key: "a", StorageClass: IntelligentTieringClass, AccessTier: AccessTierInfrequent})
+	store.PutObjectMeta(metadata.ObjectMeta{Bucket: "data", Key: "b", StorageClass: IntelligentTieringClass, AccessTier: AccessTierArchive, Tier: TierCold})
+
+	var moved []string
+	u := metadata.NewAccessUpdater(store, time.Minute)
+	u.SetAccessTierFunc(func(bucket, accessTier string) { moved = append(moved, bucket+":"+accessTier) })
+	u.MarkAccess("data", "a")
+	u.MarkAccess("data", "b")
+	u.Flush()
+
+	if meta, _ := store.GetObjectMeta("data", "a"); meta.AccessTier != "" || meta.LastAccessTime == 0 {
+		t.Errorf("a: expected frequent access after read, got %+v", meta)
+	}
+	if meta, _ := store.GetObjectMeta("data", "b"); meta.AccessTier != AccessTierArchive {
+		t.Errorf("b: archived objects need a restore, got %q", meta.AccessTier)
+	}
+	if len(moved) != 1 || moved[0] != "data:"+AccessTierFrequent {
+		t.Errorf("callbacks: %v", moved)
+	}
+}
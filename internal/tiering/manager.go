@@ -2,8 +2,10 @@ package tiering
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"log/slog"
+	"sync"
 	"time"
 
 	"github.com/eniz1806/VaultS3/internal/metadata"
@@ -14,8 +16,13 @@ type Manager struct {
 	store            *metadata.Store
 	hotEngine        storage.Engine
 	coldEngine       storage.Engine
+	remote           *RemoteTier
+	storageClasses   map[string]string
 	migrateAfterDays int
 	scanIntervalSecs int
+
+	statsMu     sync.Mutex
+	transitions map[string]map[string]int64 // bucket -> access tier -> moves
 }
 
 func NewManager(store *metadata.Store, hotEngine, coldEngine storage.Engine, migrateAfterDays, scanIntervalSecs int) *Manager {
@@ -23,11 +30,87 @@ func NewManager(store *metadata.Store, hotEngine, coldEngine storage.Engine, mig
 		store:            store,
 		hotEngine:        hotEngine,
 		coldEngine:       coldEngine,
+		storageClasses:   DefaultStorageClasses,
 		migrateAfterDays: migrateAfterDays,
 		scanIntervalSecs: scanIntervalSecs,
+		transitions:      make(map[string]map[string]int64),
 	}
 }
 
+// SetRemoteTier enables the remote tier as a storage class target.
+func (m *Manager) SetRemoteTier(remote *RemoteTier) {
+	m.remote = remote
+}
+
+// SetStorageClasses overrides the tier used for individual storage classes.
+func (m *Manager) SetStorageClasses(classes map[string]string) error {
+	merged := make(map[string]string, len(DefaultStorageClasses)+len(classes))
+	for class, tier := range DefaultStorageClasses {
+		merged[class] = tier
+	}
+	for class, tier := range classes {
+		if !validTier(tier) {
+			return fmt.Errorf("storage class %s: unknown tier %q", class, tier)
+		}
+		merged[class] = tier
+	}
+	m.storageClasses = merged
+	return nil
+}
+
+func (m *Manager) tiers() tierSet {
+	return tierSet{hot: m.hotEngine, cold: m.coldEngine, remote: m.remote}
+}
+
+// Transition moves an object or object version into the tier backing
+// storageClass and records the new class in its metadata.
+func (m *Manager) Transition(meta metadata.ObjectMeta, storageClass string) error {
+	tier, ok := m.storageClasses[storageClass]
+	if !ok {
+		return fmt.Errorf("unknown storage class: %s", storageClass)
+	}
+	if tier == TierRemote && m.remote == nil {
+		return fmt.Errorf("storage class %s requires a remote tier", storageClass)
+	}
+
+	from := meta.Tier
+	if from == "" {
+		from = TierHot
+	}
+	if from != tier {
+		if err := m.tiers().copy(from, tier, &meta); err != nil {
+			return err
+		}
+	}
+	if err := m.store.SetObjectStorageClass(meta.Bucket, meta.Key, meta.VersionID, tier, storageClass); err != nil {
+		return err
+	}
+	if from != tier {
+		// Only drop the source copy once metadata points at the new tier
+		if err := m.tiers().remove(from, &meta); err != nil {
+			slog.Error("tiering failed to delete source copy", "bucket", meta.Bucket, "key", meta.Key, "tier", from, "error", err)
+		}
+	}
+	return nil
+}
+
+// RemoveObject deletes the data of an object or object version that was moved
+// out of the hot tier, together with any restored copy and pending restore
+// request. Objects still on the hot tier are left to the caller's engine.
+func (m *Manager) RemoveObject(meta metadata.ObjectMeta) error {
+	if meta.Tier == "" || meta.Tier == TierHot {
+		return nil
+	}
+	tiers := m.tiers()
+	if err := tiers.remove(meta.Tier, &meta); err != nil {
+		return err
+	}
+	if err := tiers.remove(TierHot, &meta); err != nil {
+		slog.Error("tiering failed to delete restored copy", "bucket", meta.Bucket, "key", meta.Key, "error", err)
+	}
+	return m.store.DeleteRestoreRequest(meta.Bucket, meta.Key, meta.VersionID)
+}
+
 func (m *Manager) Run(ctx context.Context) {
 	ticker := time.NewTicker(time.Duration(m.scanIntervalSecs) * time.Second)
 	defer ticker.Stop()
@@ -43,11 +126,13 @@ func (m *Manager) Run(ctx context.Context) {
 }
 
 func (m *Manager) scan() {
+	m.scanIntelligentTiering()
+
 	cutoff := time.Now().Unix() - int64(m.migrateAfterDays*86400)
 	migrated := 0
 
 	m.store.IterateAllObjects(func(bucket, key string, meta metadata.ObjectMeta) bool {
-		if meta.DeleteMarker {
+		if meta.DeleteMarker || meta.StorageClass == IntelligentTieringClass {
 			return true
 		}
 		tier := meta.Tier
@@ -113,6 +198,8 @@ func (m *Manager) MigrateToHot(bucket, key string) error {
 		slog.Error("tiering failed to delete cold copy", "bucket", bucket, "key", key, "error", err)
 	}
 
+	// A pending or completed restore no longer applies once the object is hot
+	m.store.DeleteRestoreRequest(bucket, key, "")
 	return m.store.SetObjectTier(bucket, key, "hot")
 }
 
@@ -163,34 +250,103 @@ func (m *Manager) GetObject(bucket, key string) (storage.ReadSeekCloser, int64,
 	return reader, size, nil
 }
 
-// Status returns hot/cold counts and sizes.
-func (m *Manager) Status() map[string]interface{} {
-	var hotCount, coldCount int64
-	var hotSize, coldSize int64
+// TierUsage is the number and total size of objects in a tier.
+type TierUsage struct {
+	Count int64 `json:"count"`
+	Size  int64 `json:"size"`
+}
+
+// BucketTierStats is a bucket's object distribution across storage tiers and
+// Intelligent-Tiering access tiers, with access tier moves since startup.
+type BucketTierStats struct {
+	Tiers       map[string]TierUsage `json:"tiers"`
+	AccessTiers map[string]TierUsage `json:"access_tiers,omitempty"`
+	Transitions map[string]int64     `json:"transitions,omitempty"`
+}
+
+// BucketStats returns the tier distribution of every bucket holding objects.
+func (m *Manager) BucketStats() map[string]*BucketTierStats {
+	stats := make(map[string]*BucketTierStats)
+	get := func(bucket string) *BucketTierStats {
+		bs, ok := stats[bucket]
+		if !ok {
+			bs = &BucketTierStats{Tiers: make(map[string]TierUsage)}
+			stats[bucket] = bs
+		}
+		return bs
+	}
 
 	m.store.IterateAllObjects(func(bucket, key string, meta metadata.ObjectMeta) bool {
 		if meta.DeleteMarker {
 			return true
 		}
+		bs := get(bucket)
 		tier := meta.Tier
-		if tier == "" || tier == "hot" {
-			hotCount++
-			hotSize += meta.Size
-		} else {
-			coldCount++
-			coldSize += meta.Size
+		if tier == "" {
+			tier = TierHot
+		}
+		u := bs.Tiers[tier]
+		u.Count++
+		u.Size += meta.Size
+		bs.Tiers[tier] = u
+
+		if meta.StorageClass == IntelligentTieringClass {
+			if bs.AccessTiers == nil {
+				bs.AccessTiers = make(map[string]TierUsage)
+			}
+			at := AccessTierOf(&meta)
+			u := bs.AccessTiers[at]
+			u.Count++
+			u.Size += meta.Size
+			bs.AccessTiers[at] = u
 		}
 		return true
 	})
 
+	m.statsMu.Lock()
+	for bucket, counts := range m.transitions {
+		bs := get(bucket)
+		bs.Transitions = make(map[string]int64, len(counts))
+		for tier, n := range counts {
+			bs.Transitions[tier] = n
+		}
+	}
+	m.statsMu.Unlock()
+	return stats
+}
+
+// Status returns hot/cold counts and sizes, overall and per bucket.
+func (m *Manager) Status() map[string]interface{} {
+	buckets := m.BucketStats()
+	var hot, cold, remote TierUsage
+	for _, bs := range buckets {
+		for tier, u := range bs.Tiers {
+			switch tier {
+			case TierHot:
+				hot.Count += u.Count
+				hot.Size += u.Size
+			case TierRemote:
+				remote.Count += u.Count
+				remote.Size += u.Size
+			default:
+				cold.Count += u.Count
+				cold.Size += u.Size
+			}
+		}
+	}
+
 	return map[string]interface{}{
 		"enabled":            true,
-		"hot_count":          hotCount,
-		"hot_size":           hotSize,
-		"cold_count":         coldCount,
-		"cold_size":          coldSize,
+		"hot_count":          hot.Count,
+		"hot_size":           hot.Size,
+		"cold_count":         cold.Count,
+		"cold_size":          cold.Size,
+		"remote_count":       remote.Count,
+		"remote_size":        remote.Size,
+		"remote_enabled":     m.remote != nil,
 		"migrate_after_days": m.migrateAfterDays,
 		"scan_interval_secs": m.scanIntervalSecs,
+		"buckets":            buckets,
 	}
 }
 
@@ -0,0 +1,305 @@
+package tiering
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/eniz1806/VaultS3/internal/metadata"
+	"github.com/eniz1806/VaultS3/internal/storage"
+)
+
+// Restore tiers, in scheduling priority order.
+const (
+	RestoreTierExpedited = "Expedited"
+	RestoreTierStandard  = "Standard"
+	RestoreTierBulk      = "Bulk"
+)
+
+var restoreTierPriority = map[string]int{
+	RestoreTierExpedited: 0,
+	RestoreTierStandard:  1,
+	RestoreTierBulk:      2,
+}
+
+// ValidRestoreTier reports whether tier is a supported restore tier.
+func ValidRestoreTier(tier string) bool {
+	_, ok := restoreTierPriority[tier]
+	return ok
+}
+
+var (
+	// ErrNotArchived is returned for restores of objects in the hot tier.
+	ErrNotArchived = errors.New("object is not archived")
+	// ErrRestoreInProgress is returned for restores of objects that already
+	// have a restore queued or running.
+	ErrRestoreInProgress = errors.New("restore already in progress")
+)
+
+// EventFunc is called when a restore completes or a restored copy expires.
+type EventFunc func(eventType, bucket, key string, size int64, etag, versionID string)
+
+// Restorer runs Glacier-style restores: it copies archived objects from the
+// cold or remote tier into a temporary hot copy and removes that copy once it
+// expires.
+type Restorer struct {
+	store      *metadata.Store
+	hotEngine  storage.Engine
+	coldEngine storage.Engine
+	remote     *RemoteTier
+	delays     map[string]time.Duration
+	interval   time.Duration
+	wake       chan struct{}
+	onEvent    EventFunc
+	onAccess   func(bucket, accessTier string)
+}
+
+// NewRestorer creates a restorer. The per-tier delays control how long a
+// request waits before it is scheduled.
+func NewRestorer(store *metadata.Store, hotEngine, coldEngine storage.Engine, expeditedSecs, standardSecs, bulkSecs int) *Restorer {
+	return &Restorer{
+		store:      store,
+		hotEngine:  hotEngine,
+		coldEngine: coldEngine,
+		delays: map[string]time.Duration{
+			RestoreTierExpedited: time.Duration(expeditedSecs) * time.Second,
+			RestoreTierStandard:  time.Duration(standardSecs) * time.Second,
+			RestoreTierBulk:      time.Duration(bulkSecs) * time.Second,
+		},
+		interval: 10 * time.Second,
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// SetRemoteTier allows restoring objects archived in the remote tier.
+func (r *Restorer) SetRemoteTier(remote *RemoteTier) {
+	r.remote = remote
+}
+
+// SetEventFunc sets the callback for restore completion and expiry events.
+func (r *Restorer) SetEventFunc(fn EventFunc) {
+	r.onEvent = fn
+}
+
+// SetAccessTierFunc sets the callback for INTELLIGENT_TIERING objects a
+// restore moved back to the frequent access tier.
+func (r *Restorer) SetAccessTierFunc(fn func(bucket, accessTier string)) {
+	r.onAccess = fn
+}
+
+// Request queues a restore of an archived object version; an empty
+// versionID means the current version. If the version already has a restored
+// copy, its expiry is extended instead and queued is false.
+func (r *Restorer) Request(bucket, key, versionID, tier string, days int) (queued bool, err error) {
+	if tier == "" {
+		tier = RestoreTierStandard
+	}
+	if !ValidRestoreTier(tier) {
+		return false, fmt.Errorf("invalid restore tier: %s", tier)
+	}
+	if days <= 0 {
+		days = 1
+	}
+	meta, err := r.objectMeta(bucket, key, versionID)
+	if err != nil {
+		return false, err
+	}
+	if meta.Tier == "" || meta.Tier == TierHot {
+		return false, ErrNotArchived
+	}
+	versionID = meta.VersionID
+	now := time.Now().UTC()
+
+	if existing, err := r.store.GetRestoreRequest(bucket, key, versionID); err == nil {
+		if existing.ExpiresAt == 0 {
+			return false, ErrRestoreInProgress
+		}
+		expiry := restoreExpiry(now, days)
+		existing.Days = days
+		existing.ExpiresAt = expiry
+		if err := r.store.PutRestoreRequest(*existing); err != nil {
+			return false, err
+		}
+		return false, r.store.SetObjectRestoreStatus(bucket, key, versionID, false, expiry)
+	}
+
+	req := metadata.RestoreRequest{
+		Bucket:      bucket,
+		Key:         key,
+		VersionID:   versionID,
+		Tier:        tier,
+		Days:        days,
+		RequestedAt: now.Unix(),
+		ReadyAt:     now.Add(r.delays[tier]).Unix(),
+	}
+	if err := r.store.PutRestoreRequest(req); err != nil {
+		return false, err
+	}
+	if err := r.store.SetObjectRestoreStatus(bucket, key, versionID, true, 0); err != nil {
+		r.store.DeleteRestoreRequest(bucket, key, versionID)
+		return false, err
+	}
+
+	if r.delays[tier] == 0 {
+		select {
+		case r.wake <- struct{}{}:
+		default:
+		}
+	}
+	return true, nil
+}
+
+// Run processes queued restores and expires restored copies until ctx is cancelled.
+func (r *Restorer) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	r.process()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.process()
+		case <-r.wake:
+			r.process()
+		}
+	}
+}
+
+func (r *Restorer) process() {
+	reqs, err := r.store.ListRestoreRequests()
+	if err != nil {
+		slog.Error("restore error listing requests", "error", err)
+		return
+	}
+	now := time.Now().UTC().Unix()
+
+	var ready []metadata.RestoreRequest
+	for _, req := range reqs {
+		if req.ExpiresAt > 0 {
+			if req.ExpiresAt <= now {
+				r.expire(req)
+			}
+			continue
+		}
+		if req.ReadyAt <= now {
+			ready = append(ready, req)
+		}
+	}
+
+	// Higher priority tiers first, then oldest request first
+	sort.Slice(ready, func(i, j int) bool {
+		pi, pj := restoreTierPriority[ready[i].Tier], restoreTierPriority[ready[j].Tier]
+		if pi != pj {
+			return pi < pj
+		}
+		return ready[i].RequestedAt < ready[j].RequestedAt
+	})
+	for _, req := range ready {
+		if err := r.restore(req); err != nil {
+			slog.Error("restore failed", "bucket", req.Bucket, "key", req.Key, "tier", req.Tier, "error", err)
+		}
+	}
+}
+
+// objectMeta returns the metadata of an object version, or of the current
+// version when versionID is empty.
+func (r *Restorer) objectMeta(bucket, key, versionID string) (*metadata.ObjectMeta, error) {
+	var meta *metadata.ObjectMeta
+	var err error
+	if versionID != "" {
+		meta, err = r.store.GetObjectVersion(bucket, key, versionID)
+	} else {
+		meta, err = r.store.GetObjectMeta(bucket, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if meta.DeleteMarker {
+		return nil, fmt.Errorf("object not found: %s/%s", bucket, key)
+	}
+	return meta, nil
+}
+
+func (r *Restorer) restore(req metadata.RestoreRequest) error {
+	meta, err := r.objectMeta(req.Bucket, req.Key, req.VersionID)
+	if err != nil || meta.Tier == "" || meta.Tier == TierHot {
+		// Object deleted or promoted in the meantime — nothing to restore
+		return r.store.DeleteRestoreRequest(req.Bucket, req.Key, req.VersionID)
+	}
+
+	tiers := tierSet{hot: r.hotEngine, cold: r.coldEngine, remote: r.remote}
+	if err := tiers.copy(meta.Tier, TierHot, meta); err != nil {
+		return err
+	}
+	if meta.StorageClass == IntelligentTieringClass {
+		return r.restoreIntelligentTiering(req, meta)
+	}
+
+	req.ExpiresAt = restoreExpiry(time.Now().UTC(), req.Days)
+	if err := r.store.PutRestoreRequest(req); err != nil {
+		return err
+	}
+	if err := r.store.SetObjectRestoreStatus(req.Bucket, req.Key, meta.VersionID, false, req.ExpiresAt); err != nil {
+		return err
+	}
+
+	slog.Info("restore completed", "bucket", req.Bucket, "key", req.Key, "tier", req.Tier, "days", req.Days)
+	if r.onEvent != nil {
+		r.onEvent("s3:ObjectRestore:Completed", req.Bucket, req.Key, meta.Size, meta.ETag, meta.VersionID)
+	}
+	return nil
+}
+
+// restoreIntelligentTiering completes the restore of an archived
+// INTELLIGENT_TIERING object. Unlike other archive classes the object moves
+// back to frequent access for good instead of getting a temporary copy.
+func (r *Restorer) restoreIntelligentTiering(req metadata.RestoreRequest, meta *metadata.ObjectMeta) error {
+	from := meta.Tier
+	if err := r.store.SetObjectAccessTier(req.Bucket, req.Key, meta.VersionID, TierHot, ""); err != nil {
+		return err
+	}
+	tiers := tierSet{hot: r.hotEngine, cold: r.coldEngine, remote: r.remote}
+	if err := tiers.remove(from, meta); err != nil {
+		slog.Error("restore failed to delete archived copy", "bucket", req.Bucket, "key", req.Key, "tier", from, "error", err)
+	}
+	if err := r.store.DeleteRestoreRequest(req.Bucket, req.Key, req.VersionID); err != nil {
+		return err
+	}
+
+	slog.Info("restore completed", "bucket", req.Bucket, "key", req.Key, "tier", req.Tier, "access_tier", AccessTierFrequent)
+	if r.onAccess != nil {
+		r.onAccess(req.Bucket, AccessTierFrequent)
+	}
+	if r.onEvent != nil {
+		r.onEvent("s3:ObjectRestore:Completed", req.Bucket, req.Key, meta.Size, meta.ETag, meta.VersionID)
+	}
+	return nil
+}
+
+func (r *Restorer) expire(req metadata.RestoreRequest) {
+	meta, err := r.objectMeta(req.Bucket, req.Key, req.VersionID)
+	if err == nil && meta.Tier != "" && meta.Tier != TierHot {
+		tiers := tierSet{hot: r.hotEngine, cold: r.coldEngine, remote: r.remote}
+		if err := tiers.remove(TierHot, meta); err != nil {
+			slog.Error("restore failed to remove expired copy", "bucket", req.Bucket, "key", req.Key, "error", err)
+			return
+		}
+		r.store.SetObjectRestoreStatus(req.Bucket, req.Key, meta.VersionID, false, 0)
+		if r.onEvent != nil {
+			r.onEvent("s3:ObjectRestore:Delete", req.Bucket, req.Key, meta.Size, meta.ETag, meta.VersionID)
+		}
+	}
+	r.store.DeleteRestoreRequest(req.Bucket, req.Key, req.VersionID)
+}
+
+// restoreExpiry returns when a copy restored at now for the given number of
+// days expires: like S3, the time is rounded up to the next midnight UTC.
+func restoreExpiry(now time.Time, days int) int64 {
+	t := now.UTC().AddDate(0, 0, days)
+	return time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC).Unix()
+}
@@ -0,0 +1,214 @@
+package tiering
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/eniz1806/VaultS3/internal/metadata"
+	"github.com/eniz1806/VaultS3/internal/storage"
+)
+
+func newRestoreTestEnv(t *testing.T) (*metadata.Store, *storage.FileSystem, *storage.FileSystem) {
+	t.Helper()
+	dir := t.TempDir()
+	store, err := metadata.NewStore(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	hot, err := storage.NewFileSystem(filepath.Join(dir, "hot"))
+	if err != nil {
+		t.Fatalf("NewFileSystem: %v", err)
+	}
+	cold, err := storage.NewFileSystem(filepath.Join(dir, "cold"))
+	if err != nil {
+		t.Fatalf("NewFileSystem: %v", err)
+	}
+	return store, hot, cold
+}
+
+func putColdObject(t *testing.T, store *metadata.Store, cold *storage.FileSystem, bucket, key string, data []byte) {
+	t.Helper()
+	cold.CreateBucketDir(bucket)
+	if _, _, err := cold.PutObject(bucket, key, bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatalf("PutObject: %v", err)
+	}
+	store.CreateBucket(bucket)
+	store.PutObjectMeta(metadata.ObjectMeta{Bucket: bucket, Key: key, Size: int64(len(data)), Tier: "cold"})
+}
+
+func TestRestoreExpiry(t *testing.T) {
+	now := time.Date(2026, 3, 10, 15, 30, 0, 0, time.UTC)
+	got := time.Unix(restoreExpiry(now, 2), 0).UTC()
+	want := time.Date(2026, 3, 13, 0, 0, 0, 0, time.UTC)
+	if !got.Equal(want) {
+		t.Errorf("restoreExpiry: got %v, want %v", got, want)
+	}
+}
+
+func TestRestorer_RestoreAndExtend(t *testing.T) {
+	store, hot, cold := newRestoreTestEnv(t)
+	putColdObject(t, store, cold, "archive", "doc.txt", []byte("archived data"))
+
+	var events []string
+	r := NewRestorer(store, hot, cold, 0, 0, 0)
+	r.SetEventFunc(func(eventType, bucket, key string, size int64, etag, versionID string) {
+		events = append(events, eventType)
+	})
+
+	queued, err := r.Request("archive", "doc.txt", "", RestoreTierExpedited, 1)
+	if err != nil || !queued {
+		t.Fatalf("Request: queued=%v err=%v", queued, err)
+	}
+	if meta, _ := store.GetObjectMeta("archive", "doc.txt"); !meta.RestoreOngoing {
+		t.Error("expected restore to be ongoing after request")
+	}
+	if _, err := r.Request("archive", "doc.txt", "", RestoreTierBulk, 1); !errors.Is(err, ErrRestoreInProgress) {
+		t.Errorf("duplicate in-progress restore: got %v, want ErrRestoreInProgress", err)
+	}
+
+	r.process()
+
+	meta, _ := store.GetObjectMeta("archive", "doc.txt")
+	if meta.RestoreOngoing || meta.RestoreExpiry == 0 {
+		t.Fatalf("restore not completed: ongoing=%v expiry=%d", meta.RestoreOngoing, meta.RestoreExpiry)
+	}
+	reader, _, err := hot.GetObject("archive", "doc.txt")
+	if err != nil {
+		t.Fatalf("restored copy missing: %v", err)
+	}
+	data, _ := io.ReadAll(reader)
+	reader.Close()
+	if string(data) != "archived data" {
+		t.Errorf("restored data: got %q", data)
+	}
+	if len(events) != 1 || events[0] != "s3:ObjectRestore:Completed" {
+		t.Errorf("events: got %v", events)
+	}
+
+	// Restoring again extends the existing copy instead of queueing
+	queued, err = r.Request("archive", "doc.txt", "", RestoreTierStandard, 5)
+	if err != nil || queued {
+		t.Fatalf("extend: queued=%v err=%v", queued, err)
+	}
+	extended, _ := store.GetObjectMeta("archive", "doc.txt")
+	if extended.RestoreExpiry <= meta.RestoreExpiry {
+		t.Errorf("expected expiry to be extended: %d <= %d", extended.RestoreExpiry, meta.RestoreExpiry)
+	}
+}
+
+func TestRestorer_ExpiresCopy(t *testing.T) {
+	store, hot, cold := newRestoreTestEnv(t)
+	putColdObject(t, store, cold, "archive", "old.bin", []byte("bits"))
+	hot.CreateBucketDir("archive")
+	hot.PutObject("archive", "old.bin", bytes.NewReader([]byte("bits")), 4)
+
+	past := time.Now().Add(-time.Hour).Unix()
+	store.PutRestoreRequest(metadata.RestoreRequest{Bucket: "archive", Key: "old.bin", Tier: RestoreTierStandard, Days: 1, ExpiresAt: past})
+	store.SetObjectRestoreStatus("archive", "old.bin", "", false, past)
+
+	r := NewRestorer(store, hot, cold, 0, 0, 0)
+	r.process()
+
+	if hot.ObjectExists("archive", "old.bin") {
+		t.Error("expected expired restored copy to be removed")
+	}
+	if !cold.ObjectExists("archive", "old.bin") {
+		t.Error("archived copy must be kept")
+	}
+	if meta, _ := store.GetObjectMeta("archive", "old.bin"); meta.RestoreExpiry != 0 {
+		t.Errorf("expected restore expiry cleared, got %d", meta.RestoreExpiry)
+	}
+	if _, err := store.GetRestoreRequest("archive", "old.bin", ""); err == nil {
+		t.Error("expected restore request to be removed")
+	}
+}
+
+func TestRestorer_TierPriority(t *testing.T) {
+	store, hot, cold := newRestoreTestEnv(t)
+	putColdObject(t, store, cold, "archive", "bulk", []byte("b"))
+	putColdObject(t, store, cold, "archive", "expedited", []byte("e"))
+
+	var order []string
+	r := NewRestorer(store, hot, cold, 0, 0, 0)
+	r.SetEventFunc(func(eventType, bucket, key string, size int64, etag, versionID string) {
+		order = append(order, key)
+	})
+	r.Request("archive", "bulk", "", RestoreTierBulk, 1)
+	r.Request("archive", "expedited", "", RestoreTierExpedited, 1)
+	r.process()
+
+	if len(order) != 2 || order[0] != "expedited" {
+		t.Errorf("expected expedited restore first, got %v", order)
+	}
+}
+
+func TestRestorer_RejectsHotObject(t *testing.T) {
+	store, hot, cold := newRestoreTestEnv(t)
+	store.CreateBucket("data")
+	store.PutObjectMeta(metadata.ObjectMeta{Bucket: "data", Key: "hot.txt", Size: 3})
+
+	r := NewRestorer(store, hot, cold, 0, 0, 0)
+	if _, err := r.Request("data", "hot.txt", "", RestoreTierStandard, 1); !errors.Is(err, ErrNotArchived) {
+		t.Fatalf("got %v, want ErrNotArchived", err)
+	}
+	if meta, _ := store.GetObjectMeta("data", "hot.txt"); meta.RestoreOngoing {
+		t.Error("hot object must not be marked as restoring")
+	}
+	if reqs, _ := store.ListRestoreRequests(); len(reqs) != 0 {
+		t.Errorf("expected no restore requests, got %d", len(reqs))
+	}
+}
+
+func TestRestorer_RestoresVersion(t *testing.T) {
+	store, hot, cold := newRestoreTestEnv(t)
+	store.CreateBucket("archive")
+	cold.CreateBucketDir("archive")
+	for _, v := range []string{"v1", "v2"} {
+		if _, _, err := cold.PutObjectVersion("archive", "doc.txt", v, bytes.NewReader([]byte(v+"!")), 3); err != nil {
+			t.Fatalf("PutObjectVersion: %v", err)
+		}
+	}
+	store.PutObjectVersion(metadata.ObjectMeta{Bucket: "archive", Key: "doc.txt", VersionID: "v1", Size: 3, Tier: "cold"})
+	current := metadata.ObjectMeta{Bucket: "archive", Key: "doc.txt", VersionID: "v2", Size: 3, Tier: "cold", IsLatest: true}
+	store.PutObjectVersion(current)
+	store.PutObjectMeta(current)
+
+	r := NewRestorer(store, hot, cold, 0, 0, 0)
+	if queued, err := r.Request("archive", "doc.txt", "v1", RestoreTierExpedited, 1); err != nil || !queued {
+		t.Fatalf("Request v1: queued=%v err=%v", queued, err)
+	}
+	// The current version is a different object and can be restored too
+	if queued, err := r.Request("archive", "doc.txt", "", RestoreTierExpedited, 1); err != nil || !queued {
+		t.Fatalf("Request current: queued=%v err=%v", queued, err)
+	}
+	if _, err := store.GetRestoreRequest("archive", "doc.txt", "v1"); err != nil {
+		t.Errorf("expected restore request for v1: %v", err)
+	}
+
+	r.process()
+
+	if !hotVersionExists(hot, "archive", "doc.txt", "v1") {
+		t.Fatal("expected restored copy of v1")
+	}
+	v1, _ := store.GetObjectVersion("archive", "doc.txt", "v1")
+	if v1.RestoreOngoing || v1.RestoreExpiry == 0 {
+		t.Errorf("v1 restore not completed: ongoing=%v expiry=%d", v1.RestoreOngoing, v1.RestoreExpiry)
+	}
+	if meta, _ := store.GetObjectMeta("archive", "doc.txt"); meta.RestoreOngoing || meta.RestoreExpiry == 0 {
+		t.Errorf("current version restore not completed: ongoing=%v expiry=%d", meta.RestoreOngoing, meta.RestoreExpiry)
+	}
+}
+
+func hotVersionExists(hot *storage.FileSystem, bucket, key, versionID string) bool {
+	reader, _, err := hot.GetObjectVersion(bucket, key, versionID)
+	if err != nil {
+		return false
+	}
+	reader.Close()
+	return true
+}
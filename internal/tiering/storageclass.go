@@ -0,0 +1,123 @@
+package tiering
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/eniz1806/VaultS3/internal/metadata"
+	"github.com/eniz1806/VaultS3/internal/storage"
+)
+
+// Storage tiers object data can live in.
+const (
+	TierHot    = "hot"
+	TierCold   = "cold"
+	TierRemote = "remote"
+)
+
+// DefaultStorageClasses maps S3 storage classes to the tier holding their data.
+// Infrequent-access classes stay on the hot filesystem; archive classes need a
+// restore before they can be read.
+var DefaultStorageClasses = map[string]string{
+	"STANDARD":            TierHot,
+	"STANDARD_IA":         TierHot,
+	"ONEZONE_IA":          TierHot,
+	"INTELLIGENT_TIERING": TierHot,
+	"GLACIER_IR":          TierHot,
+	"GLACIER":             TierCold,
+	"DEEP_ARCHIVE":        TierCold,
+}
+
+func validTier(tier string) bool {
+	return tier == TierHot || tier == TierCold || tier == TierRemote
+}
+
+// tierSet gives uniform access to object data across the hot, cold and remote
+// tiers. Versioned objects are addressed by their version ID.
+type tierSet struct {
+	hot    storage.Engine
+	cold   storage.Engine
+	remote *RemoteTier
+}
+
+func (t tierSet) engine(tier string) (storage.Engine, error) {
+	switch tier {
+	case "", TierHot:
+		return t.hot, nil
+	case TierCold:
+		return t.cold, nil
+	}
+	return nil, fmt.Errorf("tier %q has no local engine", tier)
+}
+
+func (t tierSet) open(tier string, meta *metadata.ObjectMeta) (io.ReadCloser, int64, error) {
+	if tier == TierRemote {
+		if t.remote == nil {
+			return nil, 0, fmt.Errorf("remote tier not configured")
+		}
+		return t.remote.Download(remoteKey(meta))
+	}
+	engine, err := t.engine(tier)
+	if err != nil {
+		return nil, 0, err
+	}
+	if meta.VersionID != "" {
+		return engine.GetObjectVersion(meta.Bucket, meta.Key, meta.VersionID)
+	}
+	return engine.GetObject(meta.Bucket, meta.Key)
+}
+
+func (t tierSet) write(tier string, meta *metadata.ObjectMeta, reader io.Reader, size int64) error {
+	if tier == TierRemote {
+		if t.remote == nil {
+			return fmt.Errorf("remote tier not configured")
+		}
+		return t.remote.Upload(remoteKey(meta), reader, size)
+	}
+	engine, err := t.engine(tier)
+	if err != nil {
+		return err
+	}
+	engine.CreateBucketDir(meta.Bucket)
+	if meta.VersionID != "" {
+		_, _, err = engine.PutObjectVersion(meta.Bucket, meta.Key, meta.VersionID, reader, size)
+	} else {
+		_, _, err = engine.PutObject(meta.Bucket, meta.Key, reader, size)
+	}
+	return err
+}
+
+func (t tierSet) remove(tier string, meta *metadata.ObjectMeta) error {
+	if tier == TierRemote {
+		if t.remote == nil {
+			return fmt.Errorf("remote tier not configured")
+		}
+		return t.remote.Delete(remoteKey(meta))
+	}
+	engine, err := t.engine(tier)
+	if err != nil {
+		return err
+	}
+	if meta.VersionID != "" {
+		return engine.DeleteObjectVersion(meta.Bucket, meta.Key, meta.VersionID)
+	}
+	return engine.DeleteObject(meta.Bucket, meta.Key)
+}
+
+// copy copies an object's data from one tier to another.
+func (t tierSet) copy(from, to string, meta *metadata.ObjectMeta) error {
+	reader, size, err := t.open(from, meta)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	return t.write(to, meta, reader, size)
+}
+
+// remoteKey is the object key used in the remote tier bucket.
+func remoteKey(meta *metadata.ObjectMeta) string {
+	if meta.VersionID != "" {
+		return meta.Bucket + "/" + meta.Key + ".v-" + meta.VersionID
+	}
+	return meta.Bucket + "/" + meta.Key
+}
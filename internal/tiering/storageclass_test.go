@@ -0,0 +1,73 @@
+package tiering
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/eniz1806/VaultS3/internal/metadata"
+)
+
+func TestManager_TransitionVersion(t *testing.T) {
+	store, hot, cold := newRestoreTestEnv(t)
+	store.CreateBucket("docs")
+	hot.CreateBucketDir("docs")
+	hot.PutObjectVersion("docs", "a.txt", "v1", bytes.NewReader([]byte("version one")), 11)
+
+	meta := metadata.ObjectMeta{Bucket: "docs", Key: "a.txt", VersionID: "v1", Size: 11, IsLatest: true}
+	store.PutObjectVersion(meta)
+	store.PutObjectMeta(meta)
+
+	m := NewManager(store, hot, cold, 30, 3600)
+
+	// Infrequent access stays on the hot filesystem
+	if err := m.Transition(meta, "STANDARD_IA"); err != nil {
+		t.Fatalf("Transition STANDARD_IA: %v", err)
+	}
+	latest, _ := store.GetObjectMeta("docs", "a.txt")
+	if latest.Tier != TierHot || latest.EffectiveStorageClass() != "STANDARD_IA" {
+		t.Errorf("after STANDARD_IA: tier=%q class=%q", latest.Tier, latest.EffectiveStorageClass())
+	}
+
+	if err := m.Transition(*latest, "GLACIER"); err != nil {
+		t.Fatalf("Transition GLACIER: %v", err)
+	}
+	version, _ := store.GetObjectVersion("docs", "a.txt", "v1")
+	latest, _ = store.GetObjectMeta("docs", "a.txt")
+	for _, got := range []*metadata.ObjectMeta{version, latest} {
+		if got.Tier != TierCold || got.StorageClass != "GLACIER" {
+			t.Errorf("after GLACIER: tier=%q class=%q", got.Tier, got.StorageClass)
+		}
+	}
+	if _, _, err := hot.GetObjectVersion("docs", "a.txt", "v1"); err == nil {
+		t.Error("hot copy should be removed after transition")
+	}
+	reader, _, err := cold.GetObjectVersion("docs", "a.txt", "v1")
+	if err != nil {
+		t.Fatalf("cold copy missing: %v", err)
+	}
+	data, _ := io.ReadAll(reader)
+	reader.Close()
+	if string(data) != "version one" {
+		t.Errorf("cold data: got %q", data)
+	}
+}
+
+func TestManager_StorageClassMapping(t *testing.T) {
+	store, hot, cold := newRestoreTestEnv(t)
+	m := NewManager(store, hot, cold, 30, 3600)
+
+	if err := m.SetStorageClasses(map[string]string{"DEEP_ARCHIVE": "tape"}); err == nil {
+		t.Error("expected error for unknown tier")
+	}
+	if err := m.SetStorageClasses(map[string]string{"DEEP_ARCHIVE": TierRemote}); err != nil {
+		t.Fatalf("SetStorageClasses: %v", err)
+	}
+	meta := metadata.ObjectMeta{Bucket: "docs", Key: "b.txt"}
+	if err := m.Transition(meta, "DEEP_ARCHIVE"); err == nil {
+		t.Error("expected error when the remote tier is not configured")
+	}
+	if err := m.Transition(meta, "UNKNOWN"); err == nil {
+		t.Error("expected error for unknown storage class")
+	}
+}
@@ -0,0 +1,58 @@
+package versioning
+
+import (
+	"bytes"
+	"io"
+)
+
+// diffBlocks compares two versions block by block at the same offsets and
+// records the ranges of blocks that differ, merging adjacent ones. Bytes
+// past the end of the shorter version count as changed. Only one block of
+// each version is held in memory.
+func diffBlocks(result *DiffResult, a, b io.Reader, blockSize int) error {
+	bufA := make([]byte, blockSize)
+	bufB := make([]byte, blockSize)
+	stats := &DiffStats{}
+	result.BlockSize = blockSize
+	result.Stats = stats
+
+	var offset int64
+	var open *ByteRange // range of the previous block, when it changed
+	for {
+		nA, errA := io.ReadFull(a, bufA)
+		nB, errB := io.ReadFull(b, bufB)
+		if errA != nil && errA != io.EOF && errA != io.ErrUnexpectedEOF {
+			return errA
+		}
+		if errB != nil && errB != io.EOF && errB != io.ErrUnexpectedEOF {
+			return errB
+		}
+		n := max(nA, nB)
+		if n == 0 {
+			return nil
+		}
+
+		if nA != nB || !bytes.Equal(bufA[:nA], bufB[:nB]) {
+			switch {
+			case nA == 0:
+				stats.Added++
+			case nB == 0:
+				stats.Removed++
+			default:
+				stats.Changed++
+			}
+			stats.Bytes += int64(n)
+			if open != nil {
+				open.Length += int64(n)
+			} else if len(result.Blocks) < maxChanges {
+				result.Blocks = append(result.Blocks, ByteRange{Offset: offset, Length: int64(n)})
+				open = &result.Blocks[len(result.Blocks)-1]
+			} else {
+				result.Truncated = true
+			}
+		} else {
+			open = nil
+		}
+		offset += int64(n)
+	}
+}
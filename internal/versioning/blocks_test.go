@@ -0,0 +1,56 @@
+package versioning
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDiffBlocks(t *testing.T) {
+	a := bytes.Repeat([]byte{0}, 100)
+	b := bytes.Clone(a)
+	b[5] = 1  // block 0
+	b[15] = 1 // block 1, adjacent
+	b[45] = 1 // block 4
+	b = append(b, 1, 2, 3)
+
+	result := &DiffResult{}
+	if err := diffBlocks(result, bytes.NewReader(a), bytes.NewReader(b), 10); err != nil {
+		t.Fatalf("diffBlocks: %v", err)
+	}
+	want := []ByteRange{{Offset: 0, Length: 20}, {Offset: 40, Length: 10}, {Offset: 100, Length: 3}}
+	if len(result.Blocks) != len(want) {
+		t.Fatalf("blocks = %+v", result.Blocks)
+	}
+	for i := range want {
+		if result.Blocks[i] != want[i] {
+			t.Errorf("block %d = %+v, want %+v", i, result.Blocks[i], want[i])
+		}
+	}
+	if s := result.Stats; s.Changed != 3 || s.Added != 1 || s.Removed != 0 || s.Bytes != 33 {
+		t.Errorf("stats = %+v", s)
+	}
+}
+
+func TestDiffBlocks_Identical(t *testing.T) {
+	data := strings.Repeat("same data ", 1000)
+	result := &DiffResult{}
+	diffBlocks(result, strings.NewReader(data), strings.NewReader(data), DefaultBlockSize)
+	if len(result.Blocks) != 0 || result.Stats.Bytes != 0 || result.Truncated {
+		t.Errorf("result = %+v", result)
+	}
+}
+
+func TestDiffBlocks_Truncated(t *testing.T) {
+	// Every other block differs, more ranges than are listed
+	a := make([]byte, 2*(maxChanges+10))
+	b := bytes.Clone(a)
+	for i := 0; i < len(b); i += 2 {
+		b[i] = 1
+	}
+	result := &DiffResult{}
+	diffBlocks(result, bytes.NewReader(a), bytes.NewReader(b), 1)
+	if len(result.Blocks) != maxChanges || !result.Truncated || result.Stats.Changed != maxChanges+10 {
+		t.Errorf("%d blocks, truncated %v, stats %+v", len(result.Blocks), result.Truncated, result.Stats)
+	}
+}
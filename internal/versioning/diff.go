@@ -2,30 +2,146 @@ package versioning
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
+	"path"
 	"strings"
 
 	"github.com/eniz1806/VaultS3/internal/metadata"
 	"github.com/eniz1806/VaultS3/internal/storage"
 )
 
+// Diff modes. The empty mode diffs the lines of text types and only the
+// metadata of other objects.
+const (
+	ModeAuto   = "auto"   // pick a mode from the content type and key
+	ModeText   = "text"   // line diff
+	ModeJSON   = "json"   // tree diff of JSON documents
+	ModeYAML   = "yaml"   // tree diff of YAML documents
+	ModeCSV    = "csv"    // row and column diff keyed by a column
+	ModeBinary = "binary" // changed byte ranges, compared block by block
+)
+
+const (
+	// DefaultMaxSize is the largest version parsed by structured diffs.
+	// Text diffs only read this much of each version.
+	DefaultMaxSize = 10 << 20
+	// DefaultBlockSize is the block size of binary diffs.
+	DefaultBlockSize = 4096
+	// maxChanges caps the changes listed by structured and binary diffs;
+	// Stats still counts all of them.
+	maxChanges = 1000
+	// maxDiffLines caps the lines of a text diff.
+	maxDiffLines = 5000
+)
+
+var (
+	// ErrTooLarge is returned when a version is over the size limit of a
+	// diff mode that reads versions whole.
+	ErrTooLarge = errors.New("version is too large for this diff mode")
+	// ErrInvalidOption is returned for an unknown mode or CSV key column.
+	ErrInvalidOption = errors.New("invalid diff option")
+	// ErrInvalidContent is returned when a version cannot be parsed in the
+	// requested mode.
+	ErrInvalidContent = errors.New("invalid content")
+)
+
+// DiffOptions select how Diff compares two versions.
+type DiffOptions struct {
+	Mode      string
+	KeyColumn string // CSV column rows are matched by; the first column by default
+	BlockSize int    // binary block size; DefaultBlockSize by default
+	MaxSize   int64  // size limit of structured modes; DefaultMaxSize by default
+}
+
 type DiffLine struct {
 	Type string `json:"type"` // "add", "remove", "equal"
 	Line string `json:"line"`
 	Num  int    `json:"num,omitempty"`
 }
 
+// FieldChange is a changed value of a JSON or YAML document. Path is a
+// JSONPath such as $.spec.replicas or $.items[2].
+type FieldChange struct {
+	Path string `json:"path"`
+	Op   string `json:"op"` // "add", "remove", "change"
+	Old  any    `json:"old,omitempty"`
+	New  any    `json:"new,omitempty"`
+}
+
+// CellChange is a changed cell of a CSV row.
+type CellChange struct {
+	Column string `json:"column"`
+	Old    string `json:"old"`
+	New    string `json:"new"`
+}
+
+// RowChange is an added, removed or changed CSV row, identified by the
+// value of its key column.
+type RowChange struct {
+	Key    string            `json:"key"`
+	Op     string            `json:"op"`               // "add", "remove", "change"
+	Cells  []CellChange      `json:"cells,omitempty"`  // changed cells of a changed row
+	Values map[string]string `json:"values,omitempty"` // the row, when added or removed
+}
+
+// ByteRange is a range of bytes that differs between two versions.
+type ByteRange struct {
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+}
+
+// DiffStats counts the fields, rows or blocks a structured or binary diff
+// found added, removed and changed.
+type DiffStats struct {
+	Added   int   `json:"added"`
+	Removed int   `json:"removed"`
+	Changed int   `json:"changed"`
+	Bytes   int64 `json:"changed_bytes,omitempty"` // binary diffs only
+}
+
 type DiffResult struct {
-	Type     string               `json:"type"` // "text" or "binary"
+	Type     string               `json:"type"` // "text", "json", "yaml", "csv" or "binary"
 	Lines    []DiffLine           `json:"lines,omitempty"`
 	MetaDiff map[string][2]string `json:"meta_diff,omitempty"`
 	SizeA    int64                `json:"size_a"`
 	SizeB    int64                `json:"size_b"`
+
+	Changes        []FieldChange `json:"changes,omitempty"`
+	KeyColumn      string        `json:"key_column,omitempty"`
+	ColumnsAdded   []string      `json:"columns_added,omitempty"`
+	ColumnsRemoved []string      `json:"columns_removed,omitempty"`
+	Rows           []RowChange   `json:"rows,omitempty"`
+	BlockSize      int           `json:"block_size,omitempty"`
+	Blocks         []ByteRange   `json:"blocks,omitempty"`
+	Stats          *DiffStats    `json:"stats,omitempty"`
+	Truncated      bool          `json:"truncated,omitempty"` // changes or lines were left out
 }
 
-// Diff compares two versions of an object.
+// Diff compares two versions of an object by lines for text types and by
+// metadata for others.
 func Diff(store *metadata.Store, engine storage.Engine, bucket, key, versionA, versionB string) (*DiffResult, error) {
+	return DiffWithOptions(store, engine, bucket, key, versionA, versionB, DiffOptions{})
+}
+
+// DiffWithOptions compares two versions of an object in the mode of opts.
+// Versions are streamed: binary diffs hold one block of each version in
+// memory, text diffs read at most opts.MaxSize bytes of each version and
+// structured modes refuse versions over opts.MaxSize.
+func DiffWithOptions(store *metadata.Store, engine storage.Engine, bucket, key, versionA, versionB string, opts DiffOptions) (*DiffResult, error) {
+	switch opts.Mode {
+	case "", ModeAuto, ModeText, ModeJSON, ModeYAML, ModeCSV, ModeBinary:
+	default:
+		return nil, fmt.Errorf("%w: unknown mode %q", ErrInvalidOption, opts.Mode)
+	}
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = DefaultMaxSize
+	}
+	if opts.BlockSize <= 0 {
+		opts.BlockSize = DefaultBlockSize
+	}
+
 	metaA, err := store.GetObjectVersion(bucket, key, versionA)
 	if err != nil {
 		return nil, fmt.Errorf("version %s not found: %w", versionA, err)
@@ -41,10 +157,21 @@ func Diff(store *metadata.Store, engine storage.Engine, bucket, key, versionA, v
 		MetaDiff: buildMetaDiff(metaA, metaB),
 	}
 
-	// Check if text content type
-	if !isTextType(metaA.ContentType) || !isTextType(metaB.ContentType) {
-		result.Type = "binary"
-		return result, nil
+	mode := opts.Mode
+	switch mode {
+	case "":
+		// Check if text content type
+		if !isTextType(metaA.ContentType) || !isTextType(metaB.ContentType) {
+			result.Type = "binary"
+			return result, nil
+		}
+		mode = ModeText
+	case ModeAuto:
+		mode = detectMode(metaB.ContentType, key)
+	}
+	structured := mode == ModeJSON || mode == ModeYAML || mode == ModeCSV
+	if structured && (metaA.Size > opts.MaxSize || metaB.Size > opts.MaxSize) {
+		return nil, fmt.Errorf("%w: %s diffs are limited to %d bytes", ErrTooLarge, mode, opts.MaxSize)
 	}
 
 	// Read both versions
@@ -60,14 +187,59 @@ func Diff(store *metadata.Store, engine storage.Engine, bucket, key, versionA, v
 	}
 	defer readerB.Close()
 
-	linesA := readLines(readerA)
-	linesB := readLines(readerB)
-
-	result.Type = "text"
-	result.Lines = computeDiff(linesA, linesB)
+	result.Type = mode
+	switch mode {
+	case ModeBinary:
+		if metaA.ETag != "" && metaA.ETag == metaB.ETag {
+			result.BlockSize = opts.BlockSize
+			result.Stats = &DiffStats{}
+			return result, nil
+		}
+		err = diffBlocks(result, readerA, readerB, opts.BlockSize)
+	case ModeJSON, ModeYAML:
+		err = diffTrees(result, mode, readerA, readerB, opts.MaxSize)
+	case ModeCSV:
+		err = diffCSV(result, readerA, readerB, opts.KeyColumn, opts.MaxSize)
+	default:
+		linesA := readLines(io.LimitReader(readerA, opts.MaxSize))
+		linesB := readLines(io.LimitReader(readerB, opts.MaxSize))
+		result.Truncated = metaA.Size > opts.MaxSize || metaB.Size > opts.MaxSize ||
+			len(linesA) > maxDiffLines || len(linesB) > maxDiffLines
+		result.Lines = computeDiff(linesA, linesB)
+	}
+	if err != nil {
+		return nil, err
+	}
 	return result, nil
 }
 
+// detectMode picks the diff mode of an object from its content type and,
+// for generic types, the extension of its key.
+func detectMode(contentType, key string) string {
+	ct, _, _ := strings.Cut(contentType, ";")
+	ct = strings.ToLower(strings.TrimSpace(ct))
+	switch {
+	case ct == "application/json" || strings.HasSuffix(ct, "+json"):
+		return ModeJSON
+	case ct == "application/yaml" || ct == "application/x-yaml" || ct == "text/yaml" || ct == "text/x-yaml":
+		return ModeYAML
+	case ct == "text/csv":
+		return ModeCSV
+	}
+	switch strings.ToLower(path.Ext(key)) {
+	case ".json":
+		return ModeJSON
+	case ".yaml", ".yml":
+		return ModeYAML
+	case ".csv":
+		return ModeCSV
+	}
+	if isTextType(ct) {
+		return ModeText
+	}
+	return ModeBinary
+}
+
 func isTextType(ct string) bool {
 	if strings.HasPrefix(ct, "text/") {
 		return true
@@ -130,13 +302,13 @@ func computeDiff(a, b []string) []DiffLine {
 	m, n := len(a), len(b)
 
 	// For very large files, limit diff to first 5000 lines
-	if m > 5000 {
-		a = a[:5000]
-		m = 5000
+	if m > maxDiffLines {
+		a = a[:maxDiffLines]
+		m = maxDiffLines
 	}
-	if n > 5000 {
-		b = b[:5000]
-		n = 5000
+	if n > maxDiffLines {
+		b = b[:maxDiffLines]
+		n = maxDiffLines
 	}
 
 	lcs := make([][]int, m+1)
@@ -1,10 +1,12 @@
 package versioning
 
 import (
+	"errors"
 	"strings"
 	"testing"
 
 	"github.com/eniz1806/VaultS3/internal/metadata"
+	"github.com/eniz1806/VaultS3/internal/storage"
 )
 
 func TestComputeDiff_Identical(t *testing.T) {
@@ -190,3 +192,74 @@ func TestReadLines_Empty(t *testing.T) {
 		t.Errorf("expected 0 lines, got %d", len(lines))
 	}
 }
+
+// --- DiffWithOptions tests ---
+
+func TestDetectMode(t *testing.T) {
+	tests := []struct {
+		ct, key, want string
+	}{
+		{"application/json", "data", ModeJSON},
+		{"application/vnd.api+json; charset=utf-8", "data", ModeJSON},
+		{"application/x-yaml", "deploy", ModeYAML},
+		{"application/octet-stream", "deploy.yml", ModeYAML},
+		{"text/csv", "export", ModeCSV},
+		{"text/plain", "export.csv", ModeCSV},
+		{"text/plain", "notes.txt", ModeText},
+		{"image/png", "photo.png", ModeBinary},
+	}
+	for _, tt := range tests {
+		if got := detectMode(tt.ct, tt.key); got != tt.want {
+			t.Errorf("detectMode(%q, %q) = %q, want %q", tt.ct, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestDiffWithOptions(t *testing.T) {
+	store := newTestStore(t)
+	engine, err := storage.NewFileSystem(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileSystem: %v", err)
+	}
+	put := func(versionID, contentType, body string) {
+		t.Helper()
+		size, etag, err := engine.PutObjectVersion("cfg", "app.json", versionID, strings.NewReader(body), int64(len(body)))
+		if err != nil {
+			t.Fatalf("PutObjectVersion: %v", err)
+		}
+		store.PutObjectVersion(metadata.ObjectMeta{Bucket: "cfg", Key: "app.json", VersionID: versionID, ContentType: contentType, Size: size, ETag: etag})
+	}
+	store.CreateBucket("cfg")
+	engine.CreateBucketDir("cfg")
+	put("v1", "application/json", `{"debug": false, "workers": 4}`)
+	put("v2", "application/json", "{\n  \"workers\": 8,\n  \"debug\": false\n}\n")
+
+	// Without a mode, JSON is diffed by lines
+	result, err := Diff(store, engine, "cfg", "app.json", "v1", "v2")
+	if err != nil || result.Type != "text" || len(result.Lines) == 0 {
+		t.Fatalf("Diff: %+v, %v", result, err)
+	}
+
+	result, err = DiffWithOptions(store, engine, "cfg", "app.json", "v1", "v2", DiffOptions{Mode: ModeAuto})
+	if err != nil {
+		t.Fatalf("DiffWithOptions: %v", err)
+	}
+	if result.Type != ModeJSON || changePaths(result.Changes) != "change $.workers" {
+		t.Errorf("auto diff = %s %s", result.Type, changePaths(result.Changes))
+	}
+
+	result, err = DiffWithOptions(store, engine, "cfg", "app.json", "v1", "v2", DiffOptions{Mode: ModeBinary, BlockSize: 16})
+	if err != nil || result.Type != ModeBinary || len(result.Blocks) == 0 || result.BlockSize != 16 {
+		t.Errorf("binary diff = %+v, %v", result, err)
+	}
+
+	if _, err := DiffWithOptions(store, engine, "cfg", "app.json", "v1", "v2", DiffOptions{Mode: ModeJSON, MaxSize: 10}); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("over the size limit: %v", err)
+	}
+	if _, err := DiffWithOptions(store, engine, "cfg", "app.json", "v1", "v2", DiffOptions{Mode: "xml"}); !errors.Is(err, ErrInvalidOption) {
+		t.Errorf("unknown mode: %v", err)
+	}
+	if _, err := DiffWithOptions(store, engine, "cfg", "app.json", "v1", "v2", DiffOptions{Mode: ModeCSV}); !errors.Is(err, ErrInvalidContent) {
+		t.Errorf("JSON as CSV: %v", err)
+	}
+}
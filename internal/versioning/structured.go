@@ -0,0 +1,363 @@
+package versioning
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// readLimited reads all of r, failing with ErrTooLarge past maxSize bytes.
+func readLimited(r io.Reader, maxSize int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("%w: structured diffs are limited to %d bytes", ErrTooLarge, maxSize)
+	}
+	return data, nil
+}
+
+// diffTrees parses two JSON or YAML documents and records the paths of the
+// values that differ. Formatting, key order and comments do not matter;
+// array elements are compared by position.
+func diffTrees(result *DiffResult, mode string, a, b io.Reader, maxSize int64) error {
+	treeA, err := parseTree(mode, a, maxSize)
+	if err != nil {
+		return fmt.Errorf("version A: %w", err)
+	}
+	treeB, err := parseTree(mode, b, maxSize)
+	if err != nil {
+		return fmt.Errorf("version B: %w", err)
+	}
+	result.Stats = &DiffStats{}
+	compareTrees(result, "$", treeA, treeB)
+	return nil
+}
+
+// parseTree decodes a JSON document, or the YAML documents of a stream.
+// A YAML stream of several documents becomes a list of them.
+func parseTree(mode string, r io.Reader, maxSize int64) (any, error) {
+	data, err := readLimited(r, maxSize)
+	if err != nil {
+		return nil, err
+	}
+	if mode == ModeJSON {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		var v any
+		if err := dec.Decode(&v); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidContent, err)
+		}
+		return v, nil
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	var docs []any
+	for {
+		var v any
+		if err := dec.Decode(&v); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("%w: %v", ErrInvalidContent, err)
+		}
+		docs = append(docs, normalizeYAML(v))
+	}
+	switch len(docs) {
+	case 0:
+		return nil, nil
+	case 1:
+		return docs[0], nil
+	}
+	return docs, nil
+}
+
+// normalizeYAML converts maps with non-string keys, which YAML allows, to
+// maps with string keys so documents compare and encode like JSON.
+func normalizeYAML(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		for k, e := range t {
+			t[k] = normalizeYAML(e)
+		}
+		return t
+	case map[any]any:
+		m := make(map[string]any, len(t))
+		for k, e := range t {
+			m[fmt.Sprint(k)] = normalizeYAML(e)
+		}
+		return m
+	case []any:
+		for i, e := range t {
+			t[i] = normalizeYAML(e)
+		}
+		return t
+	}
+	return v
+}
+
+// compareTrees records the differences between a and b below path.
+func compareTrees(result *DiffResult, path string, a, b any) {
+	switch ta := a.(type) {
+	case map[string]any:
+		if tb, ok := b.(map[string]any); ok {
+			keys := make([]string, 0, len(ta)+len(tb))
+			for k := range ta {
+				keys = append(keys, k)
+			}
+			for k := range tb {
+				if _, ok := ta[k]; !ok {
+					keys = append(keys, k)
+				}
+			}
+			slices.Sort(keys)
+			for _, k := range keys {
+				va, inA := ta[k]
+				vb, inB := tb[k]
+				p := childPath(path, k)
+				switch {
+				case !inA:
+					addFieldChange(result, FieldChange{Path: p, Op: "add", New: vb})
+				case !inB:
+					addFieldChange(result, FieldChange{Path: p, Op: "remove", Old: va})
+				default:
+					compareTrees(result, p, va, vb)
+				}
+			}
+			return
+		}
+	case []any:
+		if tb, ok := b.([]any); ok {
+			for i := 0; i < max(len(ta), len(tb)); i++ {
+				p := path + "[" + strconv.Itoa(i) + "]"
+				switch {
+				case i >= len(ta):
+					addFieldChange(result, FieldChange{Path: p, Op: "add", New: tb[i]})
+				case i >= len(tb):
+					addFieldChange(result, FieldChange{Path: p, Op: "remove", Old: ta[i]})
+				default:
+					compareTrees(result, p, ta[i], tb[i])
+				}
+			}
+			return
+		}
+	}
+	if !equalValues(a, b) {
+		addFieldChange(result, FieldChange{Path: path, Op: "change", Old: a, New: b})
+	}
+}
+
+// equalValues compares two scalars, or values of different kinds. JSON
+// numbers are equal when they have the same value, so 1.0 equals 1.
+func equalValues(a, b any) bool {
+	na, okA := a.(json.Number)
+	nb, okB := b.(json.Number)
+	if okA && okB {
+		if na == nb {
+			return true
+		}
+		fa, errA := na.Float64()
+		fb, errB := nb.Float64()
+		return errA == nil && errB == nil && fa == fb
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// childPath appends a key to a JSONPath, quoting keys that are not plain
+// identifiers.
+func childPath(path, key string) string {
+	plain := key != ""
+	for i, r := range key {
+		if !(r == '_' || r == '-' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || i > 0 && r >= '0' && r <= '9') {
+			plain = false
+			break
+		}
+	}
+	if plain {
+		return path + "." + key
+	}
+	return path + "[" + strconv.Quote(key) + "]"
+}
+
+func addFieldChange(result *DiffResult, c FieldChange) {
+	switch c.Op {
+	case "add":
+		result.Stats.Added++
+	case "remove":
+		result.Stats.Removed++
+	default:
+		result.Stats.Changed++
+	}
+	if len(result.Changes) < maxChanges {
+		result.Changes = append(result.Changes, c)
+	} else {
+		result.Truncated = true
+	}
+}
+
+// diffCSV matches the rows of two CSV files by the value of a key column
+// and records added, removed and changed rows and the changed cells. The
+// first line of each file is its header; columns are matched by name, so
+// reordering columns is not a change. Version A is held in memory and
+// version B is streamed.
+func diffCSV(result *DiffResult, a, b io.Reader, keyColumn string, maxSize int64) error {
+	dataA, err := readLimited(a, maxSize)
+	if err != nil {
+		return err
+	}
+	readerA := newCSVReader(bytes.NewReader(dataA))
+	readerB := newCSVReader(io.LimitReader(b, maxSize))
+
+	headerA, err := readCSVHeader(readerA)
+	if err != nil {
+		return fmt.Errorf("version A: %w", err)
+	}
+	headerB, err := readCSVHeader(readerB)
+	if err != nil {
+		return fmt.Errorf("version B: %w", err)
+	}
+	if keyColumn == "" {
+		keyColumn = headerB[0]
+	}
+	keyA, keyB := slices.Index(headerA, keyColumn), slices.Index(headerB, keyColumn)
+	if keyA < 0 || keyB < 0 {
+		return fmt.Errorf("%w: key column %q is not in both versions", ErrInvalidOption, keyColumn)
+	}
+	result.KeyColumn = keyColumn
+	result.Stats = &DiffStats{}
+
+	// Columns of both versions, in the order of version B
+	type column struct {
+		name string
+		a, b int
+	}
+	var common []column
+	for i, col := range headerB {
+		if j := slices.Index(headerA, col); j >= 0 {
+			common = append(common, column{name: col, a: j, b: i})
+		} else {
+			result.ColumnsAdded = append(result.ColumnsAdded, col)
+		}
+	}
+	for _, col := range headerA {
+		if !slices.Contains(headerB, col) {
+			result.ColumnsRemoved = append(result.ColumnsRemoved, col)
+		}
+	}
+
+	rowsA := make(map[string][]string)
+	var order []string
+	seenA := make(map[string]int)
+	for {
+		rec, err := readerA.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%w: version A: %v", ErrInvalidContent, err)
+		}
+		key := rowKey(seenA, cell(rec, keyA))
+		rowsA[key] = rec
+		order = append(order, key)
+	}
+
+	seenB := make(map[string]int)
+	for {
+		rec, err := readerB.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%w: version B: %v", ErrInvalidContent, err)
+		}
+		key := rowKey(seenB, cell(rec, keyB))
+		recA, ok := rowsA[key]
+		if !ok {
+			addRowChange(result, RowChange{Key: key, Op: "add", Values: rowValues(headerB, rec)})
+			continue
+		}
+		delete(rowsA, key)
+		var cells []CellChange
+		for _, col := range common {
+			if oldVal, newVal := cell(recA, col.a), cell(rec, col.b); oldVal != newVal {
+				cells = append(cells, CellChange{Column: col.name, Old: oldVal, New: newVal})
+			}
+		}
+		if len(cells) > 0 {
+			addRowChange(result, RowChange{Key: key, Op: "change", Cells: cells})
+		}
+	}
+	for _, key := range order {
+		if rec, ok := rowsA[key]; ok {
+			addRowChange(result, RowChange{Key: key, Op: "remove", Values: rowValues(headerA, rec)})
+		}
+	}
+	return nil
+}
+
+func newCSVReader(r io.Reader) *csv.Reader {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	return cr
+}
+
+func readCSVHeader(r *csv.Reader) ([]string, error) {
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("%w: no header row", ErrInvalidContent)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidContent, err)
+	}
+	return header, nil
+}
+
+// rowKey returns the key of a row, numbering repeated keys as key#2, key#3
+// and so on so that every row can be matched.
+func rowKey(seen map[string]int, key string) string {
+	seen[key]++
+	if n := seen[key]; n > 1 {
+		return key + "#" + strconv.Itoa(n)
+	}
+	return key
+}
+
+func cell(rec []string, i int) string {
+	if i < 0 || i >= len(rec) {
+		return ""
+	}
+	return rec[i]
+}
+
+func rowValues(header, rec []string) map[string]string {
+	values := make(map[string]string, len(header))
+	for i, col := range header {
+		values[col] = cell(rec, i)
+	}
+	return values
+}
+
+func addRowChange(result *DiffResult, c RowChange) {
+	switch c.Op {
+	case "add":
+		result.Stats.Added++
+	case "remove":
+		result.Stats.Removed++
+	default:
+		result.Stats.Changed++
+	}
+	if len(result.Rows) < maxChanges {
+		result.Rows = append(result.Rows, c)
+	} else {
+		result.Truncated = true
+	}
+}
@@ -0,0 +1,128 @@
+package versioning
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func changePaths(changes []FieldChange) string {
+	var parts []string
+	for _, c := range changes {
+		parts = append(parts, c.Op+" "+c.Path)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func TestDiffTrees_JSON(t *testing.T) {
+	a := `{"name": "api", "replicas": 2, "limits": {"cpu": "500m", "memory": "1Gi"}, "ports": [80, 443], "weird.key": 1.0}`
+	// Reformatted and reordered, with a few real changes
+	b := `{
+  "weird.key": 1,
+  "ports": [80, 8443, 9000],
+  "limits": {"cpu": "1"},
+  "replicas": 3,
+  "name": "api",
+  "labels": {"team": "core"}
+}`
+	result := &DiffResult{}
+	if err := diffTrees(result, ModeJSON, strings.NewReader(a), strings.NewReader(b), DefaultMaxSize); err != nil {
+		t.Fatalf("diffTrees: %v", err)
+	}
+	want := `add $.labels, change $.limits.cpu, remove $.limits.memory, change $.ports[1], add $.ports[2], change $.replicas`
+	if got := changePaths(result.Changes); got != want {
+		t.Errorf("changes = %s", got)
+	}
+	if s := result.Stats; s.Added != 2 || s.Removed != 1 || s.Changed != 3 {
+		t.Errorf("stats = %+v", s)
+	}
+
+	result = &DiffResult{}
+	diffTrees(result, ModeJSON, strings.NewReader(a), strings.NewReader(strings.ReplaceAll(a, " ", "")), DefaultMaxSize)
+	if len(result.Changes) != 0 {
+		t.Errorf("reformatting should not be a change: %s", changePaths(result.Changes))
+	}
+}
+
+func TestDiffTrees_YAML(t *testing.T) {
+	a := "# config\nserver:\n  port: 8080\n  hosts: [a, b]\n1: one\n"
+	b := "server:\n  hosts:\n    - a\n    - b\n  port: 9090  # changed\n1: uno\n"
+	result := &DiffResult{}
+	if err := diffTrees(result, ModeYAML, strings.NewReader(a), strings.NewReader(b), DefaultMaxSize); err != nil {
+		t.Fatalf("diffTrees: %v", err)
+	}
+	if got := changePaths(result.Changes); got != `change $["1"], change $.server.port` {
+		t.Errorf("changes = %s", got)
+	}
+	if c := result.Changes[1]; c.Old != 8080 || c.New != 9090 {
+		t.Errorf("port change = %+v", c)
+	}
+
+	// A stream of documents is compared document by document
+	result = &DiffResult{}
+	diffTrees(result, ModeYAML, strings.NewReader("a: 1\n---\nb: 2\n"), strings.NewReader("a: 1\n---\nb: 3\n"), DefaultMaxSize)
+	if got := changePaths(result.Changes); got != "change $[1].b" {
+		t.Errorf("multi-document changes = %s", got)
+	}
+}
+
+func TestDiffTrees_Errors(t *testing.T) {
+	err := diffTrees(&DiffResult{}, ModeJSON, strings.NewReader(`{"a":`), strings.NewReader(`{}`), DefaultMaxSize)
+	if !errors.Is(err, ErrInvalidContent) {
+		t.Errorf("invalid JSON: %v", err)
+	}
+	err = diffTrees(&DiffResult{}, ModeJSON, strings.NewReader(`{"a": "long value"}`), strings.NewReader(`{}`), 10)
+	if !errors.Is(err, ErrTooLarge) {
+		t.Errorf("over the size limit: %v", err)
+	}
+}
+
+func TestDiffCSV(t *testing.T) {
+	a := "id,name,price,stock\n1,apple,1.00,10\n2,pear,2.00,5\n3,plum,3.00,0\n"
+	// Columns reordered, stock removed, color added
+	b := "name,id,price,color\npear,2,2.50,green\napple,1,1.00,red\nkiwi,4,0.50,brown\n"
+	result := &DiffResult{}
+	if err := diffCSV(result, strings.NewReader(a), strings.NewReader(b), "id", DefaultMaxSize); err != nil {
+		t.Fatalf("diffCSV: %v", err)
+	}
+	if strings.Join(result.ColumnsAdded, ",") != "color" || strings.Join(result.ColumnsRemoved, ",") != "stock" {
+		t.Errorf("columns added %v, removed %v", result.ColumnsAdded, result.ColumnsRemoved)
+	}
+	var rows []string
+	for _, r := range result.Rows {
+		rows = append(rows, r.Op+" "+r.Key)
+	}
+	if got := strings.Join(rows, ", "); got != "change 2, add 4, remove 3" {
+		t.Fatalf("rows = %s", got)
+	}
+	if cells := result.Rows[0].Cells; len(cells) != 1 || cells[0] != (CellChange{Column: "price", Old: "2.00", New: "2.50"}) {
+		t.Errorf("cells = %+v", cells)
+	}
+	if result.Rows[1].Values["name"] != "kiwi" || result.Rows[2].Values["stock"] != "0" {
+		t.Errorf("row values = %+v, %+v", result.Rows[1].Values, result.Rows[2].Values)
+	}
+
+	// The key column defaults to the first column of the new version
+	result = &DiffResult{}
+	diffCSV(result, strings.NewReader(a), strings.NewReader(b), "", DefaultMaxSize)
+	if result.KeyColumn != "name" || result.Stats.Changed != 1 {
+		t.Errorf("default key %q, stats %+v", result.KeyColumn, result.Stats)
+	}
+
+	err := diffCSV(&DiffResult{}, strings.NewReader(a), strings.NewReader(b), "stock", DefaultMaxSize)
+	if !errors.Is(err, ErrInvalidOption) {
+		t.Errorf("key column missing from one version: %v", err)
+	}
+}
+
+func TestDiffCSV_RepeatedKeys(t *testing.T) {
+	a := "k,v\nx,1\nx,2\n"
+	b := "k,v\nx,1\nx,3\nx,4\n"
+	result := &DiffResult{}
+	if err := diffCSV(result, strings.NewReader(a), strings.NewReader(b), "k", DefaultMaxSize); err != nil {
+		t.Fatalf("diffCSV: %v", err)
+	}
+	if len(result.Rows) != 2 || result.Rows[0].Key != "x#2" || result.Rows[1].Key != "x#3" || result.Rows[1].Op != "add" {
+		t.Errorf("rows = %+v", result.Rows)
+	}
+}
@@ -0,0 +1,264 @@
+// Package webhook calls HTTP endpoints on behalf of notifications, lambda
+// triggers and the virus scanner. Requests are signed with a per-endpoint
+// HMAC secret and can carry static headers, a bearer token named from the
+// server's secrets and a client certificate.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eniz1806/VaultS3/internal/metadata"
+)
+
+const (
+	// SignatureHeader carries "t=<unix seconds>,v1=<hex HMAC-SHA256>" where
+	// the HMAC covers "<t>.<delivery ID>.<body>".
+	SignatureHeader = "X-VaultS3-Signature"
+	// DeliveryHeader carries a random ID unique to each request. It is
+	// signed, so receivers can remember the IDs they saw within the
+	// tolerance window and drop requests that repeat one.
+	DeliveryHeader = "X-VaultS3-Delivery"
+	// DefaultTolerance is how old a signature Verify accepts by default.
+	DefaultTolerance = 5 * time.Minute
+)
+
+// Sign returns the signature header value for body sent at t with the
+// delivery ID delivery.
+func Sign(secret string, t time.Time, delivery string, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + ts + ",v1=" + mac(secret, ts, delivery, body)
+}
+
+func mac(secret, ts, delivery string, body []byte) string {
+	m := hmac.New(sha256.New, []byte(secret))
+	m.Write([]byte(ts + "." + delivery + "."))
+	m.Write(body)
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+// Verify checks a signature header produced by Sign for the delivery ID of
+// the request. Signatures older or further in the future than tolerance are
+// rejected; within it, receivers drop replays by remembering delivery IDs.
+func Verify(secret, header, delivery string, body []byte, tolerance time.Duration, now time.Time) error {
+	var ts, sig string
+	for _, part := range strings.Split(header, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		switch k {
+		case "t":
+			ts = v
+		case "v1":
+			sig = v
+		}
+	}
+	if ts == "" || sig == "" {
+		return fmt.Errorf("malformed signature header")
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return fmt.Errorf("malformed signature timestamp")
+	}
+	age := now.Sub(time.Unix(unix, 0))
+	if age > tolerance || age < -tolerance {
+		return fmt.Errorf("signature timestamp outside tolerance")
+	}
+	if !hmac.Equal([]byte(sig), []byte(mac(secret, ts, delivery, body))) {
+		return fmt.Errorf("signature mismatch")
+	}
+	return nil
+}
+
+// Secrets are the named secrets of the server configuration that a
+// bearer_token_ref may name. Each value is a reference: "env:NAME" reads an
+// environment variable and "file:/path" the trimmed contents of a file.
+// Only the references of this allow-list are ever read, so whoever
+// configures a webhook cannot make the server send other files or
+// environment variables.
+type Secrets map[string]string
+
+// Resolve reads the named secret.
+func (s Secrets) Resolve(name string) (string, error) {
+	ref, ok := s[name]
+	if !ok {
+		return "", fmt.Errorf("secret %q is not configured", name)
+	}
+	return resolveRef(ref)
+}
+
+// Check verifies that every secret is a well-formed reference.
+func (s Secrets) Check() error {
+	for name, ref := range s {
+		kind, path, _ := strings.Cut(ref, ":")
+		if (kind != "env" && kind != "file") || path == "" {
+			return fmt.Errorf("secret %q must be env:NAME or file:/path", name)
+		}
+	}
+	return nil
+}
+
+// Client posts to webhook endpoints. Endpoints with client certificates or
+// CA bundles get their own transport, cached by configuration.
+type Client struct {
+	timeout    time.Duration
+	secrets    Secrets
+	mu         sync.Mutex
+	transports map[string]*http.Transport
+}
+
+// NewClient creates a client whose requests time out after timeout.
+func NewClient(timeout time.Duration) *Client {
+	return &Client{timeout: timeout, transports: make(map[string]*http.Transport)}
+}
+
+// SetSecrets sets the secrets bearer token references are resolved against.
+// It must be called before the client is used.
+func (c *Client) SetSecrets(secrets Secrets) {
+	c.secrets = secrets
+}
+
+// Post sends body to url, authenticated as auth describes (auth may be nil).
+func (c *Client) Post(ctx context.Context, url, contentType string, body []byte, auth *metadata.WebhookAuth) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	client := &http.Client{Timeout: c.timeout}
+	delivery := deliveryID()
+	if auth != nil {
+		for k, v := range auth.Headers {
+			req.Header.Set(k, v)
+		}
+		if auth.BearerTokenRef != "" {
+			token, err := c.secrets.Resolve(auth.BearerTokenRef)
+			if err != nil {
+				return nil, fmt.Errorf("bearer token: %w", err)
+			}
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		if auth.Secret != "" {
+			req.Header.Set(SignatureHeader, Sign(auth.Secret, time.Now(), delivery, body))
+		}
+		if auth.ClientCert != "" || auth.CABundle != "" {
+			tr, err := c.transport(auth)
+			if err != nil {
+				return nil, err
+			}
+			client.Transport = tr
+		}
+	}
+	req.Header.Set(DeliveryHeader, delivery)
+	return client.Do(req)
+}
+
+// transport returns the cached TLS transport for auth's certificates.
+func (c *Client) transport(auth *metadata.WebhookAuth) (*http.Transport, error) {
+	sum := sha256.Sum256([]byte(auth.ClientCert + "\x00" + auth.ClientKey + "\x00" + auth.CABundle))
+	key := hex.EncodeToString(sum[:])
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if tr, ok := c.transports[key]; ok {
+		return tr, nil
+	}
+	tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if auth.ClientCert != "" {
+		cert, err := tls.X509KeyPair([]byte(auth.ClientCert), []byte(auth.ClientKey))
+		if err != nil {
+			return nil, fmt.Errorf("client certificate: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+	if auth.CABundle != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(auth.CABundle)) {
+			return nil, fmt.Errorf("CA bundle contains no certificates")
+		}
+		tlsCfg.RootCAs = pool
+	}
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.TLSClientConfig = tlsCfg
+	c.transports[key] = tr
+	return tr, nil
+}
+
+// resolveRef reads a secret reference: "env:NAME" reads an environment
+// variable and "file:/path" the trimmed contents of a file.
+func resolveRef(ref string) (string, error) {
+	kind, name, ok := strings.Cut(ref, ":")
+	if !ok || name == "" {
+		return "", fmt.Errorf("reference %q must be env:NAME or file:/path", ref)
+	}
+	switch kind {
+	case "env":
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return v, nil
+	case "file":
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	return "", fmt.Errorf("reference %q must be env:NAME or file:/path", ref)
+}
+
+// Validate checks that auth is usable before it is stored: certificates must
+// parse and the bearer token reference must name one of secrets.
+func Validate(auth *metadata.WebhookAuth, secrets Secrets) error {
+	if auth == nil {
+		return nil
+	}
+	if _, ok := secrets[auth.BearerTokenRef]; auth.BearerTokenRef != "" && !ok {
+		return fmt.Errorf("bearer_token_ref %q is not a configured secret", auth.BearerTokenRef)
+	}
+	if (auth.ClientCert == "") != (auth.ClientKey == "") {
+		return fmt.Errorf("client_cert and client_key must be set together")
+	}
+	if auth.ClientCert != "" && auth.ClientKey != metadata.RedactedSecret {
+		if _, err := tls.X509KeyPair([]byte(auth.ClientCert), []byte(auth.ClientKey)); err != nil {
+			return fmt.Errorf("client certificate: %w", err)
+		}
+	}
+	if auth.CABundle != "" && !x509.NewCertPool().AppendCertsFromPEM([]byte(auth.CABundle)) {
+		return fmt.Errorf("ca_bundle contains no certificates")
+	}
+	for k, v := range auth.Headers {
+		if strings.EqualFold(k, SignatureHeader) || strings.EqualFold(k, DeliveryHeader) {
+			return fmt.Errorf("header %s is reserved", k)
+		}
+		if metadata.IsSealedSecret(v) {
+			return fmt.Errorf("header %s has an encrypted value", k)
+		}
+	}
+	if metadata.IsSealedSecret(auth.Secret) || metadata.IsSealedSecret(auth.ClientKey) {
+		return fmt.Errorf("secret and client_key must not be encrypted values")
+	}
+	return nil
+}
+
+func deliveryID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
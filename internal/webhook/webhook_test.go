@@ -0,0 +1,172 @@
+package webhook
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/eniz1806/VaultS3/internal/metadata"
+)
+
+func TestSignVerify(t *testing.T) {
+	body := []byte(`{"Records":[]}`)
+	now := time.Unix(1700000000, 0)
+	sig := Sign("secret", now, "d1", body)
+
+	if err := Verify("secret", sig, "d1", body, DefaultTolerance, now.Add(time.Minute)); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if err := Verify("other", sig, "d1", body, DefaultTolerance, now); err == nil {
+		t.Error("expected mismatch with wrong secret")
+	}
+	if err := Verify("secret", sig, "d1", []byte("tampered"), DefaultTolerance, now); err == nil {
+		t.Error("expected mismatch with tampered body")
+	}
+	if err := Verify("secret", sig, "d2", body, DefaultTolerance, now); err == nil {
+		t.Error("expected mismatch with a swapped delivery ID")
+	}
+	if err := Verify("secret", sig, "d1", body, DefaultTolerance, now.Add(10*time.Minute)); err == nil {
+		t.Error("expected replayed signature to be rejected")
+	}
+	if err := Verify("secret", "garbage", "d1", body, DefaultTolerance, now); err == nil {
+		t.Error("expected malformed header to be rejected")
+	}
+}
+
+func TestClientPost_SignedHeaders(t *testing.T) {
+	t.Setenv("WEBHOOK_TEST_TOKEN", "tok")
+	var got http.Header
+	var gotBody []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		gotBody, _ = io.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	auth := &metadata.WebhookAuth{
+		Secret:         "secret",
+		Headers:        map[string]string{"X-Team": "ops"},
+		BearerTokenRef: "hook-token",
+	}
+	c := NewClient(5 * time.Second)
+	c.SetSecrets(Secrets{"hook-token": "env:WEBHOOK_TEST_TOKEN"})
+	resp, err := c.Post(context.Background(), srv.URL, "application/json", []byte("{}"), auth)
+	if err != nil {
+		t.Fatalf("Post: %v", err)
+	}
+	resp.Body.Close()
+
+	if got.Get("X-Team") != "ops" || got.Get("Authorization") != "Bearer tok" || got.Get(DeliveryHeader) == "" {
+		t.Fatalf("headers = %v", got)
+	}
+	if err := Verify("secret", got.Get(SignatureHeader), got.Get(DeliveryHeader), gotBody, DefaultTolerance, time.Now()); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	// A reference that is not a configured secret is never read
+	auth.BearerTokenRef = "env:WEBHOOK_TEST_TOKEN"
+	if _, err := c.Post(context.Background(), srv.URL, "application/json", []byte("{}"), auth); err == nil {
+		t.Fatal("expected an unconfigured secret to be refused")
+	}
+}
+
+func TestClientPost_MutualTLS(t *testing.T) {
+	certPEM, keyPEM := selfSigned(t)
+	clientCert, _ := x509.ParseCertificate(pemBlock(t, certPEM))
+	pool := x509.NewCertPool()
+	pool.AddCert(clientCert)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pool}
+	srv.StartTLS()
+	defer srv.Close()
+	caPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}))
+
+	c := NewClient(5 * time.Second)
+	if _, err := c.Post(context.Background(), srv.URL, "application/json", nil, &metadata.WebhookAuth{CABundle: caPEM}); err == nil {
+		t.Fatal("expected handshake failure without a client certificate")
+	}
+	auth := &metadata.WebhookAuth{ClientCert: certPEM, ClientKey: keyPEM, CABundle: caPEM}
+	if err := Validate(auth, nil); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	resp, err := c.Post(context.Background(), srv.URL, "application/json", nil, auth)
+	if err != nil {
+		t.Fatalf("Post: %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestValidate(t *testing.T) {
+	cases := []*metadata.WebhookAuth{
+		{BearerTokenRef: "vault:token"},
+		{BearerTokenRef: "env:HOME"},
+		{ClientCert: "cert"},
+		{ClientCert: "not pem", ClientKey: "not pem"},
+		{CABundle: "not pem"},
+		{Headers: map[string]string{"x-vaults3-signature": "forged"}},
+		{Headers: map[string]string{"Authorization": "enc:v1:AAAA"}},
+		{Secret: "enc:v1:AAAA"},
+	}
+	secrets := Secrets{"hook-token": "env:HOOK_TOKEN"}
+	for _, a := range cases {
+		if err := Validate(a, secrets); err == nil {
+			t.Errorf("Validate(%+v) succeeded", a)
+		}
+	}
+	if err := Validate(&metadata.WebhookAuth{BearerTokenRef: "hook-token"}, secrets); err != nil {
+		t.Errorf("Validate(configured secret): %v", err)
+	}
+	if err := Validate(nil, secrets); err != nil {
+		t.Errorf("Validate(nil): %v", err)
+	}
+	if err := (Secrets{"bad": "/etc/passwd"}).Check(); err == nil {
+		t.Error("expected a malformed secret reference to be rejected")
+	}
+}
+
+func selfSigned(t *testing.T) (certPEM, keyPEM string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "vaults3-client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
+
+func pemBlock(t *testing.T, s string) []byte {
+	t.Helper()
+	b, _ := pem.Decode([]byte(s))
+	if b == nil {
+		t.Fatal("invalid PEM")
+	}
+	return b.Bytes
+}
@@ -3,8 +3,13 @@ import { apiFetch } from './client'
 export interface LambdaTrigger {
   id: string
   functionURL: string
+  runtime?: string
+  command?: string
   events: string[]
   keyFilter: string
+  maxRetries?: number
+  retryBackoffSecs?: number
+  maxConcurrency?: number
 }
 
 export interface LambdaStatus {
@@ -12,6 +17,48 @@ export interface LambdaStatus {
   totalTriggers: number
   buckets: number
   queueDepth: number
+  deadLetters: number
+}
+
+export interface LambdaInvocation {
+  id: number
+  time: number // unix nanos
+  bucket: string
+  trigger_id: string
+  event_name: string
+  key: string
+  attempt: number
+  status: 'succeeded' | 'retrying' | 'dead_lettered' | 'failed'
+  status_code?: number
+  latency_ms: number
+  response_size: number
+  error?: string
+  pipeline_run_id?: number
+  step?: string
+}
+
+export interface PipelineStepRun {
+  id: string
+  after: string[]
+  depth: number
+  status: 'pending' | 'queued' | 'running' | 'succeeded' | 'failed' | 'skipped'
+  attempts?: number
+  error?: string
+  output_size?: number
+  output_key?: string
+}
+
+export interface PipelineRun {
+  id: number
+  bucket: string
+  pipeline_id: string
+  key: string
+  parent_run_id?: number
+  status: 'running' | 'succeeded' | 'failed' | 'cancelled'
+  error?: string
+  steps: PipelineStepRun[]
+  created_at: number // unix nanos
+  finished_at?: number // unix nanos
 }
 
 export interface BucketTriggers {
@@ -34,3 +81,21 @@ export function setBucketTriggers(bucket: string, triggers: LambdaTrigger[]): Pr
 export function deleteBucketTriggers(bucket: string): Promise<void> {
   return apiFetch<void>(`/lambda/triggers/${bucket}`, { method: 'DELETE' })
 }
+
+export function listLambdaInvocations(status = '', limit = 50): Promise<LambdaInvocation[]> {
+  const params = new URLSearchParams({ limit: String(limit) })
+  if (status) params.set('status', status)
+  return apiFetch<LambdaInvocation[]>(`/lambda/invocations?${params}`)
+}
+
+export function retryLambdaInvocation(id: number): Promise<void> {
+  return apiFetch<void>(`/lambda/invocations/${id}/retry`, { method: 'POST' })
+}
+
+export function listPipelineRuns(limit = 20): Promise<PipelineRun[]> {
+  return apiFetch<PipelineRun[]>(`/lambda/pipeline-runs?limit=${limit}`)
+}
+
+export function cancelPipelineRun(id: number): Promise<PipelineRun> {
+  return apiFetch<PipelineRun>(`/lambda/pipeline-runs/${id}/cancel`, { method: 'POST' })
+}
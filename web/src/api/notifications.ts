@@ -3,6 +3,7 @@ import { apiFetch } from './client'
 export interface NotificationConfig {
   bucket: string
   webhookURL: string
+  target?: string
   events: string[]
 }
 
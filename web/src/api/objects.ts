@@ -18,6 +18,7 @@ export interface UploadResult {
   key: string
   size: number
   contentType: string
+  error?: string
 }
 
 export interface BulkDeleteResult {
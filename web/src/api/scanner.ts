@@ -0,0 +1,14 @@
+import { apiFetch } from './client'
+
+export interface ScanRecord {
+  scanned_at: number
+  etag?: string
+  engine: string
+  verdict: string  // "clean" | "infected" | "error"
+  detail?: string
+  rescan_id?: number
+}
+
+export function getScanHistory(bucket: string, key: string): Promise<ScanRecord[]> {
+  return apiFetch<ScanRecord[]>(`/buckets/${bucket}/scan-history/${key}`)
+}
@@ -8,18 +8,34 @@ export interface SearchResult {
   last_modified: string
   etag: string
   tags: Record<string, string>
+  user_metadata?: Record<string, string>
+  score?: number
+  snippet?: SnippetFragment[]
+}
+
+export interface SnippetFragment {
+  text: string
+  match?: boolean
 }
 
 export interface SearchQuery {
   q: string
   bucket?: string
   limit?: number
+  cursor?: string
+}
+
+export interface SearchPage {
+  results: SearchResult[]
+  total: number
+  next_cursor?: string
 }
 
-export function searchObjects(query: SearchQuery): Promise<SearchResult[]> {
+export function searchObjects(query: SearchQuery): Promise<SearchPage> {
   const params = new URLSearchParams()
   params.set('q', query.q)
   if (query.bucket) params.set('bucket', query.bucket)
   if (query.limit) params.set('limit', String(query.limit))
-  return apiFetch<SearchResult[]>(`/search?${params.toString()}`)
+  if (query.cursor) params.set('cursor', query.cursor)
+  return apiFetch<SearchPage>(`/search?${params.toString()}`)
 }
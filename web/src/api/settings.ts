@@ -36,7 +36,6 @@ export interface Settings {
     perKeyBurst: number
   }
   memory: {
-    maxSearchEntries: number
     goMemLimitMb?: number
   }
 }
@@ -4,6 +4,7 @@ export interface BucketStat {
   name: string
   size: number
   objectCount: number
+  multipartBytes?: number
   maxSizeBytes?: number
   maxObjects?: number
 }
@@ -17,6 +18,7 @@ export interface Stats {
   totalBuckets: number
   totalObjects: number
   totalSize: number
+  totalMultipartBytes: number
   uptimeSeconds: number
   goroutines: number
   memoryMB: number
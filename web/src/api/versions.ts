@@ -12,14 +12,41 @@ export interface Version {
 
 export interface DiffLine {
   type: string  // "add" | "remove" | "equal"
-  content: string
+  line: string
+  num?: number
+}
+
+export type DiffMode = 'auto' | 'text' | 'json' | 'yaml' | 'csv' | 'binary'
+
+export interface FieldChange {
+  path: string
+  op: 'add' | 'remove' | 'change'
+  old?: unknown
+  new?: unknown
+}
+
+export interface RowChange {
+  key: string
+  op: 'add' | 'remove' | 'change'
+  cells?: { column: string; old: string; new: string }[]
+  values?: Record<string, string>
 }
 
 export interface DiffResult {
-  isText: boolean
+  type: string  // "text" | "json" | "yaml" | "csv" | "binary"
   lines?: DiffLine[]
-  metaA?: Record<string, unknown>
-  metaB?: Record<string, unknown>
+  meta_diff?: Record<string, [string, string]>
+  size_a: number
+  size_b: number
+  changes?: FieldChange[]
+  key_column?: string
+  columns_added?: string[]
+  columns_removed?: string[]
+  rows?: RowChange[]
+  block_size?: number
+  blocks?: { offset: number; length: number }[]
+  stats?: { added: number; removed: number; changed: number; changed_bytes?: number }
+  truncated?: boolean
 }
 
 export interface VersionTag {
@@ -34,10 +61,11 @@ export function listVersions(bucket: string, key: string): Promise<Version[]> {
   return apiFetch<Version[]>(`/versions?bucket=${encodeURIComponent(bucket)}&key=${encodeURIComponent(key)}`)
 }
 
-export function getVersionDiff(bucket: string, key: string, v1: string, v2: string): Promise<DiffResult> {
-  return apiFetch<DiffResult>(
-    `/versions/diff?bucket=${encodeURIComponent(bucket)}&key=${encodeURIComponent(key)}&v1=${encodeURIComponent(v1)}&v2=${encodeURIComponent(v2)}`
-  )
+export function getVersionDiff(bucket: string, key: string, v1: string, v2: string, mode?: DiffMode, column?: string): Promise<DiffResult> {
+  let url = `/versions/diff?bucket=${encodeURIComponent(bucket)}&key=${encodeURIComponent(key)}&v1=${encodeURIComponent(v1)}&v2=${encodeURIComponent(v2)}`
+  if (mode) url += `&mode=${mode}`
+  if (column) url += `&column=${encodeURIComponent(column)}`
+  return apiFetch<DiffResult>(url)
 }
 
 export function getVersionTags(bucket: string, key: string): Promise<VersionTag[]> {
@@ -83,6 +83,11 @@ export default function UploadDropzone({ bucket, prefix, onUploaded }: Props) {
     setUploading(true)
     setProgress(0)
     setError('')
+    // Files the bucket's upload policy rejected come back with an error
+    const reportRejected = (results: UploadResult[]) => {
+      const rejected = results.filter((r) => r.error)
+      if (rejected.length > 0) setError(rejected.map((r) => `${r.key}: ${r.error}`).join('; '))
+    }
     try {
       if (preservePaths) {
         // Upload files with their relative paths as key prefixes
@@ -112,9 +117,11 @@ export default function UploadDropzone({ bucket, prefix, onUploaded }: Props) {
           xhr.onerror = () => reject(new Error('Upload failed'))
           xhr.send(formData)
         })
+        reportRejected(results)
         onUploaded(results)
       } else {
         const results = await uploadFiles(bucket, files, prefix, setProgress)
+        reportRejected(results)
         onUploaded(results)
       }
     } catch (err) {
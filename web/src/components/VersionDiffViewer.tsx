@@ -1,5 +1,31 @@
 import { useState, useEffect } from 'react'
-import { getVersionDiff, type DiffResult } from '../api/versions'
+import { getVersionDiff, type DiffMode, type DiffResult } from '../api/versions'
+
+const MODES: { value: DiffMode; label: string }[] = [
+  { value: 'auto', label: 'Auto' },
+  { value: 'text', label: 'Lines' },
+  { value: 'json', label: 'JSON' },
+  { value: 'yaml', label: 'YAML' },
+  { value: 'csv', label: 'CSV' },
+  { value: 'binary', label: 'Bytes' },
+]
+
+function formatValue(v: unknown): string {
+  if (v === undefined) return ''
+  return typeof v === 'string' ? v : JSON.stringify(v)
+}
+
+function formatBytes(n: number): string {
+  if (n < 1024) return `${n} B`
+  if (n < 1024 * 1024) return `${(n / 1024).toFixed(1)} KB`
+  return `${(n / (1024 * 1024)).toFixed(1)} MB`
+}
+
+const opStyle: Record<string, string> = {
+  add: 'text-green-700 dark:text-green-400',
+  remove: 'text-red-700 dark:text-red-400',
+  change: 'text-yellow-700 dark:text-yellow-400',
+}
 
 interface Props {
   bucket: string
@@ -13,15 +39,26 @@ export default function VersionDiffViewer({ bucket, objectKey, v1, v2, onClose }
   const [diff, setDiff] = useState<DiffResult | null>(null)
   const [loading, setLoading] = useState(true)
   const [error, setError] = useState('')
+  const [mode, setMode] = useState<DiffMode>('auto')
+  const [column, setColumn] = useState('')
 
   useEffect(() => {
     setLoading(true)
     setError('')
-    getVersionDiff(bucket, objectKey, v1, v2)
+    setDiff(null)
+    getVersionDiff(bucket, objectKey, v1, v2, mode, column || undefined)
       .then(setDiff)
       .catch(err => setError(err instanceof Error ? err.message : 'Failed to load diff'))
       .finally(() => setLoading(false))
-  }, [bucket, objectKey, v1, v2])
+  }, [bucket, objectKey, v1, v2, mode, column])
+
+  const header = (title: string) => (
+    <div className="px-3 py-1.5 bg-gray-50 dark:bg-gray-900 border-b border-gray-200 dark:border-gray-700 text-xs text-gray-500 dark:text-gray-400">
+      {title}
+      {diff?.stats && <> &middot; {diff.stats.added} added, {diff.stats.removed} removed, {diff.stats.changed} changed</>}
+      {diff?.truncated && <> &middot; <span className="text-yellow-600 dark:text-yellow-400">truncated</span></>}
+    </div>
+  )
 
   return (
     <div className="fixed inset-0 z-50 flex items-center justify-center bg-black/40">
@@ -44,6 +81,25 @@ export default function VersionDiffViewer({ bucket, objectKey, v1, v2, onClose }
           </button>
         </div>
 
+        {/* Mode */}
+        <div className="flex items-center gap-2 px-5 pt-4">
+          {MODES.map(m => (
+            <button key={m.value} onClick={() => setMode(m.value)}
+              className={`px-2.5 py-1 rounded-md text-xs font-medium ${
+                mode === m.value
+                  ? 'bg-indigo-600 text-white'
+                  : 'text-gray-600 dark:text-gray-300 hover:bg-gray-100 dark:hover:bg-gray-700'
+              }`}>
+              {m.label}
+            </button>
+          ))}
+          {(mode === 'csv' || diff?.type === 'csv') && (
+            <input type="text" placeholder="Key column" defaultValue={column}
+              onKeyDown={e => e.key === 'Enter' && setColumn((e.target as HTMLInputElement).value.trim())}
+              className="ml-auto w-32 px-2 py-1 rounded-md border border-gray-300 dark:border-gray-600 bg-white dark:bg-gray-800 text-xs text-gray-900 dark:text-white" />
+          )}
+        </div>
+
         {/* Content */}
         <div className="flex-1 overflow-auto p-5">
           {loading && (
@@ -58,11 +114,9 @@ export default function VersionDiffViewer({ bucket, objectKey, v1, v2, onClose }
             </div>
           )}
 
-          {diff && diff.isText && diff.lines && (
+          {diff && diff.type === 'text' && diff.lines && (
             <div className="border border-gray-200 dark:border-gray-700 rounded-lg overflow-hidden">
-              <div className="px-3 py-1.5 bg-gray-50 dark:bg-gray-900 border-b border-gray-200 dark:border-gray-700 text-xs text-gray-500 dark:text-gray-400">
-                Text Diff &middot; {diff.lines.length} lines
-              </div>
+              {header(`Text Diff \u00b7 ${diff.lines.length} lines`)}
               <pre className="text-xs font-mono overflow-auto max-h-[50vh]">
                 {diff.lines.map((line, i) => (
                   <div
@@ -78,17 +132,84 @@ export default function VersionDiffViewer({ bucket, objectKey, v1, v2, onClose }
                     <span className="select-none text-gray-400 dark:text-gray-500 w-5 inline-block">
                       {line.type === 'add' ? '+' : line.type === 'remove' ? '-' : ' '}
                     </span>
-                    {line.content}
+                    {line.line}
                   </div>
                 ))}
               </pre>
             </div>
           )}
 
-          {diff && !diff.isText && (
+          {diff && (diff.type === 'json' || diff.type === 'yaml') && (
+            <div className="border border-gray-200 dark:border-gray-700 rounded-lg overflow-hidden">
+              {header(`${diff.type.toUpperCase()} Diff`)}
+              <table className="w-full text-xs font-mono">
+                <tbody className="text-gray-700 dark:text-gray-300">
+                  {(diff.changes || []).map((c, i) => (
+                    <tr key={i} className="border-t border-gray-100 dark:border-gray-700/50 align-top">
+                      <td className={`px-3 py-1.5 w-16 ${opStyle[c.op]}`}>{c.op}</td>
+                      <td className="px-3 py-1.5 break-all">{c.path}</td>
+                      <td className="px-3 py-1.5 break-all text-red-700 dark:text-red-400">{formatValue(c.old)}</td>
+                      <td className="px-3 py-1.5 break-all text-green-700 dark:text-green-400">{formatValue(c.new)}</td>
+                    </tr>
+                  ))}
+                  {(diff.changes || []).length === 0 && (
+                    <tr><td className="px-3 py-4 text-center text-gray-400">No changes</td></tr>
+                  )}
+                </tbody>
+              </table>
+            </div>
+          )}
+
+          {diff && diff.type === 'csv' && (
+            <div className="border border-gray-200 dark:border-gray-700 rounded-lg overflow-hidden">
+              {header(`CSV Diff by ${diff.key_column}`)}
+              {(diff.columns_added || diff.columns_removed) && (
+                <div className="px-3 py-1.5 text-xs border-b border-gray-100 dark:border-gray-700/50">
+                  {diff.columns_added && <span className="text-green-700 dark:text-green-400 mr-3">+ columns: {diff.columns_added.join(', ')}</span>}
+                  {diff.columns_removed && <span className="text-red-700 dark:text-red-400">- columns: {diff.columns_removed.join(', ')}</span>}
+                </div>
+              )}
+              <table className="w-full text-xs font-mono">
+                <tbody className="text-gray-700 dark:text-gray-300">
+                  {(diff.rows || []).map((r, i) => (
+                    <tr key={i} className="border-t border-gray-100 dark:border-gray-700/50 align-top">
+                      <td className={`px-3 py-1.5 w-16 ${opStyle[r.op]}`}>{r.op}</td>
+                      <td className="px-3 py-1.5">{r.key}</td>
+                      <td className="px-3 py-1.5 break-all">
+                        {r.cells
+                          ? r.cells.map(c => <div key={c.column}>{c.column}: <span className="text-red-700 dark:text-red-400">{c.old}</span> &rarr; <span className="text-green-700 dark:text-green-400">{c.new}</span></div>)
+                          : Object.entries(r.values || {}).map(([k, v]) => `${k}=${v}`).join(', ')}
+                      </td>
+                    </tr>
+                  ))}
+                  {(diff.rows || []).length === 0 && (
+                    <tr><td className="px-3 py-4 text-center text-gray-400">No changes</td></tr>
+                  )}
+                </tbody>
+              </table>
+            </div>
+          )}
+
+          {diff && diff.type === 'binary' && diff.blocks && (
+            <div className="border border-gray-200 dark:border-gray-700 rounded-lg overflow-hidden mb-4">
+              {header(`Changed Bytes \u00b7 ${formatBytes(diff.stats?.changed_bytes || 0)} in ${diff.block_size}-byte blocks`)}
+              <table className="w-full text-xs font-mono">
+                <tbody className="text-gray-700 dark:text-gray-300">
+                  {diff.blocks.map((b, i) => (
+                    <tr key={i} className="border-t border-gray-100 dark:border-gray-700/50">
+                      <td className="px-3 py-1.5">0x{b.offset.toString(16)}</td>
+                      <td className="px-3 py-1.5">{formatBytes(b.length)}</td>
+                    </tr>
+                  ))}
+                </tbody>
+              </table>
+            </div>
+          )}
+
+          {diff && diff.type === 'binary' && (
             <div className="border border-gray-200 dark:border-gray-700 rounded-lg overflow-hidden">
               <div className="px-3 py-1.5 bg-gray-50 dark:bg-gray-900 border-b border-gray-200 dark:border-gray-700 text-xs text-gray-500 dark:text-gray-400">
-                Binary Comparison (metadata only)
+                Metadata Changes
               </div>
               <div className="p-4">
                 <table className="w-full text-sm">
@@ -100,13 +221,11 @@ export default function VersionDiffViewer({ bucket, objectKey, v1, v2, onClose }
                     </tr>
                   </thead>
                   <tbody className="text-gray-700 dark:text-gray-300">
-                    {diff.metaA && diff.metaB && Object.keys(diff.metaA).map(k => (
+                    {Object.entries(diff.meta_diff || {}).map(([k, [a, b]]) => (
                       <tr key={k} className="border-t border-gray-100 dark:border-gray-700/50">
                         <td className="py-1.5 font-mono text-xs text-gray-500 dark:text-gray-400">{k}</td>
-                        <td className="py-1.5 font-mono text-xs">{String(diff.metaA![k])}</td>
-                        <td className={`py-1.5 font-mono text-xs ${
-                          String(diff.metaA![k]) !== String(diff.metaB![k]) ? 'text-yellow-600 dark:text-yellow-400 font-medium' : ''
-                        }`}>{String(diff.metaB![k])}</td>
+                        <td className="py-1.5 font-mono text-xs">{a}</td>
+                        <td className="py-1.5 font-mono text-xs text-yellow-600 dark:text-yellow-400 font-medium">{b}</td>
                       </tr>
                     ))}
                   </tbody>
@@ -2,6 +2,7 @@ import { useState, useEffect, useCallback, useMemo } from 'react'
 import { useParams, useSearchParams, Link } from 'react-router-dom'
 import { listObjects, deleteObject, bulkDeleteObjects, getDownloadUrl, getDownloadZipUrl, type ObjectItem } from '../api/objects'
 import { getBucketVersioning } from '../api/buckets'
+import { getScanHistory, type ScanRecord } from '../api/scanner'
 import { listVersions, getVersionTags, createVersionTag, deleteVersionTag, rollbackVersion, type Version, type VersionTag } from '../api/versions'
 import UploadDropzone from '../components/UploadDropzone'
 import CopyButton from '../components/CopyButton'
@@ -52,6 +53,16 @@ export default function FileBrowserPage() {
   const [rollbackTarget, setRollbackTarget] = useState<string | null>(null)
   const [diffVersions, setDiffVersions] = useState<[string, string] | null>(null)
 
+  // Scan history of the selected file
+  const [scanHistory, setScanHistory] = useState<ScanRecord[]>([])
+
+  useEffect(() => {
+    if (!bucket || !selectedFile || selectedFile.isPrefix) { setScanHistory([]); return }
+    getScanHistory(bucket, selectedFile.key)
+      .then(setScanHistory)
+      .catch(() => setScanHistory([]))
+  }, [bucket, selectedFile])
+
   // Check if versioning is enabled on this bucket
   useEffect(() => {
     if (!bucket) return
@@ -675,6 +686,32 @@ export default function FileBrowserPage() {
                   <MetaRow label="Modified" value={selectedFile.lastModified ? new Date(selectedFile.lastModified).toLocaleString() : '-'} />
                 </div>
 
+                {scanHistory.length > 0 && (
+                  <div className="mb-4">
+                    <h4 className="text-xs font-medium text-gray-500 dark:text-gray-400 mb-2">Scan History</h4>
+                    <div className="space-y-1.5">
+                      {scanHistory.map((rec, i) => (
+                        <div key={i} className="text-xs border border-gray-200 dark:border-gray-700 rounded-lg px-2 py-1.5">
+                          <div className="flex justify-between items-center">
+                            <span className={`font-medium ${
+                              rec.verdict === 'clean' ? 'text-green-600 dark:text-green-400'
+                                : rec.verdict === 'infected' ? 'text-red-600 dark:text-red-400'
+                                : 'text-yellow-600 dark:text-yellow-400'
+                            }`}>{rec.verdict}</span>
+                            <span className="text-gray-500 dark:text-gray-400">{new Date(rec.scanned_at * 1000).toLocaleString()}</span>
+                          </div>
+                          <div className="text-gray-600 dark:text-gray-300 font-mono truncate" title={rec.engine}>
+                            {rec.engine}{rec.rescan_id ? ` · rescan #${rec.rescan_id}` : ''}
+                          </div>
+                          {rec.detail && rec.verdict !== 'clean' && (
+                            <div className="text-gray-500 dark:text-gray-400 truncate" title={rec.detail}>{rec.detail}</div>
+                          )}
+                        </div>
+                      ))}
+                    </div>
+                  </div>
+                )}
+
                 <div className="flex gap-2 mb-4">
                   <a
                     href={getDownloadUrl(bucket, selectedFile.key)}
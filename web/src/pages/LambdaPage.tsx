@@ -1,13 +1,45 @@
 import { useState, useEffect, useCallback, useMemo } from 'react'
-import { getLambdaStatus, listLambdaTriggers, deleteBucketTriggers, type LambdaStatus, type BucketTriggers } from '../api/lambda'
+import { getLambdaStatus, listLambdaTriggers, deleteBucketTriggers, listLambdaInvocations, retryLambdaInvocation, listPipelineRuns, cancelPipelineRun, type LambdaStatus, type BucketTriggers, type LambdaInvocation, type PipelineRun, type PipelineStepRun } from '../api/lambda'
 import { useToast } from '../hooks/useToast'
 
 type LSortField = 'bucket' | 'functionURL' | 'events' | 'keyFilter'
 type LSortDir = 'asc' | 'desc'
 
+const invocationStatusStyles: Record<LambdaInvocation['status'], string> = {
+  succeeded: 'bg-green-100 dark:bg-green-900/30 text-green-700 dark:text-green-400',
+  retrying: 'bg-yellow-100 dark:bg-yellow-900/30 text-yellow-700 dark:text-yellow-400',
+  dead_lettered: 'bg-red-100 dark:bg-red-900/30 text-red-700 dark:text-red-400',
+  failed: 'bg-red-100 dark:bg-red-900/30 text-red-700 dark:text-red-400',
+}
+
+const runStatusStyles: Record<PipelineRun['status'], string> = {
+  running: 'bg-blue-100 dark:bg-blue-900/30 text-blue-700 dark:text-blue-400',
+  succeeded: invocationStatusStyles.succeeded,
+  failed: invocationStatusStyles.failed,
+  cancelled: 'bg-gray-100 dark:bg-gray-700 text-gray-600 dark:text-gray-400',
+}
+
+const stepStatusStyles: Record<PipelineStepRun['status'], string> = {
+  pending: 'bg-gray-100 dark:bg-gray-700 text-gray-600 dark:text-gray-400',
+  queued: 'bg-gray-100 dark:bg-gray-700 text-gray-600 dark:text-gray-400',
+  running: runStatusStyles.running,
+  succeeded: invocationStatusStyles.succeeded,
+  failed: invocationStatusStyles.failed,
+  skipped: 'bg-gray-100 dark:bg-gray-700 text-gray-400 dark:text-gray-500 line-through',
+}
+
+function formatBytes(n: number): string {
+  if (n < 1024) return `${n} B`
+  if (n < 1024 * 1024) return `${(n / 1024).toFixed(1)} KB`
+  return `${(n / (1024 * 1024)).toFixed(1)} MB`
+}
+
 export default function LambdaPage() {
   const [status, setStatus] = useState<LambdaStatus | null>(null)
   const [triggers, setTriggers] = useState<BucketTriggers[]>([])
+  const [invocations, setInvocations] = useState<LambdaInvocation[]>([])
+  const [invocationFilter, setInvocationFilter] = useState('')
+  const [runs, setRuns] = useState<PipelineRun[]>([])
   const [loading, setLoading] = useState(true)
   const [error, setError] = useState('')
   const [deleteTarget, setDeleteTarget] = useState<string | null>(null)
@@ -20,7 +52,7 @@ export default function LambdaPage() {
       const s = await getLambdaStatus()
       setStatus(s)
     } catch {
-      setStatus({ enabled: false, totalTriggers: 0, buckets: 0, queueDepth: 0 })
+      setStatus({ enabled: false, totalTriggers: 0, buckets: 0, queueDepth: 0, deadLetters: 0 })
     }
     try {
       const t = await listLambdaTriggers()
@@ -28,8 +60,20 @@ export default function LambdaPage() {
     } catch {
       setTriggers([])
     }
+    try {
+      const inv = await listLambdaInvocations(invocationFilter)
+      setInvocations(inv || [])
+    } catch {
+      setInvocations([])
+    }
+    try {
+      const r = await listPipelineRuns()
+      setRuns(r || [])
+    } catch {
+      setRuns([])
+    }
     setLoading(false)
-  }, [])
+  }, [invocationFilter])
 
   useEffect(() => { fetchData() }, [fetchData])
 
@@ -45,6 +89,26 @@ export default function LambdaPage() {
     }
   }
 
+  const handleRetry = async (id: number) => {
+    try {
+      await retryLambdaInvocation(id)
+      addToast('success', `Invocation ${id} queued for retry`)
+      fetchData()
+    } catch (err) {
+      addToast('error', err instanceof Error ? err.message : 'Retry failed')
+    }
+  }
+
+  const handleCancelRun = async (id: number) => {
+    try {
+      await cancelPipelineRun(id)
+      addToast('success', `Pipeline run ${id} cancelled`)
+      fetchData()
+    } catch (err) {
+      addToast('error', err instanceof Error ? err.message : 'Cancel failed')
+    }
+  }
+
   const handleLSort = (field: LSortField) => {
     if (lSortField === field) {
       setLSortDir(d => d === 'asc' ? 'desc' : 'asc')
@@ -116,17 +180,19 @@ export default function LambdaPage() {
   enabled: true
   timeout_secs: 30
   max_workers: 4
-  queue_size: 256`}</pre>
+  queue_size: 256
+  max_retries: 3`}</pre>
         </div>
       )}
 
       {/* Status cards */}
-      <div className="grid grid-cols-2 md:grid-cols-4 gap-4 mb-6">
+      <div className="grid grid-cols-2 md:grid-cols-5 gap-4 mb-6">
         {[
           { label: 'Status', value: status?.enabled ? 'Enabled' : 'Disabled', color: status?.enabled ? 'text-green-600 dark:text-green-400' : 'text-gray-500' },
           { label: 'Total Triggers', value: status?.totalTriggers ?? 0 },
           { label: 'Buckets', value: status?.buckets ?? 0 },
           { label: 'Queue Depth', value: status?.queueDepth ?? 0 },
+          { label: 'Dead Letters', value: status?.deadLetters ?? 0, color: status?.deadLetters ? 'text-red-600 dark:text-red-400' : undefined },
         ].map(card => (
           <div key={card.label} className="bg-white dark:bg-gray-800 rounded-xl border border-gray-200 dark:border-gray-700 p-4">
             <p className="text-xs text-gray-500 dark:text-gray-400">{card.label}</p>
@@ -151,7 +217,7 @@ export default function LambdaPage() {
             {flatTriggers.map((t, i) => (
               <tr key={`${t.bucket}-${i}`} className="hover:bg-gray-50 dark:hover:bg-gray-700/30 transition-colors">
                 <td className="px-4 py-3 font-medium text-gray-900 dark:text-white">{t.bucket}</td>
-                <td className="px-4 py-3 text-gray-500 dark:text-gray-400 font-mono text-xs max-w-xs truncate">{t.functionURL}</td>
+                <td className="px-4 py-3 text-gray-500 dark:text-gray-400 font-mono text-xs max-w-xs truncate">{t.runtime === 'process' ? `process: ${t.command}` : t.functionURL}</td>
                 <td className="px-4 py-3">
                   <div className="flex flex-wrap gap-1">
                     {(t.events || []).map(ev => (
@@ -179,6 +245,117 @@ export default function LambdaPage() {
         </table>
       </div>
 
+      {/* Invocation history */}
+      {status?.enabled && (
+        <div className="mt-6 bg-white dark:bg-gray-800 rounded-xl border border-gray-200 dark:border-gray-700 overflow-hidden">
+          <div className="flex items-center justify-between px-4 py-3 border-b border-gray-200 dark:border-gray-700">
+            <h3 className="text-sm font-semibold text-gray-900 dark:text-white">Recent Invocations</h3>
+            <select value={invocationFilter} onChange={e => setInvocationFilter(e.target.value)}
+              className="text-xs rounded-lg border border-gray-300 dark:border-gray-600 bg-white dark:bg-gray-700 text-gray-700 dark:text-gray-300 px-2 py-1">
+              <option value="">All</option>
+              <option value="succeeded">Succeeded</option>
+              <option value="retrying">Retrying</option>
+              <option value="dead_lettered">Dead-lettered</option>
+              <option value="failed">Failed</option>
+            </select>
+          </div>
+          <table className="w-full text-sm">
+            <thead>
+              <tr className="border-b border-gray-200 dark:border-gray-700">
+                {['Time', 'Trigger', 'Object', 'Status', 'Attempt', 'Latency', 'Response', 'Error'].map(h => (
+                  <th key={h} className="text-left px-4 py-3 text-xs font-medium text-gray-500 dark:text-gray-400 uppercase tracking-wider">{h}</th>
+                ))}
+                <th className="text-right px-4 py-3 text-xs font-medium text-gray-500 dark:text-gray-400 uppercase tracking-wider">Actions</th>
+              </tr>
+            </thead>
+            <tbody className="divide-y divide-gray-100 dark:divide-gray-700/50">
+              {invocations.map(inv => (
+                <tr key={inv.id} className="hover:bg-gray-50 dark:hover:bg-gray-700/30 transition-colors">
+                  <td className="px-4 py-3 text-gray-500 dark:text-gray-400 text-xs whitespace-nowrap">{new Date(inv.time / 1e6).toLocaleString()}</td>
+                  <td className="px-4 py-3 font-medium text-gray-900 dark:text-white">
+                    {inv.trigger_id}
+                    {inv.step && <span className="ml-1 text-xs text-gray-500 dark:text-gray-400">/ {inv.step} (run {inv.pipeline_run_id})</span>}
+                  </td>
+                  <td className="px-4 py-3 text-gray-500 dark:text-gray-400 font-mono text-xs max-w-xs truncate">{inv.bucket}/{inv.key}</td>
+                  <td className="px-4 py-3">
+                    <span className={`inline-flex items-center px-2 py-0.5 rounded text-xs font-medium ${invocationStatusStyles[inv.status]}`}>
+                      {inv.status.replace('_', ' ')}{inv.status_code ? ` (${inv.status_code})` : ''}
+                    </span>
+                  </td>
+                  <td className="px-4 py-3 text-gray-500 dark:text-gray-400 text-xs">{inv.attempt}</td>
+                  <td className="px-4 py-3 text-gray-500 dark:text-gray-400 text-xs">{inv.latency_ms} ms</td>
+                  <td className="px-4 py-3 text-gray-500 dark:text-gray-400 text-xs">{formatBytes(inv.response_size)}</td>
+                  <td className="px-4 py-3 text-red-600 dark:text-red-400 text-xs max-w-xs truncate" title={inv.error}>{inv.error || ''}</td>
+                  <td className="px-4 py-3 text-right">
+                    {inv.status !== 'succeeded' && inv.status !== 'retrying' && !inv.pipeline_run_id && (
+                      <button onClick={() => handleRetry(inv.id)}
+                        className="text-xs font-medium text-indigo-600 hover:text-indigo-800 dark:text-indigo-400 dark:hover:text-indigo-300">
+                        Retry
+                      </button>
+                    )}
+                  </td>
+                </tr>
+              ))}
+              {invocations.length === 0 && (
+                <tr><td colSpan={9} className="px-4 py-8 text-center text-gray-400">No invocations recorded</td></tr>
+              )}
+            </tbody>
+          </table>
+        </div>
+      )}
+
+      {/* Pipeline runs */}
+      {status?.enabled && runs.length > 0 && (
+        <div className="mt-6 bg-white dark:bg-gray-800 rounded-xl border border-gray-200 dark:border-gray-700 overflow-hidden">
+          <div className="px-4 py-3 border-b border-gray-200 dark:border-gray-700">
+            <h3 className="text-sm font-semibold text-gray-900 dark:text-white">Pipeline Runs</h3>
+          </div>
+          <table className="w-full text-sm">
+            <thead>
+              <tr className="border-b border-gray-200 dark:border-gray-700">
+                {['Run', 'Started', 'Pipeline', 'Object', 'Status', 'Steps'].map(h => (
+                  <th key={h} className="text-left px-4 py-3 text-xs font-medium text-gray-500 dark:text-gray-400 uppercase tracking-wider">{h}</th>
+                ))}
+                <th className="text-right px-4 py-3 text-xs font-medium text-gray-500 dark:text-gray-400 uppercase tracking-wider">Actions</th>
+              </tr>
+            </thead>
+            <tbody className="divide-y divide-gray-100 dark:divide-gray-700/50">
+              {runs.map(run => (
+                <tr key={run.id} className="hover:bg-gray-50 dark:hover:bg-gray-700/30 transition-colors">
+                  <td className="px-4 py-3 font-mono text-xs text-gray-500 dark:text-gray-400">#{run.id}</td>
+                  <td className="px-4 py-3 text-gray-500 dark:text-gray-400 text-xs whitespace-nowrap">{new Date(run.created_at / 1e6).toLocaleString()}</td>
+                  <td className="px-4 py-3 font-medium text-gray-900 dark:text-white">{run.pipeline_id}</td>
+                  <td className="px-4 py-3 text-gray-500 dark:text-gray-400 font-mono text-xs max-w-xs truncate">{run.bucket}/{run.key}</td>
+                  <td className="px-4 py-3">
+                    <span className={`inline-flex items-center px-2 py-0.5 rounded text-xs font-medium ${runStatusStyles[run.status]}`} title={run.error}>
+                      {run.status}
+                    </span>
+                  </td>
+                  <td className="px-4 py-3">
+                    <div className="flex flex-wrap gap-1">
+                      {run.steps.map(step => (
+                        <span key={step.id} title={step.error || step.status}
+                          className={`inline-flex items-center px-2 py-0.5 rounded text-xs font-medium ${stepStatusStyles[step.status]}`}>
+                          {step.id}
+                        </span>
+                      ))}
+                    </div>
+                  </td>
+                  <td className="px-4 py-3 text-right">
+                    {run.status === 'running' && (
+                      <button onClick={() => handleCancelRun(run.id)}
+                        className="text-xs font-medium text-red-600 hover:text-red-800 dark:text-red-400 dark:hover:text-red-300">
+                        Cancel
+                      </button>
+                    )}
+                  </td>
+                </tr>
+              ))}
+            </tbody>
+          </table>
+        </div>
+      )}
+
       {/* Delete confirmation */}
       {deleteTarget && (
         <div className="fixed inset-0 z-50 flex items-center justify-center bg-black/40">
@@ -39,7 +39,7 @@ export default function NotificationsPage() {
       let cmp = 0
       switch (sortField) {
         case 'bucket': cmp = a.bucket.localeCompare(b.bucket); break
-        case 'webhookURL': cmp = (a.webhookURL || '').localeCompare(b.webhookURL || ''); break
+        case 'webhookURL': cmp = (a.webhookURL || a.target || '').localeCompare(b.webhookURL || b.target || ''); break
         case 'events': cmp = (a.events?.length || 0) - (b.events?.length || 0); break
       }
       return sortDir === 'asc' ? cmp : -cmp
@@ -105,7 +105,7 @@ export default function NotificationsPage() {
             <thead>
               <tr className="border-b border-gray-200 dark:border-gray-700">
                 <SortHeader field="bucket" label="Bucket" />
-                <SortHeader field="webhookURL" label="Webhook URL" />
+                <SortHeader field="webhookURL" label="Destination" />
                 <SortHeader field="events" label="Events" />
               </tr>
             </thead>
@@ -113,7 +113,7 @@ export default function NotificationsPage() {
               {sorted.map((c, i) => (
                 <tr key={i} className="hover:bg-gray-50 dark:hover:bg-gray-700/30 transition-colors">
                   <td className="px-4 py-3 font-medium text-gray-900 dark:text-white">{c.bucket}</td>
-                  <td className="px-4 py-3 text-gray-500 dark:text-gray-400 font-mono text-xs max-w-sm truncate">{c.webhookURL}</td>
+                  <td className="px-4 py-3 text-gray-500 dark:text-gray-400 font-mono text-xs max-w-sm truncate">{c.webhookURL || c.target}</td>
                   <td className="px-4 py-3">
                     <div className="flex flex-wrap gap-1">
                       {(c.events || []).map(ev => (
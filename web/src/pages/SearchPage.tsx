@@ -2,7 +2,7 @@ import { useState, useCallback, useMemo } from 'react'
 import { useNavigate } from 'react-router-dom'
 import { searchObjects, type SearchResult } from '../api/search'
 
-type SortField = 'bucket' | 'key' | 'size' | 'content_type' | 'last_modified'
+type SortField = 'score' | 'bucket' | 'key' | 'size' | 'content_type' | 'last_modified'
 type SortDir = 'asc' | 'desc'
 
 const PAGE_SIZE = 50
@@ -22,9 +22,12 @@ export default function SearchPage() {
   const [loading, setLoading] = useState(false)
   const [error, setError] = useState('')
   const [searched, setSearched] = useState(false)
+  const [total, setTotal] = useState(0)
+  const [nextCursor, setNextCursor] = useState('')
+  const [loadingMore, setLoadingMore] = useState(false)
 
-  // Sort state
-  const [sortField, setSortField] = useState<SortField>('key')
+  // Sort state; null keeps the order of the query (relevance or sort:)
+  const [sortField, setSortField] = useState<SortField | null>(null)
   const [sortDir, setSortDir] = useState<SortDir>('asc')
 
   // Pagination
@@ -37,15 +40,37 @@ export default function SearchPage() {
     setSearched(true)
     try {
       const data = await searchObjects({ q: query.trim(), bucket: bucket || undefined, limit: 100 })
-      setResults(data || [])
+      setResults(data.results || [])
+      setTotal(data.total)
+      setNextCursor(data.next_cursor || '')
       setPage(0)
+      setSortField(null)
     } catch (err) {
+      setResults([])
+      setTotal(0)
+      setNextCursor('')
       setError(err instanceof Error ? err.message : 'Search failed')
     } finally {
       setLoading(false)
     }
   }, [query, bucket])
 
+  const handleLoadMore = useCallback(async () => {
+    if (!nextCursor) return
+    setLoadingMore(true)
+    setError('')
+    try {
+      const data = await searchObjects({ q: query.trim(), bucket: bucket || undefined, limit: 100, cursor: nextCursor })
+      setResults(prev => [...prev, ...(data.results || [])])
+      setTotal(data.total)
+      setNextCursor(data.next_cursor || '')
+    } catch (err) {
+      setError(err instanceof Error ? err.message : 'Search failed')
+    } finally {
+      setLoadingMore(false)
+    }
+  }, [query, bucket, nextCursor])
+
   const handleSort = (field: SortField) => {
     if (sortField === field) {
       setSortDir(d => d === 'asc' ? 'desc' : 'asc')
@@ -57,9 +82,11 @@ export default function SearchPage() {
 
   const sortedResults = useMemo(() => {
     const sorted = [...results]
+    if (!sortField) return sorted
     sorted.sort((a, b) => {
       let cmp = 0
       switch (sortField) {
+        case 'score': cmp = (a.score || 0) - (b.score || 0); break
         case 'bucket': cmp = a.bucket.localeCompare(b.bucket); break
         case 'key': cmp = a.key.localeCompare(b.key); break
         case 'size': cmp = a.size - b.size; break
@@ -103,7 +130,7 @@ export default function SearchPage() {
 
       {/* Search bar */}
       <div className="flex gap-3 mb-6">
-        <input type="text" placeholder="Search by key, content type, or tag..."
+        <input type="text" placeholder='e.g. size:>10MB modified:<2026-01-01 meta.owner:alice "annual report" sort:size desc'
           value={query} onChange={e => setQuery(e.target.value)}
           onKeyDown={e => e.key === 'Enter' && handleSearch()}
           className="flex-1 px-4 py-2.5 rounded-lg border border-gray-300 dark:border-gray-600 bg-white dark:bg-gray-800 text-gray-900 dark:text-white text-sm" />
@@ -120,13 +147,22 @@ export default function SearchPage() {
       {searched && (
         <>
           <div className="bg-white dark:bg-gray-800 rounded-xl border border-gray-200 dark:border-gray-700 overflow-hidden">
-            <div className="px-4 py-3 border-b border-gray-200 dark:border-gray-700">
-              <span className="text-sm text-gray-500 dark:text-gray-400">{results.length} result{results.length !== 1 ? 's' : ''}</span>
+            <div className="px-4 py-3 border-b border-gray-200 dark:border-gray-700 flex items-center justify-between">
+              <span className="text-sm text-gray-500 dark:text-gray-400">
+                {results.length < total ? `${results.length} of ${total} results` : `${total} result${total !== 1 ? 's' : ''}`}
+              </span>
+              {nextCursor && (
+                <button onClick={handleLoadMore} disabled={loadingMore}
+                  className="text-sm text-indigo-600 dark:text-indigo-400 hover:underline disabled:opacity-50">
+                  {loadingMore ? 'Loading...' : 'Load more'}
+                </button>
+              )}
             </div>
             <div className="overflow-x-auto">
               <table className="w-full text-sm">
                 <thead>
                   <tr className="border-b border-gray-200 dark:border-gray-700">
+                    <SortHeader field="score" label="Relevance" />
                     <SortHeader field="bucket" label="Bucket" />
                     <SortHeader field="key" label="Key" />
                     <SortHeader field="size" label="Size" />
@@ -139,8 +175,18 @@ export default function SearchPage() {
                     <tr key={i}
                       onClick={() => navigate(`/buckets/${r.bucket}/files`)}
                       className="hover:bg-gray-50 dark:hover:bg-gray-700/30 transition-colors cursor-pointer">
+                      <td className="px-4 py-3 text-gray-500 dark:text-gray-400">{r.score ? r.score.toFixed(2) : '-'}</td>
                       <td className="px-4 py-3 font-medium text-gray-900 dark:text-white">{r.bucket}</td>
-                      <td className="px-4 py-3 text-gray-700 dark:text-gray-300 font-mono text-xs max-w-xs truncate">{r.key}</td>
+                      <td className="px-4 py-3 max-w-md">
+                        <div className="text-gray-700 dark:text-gray-300 font-mono text-xs truncate">{r.key}</div>
+                        {r.snippet && r.snippet.length > 0 && (
+                          <div className="mt-1 text-xs text-gray-500 dark:text-gray-400 line-clamp-2">
+                            {r.snippet.map((f, j) => f.match
+                              ? <mark key={j} className="bg-yellow-100 dark:bg-yellow-900/40 text-gray-900 dark:text-white rounded px-0.5">{f.text}</mark>
+                              : <span key={j}>{f.text}</span>)}
+                          </div>
+                        )}
+                      </td>
                       <td className="px-4 py-3 text-gray-500 dark:text-gray-400">{formatSize(r.size)}</td>
                       <td className="px-4 py-3 text-gray-500 dark:text-gray-400">{r.content_type || '-'}</td>
                       <td className="px-4 py-3 text-gray-500 dark:text-gray-400 whitespace-nowrap">
@@ -149,7 +195,7 @@ export default function SearchPage() {
                     </tr>
                   ))}
                   {results.length === 0 && (
-                    <tr><td colSpan={5} className="px-4 py-8 text-center text-gray-400">No results found</td></tr>
+                    <tr><td colSpan={6} className="px-4 py-8 text-center text-gray-400">No results found</td></tr>
                   )}
                 </tbody>
               </table>
@@ -103,7 +103,6 @@ export default function SettingsPage() {
 
         {/* Memory */}
         <Section title="Memory">
-          <Row label="Max Search Entries" value={settings.memory.maxSearchEntries.toLocaleString()} />
           {settings.memory.goMemLimitMb ? (
             <Row label="Go Memory Limit" value={`${settings.memory.goMemLimitMb} MB`} />
           ) : (
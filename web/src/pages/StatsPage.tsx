@@ -174,6 +174,7 @@ export default function StatsPage() {
                     <span className="text-gray-700 dark:text-gray-300 font-medium">{b.name}</span>
                     <span className="text-gray-500 dark:text-gray-400">
                       {formatSize(b.size)} &middot; {b.objectCount} object{b.objectCount !== 1 ? 's' : ''}
+                      {b.multipartBytes ? <> &middot; {formatSize(b.multipartBytes)} in multipart uploads</> : null}
                     </span>
                   </div>
                   <div className="w-full bg-gray-100 dark:bg-gray-700 rounded-full h-2">
@@ -184,7 +185,7 @@ export default function StatsPage() {
                   </div>
                   {(b.maxSizeBytes || b.maxObjects) && (
                     <p className="text-xs text-gray-400 dark:text-gray-500 mt-0.5">
-                      Quota: {b.maxSizeBytes ? formatSize(b.maxSizeBytes) : 'unlimited'} / {b.maxObjects ? `${b.maxObjects} objects` : 'unlimited'}
+                      Quota: {b.maxSizeBytes ? `${formatSize(b.size + (b.multipartBytes || 0))} of ${formatSize(b.maxSizeBytes)}` : 'unlimited'} / {b.maxObjects ? `${b.maxObjects} objects` : 'unlimited'}
                     </p>
                   )}
                 </div>